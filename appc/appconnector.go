@@ -157,6 +157,15 @@ type AppConnector struct {
 	// wildcards is the list of domain strings that match subdomains.
 	wildcards []string
 
+	// lastSeen is when each address in domains was last seen in a DNS
+	// response, for aging out addresses that are no longer in use. Addresses
+	// restored from RouteInfo count as seen when the AppConnector was
+	// created.
+	lastSeen map[netip.Addr]time.Time
+
+	// now returns the current time; it's time.Now except in tests.
+	now func() time.Time
+
 	// queue provides ordering for update operations
 	queue execqueue.ExecQueue
 
@@ -170,11 +179,18 @@ func NewAppConnector(logf logger.Logf, routeAdvertiser RouteAdvertiser, routeInf
 		logf:            logger.WithPrefix(logf, "appc: "),
 		routeAdvertiser: routeAdvertiser,
 		storeRoutesFunc: storeRoutesFunc,
+		now:             time.Now,
 	}
 	if routeInfo != nil {
 		ac.domains = routeInfo.Domains
 		ac.wildcards = routeInfo.Wildcards
 		ac.controlRoutes = routeInfo.Control
+		now := ac.now()
+		for _, addrs := range ac.domains {
+			for _, addr := range addrs {
+				mak.Set(&ac.lastSeen, addr, now)
+			}
+		}
 	}
 	ac.writeRateMinute = newRateLogger(time.Now, time.Minute, func(c int64, s time.Time, l int64) {
 		ac.logf("routeInfo write rate: %d in minute starting at %v (%d routes)", c, s, l)
@@ -220,6 +236,7 @@ func (e *AppConnector) ClearRoutes() error {
 	e.controlRoutes = nil
 	e.domains = nil
 	e.wildcards = nil
+	e.lastSeen = nil
 	return e.storeRoutesLocked()
 }
 
@@ -463,6 +480,7 @@ func (e *AppConnector) ObserveDNSResponse(res []byte) error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	now := e.now()
 	for domain, addrs := range addressRecords {
 		domain, isRouted := e.findRoutedDomainLocked(domain, cnameChain)
 
@@ -470,6 +488,9 @@ func (e *AppConnector) ObserveDNSResponse(res []byte) error {
 		if !isRouted {
 			continue
 		}
+		for _, addr := range addrs {
+			mak.Set(&e.lastSeen, addr, now)
+		}
 
 		// advertise each address we have learned for the routed domain, that
 		// was not already known.
@@ -488,6 +509,79 @@ func (e *AppConnector) ObserveDNSResponse(res []byte) error {
 	return nil
 }
 
+// PruneRoutes asynchronously stops advertising the routes learned from DNS
+// responses for addresses that haven't been seen in one for maxAge, such as
+// those that a wildcard domain's names no longer resolve to, so that the
+// advertised routes don't grow without bound.
+func (e *AppConnector) PruneRoutes(maxAge time.Duration) {
+	e.queue.Add(func() {
+		e.pruneRoutes(maxAge)
+	})
+}
+
+func (e *AppConnector) pruneRoutes(maxAge time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	cutoff := e.now().Add(-maxAge)
+	stale := func(addr netip.Addr) bool {
+		if seen, ok := e.lastSeen[addr]; ok && seen.After(cutoff) {
+			return false
+		}
+		// Addresses covered by the routes from control were never
+		// advertised on their own.
+		return !slices.ContainsFunc(e.controlRoutes, func(p netip.Prefix) bool {
+			return p.Contains(addr)
+		})
+	}
+
+	var toRemove []netip.Prefix
+	for domain, addrs := range e.domains {
+		kept := addrs[:0]
+		for _, addr := range addrs {
+			if !stale(addr) {
+				kept = append(kept, addr)
+				continue
+			}
+			pfx := netip.PrefixFrom(addr, addr.BitLen())
+			if !slices.Contains(toRemove, pfx) {
+				toRemove = append(toRemove, pfx)
+			}
+		}
+		if len(kept) == len(addrs) {
+			continue
+		}
+		e.domains[domain] = kept
+		if len(kept) == 0 && e.isWildcardMatchLocked(domain) {
+			// It's re-added if it's seen again.
+			delete(e.domains, domain)
+		}
+	}
+	if len(toRemove) == 0 {
+		return
+	}
+	for _, pfx := range toRemove {
+		delete(e.lastSeen, pfx.Addr())
+	}
+
+	e.logf("pruning %d routes not seen in DNS responses for %v", len(toRemove), maxAge)
+	if err := e.routeAdvertiser.UnadvertiseRoute(toRemove...); err != nil {
+		e.logf("failed to unadvertise pruned routes: %v: %v", toRemove, err)
+	}
+	if err := e.storeRoutesLocked(); err != nil {
+		e.logf("failed to store route info: %v", err)
+	}
+}
+
+// isWildcardMatchLocked reports whether domain is a subdomain of one of the
+// configured wildcard domains.
+// e.mu must be held.
+func (e *AppConnector) isWildcardMatchLocked(domain string) bool {
+	return slices.ContainsFunc(e.wildcards, func(wc string) bool {
+		return dnsname.HasSuffix(domain, wc)
+	})
+}
+
 // starting from the given domain that resolved to an address, find it, or any
 // of the domains in the CNAME chain toward resolving it, that are routed
 // domains, returning the routed domain name and a bool indicating whether a
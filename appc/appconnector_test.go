@@ -543,6 +543,64 @@ func TestUpdateWildcardRouteRemoval(t *testing.T) {
 	}
 }
 
+func TestPruneRoutes(t *testing.T) {
+	for _, shouldStore := range []bool{false, true} {
+		ctx := context.Background()
+		rc := &appctest.RouteCollector{}
+		var a *AppConnector
+		if shouldStore {
+			a = NewAppConnector(t.Logf, rc, &RouteInfo{}, fakeStoreRoutes)
+		} else {
+			a = NewAppConnector(t.Logf, rc, nil, nil)
+		}
+		now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		a.now = func() time.Time { return now }
+
+		a.updateDomains([]string{"*.example.com", "example.org"})
+		a.updateRoutes([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+		for _, res := range [][]byte{
+			dnsResponse("old.example.com.", "192.0.0.8"),
+			dnsResponse("example.org.", "192.0.0.9"),
+			dnsResponse("example.org.", "10.0.0.1"),
+		} {
+			if err := a.ObserveDNSResponse(res); err != nil {
+				t.Errorf("ObserveDNSResponse: %v", err)
+			}
+		}
+		a.Wait(ctx)
+
+		now = now.Add(2 * time.Hour)
+		if err := a.ObserveDNSResponse(dnsResponse("new.example.com.", "192.0.0.10")); err != nil {
+			t.Errorf("ObserveDNSResponse: %v", err)
+		}
+		a.Wait(ctx)
+
+		a.pruneRoutes(time.Hour)
+		want := []netip.Prefix{
+			netip.MustParsePrefix("10.0.0.0/8"),
+			netip.MustParsePrefix("192.0.0.10/32"),
+		}
+		if got := rc.Routes(); !slices.Equal(got, want) {
+			t.Errorf("routes: got %v; want %v", got, want)
+		}
+		wantRemoved := []netip.Prefix{
+			netip.MustParsePrefix("192.0.0.8/32"),
+			netip.MustParsePrefix("192.0.0.9/32"),
+		}
+		got := rc.RemovedRoutes()
+		slices.SortFunc(got, func(a, b netip.Prefix) int { return a.Addr().Compare(b.Addr()) })
+		if !slices.Equal(got, wantRemoved) {
+			t.Errorf("removed routes: got %v; want %v", got, wantRemoved)
+		}
+		if _, ok := a.domains["old.example.com"]; ok {
+			t.Errorf("old.example.com still in domains after pruning")
+		}
+		if got, want := a.domains["example.org"], []netip.Addr{netip.MustParseAddr("10.0.0.1")}; !slices.Equal(got, want) {
+			t.Errorf("example.org addresses: got %v; want %v", got, want)
+		}
+	}
+}
+
 func TestRoutesWithout(t *testing.T) {
 	assert := func(msg string, got, want []netip.Prefix) {
 		if !slices.Equal(want, got) {
@@ -16,6 +16,7 @@
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net"
 	"net/http"
 	"net/http/httptrace"
@@ -34,6 +35,7 @@
 	"tailscale.com/envknob"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/netmon"
 	"tailscale.com/net/netutil"
 	"tailscale.com/paths"
 	"tailscale.com/safesocket"
@@ -374,6 +376,14 @@ func (lc *Client) UserMetrics(ctx context.Context) ([]byte, error) {
 	return lc.get200(ctx, "/localapi/v0/usermetrics")
 }
 
+// PrometheusMetrics returns all of the Tailscale daemon's metrics in the
+// Prometheus text exposition format: the user metrics, the packet filter's
+// drops if its stats are enabled, and the internal metrics returned by
+// DaemonMetrics.
+func (lc *Client) PrometheusMetrics(ctx context.Context) ([]byte, error) {
+	return lc.get200(ctx, "/localapi/v0/metrics/prometheus")
+}
+
 // IncrementCounter increments the value of a Tailscale daemon's counter
 // metric by the given delta. If the metric has yet to exist, a new counter
 // metric is created and initialized to delta.
@@ -555,6 +565,15 @@ type DebugPortmapOpts struct {
 	// LogHTTP instructs the debug-portmap endpoint to print all HTTP
 	// requests and responses made to the logs.
 	LogHTTP bool
+
+	// Verify instructs the debug-portmap endpoint to check, once a
+	// mapping is obtained, that packets from a peer actually arrive
+	// through it, by having the peer probe it as coordinated over DERP.
+	Verify bool
+
+	// VerifyPeer is the Tailscale IP of the peer to probe the mapping
+	// from when Verify is set. If unset, an online peer is picked.
+	VerifyPeer netip.Addr
 }
 
 // DebugPortmap invokes the debug-portmap endpoint, and returns an
@@ -571,6 +590,12 @@ func (lc *Client) DebugPortmap(ctx context.Context, opts *DebugPortmapOpts) (io.
 	vals.Set("duration", cmp.Or(opts.Duration, 5*time.Second).String())
 	vals.Set("type", opts.Type)
 	vals.Set("log_http", strconv.FormatBool(opts.LogHTTP))
+	if opts.Verify {
+		vals.Set("verify", "true")
+		if opts.VerifyPeer.IsValid() {
+			vals.Set("verify_peer", opts.VerifyPeer.String())
+		}
+	}
 
 	if opts.GatewayAddr.IsValid() != opts.SelfAddr.IsValid() {
 		return nil, fmt.Errorf("both GatewayAddr and SelfAddr must be provided if one is")
@@ -658,6 +683,16 @@ func (lc *Client) status(ctx context.Context, queryString string) (*ipnstate.Sta
 	return decodeJSON[*ipnstate.Status](body)
 }
 
+// PeerPathStats returns the path statistics of each peer that has them,
+// keyed by node key.
+func (lc *Client) PeerPathStats(ctx context.Context) (map[key.NodePublic]*ipnstate.PeerPathStats, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/peer-path-stats")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[map[key.NodePublic]*ipnstate.PeerPathStats](body)
+}
+
 // IDToken is a request to get an OIDC ID token for an audience.
 // The token can be presented to any resource provider which offers OIDC
 // Federation.
@@ -728,10 +763,45 @@ func (lc *Client) FileTargets(ctx context.Context) ([]apitype.FileTarget, error)
 // A size of -1 means unknown.
 // The name parameter is the original filename, not escaped.
 func (lc *Client) PushFile(ctx context.Context, target tailcfg.StableNodeID, size int64, name string, r io.Reader) error {
+	return lc.PushFileWithMetadata(ctx, target, size, name, r, PushFileMetadata{})
+}
+
+// PushFileMetadata is metadata of a file sent with PushFileWithMetadata.
+type PushFileMetadata struct {
+	// SHA256 is the hex SHA-256 of the file's contents, for the receiver to
+	// verify. If empty, the local Tailscale daemon computes it as it sends
+	// the file, where the receiver supports that.
+	SHA256 string
+
+	// ModTime and Mode, if non-zero, are the file's modification time and
+	// permission bits, to be preserved by the receiver.
+	ModTime time.Time
+	Mode    fs.FileMode
+
+	// Archive, if non-empty, says the file is an archive of a directory
+	// in that format, for the receiver to extract. The only format is
+	// "tar".
+	Archive string
+}
+
+// PushFileWithMetadata is like PushFile but also sends md.
+func (lc *Client) PushFileWithMetadata(ctx context.Context, target tailcfg.StableNodeID, size int64, name string, r io.Reader, md PushFileMetadata) error {
 	req, err := http.NewRequestWithContext(ctx, "PUT", "http://"+apitype.LocalAPIHost+"/localapi/v0/file-put/"+string(target)+"/"+url.PathEscape(name), r)
 	if err != nil {
 		return err
 	}
+	if md.SHA256 != "" {
+		req.Header.Set(apitype.TaildropSHA256Header, md.SHA256)
+	}
+	if !md.ModTime.IsZero() {
+		req.Header.Set(apitype.TaildropModTimeHeader, md.ModTime.UTC().Format(time.RFC3339Nano))
+	}
+	if md.Mode != 0 {
+		req.Header.Set(apitype.TaildropModeHeader, strconv.FormatUint(uint64(md.Mode.Perm()), 8))
+	}
+	if md.Archive != "" {
+		req.Header.Set(apitype.TaildropArchiveHeader, md.Archive)
+	}
 	if size != -1 {
 		req.ContentLength = size
 	}
@@ -747,6 +817,23 @@ func (lc *Client) PushFile(ctx context.Context, target tailcfg.StableNodeID, siz
 	return bestError(fmt.Errorf("%s: %s", res.Status, all), all)
 }
 
+// FileResumeOffset returns how many bytes of the file name target already
+// has from an earlier, interrupted PushFile, which sending the same content
+// again skips.
+//
+// The name parameter is the original filename, not escaped.
+func (lc *Client) FileResumeOffset(ctx context.Context, target tailcfg.StableNodeID, name string) (int64, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/file-resume-offset/"+string(target)+"/"+url.PathEscape(name))
+	if err != nil {
+		return 0, err
+	}
+	res, err := decodeJSON[apitype.FileResumeOffset](body)
+	if err != nil {
+		return 0, err
+	}
+	return res.Offset, nil
+}
+
 // CheckIPForwarding asks the local Tailscale daemon whether it looks like the
 // machine is properly configured to forward IP packets as a subnet router
 // or exit node.
@@ -906,6 +993,14 @@ func (lc *Client) StartLoginInteractive(ctx context.Context) error {
 	return err
 }
 
+// StartDeviceCodeLogin starts an interactive login using the device-code
+// flow: the IPN bus notification with the login URL also carries the code
+// to enter there, so the login can be completed from another device.
+func (lc *Client) StartDeviceCodeLogin(ctx context.Context) error {
+	_, err := lc.send(ctx, "POST", "/localapi/v0/login-interactive?flow=device-code", http.StatusNoContent, nil)
+	return err
+}
+
 // Start applies the configuration specified in opts, and starts the
 // state machine.
 func (lc *Client) Start(ctx context.Context, opts ipn.Options) error {
@@ -959,6 +1054,15 @@ func (lc *Client) DialTCP(ctx context.Context, host string, port uint16) (net.Co
 // The ctx is only used for the duration of the call, not the lifetime of the
 // net.Conn.
 func (lc *Client) UserDial(ctx context.Context, network, host string, port uint16) (net.Conn, error) {
+	return lc.UserDialVia(ctx, network, host, port, "")
+}
+
+// UserDialVia is like UserDial, but reaches the host via the tailnet peer
+// named by via (a MagicDNS name or Tailscale IP), through a 4via6 route the
+// peer advertises for the host's IPv4 address or else its subnet route to
+// the host. It's for reaching a host on one of several overlapping subnets.
+// If via is empty, it's the same as UserDial.
+func (lc *Client) UserDialVia(ctx context.Context, network, host string, port uint16, via string) (net.Conn, error) {
 	connCh := make(chan net.Conn, 1)
 	trace := httptrace.ClientTrace{
 		GotConn: func(info httptrace.GotConnInfo) {
@@ -977,6 +1081,9 @@ func (lc *Client) UserDial(ctx context.Context, network, host string, port uint1
 		"Dial-Port":    []string{fmt.Sprint(port)},
 		"Dial-Network": []string{network},
 	}
+	if via != "" {
+		req.Header.Set("Dial-Via", via)
+	}
 	res, err := lc.DoLocalRequest(req)
 	if err != nil {
 		return nil, err
@@ -1067,6 +1174,36 @@ func (lc *Client) CertPairWithValidity(ctx context.Context, domain string, minVa
 	return certPEM, keyPEM, nil
 }
 
+// SSHRecordings returns the Tailscale SSH session recordings kept on the
+// node, oldest first.
+func (lc *Client) SSHRecordings(ctx context.Context) ([]apitype.SSHRecording, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/ssh-recordings")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]apitype.SSHRecording](body)
+}
+
+// RemoteDiagRequests returns the control plane's requests for diagnostics
+// from this node waiting for the local user's consent.
+func (lc *Client) RemoteDiagRequests(ctx context.Context) ([]apitype.RemoteDiagRequest, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/remote-diag")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]apitype.RemoteDiagRequest](body)
+}
+
+// DecideRemoteDiag approves or denies the control plane's pending request for
+// diagnostics with the given ID.
+func (lc *Client) DecideRemoteDiag(ctx context.Context, id string, approve bool) error {
+	v := url.Values{}
+	v.Set("id", id)
+	v.Set("approve", strconv.FormatBool(approve))
+	_, err := lc.send(ctx, "POST", "/localapi/v0/remote-diag?"+v.Encode(), http.StatusNoContent, nil)
+	return err
+}
+
 // GetCertificate fetches a TLS certificate for the TLS ClientHello in hi.
 //
 // It returns a cached certificate from disk if it's still valid.
@@ -1079,6 +1216,16 @@ func GetCertificate(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
 	return defaultClient.GetCertificate(hi)
 }
 
+// CertStatus returns the status of the node's HTTPS certs, as kept by
+// tailscaled's background renewal, sorted by domain.
+func (lc *Client) CertStatus(ctx context.Context) ([]apitype.CertStatus, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/cert-status")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]apitype.CertStatus](body)
+}
+
 // GetCertificate fetches a TLS certificate for the TLS ClientHello in hi.
 //
 // It returns a cached certificate from disk if it's still valid.
@@ -1145,6 +1292,21 @@ type PingOpts struct {
 	Size int
 }
 
+// Bench runs a bandwidth test over the WireGuard tunnel to the peer with
+// Tailscale IP ip. It sends data to the peer and receives data from it for d
+// each, as selected by direction: "upload", "download" or "both".
+func (lc *Client) Bench(ctx context.Context, ip netip.Addr, d time.Duration, direction string) (*apitype.BenchResult, error) {
+	v := url.Values{}
+	v.Set("ip", ip.String())
+	v.Set("duration", d.String())
+	v.Set("direction", direction)
+	body, err := lc.send(ctx, "POST", "/localapi/v0/bench?"+v.Encode(), 200, nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.BenchResult](body)
+}
+
 // Ping sends a ping of the provided type to the provided IP and waits
 // for its response. The opts type specifies additional options.
 func (lc *Client) PingWithOpts(ctx context.Context, ip netip.Addr, pingtype tailcfg.PingType, opts PingOpts) (*ipnstate.PingResult, error) {
@@ -1165,6 +1327,43 @@ func (lc *Client) Ping(ctx context.Context, ip netip.Addr, pingtype tailcfg.Ping
 	return lc.PingWithOpts(ctx, ip, pingtype, PingOpts{})
 }
 
+// CheckACL reports whether the node's packet filter permits traffic from src
+// to dst using proto, such as "udp" or "icmp", or TCP if proto is empty, and
+// which rule permits it.
+func (lc *Client) CheckACL(ctx context.Context, src netip.Addr, dst netip.AddrPort, proto string) (*apitype.ACLCheckResponse, error) {
+	v := url.Values{}
+	v.Set("src", src.String())
+	v.Set("dst", dst.String())
+	if proto != "" {
+		v.Set("proto", proto)
+	}
+	body, err := lc.get200(ctx, "/localapi/v0/acl-check?"+v.Encode())
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.ACLCheckResponse](body)
+}
+
+// FilterStats returns the packet filter's per-rule counters and recent
+// drops. They're only collected after SetFilterStats enables them.
+func (lc *Client) FilterStats(ctx context.Context) (*apitype.FilterStats, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/filter-stats")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.FilterStats](body)
+}
+
+// SetFilterStats enables or disables the packet filter's per-rule counters
+// and drop log, returning the stats as of the change.
+func (lc *Client) SetFilterStats(ctx context.Context, enable bool) (*apitype.FilterStats, error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/filter-stats?enable="+strconv.FormatBool(enable), 200, nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.FilterStats](body)
+}
+
 // NetworkLockStatus fetches information about the tailnet key authority, if one is configured.
 func (lc *Client) NetworkLockStatus(ctx context.Context) (*ipnstate.NetworkLockStatus, error) {
 	body, err := lc.send(ctx, "GET", "/localapi/v0/tka/status", 200, nil)
@@ -1257,6 +1456,15 @@ type signRequest struct {
 	return nil
 }
 
+// NetworkLockSubmitSignature submits a node-key signature made elsewhere, such
+// as with an offline tailnet lock key, for distribution to the tailnet.
+func (lc *Client) NetworkLockSubmitSignature(ctx context.Context, sig tkatype.MarshaledSignature) error {
+	if _, err := lc.send(ctx, "POST", "/localapi/v0/tka/submit-signature", 200, bytes.NewReader(sig)); err != nil {
+		return fmt.Errorf("error: %w", err)
+	}
+	return nil
+}
+
 // NetworkLockAffectedSigs returns all signatures signed by the specified keyID.
 func (lc *Client) NetworkLockAffectedSigs(ctx context.Context, keyID tkatype.KeyID) ([]tkatype.MarshaledSignature, error) {
 	body, err := lc.send(ctx, "POST", "/localapi/v0/tka/affected-sigs", 200, bytes.NewReader(keyID))
@@ -1277,6 +1485,17 @@ func (lc *Client) NetworkLockLog(ctx context.Context, maxEntries int) ([]ipnstat
 	return decodeJSON[[]ipnstate.NetworkLockUpdate](body)
 }
 
+// NetworkLockExportLog returns an export of the changes to network-lock
+// state, signed by this node, which can be verified offline with
+// tka.VerifyLogExport.
+func (lc *Client) NetworkLockExportLog(ctx context.Context) (*tka.LogExport, error) {
+	body, err := lc.send(ctx, "GET", "/localapi/v0/tka/export-log", 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	return decodeJSON[*tka.LogExport](body)
+}
+
 // NetworkLockForceLocalDisable forcibly shuts down network lock on this node.
 func (lc *Client) NetworkLockForceLocalDisable(ctx context.Context) error {
 	// This endpoint expects an empty JSON stanza as the payload.
@@ -1342,6 +1561,35 @@ func (lc *Client) NetworkLockSubmitRecoveryAUM(ctx context.Context, aum tka.AUM)
 	return nil
 }
 
+// NetworkLockGenRotationAUM generates an AUM for rotating tailnet lock keys
+// and disablement values. A nil disablementValues keeps the current ones.
+// The AUM must be co-signed with [Client.NetworkLockCosignRecoveryAUM] by
+// enough trusted keys to make up a quorum before it can be submitted.
+func (lc *Client) NetworkLockGenRotationAUM(ctx context.Context, addKeys []tka.Key, removeKeys []tkatype.KeyID, disablementValues [][]byte) ([]byte, error) {
+	vr := struct {
+		AddKeys           []tka.Key
+		RemoveKeys        []tkatype.KeyID
+		DisablementValues [][]byte
+	}{addKeys, removeKeys, disablementValues}
+
+	body, err := lc.send(ctx, "POST", "/localapi/v0/tka/generate-rotation-aum", 200, jsonBody(vr))
+	if err != nil {
+		return nil, fmt.Errorf("sending generate-rotation-aum: %w", err)
+	}
+
+	return body, nil
+}
+
+// NetworkLockSubmitRotationAUM submits a rotation AUM to the control plane.
+func (lc *Client) NetworkLockSubmitRotationAUM(ctx context.Context, aum tka.AUM) error {
+	r := bytes.NewReader(aum.Serialize())
+	_, err := lc.send(ctx, "POST", "/localapi/v0/tka/submit-rotation-aum", 200, r)
+	if err != nil {
+		return fmt.Errorf("sending submit-rotation-aum: %w", err)
+	}
+	return nil
+}
+
 // SetServeConfig sets or replaces the serving settings.
 // If config is nil, settings are cleared and serving is disabled.
 func (lc *Client) SetServeConfig(ctx context.Context, config *ipn.ServeConfig) error {
@@ -1356,6 +1604,25 @@ func (lc *Client) SetServeConfig(ctx context.Context, config *ipn.ServeConfig) e
 	return nil
 }
 
+// CreateBackup returns a backup of the node's configuration, including its
+// machine and node keys if includeKeys. See 'tailscale backup create'.
+func (lc *Client) CreateBackup(ctx context.Context, includeKeys bool) (*ipn.Backup, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/backup?keys="+strconv.FormatBool(includeKeys))
+	if err != nil {
+		return nil, fmt.Errorf("creating backup: %w", err)
+	}
+	return decodeJSON[*ipn.Backup](body)
+}
+
+// RestoreBackup restores the node's configuration from a backup made by
+// [Client.CreateBackup].
+func (lc *Client) RestoreBackup(ctx context.Context, b *ipn.Backup) error {
+	if _, err := lc.send(ctx, "POST", "/localapi/v0/backup", 200, jsonBody(b)); err != nil {
+		return fmt.Errorf("restoring backup: %w", err)
+	}
+	return nil
+}
+
 // DisconnectControl shuts down all connections to control, thus making control consider this node inactive. This can be
 // run on HA subnet router or app connector replicas before shutting them down to ensure peers get told to switch over
 // to another replica whilst there is still some grace period for the existing connections to terminate.
@@ -1486,6 +1753,23 @@ func (lc *Client) ReloadConfig(ctx context.Context) (ok bool, err error) {
 	return res.Reloaded, nil
 }
 
+// NotifyNetworkChange tells tailscaled that the network configuration has
+// probably changed (for instance, that a DHCP lease was obtained or a link
+// went up), so that it rechecks the network right away. The reason is
+// logged by tailscaled.
+func (lc *Client) NotifyNetworkChange(ctx context.Context, reason string) error {
+	_, err := lc.send(ctx, "POST", "/localapi/v0/network-change?reason="+url.QueryEscape(reason), http.StatusNoContent, nil)
+	return err
+}
+
+// SetCARPState tells tailscaled the CARP state of this subnet router, so
+// that it advertises its routes only as the MASTER. state is "MASTER",
+// "BACKUP" or "INIT".
+func (lc *Client) SetCARPState(ctx context.Context, state string) error {
+	_, err := lc.send(ctx, "POST", "/localapi/v0/carp-state?state="+url.QueryEscape(state), http.StatusNoContent, nil)
+	return err
+}
+
 // SwitchToEmptyProfile creates and switches to a new unnamed profile. The new
 // profile is not assigned an ID until it is persisted after a successful login.
 // In order to login to the new profile, the user must call LoginInteractive.
@@ -1538,6 +1822,37 @@ func (lc *Client) DebugDERPRegion(ctx context.Context, regionIDOrCode string) (*
 	return decodeJSON[*ipnstate.DebugDERPRegionReport](body)
 }
 
+// DebugTUNCapabilities returns the capabilities of the tun device
+// tailscaled is using.
+func (lc *Client) DebugTUNCapabilities(ctx context.Context) (*ipnstate.TUNCapabilities, error) {
+	body, err := lc.send(ctx, "GET", "/localapi/v0/debug-tun-caps", 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	return decodeJSON[*ipnstate.TUNCapabilities](body)
+}
+
+// DebugRouterChanges returns the address and route changes that tailscaled's
+// router skipped in dry-run mode (TS_DEBUG_ROUTER_DRY_RUN), in the order it
+// would have made them.
+func (lc *Client) DebugRouterChanges(ctx context.Context) ([]string, error) {
+	body, err := lc.send(ctx, "GET", "/localapi/v0/debug-router-changes", 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	return decodeJSON[[]string](body)
+}
+
+// DebugRouterState returns the state of tailscaled's router: the addresses
+// and routes it configured, and why changing them last failed, if it did.
+func (lc *Client) DebugRouterState(ctx context.Context) (*ipnstate.RouterState, error) {
+	body, err := lc.send(ctx, "GET", "/localapi/v0/debug-router-state", 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	return decodeJSON[*ipnstate.RouterState](body)
+}
+
 // DebugPacketFilterRules returns the packet filter rules for the current device.
 func (lc *Client) DebugPacketFilterRules(ctx context.Context) ([]tailcfg.FilterRule, error) {
 	body, err := lc.send(ctx, "POST", "/localapi/v0/debug-packet-filter-rules", 200, nil)
@@ -1561,7 +1876,18 @@ func (lc *Client) DebugSetExpireIn(ctx context.Context, d time.Duration) error {
 // The provided context does not determine the lifetime of the
 // returned io.ReadCloser.
 func (lc *Client) StreamDebugCapture(ctx context.Context) (io.ReadCloser, error) {
-	req, err := http.NewRequestWithContext(ctx, "POST", "http://"+apitype.LocalAPIHost+"/localapi/v0/debug-capture", nil)
+	return lc.StreamDebugCaptureFilter(ctx, "")
+}
+
+// StreamDebugCaptureFilter is like StreamDebugCapture, but only streams the
+// packets matching filter, a tcpdump-like expression such as
+// "tcp and host 100.64.0.1". An empty filter matches all packets.
+func (lc *Client) StreamDebugCaptureFilter(ctx context.Context, filter string) (io.ReadCloser, error) {
+	path := "/localapi/v0/debug-capture"
+	if filter != "" {
+		path += "?filter=" + url.QueryEscape(filter)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", "http://"+apitype.LocalAPIHost+path, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1570,7 +1896,11 @@ func (lc *Client) StreamDebugCapture(ctx context.Context) (io.ReadCloser, error)
 		return nil, err
 	}
 	if res.StatusCode != 200 {
-		res.Body.Close()
+		defer res.Body.Close()
+		if res.StatusCode == http.StatusBadRequest {
+			body, _ := io.ReadAll(io.LimitReader(res.Body, 4<<10))
+			return nil, errors.New(errorMessageFromBody(body))
+		}
 		return nil, errors.New(res.Status)
 	}
 	return res.Body, nil
@@ -1609,6 +1939,38 @@ func (lc *Client) WatchIPNBus(ctx context.Context, mask ipn.NotifyWatchOpt) (*IP
 	}, nil
 }
 
+// WatchNetworkChanges subscribes to the network changes seen by tailscaled,
+// such as interfaces going up or down, address changes and default route
+// changes. The first event describes the network state at the time of the
+// call.
+//
+// The context is used for the life of the watch, not just the call to
+// WatchNetworkChanges.
+//
+// The returned NetworkChangeWatcher's Close method must be called when done
+// to release resources.
+func (lc *Client) WatchNetworkChanges(ctx context.Context) (*NetworkChangeWatcher, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET",
+		"http://"+apitype.LocalAPIHost+"/localapi/v0/watch-network-changes",
+		nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		res.Body.Close()
+		return nil, errors.New(res.Status)
+	}
+	return &NetworkChangeWatcher{
+		ctx:     ctx,
+		httpRes: res,
+		dec:     json.NewDecoder(res.Body),
+	}, nil
+}
+
 // CheckUpdate returns a tailcfg.ClientVersion indicating whether or not an update is available
 // to be installed via the LocalAPI. In case the LocalAPI can't install updates, it returns a
 // ClientVersion that says that we are up to date.
@@ -1721,6 +2083,43 @@ func (w *IPNBusWatcher) Next() (ipn.Notify, error) {
 	return n, nil
 }
 
+// NetworkChangeWatcher is an active subscription to the network changes
+// seen by tailscaled. It's returned by Client.WatchNetworkChanges.
+//
+// It must be closed when done.
+type NetworkChangeWatcher struct {
+	ctx     context.Context // from original WatchNetworkChanges call
+	httpRes *http.Response
+	dec     *json.Decoder
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// Close stops the watcher and releases its resources.
+func (w *NetworkChangeWatcher) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.httpRes.Body.Close()
+}
+
+// Next returns the next network change from the stream.
+// If the context from Client.WatchNetworkChanges is done, that error is returned.
+func (w *NetworkChangeWatcher) Next() (netmon.ChangeEvent, error) {
+	var ev netmon.ChangeEvent
+	if err := w.dec.Decode(&ev); err != nil {
+		if cerr := w.ctx.Err(); cerr != nil {
+			err = cerr
+		}
+		return netmon.ChangeEvent{}, err
+	}
+	return ev, nil
+}
+
 // SuggestExitNode requests an exit node suggestion and returns the exit node's details.
 func (lc *Client) SuggestExitNode(ctx context.Context) (apitype.ExitNodeSuggestionResponse, error) {
 	body, err := lc.get200(ctx, "/localapi/v0/suggest-exit-node")
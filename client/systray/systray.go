@@ -86,10 +86,13 @@ type Menu struct {
 	rebuildCh  chan struct{} // triggers a menu rebuild
 	accountsCh chan ipn.ProfileID
 	exitNodeCh chan tailcfg.StableNodeID // ID of selected exit node
+	filesCh    chan struct{}             // triggers a check for waiting Taildrop files
 
 	eventCancel context.CancelFunc // cancel eventLoop
 
 	notificationIcon *os.File // icon used for desktop notifications
+
+	notifiedFiles map[string]bool // names of waiting Taildrop files already notified
 }
 
 func (menu *Menu) init() {
@@ -101,6 +104,7 @@ func (menu *Menu) init() {
 	menu.rebuildCh = make(chan struct{}, 1)
 	menu.accountsCh = make(chan ipn.ProfileID)
 	menu.exitNodeCh = make(chan tailcfg.StableNodeID)
+	menu.filesCh = make(chan struct{}, 1)
 
 	// dbus wants a file path for notification icons, so copy to a temp file.
 	menu.notificationIcon, _ = os.CreateTemp("", "tailscale-systray.png")
@@ -108,11 +112,24 @@ func (menu *Menu) init() {
 
 	menu.bgCtx, menu.bgCancel = context.WithCancel(context.Background())
 	go menu.watchIPNBus()
+	go menu.watchWaitingFiles()
+}
+
+// isFreedesktop reports whether the current platform uses the freedesktop.org
+// StatusNotifierItem and notification D-Bus interfaces, rather than a native
+// tray API.
+func isFreedesktop() bool {
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd", "netbsd":
+		return true
+	}
+	return false
 }
 
 func init() {
-	if runtime.GOOS != "linux" {
-		// so far, these tweaks are only needed on Linux
+	if !isFreedesktop() {
+		// so far, these tweaks are only needed on Linux and the BSDs,
+		// which share the same desktop environments
 		return
 	}
 
@@ -318,7 +335,7 @@ func setTooltip(text string) {
 	if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
 		systray.SetTooltip(text)
 	} else {
-		// on Linux, SetTitle actually sets the tooltip
+		// on Linux and the BSDs, SetTitle actually sets the tooltip
 		systray.SetTitle(text)
 	}
 }
@@ -445,6 +462,13 @@ func (menu *Menu) watchIPNBusInner() error {
 			if n.Prefs != nil {
 				rebuild = true
 			}
+			if n.FilesWaiting != nil {
+				// Coalesce with a check that's already pending.
+				select {
+				case menu.filesCh <- struct{}{}:
+				default:
+				}
+			}
 			if rebuild {
 				menu.rebuildCh <- struct{}{}
 			}
@@ -468,6 +492,52 @@ func (menu *Menu) copyTailscaleIP(device *ipnstate.PeerStatus) {
 	menu.sendNotification(fmt.Sprintf("Copied Address for %v", name), ip)
 }
 
+// watchWaitingFiles checks for waiting Taildrop files each time filesCh is
+// signaled, one check at a time, so that an older check's result never
+// replaces a newer one's. It returns when bgCtx is done.
+func (menu *Menu) watchWaitingFiles() {
+	for {
+		select {
+		case <-menu.bgCtx.Done():
+			return
+		case <-menu.filesCh:
+			menu.notifyWaitingFiles()
+		}
+	}
+}
+
+// notifyWaitingFiles sends a notification for each received Taildrop file
+// that hasn't already been announced.
+func (menu *Menu) notifyWaitingFiles() {
+	files, err := menu.lc.WaitingFiles(menu.bgCtx)
+	if err != nil {
+		log.Printf("waiting files: %v", err)
+		return
+	}
+
+	menu.mu.Lock()
+	var names []string
+	seen := make(map[string]bool, len(files))
+	for _, f := range files {
+		seen[f.Name] = true
+		if !menu.notifiedFiles[f.Name] {
+			names = append(names, f.Name)
+		}
+	}
+	// Forget files that have since been picked up, so that a new file
+	// with the same name is announced again.
+	menu.notifiedFiles = seen
+	menu.mu.Unlock()
+
+	switch len(names) {
+	case 0:
+	case 1:
+		menu.sendNotification("Taildrop: file received", names[0])
+	default:
+		menu.sendNotification(fmt.Sprintf("Taildrop: %d files received", len(names)), strings.Join(names, "\n"))
+	}
+}
+
 // sendNotification sends a desktop notification with the given title and content.
 func (menu *Menu) sendNotification(title, content string) {
 	conn, err := dbus.SessionBus()
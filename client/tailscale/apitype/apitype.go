@@ -5,6 +5,10 @@
 package apitype
 
 import (
+	"io/fs"
+	"net/netip"
+	"time"
+
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/dnstype"
 	"tailscale.com/util/ctxkey"
@@ -54,6 +58,51 @@ type FileTarget struct {
 type WaitingFile struct {
 	Name string
 	Size int64
+
+	// SHA256 is the hex SHA-256 of the file's contents, as sent by the
+	// sender and verified on receipt. It's empty if the sender didn't
+	// send one.
+	SHA256 string `json:",omitempty"`
+
+	// ModTime and Mode are the modification time and permission bits the
+	// file had on the sender, if the sender asked for them to be preserved.
+	ModTime time.Time   `json:",omitzero"`
+	Mode    fs.FileMode `json:",omitempty"`
+
+	// Archive, if non-empty, is the format of an archive of a directory
+	// the file is, to be extracted by the receiver. The only format is
+	// "tar".
+	Archive string `json:",omitempty"`
+}
+
+// Headers of file PUTs to the LocalAPI and to peers carrying the file's
+// metadata.
+const (
+	// TaildropSHA256Header is the hex SHA-256 of the file's contents.
+	// Peers reject the file if it doesn't match.
+	TaildropSHA256Header = "Taildrop-Sha256"
+
+	// TaildropModTimeHeader is the file's modification time, in RFC 3339
+	// format with nanoseconds.
+	TaildropModTimeHeader = "Taildrop-Mod-Time"
+
+	// TaildropModeHeader is the file's permission bits, in octal.
+	TaildropModeHeader = "Taildrop-Mode"
+
+	// TaildropArchiveHeader is the format of the archive of a directory
+	// the file is, if it is one: "tar".
+	TaildropArchiveHeader = "Taildrop-Archive"
+)
+
+// FileResumeOffset is the response to a LocalAPI file-resume-offset request.
+type FileResumeOffset struct {
+	// Offset is how many bytes from the start of the file the peer
+	// already has from an earlier, interrupted attempt to send it. A new
+	// attempt to send the same content skips them.
+	//
+	// It's zero for peers that can't receive files in chunks, which can
+	// only report what they have as checksums of the content.
+	Offset int64
 }
 
 // SetPushDeviceTokenRequest is the body POSTed to the LocalAPI endpoint /set-device-token.
@@ -94,3 +143,174 @@ type DNSQueryResponse struct {
 	// Resolvers is the list of resolvers that the forwarder deemed able to resolve the query.
 	Resolvers []*dnstype.Resolver
 }
+
+// ACLCheckResponse is the response to a LocalAPI acl-check request. It
+// describes whether the node's packet filter permits some incoming traffic,
+// and which rule permits it.
+type ACLCheckResponse struct {
+	// Allowed is whether the packet filter accepts the traffic, and Reason
+	// why it reached that verdict, such as "tcp ok" or "no rules matched".
+	Allowed bool
+	Reason  string
+
+	// ShieldsUp is whether the node blocks all incoming connections,
+	// regardless of the rules.
+	ShieldsUp bool `json:",omitempty"`
+
+	// RuleIndex is the index in the netmap's packet filter of the first
+	// rule permitting the traffic, or -1 if none does. Match is that rule
+	// as compiled for the packet filter, and Rule is that rule as sent by
+	// the control plane, if known.
+	RuleIndex int
+	Match     string              `json:",omitempty"`
+	Rule      *tailcfg.FilterRule `json:",omitempty"`
+
+	// SSH is the result of checking the SSH policy, for TCP traffic to
+	// port 22 while the node runs Tailscale SSH.
+	SSH *ACLCheckSSH `json:",omitempty"`
+}
+
+// ACLCheckSSH is the result of checking a source against a node's SSH policy.
+type ACLCheckSSH struct {
+	// RuleIndex is the index of the first unexpired SSH rule whose
+	// principals match the source, or -1 if none does, and Rule is that
+	// rule.
+	RuleIndex int
+	Rule      *tailcfg.SSHRule `json:",omitempty"`
+}
+
+// FilterStats is the response to a LocalAPI filter-stats request. It
+// describes what the node's packet filter has accepted and dropped since
+// stats were enabled.
+type FilterStats struct {
+	// Enabled is whether the packet filter is counting packets. The
+	// other fields are empty if not.
+	Enabled bool
+
+	// Rules are the rules of the current packet filter, with the number
+	// of new incoming flows each accepted. Counters restart when the
+	// rules change.
+	Rules []FilterRuleStats `json:",omitempty"`
+
+	// DropsByReason counts the incoming packets dropped, by the reason
+	// the filter gave, such as "no rules matched".
+	DropsByReason map[string]uint64 `json:",omitempty"`
+
+	// Drops are the most recently logged drops, oldest first. Logging
+	// is rate limited, and DropsNotLogged is the number of drops
+	// counted in DropsByReason but not logged.
+	Drops          []FilterDrop `json:",omitempty"`
+	DropsNotLogged uint64       `json:",omitempty"`
+}
+
+// FilterRuleStats is the counter for one rule of the packet filter.
+type FilterRuleStats struct {
+	// RuleIndex is the index of the rule in the netmap's packet filter.
+	// Match is the rule as compiled for the packet filter, and Rule is
+	// the rule as sent by the control plane, if known.
+	RuleIndex int
+	Match     string
+	Rule      *tailcfg.FilterRule `json:",omitempty"`
+
+	// Packets is the number of new flows the rule accepted. Only the
+	// first rule accepting a flow counts it.
+	Packets uint64
+}
+
+// FilterDrop is an incoming packet dropped by the packet filter.
+type FilterDrop struct {
+	Time   time.Time
+	Src    netip.AddrPort
+	Dst    netip.AddrPort
+	Proto  string // such as "TCP" or "UDP"
+	Reason string
+}
+
+// BenchResult is the response to a LocalAPI bench request: the throughput
+// and latency under load measured over the WireGuard tunnel to a peer.
+type BenchResult struct {
+	NodeName string
+	NodeIP   netip.Addr
+
+	// Path is how the peer was reached when the test started, such as
+	// "direct 192.0.2.1:41641" or "DERP(fra)", and PathAfter is how it was
+	// reached when it ended.
+	Path      string
+	PathAfter string
+
+	// IdleLatency is the round-trip time before any load.
+	IdleLatency BenchLatency
+
+	// Upload and Download are the results of sending data to the peer
+	// and receiving data from it, if tested.
+	Upload   *BenchPhase `json:",omitempty"`
+	Download *BenchPhase `json:",omitempty"`
+}
+
+// BenchPhase is the result of transferring data in one direction in a
+// BenchResult.
+type BenchPhase struct {
+	Bytes         int64
+	Seconds       float64
+	BitsPerSecond float64
+
+	// Latency is the round-trip time during the transfer.
+	Latency BenchLatency
+}
+
+// BenchLatency summarizes the TSMP pings sent to a peer in a BenchResult.
+// The latencies are zero if no pong was received.
+type BenchLatency struct {
+	Pings      int
+	Pongs      int
+	MinSeconds float64
+	AvgSeconds float64
+	MaxSeconds float64
+}
+
+// CertStatus is the status of the node's HTTPS cert for a domain, as kept by
+// tailscaled's background renewal, in the response to a LocalAPI cert-status
+// request.
+type CertStatus struct {
+	Domain string
+
+	// NotAfter is when the current cert expires, if there's a valid one,
+	// and RenewAt is when it's due for renewal. Expired is whether the
+	// stored cert is expired or otherwise no longer valid.
+	NotAfter time.Time `json:",omitzero"`
+	RenewAt  time.Time `json:",omitzero"`
+	Expired  bool      `json:",omitempty"`
+
+	// LastChecked is when the cert was last checked for renewal, and
+	// LastRenewed when it was last renewed by this tailscaled.
+	LastChecked time.Time `json:",omitzero"`
+	LastRenewed time.Time `json:",omitzero"`
+
+	// LastError is the error of the last renewal attempt, if it failed.
+	LastError string `json:",omitempty"`
+
+	// OCSPStapled is whether an OCSP response is stapled to the cert when
+	// tailscaled serves it, valid until OCSPNextUpdate. OCSPError is the
+	// error of the last attempt to fetch one, if it failed. Certs without an
+	// OCSP server aren't stapled.
+	OCSPStapled    bool      `json:",omitempty"`
+	OCSPNextUpdate time.Time `json:",omitzero"`
+	OCSPError      string    `json:",omitempty"`
+}
+
+// SSHRecording is a Tailscale SSH session recording kept on the node, in
+// the response to a LocalAPI ssh-recordings GET request.
+type SSHRecording struct {
+	Path    string    // absolute path of the asciinema cast file
+	Size    int64     // size in bytes, which grows while the session is open
+	ModTime time.Time // when last written to
+}
+
+// RemoteDiagRequest is a request from the control plane for diagnostics from
+// the node, waiting for the local user's consent, in the response to a
+// LocalAPI remote-diag GET request.
+type RemoteDiagRequest struct {
+	ID        string
+	Kind      string // "netcheck", "bugreport" or "routes"
+	Requested time.Time
+}
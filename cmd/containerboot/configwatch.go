@@ -0,0 +1,78 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// configPollInterval is how often watchConfigDir checks for changes if
+// fsnotify is not available.
+const configPollInterval = 5 * time.Second
+
+// watchConfigDir returns a channel that receives a value whenever files in
+// dir may have changed, until ctx is done. It is used to hot reload config
+// files mounted from a Kubernetes ConfigMap or Secret.
+//
+// Kubernetes updates such mounts by atomically swapping a symlink, which
+// shows up as a burst of events on the directory rather than as a write to
+// the file itself. Callers therefore can't filter on the event and should
+// simply re-read their config on each receive. Bursts are coalesced, so a
+// single update results in at most one or two receives.
+//
+// If an fsnotify watcher can't be created, watchConfigDir falls back to
+// polling every configPollInterval, and the callers' own change detection
+// determines whether anything needs to be reapplied.
+func watchConfigDir(ctx context.Context, dir string) (<-chan struct{}, error) {
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("failed to create fsnotify watcher for %s, timer-only mode: %v", dir, err)
+		go func() {
+			ticker := time.NewTicker(configPollInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					notify()
+				}
+			}
+		}()
+		return changed, nil
+	}
+	if err := w.Add(dir); err != nil {
+		w.Close()
+		return nil, fmt.Errorf("failed to add fsnotify watch for %s: %w", dir, err)
+	}
+	go func() {
+		defer w.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-w.Events:
+				notify()
+			case err := <-w.Errors:
+				log.Printf("fsnotify error watching %s: %v", dir, err)
+			}
+		}
+	}()
+	return changed, nil
+}
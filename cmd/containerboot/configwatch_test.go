@@ -0,0 +1,68 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchConfigDir(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	changed, err := watchConfigDir(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitChanged := func(what string) {
+		t.Helper()
+		select {
+		case <-changed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no change notification after %s", what)
+		}
+		// Drain any coalesced notification for the same update.
+		for {
+			select {
+			case <-changed:
+				continue
+			case <-time.After(100 * time.Millisecond):
+			}
+			break
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "cfg"), []byte("1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitChanged("writing file")
+
+	// Update the directory the way Kubernetes updates a ConfigMap mount:
+	// write a new data directory, then atomically swap the ..data
+	// symlink to point at it.
+	data1 := filepath.Join(dir, "..data_1")
+	if err := os.Mkdir(data1, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(data1, filepath.Join(dir, "..data_tmp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	waitChanged("swapping symlink")
+
+	select {
+	case <-changed:
+		t.Fatal("unexpected change notification with no changes")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"tailscale.com/net/tsaddr"
+)
+
+const (
+	// dnsForwarderTimeout is how long the DNS forwarder waits for the
+	// MagicDNS resolver to answer a UDP query, and how long a TCP
+	// connection may be idle, before giving up.
+	dnsForwarderTimeout = 10 * time.Second
+
+	// maxDNSMessageSize is the largest DNS message that the forwarder
+	// relays over UDP.
+	maxDNSMessageSize = 65535
+
+	// dnsHeaderLen is the length of a DNS message header, which starts
+	// with the message ID.
+	dnsHeaderLen = 12
+
+	// dnsForwarderUDPWorkers is how many UDP queries the DNS forwarder
+	// relays at once on each address, each worker over its own upstream
+	// connection.
+	dnsForwarderUDPWorkers = 32
+
+	// dnsForwarderUDPQueueLen is how many UDP queries may wait for a
+	// worker before the DNS forwarder drops new ones.
+	dnsForwarderUDPQueueLen = 256
+)
+
+// dnsForwarder relays DNS queries received on the Pod's IP addresses to
+// the tailscaled MagicDNS resolver at 100.100.100.100, so that cluster
+// workloads that are not on the tailnet can resolve tailnet names via a
+// cluster DNS stub domain pointed at this Pod. It is used by ProxyGroups of
+// type dns.
+type dnsForwarder struct {
+	upstream netip.AddrPort // the MagicDNS resolver; overridden in tests
+}
+
+func newDNSForwarder() *dnsForwarder {
+	return &dnsForwarder{
+		upstream: netip.AddrPortFrom(tsaddr.TailscaleServiceIP(), 53),
+	}
+}
+
+// run listens for DNS queries over UDP and TCP on each of addrs and
+// relays them until ctx is done or a listener fails.
+func (f *dnsForwarder) run(ctx context.Context, addrs []netip.AddrPort) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, 2*len(addrs))
+	var lc net.ListenConfig
+	for _, ap := range addrs {
+		pc, err := lc.ListenPacket(ctx, "udp", ap.String())
+		if err != nil {
+			return fmt.Errorf("error listening for DNS queries on udp %v: %w", ap, err)
+		}
+		defer pc.Close()
+		ln, err := lc.Listen(ctx, "tcp", ap.String())
+		if err != nil {
+			return fmt.Errorf("error listening for DNS queries on tcp %v: %w", ap, err)
+		}
+		defer ln.Close()
+		log.Printf("Forwarding DNS queries received on %v to %v", ap, f.upstream)
+		go func() { errs <- f.serveUDP(ctx, pc) }()
+		go func() { errs <- f.serveTCP(ctx, ln) }()
+	}
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errs:
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+}
+
+// serveUDP relays each query read from pc to the upstream resolver and
+// writes the response back to the client. Queries are handed to a fixed
+// pool of workers; when they're all busy and the queue is full, queries
+// are dropped, as by any overloaded resolver, and clients retry. It
+// returns when pc is closed.
+func (f *dnsForwarder) serveUDP(ctx context.Context, pc net.PacketConn) error {
+	queries := make(chan udpQuery, dnsForwarderUDPQueueLen)
+	defer close(queries)
+	for range dnsForwarderUDPWorkers {
+		go f.udpWorker(ctx, pc, queries)
+	}
+	buf := make([]byte, maxDNSMessageSize)
+	for {
+		n, src, err := pc.ReadFrom(buf)
+		if err != nil {
+			return fmt.Errorf("error reading DNS query: %w", err)
+		}
+		if n < dnsHeaderLen {
+			continue
+		}
+		select {
+		case queries <- udpQuery{msg: bytes.Clone(buf[:n]), src: src}:
+		default:
+		}
+	}
+}
+
+// udpQuery is a DNS query received over UDP.
+type udpQuery struct {
+	msg []byte
+	src net.Addr
+}
+
+// udpWorker forwards queries to the upstream resolver over a single UDP
+// connection, which it reuses until an exchange fails, and writes the
+// responses back to the clients on pc.
+func (f *dnsForwarder) udpWorker(ctx context.Context, pc net.PacketConn, queries <-chan udpQuery) {
+	var up net.Conn
+	defer func() {
+		if up != nil {
+			up.Close()
+		}
+	}()
+	resp := make([]byte, maxDNSMessageSize)
+	for q := range queries {
+		if up == nil {
+			d := net.Dialer{Timeout: dnsForwarderTimeout}
+			c, err := d.DialContext(ctx, "udp", f.upstream.String())
+			if err != nil {
+				log.Printf("error forwarding DNS query from %v: %v", q.src, err)
+				continue
+			}
+			up = c
+		}
+		n, err := exchangeUDP(up, q.msg, resp)
+		if err != nil {
+			log.Printf("error forwarding DNS query from %v: %v", q.src, err)
+			// Start over with a new connection, so that a late response
+			// to this query can't be taken for the next one's.
+			up.Close()
+			up = nil
+			continue
+		}
+		if _, err := pc.WriteTo(resp[:n], q.src); err != nil {
+			log.Printf("error writing DNS response to %v: %v", q.src, err)
+		}
+	}
+}
+
+// exchangeUDP sends query to the upstream resolver on up and reads its
+// response into resp, returning the response's length. Responses whose
+// ID doesn't match the query's are skipped.
+func exchangeUDP(up net.Conn, query, resp []byte) (int, error) {
+	up.SetDeadline(time.Now().Add(dnsForwarderTimeout))
+	if _, err := up.Write(query); err != nil {
+		return 0, err
+	}
+	for {
+		n, err := up.Read(resp)
+		if err != nil {
+			return 0, err
+		}
+		if n >= dnsHeaderLen && resp[0] == query[0] && resp[1] == query[1] {
+			return n, nil
+		}
+	}
+}
+
+// serveTCP proxies each connection accepted on ln to the upstream
+// resolver. It returns when ln is closed.
+func (f *dnsForwarder) serveTCP(ctx context.Context, ln net.Listener) error {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return fmt.Errorf("error accepting DNS connection: %w", err)
+		}
+		go func() {
+			if err := f.proxyTCP(ctx, c); err != nil {
+				log.Printf("error forwarding DNS connection from %v: %v", c.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+func (f *dnsForwarder) proxyTCP(ctx context.Context, c net.Conn) error {
+	defer c.Close()
+	d := net.Dialer{Timeout: dnsForwarderTimeout}
+	up, err := d.DialContext(ctx, "tcp", f.upstream.String())
+	if err != nil {
+		return err
+	}
+	defer up.Close()
+
+	errc := make(chan error, 2)
+	cp := func(dst, src net.Conn) {
+		_, err := io.Copy(dst, deadlineReader{src})
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		}
+		errc <- err
+	}
+	go cp(up, c)
+	go cp(c, up)
+	for range 2 {
+		if err := <-errc; err != nil && !errors.Is(err, net.ErrClosed) {
+			return err
+		}
+	}
+	return nil
+}
+
+// deadlineReader is a net.Conn reader that extends the read deadline by
+// dnsForwarderTimeout before each read, so that idle connections are
+// eventually closed.
+type deadlineReader struct {
+	net.Conn
+}
+
+func (r deadlineReader) Read(b []byte) (int, error) {
+	r.Conn.SetReadDeadline(time.Now().Add(dnsForwarderTimeout))
+	return r.Conn.Read(b)
+}
+
+// dnsForwarderAddrs returns the addresses on which the DNS forwarder
+// should listen: the configured port on each of the Pod's IP addresses.
+func dnsForwarderAddrs(cfg *settings) ([]netip.AddrPort, error) {
+	port, err := strconv.ParseUint(cfg.DNSForwarderPort, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing TS_EXPERIMENTAL_DNS_FORWARDER_PORT value %q: %w", cfg.DNSForwarderPort, err)
+	}
+	var addrs []netip.AddrPort
+	for _, s := range []string{cfg.PodIPv4, cfg.PodIPv6} {
+		if s == "" {
+			continue
+		}
+		ip, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, netip.AddrPortFrom(ip, uint16(port)))
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("TS_EXPERIMENTAL_DNS_FORWARDER_PORT is set but POD_IPS is not")
+	}
+	return addrs, nil
+}
@@ -0,0 +1,220 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestDNSForwarder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A fake MagicDNS resolver that answers every query over UDP with the
+	// query, so with its ID, followed by ":resp", and every query over TCP
+	// with the query prefixed by "resp:", on the same port.
+	upUDP, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upUDP.Close()
+	upstream := netip.MustParseAddrPort(upUDP.LocalAddr().String())
+	upTCP, err := net.Listen("tcp", upstream.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upTCP.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, src, err := upUDP.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			upUDP.WriteTo(append(buf[:n:n], ":resp"...), src)
+		}
+	}()
+	go func() {
+		for {
+			c, err := upTCP.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				b, _ := io.ReadAll(c)
+				c.Write(append([]byte("resp:"), b...))
+			}()
+		}
+	}()
+
+	// Pick a free port for the forwarder.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := netip.MustParseAddrPort(ln.Addr().String())
+	ln.Close()
+
+	f := &dnsForwarder{upstream: upstream}
+	errc := make(chan error, 1)
+	go func() { errc <- f.run(ctx, []netip.AddrPort{addr}) }()
+
+	for range 50 {
+		// Wait for the forwarder to start listening.
+		if c, err := net.Dial("tcp", addr.String()); err == nil {
+			c.Close()
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	udpConn, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpConn.Close()
+	udpConn.SetDeadline(time.Now().Add(5 * time.Second))
+	// Send a burst of queries, which are all answered through the
+	// forwarder's workers.
+	const numQueries = 100
+	want := map[string]bool{}
+	for i := range numQueries {
+		q := fmt.Sprintf("query-%08d", i)
+		want[q+":resp"] = true
+		if _, err := udpConn.Write([]byte(q)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf := make([]byte, 512)
+	for range numQueries {
+		n, err := udpConn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !want[string(buf[:n])] {
+			t.Errorf("unexpected UDP response %q", buf[:n])
+		}
+		delete(want, string(buf[:n]))
+	}
+
+	tcpConn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpConn.Close()
+	tcpConn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := tcpConn.Write([]byte("query2")); err != nil {
+		t.Fatal(err)
+	}
+	tcpConn.(*net.TCPConn).CloseWrite()
+	got, err := io.ReadAll(tcpConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte("resp:query2"); !bytes.Equal(got, want) {
+		t.Errorf("TCP response = %q; want %q", got, want)
+	}
+
+	cancel()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("run returned %v after cancellation", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("forwarder did not shut down")
+	}
+}
+
+func TestExchangeUDP(t *testing.T) {
+	up, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer up.Close()
+	// The resolver first sends a late response to an earlier query, with
+	// another ID, which must be skipped.
+	go func() {
+		buf := make([]byte, 512)
+		n, src, err := up.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		up.WriteTo([]byte("XXstale-response"), src)
+		up.WriteTo(append(buf[:n:n], ":resp"...), src)
+	}()
+
+	c, err := net.Dial("udp", up.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	resp := make([]byte, maxDNSMessageSize)
+	n, err := exchangeUDP(c, []byte("query-00000001"), resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(resp[:n]), "query-00000001:resp"; got != want {
+		t.Errorf("response = %q; want %q", got, want)
+	}
+}
+
+func TestDNSForwarderAddrs(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     settings
+		want    []netip.AddrPort
+		wantErr bool
+	}{
+		{
+			name: "dual_stack",
+			cfg:  settings{DNSForwarderPort: "53", PodIPv4: "10.0.0.1", PodIPv6: "fd00::1"},
+			want: []netip.AddrPort{
+				netip.MustParseAddrPort("10.0.0.1:53"),
+				netip.MustParseAddrPort("[fd00::1]:53"),
+			},
+		},
+		{
+			name: "ipv4_only",
+			cfg:  settings{DNSForwarderPort: "5353", PodIPv4: "10.0.0.1"},
+			want: []netip.AddrPort{netip.MustParseAddrPort("10.0.0.1:5353")},
+		},
+		{
+			name:    "no_pod_ips",
+			cfg:     settings{DNSForwarderPort: "53"},
+			wantErr: true,
+		},
+		{
+			name:    "bad_port",
+			cfg:     settings{DNSForwarderPort: "dns", PodIPv4: "10.0.0.1"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := dnsForwarderAddrs(&tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v; want error %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v; want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got %v; want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
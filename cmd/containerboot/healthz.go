@@ -6,11 +6,17 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
+	"tailscale.com/client/local"
+	"tailscale.com/health"
 	"tailscale.com/kube/kubetypes"
 )
 
@@ -55,3 +61,124 @@ func healthHandlers(mux *http.ServeMux, podIPv4 string) *healthz {
 	mux.Handle("GET /healthz", h)
 	return h
 }
+
+// readiness is a readiness check server. It returns 200 OK once this node
+// is ready to receive traffic: it is logged in, has a netmap with at least
+// one tailnet IP address and has finished configuring any proxy rules.
+// Once shutdown has started, it returns 503 so that Kubernetes stops
+// routing traffic to the Pod while in-flight connections drain.
+type readiness struct {
+	// requireHealthy, if set, additionally makes this node unready while
+	// tailscaled reports health warnings that impact connectivity.
+	requireHealthy bool
+
+	mu         sync.Mutex
+	hasAddrs   bool
+	configured bool
+	warnings   []string // titles of warnings that impact connectivity
+	draining   bool
+}
+
+func (r *readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	reason := r.notReadyReasonLocked()
+	r.mu.Unlock()
+
+	if reason != "" {
+		http.Error(w, reason, http.StatusServiceUnavailable)
+		return
+	}
+	if _, err := w.Write([]byte("ok")); err != nil {
+		http.Error(w, fmt.Sprintf("error writing status: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// notReadyReasonLocked returns why this node is not ready, or the empty
+// string if it is. r.mu must be held.
+func (r *readiness) notReadyReasonLocked() string {
+	switch {
+	case r.draining:
+		return "shutting down"
+	case !r.hasAddrs:
+		return "node currently has no tailscale IPs"
+	case !r.configured:
+		return "node is still being configured"
+	case r.requireHealthy && len(r.warnings) > 0:
+		return fmt.Sprintf("node is unhealthy: %s", strings.Join(r.warnings, "; "))
+	}
+	return ""
+}
+
+// update calls f with r.mu held and logs any resulting change in readiness.
+func (r *readiness) update(f func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	wasReady := r.notReadyReasonLocked() == ""
+	f()
+	if reason := r.notReadyReasonLocked(); (reason == "") != wasReady {
+		if reason == "" {
+			log.Println("Setting ready true")
+		} else {
+			log.Printf("Setting ready false: %s", reason)
+		}
+	}
+}
+
+func (r *readiness) setHasAddrs(hasAddrs bool) {
+	r.update(func() { r.hasAddrs = hasAddrs })
+}
+
+// setConfigured marks the startup configuration of this node as complete.
+func (r *readiness) setConfigured() {
+	r.update(func() { r.configured = true })
+}
+
+// setHealth records the health warnings from st that impact connectivity.
+func (r *readiness) setHealth(st *health.State) {
+	var warnings []string
+	for _, w := range st.Warnings {
+		if w.ImpactsConnectivity {
+			warnings = append(warnings, w.Title)
+		}
+	}
+	slices.Sort(warnings)
+	r.update(func() { r.warnings = warnings })
+}
+
+// startDraining permanently marks this node as not ready.
+func (r *readiness) startDraining() {
+	r.update(func() { r.draining = true })
+}
+
+// liveness is a liveness check server. It returns 200 OK as long as
+// tailscaled's LocalAPI is responsive. It deliberately does not depend on
+// tailnet connectivity, so that Kubernetes only restarts containers whose
+// tailscaled has hung rather than ones that are waiting on the network.
+type liveness struct {
+	check func(context.Context) error
+}
+
+func (l *liveness) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	if err := l.check(ctx); err != nil {
+		http.Error(w, fmt.Sprintf("tailscaled is not responding: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	if _, err := w.Write([]byte("ok")); err != nil {
+		http.Error(w, fmt.Sprintf("error writing status: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// probeHandlers registers readiness and liveness handlers at /readyz and
+// /livez.
+func probeHandlers(mux *http.ServeMux, lc *local.Client, requireHealthy bool) *readiness {
+	r := &readiness{requireHealthy: requireHealthy}
+	mux.Handle("GET /readyz", r)
+	mux.Handle("GET /livez", &liveness{check: func(ctx context.Context) error {
+		_, err := lc.StatusWithoutPeers(ctx)
+		return err
+	}})
+	return r
+}
@@ -51,7 +51,8 @@
 //     It will be applied once tailscaled is up and running. If the file contains
 //     ${TS_CERT_DOMAIN}, it will be replaced with the value of the available FQDN.
 //     It cannot be used in conjunction with TS_DEST_IP. The file is watched for changes,
-//     and will be re-applied when it changes.
+//     and will be re-applied when it changes. If an updated file can't be parsed,
+//     the previously applied config is kept.
 //   - TS_HEALTHCHECK_ADDR_PORT: deprecated, use TS_ENABLE_HEALTH_CHECK instead and optionally
 //     set TS_LOCAL_ADDR_PORT. Will be removed in 1.82.0.
 //   - TS_LOCAL_ADDR_PORT: the address and port to serve local metrics and health
@@ -64,6 +65,20 @@
 //     the address specified by TS_LOCAL_ADDR_PORT. The health endpoint will return 200
 //     OK if this node has at least one tailnet IP address, otherwise returns 503.
 //     NB: the health criteria might change in the future.
+//     Readiness and liveness endpoints are also served at /readyz and /livez.
+//     /readyz returns 200 OK once this node is logged in, has a netmap with at
+//     least one tailnet IP address and has finished setting up any proxy
+//     rules, and returns 503 again once shutdown has started. /livez returns
+//     200 OK as long as tailscaled's LocalAPI is responsive.
+//   - TS_EXPERIMENTAL_READINESS_REQUIRE_HEALTHY: if true, /readyz additionally
+//     returns 503 while tailscaled reports any health warnings that impact
+//     connectivity. Requires TS_ENABLE_HEALTH_CHECK.
+//   - TS_EXPERIMENTAL_SHUTDOWN_DRAIN_PERIOD: if set to a duration, on SIGTERM
+//     containerboot marks the node as not ready, withdraws any subnet routes
+//     that it advertises and keeps tailscaled running for this long before
+//     shutting it down, so that traffic can move to other replicas first. On
+//     Kubernetes, the Pod's terminationGracePeriodSeconds must leave room for
+//     the drain period on top of tailscaled's own shutdown.
 //   - TS_EXPERIMENTAL_VERSIONED_CONFIG_DIR: if specified, a path to a
 //     directory that containers tailscaled config in file. The config file needs to be
 //     named cap-<current-tailscaled-cap>.hujson. If this is set, TS_HOSTNAME,
@@ -87,6 +102,12 @@
 //     cluster using the same hostname (in this case, the MagicDNS name of the ingress proxy)
 //     as a non-cluster workload on tailnet.
 //     This is only meant to be configured by the Kubernetes operator.
+//   - TS_EXPERIMENTAL_DNS_FORWARDER_PORT: if set, serve DNS over UDP and TCP on
+//     this port on each of the Pod's IP addresses (from POD_IPS), forwarding
+//     all queries to the MagicDNS resolver at 100.100.100.100. This lets
+//     cluster workloads that are not on the tailnet resolve MagicDNS names.
+//     Requires kernel networking.
+//     This is only meant to be configured by the Kubernetes operator.
 //
 // When running on Kubernetes, containerboot defaults to storing state in the
 // "tailscale" kube secret. To store state on local disk instead, set
@@ -118,6 +139,7 @@
 	"time"
 
 	"golang.org/x/sys/unix"
+	"tailscale.com/client/local"
 	"tailscale.com/client/tailscale"
 	"tailscale.com/ipn"
 	kubeutils "tailscale.com/k8s-operator"
@@ -220,7 +242,10 @@ func run() error {
 	}
 	defer killTailscaled()
 
-	var healthCheck *healthz
+	var (
+		healthCheck *healthz
+		ready       *readiness
+	)
 	ep := &egressProxy{}
 	if cfg.HealthCheckAddrPort != "" {
 		mux := http.NewServeMux()
@@ -243,6 +268,8 @@ func run() error {
 		if cfg.localHealthEnabled() {
 			log.Printf("Running healthcheck endpoint at %s/healthz", cfg.LocalAddrPort)
 			healthCheck = healthHandlers(mux, cfg.PodIPv4)
+			log.Printf("Running readiness and liveness endpoints at %s/readyz and %s/livez", cfg.LocalAddrPort, cfg.LocalAddrPort)
+			ready = probeHandlers(mux, client, cfg.ReadinessRequireHealthy)
 		}
 		if cfg.EgressProxiesCfgPath != "" {
 			log.Printf("Running preshutdown hook at %s%s", cfg.LocalAddrPort, kubetypes.EgessServicesPreshutdownEP)
@@ -386,7 +413,11 @@ func run() error {
 		}
 	}
 
-	w, err = client.WatchIPNBus(ctx, ipn.NotifyInitialNetMap|ipn.NotifyInitialState)
+	watchOpts := ipn.NotifyInitialNetMap | ipn.NotifyInitialState
+	if cfg.ReadinessRequireHealthy {
+		watchOpts |= ipn.NotifyInitialHealthState
+	}
+	w, err = client.WatchIPNBus(ctx, watchOpts)
 	if err != nil {
 		return fmt.Errorf("rewatching tailscaled for updates after auth: %w", err)
 	}
@@ -437,6 +468,9 @@ func run() error {
 	// egressSvcsErrorChan will get an error sent to it if this containerboot instance is configured to expose 1+
 	// egress services in HA mode and errored.
 	var egressSvcsErrorChan = make(chan error)
+	// dnsForwarderErrorChan will get an error sent to it if the DNS
+	// forwarder enabled by TS_EXPERIMENTAL_DNS_FORWARDER_PORT fails.
+	var dnsForwarderErrorChan = make(chan error)
 	defer t.Stop()
 	// resetTimer resets timer for when to next attempt to resolve the DNS
 	// name for the proxy configured with TS_EXPERIMENTAL_DEST_DNS_NAME. The
@@ -482,6 +516,7 @@ func run() error {
 	for {
 		select {
 		case <-ctx.Done():
+			drainForShutdown(client, cfg, ready)
 			// Although killTailscaled() is deferred earlier, if we
 			// have started the reaper defined below, we need to
 			// kill tailscaled and let reaper clean up child
@@ -501,6 +536,9 @@ func run() error {
 				// whereupon we'll go through initial auth again.
 				return fmt.Errorf("tailscaled left running state (now in state %q), exiting", *n.State)
 			}
+			if n.Health != nil && ready != nil {
+				ready.setHealth(n.Health)
+			}
 			if n.NetMap != nil {
 				addrs = n.NetMap.SelfNode.Addresses().AsSlice()
 				newCurrentIPs := deephash.Hash(&addrs)
@@ -643,6 +681,9 @@ func run() error {
 				if healthCheck != nil {
 					healthCheck.update(len(addrs) != 0)
 				}
+				if ready != nil {
+					ready.setHasAddrs(len(addrs) != 0)
+				}
 
 				if cfg.ServeConfigPath != "" {
 					triggerWatchServeConfigChanges.Do(func() {
@@ -665,6 +706,9 @@ func run() error {
 					// post-auth configuration is done.
 					log.Println("Startup complete, waiting for shutdown signal")
 					startupTasksDone = true
+					if ready != nil {
+						ready.setConfigured()
+					}
 
 					// Configure egress proxy. Egress proxy will set up firewall rules to proxy
 					// traffic to tailnet targets configured in the provided configuration file. It
@@ -691,6 +735,20 @@ func run() error {
 						}()
 					}
 
+					// Start forwarding DNS queries from the cluster to
+					// MagicDNS, now that tailscaled can answer them.
+					if cfg.DNSForwarderPort != "" {
+						dnsAddrs, err := dnsForwarderAddrs(cfg)
+						if err != nil {
+							return err
+						}
+						go func() {
+							if err := newDNSForwarder().run(ctx, dnsAddrs); err != nil {
+								dnsForwarderErrorChan <- err
+							}
+						}()
+					}
+
 					// Wait on tailscaled process. It won't be cleaned up by default when the
 					// container exits as it is not PID1. TODO (irbekrm): perhaps we can replace the
 					// reaper by a running cmd.Wait in a goroutine immediately after starting
@@ -734,6 +792,8 @@ func run() error {
 			resetTimer(false)
 		case e := <-egressSvcsErrorChan:
 			return fmt.Errorf("egress proxy failed: %v", e)
+		case e := <-dnsForwarderErrorChan:
+			return fmt.Errorf("DNS forwarder failed: %v", e)
 		}
 	}
 	wg.Wait()
@@ -807,6 +867,48 @@ func contextWithExitSignalWatch() (context.Context, func()) {
 	return ctx, f
 }
 
+// drainForShutdown prepares this node to exit without dropping traffic. It
+// marks the node as not ready, so that Kubernetes stops sending new
+// connections to it, and withdraws any subnet routes that containerboot
+// manages, so that peers fail over to other subnet routers. It then waits
+// for cfg.ShutdownDrainPeriod while tailscaled keeps serving in-flight
+// connections.
+func drainForShutdown(client *local.Client, cfg *settings, ready *readiness) {
+	if ready != nil {
+		ready.startDraining()
+	}
+	if cfg.ShutdownDrainPeriod == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownDrainPeriod)
+	defer cancel()
+
+	log.Printf("Draining traffic for %v before shutting down", cfg.ShutdownDrainPeriod)
+	// Only withdraw routes that will be re-advertised on the next start,
+	// either from TS_ROUTES or from the tailscaled config file.
+	if cfg.Routes != nil || cfg.TailscaledConfigFilePath != "" {
+		if err := withdrawRoutes(ctx, client); err != nil {
+			log.Printf("Error withdrawing advertised routes: %v", err)
+		}
+	}
+	<-ctx.Done()
+}
+
+// withdrawRoutes stops advertising any subnet routes that this node
+// currently advertises.
+func withdrawRoutes(ctx context.Context, client *local.Client) error {
+	prefs, err := client.GetPrefs(ctx)
+	if err != nil {
+		return err
+	}
+	if len(prefs.AdvertiseRoutes) == 0 {
+		return nil
+	}
+	log.Printf("Withdrawing advertised routes %v", prefs.AdvertiseRoutes)
+	_, err = client.EditPrefs(ctx, &ipn.MaskedPrefs{AdvertiseRoutesSet: true})
+	return err
+}
+
 // tailscaledConfigFilePath returns the path to the tailscaled config file that
 // should be used for the current capability version. It is determined by the
 // TS_EXPERIMENTAL_VERSIONED_CONFIG_DIR environment variable and looks for a
@@ -31,6 +31,7 @@
 
 	"github.com/google/go-cmp/cmp"
 	"golang.org/x/sys/unix"
+	"tailscale.com/health"
 	"tailscale.com/ipn"
 	"tailscale.com/kube/egressservices"
 	"tailscale.com/kube/kubeclient"
@@ -123,6 +124,12 @@ func TestContainerBoot(t *testing.T) {
 	healthURL := func(port int) string {
 		return fmt.Sprintf("http://127.0.0.1:%d/healthz", port)
 	}
+	readyURL := func(port int) string {
+		return fmt.Sprintf("http://127.0.0.1:%d/readyz", port)
+	}
+	liveURL := func(port int) string {
+		return fmt.Sprintf("http://127.0.0.1:%d/livez", port)
+	}
 	egressSvcTerminateURL := func(port int) string {
 		return fmt.Sprintf("http://127.0.0.1:%d%s", port, kubetypes.EgessServicesPreshutdownEP)
 	}
@@ -789,16 +796,96 @@ type phase struct {
 					EndpointStatuses: map[string]int{
 						metricsURL(localAddrPort): -1,
 						healthURL(localAddrPort):  503, // Doesn't start passing until the next phase.
+						readyURL(localAddrPort):   503,
+						liveURL(localAddrPort):    200,
 					},
 				}, {
 					Notify: runningNotify,
 					EndpointStatuses: map[string]int{
 						metricsURL(localAddrPort): -1,
 						healthURL(localAddrPort):  200,
+						readyURL(localAddrPort):   200,
+						liveURL(localAddrPort):    200,
+					},
+				},
+			},
+		},
+		{
+			Name: "readiness_requires_healthy",
+			Env: map[string]string{
+				"TS_LOCAL_ADDR_PORT":                        fmt.Sprintf("[::]:%d", localAddrPort),
+				"TS_ENABLE_HEALTH_CHECK":                    "true",
+				"TS_EXPERIMENTAL_READINESS_REQUIRE_HEALTHY": "true",
+			},
+			Phases: []phase{
+				{
+					WantCmds: []string{
+						"/usr/bin/tailscaled --socket=/tmp/tailscaled.sock --state=mem: --statedir=/tmp --tun=userspace-networking",
+						"/usr/bin/tailscale --socket=/tmp/tailscaled.sock up --accept-dns=false",
+					},
+				}, {
+					Notify: &ipn.Notify{
+						State:  runningNotify.State,
+						NetMap: runningNotify.NetMap,
+						Health: &health.State{
+							Warnings: map[health.WarnableCode]health.UnhealthyState{
+								"no-derp-connection": {
+									WarnableCode:        "no-derp-connection",
+									Title:               "Relay server unavailable",
+									ImpactsConnectivity: true,
+								},
+							},
+						},
+					},
+					EndpointStatuses: map[string]int{
+						healthURL(localAddrPort): 200,
+						readyURL(localAddrPort):  503,
+					},
+				}, {
+					Notify: &ipn.Notify{
+						State:  runningNotify.State,
+						NetMap: runningNotify.NetMap,
+						Health: &health.State{},
+					},
+					EndpointStatuses: map[string]int{
+						healthURL(localAddrPort): 200,
+						readyURL(localAddrPort):  200,
 					},
 				},
 			},
 		},
+		{
+			Name: "graceful_shutdown",
+			Env: map[string]string{
+				"TS_LOCAL_ADDR_PORT":                    fmt.Sprintf("[::]:%d", localAddrPort),
+				"TS_ENABLE_HEALTH_CHECK":                "true",
+				"TS_EXPERIMENTAL_SHUTDOWN_DRAIN_PERIOD": "2s",
+			},
+			Phases: []phase{
+				{
+					WantCmds: []string{
+						"/usr/bin/tailscaled --socket=/tmp/tailscaled.sock --state=mem: --statedir=/tmp --tun=userspace-networking",
+						"/usr/bin/tailscale --socket=/tmp/tailscaled.sock up --accept-dns=false",
+					},
+				}, {
+					Notify: runningNotify,
+					EndpointStatuses: map[string]int{
+						readyURL(localAddrPort): 200,
+					},
+				}, {
+					// SIGTERM should make the node unready while
+					// tailscaled keeps running for the drain period.
+					Signal:  ptr.To(unix.SIGTERM),
+					WantLog: "Draining traffic for 2s before shutting down",
+					EndpointStatuses: map[string]int{
+						readyURL(localAddrPort): 503,
+						liveURL(localAddrPort):  200,
+					},
+				}, {
+					WantExitCode: ptr.To(0),
+				},
+			},
+		},
 		{
 			Name: "metrics_and_health_on_same_port",
 			Env: map[string]string{
@@ -1298,6 +1385,13 @@ func (l *localAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "GET" {
 			panic(fmt.Sprintf("unsupported method %q", r.Method))
 		}
+	case "/localapi/v0/status":
+		if r.Method != "GET" {
+			panic(fmt.Sprintf("unsupported method %q", r.Method))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+		return
 	case "/localapi/v0/usermetrics":
 		if r.Method != "GET" {
 			panic(fmt.Sprintf("unsupported method %q", r.Method))
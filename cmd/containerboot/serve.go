@@ -14,9 +14,7 @@
 	"path/filepath"
 	"reflect"
 	"sync/atomic"
-	"time"
 
-	"github.com/fsnotify/fsnotify"
 	"tailscale.com/client/local"
 	"tailscale.com/ipn"
 	"tailscale.com/kube/kubetypes"
@@ -28,23 +26,17 @@
 // applies it to lc. It exits when ctx is canceled. cdChanged is a channel that
 // is written to when the certDomain changes, causing the serve config to be
 // re-read and applied.
+//
+// If an updated serve config can't be read or parsed, for example because a
+// user has mounted a malformed ConfigMap, the previously applied config is
+// left in place until the file is fixed.
 func watchServeConfigChanges(ctx context.Context, path string, cdChanged <-chan bool, certDomainAtomic *atomic.Pointer[string], lc *local.Client, kc *kubeClient) {
 	if certDomainAtomic == nil {
 		panic("certDomainAtomic must not be nil")
 	}
-	var tickChan <-chan time.Time
-	var eventChan <-chan fsnotify.Event
-	if w, err := fsnotify.NewWatcher(); err != nil {
-		log.Printf("serve proxy: failed to create fsnotify watcher, timer-only mode: %v", err)
-		ticker := time.NewTicker(5 * time.Second)
-		defer ticker.Stop()
-		tickChan = ticker.C
-	} else {
-		defer w.Close()
-		if err := w.Add(filepath.Dir(path)); err != nil {
-			log.Fatalf("serve proxy: failed to add fsnotify watch: %v", err)
-		}
-		eventChan = w.Events
+	changed, err := watchConfigDir(ctx, filepath.Dir(path))
+	if err != nil {
+		log.Fatalf("serve proxy: %v", err)
 	}
 
 	var certDomain string
@@ -55,15 +47,15 @@ func watchServeConfigChanges(ctx context.Context, path string, cdChanged <-chan
 			return
 		case <-cdChanged:
 			certDomain = *certDomainAtomic.Load()
-		case <-tickChan:
-		case <-eventChan:
-			// We can't do any reasonable filtering on the event because of how
-			// k8s handles these mounts. So just re-read the file and apply it
-			// if it's changed.
+		case <-changed:
 		}
 		sc, err := readServeConfig(path, certDomain)
 		if err != nil {
-			log.Fatalf("serve proxy: failed to read serve config: %v", err)
+			if prevServeConfig == nil {
+				log.Fatalf("serve proxy: failed to read serve config: %v", err)
+			}
+			log.Printf("serve proxy: failed to read updated serve config, keeping previous config: %v", err)
+			continue
 		}
 		if sc == nil {
 			log.Printf("serve proxy: no serve config at %q, skipping", path)
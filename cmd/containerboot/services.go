@@ -20,7 +20,6 @@
 	"strings"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
 	"tailscale.com/client/local"
 	"tailscale.com/ipn"
 	"tailscale.com/kube/egressservices"
@@ -86,21 +85,9 @@ type httpClient interface {
 // - tailnet IPs have changed for any backend targets specified by tailnet FQDN
 func (ep *egressProxy) run(ctx context.Context, n ipn.Notify, opts egressProxyRunOpts) error {
 	ep.configure(opts)
-	var tickChan <-chan time.Time
-	var eventChan <-chan fsnotify.Event
-	// TODO (irbekrm): take a look if this can be pulled into a single func
-	// shared with serve config loader.
-	if w, err := fsnotify.NewWatcher(); err != nil {
-		log.Printf("failed to create fsnotify watcher, timer-only mode: %v", err)
-		ticker := time.NewTicker(5 * time.Second)
-		defer ticker.Stop()
-		tickChan = ticker.C
-	} else {
-		defer w.Close()
-		if err := w.Add(ep.cfgPath); err != nil {
-			return fmt.Errorf("failed to add fsnotify watch: %w", err)
-		}
-		eventChan = w.Events
+	changed, err := watchConfigDir(ctx, ep.cfgPath)
+	if err != nil {
+		return err
 	}
 
 	if err := ep.sync(ctx, n); err != nil {
@@ -110,9 +97,7 @@ func (ep *egressProxy) run(ctx context.Context, n ipn.Notify, opts egressProxyRu
 		select {
 		case <-ctx.Done():
 			return nil
-		case <-tickChan:
-			log.Printf("periodic sync, ensuring firewall config is up to date...")
-		case <-eventChan:
+		case <-changed:
 			log.Printf("config file change detected, ensuring firewall config is up to date...")
 		case n = <-ep.netmapChan:
 			shouldResync := ep.shouldResync(n)
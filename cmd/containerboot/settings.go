@@ -15,6 +15,7 @@
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"tailscale.com/ipn/conffile"
 	"tailscale.com/kube/kubeclient"
@@ -74,6 +75,16 @@ type settings struct {
 	HealthCheckEnabled   bool
 	DebugAddrPort        string
 	EgressProxiesCfgPath string
+	// DNSForwarderPort, if set, is the port on the Pod's IP addresses on
+	// which to serve DNS by forwarding queries to the MagicDNS resolver.
+	DNSForwarderPort string
+	// ReadinessRequireHealthy, if set, makes the readiness check fail while
+	// tailscaled reports health warnings that impact connectivity.
+	ReadinessRequireHealthy bool
+	// ShutdownDrainPeriod is how long to keep tailscaled running after
+	// receiving SIGTERM, with the readiness check failing, so that
+	// traffic can move off this node before it exits.
+	ShutdownDrainPeriod time.Duration
 }
 
 func configFromEnv() (*settings, error) {
@@ -109,6 +120,15 @@ func configFromEnv() (*settings, error) {
 		DebugAddrPort:                         defaultEnv("TS_DEBUG_ADDR_PORT", ""),
 		EgressProxiesCfgPath:                  defaultEnv("TS_EGRESS_PROXIES_CONFIG_PATH", ""),
 		PodUID:                                defaultEnv("POD_UID", ""),
+		DNSForwarderPort:                      defaultEnv("TS_EXPERIMENTAL_DNS_FORWARDER_PORT", ""),
+		ReadinessRequireHealthy:               defaultBool("TS_EXPERIMENTAL_READINESS_REQUIRE_HEALTHY", false),
+	}
+	if v := os.Getenv("TS_EXPERIMENTAL_SHUTDOWN_DRAIN_PERIOD"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing TS_EXPERIMENTAL_SHUTDOWN_DRAIN_PERIOD value %q: %w", v, err)
+		}
+		cfg.ShutdownDrainPeriod = d
 	}
 	podIPs, ok := os.LookupEnv("POD_IPS")
 	if ok {
@@ -202,6 +222,20 @@ func (s *settings) validate() error {
 	if s.EgressProxiesCfgPath != "" && !(s.InKubernetes && s.KubeSecret != "") {
 		return errors.New("TS_EGRESS_PROXIES_CONFIG_PATH is only supported for Tailscale running on Kubernetes")
 	}
+	if s.DNSForwarderPort != "" {
+		if s.UserspaceMode {
+			return errors.New("TS_EXPERIMENTAL_DNS_FORWARDER_PORT is not supported in userspace mode")
+		}
+		if _, err := dnsForwarderAddrs(s); err != nil {
+			return err
+		}
+	}
+	if s.ReadinessRequireHealthy && !s.localHealthEnabled() {
+		return errors.New("TS_EXPERIMENTAL_READINESS_REQUIRE_HEALTHY requires TS_ENABLE_HEALTH_CHECK")
+	}
+	if s.ShutdownDrainPeriod < 0 {
+		return fmt.Errorf("TS_EXPERIMENTAL_SHUTDOWN_DRAIN_PERIOD must not be negative, got %v", s.ShutdownDrainPeriod)
+	}
 	return nil
 }
 
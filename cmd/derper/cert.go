@@ -48,6 +48,8 @@ func certProviderByCertMode(mode, dir, hostname string) (certProvider, error) {
 		return certManager, nil
 	case "manual":
 		return NewManualCertManager(dir, hostname)
+	case "dns":
+		return newDNSCertManager(dir, hostname, *acmeDNSProvider, *acmeDirectoryURL)
 	default:
 		return nil, fmt.Errorf("unsupport cert mode: %q", mode)
 	}
@@ -0,0 +1,362 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"tailscale.com/atomicfile"
+	"tailscale.com/version"
+)
+
+// dnsProvider publishes the TXT records with which an ACME server checks
+// DNS-01 challenges.
+type dnsProvider interface {
+	// Present adds a TXT record at fqdn with value.
+	Present(ctx context.Context, fqdn, value string) error
+	// CleanUp removes the TXT record that Present added.
+	CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+// dnsProviderByName returns the DNS provider named name, configured from
+// the environment.
+func dnsProviderByName(name string) (dnsProvider, error) {
+	switch name {
+	case "cloudflare":
+		return newCloudflareProvider(os.Getenv("CLOUDFLARE_DNS_API_TOKEN"), os.Getenv("CLOUDFLARE_ZONE_ID"))
+	case "route53":
+		return newRoute53Provider(os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN"), os.Getenv("AWS_HOSTED_ZONE_ID"))
+	case "rfc2136":
+		return newRFC2136Provider(os.Getenv("RFC2136_NAMESERVER"), os.Getenv("RFC2136_ZONE"), os.Getenv("RFC2136_TSIG_KEY"), os.Getenv("RFC2136_TSIG_ALGORITHM"), os.Getenv("RFC2136_TSIG_SECRET"))
+	case "":
+		return nil, errors.New("missing required --acme-dns-provider flag")
+	default:
+		return nil, fmt.Errorf("unknown ACME DNS provider %q; want cloudflare, route53 or rfc2136", name)
+	}
+}
+
+const (
+	// dnsCertRenewBefore is how long before its expiry a certificate is
+	// renewed.
+	dnsCertRenewBefore = 30 * 24 * time.Hour
+
+	// dnsCertCheckInterval is how often the certificate is checked for
+	// renewal, and renewal retried after it failed.
+	dnsCertCheckInterval = 12 * time.Hour
+
+	// dnsPropagationTimeout is how long to wait for a challenge record to
+	// be visible in DNS before asking the ACME server to check it anyway.
+	dnsPropagationTimeout = 2 * time.Minute
+)
+
+// dnsCertManager is a certProvider that gets and renews a certificate from
+// an ACME server, such as LetsEncrypt, by completing DNS-01 challenges
+// with a dnsProvider. Unlike autocert's HTTP-01 and TLS-ALPN-01 challenges,
+// they don't need the ACME server to reach derper, so they work behind
+// restrictive firewalls and on hosts serving only port 443.
+type dnsCertManager struct {
+	hostname string
+	dir      string
+	provider dnsProvider
+	client   *acme.Client
+
+	cert atomic.Pointer[tls.Certificate] // with Leaf set; or nil before the first is obtained
+}
+
+// newDNSCertManager returns a dnsCertManager for hostname, which keeps its
+// ACME account key and the certificate in dir. It obtains a certificate, if
+// dir doesn't have a current one, before it returns, and renews it in the
+// background.
+func newDNSCertManager(dir, hostname, providerName, directoryURL string) (certProvider, error) {
+	provider, err := dnsProviderByName(providerName)
+	if err != nil {
+		return nil, err
+	}
+	key, err := acmeAccountKey(filepath.Join(dir, "acme_account.key"))
+	if err != nil {
+		return nil, err
+	}
+	m := &dnsCertManager{
+		hostname: hostname,
+		dir:      dir,
+		provider: provider,
+		client: &acme.Client{
+			Key:          key,
+			UserAgent:    "derper/" + version.Long(),
+			DirectoryURL: directoryURL,
+		},
+	}
+	if err := m.load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("derper: ignoring stored certificate: %v", err)
+	}
+	if m.needsRenewal(time.Now()) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		err := m.renew(ctx)
+		cancel()
+		if err != nil {
+			if m.cert.Load() == nil {
+				return nil, fmt.Errorf("obtaining certificate for %q: %w", hostname, err)
+			}
+			log.Printf("derper: renewing certificate failed: %v", err)
+		}
+	}
+	go m.renewLoop()
+	return m, nil
+}
+
+func (m *dnsCertManager) certPaths() (crtPath, keyPath string) {
+	keyname := unsafeHostnameCharacters.ReplaceAllString(m.hostname, "")
+	return filepath.Join(m.dir, keyname+".crt"), filepath.Join(m.dir, keyname+".key")
+}
+
+// load loads the certificate that an earlier run stored.
+func (m *dnsCertManager) load() error {
+	crtPath, keyPath := m.certPaths()
+	cert, err := tls.LoadX509KeyPair(crtPath, keyPath)
+	if err != nil {
+		return err
+	}
+	if err := cert.Leaf.VerifyHostname(m.hostname); err != nil {
+		return err
+	}
+	m.cert.Store(&cert)
+	return nil
+}
+
+// needsRenewal reports whether, at now, there's no certificate or the
+// current one expires within dnsCertRenewBefore.
+func (m *dnsCertManager) needsRenewal(now time.Time) bool {
+	cert := m.cert.Load()
+	return cert == nil || now.Add(dnsCertRenewBefore).After(cert.Leaf.NotAfter)
+}
+
+func (m *dnsCertManager) renewLoop() {
+	for {
+		time.Sleep(dnsCertCheckInterval)
+		if !m.needsRenewal(time.Now()) {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		if err := m.renew(ctx); err != nil {
+			log.Printf("derper: renewing certificate failed: %v", err)
+		}
+		cancel()
+	}
+}
+
+// renew gets a new certificate from the ACME server, stores it and starts
+// serving it.
+func (m *dnsCertManager) renew(ctx context.Context) error {
+	if _, err := m.client.Register(ctx, new(acme.Account), acme.AcceptTOS); err != nil && err != acme.ErrAccountAlreadyExists {
+		return fmt.Errorf("acme.Register: %w", err)
+	}
+	order, err := m.client.AuthorizeOrder(ctx, acme.DomainIDs(m.hostname))
+	if err != nil {
+		return fmt.Errorf("acme.AuthorizeOrder: %w", err)
+	}
+	for _, aurl := range order.AuthzURLs {
+		if err := m.authorize(ctx, aurl); err != nil {
+			return err
+		}
+	}
+	order, err = m.client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return fmt.Errorf("acme.WaitOrder: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.hostname},
+		DNSNames: []string{m.hostname},
+	}, key)
+	if err != nil {
+		return err
+	}
+	der, _, err := m.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("acme.CreateOrderCert: %w", err)
+	}
+
+	var certPEM, keyPEM bytes.Buffer
+	for _, b := range der {
+		if err := pem.Encode(&certPEM, &pem.Block{Type: "CERTIFICATE", Bytes: b}); err != nil {
+			return err
+		}
+	}
+	if err := encodeECDSAKey(&keyPEM, key); err != nil {
+		return err
+	}
+	cert, err := tls.X509KeyPair(certPEM.Bytes(), keyPEM.Bytes())
+	if err != nil {
+		return err
+	}
+	crtPath, keyPath := m.certPaths()
+	if err := atomicfile.WriteFile(keyPath, keyPEM.Bytes(), 0600); err != nil {
+		return err
+	}
+	if err := atomicfile.WriteFile(crtPath, certPEM.Bytes(), 0644); err != nil {
+		return err
+	}
+	m.cert.Store(&cert)
+	log.Printf("derper: got certificate for %q, valid until %v", m.hostname, cert.Leaf.NotAfter)
+	return nil
+}
+
+// authorize completes the DNS-01 challenge of the authorization at aurl, if
+// it's not already valid.
+func (m *dnsCertManager) authorize(ctx context.Context, aurl string) error {
+	az, err := m.client.GetAuthorization(ctx, aurl)
+	if err != nil {
+		return fmt.Errorf("acme.GetAuthorization: %w", err)
+	}
+	if az.Status == acme.StatusValid {
+		return nil
+	}
+	i := slices.IndexFunc(az.Challenges, func(ch *acme.Challenge) bool { return ch.Type == "dns-01" })
+	if i < 0 {
+		return fmt.Errorf("no dns-01 challenge for %q", az.Identifier.Value)
+	}
+	ch := az.Challenges[i]
+	value, err := m.client.DNS01ChallengeRecord(ch.Token)
+	if err != nil {
+		return err
+	}
+	fqdn := "_acme-challenge." + az.Identifier.Value
+	if err := m.provider.Present(ctx, fqdn, value); err != nil {
+		return fmt.Errorf("adding TXT record %q: %w", fqdn, err)
+	}
+	defer func() {
+		// Clean up even if ctx is done.
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := m.provider.CleanUp(ctx, fqdn, value); err != nil {
+			log.Printf("derper: removing TXT record %q: %v", fqdn, err)
+		}
+	}()
+	waitTXT(ctx, fqdn, value)
+
+	if _, err := m.client.Accept(ctx, ch); err != nil {
+		return fmt.Errorf("acme.Accept: %w", err)
+	}
+	if _, err := m.client.WaitAuthorization(ctx, az.URI); err != nil {
+		return fmt.Errorf("acme.WaitAuthorization: %w", err)
+	}
+	return nil
+}
+
+// waitTXT waits, for up to dnsPropagationTimeout, for the system resolver
+// to see the TXT record at fqdn with value. It's best effort: the ACME
+// server asks the authoritative servers, which may have the record before
+// the resolver sees it.
+func waitTXT(ctx context.Context, fqdn, value string) {
+	ctx, cancel := context.WithTimeout(ctx, dnsPropagationTimeout)
+	defer cancel()
+	for {
+		txts, _ := net.DefaultResolver.LookupTXT(ctx, fqdn)
+		if slices.Contains(txts, value) {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("derper: TXT record %q not visible yet; asking the ACME server to check it anyway", fqdn)
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (m *dnsCertManager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		NextProtos: []string{
+			"http/1.1",
+		},
+		GetCertificate: m.getCertificate,
+	}
+}
+
+func (m *dnsCertManager) getCertificate(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if hi.ServerName != m.hostname {
+		return nil, fmt.Errorf("cert mismatch with hostname: %q", hi.ServerName)
+	}
+	cert := m.cert.Load()
+	if cert == nil {
+		return nil, fmt.Errorf("no certificate for %q yet", m.hostname)
+	}
+
+	// Return a shallow copy of the cert so the caller can append to its
+	// Certificate field.
+	certCopy := new(tls.Certificate)
+	*certCopy = *cert
+	certCopy.Certificate = certCopy.Certificate[:len(certCopy.Certificate):len(certCopy.Certificate)]
+	return certCopy, nil
+}
+
+// HTTPHandler implements certProvider. DNS-01 challenges need no HTTP
+// handler.
+func (m *dnsCertManager) HTTPHandler(fallback http.Handler) http.Handler {
+	return fallback
+}
+
+// acmeAccountKey returns the ACME account key stored in path, creating it
+// if it doesn't exist.
+func acmeAccountKey(path string) (crypto.Signer, error) {
+	b, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(b)
+		if block == nil || block.Type != "EC PRIVATE KEY" {
+			return nil, fmt.Errorf("invalid ACME account key in %s", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := encodeECDSAKey(&buf, key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := atomicfile.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func encodeECDSAKey(w *bytes.Buffer, key *ecdsa.PrivateKey) error {
+	b, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return pem.Encode(w, &pem.Block{Type: "EC PRIVATE KEY", Bytes: b})
+}
@@ -0,0 +1,330 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// TestSignV4 checks signV4 against the example in AWS's Signature Version 4
+// documentation.
+func TestSignV4(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	signV4(req, nil, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "iam", now)
+
+	const want = "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %q; want %q", got, want)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("X-Amz-Date = %q", got)
+	}
+}
+
+func TestRoute53Provider(t *testing.T) {
+	var changes []route53Change
+	polls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			t.Errorf("%s %s: missing signature", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("X-Amz-Security-Token"); got != "token" {
+			t.Errorf("X-Amz-Security-Token = %q", got)
+		}
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/2013-04-01/hostedzone/Z123/rrset":
+			var cr route53ChangeRequest
+			if err := xml.NewDecoder(r.Body).Decode(&cr); err != nil {
+				t.Error(err)
+			}
+			changes = append(changes, cr.Changes...)
+			io.WriteString(w, `<ChangeResourceRecordSetsResponse><ChangeInfo><Id>/change/C1</Id><Status>PENDING</Status></ChangeInfo></ChangeResourceRecordSetsResponse>`)
+		case r.Method == "GET" && r.URL.Path == "/2013-04-01/change/C1":
+			polls++
+			io.WriteString(w, `<GetChangeResponse><ChangeInfo><Id>/change/C1</Id><Status>INSYNC</Status></ChangeInfo></GetChangeResponse>`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `<ErrorResponse><Error><Code>InvalidInput</Code><Message>bad request</Message></Error></ErrorResponse>`)
+		}
+	}))
+	defer ts.Close()
+
+	p, err := newRoute53Provider("AKID", "secret", "token", "/hostedzone/Z123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.base = ts.URL
+	p.pollInterval = time.Millisecond
+
+	ctx := context.Background()
+	const fqdn, value = "_acme-challenge.derp.example.com", "v4lue"
+	if err := p.Present(ctx, fqdn, value); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CleanUp(ctx, fqdn, value); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0].Action != "UPSERT" || changes[1].Action != "DELETE" {
+		t.Fatalf("changes = %+v; want UPSERT then DELETE", changes)
+	}
+	if c := changes[0]; c.Name != fqdn || c.Type != "TXT" || len(c.Values) != 1 || c.Values[0] != `"v4lue"` {
+		t.Errorf("change = %+v", c)
+	}
+	if polls != 2 {
+		t.Errorf("polled %d times; want 2", polls)
+	}
+
+	p.zoneID = "other"
+	if err := p.Present(ctx, fqdn, value); err == nil || !strings.Contains(err.Error(), "InvalidInput") {
+		t.Errorf("Present to unknown zone: err = %v; want InvalidInput", err)
+	}
+}
+
+func TestCloudflareProvider(t *testing.T) {
+	records := map[string]string{} // ID => name
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer tok" {
+			t.Errorf("Authorization = %q", got)
+		}
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/zones":
+			if r.URL.Query().Get("name") == "example.com" {
+				io.WriteString(w, `{"success":true,"result":[{"id":"zone1"}]}`)
+			} else {
+				io.WriteString(w, `{"success":true,"result":[]}`)
+			}
+		case r.Method == "POST" && r.URL.Path == "/zones/zone1/dns_records":
+			var rec struct {
+				Type, Name, Content string
+			}
+			if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+				t.Error(err)
+			}
+			if rec.Type != "TXT" || rec.Content != "v4lue" {
+				t.Errorf("record = %+v", rec)
+			}
+			records["rec1"] = rec.Name
+			io.WriteString(w, `{"success":true,"result":{"id":"rec1"}}`)
+		case r.Method == "DELETE" && r.URL.Path == "/zones/zone1/dns_records/rec1":
+			delete(records, "rec1")
+			io.WriteString(w, `{"success":true,"result":{"id":"rec1"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"success":false,"errors":[{"code":7003,"message":"no route"}]}`)
+		}
+	}))
+	defer ts.Close()
+
+	p, err := newCloudflareProvider("tok", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.base = ts.URL
+
+	ctx := context.Background()
+	const fqdn, value = "_acme-challenge.derp.example.com", "v4lue"
+	if err := p.Present(ctx, fqdn, value); err != nil {
+		t.Fatal(err)
+	}
+	if got := records["rec1"]; got != fqdn {
+		t.Errorf("record name = %q; want %q", got, fqdn)
+	}
+	if err := p.CleanUp(ctx, fqdn, value); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Errorf("records left after CleanUp: %v", records)
+	}
+
+	if err := p.Present(ctx, "_acme-challenge.derp.example.net", value); err == nil {
+		t.Error("Present in unknown zone succeeded")
+	}
+}
+
+func TestRFC2136Provider(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	updates := make(chan rfc2136Update, 2)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var lb [2]byte
+			io.ReadFull(c, lb[:])
+			msg := make([]byte, binary.BigEndian.Uint16(lb[:]))
+			io.ReadFull(c, msg)
+			u, h, err := checkUpdate(msg, secret)
+			if err != nil {
+				t.Error(err)
+			} else {
+				updates <- u
+			}
+			h.Response = true
+			b := dnsmessage.NewBuilder(nil, h)
+			res, _ := b.Finish()
+			c.Write(binary.BigEndian.AppendUint16(nil, uint16(len(res))))
+			c.Write(res)
+			c.Close()
+		}
+	}()
+
+	p, err := newRFC2136Provider(ln.Addr().String(), "example.com", "derper-key", "", base64.StdEncoding.EncodeToString(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	const fqdn, value = "_acme-challenge.derp.example.com", "v4lue"
+	if err := p.Present(ctx, fqdn, value); err != nil {
+		t.Fatal(err)
+	}
+	if u := <-updates; u != (rfc2136Update{dnsmessage.ClassINET, value}) {
+		t.Errorf("Present sent %+v", u)
+	}
+	if err := p.CleanUp(ctx, fqdn, value); err != nil {
+		t.Fatal(err)
+	}
+	if u := <-updates; u != (rfc2136Update{dnsClassNone, value}) {
+		t.Errorf("CleanUp sent %+v", u)
+	}
+}
+
+// rfc2136Update is the TXT record update in a DNS UPDATE message.
+type rfc2136Update struct {
+	class dnsmessage.Class // ClassINET to add, or dnsClassNone to delete
+	txt   string
+}
+
+// checkUpdate parses the DNS UPDATE message msg, verifies its hmac-sha256
+// TSIG with secret, and returns its update and header.
+func checkUpdate(msg, secret []byte) (u rfc2136Update, h dnsmessage.Header, err error) {
+	var p dnsmessage.Parser
+	if h, err = p.Start(msg); err != nil {
+		return u, h, err
+	}
+	if h.OpCode != dnsOpcodeUpdate {
+		return u, h, fmt.Errorf("opcode = %v; want UPDATE", h.OpCode)
+	}
+	q, err := p.Question()
+	if err != nil {
+		return u, h, err
+	}
+	if q.Name.String() != "example.com." || q.Type != dnsmessage.TypeSOA {
+		return u, h, fmt.Errorf("zone = %v", q)
+	}
+	p.SkipAllQuestions()
+	p.SkipAllAnswers()
+	rh, err := p.AuthorityHeader()
+	if err != nil {
+		return u, h, err
+	}
+	txt, err := p.TXTResource()
+	if err != nil {
+		return u, h, err
+	}
+	if rh.Name.String() != "_acme-challenge.derp.example.com." || len(txt.TXT) != 1 {
+		return u, h, fmt.Errorf("update = %v %v", rh, txt)
+	}
+	u.class, u.txt = rh.Class, txt.TXT[0]
+	p.SkipAllAuthorities()
+	th, err := p.AdditionalHeader()
+	if err != nil {
+		return u, h, err
+	}
+	if th.Type != dnsTypeTSIG || th.Name.String() != "derper-key." {
+		return u, h, fmt.Errorf("additional record = %v; want TSIG", th)
+	}
+	tsig, err := p.UnknownResource()
+	if err != nil {
+		return u, h, err
+	}
+
+	// The TSIG rdata is the algorithm name, time signed (6 bytes), fudge
+	// (2), MAC size (2), MAC, original ID (2), error (2) and other len (2).
+	rdata := tsig.Data
+	alg := []byte("\x0bhmac-sha256\x00")
+	if !strings.HasPrefix(string(rdata), string(alg)) {
+		return u, h, fmt.Errorf("TSIG algorithm in %x; want hmac-sha256", rdata)
+	}
+	vars := rdata[len(alg):]
+	macLen := int(binary.BigEndian.Uint16(vars[8:]))
+	gotMAC := vars[10 : 10+macLen]
+
+	unsigned := append([]byte(nil), msg[:len(msg)-(len("\x0aderper-key\x00")+10+len(rdata))]...)
+	binary.BigEndian.PutUint16(unsigned[10:], binary.BigEndian.Uint16(unsigned[10:])-1)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(unsigned)
+	mac.Write([]byte("\x0aderper-key\x00"))
+	mac.Write([]byte{0, 255, 0, 0, 0, 0})
+	mac.Write(alg)
+	mac.Write(vars[:8])           // time signed, fudge
+	mac.Write([]byte{0, 0, 0, 0}) // error, other len
+	if !hmac.Equal(gotMAC, mac.Sum(nil)) {
+		return u, h, fmt.Errorf("TSIG MAC mismatch")
+	}
+	return u, h, nil
+}
+
+func TestDNSCertManagerNeedsRenewal(t *testing.T) {
+	m := &dnsCertManager{hostname: "derp.example.com"}
+	now := time.Now()
+	if !m.needsRenewal(now) {
+		t.Error("needsRenewal with no cert = false")
+	}
+	m.cert.Store(&tls.Certificate{Leaf: &x509.Certificate{NotAfter: now.Add(60 * 24 * time.Hour)}})
+	if m.needsRenewal(now) {
+		t.Error("needsRenewal 60 days before expiry = true")
+	}
+	if !m.needsRenewal(now.Add(40 * 24 * time.Hour)) {
+		t.Error("needsRenewal 20 days before expiry = false")
+	}
+}
+
+func TestDNSCertManagerGetCertificate(t *testing.T) {
+	m := &dnsCertManager{hostname: "derp.example.com"}
+	hi := &tls.ClientHelloInfo{ServerName: "derp.example.com"}
+	if c, err := m.getCertificate(hi); err == nil {
+		t.Fatalf("getCertificate with no cert = %v, want error", c)
+	}
+	m.cert.Store(&tls.Certificate{Certificate: [][]byte{{1}}})
+	c, err := m.getCertificate(hi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Certificate) != 1 {
+		t.Errorf("got %d certificates, want 1", len(c.Certificate))
+	}
+	if _, err := m.getCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"}); err == nil {
+		t.Error("getCertificate for other hostname succeeded")
+	}
+}
@@ -38,6 +38,7 @@
 	"time"
 
 	"github.com/tailscale/setec/client/setec"
+	"golang.org/x/crypto/acme"
 	"golang.org/x/time/rate"
 	"tailscale.com/atomicfile"
 	"tailscale.com/derp"
@@ -54,16 +55,19 @@
 var (
 	dev         = flag.Bool("dev", false, "run in localhost development mode (overrides -a)")
 	versionFlag = flag.Bool("version", false, "print version and exit")
-	addr        = flag.String("a", ":443", "server HTTP/HTTPS listen address, in form \":port\", \"ip:port\", or for IPv6 \"[ip]:port\". If the IP is omitted, it defaults to all interfaces. Serves HTTPS if the port is 443 and/or -certmode is manual, otherwise HTTP.")
+	addr        = flag.String("a", ":443", "server HTTP/HTTPS listen address, in form \":port\", \"ip:port\", or for IPv6 \"[ip]:port\". If the IP is omitted, it defaults to all interfaces. Serves HTTPS if the port is 443 and/or -certmode is manual or dns, otherwise HTTP.")
 	httpPort    = flag.Int("http-port", 80, "The port on which to serve HTTP. Set to -1 to disable. The listener is bound to the same IP (if any) as specified in the -a flag.")
 	stunPort    = flag.Int("stun-port", 3478, "The UDP port on which to serve STUN. The listener is bound to the same IP (if any) as specified in the -a flag.")
 	configPath  = flag.String("c", "", "config file path")
-	certMode    = flag.String("certmode", "letsencrypt", "mode for getting a cert. possible options: manual, letsencrypt")
+	certMode    = flag.String("certmode", "letsencrypt", "mode for getting a cert. possible options: manual, letsencrypt, dns")
 	certDir     = flag.String("certdir", tsweb.DefaultCertDir("derper-certs"), "directory to store LetsEncrypt certs, if addr's port is :443")
 	hostname    = flag.String("hostname", "derp.tailscale.com", "LetsEncrypt host name, if addr's port is :443. When --certmode=manual, this can be an IP address to avoid SNI checks")
 	runSTUN     = flag.Bool("stun", true, "whether to run a STUN server. It will bind to the same IP (if any) as the --addr flag value.")
 	runDERP     = flag.Bool("derp", true, "whether to run a DERP server. The only reason to set this false is if you're decommissioning a server but want to keep its bootstrap DNS functionality still running.")
 
+	acmeDNSProvider  = flag.String("acme-dns-provider", "", "when --certmode=dns, the DNS provider to complete ACME DNS-01 challenges with: cloudflare, route53 or rfc2136, configured with environment variables; see README.md")
+	acmeDirectoryURL = flag.String("acme-directory-url", acme.LetsEncryptURL, "when --certmode=dns, the ACME server's directory URL")
+
 	meshPSKFile     = flag.String("mesh-psk-file", defaultMeshPSKFile(), "if non-empty, path to file containing the mesh pre-shared key file. It should contain some hex string; whitespace is trimmed.")
 	meshWith        = flag.String("mesh-with", "", "optional comma-separated list of hostnames to mesh with; the server's own hostname can be in the list. If an entry contains a slash, the second part names a hostname to be used when dialing the target.")
 	secretsURL      = flag.String("secrets-url", "", "SETEC server URL for secrets retrieval of mesh key")
@@ -75,6 +79,12 @@
 	verifyClientURL = flag.String("verify-client-url", "", "if non-empty, an admission controller URL for permitting client connections; see tailcfg.DERPAdmitClientRequest")
 	verifyFailOpen  = flag.Bool("verify-client-url-fail-open", true, "whether we fail open if --verify-client-url is unreachable")
 
+	clientRateBytes        = flag.Int("client-rate-limit-bytes", 0, "if non-zero, how many bytes per second each client may send through the server; packets over the limit are dropped. Mesh peers aren't limited. See also /debug/ratelimit to change limits at runtime")
+	clientRateBytesBurst   = flag.Int("client-rate-limit-bytes-burst", 0, "how many bytes a client may send at once above --client-rate-limit-bytes; if zero, one second's worth")
+	clientRatePackets      = flag.Int("client-rate-limit-packets", 0, "if non-zero, how many packets per second each client may send through the server; packets over the limit are dropped")
+	clientRatePacketsBurst = flag.Int("client-rate-limit-packets-burst", 0, "how many packets a client may send at once above --client-rate-limit-packets; if zero, one second's worth")
+	clientRateOverrides    = flag.String("client-rate-limit-overrides", "", "optional path to a JSON file of per-client rate limits overriding the --client-rate-limit-* flags: an object from node keys (\"nodekey:...\") to objects with BytesPerSecond, BytesBurst, PacketsPerSecond and PacketsBurst fields, where all zero exempts the client")
+
 	acceptConnLimit = flag.Float64("accept-connection-limit", math.Inf(+1), "rate limit for accepting new connection")
 	acceptConnBurst = flag.Int("accept-connection-burst", math.MaxInt, "burst limit for accepting new connection")
 
@@ -188,13 +198,16 @@ func main() {
 
 	cfg := loadConfig()
 
-	serveTLS := tsweb.IsProd443(*addr) || *certMode == "manual"
+	serveTLS := tsweb.IsProd443(*addr) || *certMode == "manual" || *certMode == "dns"
 
 	s := derp.NewServer(cfg.PrivateKey, log.Printf)
 	s.SetVerifyClient(*verifyClients)
 	s.SetVerifyClientURL(*verifyClientURL)
 	s.SetVerifyClientURLFailOpen(*verifyFailOpen)
 	s.SetTCPWriteTimeout(*tcpWriteTimeout)
+	if err := setRateLimits(s); err != nil {
+		log.Fatalf("setting client rate limits: %v", err)
+	}
 
 	var meshKey string
 	if *dev {
@@ -301,6 +314,7 @@ func main() {
 		}
 	}))
 	debug.Handle("traffic", "Traffic check", http.HandlerFunc(s.ServeDebugTraffic))
+	debug.Handle("ratelimit", "Per-client rate limits", serveDebugRateLimits(s))
 	debug.Handle("set-mutex-profile-fraction", "SetMutexProfileFraction", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		s := r.FormValue("rate")
 		if s == "" || r.Header.Get("Sec-Debug") != "derp" {
@@ -6,13 +6,21 @@
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"tailscale.com/derp"
 	"tailscale.com/derp/derphttp"
 	"tailscale.com/tstest/deptest"
+	"tailscale.com/types/key"
 )
 
 func TestProdAutocertHostPolicy(t *testing.T) {
@@ -181,3 +189,76 @@ func TestCheckMeshKey(t *testing.T) {
 	}
 
 }
+
+func TestDebugRateLimits(t *testing.T) {
+	s := derp.NewServer(key.NewNode(), t.Logf)
+	defer s.Close()
+	h := serveDebugRateLimits(s)
+	nodeKey := key.NewNode().Public()
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/debug/ratelimit", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Sec-Debug", "derp")
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec
+	}
+
+	if rec := post(url.Values{"bytes": {"1000"}}); rec.Code != http.StatusOK {
+		t.Fatalf("setting default: %v %s", rec.Code, rec.Body)
+	}
+	if rec := post(url.Values{"key": {nodeKey.String()}, "packets": {"10"}, "packets-burst": {"20"}}); rec.Code != http.StatusOK {
+		t.Fatalf("setting client limit: %v %s", rec.Code, rec.Body)
+	}
+	def, clients := s.RateLimits()
+	if want := (derp.RateLimit{BytesPerSecond: 1000}); def != want {
+		t.Errorf("default = %+v; want %+v", def, want)
+	}
+	if want := (derp.RateLimit{PacketsPerSecond: 10, PacketsBurst: 20}); clients[nodeKey] != want {
+		t.Errorf("client limit = %+v; want %+v", clients[nodeKey], want)
+	}
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest("GET", "/debug/ratelimit", nil))
+	var got rateLimitsJSON
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Default != def || len(got.Clients) != 1 || got.Clients[nodeKey] != clients[nodeKey] {
+		t.Errorf("GET = %+v", got)
+	}
+
+	if rec := post(url.Values{"key": {nodeKey.String()}, "clear": {"1"}}); rec.Code != http.StatusOK {
+		t.Fatalf("clearing client limit: %v %s", rec.Code, rec.Body)
+	}
+	if _, clients := s.RateLimits(); len(clients) != 0 {
+		t.Errorf("client limits after clear = %v", clients)
+	}
+	if rec := post(url.Values{"bytes": {"-1"}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("negative rate: %v; want %v", rec.Code, http.StatusBadRequest)
+	}
+
+	req := httptest.NewRequest("POST", "/debug/ratelimit?bytes=1", nil)
+	rec = httptest.NewRecorder()
+	h(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("POST without Sec-Debug: %v; want %v", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestLoadRateLimitOverrides(t *testing.T) {
+	nodeKey := key.NewNode().Public()
+	path := filepath.Join(t.TempDir(), "overrides.json")
+	b := fmt.Appendf(nil, `{%q: {"BytesPerSecond": 5000}}`, nodeKey.String())
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadRateLimitOverrides(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[key.NodePublic]derp.RateLimit{nodeKey: {BytesPerSecond: 5000}}; !maps.Equal(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
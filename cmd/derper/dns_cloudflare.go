@@ -0,0 +1,154 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// cloudflareProvider is a dnsProvider that publishes TXT records with the
+// Cloudflare API.
+type cloudflareProvider struct {
+	token  string // API token with Zone:DNS:Edit permission
+	zoneID string // or empty to look up the zone by name
+	base   string // API base URL; a var for tests
+
+	mu      sync.Mutex
+	records map[[2]string]string // (fqdn, value) => record ID
+}
+
+func newCloudflareProvider(token, zoneID string) (*cloudflareProvider, error) {
+	if token == "" {
+		return nil, errors.New("cloudflare: CLOUDFLARE_DNS_API_TOKEN is not set")
+	}
+	return &cloudflareProvider{
+		token:   token,
+		zoneID:  zoneID,
+		base:    "https://api.cloudflare.com/client/v4",
+		records: map[[2]string]string{},
+	}, nil
+}
+
+// cloudflareResponse is the envelope of Cloudflare API responses.
+type cloudflareResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result json.RawMessage `json:"result"`
+}
+
+// do makes an API request, and decodes the result of a successful one into
+// result, if non-nil.
+func (p *cloudflareProvider) do(ctx context.Context, method, path string, body, result any) error {
+	var rb io.Reader
+	if body != nil {
+		j, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rb = bytes.NewReader(j)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.base+path, rb)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	var cr cloudflareResponse
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&cr); err != nil {
+		return fmt.Errorf("cloudflare: %s %s: %s: %w", method, path, res.Status, err)
+	}
+	if !cr.Success {
+		var msgs []string
+		for _, e := range cr.Errors {
+			msgs = append(msgs, fmt.Sprintf("%d: %s", e.Code, e.Message))
+		}
+		return fmt.Errorf("cloudflare: %s %s: %s: %s", method, path, res.Status, strings.Join(msgs, "; "))
+	}
+	if result != nil {
+		return json.Unmarshal(cr.Result, result)
+	}
+	return nil
+}
+
+// zone returns the ID of the zone that fqdn is in: the configured one, or
+// the one whose name is the longest suffix of fqdn.
+func (p *cloudflareProvider) zone(ctx context.Context, fqdn string) (string, error) {
+	if p.zoneID != "" {
+		return p.zoneID, nil
+	}
+	name := strings.TrimSuffix(fqdn, ".")
+	for {
+		var zones []struct {
+			ID string `json:"id"`
+		}
+		if err := p.do(ctx, "GET", "/zones?name="+url.QueryEscape(name), nil, &zones); err != nil {
+			return "", err
+		}
+		if len(zones) > 0 {
+			return zones[0].ID, nil
+		}
+		_, parent, ok := strings.Cut(name, ".")
+		if !ok || !strings.Contains(parent, ".") {
+			return "", fmt.Errorf("cloudflare: no zone found for %q", fqdn)
+		}
+		name = parent
+	}
+}
+
+func (p *cloudflareProvider) Present(ctx context.Context, fqdn, value string) error {
+	zone, err := p.zone(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	var rec struct {
+		ID string `json:"id"`
+	}
+	err = p.do(ctx, "POST", "/zones/"+zone+"/dns_records", map[string]any{
+		"type":    "TXT",
+		"name":    strings.TrimSuffix(fqdn, "."),
+		"content": value,
+		"ttl":     120,
+	}, &rec)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.records[[2]string{fqdn, value}] = zone + "/dns_records/" + rec.ID
+	return nil
+}
+
+func (p *cloudflareProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	k := [2]string{fqdn, value}
+	p.mu.Lock()
+	rec, ok := p.records[k]
+	p.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if err := p.do(ctx, "DELETE", "/zones/"+rec, nil, nil); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.records, k)
+	return nil
+}
@@ -0,0 +1,246 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// rfc2136Provider is a dnsProvider that publishes TXT records with DNS
+// UPDATE messages (RFC 2136) to a name server, such as BIND or Knot,
+// authenticated with TSIG (RFC 8945).
+type rfc2136Provider struct {
+	server  string // host:port of the primary name server
+	zone    dnsmessage.Name
+	keyName dnsmessage.Name // or empty to not sign updates
+	alg     dnsmessage.Name
+	newHash func() hash.Hash
+	secret  []byte
+
+	now func() time.Time // a var for tests
+}
+
+// tsigAlgorithms are the supported TSIG algorithms and their hashes.
+var tsigAlgorithms = map[string]func() hash.Hash{
+	"hmac-sha256.": sha256.New,
+	"hmac-sha512.": sha512.New,
+}
+
+func newRFC2136Provider(server, zone, keyName, alg, secret string) (*rfc2136Provider, error) {
+	if server == "" || zone == "" {
+		return nil, errors.New("rfc2136: RFC2136_NAMESERVER and RFC2136_ZONE must be set")
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	zn, err := dnsmessage.NewName(fqdn(zone))
+	if err != nil {
+		return nil, fmt.Errorf("rfc2136: zone: %w", err)
+	}
+	p := &rfc2136Provider{server: server, zone: zn, now: time.Now}
+	if keyName == "" {
+		return p, nil
+	}
+	if alg == "" {
+		alg = "hmac-sha256"
+	}
+	p.newHash = tsigAlgorithms[fqdn(strings.ToLower(alg))]
+	if p.newHash == nil {
+		return nil, fmt.Errorf("rfc2136: unsupported TSIG algorithm %q; want hmac-sha256 or hmac-sha512", alg)
+	}
+	if p.alg, err = dnsmessage.NewName(fqdn(strings.ToLower(alg))); err != nil {
+		return nil, err
+	}
+	if p.keyName, err = dnsmessage.NewName(fqdn(strings.ToLower(keyName))); err != nil {
+		return nil, fmt.Errorf("rfc2136: TSIG key name: %w", err)
+	}
+	if p.secret, err = base64.StdEncoding.DecodeString(secret); err != nil || len(p.secret) == 0 {
+		return nil, errors.New("rfc2136: RFC2136_TSIG_SECRET must be the base64 TSIG secret")
+	}
+	return p, nil
+}
+
+// fqdn returns name with a trailing dot.
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+func (p *rfc2136Provider) Present(ctx context.Context, fqdn, value string) error {
+	return p.update(ctx, fqdn, value, false)
+}
+
+func (p *rfc2136Provider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.update(ctx, fqdn, value, true)
+}
+
+// update adds (or with del, deletes) the TXT record at name with value.
+func (p *rfc2136Provider) update(ctx context.Context, name, value string, del bool) error {
+	msg, err := p.updateMessage(name, value, del)
+	if err != nil {
+		return err
+	}
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "tcp", p.server)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if dl, ok := ctx.Deadline(); ok {
+		c.SetDeadline(dl)
+	} else {
+		c.SetDeadline(time.Now().Add(30 * time.Second))
+	}
+	if _, err := c.Write(binary.BigEndian.AppendUint16(nil, uint16(len(msg)))); err != nil {
+		return err
+	}
+	if _, err := c.Write(msg); err != nil {
+		return err
+	}
+	var lb [2]byte
+	if _, err := io.ReadFull(c, lb[:]); err != nil {
+		return fmt.Errorf("rfc2136: reading response: %w", err)
+	}
+	res := make([]byte, binary.BigEndian.Uint16(lb[:]))
+	if _, err := io.ReadFull(c, res); err != nil {
+		return fmt.Errorf("rfc2136: reading response: %w", err)
+	}
+	var parser dnsmessage.Parser
+	h, err := parser.Start(res)
+	if err != nil {
+		return fmt.Errorf("rfc2136: parsing response: %w", err)
+	}
+	if h.ID != binary.BigEndian.Uint16(msg) {
+		return errors.New("rfc2136: response ID mismatch")
+	}
+	if h.RCode != dnsmessage.RCodeSuccess {
+		return fmt.Errorf("rfc2136: update of %q failed: %v", name, h.RCode)
+	}
+	return nil
+}
+
+const (
+	dnsOpcodeUpdate = 5
+	dnsTypeTSIG     = dnsmessage.Type(250)
+	dnsClassNone    = dnsmessage.Class(254)
+	dnsClassAny     = dnsmessage.Class(255)
+	tsigFudge       = 300 // seconds of allowed clock skew
+)
+
+// updateMessage returns the DNS UPDATE message that adds (or with del,
+// deletes) the TXT record at name with value, signed with TSIG if
+// configured.
+func (p *rfc2136Provider) updateMessage(name, value string, del bool) ([]byte, error) {
+	rn, err := dnsmessage.NewName(fqdn(name))
+	if err != nil {
+		return nil, err
+	}
+	var idb [2]byte
+	if _, err := rand.Read(idb[:]); err != nil {
+		return nil, err
+	}
+	id := binary.BigEndian.Uint16(idb[:])
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, OpCode: dnsOpcodeUpdate})
+	// An update's sections are the zone, prerequisites, updates and
+	// additional data, in place of a query's question, answer, authority
+	// and additional sections.
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: p.zone, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	if err := b.StartAuthorities(); err != nil {
+		return nil, err
+	}
+	rh := dnsmessage.ResourceHeader{Name: rn, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET, TTL: 60}
+	if del {
+		// Delete an RR from an RRset (RFC 2136, section 2.5.4).
+		rh.Class = dnsClassNone
+		rh.TTL = 0
+	}
+	if err := b.TXTResource(rh, dnsmessage.TXTResource{TXT: []string{value}}); err != nil {
+		return nil, err
+	}
+	msg, err := b.Finish()
+	if err != nil {
+		return nil, err
+	}
+	if p.keyName.Length == 0 {
+		return msg, nil
+	}
+	return p.sign(msg, p.now())
+}
+
+// sign returns msg with a TSIG record (RFC 8945) appended, signed as of now.
+func (p *rfc2136Provider) sign(msg []byte, now time.Time) ([]byte, error) {
+	keyName := appendName(nil, p.keyName)
+	alg := appendName(nil, p.alg)
+	var timeSigned [6]byte
+	ts := uint64(now.Unix())
+	binary.BigEndian.PutUint16(timeSigned[:2], uint16(ts>>32))
+	binary.BigEndian.PutUint32(timeSigned[2:], uint32(ts))
+
+	// The MAC covers the message and the TSIG variables (section 4.3.3).
+	mac := hmac.New(p.newHash, p.secret)
+	mac.Write(msg)
+	mac.Write(keyName)
+	mac.Write(binary.BigEndian.AppendUint16(nil, uint16(dnsClassAny)))
+	mac.Write([]byte{0, 0, 0, 0}) // TTL
+	mac.Write(alg)
+	mac.Write(timeSigned[:])
+	mac.Write(binary.BigEndian.AppendUint16(nil, tsigFudge))
+	mac.Write([]byte{0, 0, 0, 0}) // error, other len
+	sum := mac.Sum(nil)
+
+	var rdata []byte
+	rdata = append(rdata, alg...)
+	rdata = append(rdata, timeSigned[:]...)
+	rdata = binary.BigEndian.AppendUint16(rdata, tsigFudge)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(sum)))
+	rdata = append(rdata, sum...)
+	rdata = append(rdata, msg[:2]...) // original ID
+	rdata = append(rdata, 0, 0, 0, 0) // error, other len
+
+	out := append([]byte(nil), msg...)
+	out = append(out, keyName...)
+	out = binary.BigEndian.AppendUint16(out, uint16(dnsTypeTSIG))
+	out = binary.BigEndian.AppendUint16(out, uint16(dnsClassAny))
+	out = binary.BigEndian.AppendUint32(out, 0) // TTL
+	out = binary.BigEndian.AppendUint16(out, uint16(len(rdata)))
+	out = append(out, rdata...)
+	// ARCOUNT, at offset 10, counts the TSIG record.
+	binary.BigEndian.PutUint16(out[10:], binary.BigEndian.Uint16(out[10:])+1)
+	return out, nil
+}
+
+// appendName appends n to b in uncompressed wire format.
+func appendName(b []byte, n dnsmessage.Name) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(n.String(), "."), ".") {
+		if label == "" {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
@@ -0,0 +1,210 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// route53Provider is a dnsProvider that publishes TXT records in an AWS
+// Route 53 hosted zone.
+type route53Provider struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string // or empty
+	zoneID          string
+	base            string // API base URL; a var for tests
+
+	now          func() time.Time // a var for tests
+	pollInterval time.Duration    // between checks for the change to be in sync
+}
+
+func newRoute53Provider(accessKeyID, secretAccessKey, sessionToken, zoneID string) (*route53Provider, error) {
+	if accessKeyID == "" || secretAccessKey == "" {
+		return nil, errors.New("route53: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	if zoneID == "" {
+		return nil, errors.New("route53: AWS_HOSTED_ZONE_ID is not set")
+	}
+	return &route53Provider{
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		sessionToken:    sessionToken,
+		zoneID:          strings.TrimPrefix(zoneID, "/hostedzone/"),
+		base:            "https://route53.amazonaws.com",
+		now:             time.Now,
+		pollInterval:    5 * time.Second,
+	}, nil
+}
+
+const route53XMLNS = "https://route53.amazonaws.com/doc/2013-04-01/"
+
+type route53ChangeRequest struct {
+	XMLName xml.Name        `xml:"ChangeResourceRecordSetsRequest"`
+	XMLNS   string          `xml:"xmlns,attr"`
+	Changes []route53Change `xml:"ChangeBatch>Changes>Change"`
+}
+
+type route53Change struct {
+	Action string   `xml:"Action"`
+	Name   string   `xml:"ResourceRecordSet>Name"`
+	Type   string   `xml:"ResourceRecordSet>Type"`
+	TTL    int      `xml:"ResourceRecordSet>TTL"`
+	Values []string `xml:"ResourceRecordSet>ResourceRecords>ResourceRecord>Value"`
+}
+
+type route53ChangeInfo struct {
+	ID     string `xml:"ChangeInfo>Id"`
+	Status string `xml:"ChangeInfo>Status"`
+}
+
+type route53Error struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+// do makes a signed API request, and decodes the response to a successful
+// one into result.
+func (p *route53Provider) do(ctx context.Context, method, path string, body []byte, result any) error {
+	req, err := http.NewRequestWithContext(ctx, method, p.base+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "text/xml")
+	}
+	if p.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", p.sessionToken)
+	}
+	signV4(req, body, p.accessKeyID, p.secretAccessKey, "us-east-1", "route53", p.now())
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	rb, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		var e route53Error
+		if xml.Unmarshal(rb, &e) == nil && e.Code != "" {
+			return fmt.Errorf("route53: %s %s: %s: %s", method, path, e.Code, e.Message)
+		}
+		return fmt.Errorf("route53: %s %s: %s", method, path, res.Status)
+	}
+	return xml.Unmarshal(rb, result)
+}
+
+// change applies the TXT record change action (UPSERT or DELETE) and waits
+// for it to reach all of Route 53's name servers.
+func (p *route53Provider) change(ctx context.Context, action, fqdn, value string) error {
+	body, err := xml.Marshal(route53ChangeRequest{
+		XMLNS: route53XMLNS,
+		Changes: []route53Change{{
+			Action: action,
+			Name:   fqdn,
+			Type:   "TXT",
+			TTL:    60,
+			Values: []string{`"` + value + `"`},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	var ci route53ChangeInfo
+	if err := p.do(ctx, "POST", "/2013-04-01/hostedzone/"+p.zoneID+"/rrset", append([]byte(xml.Header), body...), &ci); err != nil {
+		return err
+	}
+	for ci.Status != "INSYNC" {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(p.pollInterval):
+		}
+		if err := p.do(ctx, "GET", "/2013-04-01/change/"+strings.TrimPrefix(ci.ID, "/change/"), nil, &ci); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *route53Provider) Present(ctx context.Context, fqdn, value string) error {
+	return p.change(ctx, "UPSERT", fqdn, value)
+}
+
+func (p *route53Provider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.change(ctx, "DELETE", fqdn, value)
+}
+
+// signV4 signs req, whose body is body, for service in region with AWS
+// Signature Version 4, as of now. All the headers set on req are signed.
+func signV4(req *http.Request, body []byte, accessKeyID, secretAccessKey, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, vs := range req.Header {
+		headers[strings.ToLower(k)] = strings.Join(vs, ",")
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		fmt.Fprintf(&canonHeaders, "%s:%s\n", k, strings.TrimSpace(headers[k]))
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	// url.Values.Encode sorts by key, as the canonical query string
+	// requires, but escapes spaces as "+" rather than "%20".
+	query := strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20")
+	bodyHash := sha256.Sum256(body)
+	canonRequest := strings.Join([]string{
+		req.Method,
+		path,
+		query,
+		canonHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	crHash := sha256.Sum256([]byte(canonRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(crHash[:])
+
+	key := []byte("AWS4" + secretAccessKey)
+	for _, s := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	sig := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyID, scope, signedHeaders, sig))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,128 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"tailscale.com/derp"
+	"tailscale.com/types/key"
+)
+
+// setRateLimits sets s's per-client rate limits from the
+// --client-rate-limit-* flags.
+func setRateLimits(s *derp.Server) error {
+	s.SetRateLimit(derp.RateLimit{
+		BytesPerSecond:   *clientRateBytes,
+		BytesBurst:       *clientRateBytesBurst,
+		PacketsPerSecond: *clientRatePackets,
+		PacketsBurst:     *clientRatePacketsBurst,
+	})
+	if *clientRateOverrides == "" {
+		return nil
+	}
+	overrides, err := loadRateLimitOverrides(*clientRateOverrides)
+	if err != nil {
+		return err
+	}
+	for k, rl := range overrides {
+		s.SetClientRateLimit(k, rl)
+	}
+	return nil
+}
+
+// loadRateLimitOverrides reads a JSON object mapping node keys to their
+// derp.RateLimit from the file at path.
+func loadRateLimitOverrides(path string) (map[key.NodePublic]derp.RateLimit, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var overrides map[key.NodePublic]derp.RateLimit
+	if err := json.Unmarshal(b, &overrides); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return overrides, nil
+}
+
+// rateLimitsJSON is the body of /debug/ratelimit responses.
+type rateLimitsJSON struct {
+	Default derp.RateLimit
+	Clients map[key.NodePublic]derp.RateLimit `json:",omitempty"`
+}
+
+// serveDebugRateLimits serves /debug/ratelimit, which shows s's rate
+// limits, and, on POST, changes them.
+//
+// A POST with a "key" sets that client's limit, or without one, the
+// default, to the form values "bytes", "bytes-burst", "packets" and
+// "packets-burst", zero if unset. A POST with a "key" and "clear" removes
+// the client's own limit.
+func serveDebugRateLimits(s *derp.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			if r.Header.Get("Sec-Debug") != "derp" {
+				http.Error(w, "To set, use: curl -HSec-Debug:derp -d key=nodekey:... -d bytes=1000000 http://derp/debug/ratelimit", http.StatusBadRequest)
+				return
+			}
+			if err := setDebugRateLimit(s, r); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		var res rateLimitsJSON
+		res.Default, res.Clients = s.RateLimits()
+		w.Header().Set("Content-Type", "application/json")
+		e := json.NewEncoder(w)
+		e.SetIndent("", "\t")
+		e.Encode(res)
+	}
+}
+
+func setDebugRateLimit(s *derp.Server, r *http.Request) error {
+	var k key.NodePublic
+	hasKey := r.FormValue("key") != ""
+	if hasKey {
+		if err := k.UnmarshalText([]byte(r.FormValue("key"))); err != nil {
+			return fmt.Errorf("bad key: %w", err)
+		}
+	}
+	if r.FormValue("clear") != "" {
+		if !hasKey {
+			return fmt.Errorf("clear requires a key")
+		}
+		s.ClearClientRateLimit(k)
+		return nil
+	}
+	var rl derp.RateLimit
+	for _, f := range []struct {
+		name string
+		v    *int
+	}{
+		{"bytes", &rl.BytesPerSecond},
+		{"bytes-burst", &rl.BytesBurst},
+		{"packets", &rl.PacketsPerSecond},
+		{"packets-burst", &rl.PacketsBurst},
+	} {
+		v := r.FormValue(f.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("bad %s value %q", f.name, v)
+		}
+		*f.v = n
+	}
+	if hasKey {
+		s.SetClientRateLimit(k, rl)
+	} else {
+		s.SetRateLimit(rl)
+	}
+	return nil
+}
@@ -55,6 +55,7 @@
 var (
 	gaugeEgressProxyGroupResources  = clientmetric.NewGauge(kubetypes.MetricProxyGroupEgressCount)
 	gaugeIngressProxyGroupResources = clientmetric.NewGauge(kubetypes.MetricProxyGroupIngressCount)
+	gaugeDNSProxyGroupResources     = clientmetric.NewGauge(kubetypes.MetricProxyGroupDNSCount)
 )
 
 // ProxyGroupReconciler ensures cluster resources for a ProxyGroup definition.
@@ -75,6 +76,7 @@ type ProxyGroupReconciler struct {
 	mu                 sync.Mutex           // protects following
 	egressProxyGroups  set.Slice[types.UID] // for egress proxygroups gauge
 	ingressProxyGroups set.Slice[types.UID] // for ingress proxygroups gauge
+	dnsProxyGroups     set.Slice[types.UID] // for dns proxygroups gauge
 }
 
 func (r *ProxyGroupReconciler) logger(name string) *zap.SugaredLogger {
@@ -208,7 +210,7 @@ func (r *ProxyGroupReconciler) Reconcile(ctx context.Context, req reconcile.Requ
 
 // validateProxyClassForPG applies custom validation logic for ProxyClass applied to ProxyGroup.
 func validateProxyClassForPG(logger *zap.SugaredLogger, pg *tsapi.ProxyGroup, pc *tsapi.ProxyClass) {
-	if pg.Spec.Type == tsapi.ProxyGroupTypeIngress {
+	if pg.Spec.Type != tsapi.ProxyGroupTypeEgress {
 		return
 	}
 	// Our custom logic for ensuring minimum downtime ProxyGroup update rollouts relies on the local health check
@@ -279,6 +281,22 @@ func (r *ProxyGroupReconciler) maybeProvision(ctx context.Context, pg *tsapi.Pro
 	}); err != nil {
 		return fmt.Errorf("error provisioning RoleBinding: %w", err)
 	}
+	if pg.Spec.Type == tsapi.ProxyGroupTypeDNS {
+		want := pgDNSService(pg, r.tsNamespace)
+		svc, err := createOrUpdate(ctx, r.Client, r.tsNamespace, want, func(existing *corev1.Service) {
+			existing.ObjectMeta.Labels = want.ObjectMeta.Labels
+			existing.ObjectMeta.OwnerReferences = want.ObjectMeta.OwnerReferences
+			existing.Spec.Selector = want.Spec.Selector
+			existing.Spec.Ports = want.Spec.Ports
+		})
+		if err != nil {
+			return fmt.Errorf("error provisioning DNS Service: %w", err)
+		}
+		pg.Status.Nameserver = nil
+		if ip := svc.Spec.ClusterIP; ip != "" && ip != corev1.ClusterIPNone {
+			pg.Status.Nameserver = &tsapi.ProxyGroupNameserverStatus{IP: ip}
+		}
+	}
 	if pg.Spec.Type == tsapi.ProxyGroupTypeEgress {
 		cm, hp := pgEgressCM(pg, r.tsNamespace)
 		if _, err := createOrUpdate(ctx, r.Client, r.tsNamespace, cm, func(existing *corev1.ConfigMap) {
@@ -543,9 +561,12 @@ func (r *ProxyGroupReconciler) ensureAddedToGaugeForProxyGroup(pg *tsapi.ProxyGr
 		r.egressProxyGroups.Add(pg.UID)
 	case tsapi.ProxyGroupTypeIngress:
 		r.ingressProxyGroups.Add(pg.UID)
+	case tsapi.ProxyGroupTypeDNS:
+		r.dnsProxyGroups.Add(pg.UID)
 	}
 	gaugeEgressProxyGroupResources.Set(int64(r.egressProxyGroups.Len()))
 	gaugeIngressProxyGroupResources.Set(int64(r.ingressProxyGroups.Len()))
+	gaugeDNSProxyGroupResources.Set(int64(r.dnsProxyGroups.Len()))
 }
 
 // ensureRemovedFromGaugeForProxyGroup ensures the gauge metric for the ProxyGroup resource type is updated when the
@@ -556,9 +577,12 @@ func (r *ProxyGroupReconciler) ensureRemovedFromGaugeForProxyGroup(pg *tsapi.Pro
 		r.egressProxyGroups.Remove(pg.UID)
 	case tsapi.ProxyGroupTypeIngress:
 		r.ingressProxyGroups.Remove(pg.UID)
+	case tsapi.ProxyGroupTypeDNS:
+		r.dnsProxyGroups.Remove(pg.UID)
 	}
 	gaugeEgressProxyGroupResources.Set(int64(r.egressProxyGroups.Len()))
 	gaugeIngressProxyGroupResources.Set(int64(r.ingressProxyGroups.Len()))
+	gaugeDNSProxyGroupResources.Set(int64(r.dnsProxyGroups.Len()))
 }
 
 func pgTailscaledConfig(pg *tsapi.ProxyGroup, class *tsapi.ProxyClass, idx int32, authKey string, oldSecret *corev1.Secret) (tailscaledConfigs, error) {
@@ -25,6 +25,10 @@
 // deletionGracePeriodSeconds is set to 6 minutes to ensure that the pre-stop hook of these proxies have enough chance to terminate gracefully.
 const deletionGracePeriodSeconds int64 = 360
 
+// dnsPort is the port on which DNS ProxyGroup replicas and the Service
+// fronting them serve DNS.
+const dnsPort = 53
+
 // Returns the base StatefulSet definition for a ProxyGroup. A ProxyClass may be
 // applied over the top after.
 func pgStatefulSet(pg *tsapi.ProxyGroup, namespace, image, tsFirewallMode string, proxyClass *tsapi.ProxyClass) (*appsv1.StatefulSet, error) {
@@ -62,8 +66,11 @@ func pgStatefulSet(pg *tsapi.ProxyGroup, namespace, image, tsFirewallMode string
 	}
 	tmpl.Spec.ServiceAccountName = pg.Name
 	tmpl.Spec.InitContainers[0].Image = image
-	proxyConfigVolName := pgEgressCMName(pg.Name)
-	if pg.Spec.Type == tsapi.ProxyGroupTypeIngress {
+	var proxyConfigVolName string // DNS ProxyGroups have no proxy config.
+	switch pg.Spec.Type {
+	case tsapi.ProxyGroupTypeEgress:
+		proxyConfigVolName = pgEgressCMName(pg.Name)
+	case tsapi.ProxyGroupTypeIngress:
 		proxyConfigVolName = pgIngressCMName(pg.Name)
 	}
 	tmpl.Spec.Volumes = func() []corev1.Volume {
@@ -79,16 +86,18 @@ func pgStatefulSet(pg *tsapi.ProxyGroup, namespace, image, tsFirewallMode string
 			})
 		}
 
-		volumes = append(volumes, corev1.Volume{
-			Name: proxyConfigVolName,
-			VolumeSource: corev1.VolumeSource{
-				ConfigMap: &corev1.ConfigMapVolumeSource{
-					LocalObjectReference: corev1.LocalObjectReference{
-						Name: proxyConfigVolName,
+		if proxyConfigVolName != "" {
+			volumes = append(volumes, corev1.Volume{
+				Name: proxyConfigVolName,
+				VolumeSource: corev1.VolumeSource{
+					ConfigMap: &corev1.ConfigMapVolumeSource{
+						LocalObjectReference: corev1.LocalObjectReference{
+							Name: proxyConfigVolName,
+						},
 					},
 				},
-			},
-		})
+			})
+		}
 
 		return volumes
 	}()
@@ -110,11 +119,13 @@ func pgStatefulSet(pg *tsapi.ProxyGroup, namespace, image, tsFirewallMode string
 			})
 		}
 
-		mounts = append(mounts, corev1.VolumeMount{
-			Name:      proxyConfigVolName,
-			MountPath: "/etc/proxies",
-			ReadOnly:  true,
-		})
+		if proxyConfigVolName != "" {
+			mounts = append(mounts, corev1.VolumeMount{
+				Name:      proxyConfigVolName,
+				MountPath: "/etc/proxies",
+				ReadOnly:  true,
+			})
+		}
 
 		return mounts
 	}()
@@ -150,7 +161,8 @@ func pgStatefulSet(pg *tsapi.ProxyGroup, namespace, image, tsFirewallMode string
 			})
 		}
 
-		if pg.Spec.Type == tsapi.ProxyGroupTypeEgress {
+		switch pg.Spec.Type {
+		case tsapi.ProxyGroupTypeEgress:
 			envs = append(envs,
 				// TODO(irbekrm): in 1.80 we deprecated TS_EGRESS_SERVICES_CONFIG_PATH in favour of
 				// TS_EGRESS_PROXIES_CONFIG_PATH. Remove it in 1.84.
@@ -170,7 +182,7 @@ func pgStatefulSet(pg *tsapi.ProxyGroup, namespace, image, tsFirewallMode string
 					Name:  "TS_ENABLE_HEALTH_CHECK",
 					Value: "true",
 				})
-		} else { // ingress
+		case tsapi.ProxyGroupTypeIngress:
 			envs = append(envs, corev1.EnvVar{
 				Name:  "TS_INTERNAL_APP",
 				Value: kubetypes.AppProxyGroupIngress,
@@ -179,9 +191,24 @@ func pgStatefulSet(pg *tsapi.ProxyGroup, namespace, image, tsFirewallMode string
 					Name:  "TS_SERVE_CONFIG",
 					Value: fmt.Sprintf("/etc/proxies/%s", serveConfigKey),
 				})
+		case tsapi.ProxyGroupTypeDNS:
+			envs = append(envs, corev1.EnvVar{
+				Name:  "TS_INTERNAL_APP",
+				Value: kubetypes.AppProxyGroupDNS,
+			},
+				corev1.EnvVar{
+					Name:  "TS_EXPERIMENTAL_DNS_FORWARDER_PORT",
+					Value: strconv.Itoa(dnsPort),
+				})
 		}
 		return append(c.Env, envs...)
 	}()
+	if pg.Spec.Type == tsapi.ProxyGroupTypeDNS {
+		c.Ports = append(c.Ports,
+			corev1.ContainerPort{Name: "dns-udp", ContainerPort: dnsPort, Protocol: corev1.ProtocolUDP},
+			corev1.ContainerPort{Name: "dns-tcp", ContainerPort: dnsPort, Protocol: corev1.ProtocolTCP},
+		)
+	}
 
 	// The pre-stop hook is used to ensure that a replica does not get terminated while cluster traffic for egress
 	// services is still being routed to it.
@@ -318,6 +345,28 @@ func pgIngressCM(pg *tsapi.ProxyGroup, namespace string) *corev1.ConfigMap {
 	}
 }
 
+// pgDNSService returns the ClusterIP Service that fronts the MagicDNS
+// forwarders of a ProxyGroup of type dns. Cluster DNS forwards queries for
+// the tailnet's domain to this Service.
+func pgDNSService(pg *tsapi.ProxyGroup, namespace string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            pgDNSServiceName(pg.Name),
+			Namespace:       namespace,
+			Labels:          pgLabels(pg.Name, nil),
+			OwnerReferences: pgOwnerReference(pg),
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceTypeClusterIP,
+			Selector: pgLabels(pg.Name, nil),
+			Ports: []corev1.ServicePort{
+				{Name: "dns-udp", Port: dnsPort, TargetPort: intstr.FromInt(dnsPort), Protocol: corev1.ProtocolUDP},
+				{Name: "dns-tcp", Port: dnsPort, TargetPort: intstr.FromInt(dnsPort), Protocol: corev1.ProtocolTCP},
+			},
+		},
+	}
+}
+
 func pgSecretLabels(pgName, typ string) map[string]string {
 	return pgLabels(pgName, map[string]string{
 		labelSecretType: typ, // "config" or "state".
@@ -353,6 +402,10 @@ func pgEgressCMName(pg string) string {
 	return fmt.Sprintf("%s-egress-config", pg)
 }
 
+func pgDNSServiceName(pg string) string {
+	return fmt.Sprintf("%s-dns", pg)
+}
+
 // hasLocalAddrPortSet returns true if the proxyclass has the TS_LOCAL_ADDR_PORT env var set. For egress ProxyGroups,
 // currently (2025-01-26) this means that the ProxyGroup does not support graceful failover.
 func hasLocalAddrPortSet(proxyClass *tsapi.ProxyClass) bool {
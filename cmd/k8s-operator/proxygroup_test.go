@@ -260,7 +260,7 @@ func TestProxyGroupTypes(t *testing.T) {
 	fc := fake.NewClientBuilder().
 		WithScheme(tsapi.GlobalScheme).
 		WithObjects(pc).
-		WithStatusSubresource(pc).
+		WithStatusSubresource(pc, &tsapi.ProxyGroup{}).
 		Build()
 	mustUpdateStatus(t, fc, "", pc.Name, func(p *tsapi.ProxyClass) {
 		p.Status.Conditions = []metav1.Condition{{
@@ -444,6 +444,58 @@ func TestProxyGroupTypes(t *testing.T) {
 			t.Errorf("unexpected volume mounts (-want +got):\n%s", diff)
 		}
 	})
+
+	t.Run("dns_type", func(t *testing.T) {
+		pg := &tsapi.ProxyGroup{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "test-dns",
+				UID:  "test-dns-uid",
+			},
+			Spec: tsapi.ProxyGroupSpec{
+				Type:     tsapi.ProxyGroupTypeDNS,
+				Replicas: ptr.To[int32](0),
+			},
+		}
+		mustCreate(t, fc, pg)
+
+		expectReconciled(t, reconciler, "", pg.Name)
+		verifyProxyGroupCounts(t, reconciler, 1, 2)
+		if got := reconciler.dnsProxyGroups.Len(); got != 1 {
+			t.Errorf("expected 1 dns proxy group, got %d", got)
+		}
+
+		sts := &appsv1.StatefulSet{}
+		if err := fc.Get(context.Background(), client.ObjectKey{Namespace: tsNamespace, Name: pg.Name}, sts); err != nil {
+			t.Fatalf("failed to get StatefulSet: %v", err)
+		}
+		verifyEnvVar(t, sts, "TS_INTERNAL_APP", kubetypes.AppProxyGroupDNS)
+		verifyEnvVar(t, sts, "TS_EXPERIMENTAL_DNS_FORWARDER_PORT", "53")
+		if len(sts.Spec.Template.Spec.Volumes) != 0 {
+			t.Errorf("expected no proxy config volumes, got %v", sts.Spec.Template.Spec.Volumes)
+		}
+		wantPorts := []corev1.ContainerPort{
+			{Name: "dns-udp", ContainerPort: 53, Protocol: corev1.ProtocolUDP},
+			{Name: "dns-tcp", ContainerPort: 53, Protocol: corev1.ProtocolTCP},
+		}
+		if diff := cmp.Diff(wantPorts, sts.Spec.Template.Spec.Containers[0].Ports); diff != "" {
+			t.Errorf("unexpected container ports (-want +got):\n%s", diff)
+		}
+
+		// The Service fronting the forwarders is created, and its
+		// ClusterIP surfaced in status once allocated.
+		wantSvc := pgDNSService(pg, tsNamespace)
+		expectEqual(t, fc, wantSvc)
+		mustUpdate(t, fc, tsNamespace, wantSvc.Name, func(svc *corev1.Service) {
+			svc.Spec.ClusterIP = "10.20.30.40"
+		})
+		expectReconciled(t, reconciler, "", pg.Name)
+		if err := fc.Get(context.Background(), client.ObjectKeyFromObject(pg), pg); err != nil {
+			t.Fatal(err)
+		}
+		if pg.Status.Nameserver == nil || pg.Status.Nameserver.IP != "10.20.30.40" {
+			t.Errorf("unexpected nameserver status %+v", pg.Status.Nameserver)
+		}
+	})
 }
 
 func verifyProxyGroupCounts(t *testing.T, r *ProxyGroupReconciler, wantIngress, wantEgress int) {
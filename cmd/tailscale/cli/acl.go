@@ -0,0 +1,272 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/ipproto"
+)
+
+var aclCmd = &ffcli.Command{
+	Name:       "acl",
+	ShortUsage: "tailscale acl <subcommand> [flags]",
+	ShortHelp:  "Debug this machine's access controls",
+	UsageFunc:  usageFuncNoDefaultValues,
+	Subcommands: []*ffcli.Command{
+		{
+			Name:       "check",
+			ShortUsage: "tailscale acl check [--json] <src> <dst:port> [proto]",
+			ShortHelp:  "Check whether this machine accepts traffic from a source",
+			LongHelp: strings.TrimSpace(`
+'tailscale acl check' evaluates the packet filter currently installed on this
+machine and reports whether it accepts incoming traffic from <src> to
+<dst:port>, and which rule from the tailnet policy permits it. This tells apart
+traffic that's filtered from connections refused by the service itself.
+
+<src> and <dst> are Tailscale IPs or machine names; an empty <dst>, as in
+":22", means this machine. [proto] is "tcp" (the default), "udp", "icmp", or
+another IP protocol name or number. The port may be omitted for ICMP.
+
+For TCP to port 22 while this machine runs Tailscale SSH, it also reports
+which rule of the SSH policy applies to <src>.
+`),
+			Exec: runACLCheck,
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("check")
+				fs.BoolVar(&aclCheckArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
+		{
+			Name:       "stats",
+			ShortUsage: "tailscale acl stats [--enable | --disable] [--json]",
+			ShortHelp:  "Show which rules accept and drop traffic to this machine",
+			LongHelp: strings.TrimSpace(`
+'tailscale acl stats' shows how many new incoming flows each rule of this
+machine's packet filter has accepted, and the incoming packets it has dropped,
+by reason and with a rate-limited log of the most recent ones. This shows
+which traffic the tailnet policy is blocking without capturing packets.
+
+Counting is off by default, as it costs a little per new flow. Turn it on with
+--enable and off with --disable. Rule counters restart whenever the rules
+change.
+`),
+			Exec: runACLStats,
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("stats")
+				fs.BoolVar(&aclStatsArgs.enable, "enable", false, "start counting packets")
+				fs.BoolVar(&aclStatsArgs.disable, "disable", false, "stop counting packets and discard the counters")
+				fs.BoolVar(&aclStatsArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
+	},
+}
+
+var aclCheckArgs struct {
+	json bool
+}
+
+func runACLCheck(ctx context.Context, args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return errors.New("usage: tailscale acl check <src> <dst:port> [proto]")
+	}
+	proto := ipproto.TCP
+	if len(args) == 3 {
+		if err := proto.UnmarshalText([]byte(args[2])); err != nil {
+			return err
+		}
+	}
+	srcStr, _, err := tailscaleIPFromArg(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	src, err := netip.ParseAddr(srcStr)
+	if err != nil {
+		return err
+	}
+	dst, err := aclCheckDst(ctx, args[1], src, proto)
+	if err != nil {
+		return err
+	}
+	protoName, _ := proto.MarshalText()
+	res, err := localClient.CheckACL(ctx, src, dst, string(protoName))
+	if err != nil {
+		return err
+	}
+	if aclCheckArgs.json {
+		j, err := json.MarshalIndent(res, "", "  ")
+		if err != nil {
+			return err
+		}
+		outln(string(j))
+		return nil
+	}
+
+	verdict := "allowed"
+	if !res.Allowed {
+		verdict = "denied"
+	}
+	printf("%v => %v (%s): %s (%s)\n", src, dst, protoName, verdict, res.Reason)
+	switch {
+	case res.ShieldsUp:
+		outln("Shields up: this machine blocks all incoming connections.")
+	case res.RuleIndex < 0:
+		outln("No rule in the tailnet policy permits this traffic.")
+	default:
+		printf("Permitted by rule #%d: %s\n", res.RuleIndex, res.Match)
+		if res.Rule != nil {
+			if j, err := json.Marshal(res.Rule); err == nil {
+				printf("  %s\n", j)
+			}
+		}
+	}
+	if res.SSH != nil {
+		if res.SSH.Rule == nil {
+			outln("No rule in the SSH policy applies to the source.")
+		} else {
+			printf("SSH rule #%d applies: %s", res.SSH.RuleIndex, sshActionName(res.SSH.Rule.Action))
+			if len(res.SSH.Rule.SSHUsers) > 0 {
+				printf(" (users %v)", res.SSH.Rule.SSHUsers)
+			}
+			outln()
+		}
+	}
+	return nil
+}
+
+var aclStatsArgs struct {
+	enable  bool
+	disable bool
+	json    bool
+}
+
+func runACLStats(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("usage: tailscale acl stats [--enable | --disable] [--json]")
+	}
+	var (
+		res *apitype.FilterStats
+		err error
+	)
+	switch {
+	case aclStatsArgs.enable && aclStatsArgs.disable:
+		return errors.New("--enable and --disable are mutually exclusive")
+	case aclStatsArgs.enable || aclStatsArgs.disable:
+		res, err = localClient.SetFilterStats(ctx, aclStatsArgs.enable)
+	default:
+		res, err = localClient.FilterStats(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if aclStatsArgs.json {
+		j, err := json.MarshalIndent(res, "", "  ")
+		if err != nil {
+			return err
+		}
+		outln(string(j))
+		return nil
+	}
+
+	if !res.Enabled {
+		outln("Packet filter stats are disabled; enable them with 'tailscale acl stats --enable'.")
+		return nil
+	}
+	if len(res.Rules) == 0 {
+		outln("The packet filter has no rules.")
+	} else {
+		outln("Flows accepted by rule:")
+		for _, r := range res.Rules {
+			printf("  #%-4d %10d  %s\n", r.RuleIndex, r.Packets, r.Match)
+		}
+	}
+	if len(res.DropsByReason) == 0 {
+		outln("No packets dropped.")
+		return nil
+	}
+	outln("Packets dropped by reason:")
+	for _, reason := range slices.Sorted(maps.Keys(res.DropsByReason)) {
+		printf("  %10d  %s\n", res.DropsByReason[reason], reason)
+	}
+	if len(res.Drops) > 0 {
+		outln("Recent drops:")
+		for _, d := range res.Drops {
+			printf("  %s  %v => %v (%s): %s\n", d.Time.Local().Format(time.DateTime), d.Src, d.Dst, d.Proto, d.Reason)
+		}
+	}
+	if res.DropsNotLogged > 0 {
+		printf("(%d more drops not logged)\n", res.DropsNotLogged)
+	}
+	return nil
+}
+
+// aclCheckDst parses arg as the destination of "tailscale acl check", a host
+// and port, resolving the host like [tailscaleIPFromArg]. An empty host is
+// this node's Tailscale IP of the same family as src.
+func aclCheckDst(ctx context.Context, arg string, src netip.Addr, proto ipproto.Proto) (netip.AddrPort, error) {
+	host, portStr, err := net.SplitHostPort(arg)
+	if err != nil {
+		if proto != ipproto.ICMPv4 && proto != ipproto.ICMPv6 {
+			return netip.AddrPort{}, fmt.Errorf("invalid destination %q; want host:port", arg)
+		}
+		host, portStr = arg, "0"
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid port %q", portStr)
+	}
+	if host == "" {
+		st, err := localClient.StatusWithoutPeers(ctx)
+		if err != nil {
+			return netip.AddrPort{}, err
+		}
+		for _, ip := range st.TailscaleIPs {
+			if ip.Is4() == src.Is4() {
+				return netip.AddrPortFrom(ip, uint16(port)), nil
+			}
+		}
+		return netip.AddrPort{}, fmt.Errorf("this machine has no Tailscale IP of the same family as %v", src)
+	}
+	ipStr, _, err := tailscaleIPFromArg(ctx, host)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	ip, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	return netip.AddrPortFrom(ip, uint16(port)), nil
+}
+
+// sshActionName returns the name of the SSH policy action a, as in the
+// tailnet policy file.
+func sshActionName(a *tailcfg.SSHAction) string {
+	switch {
+	case a == nil:
+		return "none"
+	case a.Reject:
+		return "reject"
+	case a.HoldAndDelegate != "":
+		return "check"
+	case a.Accept:
+		return "accept"
+	}
+	return "unknown"
+}
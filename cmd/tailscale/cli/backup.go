@@ -0,0 +1,176 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"golang.org/x/term"
+	"tailscale.com/atomicfile"
+	"tailscale.com/ipn"
+)
+
+const (
+	backupCreateUsage  = "tailscale backup create [--include-keys] [--passphrase-file=<path>] <file>"
+	backupRestoreUsage = "tailscale backup restore [--passphrase-file=<path>] <file>"
+)
+
+var backupCmd = &ffcli.Command{
+	Name:      "backup",
+	ShortHelp: "Back up or restore this node's configuration",
+	ShortUsage: strings.Join([]string{
+		backupCreateUsage,
+		backupRestoreUsage,
+	}, "\n"),
+	LongHelp: strings.TrimSpace(`
+'tailscale backup create' writes this node's configuration to a single
+archive encrypted with a passphrase: the settings, serve config and Taildrive
+shares of each profile, the profiles themselves and the node's TLS certs.
+
+'tailscale backup restore' restores the configuration in an archive, such as
+after reinstalling the node, adding its profiles to any existing ones and
+switching to the profile that was in use.
+
+By default, the archive doesn't include the node's keys, so the restored node
+must log in again and becomes a new node in the tailnet. With --include-keys,
+it does, and the restored node takes over the identity of the original;
+anyone with such an archive and its passphrase can impersonate this node, so
+keep it safe, and don't restore it to more than one node. Creating or
+restoring an archive with keys must be done as root, not as the operator.
+
+The passphrase is read from the terminal, or from the file given with
+--passphrase-file.
+`),
+	UsageFunc: usageFuncNoDefaultValues,
+	Subcommands: []*ffcli.Command{
+		{
+			Name:       "create",
+			ShortUsage: backupCreateUsage,
+			ShortHelp:  "Write this node's configuration to an encrypted archive",
+			Exec:       runBackupCreate,
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("create")
+				fs.BoolVar(&backupArgs.includeKeys, "include-keys", false, "include the node's machine and node keys, so that the restored node keeps its identity")
+				fs.StringVar(&backupArgs.passphraseFile, "passphrase-file", "", "read the passphrase from this file instead of the terminal")
+				return fs
+			})(),
+		},
+		{
+			Name:       "restore",
+			ShortUsage: backupRestoreUsage,
+			ShortHelp:  "Restore this node's configuration from an encrypted archive",
+			Exec:       runBackupRestore,
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("restore")
+				fs.StringVar(&backupArgs.passphraseFile, "passphrase-file", "", "read the passphrase from this file instead of the terminal")
+				return fs
+			})(),
+		},
+	},
+}
+
+var backupArgs struct {
+	includeKeys    bool
+	passphraseFile string
+}
+
+// readBackupPassphrase returns the passphrase from --passphrase-file, or
+// else prompts for it, twice if confirm.
+func readBackupPassphrase(confirm bool) ([]byte, error) {
+	if backupArgs.passphraseFile != "" {
+		b, err := os.ReadFile(backupArgs.passphraseFile)
+		if err != nil {
+			return nil, err
+		}
+		b = bytes.TrimRight(b, "\r\n")
+		if len(b) == 0 {
+			return nil, fmt.Errorf("passphrase file %s is empty", backupArgs.passphraseFile)
+		}
+		return b, nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, errors.New("no terminal to read the passphrase from; use --passphrase-file")
+	}
+	fmt.Fprint(Stderr, "Passphrase: ")
+	pass, err := term.ReadPassword(fd)
+	fmt.Fprintln(Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if len(pass) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	if confirm {
+		fmt.Fprint(Stderr, "Confirm passphrase: ")
+		again, err := term.ReadPassword(fd)
+		fmt.Fprintln(Stderr)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(pass, again) {
+			return nil, errors.New("passphrases don't match")
+		}
+	}
+	return pass, nil
+}
+
+func runBackupCreate(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", backupCreateUsage)
+	}
+	pass, err := readBackupPassphrase(true)
+	if err != nil {
+		return err
+	}
+	b, err := localClient.CreateBackup(ctx, backupArgs.includeKeys)
+	if err != nil {
+		return err
+	}
+	archive, err := ipn.SealBackup(b, pass)
+	if err != nil {
+		return err
+	}
+	if err := atomicfile.WriteFile(args[0], archive, 0600); err != nil {
+		return err
+	}
+	printf("Backed up %d profile(s) and %d cert(s) to %s.\n", len(b.Profiles), len(b.Certs), args[0])
+	if b.IncludesKeys {
+		printf("The backup includes this node's keys. Anyone with it and its passphrase can impersonate this node.\n")
+	}
+	return nil
+}
+
+func runBackupRestore(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", backupRestoreUsage)
+	}
+	archive, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	pass, err := readBackupPassphrase(false)
+	if err != nil {
+		return err
+	}
+	b, err := ipn.OpenBackup(archive, pass)
+	if err != nil {
+		return err
+	}
+	if err := localClient.RestoreBackup(ctx, b); err != nil {
+		return err
+	}
+	printf("Restored %d profile(s) and %d cert(s) from the backup of %s.\n", len(b.Profiles), len(b.Certs), b.Created.Local().Format("2006-01-02 15:04"))
+	if !b.IncludesKeys {
+		printf("The backup has no node keys, so this node must log in again.\n")
+	}
+	return nil
+}
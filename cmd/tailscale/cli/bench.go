@@ -0,0 +1,138 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/cmd/tailscale/cli/ffcomplete"
+)
+
+var benchCmd = &ffcli.Command{
+	Name:       "bench",
+	ShortUsage: "tailscale bench [--time=5s] [--direction=both] [--json] <hostname-or-IP>",
+	ShortHelp:  "Measure throughput and latency under load to a peer",
+	LongHelp: strings.TrimSpace(`
+
+The 'tailscale bench' command measures the throughput of the WireGuard tunnel
+to a peer and its latency under load, to check MTU and offload tuning without
+installing a tool such as iperf on both ends.
+
+It first measures the idle round-trip time with TSMP pings. It then sends data
+to the peer's peerapi server and receives data from it, for the --time each,
+while pinging it again. The report says whether the peer was reached directly
+or through a DERP relay, which often limits throughput.
+
+The peer must be running a version of Tailscale that supports it, and allow
+this node to debug it: it must be owned by the same user, or grant the
+"https://tailscale.com/cap/debug-peer" capability.
+
+`),
+	Exec: runBench,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("bench")
+		fs.DurationVar(&benchArgs.duration, "time", 5*time.Second, "how long to transfer data in each direction, up to 30s")
+		fs.StringVar(&benchArgs.direction, "direction", "both", `which transfers to test: "upload" (to the peer), "download" (from the peer) or "both"`)
+		fs.BoolVar(&benchArgs.json, "json", false, "output in JSON format")
+		return fs
+	})(),
+}
+
+func init() {
+	ffcomplete.Args(benchCmd, func(args []string) ([]string, ffcomplete.ShellCompDirective, error) {
+		if len(args) > 1 {
+			return nil, ffcomplete.ShellCompDirectiveNoFileComp, nil
+		}
+		return completeHostOrIP(ffcomplete.LastArg(args))
+	})
+}
+
+var benchArgs struct {
+	duration  time.Duration
+	direction string
+	json      bool
+}
+
+func runBench(ctx context.Context, args []string) error {
+	if len(args) != 1 || args[0] == "" {
+		return errors.New("usage: tailscale bench <hostname-or-IP>")
+	}
+	ipStr, self, err := tailscaleIPFromArg(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if self {
+		return fmt.Errorf("%v is local Tailscale IP", ipStr)
+	}
+	ip, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		return err
+	}
+	if !benchArgs.json {
+		printf("Measuring for about %v...\n", benchDuration())
+	}
+	res, err := localClient.Bench(ctx, ip, benchArgs.duration, benchArgs.direction)
+	if err != nil {
+		return err
+	}
+	if benchArgs.json {
+		j, err := json.MarshalIndent(res, "", "  ")
+		if err != nil {
+			return err
+		}
+		printf("%s\n", j)
+		return nil
+	}
+
+	printf("bench to %s (%v) via %s\n", strings.TrimSuffix(res.NodeName, "."), res.NodeIP, res.Path)
+	printf("idle latency:  %s\n", benchLatencyString(res.IdleLatency))
+	for _, p := range []struct {
+		name  string
+		phase *apitype.BenchPhase
+	}{
+		{"upload:  ", res.Upload},
+		{"download:", res.Download},
+	} {
+		if p.phase == nil {
+			continue
+		}
+		printf("%s     %.1f Mbit/s (%.1f MB in %.1fs); latency under load %s\n",
+			p.name, p.phase.BitsPerSecond/1e6, float64(p.phase.Bytes)/1e6, p.phase.Seconds,
+			benchLatencyString(p.phase.Latency))
+	}
+	if res.PathAfter != res.Path {
+		printf("# The path changed during the test; it ended via %s.\n", res.PathAfter)
+	}
+	if strings.HasPrefix(res.Path, "DERP(") || strings.HasPrefix(res.PathAfter, "DERP(") {
+		printf("# Traffic went through a DERP relay, which limits throughput; see 'tailscale netcheck'.\n")
+	}
+	return nil
+}
+
+// benchDuration estimates how long the test will take.
+func benchDuration() time.Duration {
+	if benchArgs.direction == "both" {
+		return 2 * benchArgs.duration
+	}
+	return benchArgs.duration
+}
+
+func benchLatencyString(l apitype.BenchLatency) string {
+	if l.Pongs == 0 {
+		return fmt.Sprintf("no replies to %d pings", l.Pings)
+	}
+	ms := func(s float64) time.Duration {
+		return time.Duration(s * float64(time.Second)).Round(10 * time.Microsecond)
+	}
+	return fmt.Sprintf("%v avg (min %v, max %v), %d/%d pongs", ms(l.AvgSeconds), ms(l.MinSeconds), ms(l.MaxSeconds), l.Pongs, l.Pings)
+}
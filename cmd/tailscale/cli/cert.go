@@ -26,16 +26,18 @@
 )
 
 var certCmd = &ffcli.Command{
-	Name:       "cert",
-	Exec:       runCert,
-	ShortHelp:  "Get TLS certs",
-	ShortUsage: "tailscale cert [flags] <domain>",
+	Name:      "cert",
+	Exec:      runCert,
+	ShortHelp: "Get TLS certs",
+	ShortUsage: "tailscale cert [flags] <domain>\n" +
+		"tailscale cert --status [domain]",
 	FlagSet: (func() *flag.FlagSet {
 		fs := newFlagSet("cert")
 		fs.StringVar(&certArgs.certFile, "cert-file", "", "output cert file or \"-\" for stdout; defaults to DOMAIN.crt if --cert-file and --key-file are both unset")
 		fs.StringVar(&certArgs.keyFile, "key-file", "", "output key file or \"-\" for stdout; defaults to DOMAIN.key if --cert-file and --key-file are both unset")
 		fs.BoolVar(&certArgs.serve, "serve-demo", false, "if true, serve on port :443 using the cert as a demo, instead of writing out the files to disk")
 		fs.DurationVar(&certArgs.minValidity, "min-validity", 0, "ensure the certificate is valid for at least this duration; the output certificate is never expired if this flag is unset or 0, but the lifetime may vary; the maximum allowed min-validity depends on the CA")
+		fs.BoolVar(&certArgs.status, "status", false, "if true, print the status of the background renewal of this machine's certs, instead of getting a cert")
 		return fs
 	})(),
 }
@@ -45,9 +47,13 @@
 	keyFile     string
 	serve       bool
 	minValidity time.Duration
+	status      bool
 }
 
 func runCert(ctx context.Context, args []string) error {
+	if certArgs.status {
+		return runCertStatus(ctx, args)
+	}
 	if certArgs.serve {
 		s := &http.Server{
 			Addr: ":443",
@@ -160,6 +166,59 @@ func runCert(ctx context.Context, args []string) error {
 	return nil
 }
 
+func runCertStatus(ctx context.Context, args []string) error {
+	if len(args) > 1 {
+		return errors.New("too many arguments; max 1 allowed with --status (the domain)")
+	}
+	sts, err := localClient.CertStatus(ctx)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, st := range sts {
+		if len(args) == 1 && st.Domain != args[0] {
+			continue
+		}
+		found = true
+		printf("%s:\n", st.Domain)
+		switch {
+		case st.Expired:
+			printf("  expired\n")
+		case !st.NotAfter.IsZero():
+			printf("  expires %v\n", st.NotAfter.Local().Format(time.RFC3339))
+		}
+		if !st.RenewAt.IsZero() {
+			printf("  renewal due %v\n", st.RenewAt.Local().Format(time.RFC3339))
+		}
+		if !st.LastRenewed.IsZero() {
+			printf("  last renewed %v\n", st.LastRenewed.Local().Format(time.RFC3339))
+		}
+		if !st.LastChecked.IsZero() {
+			printf("  last checked %v\n", st.LastChecked.Local().Format(time.RFC3339))
+		}
+		if st.LastError != "" {
+			printf("  last renewal failed: %s\n", st.LastError)
+		}
+		if st.OCSPStapled {
+			printf("  OCSP stapled")
+			if !st.OCSPNextUpdate.IsZero() {
+				printf(" until %v", st.OCSPNextUpdate.Local().Format(time.RFC3339))
+			}
+			outln()
+		}
+		if st.OCSPError != "" {
+			printf("  OCSP: %s\n", st.OCSPError)
+		}
+	}
+	if !found {
+		if len(args) == 1 {
+			return fmt.Errorf("no cert status for %q; its cert hasn't been requested or checked yet", args[0])
+		}
+		outln("No certs have been requested or checked yet.")
+	}
+	return nil
+}
+
 func writeIfChanged(filename string, contents []byte, mode os.FileMode) (changed bool, err error) {
 	if filename == "-" {
 		Stdout.Write(contents)
@@ -199,6 +199,7 @@ func newRootCmd() *ffcli.Command {
 			statusCmd,
 			metricsCmd,
 			pingCmd,
+			benchCmd,
 			ncCmd,
 			sshCmd,
 			funnelCmd(),
@@ -213,8 +214,10 @@ func newRootCmd() *ffcli.Command {
 			exitNodeCmd(),
 			updateCmd,
 			whoisCmd,
+			aclCmd,
 			debugCmd(),
 			driveCmd,
+			backupCmd,
 			idTokenCmd,
 			advertiseCmd(),
 			configureHostCmd(),
@@ -739,6 +739,28 @@ func TestPrefsFromUpArgs(t *testing.T) {
 			},
 			wantErr: `invalid value "foo" for --exit-node; must be IP or unique node name`,
 		},
+		{
+			name: "auto_exit_node",
+			args: upArgsFromOSArgs("linux", "--exit-node=auto:online,tag:exit"),
+			want: &ipn.Prefs{
+				ControlURL:          ipn.DefaultControlURL,
+				WantRunning:         true,
+				CorpDNS:             true,
+				AutoExitNode:        "online,tag:exit",
+				NoStatefulFiltering: "true",
+				NetfilterMode:       preftype.NetfilterOn,
+				AutoUpdate: ipn.AutoUpdatePrefs{
+					Check: true,
+				},
+			},
+		},
+		{
+			name: "error_auto_exit_node_bad_expression",
+			args: upArgsT{
+				exitNodeIP: "auto:fastest",
+			},
+			wantErr: `invalid --exit-node: invalid exit node expression "fastest": unknown term "fastest"`,
+		},
 		{
 			name: "error_exit_node_allow_lan_without_exit_node",
 			args: upArgsT{
@@ -1051,6 +1073,7 @@ func TestUpdatePrefs(t *testing.T) {
 				AdvertiseRoutesSet:        true,
 				AdvertiseTagsSet:          true,
 				AppConnectorSet:           true,
+				AutoExitNodeSet:           true,
 				ControlURLSet:             true,
 				CorpDNSSet:                true,
 				ExitNodeAllowLANAccessSet: true,
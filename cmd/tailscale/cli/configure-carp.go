@@ -0,0 +1,169 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/net/carp"
+)
+
+// carpIfwatchdScript is the path of the script generated for NetBSD's
+// ifwatchd.
+const carpIfwatchdScript = "/etc/ifwatchd-tailscale-carp"
+
+var carpArgs struct {
+	goos string
+	vhid string
+}
+
+func carpCmd() *ffcli.Command {
+	switch runtime.GOOS {
+	case "freebsd", "openbsd", "netbsd", "dragonfly":
+	default:
+		return nil
+	}
+	return &ffcli.Command{
+		Name:       "carp",
+		Exec:       runConfigureCARP,
+		ShortUsage: "tailscale configure carp --vhid=<vhid@interface|carpN>",
+		ShortHelp:  "Generate hooks handing subnet routes over on CARP failover",
+		LongHelp: strings.TrimSpace(`
+For a pair of subnet routers sharing LAN addresses over CARP, this command
+prints the configuration for the system's event daemon (devd on FreeBSD and
+DragonFly, ifstated on OpenBSD, ifwatchd on NetBSD) that tells tailscaled
+whenever the CARP virtual host changes state. Only the master advertises
+its subnet routes, so that peers follow the LAN addresses.
+
+tailscaled must also run with --carp set to the same virtual host; it
+polls the state in case a hook is missed.
+`),
+		FlagSet: (func() *flag.FlagSet {
+			fs := newFlagSet("carp")
+			fs.StringVar(&carpArgs.goos, "os", runtime.GOOS, `operating system to generate the configuration for: "freebsd", "dragonfly", "netbsd" or "openbsd"`)
+			fs.StringVar(&carpArgs.vhid, "vhid", "", `CARP virtual host, as "vhid@interface" (FreeBSD) or a carp interface name (others)`)
+			return fs
+		})(),
+		Subcommands: []*ffcli.Command{
+			{
+				Name:       "notify",
+				Exec:       runCARPNotify,
+				ShortUsage: "tailscale configure carp notify <MASTER|BACKUP|INIT>",
+				ShortHelp:  "Tell tailscaled the CARP state changed (run by the hooks)",
+				FlagSet:    newFlagSet("notify"),
+			},
+		},
+	}
+}
+
+// genCARPHooks returns the event daemon configuration reporting the
+// state of the CARP virtual host spec to tailscaled, which is run as
+// tailscalePath, along with the file it belongs in.
+func genCARPHooks(goos, tailscalePath string, spec carp.Spec) (file, conf string, err error) {
+	switch goos {
+	case "freebsd", "dragonfly":
+		// devd reports CARP events with subsystem "vhid@interface"
+		// and type MASTER, BACKUP or INIT.
+		subsystem := spec.String()
+		if spec.VHID == 0 {
+			subsystem = "[0-9]+@" + spec.Interface
+		}
+		return "/usr/local/etc/devd/tailscale-carp.conf", fmt.Sprintf(`# Generated by "tailscale configure carp".
+notify 0 {
+	match "system" "CARP";
+	match "subsystem" "%s";
+	action "%s configure carp notify $type";
+};
+`, subsystem, tailscalePath), nil
+	case "openbsd":
+		return "/etc/ifstated.conf", fmt.Sprintf(`# Generated by "tailscale configure carp".
+ts_master = "%[1]s.link.up"
+
+init-state auto
+
+state auto {
+	if $ts_master {
+		set-state master
+	}
+	if ! $ts_master {
+		set-state backup
+	}
+}
+
+state master {
+	init {
+		run "%[2]s configure carp notify MASTER"
+	}
+	if ! $ts_master {
+		set-state backup
+	}
+}
+
+state backup {
+	init {
+		run "%[2]s configure carp notify BACKUP"
+	}
+	if $ts_master {
+		set-state master
+	}
+}
+`, spec.Interface, tailscalePath), nil
+	case "netbsd":
+		// ifwatchd runs the script, with the interface name as its
+		// first argument, when the carp interface's link goes up or
+		// down, which it does on becoming master or leaving that state.
+		return carpIfwatchdScript, fmt.Sprintf(`#!/bin/sh
+# Generated by "tailscale configure carp".
+state=$(ifconfig "$1" | sed -n 's/.*carp: \([A-Z]*\).*/\1/p')
+exec %q configure carp notify "${state:-INIT}" >/dev/null 2>&1
+`, tailscalePath), nil
+	}
+	return "", "", fmt.Errorf("CARP hooks are not supported on %q", goos)
+}
+
+func runConfigureCARP(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unknown arguments")
+	}
+	if carpArgs.vhid == "" {
+		return errors.New("--vhid is required")
+	}
+	spec, err := carp.ParseSpec(carpArgs.vhid)
+	if err != nil {
+		return err
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	file, conf, err := genCARPHooks(carpArgs.goos, self, spec)
+	if err != nil {
+		return err
+	}
+	printf("# Add to %s:\n\n%s", file, conf)
+	if carpArgs.goos == "netbsd" {
+		s := carpIfwatchdScript
+		printf("\n# and to /etc/rc.conf:\n#\n# ifwatchd=YES\n# ifwatchd_flags=\"-u %s -d %s %s\"\n", s, s, spec.Interface)
+	}
+	printf("\n# and run tailscaled with --carp=%v\n", spec)
+	return nil
+}
+
+func runCARPNotify(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tailscale configure carp notify <MASTER|BACKUP|INIT>")
+	}
+	st, err := carp.ParseState(args[0])
+	if err != nil {
+		return err
+	}
+	return localClient.SetCARPState(ctx, string(st))
+}
@@ -0,0 +1,63 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"strings"
+	"testing"
+
+	"tailscale.com/net/carp"
+)
+
+func TestGenCARPHooks(t *testing.T) {
+	tests := []struct {
+		goos     string
+		spec     carp.Spec
+		wantFile string
+		want     []string
+	}{
+		{
+			goos:     "freebsd",
+			spec:     carp.Spec{Interface: "em0", VHID: 3},
+			wantFile: "/usr/local/etc/devd/tailscale-carp.conf",
+			want:     []string{`match "subsystem" "3@em0";`, `action "/usr/local/bin/tailscale configure carp notify $type";`},
+		},
+		{
+			goos:     "dragonfly",
+			spec:     carp.Spec{Interface: "em0"},
+			wantFile: "/usr/local/etc/devd/tailscale-carp.conf",
+			want:     []string{`match "subsystem" "[0-9]+@em0";`},
+		},
+		{
+			goos:     "openbsd",
+			spec:     carp.Spec{Interface: "carp1"},
+			wantFile: "/etc/ifstated.conf",
+			want:     []string{`ts_master = "carp1.link.up"`, `run "/usr/local/bin/tailscale configure carp notify BACKUP"`},
+		},
+		{
+			goos:     "netbsd",
+			spec:     carp.Spec{Interface: "carp0"},
+			wantFile: carpIfwatchdScript,
+			want:     []string{`exec "/usr/local/bin/tailscale" configure carp notify "${state:-INIT}"`},
+		},
+	}
+	for _, tt := range tests {
+		file, conf, err := genCARPHooks(tt.goos, "/usr/local/bin/tailscale", tt.spec)
+		if err != nil {
+			t.Errorf("%s: %v", tt.goos, err)
+			continue
+		}
+		if file != tt.wantFile {
+			t.Errorf("%s: file = %q, want %q", tt.goos, file, tt.wantFile)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(conf, want) {
+				t.Errorf("%s: config doesn't contain %q:\n%s", tt.goos, want, conf)
+			}
+		}
+	}
+	if _, _, err := genCARPHooks("linux", "tailscale", carp.Spec{Interface: "eth0"}); err == nil {
+		t.Errorf("linux: got no error")
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+var dhcpcdHookArgs struct {
+	dir       string
+	ifwatchd  bool
+	uninstall bool
+}
+
+// dhcpcdHookName is the name of the hook file installed in dhcpcd's hook
+// directory. dhcpcd-run-hooks sources hooks in lexical order; run late,
+// after the resolv.conf hook has done its work.
+const dhcpcdHookName = "99-tailscale"
+
+// ifwatchdScript is the path of the script installed for NetBSD's ifwatchd.
+const ifwatchdScript = "/etc/ifwatchd-tailscale"
+
+func dhcpcdHookCmd() *ffcli.Command {
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd", "netbsd", "dragonfly":
+	default:
+		return nil
+	}
+	return &ffcli.Command{
+		Name:       "dhcpcd-hook",
+		Exec:       runConfigureDHCPCDHook,
+		ShortUsage: "tailscale configure dhcpcd-hook [--ifwatchd] [--uninstall]",
+		ShortHelp:  "Install hooks telling tailscaled about DHCP lease and link changes",
+		LongHelp: strings.TrimSpace(`
+This command installs a dhcpcd hook that tells tailscaled whenever dhcpcd
+obtains, renews or loses a lease or sees a link go up or down, so that
+tailscaled rebinds and reconfigures DNS immediately rather than waiting to
+notice the change on its own.
+
+With --ifwatchd (NetBSD only), it also installs a script for ifwatchd(8)
+and prints the rc.conf settings needed to use it.
+`),
+		FlagSet: (func() *flag.FlagSet {
+			fs := newFlagSet("dhcpcd-hook")
+			fs.StringVar(&dhcpcdHookArgs.dir, "hook-dir", "", "dhcpcd hook directory; if empty, it's detected")
+			fs.BoolVar(&dhcpcdHookArgs.ifwatchd, "ifwatchd", false, "also install an ifwatchd script (NetBSD)")
+			fs.BoolVar(&dhcpcdHookArgs.uninstall, "uninstall", false, "remove the installed hooks")
+			return fs
+		})(),
+		Subcommands: []*ffcli.Command{
+			{
+				Name:       "notify",
+				Exec:       runDHCPCDHookNotify,
+				ShortUsage: "tailscale configure dhcpcd-hook notify [reason]",
+				ShortHelp:  "Tell tailscaled the network changed (run by the installed hooks)",
+				FlagSet:    newFlagSet("notify"),
+			},
+		},
+	}
+}
+
+// dhcpcdHookDirs are the directories dhcpcd looks for hooks in, depending on
+// how it was built and packaged.
+var dhcpcdHookDirs = []string{
+	"/libexec/dhcpcd-hooks",           // NetBSD base
+	"/usr/local/libexec/dhcpcd-hooks", // FreeBSD ports, OpenBSD packages
+	"/usr/pkg/libexec/dhcpcd-hooks",   // pkgsrc
+	"/usr/lib/dhcpcd/dhcpcd-hooks",    // Debian and derivatives
+	"/usr/libexec/dhcpcd-hooks",
+	"/lib/dhcpcd/dhcpcd-hooks",
+}
+
+func findDHCPCDHookDir() (string, error) {
+	for _, d := range dhcpcdHookDirs {
+		if fi, err := os.Stat(d); err == nil && fi.IsDir() {
+			return d, nil
+		}
+	}
+	return "", errors.New("no dhcpcd hook directory found; is dhcpcd installed? Use --hook-dir to specify one")
+}
+
+// dhcpcdHook returns the dhcpcd hook script, which runs the tailscale
+// binary at tailscalePath. dhcpcd-run-hooks sources it with $reason and
+// $interface set.
+func dhcpcdHook(tailscalePath string) string {
+	return fmt.Sprintf(`# Installed by "tailscale configure dhcpcd-hook".
+# Tells tailscaled about lease and link changes so it can react immediately.
+case "$interface" in
+tailscale*) ;;
+*)
+	case "$reason" in
+	BOUND|BOUND6|RENEW|RENEW6|REBIND|REBIND6|REBOOT|REBOOT6|EXPIRE|EXPIRE6|NOCARRIER|CARRIER|ROUTERADVERT|STATIC|IPV4LL)
+		%q configure dhcpcd-hook notify "dhcpcd $reason $interface" >/dev/null 2>&1 &
+		;;
+	esac
+	;;
+esac
+`, tailscalePath)
+}
+
+// ifwatchdHook returns the script run by ifwatchd, which passes it the
+// interface name and address as its first and fourth arguments.
+func ifwatchdHook(tailscalePath string) string {
+	return fmt.Sprintf(`#!/bin/sh
+# Installed by "tailscale configure dhcpcd-hook --ifwatchd".
+# Tells tailscaled about link and address changes so it can react immediately.
+exec %q configure dhcpcd-hook notify "ifwatchd $1 $4" >/dev/null 2>&1
+`, tailscalePath)
+}
+
+func runConfigureDHCPCDHook(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unknown arguments")
+	}
+	if uid := os.Getuid(); uid != 0 {
+		return fmt.Errorf("must be run as root, not %q (%v)", os.Getenv("USER"), uid)
+	}
+	if dhcpcdHookArgs.ifwatchd && runtime.GOOS != "netbsd" {
+		return errors.New("--ifwatchd is only supported on NetBSD")
+	}
+	dir := dhcpcdHookArgs.dir
+	if dir == "" {
+		var err error
+		if dir, err = findDHCPCDHookDir(); err != nil {
+			return err
+		}
+	}
+	hookPath := filepath.Join(dir, dhcpcdHookName)
+
+	if dhcpcdHookArgs.uninstall {
+		for _, p := range []string{hookPath, ifwatchdScript} {
+			if err := os.Remove(p); err == nil {
+				printf("removed %s\n", p)
+			} else if !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(hookPath, []byte(dhcpcdHook(self)), 0444); err != nil {
+		return err
+	}
+	printf("installed dhcpcd hook %s\n", hookPath)
+
+	if dhcpcdHookArgs.ifwatchd {
+		if err := os.WriteFile(ifwatchdScript, []byte(ifwatchdHook(self)), 0555); err != nil {
+			return err
+		}
+		printf("installed ifwatchd script %s\n\n", ifwatchdScript)
+		printf("To use it, add the following to /etc/rc.conf, listing the interfaces to watch:\n\n")
+		printf("ifwatchd=YES\n")
+		s := ifwatchdScript
+		printf("ifwatchd_flags=\"-u %s -d %s -c %s -n %s <interfaces>\"\n", s, s, s, s)
+	}
+	return nil
+}
+
+func runDHCPCDHookNotify(ctx context.Context, args []string) error {
+	return localClient.NotifyNetworkChange(ctx, strings.Join(args, " "))
+}
@@ -0,0 +1,35 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDHCPCDHookScripts(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh")
+	}
+	const bin = "/usr/local/bin/tailscale"
+	for name, script := range map[string]string{
+		"dhcpcd":   dhcpcdHook(bin),
+		"ifwatchd": ifwatchdHook(bin),
+	} {
+		if !strings.Contains(script, `"`+bin+`" configure dhcpcd-hook notify`) {
+			t.Errorf("%s hook doesn't run %s:\n%s", name, bin, script)
+		}
+		p := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(p, []byte(script), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if out, err := exec.Command(sh, "-n", p).CombinedOutput(); err != nil {
+			t.Errorf("%s hook has syntax errors: %v\n%s", name, err, out)
+		}
+	}
+}
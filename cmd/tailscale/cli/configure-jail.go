@@ -0,0 +1,137 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"runtime"
+	"strings"
+	"text/template"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/hostinfo"
+)
+
+var jailArgs struct {
+	name         string
+	path         string
+	epair        string
+	devfsRuleset uint
+}
+
+func jailCmd() *ffcli.Command {
+	if runtime.GOOS != "freebsd" {
+		return nil
+	}
+	return &ffcli.Command{
+		Name:       "jail",
+		Exec:       runConfigureJail,
+		ShortUsage: "tailscale configure jail [flags]",
+		ShortHelp:  "Print the jail.conf and devfs.rules settings to run tailscaled in a jail",
+		LongHelp: strings.TrimSpace(`
+This command prints the settings a FreeBSD jail needs to run tailscaled
+with a tun device: a VNET network stack of its own, connected to the host
+by an epair(4) interface, and a devfs ruleset that makes tun devices
+visible inside the jail.
+
+Jails without VNET share the host's network stack; tailscaled runs in them
+only with --tun=userspace-networking.
+`),
+		FlagSet: (func() *flag.FlagSet {
+			fs := newFlagSet("jail")
+			fs.StringVar(&jailArgs.name, "name", "tailscale", "name of the jail")
+			fs.StringVar(&jailArgs.path, "path", "", "root directory of the jail; if empty, /usr/local/jails/<name>")
+			fs.StringVar(&jailArgs.epair, "epair", "epair0", "epair(4) interface connecting the jail to the host; the jail gets its b end")
+			fs.UintVar(&jailArgs.devfsRuleset, "devfs-ruleset", 41641, "number of the devfs ruleset to define for the jail")
+			return fs
+		})(),
+	}
+}
+
+// jailParams are the parameters of the generated jail configuration.
+type jailParams struct {
+	Name         string
+	Path         string
+	Epair        string
+	DevfsRuleset uint
+}
+
+var jailConfTemplate = template.Must(template.New("jail").Parse(`# Add to /boot/loader.conf on the host (jails can't load kernel modules):
+if_tun_load="YES"
+
+# Add to /etc/devfs.rules on the host, then run "service devfs restart":
+[devfsrules_tailscale_jail={{.DevfsRuleset}}]
+add include $devfsrules_jail_vnet
+add path 'tun*' unhide
+add path pf unhide
+
+# Add to /etc/jail.conf on the host. Bridge {{.Epair}}a to your LAN, or
+# route to it, to give the jail connectivity.
+{{.Name}} {
+	path = "{{.Path}}";
+	host.hostname = "{{.Name}}";
+	vnet;
+	vnet.interface = "{{.Epair}}b";
+	devfs_ruleset = {{.DevfsRuleset}};
+	mount.devfs;
+	allow.raw_sockets;
+	exec.prestart = "ifconfig {{.Epair}} create up";
+	exec.poststop = "ifconfig {{.Epair}}a destroy";
+	exec.start = "/bin/sh /etc/rc";
+	exec.stop = "/bin/sh /etc/rc.shutdown";
+}
+`))
+
+// genJailConf returns the host configuration for a jail running tailscaled.
+func genJailConf(p jailParams) ([]byte, error) {
+	if p.Path == "" {
+		p.Path = "/usr/local/jails/" + p.Name
+	}
+	if p.DevfsRuleset == 0 || p.DevfsRuleset > 65535 {
+		return nil, fmt.Errorf("invalid devfs ruleset %d", p.DevfsRuleset)
+	}
+	if !strings.HasPrefix(p.Epair, "epair") {
+		return nil, fmt.Errorf("%q is not an epair interface", p.Epair)
+	}
+	for _, v := range []string{p.Name, p.Path, p.Epair} {
+		if v == "" || strings.ContainsAny(v, " \t\n\"'$`;{}") {
+			return nil, fmt.Errorf("%q is not permitted in jail.conf", v)
+		}
+	}
+	var b bytes.Buffer
+	if err := jailConfTemplate.Execute(&b, p); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func runConfigureJail(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unknown arguments")
+	}
+	conf, err := genJailConf(jailParams{
+		Name:         jailArgs.name,
+		Path:         jailArgs.path,
+		Epair:        jailArgs.epair,
+		DevfsRuleset: jailArgs.devfsRuleset,
+	})
+	if err != nil {
+		return err
+	}
+	switch j := hostinfo.GetJail(); {
+	case j.Jailed && j.VNET:
+		outln("# This system is a VNET jail; apply these settings on its host.")
+		outln()
+	case j.Jailed:
+		outln("# This system is a jail without VNET; tailscaled can only run here with")
+		outln("# --tun=userspace-networking. Apply these settings on its host to fix that.")
+		outln()
+	}
+	Stdout.Write(conf)
+	return nil
+}
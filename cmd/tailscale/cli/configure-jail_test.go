@@ -0,0 +1,37 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenJailConf(t *testing.T) {
+	conf, err := genJailConf(jailParams{Name: "ts", Epair: "epair3", DevfsRuleset: 123})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"[devfsrules_tailscale_jail=123]",
+		"\tdevfs_ruleset = 123;\n",
+		"\tpath = \"/usr/local/jails/ts\";\n",
+		"\tvnet.interface = \"epair3b\";\n",
+		"ifconfig epair3 create up",
+	} {
+		if !strings.Contains(string(conf), want) {
+			t.Errorf("config doesn't contain %q:\n%s", want, conf)
+		}
+	}
+
+	for _, p := range []jailParams{
+		{Name: "ts", Epair: "epair0", DevfsRuleset: 0},
+		{Name: "ts", Epair: "em0", DevfsRuleset: 1},
+		{Name: "ts; x", Epair: "epair0", DevfsRuleset: 1},
+	} {
+		if _, err := genJailConf(p); err == nil {
+			t.Errorf("%+v: got no error", p)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+var rcScriptArgs struct {
+	goos       string
+	tailscaled string
+	stateDir   string
+	port       uint
+	tun        string
+	install    bool
+}
+
+func rcScriptCmd() *ffcli.Command {
+	switch runtime.GOOS {
+	case "freebsd", "openbsd", "netbsd":
+	default:
+		return nil
+	}
+	return &ffcli.Command{
+		Name:       "rc-script",
+		Exec:       runConfigureRCScript,
+		ShortUsage: "tailscale configure rc-script [flags]",
+		ShortHelp:  "Generate an rc script to run tailscaled at boot",
+		LongHelp: strings.TrimSpace(`
+This command prints an rc.d script (FreeBSD, NetBSD) or rc script (OpenBSD)
+that starts tailscaled at boot, followed by the rc.conf settings enabling it.
+With --install, the script is written to the system's rc.d directory.
+
+It also checks that the kernel supports tun devices and whether IP
+forwarding is enabled, as needed for subnet routers and exit nodes.
+`),
+		FlagSet: (func() *flag.FlagSet {
+			fs := newFlagSet("rc-script")
+			fs.StringVar(&rcScriptArgs.goos, "os", runtime.GOOS, `operating system to generate the script for: "freebsd", "netbsd" or "openbsd"`)
+			fs.StringVar(&rcScriptArgs.tailscaled, "tailscaled", "", "path to the tailscaled binary; if empty, it's assumed to be next to this binary")
+			fs.StringVar(&rcScriptArgs.stateDir, "statedir", "", "directory to keep tailscaled state in; if empty, the OS default is used")
+			fs.UintVar(&rcScriptArgs.port, "port", 41641, "UDP port for tailscaled to listen on")
+			fs.StringVar(&rcScriptArgs.tun, "tun", "", "tun device name; if empty, the OS default is used")
+			fs.BoolVar(&rcScriptArgs.install, "install", false, "write the script to the rc.d directory, rather than printing it")
+			return fs
+		})(),
+	}
+}
+
+// rcScriptParams are the parameters of a generated rc script.
+type rcScriptParams struct {
+	Tailscaled string
+	StateDir   string
+	Socket     string
+	Port       uint
+	Tun        string
+}
+
+// rcScriptOS describes the rc system of one OS.
+type rcScriptOS struct {
+	dir      string // where the script is installed
+	stateDir string // default state directory
+	tun      string // default tun device name
+	script   *template.Template
+	rcConf   *template.Template
+}
+
+var rcScriptOSes = map[string]rcScriptOS{
+	"freebsd": {
+		dir:      "/usr/local/etc/rc.d",
+		stateDir: "/var/db/tailscale",
+		tun:      "tailscale0",
+		script: template.Must(template.New("freebsd").Parse(`#!/bin/sh
+#
+# Generated by "tailscale configure rc-script".
+
+# PROVIDE: tailscaled
+# REQUIRE: NETWORKING
+# KEYWORD: shutdown
+
+. /etc/rc.subr
+
+name="tailscaled"
+rcvar="tailscaled_enable"
+
+load_rc_config $name
+
+: ${tailscaled_enable:="NO"}
+: ${tailscaled_state_dir:="{{.StateDir}}"}
+: ${tailscaled_port:="{{.Port}}"}
+: ${tailscaled_tun_dev:="{{.Tun}}"}
+
+pidfile="/var/run/${name}.pid"
+procname="{{.Tailscaled}}"
+command="/usr/sbin/daemon"
+command_args="-f -p ${pidfile} ${procname} --statedir=${tailscaled_state_dir} --socket={{.Socket}} --port=${tailscaled_port} --tun=${tailscaled_tun_dev}"
+start_precmd="tailscaled_prestart"
+stop_postcmd="tailscaled_poststop"
+
+tailscaled_prestart()
+{
+	mkdir -p "${tailscaled_state_dir}" "$(dirname {{.Socket}})"
+}
+
+tailscaled_poststop()
+{
+	${procname} --cleanup --tun=${tailscaled_tun_dev}
+}
+
+run_rc_command "$1"
+`)),
+		rcConf: template.Must(template.New("freebsd.conf").Parse(`tailscaled_enable="YES"
+`)),
+	},
+	"netbsd": {
+		dir:      "/etc/rc.d",
+		stateDir: "/var/db/tailscale",
+		tun:      "tun0",
+		script: template.Must(template.New("netbsd").Parse(`#!/bin/sh
+#
+# Generated by "tailscale configure rc-script".
+
+# PROVIDE: tailscaled
+# REQUIRE: NETWORKING
+# KEYWORD: shutdown
+
+$_rc_subr_loaded . /etc/rc.subr
+
+name="tailscaled"
+rcvar=$name
+command="{{.Tailscaled}}"
+pidfile="/var/run/${name}.pid"
+start_precmd="tailscaled_prestart"
+stop_postcmd="tailscaled_poststop"
+
+load_rc_config $name
+
+: ${tailscaled_state_dir:="{{.StateDir}}"}
+: ${tailscaled_port:="{{.Port}}"}
+: ${tailscaled_tun_dev:="{{.Tun}}"}
+
+# tailscaled runs in the foreground, so background it here.
+command_args="--statedir=${tailscaled_state_dir} --socket={{.Socket}} --port=${tailscaled_port} --tun=${tailscaled_tun_dev} >/dev/null 2>&1 & echo \$! >${pidfile}"
+
+tailscaled_prestart()
+{
+	mkdir -p "${tailscaled_state_dir}" "$(dirname {{.Socket}})"
+}
+
+tailscaled_poststop()
+{
+	${command} --cleanup --tun=${tailscaled_tun_dev}
+}
+
+run_rc_command "$1"
+`)),
+		rcConf: template.Must(template.New("netbsd.conf").Parse(`tailscaled=YES
+`)),
+	},
+	"openbsd": {
+		dir:      "/etc/rc.d",
+		stateDir: "/var/db/tailscale",
+		tun:      "tun0",
+		script: template.Must(template.New("openbsd").Parse(`#!/bin/ksh
+#
+# Generated by "tailscale configure rc-script".
+
+daemon="{{.Tailscaled}}"
+daemon_flags="--statedir={{.StateDir}} --socket={{.Socket}} --port={{.Port}} --tun={{.Tun}}"
+
+. /etc/rc.d/rc.subr
+
+rc_bg=YES
+rc_reload=NO
+
+rc_pre() {
+	mkdir -p {{.StateDir}} "$(dirname {{.Socket}})"
+}
+
+rc_post() {
+	${daemon} --cleanup --tun={{.Tun}}
+}
+
+rc_cmd $1
+`)),
+		rcConf: template.Must(template.New("openbsd.conf").Parse(`pkg_scripts="${pkg_scripts} tailscaled"
+`)),
+	},
+}
+
+// genRCScript returns the rc script and rc.conf lines for goos.
+func genRCScript(goos string, p rcScriptParams) (script, rcConf []byte, err error) {
+	o, ok := rcScriptOSes[goos]
+	if !ok {
+		return nil, nil, fmt.Errorf("rc scripts are not supported on %q", goos)
+	}
+	if p.StateDir == "" {
+		p.StateDir = o.stateDir
+	}
+	if p.Tun == "" {
+		p.Tun = o.tun
+	}
+	if p.Socket == "" {
+		p.Socket = "/var/run/tailscale/tailscaled.sock"
+	}
+	if p.Port > 65535 {
+		return nil, nil, fmt.Errorf("invalid port %d", p.Port)
+	}
+	for _, v := range []string{p.Tailscaled, p.StateDir, p.Tun} {
+		if strings.ContainsAny(v, " \t\n\"'$`\\;&|") {
+			return nil, nil, fmt.Errorf("%q contains characters not permitted in rc scripts", v)
+		}
+	}
+	var sb, cb bytes.Buffer
+	if err := o.script.Execute(&sb, p); err != nil {
+		return nil, nil, err
+	}
+	if err := o.rcConf.Execute(&cb, p); err != nil {
+		return nil, nil, err
+	}
+	return sb.Bytes(), cb.Bytes(), nil
+}
+
+// rcScriptWarnings checks the running system for problems that would stop
+// tailscaled from working well, returning a description of each.
+func rcScriptWarnings(goos string) []string {
+	var warns []string
+	if !tunSupported(goos) {
+		warns = append(warns, "the kernel doesn't appear to support tun devices; tailscaled must run with --tun=userspace-networking")
+	}
+	for _, key := range []string{"net.inet.ip.forwarding", "net.inet6.ip6.forwarding"} {
+		out, err := exec.Command("sysctl", "-n", key).Output()
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(string(out)) == "0" {
+			warns = append(warns, fmt.Sprintf("%s is 0; set %s=1 in /etc/sysctl.conf to use this node as a subnet router or exit node", key, key))
+		}
+	}
+	return warns
+}
+
+// tunSupported reports whether the running kernel supports tun devices.
+func tunSupported(goos string) bool {
+	if goos == "freebsd" {
+		// The module is either compiled in or loadable; kldstat knows
+		// about both.
+		return exec.Command("kldstat", "-q", "-m", "if_tun").Run() == nil
+	}
+	_, err := os.Stat("/dev/tun0")
+	return err == nil
+}
+
+func runConfigureRCScript(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unknown arguments")
+	}
+	goos := rcScriptArgs.goos
+	ts := rcScriptArgs.tailscaled
+	if ts == "" {
+		self, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		ts = filepath.Join(filepath.Dir(self), "tailscaled")
+	}
+	script, rcConf, err := genRCScript(goos, rcScriptParams{
+		Tailscaled: ts,
+		StateDir:   rcScriptArgs.stateDir,
+		Port:       rcScriptArgs.port,
+		Tun:        rcScriptArgs.tun,
+	})
+	if err != nil {
+		return err
+	}
+
+	if goos == runtime.GOOS {
+		if _, err := os.Stat(ts); err != nil {
+			fmt.Fprintln(Stderr, "warning:", err)
+		}
+		for _, w := range rcScriptWarnings(goos) {
+			fmt.Fprintln(Stderr, "warning:", w)
+		}
+	}
+
+	conf := "/etc/rc.conf"
+	if goos == "openbsd" {
+		conf = "/etc/rc.conf.local"
+	}
+	if !rcScriptArgs.install {
+		Stdout.Write(script)
+		printf("\n# Add to %s:\n", conf)
+		for _, l := range strings.SplitAfter(string(rcConf), "\n") {
+			if l != "" {
+				printf("# %s", l)
+			}
+		}
+		return nil
+	}
+
+	if uid := os.Getuid(); uid != 0 {
+		return fmt.Errorf("must be run as root, not %q (%v)", os.Getenv("USER"), uid)
+	}
+	dst := filepath.Join(rcScriptOSes[goos].dir, "tailscaled")
+	if err := os.WriteFile(dst, script, 0555); err != nil {
+		return err
+	}
+	printf("installed %s\n\nTo start tailscaled at boot, add to %s:\n\n%s", dst, conf, rcConf)
+	return nil
+}
@@ -0,0 +1,51 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenRCScript(t *testing.T) {
+	sh, _ := exec.LookPath("sh")
+	for _, goos := range []string{"freebsd", "netbsd", "openbsd"} {
+		script, rcConf, err := genRCScript(goos, rcScriptParams{
+			Tailscaled: "/opt/bin/tailscaled",
+			Port:       1234,
+			Tun:        "tun7",
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", goos, err)
+		}
+		for _, want := range []string{"/opt/bin/tailscaled", "1234", "tun7", "/var/db/tailscale", "--cleanup"} {
+			if !strings.Contains(string(script), want) {
+				t.Errorf("%s: script doesn't contain %q:\n%s", goos, want, script)
+			}
+		}
+		if !strings.Contains(string(rcConf), "tailscaled") {
+			t.Errorf("%s: rc.conf lines don't mention tailscaled: %q", goos, rcConf)
+		}
+		if sh == "" {
+			continue
+		}
+		p := filepath.Join(t.TempDir(), "tailscaled")
+		if err := os.WriteFile(p, script, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if out, err := exec.Command(sh, "-n", p).CombinedOutput(); err != nil {
+			t.Errorf("%s: script has syntax errors: %v\n%s", goos, err, out)
+		}
+	}
+
+	if _, _, err := genRCScript("linux", rcScriptParams{}); err == nil {
+		t.Error("linux: got no error")
+	}
+	if _, _, err := genRCScript("freebsd", rcScriptParams{Tailscaled: "/bin/x; rm -rf /"}); err == nil {
+		t.Error("unsafe tailscaled path: got no error")
+	}
+}
@@ -27,6 +27,10 @@ func configureCmd() *ffcli.Command {
 			configureKubeconfigCmd(),
 			synologyConfigureCmd(),
 			synologyConfigureCertCmd(),
+			dhcpcdHookCmd(),
+			rcScriptCmd(),
+			jailCmd(),
+			carpCmd(),
 			ccall(maybeSysExtCmd),
 			ccall(maybeVPNConfigCmd),
 		),
@@ -6,12 +6,18 @@
 package cli
 
 import (
+	"bufio"
 	"context"
+	"encoding/binary"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/feature/capture/dissector"
@@ -24,23 +30,54 @@ func init() {
 func mkDebugCaptureCmd() *ffcli.Command {
 	return &ffcli.Command{
 		Name:       "capture",
-		ShortUsage: "tailscale debug capture",
+		ShortUsage: "tailscale debug capture [flags] [filter expression]",
 		Exec:       runCapture,
 		ShortHelp:  "Stream pcaps for debugging",
+		LongHelp: strings.TrimSpace(`
+The 'tailscale debug capture' command streams the packets going through
+tailscaled as a pcap, to a file or to Wireshark.
+
+The optional filter expression selects which of the inner (decrypted) packets
+to capture, with a subset of the tcpdump syntax: "[src|dst] host ADDR",
+"[src|dst] net PREFIX", "[src|dst] port PORT", "proto PROTO", "ip", "ip6",
+"tcp", "udp", "icmp", "icmp6" and "disco", combined with "and", "or", "not"
+and parentheses. For example:
+
+  tailscale debug capture -o ssh.pcap tcp port 22 and host 100.101.102.103
+
+With --max-size, the output file is rotated once it reaches that size: the
+files are named like the -o path with a sequence number added before its
+extension. With --max-files too, only that many of the most recent files are
+kept, so a long capture on a busy node doesn't fill the disk.
+`),
 		FlagSet: (func() *flag.FlagSet {
 			fs := newFlagSet("capture")
 			fs.StringVar(&captureArgs.outFile, "o", "", "path to stream the pcap (or - for stdout), leave empty to start wireshark")
+			fs.IntVar(&captureArgs.maxSizeMB, "max-size", 0, "if non-zero, rotate the -o file when it reaches this many megabytes")
+			fs.IntVar(&captureArgs.maxFiles, "max-files", 0, "if non-zero, with --max-size, keep only this many of the most recent files")
 			return fs
 		})(),
 	}
 }
 
 var captureArgs struct {
-	outFile string
+	outFile   string
+	maxSizeMB int
+	maxFiles  int
 }
 
 func runCapture(ctx context.Context, args []string) error {
-	stream, err := localClient.StreamDebugCapture(ctx)
+	rotate := captureArgs.maxSizeMB > 0
+	switch {
+	case captureArgs.maxSizeMB < 0 || captureArgs.maxFiles < 0:
+		return errors.New("--max-size and --max-files must not be negative")
+	case captureArgs.maxFiles > 0 && !rotate:
+		return errors.New("--max-files requires --max-size")
+	case rotate && (captureArgs.outFile == "" || captureArgs.outFile == "-"):
+		return errors.New("--max-size requires -o with a file path")
+	}
+
+	stream, err := localClient.StreamDebugCaptureFilter(ctx, strings.Join(args, " "))
 	if err != nil {
 		return err
 	}
@@ -69,6 +106,16 @@ func runCapture(ctx context.Context, args []string) error {
 		return wireshark.Run()
 	}
 
+	if rotate {
+		fmt.Fprintln(Stderr, "Press Ctrl-C to stop the capture.")
+		r := &pcapRotator{
+			path:     captureArgs.outFile,
+			maxSize:  int64(captureArgs.maxSizeMB) << 20,
+			maxFiles: captureArgs.maxFiles,
+		}
+		return r.copy(stream)
+	}
+
 	f, err := os.OpenFile(captureArgs.outFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		return err
@@ -78,3 +125,103 @@ func runCapture(ctx context.Context, args []string) error {
 	_, err = io.Copy(f, stream)
 	return err
 }
+
+// Sizes of the headers of a pcap stream.
+const (
+	pcapFileHeaderLen   = 24
+	pcapRecordHeaderLen = 16
+)
+
+// pcapRotator writes a pcap stream to a series of files, starting a new one
+// before a file would exceed maxSize. Each file starts with the stream's
+// file header, so it can be read on its own.
+type pcapRotator struct {
+	path     string // the -o path, which file names are derived from
+	maxSize  int64
+	maxFiles int // or zero to keep all files
+
+	header [pcapFileHeaderLen]byte
+	seq    int      // sequence number of the current file
+	f      *os.File // or nil before the first file
+	size   int64    // bytes written to f
+}
+
+// fileName returns the name of the file with sequence number seq: path
+// with seq inserted before its extension, as in "capture.3.pcap".
+func (r *pcapRotator) fileName(seq int) string {
+	ext := filepath.Ext(r.path)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(r.path, ext), seq, ext)
+}
+
+// copy reads the pcap stream from src and writes it to the rotated files
+// until src ends.
+func (r *pcapRotator) copy(src io.Reader) error {
+	defer func() {
+		if r.f != nil {
+			r.f.Close()
+		}
+	}()
+	br := bufio.NewReader(src)
+	if _, err := io.ReadFull(br, r.header[:]); err != nil {
+		return err
+	}
+	var rec []byte
+	for {
+		var hdr [pcapRecordHeaderLen]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		n := int(binary.LittleEndian.Uint32(hdr[8:12])) // included length
+		rec = slices.Grow(rec[:0], pcapRecordHeaderLen+n)[:pcapRecordHeaderLen+n]
+		copy(rec, hdr[:])
+		if _, err := io.ReadFull(br, rec[pcapRecordHeaderLen:]); err != nil {
+			return err
+		}
+		if err := r.write(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// write writes the pcap record rec, rotating files first if needed.
+func (r *pcapRotator) write(rec []byte) error {
+	// Always write at least one record per file, however large.
+	if r.f == nil || (r.size > pcapFileHeaderLen && r.size+int64(len(rec)) > r.maxSize) {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := r.f.Write(rec)
+	r.size += int64(n)
+	return err
+}
+
+// rotate closes the current file, if any, and starts the next one, removing
+// the oldest file if there are more than maxFiles.
+func (r *pcapRotator) rotate() error {
+	if r.f != nil {
+		if err := r.f.Close(); err != nil {
+			return err
+		}
+		r.seq++
+	}
+	f, err := os.OpenFile(r.fileName(r.seq), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	r.f = f
+	n, err := f.Write(r.header[:])
+	r.size = int64(n)
+	if err != nil {
+		return err
+	}
+	if old := r.seq - r.maxFiles; r.maxFiles > 0 && old >= 0 {
+		if err := os.Remove(r.fileName(old)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ios && !ts_omit_capture
+
+package cli
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPCAPRotator(t *testing.T) {
+	var stream bytes.Buffer
+	header := bytes.Repeat([]byte{'H'}, pcapFileHeaderLen)
+	stream.Write(header)
+	record := func(b byte, n int) []byte {
+		rec := make([]byte, pcapRecordHeaderLen, pcapRecordHeaderLen+n)
+		binary.LittleEndian.PutUint32(rec[8:], uint32(n))
+		binary.LittleEndian.PutUint32(rec[12:], uint32(n))
+		return append(rec, bytes.Repeat([]byte{b}, n)...)
+	}
+	var recs [][]byte
+	for i := range 5 {
+		rec := record(byte('a'+i), 34) // 50 bytes with its header
+		recs = append(recs, rec)
+		stream.Write(rec)
+	}
+
+	dir := t.TempDir()
+	r := &pcapRotator{
+		path:     filepath.Join(dir, "capture.pcap"),
+		maxSize:  pcapFileHeaderLen + 100, // two records per file
+		maxFiles: 2,
+	}
+	if err := r.copy(&stream); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]byte{
+		"capture.1.pcap": bytes.Join([][]byte{header, recs[2], recs[3]}, nil),
+		"capture.2.pcap": bytes.Join([][]byte{header, recs[4]}, nil),
+	}
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ents) != len(want) {
+		var names []string
+		for _, e := range ents {
+			names = append(names, e.Name())
+		}
+		t.Fatalf("got files %q; want %d files", names, len(want))
+	}
+	for name, wantData := range want {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, wantData) {
+			t.Errorf("%s = %q; want %q", name, got, wantData)
+		}
+	}
+}
@@ -24,6 +24,7 @@
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
@@ -229,6 +230,12 @@ func debugCmd() *ffcli.Command {
 					return fs
 				})(),
 			},
+			{
+				Name:       "watch-network",
+				ShortUsage: "tailscale debug watch-network",
+				Exec:       runWatchNetwork,
+				ShortHelp:  "Subscribe to tailscaled's network interface and route changes",
+			},
 			{
 				Name:       "netmap",
 				ShortUsage: "tailscale debug netmap",
@@ -291,7 +298,7 @@ func debugCmd() *ffcli.Command {
 			ccall(debugCaptureCmd),
 			{
 				Name:       "portmap",
-				ShortUsage: "tailscale debug portmap",
+				ShortUsage: "tailscale debug portmap [--verify [--verify-peer=<hostname-or-IP>]]",
 				Exec:       debugPortmap,
 				ShortHelp:  "Run portmap debugging",
 				FlagSet: (func() *flag.FlagSet {
@@ -301,6 +308,8 @@ func debugCmd() *ffcli.Command {
 					fs.StringVar(&debugPortmapArgs.gatewayAddr, "gateway-addr", "", `override gateway IP (must also pass --self-addr)`)
 					fs.StringVar(&debugPortmapArgs.selfAddr, "self-addr", "", `override self IP (must also pass --gateway-addr)`)
 					fs.BoolVar(&debugPortmapArgs.logHTTP, "log-http", false, `print all HTTP requests and responses to the log`)
+					fs.BoolVar(&debugPortmapArgs.verify, "verify", false, `once a mapping is obtained, verify that packets arrive through it by having a peer probe it`)
+					fs.StringVar(&debugPortmapArgs.verifyPeer, "verify-peer", "", `hostname or Tailscale IP of the peer to probe the mapping from with --verify (default: any online peer)`)
 					return fs
 				})(),
 			},
@@ -332,6 +341,43 @@ func debugCmd() *ffcli.Command {
 					return fs
 				})(),
 			},
+			{
+				Name:       "tun-caps",
+				ShortUsage: "tailscale debug tun-caps",
+				ShortHelp:  "Print the capabilities of tailscaled's tun device",
+				Exec:       runDebugTUNCaps,
+			},
+			{
+				Name:       "router-changes",
+				ShortUsage: "tailscale debug router-changes",
+				ShortHelp:  "Print the address and route changes skipped by tailscaled's router in dry-run mode",
+				Exec:       runDebugRouterChanges,
+			},
+			{
+				Name:       "routes",
+				ShortUsage: "tailscale debug routes [--json]",
+				ShortHelp:  "Print the addresses and routes tailscaled's router configured",
+				Exec:       runDebugRoutes,
+				FlagSet: (func() *flag.FlagSet {
+					fs := newFlagSet("routes")
+					fs.BoolVar(&debugRoutesArgs.json, "json", false, "output in JSON format")
+					return fs
+				})(),
+			},
+			{
+				Name:       "remote-diag",
+				ShortUsage: "tailscale debug remote-diag [approve|deny [id]]",
+				ShortHelp:  "List or decide the control plane's requests for diagnostics",
+				LongHelp: strings.TrimSpace(`
+With no arguments, 'tailscale debug remote-diag' lists the requests from the
+control plane for diagnostics from this machine that wait for your consent,
+per 'tailscale set --remote-diagnostics=prompt'.
+
+'approve' or 'deny' decides the request with the given ID, or the only one
+pending if no ID is given.
+`),
+				Exec: runRemoteDiag,
+			},
 			{
 				Name:       "go-buildinfo",
 				ShortUsage: "tailscale debug go-buildinfo",
@@ -342,6 +388,48 @@ func debugCmd() *ffcli.Command {
 	}
 }
 
+func runRemoteDiag(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		reqs, err := localClient.RemoteDiagRequests(ctx)
+		if err != nil {
+			return err
+		}
+		if len(reqs) == 0 {
+			outln("No pending requests for diagnostics.")
+			return nil
+		}
+		for _, r := range reqs {
+			printf("%s\t%s\trequested %v\n", r.ID, r.Kind, r.Requested.Local().Format(time.RFC3339))
+		}
+		return nil
+	}
+	var approve bool
+	switch args[0] {
+	case "approve":
+		approve = true
+	case "deny":
+	default:
+		return fmt.Errorf("unknown action %q; want approve or deny", args[0])
+	}
+	var id string
+	switch len(args) {
+	case 1:
+		reqs, err := localClient.RemoteDiagRequests(ctx)
+		if err != nil {
+			return err
+		}
+		if len(reqs) != 1 {
+			return fmt.Errorf("%d requests pending; specify which by ID", len(reqs))
+		}
+		id = reqs[0].ID
+	case 2:
+		id = args[1]
+	default:
+		return errors.New("too many arguments")
+	}
+	return localClient.DecideRemoteDiag(ctx, id, approve)
+}
+
 func runGoBuildInfo(ctx context.Context, args []string) error {
 	bi, ok := debug.ReadBuildInfo()
 	if !ok {
@@ -352,6 +440,89 @@ func runGoBuildInfo(ctx context.Context, args []string) error {
 	return e.Encode(bi)
 }
 
+func runDebugTUNCaps(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	caps, err := localClient.DebugTUNCapabilities(ctx)
+	if err != nil {
+		return err
+	}
+	e := json.NewEncoder(Stdout)
+	e.SetIndent("", "\t")
+	return e.Encode(caps)
+}
+
+func runDebugRouterChanges(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	changes, err := localClient.DebugRouterChanges(ctx)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		outln("No changes.")
+	}
+	for _, c := range changes {
+		outln(c)
+	}
+	return nil
+}
+
+var debugRoutesArgs struct {
+	json bool
+}
+
+func runDebugRoutes(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	st, err := localClient.DebugRouterState(ctx)
+	if err != nil {
+		return err
+	}
+	if debugRoutesArgs.json {
+		e := json.NewEncoder(Stdout)
+		e.SetIndent("", "\t")
+		return e.Encode(st)
+	}
+	printf("Interface: %s\n", st.Interface)
+	for _, addr := range st.LocalAddrs {
+		printf("Address: %v\n", addr)
+	}
+	if st.Error != "" {
+		printf("Last error: %s\n", st.Error)
+	}
+	if len(st.Routes) == 0 {
+		outln("No routes.")
+		return nil
+	}
+	outln()
+	tw := tabwriter.NewWriter(Stdout, 0, 2, 2, ' ', 0)
+	fmt.Fprintf(tw, "DESTINATION\tINTERFACE\tSTATE\tERROR\n")
+	for _, r := range st.Routes {
+		dev := r.Dev
+		if dev == "" {
+			dev = st.Interface
+		}
+		if r.Priority != 0 {
+			dev += fmt.Sprintf(" (priority %d)", r.Priority)
+		}
+		state := "installed"
+		switch {
+		case r.Installed && !r.Wanted:
+			state = "pending delete"
+		case !r.Installed:
+			state = "pending add"
+		}
+		// The error may end in route(8)'s output; keep it on one line.
+		errText := strings.ReplaceAll(strings.TrimSpace(r.Error), "\n", " ")
+		fmt.Fprintf(tw, "%v\t%s\t%s\t%s\n", r.Dst, dev, state, errText)
+	}
+	return tw.Flush()
+}
+
 var debugArgs struct {
 	file    string
 	cpuSec  int
@@ -537,6 +708,26 @@ func runWatchIPN(ctx context.Context, args []string) error {
 	return nil
 }
 
+func runWatchNetwork(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	watcher, err := localClient.WatchNetworkChanges(ctx)
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	fmt.Fprintf(Stderr, "Connected.\n")
+	for {
+		ev, err := watcher.Next()
+		if err != nil {
+			return err
+		}
+		j, _ := json.MarshalIndent(ev, "", "\t")
+		fmt.Printf("%s\n", j)
+	}
+}
+
 var netmapArgs struct {
 	showPrivateKey bool
 }
@@ -1036,6 +1227,8 @@ func runSetExpire(ctx context.Context, args []string) error {
 	selfAddr    string
 	ty          string
 	logHTTP     bool
+	verify      bool
+	verifyPeer  string
 }
 
 func debugPortmap(ctx context.Context, args []string) error {
@@ -1043,6 +1236,7 @@ func debugPortmap(ctx context.Context, args []string) error {
 		Duration: debugPortmapArgs.duration,
 		Type:     debugPortmapArgs.ty,
 		LogHTTP:  debugPortmapArgs.logHTTP,
+		Verify:   debugPortmapArgs.verify,
 	}
 	if (debugPortmapArgs.gatewayAddr != "") != (debugPortmapArgs.selfAddr != "") {
 		return fmt.Errorf("if one of --gateway-addr and --self-addr is provided, the other must be as well")
@@ -1058,6 +1252,22 @@ func debugPortmap(ctx context.Context, args []string) error {
 			return fmt.Errorf("invalid --self-addr: %w", err)
 		}
 	}
+	if debugPortmapArgs.verifyPeer != "" {
+		if !debugPortmapArgs.verify {
+			return errors.New("--verify-peer requires --verify")
+		}
+		ip, self, err := tailscaleIPFromArg(ctx, debugPortmapArgs.verifyPeer)
+		if err != nil {
+			return err
+		}
+		if self {
+			return fmt.Errorf("%v is local Tailscale IP", ip)
+		}
+		opts.VerifyPeer, err = netip.ParseAddr(ip)
+		if err != nil {
+			return err
+		}
+	}
 	rc, err := localClient.DebugPortmap(ctx, opts)
 	if err != nil {
 		return err
@@ -4,17 +4,23 @@
 package cli
 
 import (
+	"cmp"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/drive"
+	"tailscale.com/safesocket"
 )
 
 const (
-	driveShareUsage   = "tailscale drive share <name> <path>"
+	driveShareUsage   = "tailscale drive share [--as=<user>] <name> <path>"
 	driveRenameUsage  = "tailscale drive rename <oldname> <newname>"
 	driveUnshareUsage = "tailscale drive unshare <name>"
 	driveListUsage    = "tailscale drive list"
@@ -37,6 +43,11 @@
 			ShortUsage: driveShareUsage,
 			Exec:       runDriveShare,
 			ShortHelp:  "[ALPHA] Create or modify a share",
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("share")
+				fs.StringVar(&driveShareArgs.as, "as", "", "user to access the shared directory as, where tailscaled can't tell which user is running this command (default: the user that ran sudo or doas)")
+				return fs
+			})(),
 		},
 		{
 			Name:       "rename",
@@ -59,6 +70,10 @@
 	},
 }
 
+var driveShareArgs struct {
+	as string
+}
+
 // runDriveShare is the entry point for the "tailscale drive share" command.
 func runDriveShare(ctx context.Context, args []string) error {
 	if len(args) != 2 {
@@ -72,10 +87,22 @@ func runDriveShare(ctx context.Context, args []string) error {
 		return err
 	}
 
-	err = localClient.DriveShareSet(ctx, &drive.Share{
+	share := &drive.Share{
 		Name: name,
 		Path: absolutePath,
-	})
+	}
+	if drive.AllowShareAs() && !safesocket.PlatformUsesPeerCreds() {
+		// tailscaled can't tell who we are, so tell it which user to
+		// access the share as.
+		share.As = cmp.Or(driveShareArgs.as, os.Getenv("DOAS_USER"), os.Getenv("SUDO_USER"))
+		if share.As == "" {
+			return errors.New("can't tell which user to share as; run with sudo or doas as the user, or use --as")
+		}
+	} else if driveShareArgs.as != "" {
+		return errors.New("--as is not supported on this platform; run this command as the user to share as instead")
+	}
+
+	err = localClient.DriveShareSet(ctx, share)
 	if err == nil {
 		fmt.Printf("Sharing %q as %q\n", path, name)
 	}
@@ -150,8 +177,16 @@ func buildShareLongHelp() string {
 	longHelpAs := ""
 	if drive.AllowShareAs() {
 		longHelpAs = shareLongHelpAs
+		if !safesocket.PlatformUsesPeerCreds() {
+			longHelpAs = shareLongHelpAsNoPeerCreds
+		}
 	}
-	return fmt.Sprintf(shareLongHelpBase, longHelpAs)
+	longHelpMount := ""
+	switch runtime.GOOS {
+	case "freebsd", "openbsd", "netbsd":
+		longHelpMount = shareLongHelpMountBSD
+	}
+	return fmt.Sprintf(shareLongHelpBase, longHelpAs) + longHelpMount
 }
 
 var shareLongHelpBase = `Taildrive allows you to share directories with other machines on your tailnet.
@@ -234,3 +269,15 @@ func buildShareLongHelp() string {
 If you want a share to be accessed as a different user, you can use sudo to accomplish this. For example, to create the aforementioned share as "theuser", you could run:
 
   $ sudo -u theuser tailscale drive share docs /Users/theuser/Documents`
+
+const shareLongHelpAsNoPeerCreds = `
+
+Shares are accessed as the user that ran "tailscale drive share" with sudo or doas. To create the aforementioned share as a different user, "theuser", you could run:
+
+  $ doas tailscale drive share --as=theuser docs /home/theuser/Documents`
+
+const shareLongHelpMountBSD = `
+
+This system has no built-in WebDAV file system, but shares on other machines can be mounted with a FUSE-based WebDAV client such as rclone. For example, after loading FUSE support (kldload fusefs on FreeBSD), you could run:
+
+  $ rclone mount --webdav-url=http://100.100.100.100:8080 :webdav:mydomain.com/mylaptop/docs /mnt/docs`
@@ -6,6 +6,8 @@
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
@@ -25,6 +27,7 @@
 	"github.com/mattn/go-isatty"
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"golang.org/x/time/rate"
+	"tailscale.com/client/local"
 	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/cmd/tailscale/cli/ffcomplete"
 	"tailscale.com/envknob"
@@ -60,7 +63,7 @@ func (c *countingReader) Read(buf []byte) (int, error) {
 
 var fileCpCmd = &ffcli.Command{
 	Name:       "cp",
-	ShortUsage: "tailscale file cp <files...> <target>:",
+	ShortUsage: "tailscale file cp [--preserve] <files or directories...> <target>:",
 	ShortHelp:  "Copy file(s) to a host",
 	Exec:       runCp,
 	FlagSet: (func() *flag.FlagSet {
@@ -68,14 +71,16 @@ func (c *countingReader) Read(buf []byte) (int, error) {
 		fs.StringVar(&cpArgs.name, "name", "", "alternate filename to use, especially useful when <file> is \"-\" (stdin)")
 		fs.BoolVar(&cpArgs.verbose, "verbose", false, "verbose output")
 		fs.BoolVar(&cpArgs.targets, "targets", false, "list possible file cp targets")
+		fs.BoolVar(&cpArgs.preserve, "preserve", false, "preserve the files' modification times and permissions")
 		return fs
 	})(),
 }
 
 var cpArgs struct {
-	name    string
-	verbose bool
-	targets bool
+	name     string
+	verbose  bool
+	targets  bool
+	preserve bool
 }
 
 func runCp(ctx context.Context, args []string) error {
@@ -128,6 +133,9 @@ func runCp(ctx context.Context, args []string) error {
 		var fileContents *countingReader
 		var name = cpArgs.name
 		var contentLength int64 = -1
+		var md local.PushFileMetadata
+		progressName := func() string { return name }
+		var progressLength int64 = -1
 		if fileArg == "-" {
 			fileContents = &countingReader{Reader: os.Stdin}
 			if name == "" {
@@ -136,6 +144,19 @@ func runCp(ctx context.Context, args []string) error {
 					return err
 				}
 			}
+		} else if fi, err := os.Stat(fileArg); err == nil && fi.IsDir() {
+			fileContents, err = archiveDir(fileArg, &progressName, &progressLength)
+			if err != nil {
+				return err
+			}
+			md.Archive = "tar"
+			if name == "" {
+				abs, err := filepath.Abs(fileArg)
+				if err != nil {
+					return err
+				}
+				name = filepath.Base(abs) + ".tar"
+			}
 		} else {
 			f, err := os.Open(fileArg)
 			if err != nil {
@@ -153,6 +174,11 @@ func runCp(ctx context.Context, args []string) error {
 				return errors.New("directories not supported")
 			}
 			contentLength = fi.Size()
+			progressLength = contentLength
+			if cpArgs.preserve {
+				md.ModTime = fi.ModTime()
+				md.Mode = fi.Mode().Perm()
+			}
 			fileContents = &countingReader{Reader: io.LimitReader(f, contentLength)}
 			if name == "" {
 				name = filepath.Base(fileArg)
@@ -171,10 +197,10 @@ func runCp(ctx context.Context, args []string) error {
 		ctxProgress, cancelProgress := context.WithCancel(ctx)
 		defer cancelProgress()
 		if isatty.IsTerminal(os.Stderr.Fd()) {
-			group.Go(func() { progressPrinter(ctxProgress, name, fileContents.n.Load, contentLength) })
+			group.Go(func() { progressPrinter(ctxProgress, progressName, fileContents.n.Load, progressLength) })
 		}
 
-		err := localClient.PushFile(ctx, stableID, contentLength, name, fileContents)
+		err := localClient.PushFileWithMetadata(ctx, stableID, contentLength, name, fileContents, md)
 		cancelProgress()
 		group.Wait() // wait for progress printer to stop before reporting the error
 		if err != nil {
@@ -187,7 +213,10 @@ func runCp(ctx context.Context, args []string) error {
 	return nil
 }
 
-func progressPrinter(ctx context.Context, name string, contentCount func() int64, contentLength int64) {
+// progressPrinter prints the progress of sending a file of contentLength
+// bytes, or -1 if unknown, until ctx is done. name returns the name of what
+// is being sent.
+func progressPrinter(ctx context.Context, name func() string, contentCount func() int64, contentLength int64) {
 	var rateValueFast, rateValueSlow tsrate.Value
 	rateValueFast.HalfLife = 1 * time.Second  // fast response for rate measurement
 	rateValueSlow.HalfLife = 10 * time.Second // slow response for ETA measurement
@@ -201,7 +230,7 @@ func progressPrinter(ctx context.Context, name string, contentCount func() int64
 		const vtRestartLine = "\r\x1b[K"
 		fmt.Fprintf(os.Stderr, "%s%s    %s    %s",
 			vtRestartLine,
-			rightPad(name, 36),
+			rightPad(name(), 36),
 			leftPad(formatIEC(float64(currContentCount), "B"), len("1023.00MiB")),
 			leftPad(formatIEC(rateValueFast.Rate(), "B/s"), len("1023.00MiB/s")))
 		if contentLength >= 0 {
@@ -407,7 +436,7 @@ func (v *onConflict) Set(s string) error {
 
 var fileGetCmd = &ffcli.Command{
 	Name:       "get",
-	ShortUsage: "tailscale file get [--wait] [--verbose] [--conflict=(skip|overwrite|rename)] <target-directory>",
+	ShortUsage: "tailscale file get [--wait] [--verbose] [--verify] [--conflict=(skip|overwrite|rename)] <target-directory>",
 	ShortHelp:  "Move files out of the Tailscale file inbox",
 	Exec:       runFileGet,
 	FlagSet: (func() *flag.FlagSet {
@@ -415,6 +444,7 @@ func (v *onConflict) Set(s string) error {
 		fs.BoolVar(&getArgs.wait, "wait", false, "wait for a file to arrive if inbox is empty")
 		fs.BoolVar(&getArgs.loop, "loop", false, "run get in a loop, receiving files as they come in")
 		fs.BoolVar(&getArgs.verbose, "verbose", false, "verbose output")
+		fs.BoolVar(&getArgs.verify, "verify", false, "check each file against the checksum its sender sent, leaving files without one or that don't match in the inbox")
 		fs.Var(&getArgs.conflict, "conflict", "`behavior`"+` when a conflicting (same-named) file already exists in the target directory.
 	skip:       skip conflicting files: leave them in the taildrop inbox and print an error. get any non-conflicting files
 	overwrite:  overwrite existing file
@@ -428,6 +458,7 @@ func (v *onConflict) Set(s string) error {
 	wait     bool
 	loop     bool
 	verbose  bool
+	verify   bool
 	conflict onConflict
 }{conflict: skipOnExist}
 
@@ -480,7 +511,18 @@ func openFileOrSubstitute(dir, base string, action onConflict) (*os.File, error)
 	}
 }
 
+// receiveFile writes the waiting file wf into dir, applying the modification
+// time and permissions preserved by the sender, if any. With --verify, it
+// fails, removing what it wrote, unless the file matches the checksum the
+// sender sent. An archive of a directory is extracted instead, with
+// receiveArchive.
 func receiveFile(ctx context.Context, wf apitype.WaitingFile, dir string) (targetFile string, size int64, err error) {
+	if getArgs.verify && wf.SHA256 == "" {
+		return "", 0, fmt.Errorf("can't verify %q: its sender sent no checksum", wf.Name)
+	}
+	if wf.Archive != "" {
+		return receiveArchive(ctx, wf, dir)
+	}
 	rc, size, err := localClient.GetWaitingFile(ctx, wf.Name)
 	if err != nil {
 		return "", 0, fmt.Errorf("opening inbox file %q: %w", wf.Name, err)
@@ -494,12 +536,80 @@ func receiveFile(ctx context.Context, wf apitype.WaitingFile, dir string) (targe
 	if err := quarantine.SetOnFile(f); err != nil {
 		return "", 0, fmt.Errorf("failed to apply quarantine attribute to file %v: %v", f.Name(), err)
 	}
-	_, err = io.Copy(f, rc)
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), rc)
 	if err != nil {
 		f.Close()
 		return "", 0, fmt.Errorf("failed to write %v: %v", f.Name(), err)
 	}
-	return f.Name(), size, f.Close()
+	if err := f.Close(); err != nil {
+		return "", 0, err
+	}
+	if getArgs.verify {
+		if got := hex.EncodeToString(h.Sum(nil)); got != wf.SHA256 {
+			os.Remove(f.Name())
+			return "", 0, fmt.Errorf("%q doesn't match its checksum: got SHA-256 %s, want %s", wf.Name, got, wf.SHA256)
+		}
+	}
+	if wf.Mode != 0 {
+		// Don't let the sender make the file writable by others.
+		if err := os.Chmod(f.Name(), wf.Mode.Perm()&^0o022); err != nil {
+			return "", 0, err
+		}
+	}
+	if !wf.ModTime.IsZero() {
+		if err := os.Chtimes(f.Name(), time.Time{}, wf.ModTime); err != nil {
+			return "", 0, err
+		}
+	}
+	return f.Name(), size, nil
+}
+
+// receiveArchive extracts the waiting file wf, an archive of a directory sent
+// by "tailscale file cp", into a new directory in dir named after it. With
+// --verify, the archive is checked against the checksum the sender sent
+// before anything is extracted.
+func receiveArchive(ctx context.Context, wf apitype.WaitingFile, dir string) (targetDir string, size int64, err error) {
+	if wf.Archive != "tar" {
+		return "", 0, fmt.Errorf("%q is an archive in unsupported format %q", wf.Name, wf.Archive)
+	}
+	if getArgs.verify {
+		rc, _, err := localClient.GetWaitingFile(ctx, wf.Name)
+		if err != nil {
+			return "", 0, fmt.Errorf("opening inbox file %q: %w", wf.Name, err)
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, rc)
+		rc.Close()
+		if err != nil {
+			return "", 0, fmt.Errorf("reading inbox file %q: %w", wf.Name, err)
+		}
+		if got := hex.EncodeToString(h.Sum(nil)); got != wf.SHA256 {
+			return "", 0, fmt.Errorf("%q doesn't match its checksum: got SHA-256 %s, want %s", wf.Name, got, wf.SHA256)
+		}
+	}
+	rc, size, err := localClient.GetWaitingFile(ctx, wf.Name)
+	if err != nil {
+		return "", 0, fmt.Errorf("opening inbox file %q: %w", wf.Name, err)
+	}
+	defer rc.Close()
+	base := strings.TrimSuffix(wf.Name, ".tar")
+	if base == "" {
+		base = wf.Name
+	}
+	targetDir, err = mkdirOrSubstitute(dir, base, getArgs.conflict)
+	if err != nil {
+		return "", 0, err
+	}
+	err = extractTar(rc, targetDir, func(name string, n int64) {
+		if getArgs.verbose {
+			printf("wrote %v (%d bytes)\n", filepath.Join(targetDir, name), n)
+		}
+	})
+	if err != nil {
+		return "", 0, fmt.Errorf("extracting %q into %v: %w", wf.Name, targetDir, err)
+	}
+	return targetDir, size, nil
 }
 
 func runFileGetOneBatch(ctx context.Context, dir string) []error {
@@ -0,0 +1,263 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"tailscale.com/syncs"
+	"tailscale.com/util/quarantine"
+)
+
+// Directories are sent as a tar archive of their directories and regular
+// files, named after the directory with a ".tar" suffix and marked with
+// the Taildrop-Archive header, which "tailscale file get" extracts.
+// Receivers that don't know about archives get the tar file itself.
+
+// archiveDir returns a reader of a tar archive of dir, written as it's read.
+// It sets *progressName to report the file being archived and
+// *progressLength to the size of dir's files.
+func archiveDir(dir string, progressName *func() string, progressLength *int64) (*countingReader, error) {
+	size, err := dirSize(dir)
+	if err != nil {
+		return nil, err
+	}
+	*progressLength = size
+
+	var cur syncs.AtomicValue[string]
+	cur.Store(dir)
+	*progressName = cur.Load
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(tarDir(pw, dir, func(name string) {
+			cur.Store(name)
+			if cpArgs.verbose {
+				log.Printf("adding %q", name)
+			}
+		}))
+	}()
+	return &countingReader{Reader: pr}, nil
+}
+
+// tarDir writes a tar archive of the directories and regular files in dir
+// to w, with their permission bits and modification times. Other files,
+// such as symlinks, are skipped. It calls onFile with the path of each
+// regular file, relative to dir, as it starts writing it.
+func tarDir(w io.Writer, dir string, onFile func(name string)) error {
+	tw := tar.NewWriter(w)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() && !fi.Mode().IsRegular() {
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		// Don't send local user and group names or IDs.
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		onFile(rel)
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		// The file may have grown since it was stat'ed; send what it had.
+		_, err = io.CopyN(tw, f, hdr.Size)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// dirSize returns the total size of the regular files in dir, for
+// reporting the progress of sending it.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += fi.Size()
+		return nil
+	})
+	return size, err
+}
+
+// mkdirOrSubstitute creates the directory base in dir, in which to extract
+// an archive, handling a conflicting existing file or directory as action
+// says. With overwriteExisting, an existing directory is extracted into.
+func mkdirOrSubstitute(dir, base string, action onConflict) (string, error) {
+	target := filepath.Join(dir, base)
+	err := os.Mkdir(target, 0755)
+	if err == nil {
+		return target, nil
+	}
+	switch action {
+	default:
+		return "", fmt.Errorf("file issue. how to resolve this conflict? no one knows.")
+	case skipOnExist:
+		if _, statErr := os.Lstat(target); statErr == nil {
+			return "", fmt.Errorf("refusing to overwrite directory: %w", err)
+		}
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	case overwriteExisting:
+		// Don't follow a symlink an attacker placed at the target name.
+		if fi, statErr := os.Lstat(target); statErr == nil && fi.IsDir() {
+			return target, nil
+		}
+		return "", fmt.Errorf("unable to overwrite: %w", err)
+	case createNumberedFiles:
+		for i := 1; i < 100; i++ {
+			target := numberedFileName(dir, base, i)
+			if err = os.Mkdir(target, 0755); err == nil {
+				return target, nil
+			}
+		}
+		return "", fmt.Errorf("unable to find a name for %v, final attempt: %w", target, err)
+	}
+}
+
+// extractTar extracts the directories and regular files in the tar archive
+// read from r into dir, applying their permission bits, other than write
+// permission for others, and modification times. Existing files are
+// replaced. It calls onFile with the path of each regular file, relative
+// to dir, once it's written.
+func extractTar(r io.Reader, dir string, onFile func(name string, size int64)) error {
+	type dirTime struct {
+		path    string
+		modTime time.Time
+	}
+	var dirTimes []dirTime
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(hdr.Name, "/")
+		rel := filepath.FromSlash(name)
+		if !filepath.IsLocal(rel) {
+			return fmt.Errorf("archive entry %q is outside the archive's directory", hdr.Name)
+		}
+		path := filepath.Join(dir, rel)
+		mode := fs.FileMode(hdr.Mode).Perm() &^ 0o022
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := mkdirAllInDir(dir, rel); err != nil {
+				return err
+			}
+			if err := os.Chmod(path, mode|0o700); err != nil {
+				return err
+			}
+			dirTimes = append(dirTimes, dirTime{path, hdr.ModTime})
+		case tar.TypeReg:
+			if err := mkdirAllInDir(dir, filepath.Dir(rel)); err != nil {
+				return err
+			}
+			// Remove any existing file, rather than writing through a
+			// symlink an attacker placed at its name.
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+			if err != nil {
+				return err
+			}
+			if err := quarantine.SetOnFile(f); err != nil {
+				f.Close()
+				return fmt.Errorf("failed to apply quarantine attribute to file %v: %v", path, err)
+			}
+			n, err := io.Copy(f, tr)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+			if err := os.Chmod(path, mode); err != nil {
+				return err
+			}
+			if err := os.Chtimes(path, time.Time{}, hdr.ModTime); err != nil {
+				return err
+			}
+			onFile(name, n)
+		default:
+			return fmt.Errorf("archive entry %q is of unsupported type %q", hdr.Name, hdr.Typeflag)
+		}
+	}
+
+	// Set directory modification times last, as writing the files in
+	// them changed them.
+	for i := len(dirTimes) - 1; i >= 0; i-- {
+		if err := os.Chtimes(dirTimes[i].path, time.Time{}, dirTimes[i].modTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mkdirAllInDir is like os.MkdirAll for the directory rel within dir, but
+// fails rather than follow a symlink in rel, which could lead outside dir.
+func mkdirAllInDir(dir, rel string) error {
+	path := dir
+	for _, elem := range strings.Split(rel, string(filepath.Separator)) {
+		if elem == "." {
+			continue
+		}
+		path = filepath.Join(path, elem)
+		fi, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			if err := os.Mkdir(path, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("%s exists and is not a directory", path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTarDirRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	files := map[string]os.FileMode{
+		"a.txt":         0o644,
+		"sub/b.sh":      0o755,
+		"sub/deep/c.md": 0o600,
+	}
+	for name, mode := range files {
+		p := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(name), mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(p, mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, time.Time{}, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if runtime.GOOS != "windows" {
+		if err := os.Symlink("/etc/passwd", filepath.Join(src, "link")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	var sent []string
+	if err := tarDir(&buf, src, func(name string) { sent = append(sent, filepath.ToSlash(name)) }); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(sent)
+	if want := []string{"a.txt", "sub/b.sh", "sub/deep/c.md"}; !slices.Equal(sent, want) {
+		t.Errorf("sent %q; want %q", sent, want)
+	}
+
+	dst := t.TempDir()
+	var got []string
+	if err := extractTar(bytes.NewReader(buf.Bytes()), dst, func(name string, n int64) { got = append(got, name) }); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, sent) {
+		t.Errorf("extracted %q; want %q", got, sent)
+	}
+	for name, mode := range files {
+		p := filepath.Join(dst, filepath.FromSlash(name))
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != name {
+			t.Errorf("%s: content %q", name, b)
+		}
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.ModTime().Equal(mtime) {
+			t.Errorf("%s: ModTime = %v; want %v", name, fi.ModTime(), mtime)
+		}
+		if runtime.GOOS != "windows" && fi.Mode().Perm() != mode {
+			t.Errorf("%s: Mode = %v; want %v", name, fi.Mode().Perm(), mode)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(dst, "link")); !os.IsNotExist(err) {
+		t.Errorf("symlink was sent: %v", err)
+	}
+}
+
+func TestExtractTarRejectsEscapes(t *testing.T) {
+	for _, name := range []string{"../evil", "/abs/evil", "a/../../evil"} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: 1, Typeflag: tar.TypeReg})
+		tw.Write([]byte("x"))
+		tw.Close()
+		if err := extractTar(&buf, t.TempDir(), func(string, int64) {}); err == nil {
+			t.Errorf("%q: extracted", name)
+		}
+	}
+
+	// An entry under a symlink in the target directory isn't written
+	// through it.
+	if runtime.GOOS == "windows" {
+		return
+	}
+	dst, outside := t.TempDir(), t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(dst, "sub")); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "sub/evil", Mode: 0o644, Size: 1, Typeflag: tar.TypeReg})
+	tw.Write([]byte("x"))
+	tw.Close()
+	if err := extractTar(&buf, dst, func(string, int64) {}); err == nil {
+		t.Error("extracted through a symlink")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "evil")); !os.IsNotExist(err) {
+		t.Errorf("file written outside the target: %v", err)
+	}
+}
+
+func TestMkdirOrSubstitute(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := mkdirOrSubstitute(dir, "x", skipOnExist); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mkdirOrSubstitute(dir, "x", skipOnExist); err == nil {
+		t.Error("skip: no error for existing directory")
+	}
+	if got, err := mkdirOrSubstitute(dir, "x", overwriteExisting); err != nil || got != filepath.Join(dir, "x") {
+		t.Errorf("overwrite: got %q, %v", got, err)
+	}
+	if got, err := mkdirOrSubstitute(dir, "x", createNumberedFiles); err != nil || got != filepath.Join(dir, "x (1)") {
+		t.Errorf("rename: got %q, %v", got, err)
+	}
+}
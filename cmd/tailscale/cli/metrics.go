@@ -6,6 +6,7 @@
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"strings"
 
@@ -31,15 +32,17 @@
 	Subcommands: []*ffcli.Command{
 		{
 			Name:       "print",
-			ShortUsage: "tailscale metrics print",
+			ShortUsage: "tailscale metrics print [--all]",
 			Exec:       runMetricsPrint,
 			ShortHelp:  "Print current metric values in Prometheus text format",
+			FlagSet:    metricsFlagSet("print"),
 		},
 		{
 			Name:       "write",
-			ShortUsage: "tailscale metrics write <path>",
+			ShortUsage: "tailscale metrics write [--all] <path>",
 			Exec:       runMetricsWrite,
 			ShortHelp:  "Write metric values to a file",
+			FlagSet:    metricsFlagSet("write"),
 			LongHelp: strings.TrimSpace(`
 
 The 'tailscale metrics write' command writes metric values to a text file provided as its
@@ -50,11 +53,32 @@
 can regularly run 'tailscale metrics write /var/lib/prometheus/node-exporter/tailscaled.prom'
 using cron or a systemd timer.
 
+With --all, the file also has the metrics that aren't user-facing, as served by
+tailscaled's LocalAPI at /localapi/v0/metrics/prometheus.
+
 	`),
 		},
 	},
 }
 
+var metricsCmdArgs struct {
+	all bool
+}
+
+func metricsFlagSet(name string) *flag.FlagSet {
+	fs := newFlagSet(name)
+	fs.BoolVar(&metricsCmdArgs.all, "all", false, "include tailscaled's internal metrics and packet filter drops, not only the user-facing metrics; requires operator access")
+	return fs
+}
+
+// getMetrics returns the metrics selected by metricsCmdArgs.
+func getMetrics(ctx context.Context) ([]byte, error) {
+	if metricsCmdArgs.all {
+		return localClient.PrometheusMetrics(ctx)
+	}
+	return localClient.UserMetrics(ctx)
+}
+
 // runMetricsNoSubcommand prints metric values if no subcommand is specified.
 func runMetricsNoSubcommand(ctx context.Context, args []string) error {
 	if len(args) > 0 {
@@ -66,7 +90,7 @@ func runMetricsNoSubcommand(ctx context.Context, args []string) error {
 
 // runMetricsPrint prints metric values to stdout.
 func runMetricsPrint(ctx context.Context, args []string) error {
-	out, err := localClient.UserMetrics(ctx)
+	out, err := getMetrics(ctx)
 	if err != nil {
 		return err
 	}
@@ -80,7 +104,7 @@ func runMetricsWrite(ctx context.Context, args []string) error {
 		return errors.New("usage: tailscale metrics write <path>")
 	}
 	path := args[0]
-	out, err := localClient.UserMetrics(ctx)
+	out, err := getMetrics(ctx)
 	if err != nil {
 		return err
 	}
@@ -6,6 +6,7 @@
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
@@ -18,9 +19,27 @@
 
 var ncCmd = &ffcli.Command{
 	Name:       "nc",
-	ShortUsage: "tailscale nc <hostname-or-IP> <port>",
+	ShortUsage: "tailscale nc [--via=<peer>] <hostname-or-IP> <port>",
 	ShortHelp:  "Connect to a port on a host, connected to stdin/stdout",
-	Exec:       runNC,
+	LongHelp: strings.TrimSpace(`
+The 'tailscale nc' command connects to a port on a host over Tailscale.
+
+With --via, the host is reached through the named tailnet peer: through a
+4via6 route the peer advertises for the host's IPv4 address if it has one,
+or else through its subnet route to the host. This reaches the host on the
+peer's network even when several subnet routers advertise overlapping
+networks.
+`),
+	Exec: runNC,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("nc")
+		fs.StringVar(&ncArgs.via, "via", "", "MagicDNS name or Tailscale IP of the peer to reach the host through")
+		return fs
+	})(),
+}
+
+var ncArgs struct {
+	via string
 }
 
 func init() {
@@ -30,6 +49,9 @@ func init() {
 		}
 		return completeHostOrIP(ffcomplete.LastArg(args))
 	})
+	ffcomplete.Flag(ncCmd.FlagSet, "via", func(args []string) ([]string, ffcomplete.ShellCompDirective, error) {
+		return completeHostOrIP(ffcomplete.LastArg(args))
+	})
 }
 
 func completeHostOrIP(arg string) ([]string, ffcomplete.ShellCompDirective, error) {
@@ -56,7 +78,7 @@ func runNC(ctx context.Context, args []string) error {
 	}
 
 	if len(args) != 2 {
-		return errors.New("usage: tailscale nc <hostname-or-IP> <port>")
+		return errors.New("usage: tailscale nc [--via=<peer>] <hostname-or-IP> <port>")
 	}
 
 	hostOrIP, portStr := args[0], args[1]
@@ -66,7 +88,7 @@ func runNC(ctx context.Context, args []string) error {
 	}
 
 	// TODO(bradfitz): also add UDP too, via flag?
-	c, err := localClient.DialTCP(ctx, hostOrIP, uint16(port))
+	c, err := localClient.UserDialVia(ctx, "tcp", hostOrIP, uint16(port), ncArgs.via)
 	if err != nil {
 		return fmt.Errorf("Dial(%q, %v): %w", hostOrIP, port, err)
 	}
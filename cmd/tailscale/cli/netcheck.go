@@ -24,26 +24,44 @@
 	"tailscale.com/net/tlsdial"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/logger"
+	"tailscale.com/types/opt"
 )
 
 var netcheckCmd = &ffcli.Command{
 	Name:       "netcheck",
-	ShortUsage: "tailscale netcheck",
+	ShortUsage: "tailscale netcheck [--format=json] [--ipv6-only]",
 	ShortHelp:  "Print an analysis of local network conditions",
-	Exec:       runNetcheck,
+	LongHelp: strings.TrimSpace(`
+
+The 'tailscale netcheck' command probes the DERP servers to measure the local
+network's UDP connectivity, IPv4 and IPv6 support, NAT behavior, port mapping
+protocols and captive portals, and prints a report.
+
+With --format=json or --format=json-line, the report has the latency of each
+DERP region overall and over IPv4 and IPv6, in seconds, alongside the results
+of port mapping and captive portal detection.
+
+With --ipv6-only, DERP servers are only probed over IPv6, and the HTTPS and
+ICMP latency checks that are tried when UDP seems blocked are skipped, to debug
+IPv6-only deployments.
+
+`),
+	Exec: runNetcheck,
 	FlagSet: (func() *flag.FlagSet {
 		fs := newFlagSet("netcheck")
 		fs.StringVar(&netcheckArgs.format, "format", "", `output format; empty (for human-readable), "json" or "json-line"`)
 		fs.DurationVar(&netcheckArgs.every, "every", 0, "if non-zero, do an incremental report with the given frequency")
 		fs.BoolVar(&netcheckArgs.verbose, "verbose", false, "verbose logs")
+		fs.BoolVar(&netcheckArgs.ipv6Only, "ipv6-only", false, "probe DERP servers over IPv6 only")
 		return fs
 	})(),
 }
 
 var netcheckArgs struct {
-	format  string
-	every   time.Duration
-	verbose bool
+	format   string
+	every    time.Duration
+	verbose  bool
+	ipv6Only bool
 }
 
 func runNetcheck(ctx context.Context, args []string) error {
@@ -96,7 +114,7 @@ func runNetcheck(ctx context.Context, args []string) error {
 	}
 	for {
 		t0 := time.Now()
-		report, err := c.GetReport(ctx, dm, nil)
+		report, err := c.GetReport(ctx, dm, &netcheck.GetReportOpts{OnlyIPv6: netcheckArgs.ipv6Only})
 		d := time.Since(t0)
 		if netcheckArgs.verbose {
 			c.Logf("GetReport took %v; err=%v", d.Round(time.Millisecond), err)
@@ -120,9 +138,9 @@ func printReport(dm *tailcfg.DERPMap, report *netcheck.Report) error {
 	switch netcheckArgs.format {
 	case "":
 	case "json":
-		j, err = json.MarshalIndent(report, "", "\t")
+		j, err = json.MarshalIndent(newNetcheckJSON(dm, report), "", "\t")
 	case "json-line":
-		j, err = json.Marshal(report)
+		j, err = json.Marshal(newNetcheckJSON(dm, report))
 	default:
 		return fmt.Errorf("unknown output format %q", netcheckArgs.format)
 	}
@@ -137,6 +155,9 @@ func printReport(dm *tailcfg.DERPMap, report *netcheck.Report) error {
 
 	printf("\nReport:\n")
 	printf("\t* Time: %v\n", report.Now.Format(time.RFC3339Nano))
+	if netcheckArgs.ipv6Only {
+		printf("\t* Probed: IPv6 only\n")
+	}
 	printf("\t* UDP: %v\n", report.UDP)
 	if report.GlobalV4.IsValid() {
 		printf("\t* IPv4: yes, %s\n", report.GlobalV4)
@@ -170,22 +191,7 @@ func printReport(dm *tailcfg.DERPMap, report *netcheck.Report) error {
 			printf("\t* Nearest DERP: [none]\n")
 		}
 		printf("\t* DERP latency:\n")
-		var rids []int
-		for rid := range dm.Regions {
-			rids = append(rids, rid)
-		}
-		sort.Slice(rids, func(i, j int) bool {
-			l1, ok1 := report.RegionLatency[rids[i]]
-			l2, ok2 := report.RegionLatency[rids[j]]
-			if ok1 != ok2 {
-				return ok1 // defined things sort first
-			}
-			if !ok1 {
-				return rids[i] < rids[j]
-			}
-			return l1 < l2
-		})
-		for _, rid := range rids {
+		for _, rid := range sortedRegionIDs(dm, report) {
 			d, ok := report.RegionLatency[rid]
 			var latency string
 			if ok {
@@ -202,6 +208,121 @@ func printReport(dm *tailcfg.DERPMap, report *netcheck.Report) error {
 	return nil
 }
 
+// sortedRegionIDs returns the IDs of the regions of dm, those with the
+// lowest latency in report first, followed by those without a latency.
+func sortedRegionIDs(dm *tailcfg.DERPMap, report *netcheck.Report) []int {
+	var rids []int
+	for rid := range dm.Regions {
+		rids = append(rids, rid)
+	}
+	sort.Slice(rids, func(i, j int) bool {
+		l1, ok1 := report.RegionLatency[rids[i]]
+		l2, ok2 := report.RegionLatency[rids[j]]
+		if ok1 != ok2 {
+			return ok1 // defined things sort first
+		}
+		if !ok1 {
+			return rids[i] < rids[j]
+		}
+		return l1 < l2
+	})
+	return rids
+}
+
+// netcheckJSON is the output of 'tailscale netcheck --format=json'. Unlike
+// netcheck.Report, it names the DERP regions and has latencies in seconds.
+type netcheckJSON struct {
+	Time        time.Time
+	IPv6Only    bool `json:",omitempty"` // only IPv6 was probed (--ipv6-only)
+	UDP         bool // a UDP STUN round trip completed
+	IPv4        bool // an IPv4 STUN round trip completed
+	IPv6        bool // an IPv6 STUN round trip completed
+	IPv4CanSend bool // an IPv4 packet could be sent
+	IPv6CanSend bool // an IPv6 packet could be sent
+	OSHasIPv6   bool // could bind a socket to ::1
+	ICMPv4      bool // an ICMPv4 round trip completed
+
+	GlobalV4 string `json:",omitempty"` // public IPv4 address and port, as seen by DERP
+	GlobalV6 string `json:",omitempty"` // public IPv6 address and port, as seen by DERP
+
+	// MappingVariesByDestIP is whether the public IPv4 address and port
+	// depend on the destination (a "hard" NAT); null if unknown.
+	MappingVariesByDestIP opt.Bool
+
+	// PortMapping has whether each port mapping protocol was found on the
+	// LAN; null if not checked.
+	PortMapping netcheckPortMappingJSON
+
+	// CaptivePortal is whether a captive portal seems to intercept HTTP
+	// traffic; null if not checked.
+	CaptivePortal opt.Bool
+
+	// PreferredDERP is the ID of the region with the lowest latency, or
+	// zero if none replied.
+	PreferredDERP int
+
+	// DERP are all the regions of the DERP map, those with the lowest
+	// latency first.
+	DERP []netcheckRegionJSON
+}
+
+type netcheckPortMappingJSON struct {
+	UPnP opt.Bool
+	PMP  opt.Bool
+	PCP  opt.Bool
+}
+
+// netcheckRegionJSON is a DERP region in netcheckJSON. Latencies are zero
+// if the region didn't reply.
+type netcheckRegionJSON struct {
+	RegionID         int
+	RegionCode       string
+	RegionName       string
+	LatencySeconds   float64 `json:",omitempty"` // the best of V4 and V6
+	V4LatencySeconds float64 `json:",omitempty"`
+	V6LatencySeconds float64 `json:",omitempty"`
+}
+
+func newNetcheckJSON(dm *tailcfg.DERPMap, report *netcheck.Report) *netcheckJSON {
+	j := &netcheckJSON{
+		Time:                  report.Now,
+		IPv6Only:              netcheckArgs.ipv6Only,
+		UDP:                   report.UDP,
+		IPv4:                  report.IPv4,
+		IPv6:                  report.IPv6,
+		IPv4CanSend:           report.IPv4CanSend,
+		IPv6CanSend:           report.IPv6CanSend,
+		OSHasIPv6:             report.OSHasIPv6,
+		ICMPv4:                report.ICMPv4,
+		MappingVariesByDestIP: report.MappingVariesByDestIP,
+		PortMapping: netcheckPortMappingJSON{
+			UPnP: report.UPnP,
+			PMP:  report.PMP,
+			PCP:  report.PCP,
+		},
+		CaptivePortal: report.CaptivePortal,
+		PreferredDERP: report.PreferredDERP,
+	}
+	if report.GlobalV4.IsValid() {
+		j.GlobalV4 = report.GlobalV4.String()
+	}
+	if report.GlobalV6.IsValid() {
+		j.GlobalV6 = report.GlobalV6.String()
+	}
+	for _, rid := range sortedRegionIDs(dm, report) {
+		r := dm.Regions[rid]
+		j.DERP = append(j.DERP, netcheckRegionJSON{
+			RegionID:         rid,
+			RegionCode:       r.RegionCode,
+			RegionName:       r.RegionName,
+			LatencySeconds:   report.RegionLatency[rid].Seconds(),
+			V4LatencySeconds: report.RegionV4Latency[rid].Seconds(),
+			V6LatencySeconds: report.RegionV6Latency[rid].Seconds(),
+		})
+	}
+	return j
+}
+
 func portMapping(r *netcheck.Report) string {
 	if !r.AnyPortMappingChecked() {
 		return "not checked"
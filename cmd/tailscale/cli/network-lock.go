@@ -6,6 +6,7 @@
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
@@ -13,6 +14,7 @@
 	"flag"
 	"fmt"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -36,11 +38,13 @@
 		nlAddCmd,
 		nlRemoveCmd,
 		nlSignCmd,
+		nlSignOfflineCmd,
 		nlDisableCmd,
 		nlDisablementKDFCmd,
 		nlLogCmd,
 		nlLocalDisableCmd,
 		nlRevokeKeysCmd,
+		nlRotateCmd,
 	},
 	Exec: runNetworkLockNoSubcommand,
 }
@@ -328,47 +332,59 @@ func runNetworkLockRemove(ctx context.Context, args []string) error {
 	}
 
 	if nlRemoveArgs.resign {
-		// Validate we are not removing trust in ourselves while resigning. This is because
-		// we resign with our own key, so the signatures would be immediately invalid.
-		for _, k := range removeKeys {
-			kID, err := k.ID()
+		keyIDs := make([]tkatype.KeyID, len(removeKeys))
+		for i, k := range removeKeys {
+			keyIDs[i], err = k.ID()
 			if err != nil {
 				return fmt.Errorf("computing KeyID for key %v: %w", k, err)
 			}
-			if bytes.Equal(st.PublicKey.KeyID(), kID) {
-				return errors.New("cannot remove local trusted signing key while resigning; run command on a different node or with --re-sign=false")
-			}
 		}
+		if err := resignAffectedSigs(ctx, st, keyIDs); err != nil {
+			return err
+		}
+	}
 
-		// Resign affected signatures for each of the keys we are removing.
-		for _, k := range removeKeys {
-			kID, _ := k.ID() // err already checked above
-			sigs, err := localClient.NetworkLockAffectedSigs(ctx, kID)
-			if err != nil {
-				return fmt.Errorf("affected sigs for key %X: %w", kID, err)
-			}
+	return localClient.NetworkLockModify(ctx, nil, removeKeys)
+}
 
-			for _, sigBytes := range sigs {
-				var sig tka.NodeKeySignature
-				if err := sig.Unserialize(sigBytes); err != nil {
-					return fmt.Errorf("failed decoding signature: %w", err)
-				}
-				var nodeKey key.NodePublic
-				if err := nodeKey.UnmarshalBinary(sig.Pubkey); err != nil {
-					return fmt.Errorf("failed decoding pubkey for signature: %w", err)
-				}
+// resignAffectedSigs re-signs, with the tailnet lock key of this node, the
+// node-key signatures which would be invalidated by no longer trusting the
+// keys with the given IDs.
+func resignAffectedSigs(ctx context.Context, st *ipnstate.NetworkLockStatus, keyIDs []tkatype.KeyID) error {
+	// Validate we are not removing trust in ourselves while resigning. This is because
+	// we resign with our own key, so the signatures would be immediately invalid.
+	for _, kID := range keyIDs {
+		if bytes.Equal(st.PublicKey.KeyID(), kID) {
+			return errors.New("cannot remove local trusted signing key while resigning; run command on a different node or with --re-sign=false")
+		}
+	}
 
-				// Safety: NetworkLockAffectedSigs() verifies all signatures before
-				// successfully returning.
-				rotationKey, _ := sig.UnverifiedWrappingPublic()
-				if err := localClient.NetworkLockSign(ctx, nodeKey, []byte(rotationKey)); err != nil {
-					return fmt.Errorf("failed to sign %v: %w", nodeKey, err)
-				}
+	// Resign affected signatures for each of the keys we are removing.
+	for _, kID := range keyIDs {
+		sigs, err := localClient.NetworkLockAffectedSigs(ctx, kID)
+		if err != nil {
+			return fmt.Errorf("affected sigs for key %X: %w", kID, err)
+		}
+
+		for _, sigBytes := range sigs {
+			var sig tka.NodeKeySignature
+			if err := sig.Unserialize(sigBytes); err != nil {
+				return fmt.Errorf("failed decoding signature: %w", err)
+			}
+			var nodeKey key.NodePublic
+			if err := nodeKey.UnmarshalBinary(sig.Pubkey); err != nil {
+				return fmt.Errorf("failed decoding pubkey for signature: %w", err)
+			}
+
+			// Safety: NetworkLockAffectedSigs() verifies all signatures before
+			// successfully returning.
+			rotationKey, _ := sig.UnverifiedWrappingPublic()
+			if err := localClient.NetworkLockSign(ctx, nodeKey, []byte(rotationKey)); err != nil {
+				return fmt.Errorf("failed to sign %v: %w", nodeKey, err)
 			}
 		}
 	}
-
-	return localClient.NetworkLockModify(ctx, nil, removeKeys)
+	return nil
 }
 
 // parseNLArgs parses a slice of strings into slices of tka.Key & disablement
@@ -442,9 +458,14 @@ func runNetworkLockModify(ctx context.Context, addArgs, removeArgs []string) err
 	return nil
 }
 
+var nlSignArgs struct {
+	offline    bool
+	importFile string
+}
+
 var nlSignCmd = &ffcli.Command{
 	Name:       "sign",
-	ShortUsage: "tailscale lock sign <node-key> [<rotation-key>]\ntailscale lock sign <auth-key>",
+	ShortUsage: "tailscale lock sign [--offline] <node-key> [<rotation-key>]\ntailscale lock sign <auth-key>\ntailscale lock sign --import=<signature-file>",
 	ShortHelp:  "Sign a node or pre-approved auth key",
 	LongHelp: `Either:
   - signs a node key and transmits the signature to the coordination
@@ -453,11 +474,44 @@ func runNetworkLockModify(ctx context.Context, addArgs, removeArgs []string) err
     used to bring up nodes under tailnet lock
 
 If any of the key arguments begin with "file:", the key is retrieved from
-the file at the path specified in the argument suffix.`,
+the file at the path specified in the argument suffix.
+
+To sign a node key with a tailnet lock key kept on an offline machine,
+run "tailscale lock sign --offline" with the node key to print a signing
+request, sign the request with "tailscale lock sign-offline" on the
+offline machine, then submit the resulting signature with
+"tailscale lock sign --import".`,
 	Exec: runNetworkLockSign,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("lock sign")
+		fs.BoolVar(&nlSignArgs.offline, "offline", false, "print a signing request for \"tailscale lock sign-offline\" instead of signing with this device's tailnet lock key")
+		fs.StringVar(&nlSignArgs.importFile, "import", "", "submit the signature in this file, as output by \"tailscale lock sign-offline\"")
+		return fs
+	})(),
+}
+
+// nlSigningRequest is a request to sign a node key, as printed by
+// "tailscale lock sign --offline" for "tailscale lock sign-offline".
+type nlSigningRequest struct {
+	NodeKey     key.NodePublic
+	RotationKey key.NLPublic
+}
+
+// nlSignedRequest is the signature made by "tailscale lock sign-offline"
+// for "tailscale lock sign --import".
+type nlSignedRequest struct {
+	NodeKey   key.NodePublic
+	Signature tkatype.MarshaledSignature
 }
 
 func runNetworkLockSign(ctx context.Context, args []string) error {
+	if nlSignArgs.importFile != "" {
+		if len(args) != 0 || nlSignArgs.offline {
+			return errors.New("usage: tailscale lock sign --import=<signature-file>")
+		}
+		return runNetworkLockSignImport(ctx, nlSignArgs.importFile)
+	}
+
 	// If any of the arguments start with "file:", replace that argument
 	// with the contents of the file. We do this early, before the check
 	// to see if the first argument is an auth key.
@@ -472,6 +526,9 @@ func runNetworkLockSign(ctx context.Context, args []string) error {
 	}
 
 	if len(args) > 0 && strings.HasPrefix(args[0], "tskey-auth-") {
+		if nlSignArgs.offline {
+			return errors.New("--offline is only supported for signing node keys")
+		}
 		return runTskeyWrapCmd(ctx, args)
 	}
 
@@ -492,6 +549,16 @@ func runNetworkLockSign(ctx context.Context, args []string) error {
 		}
 	}
 
+	if nlSignArgs.offline {
+		b, err := json.MarshalIndent(nlSigningRequest{NodeKey: nodeKey, RotationKey: rotationKey}, "", "\t")
+		if err != nil {
+			return err
+		}
+		outln(string(b))
+		fmt.Fprintf(Stderr, "\nSign this request on a machine with a trusted tailnet lock key using:\n\t%s lock sign-offline --key=<key-file> <request-file>\n", os.Args[0])
+		return nil
+	}
+
 	err := localClient.NetworkLockSign(ctx, nodeKey, []byte(rotationKey.Verifier()))
 	// Provide a better help message for when someone clicks through the signing flow
 	// on the wrong device.
@@ -504,6 +571,126 @@ func runNetworkLockSign(ctx context.Context, args []string) error {
 	return err
 }
 
+// runNetworkLockSignImport submits the signature in the file at path, as
+// output by "tailscale lock sign-offline".
+func runNetworkLockSignImport(ctx context.Context, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var signed nlSignedRequest
+	if err := json.Unmarshal(b, &signed); err != nil {
+		return fmt.Errorf("decoding signature file: %w", err)
+	}
+	if err := localClient.NetworkLockSubmitSignature(ctx, signed.Signature); err != nil {
+		return err
+	}
+	outln("Submitted signature for", signed.NodeKey.String())
+	return nil
+}
+
+var nlSignOfflineArgs struct {
+	keyFile string
+	genKey  bool
+}
+
+var nlSignOfflineCmd = &ffcli.Command{
+	Name:       "sign-offline",
+	ShortUsage: "tailscale lock sign-offline --key=<key-file> <request-file>\ntailscale lock sign-offline --gen-key --key=<key-file>",
+	ShortHelp:  "Sign a signing request with a tailnet lock key kept offline",
+	LongHelp: `Signs a signing request, as printed by "tailscale lock sign --offline",
+with the tailnet lock key in a file, printing the signature for
+"tailscale lock sign --import".
+
+This command doesn't use tailscaled, so that it can be run on a machine
+that isn't connected to any network and the key never touches one that is.
+
+With --gen-key, generates a new tailnet lock key in the file instead, and
+prints the command to trust it, to be run on a signing node.`,
+	Exec: runNetworkLockSignOffline,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("lock sign-offline")
+		fs.StringVar(&nlSignOfflineArgs.keyFile, "key", "", "file holding the tailnet lock private key")
+		fs.BoolVar(&nlSignOfflineArgs.genKey, "gen-key", false, "generate a new tailnet lock private key in the file given by --key")
+		return fs
+	})(),
+}
+
+func runNetworkLockSignOffline(ctx context.Context, args []string) error {
+	if nlSignOfflineArgs.keyFile == "" {
+		return errors.New("--key is required")
+	}
+	if nlSignOfflineArgs.genKey {
+		if len(args) != 0 {
+			return errors.New("usage: tailscale lock sign-offline --gen-key --key=<key-file>")
+		}
+		priv := key.NewNLPrivate()
+		b, err := priv.MarshalText()
+		if err != nil {
+			return err
+		}
+		f, err := os.OpenFile(nlSignOfflineArgs.keyFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(append(b, '\n')); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		outln("Generated a tailnet lock key. To trust it, run on a signing node:")
+		outln("\ttailscale lock add", priv.Public().CLIString())
+		return nil
+	}
+	if len(args) != 1 {
+		return errors.New("usage: tailscale lock sign-offline --key=<key-file> <request-file>")
+	}
+
+	b, err := os.ReadFile(nlSignOfflineArgs.keyFile)
+	if err != nil {
+		return err
+	}
+	var priv key.NLPrivate
+	if err := priv.UnmarshalText(bytes.TrimSpace(b)); err != nil {
+		return fmt.Errorf("decoding key file: %w", err)
+	}
+	b, err = os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	var req nlSigningRequest
+	if err := json.Unmarshal(b, &req); err != nil {
+		return fmt.Errorf("decoding signing request: %w", err)
+	}
+	if req.NodeKey.IsZero() {
+		return errors.New("signing request has no node key")
+	}
+
+	p, err := req.NodeKey.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	sig := tka.NodeKeySignature{
+		SigKind:        tka.SigDirect,
+		KeyID:          priv.KeyID(),
+		Pubkey:         p,
+		WrappingPubkey: []byte(req.RotationKey.Verifier()),
+	}
+	sig.Signature, err = priv.SignNKS(sig.SigHash())
+	if err != nil {
+		return fmt.Errorf("signature failed: %w", err)
+	}
+	b, err = json.MarshalIndent(nlSignedRequest{NodeKey: req.NodeKey, Signature: sig.Serialize()}, "", "\t")
+	if err != nil {
+		return err
+	}
+	outln(string(b))
+	fmt.Fprintf(Stderr, "\nSigned %v with %v. Submit the signature from a node in the tailnet using:\n\t%s lock sign --import=<signature-file>\n", req.NodeKey, priv.Public().CLIString(), os.Args[0])
+	return nil
+}
+
 var nlDisableCmd = &ffcli.Command{
 	Name:       "disable",
 	ShortUsage: "tailscale lock disable <disablement-secret>",
@@ -576,20 +763,31 @@ func runNetworkLockDisablementKDF(ctx context.Context, args []string) error {
 }
 
 var nlLogArgs struct {
-	limit int
-	json  bool
+	limit  int
+	json   bool
+	export bool
+	verify string
 }
 
 var nlLogCmd = &ffcli.Command{
 	Name:       "log",
-	ShortUsage: "tailscale lock log [--limit N]",
+	ShortUsage: "tailscale lock log [--limit N] [--export | --verify <file>]",
 	ShortHelp:  "List changes applied to tailnet lock",
-	LongHelp:   "List changes applied to tailnet lock",
-	Exec:       runNetworkLockLog,
+	LongHelp: strings.TrimSpace(`
+List changes applied to tailnet lock.
+
+With --export, the full chain of changes is written to stdout as JSON, with
+summaries of each change and of the tailnet lock signatures of this node and
+its peers, signed by this node's tailnet lock key. The export can be verified
+offline, without tailscaled, with --verify.
+`),
+	Exec: runNetworkLockLog,
 	FlagSet: (func() *flag.FlagSet {
 		fs := newFlagSet("lock log")
 		fs.IntVar(&nlLogArgs.limit, "limit", 50, "max number of updates to list")
 		fs.BoolVar(&nlLogArgs.json, "json", false, "output in JSON format (WARNING: format subject to change)")
+		fs.BoolVar(&nlLogArgs.export, "export", false, "write a signed export of the full log to stdout")
+		fs.StringVar(&nlLogArgs.verify, "verify", "", "verify the export in the given file and print its summaries")
 		return fs
 	})(),
 }
@@ -663,6 +861,21 @@ func nlDescribeUpdate(update ipnstate.NetworkLockUpdate, color bool) (string, er
 }
 
 func runNetworkLockLog(ctx context.Context, args []string) error {
+	switch {
+	case nlLogArgs.export && nlLogArgs.verify != "":
+		return errors.New("--export and --verify are mutually exclusive")
+	case nlLogArgs.export:
+		export, err := localClient.NetworkLockExportLog(ctx)
+		if err != nil {
+			return fixTailscaledConnectError(err)
+		}
+		enc := json.NewEncoder(Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(export)
+	case nlLogArgs.verify != "":
+		return runNetworkLockVerifyExport(nlLogArgs.verify)
+	}
+
 	updates, err := localClient.NetworkLockLog(ctx, nlLogArgs.limit)
 	if err != nil {
 		return fixTailscaledConnectError(err)
@@ -685,6 +898,64 @@ func runNetworkLockLog(ctx context.Context, args []string) error {
 	return nil
 }
 
+// runNetworkLockVerifyExport verifies the log export in the named file, as
+// written by "tailscale lock log --export", and prints its summaries.
+func runNetworkLockVerifyExport(file string) error {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var export tka.LogExport
+	if err := json.Unmarshal(b, &export); err != nil {
+		return fmt.Errorf("decoding export: %w", err)
+	}
+	trusted, err := tka.VerifyLogExport(&export)
+	if err != nil {
+		return fmt.Errorf("verification failed: %w", err)
+	}
+
+	out, useColor := colorableOutput()
+	terminalYellow, terminalClear := "", ""
+	if useColor {
+		terminalYellow = "\x1b[33m"
+		terminalClear = "\x1b[0m"
+	}
+	signer := key.NLPublicFromEd25519Unsafe(ed25519.PublicKey(export.Signature.KeyID)).CLIString()
+	fmt.Fprintf(out, "Export of %d updates up to %v, made %v by %s.\n", len(export.Updates), export.Head, export.Exported.Local().Format(time.RFC3339), signer)
+	if trusted {
+		fmt.Fprintln(out, "The chain and signatures are valid, and the export was signed by a trusted key.")
+	} else {
+		fmt.Fprintln(out, "The chain and signatures are valid, but the export was signed by a key that isn't trusted, so anyone could have made it.")
+	}
+	for _, u := range export.Updates {
+		fmt.Fprintf(out, "\n%supdate %v (%s)%s\n", terminalYellow, u.Hash, u.Kind, terminalClear)
+		fmt.Fprintln(out, u.Description)
+		for _, k := range u.SignedBy {
+			fmt.Fprintf(out, "Signed by: %s%s\n", k.Key, nlDescribeKeyMeta(k.Meta))
+		}
+	}
+	if len(export.Nodes) > 0 {
+		fmt.Fprintln(out, "\nNodes:")
+		for _, n := range export.Nodes {
+			fmt.Fprintf(out, "  %s (%s): %s signature by %s%s", strings.TrimSuffix(n.Name, "."), n.NodeKey.ShortString(), n.Kind, n.SignedBy.Key, nlDescribeKeyMeta(n.SignedBy.Meta))
+			if !n.Created.IsZero() {
+				fmt.Fprintf(out, ", node created %v", n.Created.Local().Format(time.RFC3339))
+			}
+			fmt.Fprintln(out)
+		}
+	}
+	return nil
+}
+
+// nlDescribeKeyMeta returns the metadata of a tailnet lock key for printing
+// after it, or the empty string if it has none.
+func nlDescribeKeyMeta(meta map[string]string) string {
+	if len(meta) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" %v", meta)
+}
+
 func runTskeyWrapCmd(ctx context.Context, args []string) error {
 	if len(args) != 1 {
 		return errors.New("usage: lock tskey-wrap <tailscale pre-auth key>")
@@ -848,3 +1119,192 @@ func runNetworkLockRevokeKeys(ctx context.Context, args []string) error {
 
 	return nil
 }
+
+var nlRotateArgs struct {
+	numDisablements int
+	cosign          bool
+	finish          bool
+	resign          bool
+}
+
+var nlRotateCmd = &ffcli.Command{
+	Name:       "rotate",
+	ShortUsage: "tailscale lock rotate [--gen-disablements N] [<old-key> <new-key>]\n  rotate [--cosign] [--finish] <rotation-blob>",
+	ShortHelp:  "Rotate a tailnet lock key and/or the disablement secrets",
+	LongHelp: `Replace a trusted tailnet lock key (tlpub:abc) with a new one, regenerate the
+disablement secrets, or both, in a single change to tailnet lock.
+
+The new key is trusted with the votes of the key it replaces, unless specified
+with a '?<votes>' suffix. If --gen-disablements is given, that many new
+disablement secrets are generated and replace all the current ones, including
+any held by Tailscale support.
+
+As a rotation can change anything about tailnet lock, it must be signed by
+trusted keys holding a majority of the votes before it takes effect.
+
+1. To start, run ` + "`tailscale lock rotate`" + ` on a node with a trusted tailnet lock key.
+   Take note of any disablement secrets printed.
+2. Re-run the ` + "`--cosign`" + ` command output by ` + "`rotate`" + ` on other signing nodes. Use the
+   most recent command output on the next signing node in sequence.
+3. Once the rotation is signed by keys with more than half the votes, run the
+   command one final time with ` + "`--finish`" + ` instead of ` + "`--cosign`" + `. Unless
+   --re-sign=false is given, nodes signed by the old key are re-signed first.`,
+	Exec: runNetworkLockRotate,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("lock rotate")
+		fs.IntVar(&nlRotateArgs.numDisablements, "gen-disablements", 0, "number of disablement secrets to generate, replacing the current ones")
+		fs.BoolVar(&nlRotateArgs.cosign, "cosign", false, "continue generating the rotation using the tailnet lock key on this device and the provided rotation blob")
+		fs.BoolVar(&nlRotateArgs.finish, "finish", false, "finish the rotation by transmitting it")
+		fs.BoolVar(&nlRotateArgs.resign, "re-sign", true, "when finishing, resign signatures which would be invalidated by removal of the old key")
+		return fs
+	})(),
+}
+
+func runNetworkLockRotate(ctx context.Context, args []string) error {
+	st, err := localClient.NetworkLockStatus(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if !st.Enabled {
+		return errors.New("tailnet lock is not enabled")
+	}
+
+	// First step in the process
+	if !nlRotateArgs.cosign && !nlRotateArgs.finish {
+		if len(args) != 0 && len(args) != 2 {
+			return errors.New("usage: tailscale lock rotate [--gen-disablements N] [<old-key> <new-key>]")
+		}
+		if len(args) == 0 && nlRotateArgs.numDisablements <= 0 {
+			return errors.New("nothing to rotate: specify the key to replace and/or --gen-disablements")
+		}
+
+		var (
+			addKeys    []tka.Key
+			removeKeys []tkatype.KeyID
+		)
+		if len(args) == 2 {
+			keys, _, err := parseNLArgs(args, true, false)
+			if err != nil {
+				return err
+			}
+			oldKey, newKey := keys[0], keys[1]
+			oldKeyID, err := oldKey.ID()
+			if err != nil {
+				return fmt.Errorf("generating keyID: %v", err)
+			}
+			if !strings.Contains(args[1], "?") {
+				for _, k := range st.TrustedKeys {
+					if bytes.Equal(k.Key.KeyID(), oldKeyID) {
+						newKey.Votes = k.Votes
+					}
+				}
+			}
+			addKeys = []tka.Key{newKey}
+			removeKeys = []tkatype.KeyID{oldKeyID}
+		}
+
+		var (
+			disablementValues [][]byte
+			secrets           strings.Builder
+		)
+		for range nlRotateArgs.numDisablements {
+			var secret [32]byte
+			if _, err := rand.Read(secret[:]); err != nil {
+				return err
+			}
+			fmt.Fprintf(&secrets, "\tdisablement-secret:%X\n", secret[:])
+			disablementValues = append(disablementValues, tka.DisablementKDF(secret[:]))
+		}
+
+		aumBytes, err := localClient.NetworkLockGenRotationAUM(ctx, addKeys, removeKeys, disablementValues)
+		if err != nil {
+			return fmt.Errorf("generation of rotation AUM failed: %w", err)
+		}
+
+		if secrets.Len() > 0 {
+			fmt.Printf("%d disablement secrets have been generated and are printed below. Take note of them now, they WILL NOT be shown again.\n", nlRotateArgs.numDisablements)
+			fmt.Print(secrets.String())
+			fmt.Println("They replace the current disablement secrets once the rotation is finished.")
+			fmt.Println()
+		}
+		fmt.Printf(`Run the following command on another machine with a trusted tailnet lock key:
+	%s lock rotate --cosign %X
+`, os.Args[0], aumBytes)
+		return nil
+	}
+
+	// If we got this far, we need to co-sign the AUM and/or transmit it for distribution.
+	if len(args) != 1 {
+		return errors.New("expected a single rotation blob")
+	}
+	b, err := hex.DecodeString(args[0])
+	if err != nil {
+		return fmt.Errorf("parsing hex: %v", err)
+	}
+	var rotationAUM tka.AUM
+	if err := rotationAUM.Unserialize(b); err != nil {
+		return fmt.Errorf("decoding rotation AUM: %v", err)
+	}
+	if rotationAUM.MessageKind != tka.AUMCheckpoint || rotationAUM.State == nil {
+		return errors.New("not a rotation blob")
+	}
+	removed := nlDescribeRotation(st, &rotationAUM)
+
+	if nlRotateArgs.cosign {
+		aumBytes, err := localClient.NetworkLockCosignRecoveryAUM(ctx, rotationAUM)
+		if err != nil {
+			return fmt.Errorf("co-signing rotation AUM failed: %w", err)
+		}
+
+		fmt.Printf(`Co-signing completed successfully.
+
+To accumulate an additional signature, run the following command on another machine with a trusted tailnet lock key:
+	%s lock rotate --cosign %X
+
+Alternatively if you are done with co-signing, complete the rotation by running the following command:
+	%s lock rotate --finish %X
+`, os.Args[0], aumBytes, os.Args[0], aumBytes)
+	}
+
+	if nlRotateArgs.finish {
+		if nlRotateArgs.resign {
+			if err := resignAffectedSigs(ctx, st, removed); err != nil {
+				return err
+			}
+		}
+		if err := localClient.NetworkLockSubmitRotationAUM(ctx, rotationAUM); err != nil {
+			return fmt.Errorf("submitting rotation AUM failed: %w", err)
+		}
+		fmt.Println("Rotation completed.")
+	}
+
+	return nil
+}
+
+// nlDescribeRotation prints the changes the rotation AUM makes to the trusted
+// keys and disablement secrets in st, for signers to confirm, and returns the
+// IDs of the keys it stops trusting.
+func nlDescribeRotation(st *ipnstate.NetworkLockStatus, aum *tka.AUM) (removed []tkatype.KeyID) {
+	fmt.Println("This rotation makes the following changes to tailnet lock:")
+	for _, k := range st.TrustedKeys {
+		if _, err := aum.State.GetKey(k.Key.KeyID()); err != nil {
+			fmt.Printf(" - stop trusting %s\n", k.Key.CLIString())
+			removed = append(removed, k.Key.KeyID())
+		}
+	}
+	for _, k := range aum.State.Keys {
+		kID, err := k.ID()
+		if err != nil {
+			continue
+		}
+		trusted := slices.ContainsFunc(st.TrustedKeys, func(tk ipnstate.TKAKey) bool {
+			return bytes.Equal(tk.Key.KeyID(), kID)
+		})
+		if !trusted {
+			fmt.Printf(" - trust tlpub:%x (%d votes)\n", k.Public, k.Votes)
+		}
+	}
+	fmt.Printf(" - %d disablement values\n", len(aum.State.DisablementSecrets))
+	fmt.Println()
+	return removed
+}
@@ -8,10 +8,13 @@
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/netip"
 	"os"
+	"os/signal"
+	"slices"
 	"strings"
 	"time"
 
@@ -38,7 +41,13 @@
 does not inject packets into either side's TUN devices.
 
 By default, 'tailscale ping' stops after 10 pings or once a direct
-(non-DERP) path has been established, whichever comes first.
+(non-DERP) path has been established, whichever comes first. Use -c 0
+with --until-direct=false to ping until interrupted.
+
+With --until-direct=false or --until-direct-then-stats, it prints packet
+loss, latency and jitter statistics when it stops, including when
+interrupted. --until-direct-then-stats reports when the path upgrades to
+direct but keeps pinging, to diagnose flaky direct connections.
 
 The provided hostname must resolve to or be a Tailscale IP
 (e.g. 100.x.y.z) or a subnet IP advertised by a Tailscale
@@ -50,11 +59,13 @@
 		fs := newFlagSet("ping")
 		fs.BoolVar(&pingArgs.verbose, "verbose", false, "verbose output")
 		fs.BoolVar(&pingArgs.untilDirect, "until-direct", true, "stop once a direct path is established")
+		fs.BoolVar(&pingArgs.untilDirectThenStats, "until-direct-then-stats", false, "keep pinging once a direct path is established, then print statistics; fails if no direct path was established")
 		fs.BoolVar(&pingArgs.tsmp, "tsmp", false, "do a TSMP-level ping (through WireGuard, but not either host OS stack)")
 		fs.BoolVar(&pingArgs.icmp, "icmp", false, "do a ICMP-level ping (through WireGuard, but not the local host OS stack)")
 		fs.BoolVar(&pingArgs.peerAPI, "peerapi", false, "try hitting the peer's peerapi HTTP server")
 		fs.IntVar(&pingArgs.num, "c", 10, "max number of pings to send. 0 for infinity.")
 		fs.DurationVar(&pingArgs.timeout, "timeout", 5*time.Second, "timeout before giving up on a ping")
+		fs.DurationVar(&pingArgs.interval, "interval", time.Second, "time to wait between pings")
 		fs.IntVar(&pingArgs.size, "size", 0, "size of the ping message (disco pings only). 0 for minimum size.")
 		return fs
 	})(),
@@ -70,14 +81,16 @@ func init() {
 }
 
 var pingArgs struct {
-	num         int
-	size        int
-	untilDirect bool
-	verbose     bool
-	tsmp        bool
-	icmp        bool
-	peerAPI     bool
-	timeout     time.Duration
+	num                  int
+	size                 int
+	untilDirect          bool
+	untilDirectThenStats bool
+	verbose              bool
+	tsmp                 bool
+	icmp                 bool
+	peerAPI              bool
+	timeout              time.Duration
+	interval             time.Duration
 }
 
 func pingType() tailcfg.PingType {
@@ -123,15 +136,37 @@ func runPing(ctx context.Context, args []string) error {
 		log.Printf("lookup %q => %q", hostOrIP, ip)
 	}
 
+	// Statistics are printed when the command doesn't stop at the first
+	// direct pong, so that a long run can be interrupted for its summary.
+	wantStats := !pingArgs.untilDirect || pingArgs.untilDirectThenStats
+	var stats pingStats
+	if wantStats {
+		var cancel context.CancelFunc
+		ctx, cancel = signal.NotifyContext(ctx, os.Interrupt)
+		defer cancel()
+		defer func() {
+			if stats.sent > 0 {
+				outln()
+				stats.write(Stdout, ip)
+			}
+		}()
+	}
+
 	n := 0
 	anyPong := false
+	sawDirect := false
 	for {
 		n++
-		ctx, cancel := context.WithTimeout(ctx, pingArgs.timeout)
-		pr, err := localClient.PingWithOpts(ctx, netip.MustParseAddr(ip), pingType(), tailscale.PingOpts{Size: pingArgs.size})
+		pctx, cancel := context.WithTimeout(ctx, pingArgs.timeout)
+		pr, err := localClient.PingWithOpts(pctx, netip.MustParseAddr(ip), pingType(), tailscale.PingOpts{Size: pingArgs.size})
 		cancel()
 		if err != nil {
+			if wantStats && ctx.Err() != nil {
+				// Interrupted.
+				return nil
+			}
 			if errors.Is(err, context.DeadlineExceeded) {
+				stats.sent++
 				printf("ping %q timed out\n", ip)
 				if n == pingArgs.num {
 					if !anyPong {
@@ -150,7 +185,8 @@ func runPing(ctx context.Context, args []string) error {
 			}
 			return errors.New(pr.Err)
 		}
-		latency := time.Duration(pr.LatencySeconds * float64(time.Second)).Round(time.Millisecond)
+		rtt := time.Duration(pr.LatencySeconds * float64(time.Second))
+		latency := rtt.Round(time.Millisecond)
 		via := pr.Endpoint
 		if pr.DERPRegionID != 0 {
 			via = fmt.Sprintf("DERP(%s)", pr.DERPRegionCode)
@@ -165,29 +201,87 @@ func runPing(ctx context.Context, args []string) error {
 			return nil
 		}
 		anyPong = true
+		stats.sent++
+		stats.addPong(via, pr.DERPRegionID != 0, rtt)
 		extra := ""
 		if pr.PeerAPIPort != 0 {
 			extra = fmt.Sprintf(", %d", pr.PeerAPIPort)
 		}
 		printf("pong from %s (%s%s) via %v in %v\n", pr.NodeName, pr.NodeIP, extra, via, latency)
-		if pingArgs.tsmp || pingArgs.icmp {
+		if (pingArgs.tsmp || pingArgs.icmp) && !wantStats {
 			return nil
 		}
-		if pr.Endpoint != "" && pingArgs.untilDirect {
-			return nil
+		if pr.Endpoint != "" && !sawDirect {
+			sawDirect = true
+			if pingArgs.untilDirectThenStats {
+				printf("direct path established after %d pings; still measuring\n", n)
+			} else if pingArgs.untilDirect {
+				return nil
+			}
 		}
-		time.Sleep(time.Second)
-
 		if n == pingArgs.num {
-			if !anyPong {
-				return errors.New("no reply")
-			}
-			if pingArgs.untilDirect {
+			if (pingArgs.untilDirect || pingArgs.untilDirectThenStats) && !sawDirect {
 				return errors.New("direct connection not established")
 			}
 			return nil
 		}
+		select {
+		case <-ctx.Done():
+			if wantStats {
+				return nil
+			}
+			return ctx.Err()
+		case <-time.After(pingArgs.interval):
+		}
+	}
+}
+
+// pingStats accumulates the results of a 'tailscale ping' run.
+type pingStats struct {
+	sent        int
+	rtts        []time.Duration // of each pong, in order
+	viaDERP     int             // pongs relayed through DERP
+	pathChanges int             // times a pong came by a different path than the previous one
+	lastVia     string
+}
+
+func (s *pingStats) addPong(via string, derp bool, rtt time.Duration) {
+	if s.lastVia != "" && via != s.lastVia {
+		s.pathChanges++
+	}
+	s.lastVia = via
+	if derp {
+		s.viaDERP++
+	}
+	s.rtts = append(s.rtts, rtt)
+}
+
+// write writes the summary of s for pings to ip to w, like ping(8). Jitter
+// is the mean difference between the round-trip times of consecutive
+// pongs.
+func (s *pingStats) write(w io.Writer, ip string) {
+	received := len(s.rtts)
+	fmt.Fprintf(w, "--- %s ping statistics ---\n", ip)
+	fmt.Fprintf(w, "%d pings sent, %d pongs received, %.1f%% loss\n", s.sent, received, 100*float64(s.sent-received)/float64(s.sent))
+	if received == 0 {
+		return
+	}
+	minRTT, maxRTT := slices.Min(s.rtts), slices.Max(s.rtts)
+	var sum, diffs time.Duration
+	for i, rtt := range s.rtts {
+		sum += rtt
+		if i > 0 {
+			diffs += (rtt - s.rtts[i-1]).Abs()
+		}
+	}
+	avg := sum / time.Duration(received)
+	var jitter time.Duration
+	if received > 1 {
+		jitter = diffs / time.Duration(received-1)
 	}
+	round := func(d time.Duration) time.Duration { return d.Round(10 * time.Microsecond) }
+	fmt.Fprintf(w, "rtt min/avg/max/jitter = %v/%v/%v/%v\n", round(minRTT), round(avg), round(maxRTT), round(jitter))
+	fmt.Fprintf(w, "%d direct, %d via DERP, %d path changes\n", received-s.viaDERP, s.viaDERP, s.pathChanges)
 }
 
 func tailscaleIPFromArg(ctx context.Context, hostOrIP string) (ip string, self bool, err error) {
@@ -0,0 +1,37 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPingStats(t *testing.T) {
+	var s pingStats
+	s.sent = 5
+	s.addPong("DERP(fra)", true, 40*time.Millisecond)
+	s.addPong("192.0.2.1:41641", false, 10*time.Millisecond)
+	s.addPong("192.0.2.1:41641", false, 12*time.Millisecond)
+	s.addPong("192.0.2.1:41641", false, 18*time.Millisecond)
+
+	var sb strings.Builder
+	s.write(&sb, "100.64.0.2")
+	const want = `--- 100.64.0.2 ping statistics ---
+5 pings sent, 4 pongs received, 20.0% loss
+rtt min/avg/max/jitter = 10ms/20ms/40ms/12.67ms
+3 direct, 1 via DERP, 1 path changes
+`
+	if got := sb.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	sb.Reset()
+	s = pingStats{sent: 3}
+	s.write(&sb, "100.64.0.2")
+	if got := sb.String(); !strings.HasSuffix(got, "3 pings sent, 0 pongs received, 100.0% loss\n") {
+		t.Errorf("no replies: got:\n%s", got)
+	}
+}
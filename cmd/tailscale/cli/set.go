@@ -8,10 +8,12 @@
 	"errors"
 	"flag"
 	"fmt"
+	"math"
 	"net/netip"
 	"os/exec"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/client/web"
@@ -62,6 +64,13 @@ type setArgsT struct {
 	snat                   bool
 	statefulFiltering      bool
 	netfilterMode          string
+	portmapProtocols       string
+	portmapLease           time.Duration
+	portmapRenewMargin     time.Duration
+	portmapExternalPort    uint
+	remoteDiagnostics      string
+	addrConflictMitigation string
+	subnetRouteMetric      uint
 }
 
 func newSetFlagSet(goos string, setArgs *setArgsT) *flag.FlagSet {
@@ -70,7 +79,7 @@ func newSetFlagSet(goos string, setArgs *setArgsT) *flag.FlagSet {
 	setf.StringVar(&setArgs.profileName, "nickname", "", "nickname for the current account")
 	setf.BoolVar(&setArgs.acceptRoutes, "accept-routes", false, "accept routes advertised by other Tailscale nodes")
 	setf.BoolVar(&setArgs.acceptDNS, "accept-dns", false, "accept DNS configuration from the admin panel")
-	setf.StringVar(&setArgs.exitNodeIP, "exit-node", "", "Tailscale exit node (IP or base name) for internet traffic, or empty string to not use an exit node")
+	setf.StringVar(&setArgs.exitNodeIP, "exit-node", "", "Tailscale exit node (IP or base name) for internet traffic, \"auto:<expression>\" to choose one automatically (e.g. \"auto:any\" or \"auto:online,tag:exit,region:nyc\"), or empty string to not use an exit node")
 	setf.BoolVar(&setArgs.exitNodeAllowLANAccess, "exit-node-allow-lan-access", false, "Allow direct access to the local network when routing traffic via an exit node")
 	setf.BoolVar(&setArgs.shieldsUp, "shields-up", false, "don't allow incoming connections")
 	setf.BoolVar(&setArgs.runSSH, "ssh", false, "run an SSH server, permitting access per tailnet admin's declared policy")
@@ -82,6 +91,13 @@ func newSetFlagSet(goos string, setArgs *setArgsT) *flag.FlagSet {
 	setf.BoolVar(&setArgs.updateApply, "auto-update", false, "automatically update to the latest available version")
 	setf.BoolVar(&setArgs.postureChecking, "posture-checking", false, hidden+"allow management plane to gather device posture information")
 	setf.BoolVar(&setArgs.runWebClient, "webclient", false, "expose the web interface for managing this node over Tailscale at port 5252")
+	setf.StringVar(&setArgs.portmapProtocols, "portmap-protocols", "", `port mapping protocols to use, most preferred first (comma-separated, from "pcp", "pmp" and "upnp"), or empty string to use all`)
+	setf.DurationVar(&setArgs.portmapLease, "portmap-lease", 0, "lease lifetime to request for port mappings, or 0 for the default of 2h")
+	setf.DurationVar(&setArgs.portmapRenewMargin, "portmap-renew-margin", 0, "how long before a port mapping's lease expires to renew it, or 0 to renew halfway through the lease")
+	setf.UintVar(&setArgs.portmapExternalPort, "portmap-external-port", 0, "external port to request for port mappings, or 0 to let the gateway pick one")
+	setf.StringVar(&setArgs.remoteDiagnostics, "remote-diagnostics", "off", `whether the control plane may collect diagnostics from this machine: "off", "prompt" to ask first with 'tailscale debug remote-diag', or "allow"`)
+	setf.StringVar(&setArgs.addrConflictMitigation, "addr-conflict-mitigation", "", `how to work around local networks using Tailscale's 100.64.0.0/10 or fd7a:115c:a1e0::/48 ranges: "routes" to route only to peers' addresses, "snat" to also SNAT traffic forwarded from the tailnet (Linux only), or empty to only warn`)
+	setf.UintVar(&setArgs.subnetRouteMetric, "subnet-route-metric", 0, "metric (on OpenBSD, priority) of the routes to peers' advertised subnets, so that they lose to this machine's own routes to the same networks; 0 uses the platform default")
 
 	ffcomplete.Flag(setf, "exit-node", func(args []string) ([]string, ffcomplete.ShellCompDirective, error) {
 		st, err := localClient.Status(context.Background())
@@ -129,6 +145,16 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 		return err
 	}
 
+	if setArgs.portmapLease < 0 || setArgs.portmapRenewMargin < 0 {
+		return errors.New("--portmap-lease and --portmap-renew-margin must not be negative")
+	}
+	if setArgs.portmapExternalPort > math.MaxUint16 {
+		return fmt.Errorf("invalid --portmap-external-port %d", setArgs.portmapExternalPort)
+	}
+	if setArgs.subnetRouteMetric > math.MaxUint32 {
+		return fmt.Errorf("invalid --subnet-route-metric %d", setArgs.subnetRouteMetric)
+	}
+
 	// Note that even though we set the values here regardless of whether the
 	// user passed the flag, the value is only used if the user passed the flag.
 	// See updateMaskedPrefsFromUpOrSetFlag.
@@ -152,8 +178,17 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 			AppConnector: ipn.AppConnectorPrefs{
 				Advertise: setArgs.advertiseConnector,
 			},
-			PostureChecking:     setArgs.postureChecking,
-			NoStatefulFiltering: opt.NewBool(!setArgs.statefulFiltering),
+			PortMapping: ipn.PortMappingPrefs{
+				Protocols:          setArgs.portmapProtocols,
+				LeaseSeconds:       uint32(setArgs.portmapLease / time.Second),
+				RenewMarginSeconds: uint32(setArgs.portmapRenewMargin / time.Second),
+				ExternalPort:       uint16(setArgs.portmapExternalPort),
+			},
+			PostureChecking:        setArgs.postureChecking,
+			RemoteDiagnostics:      setArgs.remoteDiagnostics,
+			AddrConflictMitigation: setArgs.addrConflictMitigation,
+			SubnetRouteMetric:      uint32(setArgs.subnetRouteMetric),
+			NoStatefulFiltering:    opt.NewBool(!setArgs.statefulFiltering),
 		},
 	}
 
@@ -169,11 +204,7 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 	}
 
 	if setArgs.exitNodeIP != "" {
-		if err := maskedPrefs.Prefs.SetExitNodeIP(setArgs.exitNodeIP, st); err != nil {
-			var e ipn.ExitNodeLocalIPError
-			if errors.As(err, &e) {
-				return fmt.Errorf("%w; did you mean --advertise-exit-node?", err)
-			}
+		if err := setExitNodeFromFlag(&maskedPrefs.Prefs, setArgs.exitNodeIP, st); err != nil {
 			return err
 		}
 	}
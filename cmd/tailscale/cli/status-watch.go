@@ -0,0 +1,225 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/key"
+	"tailscale.com/util/set"
+)
+
+// Terminal escapes used by watch mode.
+const (
+	vtHighlight   = "\x1b[33m" // yellow, for the rows of peers that just changed
+	vtReset       = "\x1b[0m"
+	vtClearScreen = "\x1b[H\x1b[2J"
+)
+
+const (
+	// statusWatchInterval is the minimum time between two status fetches
+	// in watch mode. Notifications arriving in between are coalesced.
+	statusWatchInterval = time.Second
+
+	// maxStatusWatchChanges is the number of recent changes listed below
+	// the table in watch mode on a terminal.
+	maxStatusWatchChanges = 10
+)
+
+// statusChange is what changed about a peer between two statuses.
+type statusChange struct {
+	peer key.NodePublic
+	name string // the peer's name, as in the status table
+	what string // e.g. `offline; direct 192.0.2.1:41641 -> relay "fra"`
+}
+
+func (c statusChange) String() string {
+	return c.name + ": " + c.what
+}
+
+// peerConnString describes how ps is reached, as in the status table.
+func peerConnString(ps *ipnstate.PeerStatus) string {
+	switch {
+	case ps.CurAddr != "":
+		return "direct " + ps.CurAddr
+	case ps.Relay != "":
+		return fmt.Sprintf("relay %q", ps.Relay)
+	}
+	return "-"
+}
+
+// statusChanges returns the changes of the peers from old to cur, sorted by
+// peer name. It returns nil if old is nil.
+func statusChanges(old, cur *ipnstate.Status) []statusChange {
+	if old == nil {
+		return nil
+	}
+	var changes []statusChange
+	for _, pk := range cur.Peers() {
+		ps := cur.Peer[pk]
+		if ps.ShareeNode {
+			continue
+		}
+		was, ok := old.Peer[pk]
+		if !ok {
+			changes = append(changes, statusChange{pk, dnsOrQuoteHostname(cur, ps), "joined"})
+			continue
+		}
+		var what []string
+		if was.Online != ps.Online {
+			if ps.Online {
+				what = append(what, "online")
+			} else {
+				what = append(what, "offline")
+			}
+		}
+		if was.Active != ps.Active {
+			if ps.Active {
+				what = append(what, "active")
+			} else {
+				what = append(what, "idle")
+			}
+		}
+		if from, to := peerConnString(was), peerConnString(ps); from != to {
+			what = append(what, from+" -> "+to)
+		}
+		if len(what) > 0 {
+			changes = append(changes, statusChange{pk, dnsOrQuoteHostname(cur, ps), strings.Join(what, "; ")})
+		}
+	}
+	for _, pk := range old.Peers() {
+		if ps := old.Peer[pk]; !ps.ShareeNode && cur.Peer[pk] == nil {
+			changes = append(changes, statusChange{pk, dnsOrQuoteHostname(old, ps), "left"})
+		}
+	}
+	slices.SortStableFunc(changes, func(a, b statusChange) int {
+		return strings.Compare(a.name, b.name)
+	})
+	return changes
+}
+
+// runStatusWatch implements 'tailscale status --watch'. It fetches the
+// status whenever tailscaled notifies that something changed, and shows
+// what changed since the previous fetch.
+func runStatusWatch(ctx context.Context) error {
+	watcher, err := localClient.WatchIPNBus(ctx, ipn.NotifyInitialState|ipn.NotifyWatchEngineUpdates|ipn.NotifyNoPrivateKeys|ipn.NotifyRateLimit)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	defer watcher.Close()
+
+	changed := make(chan struct{}, 1)
+	errc := make(chan error, 1)
+	go func() {
+		for {
+			n, err := watcher.Next()
+			if err != nil {
+				errc <- err
+				return
+			}
+			if n.State != nil || n.NetMap != nil || n.Engine != nil || n.Health != nil {
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	getStatus := localClient.Status
+	if !statusArgs.peers {
+		getStatus = localClient.StatusWithoutPeers
+	}
+	out, onTerminal := colorableOutput()
+	var last *ipnstate.Status
+	var recent []string // the most recent changes, oldest first
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errc:
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		case <-changed:
+		}
+		st, err := getStatus(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		now := time.Now().Format(time.TimeOnly)
+
+		var lines []string
+		if last != nil && last.BackendState != st.BackendState {
+			lines = append(lines, "state: "+st.BackendState)
+		}
+		changes := statusChanges(last, st)
+		highlight := make(set.Set[key.NodePublic])
+		for _, c := range changes {
+			lines = append(lines, c.String())
+			highlight.Add(c.peer)
+		}
+
+		var buf bytes.Buffer
+		switch {
+		case onTerminal:
+			for _, l := range lines {
+				recent = append(recent, now+" "+l)
+			}
+			if len(recent) > maxStatusWatchChanges {
+				recent = recent[len(recent)-maxStatusWatchChanges:]
+			}
+			buf.WriteString(vtClearScreen)
+			fmt.Fprintf(&buf, "# %s; watching for changes, press Ctrl-C to stop\n\n", now)
+			writeStatusWatchTable(&buf, st, highlight)
+			if len(recent) > 0 {
+				buf.WriteString("\n# Recent changes:\n")
+				for _, l := range recent {
+					fmt.Fprintf(&buf, "#     - %s\n", l)
+				}
+			}
+		case last == nil:
+			writeStatusWatchTable(&buf, st, nil)
+		default:
+			for _, l := range lines {
+				fmt.Fprintf(&buf, "%s %s\n", now, l)
+			}
+		}
+		out.Write(buf.Bytes())
+		last = st
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(statusWatchInterval):
+		}
+	}
+}
+
+// writeStatusWatchTable writes the status table to w, or a description of
+// the backend state if it's not running, followed by any health warnings.
+func writeStatusWatchTable(w *bytes.Buffer, st *ipnstate.Status, highlight set.Set[key.NodePublic]) {
+	if description, ok := isRunningOrStarting(st); ok {
+		writeStatusTable(w, st, highlight)
+	} else {
+		fmt.Fprintln(w, description)
+	}
+	if len(st.Health) > 0 {
+		w.WriteString("\n# Health check:\n")
+		for _, m := range st.Health {
+			fmt.Fprintf(w, "#     - %s\n", m)
+		}
+	}
+}
@@ -11,6 +11,7 @@
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/netip"
@@ -24,15 +25,27 @@
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/net/netmon"
+	"tailscale.com/types/key"
 	"tailscale.com/util/dnsname"
+	"tailscale.com/util/set"
 )
 
 var statusCmd = &ffcli.Command{
 	Name:       "status",
-	ShortUsage: "tailscale status [--active] [--web] [--json]",
+	ShortUsage: "tailscale status [--active] [--web] [--json] [--watch]",
 	ShortHelp:  "Show state of tailscaled and its connections",
 	LongHelp: strings.TrimSpace(`
 
+WATCH MODE
+
+With --watch, the status is updated as tailscaled reports changes, until
+interrupted. On a terminal, the table is redrawn with the rows of the peers
+that just changed highlighted, followed by the most recent changes. Otherwise,
+the table is printed once, followed by a line for each change as it happens.
+The changes reported are peers going online or offline, becoming active or
+idle, switching between direct and relayed (DERP) connections or between
+endpoints, and joining or leaving the tailnet.
+
 JSON FORMAT
 
 Warning: this format has changed between releases and might change more
@@ -56,6 +69,7 @@
 		fs.BoolVar(&statusArgs.peers, "peers", true, "show status of peers")
 		fs.StringVar(&statusArgs.listen, "listen", "127.0.0.1:8384", "listen address for web mode; use port 0 for automatic")
 		fs.BoolVar(&statusArgs.browser, "browser", true, "Open a browser in web mode")
+		fs.BoolVar(&statusArgs.watch, "watch", false, "keep running, updating the status and showing peers' changes as they happen")
 		return fs
 	})(),
 }
@@ -68,12 +82,19 @@
 	active  bool   // in CLI mode, filter output to only peers with active sessions
 	self    bool   // in CLI mode, show status of local machine
 	peers   bool   // in CLI mode, show status of peer machines
+	watch   bool   // in CLI mode, keep showing changes
 }
 
 func runStatus(ctx context.Context, args []string) error {
 	if len(args) > 0 {
 		return errors.New("unexpected non-flag arguments to 'tailscale status'")
 	}
+	if statusArgs.watch {
+		if statusArgs.json || statusArgs.web {
+			return errors.New("--watch can't be used with --json or --web")
+		}
+		return runStatusWatch(ctx)
+	}
 	getStatus := localClient.Status
 	if !statusArgs.peers {
 		getStatus = localClient.StatusWithoutPeers
@@ -150,8 +171,31 @@ func runStatus(ctx context.Context, args []string) error {
 	}
 
 	var buf bytes.Buffer
-	f := func(format string, a ...any) { fmt.Fprintf(&buf, format, a...) }
+	locBasedExitNode := writeStatusTable(&buf, st, nil)
+	Stdout.Write(buf.Bytes())
+	if locBasedExitNode {
+		outln()
+		printf("# To see the full list of exit nodes, including location-based exit nodes, run `tailscale exit-node list`  \n")
+	}
+	if len(st.Health) > 0 {
+		outln()
+		printHealth()
+	}
+	printFunnelStatus(ctx)
+	return nil
+}
+
+// writeStatusTable writes the table of st's self and peers shown by
+// 'tailscale status' to w, as filtered by statusArgs. The rows of the peers
+// in highlight are colored, for watch mode on a terminal. It reports whether
+// it left out location-based exit nodes.
+func writeStatusTable(w io.Writer, st *ipnstate.Status, highlight set.Set[key.NodePublic]) (locBasedExitNode bool) {
+	f := func(format string, a ...any) { fmt.Fprintf(w, format, a...) }
 	printPS := func(ps *ipnstate.PeerStatus) {
+		hl := highlight.Contains(ps.PublicKey)
+		if hl {
+			f(vtHighlight)
+		}
 		f("%-15s %-20s %-12s %-7s ",
 			firstIPString(ps.TailscaleIPs),
 			dnsOrQuoteHostname(st, ps),
@@ -195,6 +239,9 @@ func runStatus(ctx context.Context, args []string) error {
 		if anyTraffic {
 			f(", tx %d rx %d", ps.TxBytes, ps.RxBytes)
 		}
+		if hl {
+			f(vtReset)
+		}
 		f("\n")
 	}
 
@@ -202,7 +249,6 @@ func runStatus(ctx context.Context, args []string) error {
 		printPS(st.Self)
 	}
 
-	locBasedExitNode := false
 	if statusArgs.peers {
 		var peers []*ipnstate.PeerStatus
 		for _, peer := range st.Peers() {
@@ -226,17 +272,7 @@ func runStatus(ctx context.Context, args []string) error {
 			printPS(ps)
 		}
 	}
-	Stdout.Write(buf.Bytes())
-	if locBasedExitNode {
-		outln()
-		printf("# To see the full list of exit nodes, including location-based exit nodes, run `tailscale exit-node list`  \n")
-	}
-	if len(st.Health) > 0 {
-		outln()
-		printHealth()
-	}
-	printFunnelStatus(ctx)
-	return nil
+	return locBasedExitNode
 }
 
 // printFunnelStatus prints the status of the funnel, if it's running.
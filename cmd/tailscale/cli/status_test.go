@@ -0,0 +1,73 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"reflect"
+	"testing"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/key"
+)
+
+func TestStatusChanges(t *testing.T) {
+	var keys [5]key.NodePublic
+	for i := range keys {
+		keys[i] = key.NewNode().Public()
+	}
+	peer := func(i int, name string, online, active bool, curAddr, relay string) *ipnstate.PeerStatus {
+		return &ipnstate.PeerStatus{
+			PublicKey: keys[i],
+			DNSName:   name + ".example.ts.net.",
+			Online:    online,
+			Active:    active,
+			CurAddr:   curAddr,
+			Relay:     relay,
+		}
+	}
+	status := func(peers ...*ipnstate.PeerStatus) *ipnstate.Status {
+		st := &ipnstate.Status{
+			MagicDNSSuffix: "example.ts.net",
+			Peer:           map[key.NodePublic]*ipnstate.PeerStatus{},
+		}
+		for _, ps := range peers {
+			st.Peer[ps.PublicKey] = ps
+		}
+		return st
+	}
+
+	old := status(
+		peer(0, "alpha", true, true, "", "fra"),
+		peer(1, "bravo", true, false, "", "nyc"),
+		peer(2, "charlie", true, true, "192.0.2.1:41641", "fra"),
+		peer(3, "delta", true, false, "", "fra"),
+	)
+	cur := status(
+		peer(0, "alpha", true, true, "198.51.100.7:41641", "fra"),
+		peer(1, "bravo", false, false, "", "nyc"),
+		peer(2, "charlie", true, false, "192.0.2.1:41641", "fra"),
+		peer(4, "echo", true, false, "", "sea"),
+	)
+
+	if got := statusChanges(nil, cur); got != nil {
+		t.Errorf("changes from nil status = %v; want none", got)
+	}
+	var got []string
+	for _, c := range statusChanges(old, cur) {
+		got = append(got, c.String())
+	}
+	want := []string{
+		`alpha: relay "fra" -> direct 198.51.100.7:41641`,
+		"bravo: offline",
+		"charlie: idle",
+		"delta: left",
+		"echo: joined",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q\nwant %q", got, want)
+	}
+	if got := statusChanges(cur, cur); len(got) != 0 {
+		t.Errorf("changes between identical statuses = %v", got)
+	}
+}
@@ -59,7 +59,7 @@
 If flags are specified, the flags must be the complete set of desired
 settings. An error is returned if any setting would be changed as a
 result of an unspecified flag's default value, unless the --reset flag
-is also used. (The flags --auth-key, --force-reauth, and --qr are not
+is also used. (The flags --auth-key, --auth-flow, --force-reauth, and --qr are not
 considered settings that need to be re-specified when modifying
 settings.)
 `),
@@ -102,12 +102,13 @@ func newUpFlagSet(goos string, upArgs *upArgsT, cmd string) *flag.FlagSet {
 	// of here. Setting preferences via "tailscale up" is deprecated.
 	upf.BoolVar(&upArgs.qr, "qr", false, "show QR code for login URLs")
 	upf.StringVar(&upArgs.authKeyOrFile, "auth-key", "", `node authorization key; if it begins with "file:", then it's a path to a file containing the authkey`)
+	upf.StringVar(&upArgs.authFlow, "auth-flow", "browser", `interactive login flow: "browser", or "device-code" to print a URL and a code that can be entered there from any device`)
 
 	upf.StringVar(&upArgs.server, "login-server", ipn.DefaultControlURL, "base URL of control server")
 	upf.BoolVar(&upArgs.acceptRoutes, "accept-routes", acceptRouteDefault(goos), "accept routes advertised by other Tailscale nodes")
 	upf.BoolVar(&upArgs.acceptDNS, "accept-dns", true, "accept DNS configuration from the admin panel")
 	upf.Var(notFalseVar{}, "host-routes", hidden+"install host routes to other Tailscale nodes (must be true as of Tailscale 1.67+)")
-	upf.StringVar(&upArgs.exitNodeIP, "exit-node", "", "Tailscale exit node (IP or base name) for internet traffic, or empty string to not use an exit node")
+	upf.StringVar(&upArgs.exitNodeIP, "exit-node", "", "Tailscale exit node (IP or base name) for internet traffic, \"auto:<expression>\" to choose one automatically (e.g. \"auto:any\" or \"auto:online,tag:exit,region:nyc\"), or empty string to not use an exit node")
 	upf.BoolVar(&upArgs.exitNodeAllowLANAccess, "exit-node-allow-lan-access", false, "Allow direct access to the local network when routing traffic via an exit node")
 	upf.BoolVar(&upArgs.shieldsUp, "shields-up", false, "don't allow incoming connections")
 	upf.BoolVar(&upArgs.runSSH, "ssh", false, "run an SSH server, permitting access per tailnet admin's declared policy")
@@ -189,6 +190,7 @@ type upArgsT struct {
 	statefulFiltering      bool
 	netfilterMode          string
 	authKeyOrFile          string // "secret" or "file:/path/to/secret"
+	authFlow               string // "browser" or "device-code"
 	hostname               string
 	opUser                 string
 	json                   bool
@@ -235,6 +237,7 @@ func (a upArgsT) getAuthKey() (string, error) {
 //	}
 type upOutputJSON struct {
 	AuthURL      string `json:",omitempty"` // Authentication URL of the form https://login.tailscale.com/a/0123456789
+	UserCode     string `json:",omitempty"` // code to enter at AuthURL, with --auth-flow=device-code
 	QR           string `json:",omitempty"` // a DataURL (base64) PNG of a QR code AuthURL
 	BackendState string `json:",omitempty"` // name of state like Running or NeedsMachineAuth
 	Error        string `json:",omitempty"` // description of an error
@@ -285,11 +288,7 @@ func prefsFromUpArgs(upArgs upArgsT, warnf logger.Logf, st *ipnstate.Status, goo
 		prefs.NetfilterMode = preftype.NetfilterOff
 	}
 	if upArgs.exitNodeIP != "" {
-		if err := prefs.SetExitNodeIP(upArgs.exitNodeIP, st); err != nil {
-			var e ipn.ExitNodeLocalIPError
-			if errors.As(err, &e) {
-				return nil, fmt.Errorf("%w; did you mean --advertise-exit-node?", err)
-			}
+		if err := setExitNodeFromFlag(prefs, upArgs.exitNodeIP, st); err != nil {
 			return nil, err
 		}
 	}
@@ -442,6 +441,16 @@ func runUp(ctx context.Context, cmd string, args []string, upArgs upArgsT) (retE
 		}
 	}
 
+	switch upArgs.authFlow {
+	case "", "browser":
+	case "device-code":
+		if upArgs.authKeyOrFile != "" {
+			return errors.New("--auth-flow=device-code and --auth-key are mutually exclusive")
+		}
+	default:
+		return fmt.Errorf("invalid --auth-flow %q; want \"browser\" or \"device-code\"", upArgs.authFlow)
+	}
+
 	st, err := localClient.Status(ctx)
 	if err != nil {
 		return fixTailscaledConnectError(err)
@@ -574,7 +583,11 @@ func runUp(ctx context.Context, cmd string, args []string, upArgs upArgsT) (retE
 			return err
 		}
 		if upArgs.forceReauth || !st.HaveNodeKey {
-			err := localClient.StartLoginInteractive(ctx)
+			if upArgs.authFlow == "device-code" {
+				err = localClient.StartDeviceCodeLogin(ctx)
+			} else {
+				err = localClient.StartLoginInteractive(ctx)
+			}
 			if err != nil {
 				return err
 			}
@@ -632,8 +645,12 @@ func runUp(ctx context.Context, cmd string, args []string, upArgs upArgsT) (retE
 				}
 				printed = true
 				lastURLPrinted = authURL
+				var userCode string
+				if n.LoginUserCode != nil {
+					userCode = *n.LoginUserCode
+				}
 				if upArgs.json {
-					js := &upOutputJSON{AuthURL: authURL, BackendState: st.BackendState}
+					js := &upOutputJSON{AuthURL: authURL, UserCode: userCode, BackendState: st.BackendState}
 
 					q, err := qrcode.New(authURL, qrcode.Medium)
 					if err == nil {
@@ -650,7 +667,11 @@ func runUp(ctx context.Context, cmd string, args []string, upArgs upArgsT) (retE
 						outln(string(data))
 					}
 				} else {
-					fmt.Fprintf(Stderr, "\nTo authenticate, visit:\n\n\t%s\n\n", authURL)
+					if userCode != "" {
+						fmt.Fprintf(Stderr, "\nTo authenticate, visit:\n\n\t%s\n\nand enter the code:\n\n\t%s\n\n", authURL, userCode)
+					} else {
+						fmt.Fprintf(Stderr, "\nTo authenticate, visit:\n\n\t%s\n\n", authURL)
+					}
 					if upArgs.qr {
 						q, err := qrcode.New(authURL, qrcode.Medium)
 						if err != nil {
@@ -758,7 +779,7 @@ func init() {
 	addPrefFlagMapping("advertise-routes", "AdvertiseRoutes")
 
 	// And this flag has two ipn.Prefs:
-	addPrefFlagMapping("exit-node", "ExitNodeIP", "ExitNodeID")
+	addPrefFlagMapping("exit-node", "ExitNodeIP", "ExitNodeID", "AutoExitNode")
 
 	// The rest are 1:1:
 	addPrefFlagMapping("accept-dns", "CorpDNS")
@@ -780,6 +801,13 @@ func init() {
 	addPrefFlagMapping("auto-update", "AutoUpdate.Apply")
 	addPrefFlagMapping("advertise-connector", "AppConnector")
 	addPrefFlagMapping("posture-checking", "PostureChecking")
+	addPrefFlagMapping("portmap-protocols", "PortMapping.Protocols")
+	addPrefFlagMapping("portmap-lease", "PortMapping.LeaseSeconds")
+	addPrefFlagMapping("portmap-renew-margin", "PortMapping.RenewMarginSeconds")
+	addPrefFlagMapping("portmap-external-port", "PortMapping.ExternalPort")
+	addPrefFlagMapping("remote-diagnostics", "RemoteDiagnostics")
+	addPrefFlagMapping("addr-conflict-mitigation", "AddrConflictMitigation")
+	addPrefFlagMapping("subnet-route-metric", "SubnetRouteMetric")
 }
 
 func addPrefFlagMapping(flagName string, prefNames ...string) {
@@ -802,7 +830,7 @@ func addPrefFlagMapping(flagName string, prefNames ...string) {
 // correspond to an ipn.Pref.
 func preflessFlag(flagName string) bool {
 	switch flagName {
-	case "auth-key", "force-reauth", "reset", "qr", "json", "timeout", "accept-risk", "host-routes":
+	case "auth-key", "auth-flow", "force-reauth", "reset", "qr", "json", "timeout", "accept-risk", "host-routes":
 		return true
 	}
 	return false
@@ -981,6 +1009,9 @@ func prefsToFlags(env upCheckEnv, prefs *ipn.Prefs) (flagVal map[string]any) {
 	ret := make(map[string]any)
 
 	exitNodeIPStr := func() string {
+		if prefs.AutoExitNode != "" {
+			return prefs.AutoExitNode.AutoString()
+		}
 		if prefs.ExitNodeIP.IsValid() {
 			return prefs.ExitNodeIP.String()
 		}
@@ -1073,6 +1104,28 @@ func fmtFlagValueArg(flagName string, val any) string {
 	return fmt.Sprintf("--%s=%v", flagName, shellquote.Join(fmt.Sprint(val)))
 }
 
+// setExitNodeFromFlag sets the exit node in p from the value v of the
+// --exit-node flag: an IP or base name, or "auto:<expression>" to choose
+// the exit node automatically.
+func setExitNodeFromFlag(p *ipn.Prefs, v string, st *ipnstate.Status) error {
+	if expr, ok := strings.CutPrefix(v, "auto:"); ok {
+		e := ipn.ExitNodeExpression(expr)
+		if _, err := e.Parse(); err != nil {
+			return fmt.Errorf("invalid --exit-node: %w", err)
+		}
+		p.AutoExitNode = e
+		return nil
+	}
+	if err := p.SetExitNodeIP(v, st); err != nil {
+		var e ipn.ExitNodeLocalIPError
+		if errors.As(err, &e) {
+			return fmt.Errorf("%w; did you mean --advertise-exit-node?", err)
+		}
+		return err
+	}
+	return nil
+}
+
 // exitNodeIP returns the exit node IP from p, using st to map
 // it from its ID form to an IP address if needed.
 func exitNodeIP(p *ipn.Prefs, st *ipnstate.Status) (ip netip.Addr) {
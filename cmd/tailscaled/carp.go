@@ -0,0 +1,64 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/net/carp"
+	"tailscale.com/net/netmon"
+	"tailscale.com/types/logger"
+)
+
+// carpPollInterval is how often the CARP state is checked in the absence
+// of network change events. CARP itself fails over after about three
+// advertisement intervals (3s by default), so this adds little to that.
+// Hooks calling the carp-state LocalAPI (see 'tailscale configure carp')
+// make the handover immediate.
+const carpPollInterval = time.Second
+
+// runCARPWatcher withholds lb's advertised subnet routes while the CARP
+// virtual host spec is not MASTER, until ctx is done, so that of a pair of
+// subnet routers sharing LAN addresses over CARP, only the master is used
+// by peers.
+func runCARPWatcher(ctx context.Context, logf logger.Logf, lb *ipnlocal.LocalBackend, netMon *netmon.Monitor, spec carp.Spec) {
+	logf = logger.WithPrefix(logf, "carp: ")
+	changed := make(chan struct{}, 1)
+	unregister := netMon.RegisterChangeCallback(func(*netmon.ChangeDelta) {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	defer unregister()
+
+	t := time.NewTicker(carpPollInterval)
+	defer t.Stop()
+	var last carp.State
+	var lastErr string
+	for {
+		st, err := carp.Get(spec)
+		switch {
+		case err != nil:
+			// Keep the last known state; flapping the routes on a
+			// transient ifconfig failure would be worse.
+			if err.Error() != lastErr {
+				logf("%v: %v", spec, err)
+				lastErr = err.Error()
+			}
+		case st != last:
+			logf("%v is %v", spec, st)
+			last, lastErr = st, ""
+			lb.SetRoutesStandby(st != carp.Master)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-changed:
+		}
+	}
+}
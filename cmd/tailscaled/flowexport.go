@@ -0,0 +1,51 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"errors"
+	"math"
+	"net/netip"
+	"strings"
+
+	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/types/logger"
+	"tailscale.com/wgengine/netlog"
+)
+
+// flowExporter is the exporter configured by --flow-export, or nil.
+var flowExporter *netlog.IPFIXExporter
+
+func configureFlowExport(logf logger.Logf) error {
+	if args.flowExport == "" {
+		if args.flowExportEnterprise != 0 {
+			return errors.New("--flow-export-enterprise requires a collector")
+		}
+		return nil
+	}
+	if args.flowExportEnterprise > math.MaxUint32 {
+		return errors.New("--flow-export-enterprise is out of range")
+	}
+	x, err := netlog.NewIPFIXExporter(args.flowExport, uint32(args.flowExportEnterprise), 0, logf)
+	if err != nil {
+		return err
+	}
+	flowExporter = x
+	return nil
+}
+
+// configureFlowExportAttribution makes the flow exporter, if any, name the
+// nodes and users of flows as lb knows them.
+func configureFlowExportAttribution(lb *ipnlocal.LocalBackend) {
+	if flowExporter == nil {
+		return
+	}
+	flowExporter.SetAttribution(func(ip netip.Addr) (node, user string) {
+		n, u, ok := lb.WhoIs("", netip.AddrPortFrom(ip, 0))
+		if !ok {
+			return "", ""
+		}
+		return strings.TrimSuffix(n.Name(), "."), u.LoginName
+	})
+}
@@ -9,16 +9,26 @@
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/netip"
+	"os"
+	"os/user"
+	"runtime"
 	"strings"
+
+	"tailscale.com/util/set"
 )
 
 // httpProxyHandler returns an HTTP proxy http.Handler using the
-// provided backend dialer.
-func httpProxyHandler(dialer func(ctx context.Context, netw, addr string) (net.Conn, error)) http.Handler {
+// provided backend dialer. If auth is non-nil, clients must be run by one
+// of its users and may only reach that user's destinations; the handler's
+// http.Server must then use withConn as its ConnContext.
+func httpProxyHandler(dialer func(ctx context.Context, netw, addr string) (net.Conn, error), auth *httpProxyAuth) http.Handler {
 	rp := &httputil.ReverseProxy{
 		Director: func(r *http.Request) {}, // no change
 		Transport: &http.Transport{
@@ -32,6 +42,9 @@ func httpProxyHandler(dialer func(ctx context.Context, netw, addr string) (net.C
 				http.Error(w, "bogus RequestURI; must be absolute URL or CONNECT", 400)
 				return
 			}
+			if auth != nil && !auth.authorize(w, r, r.URL.Host) {
+				return
+			}
 			rp.ServeHTTP(w, r)
 			return
 		}
@@ -39,6 +52,9 @@ func httpProxyHandler(dialer func(ctx context.Context, netw, addr string) (net.C
 		// CONNECT support:
 
 		dst := r.RequestURI
+		if auth != nil && !auth.authorize(w, r, dst) {
+			return
+		}
 		c, err := dialer(r.Context(), "tcp", dst)
 		if err != nil {
 			w.Header().Set("Tailscale-Connect-Error", err.Error())
@@ -78,3 +94,154 @@ func httpProxyHandler(dialer func(ctx context.Context, netw, addr string) (net.C
 		<-errc
 	})
 }
+
+// httpProxyAuth is the --outbound-http-proxy-auth file, in JSON.
+//
+// It lists the local users allowed to use the proxy and the tailnet
+// destinations each may reach, so that a shared host can run the proxy
+// without letting every local process reach the whole tailnet. Clients
+// aren't asked for credentials: the proxy identifies the local user on the
+// other end of each connection from the kernel, so it only accepts
+// connections from this host, and only where it can do so (currently,
+// Linux).
+type httpProxyAuth struct {
+	// Users maps the names of local users to the destinations they may
+	// reach. Connections from users not listed are refused.
+	Users map[string]*httpProxyUser
+
+	// connUser returns the name of the local user on the other end of c.
+	// It's connUsername, except in tests.
+	connUser func(c net.Conn) (string, error)
+}
+
+// httpProxyUser is a local user of the HTTP proxy.
+type httpProxyUser struct {
+	// AllowDst, if non-empty, limits the destinations the user may
+	// reach to those with these host names (such as MagicDNS names)
+	// or IP addresses, or with IP addresses in these CIDR prefixes.
+	AllowDst []string `json:",omitempty"`
+
+	names    set.Set[string] // from AllowDst
+	prefixes []netip.Prefix  // from AllowDst
+}
+
+// loadHTTPProxyAuth reads the httpProxyAuth file at path. It returns nil
+// if path is empty.
+func loadHTTPProxyAuth(path string) (*httpProxyAuth, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if !canIdentifyConnUser {
+		return nil, fmt.Errorf("identifying the local users of proxy connections isn't supported on %s", runtime.GOOS)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	auth := httpProxyAuth{connUser: connUsername}
+	if err := json.Unmarshal(b, &auth); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if len(auth.Users) == 0 {
+		return nil, fmt.Errorf("%s: no users", path)
+	}
+	for name, u := range auth.Users {
+		if u == nil {
+			u = new(httpProxyUser)
+			auth.Users[name] = u
+		}
+		u.parseAllowDst()
+	}
+	return &auth, nil
+}
+
+// parseAllowDst fills in u.names and u.prefixes from u.AllowDst.
+func (u *httpProxyUser) parseAllowDst() {
+	for _, dst := range u.AllowDst {
+		if ip, err := netip.ParseAddr(dst); err == nil {
+			u.prefixes = append(u.prefixes, netip.PrefixFrom(ip, ip.BitLen()))
+		} else if p, err := netip.ParsePrefix(dst); err == nil {
+			u.prefixes = append(u.prefixes, p.Masked())
+		} else {
+			u.names.Make()
+			u.names.Add(canonHost(dst))
+		}
+	}
+}
+
+// connContextKey is the context key for the net.Conn an HTTP proxy
+// request arrived on.
+type connContextKey struct{}
+
+// withConn is an http.Server.ConnContext func that records the connection
+// requests arrive on, for httpProxyAuth to identify its user.
+func withConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// connUsername returns the name of the local user on the other end of c,
+// a connection from this host.
+func connUsername(c net.Conn) (string, error) {
+	uid, err := connUID(c)
+	if err != nil {
+		return "", err
+	}
+	u, err := user.LookupId(uid)
+	if err != nil {
+		return "", err
+	}
+	return u.Username, nil
+}
+
+// authorize reports whether r may be proxied to dst, an address in the
+// form "host:port" or, for plain HTTP requests, "host". If not, it writes
+// an error response to w.
+func (a *httpProxyAuth) authorize(w http.ResponseWriter, r *http.Request, dst string) bool {
+	c, _ := r.Context().Value(connContextKey{}).(net.Conn)
+	if c == nil {
+		http.Error(w, "no connection to identify the user of", http.StatusInternalServerError)
+		return false
+	}
+	name, err := a.connUser(c)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("can't identify the local user: %v", err), http.StatusForbidden)
+		return false
+	}
+	u := a.Users[name]
+	if u == nil {
+		http.Error(w, fmt.Sprintf("user %q may not use the proxy", name), http.StatusForbidden)
+		return false
+	}
+	if !u.allows(dst) {
+		http.Error(w, fmt.Sprintf("user %q may not reach %q", name, dst), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// allows reports whether u may reach dst, in the form "host:port" or
+// "host".
+func (u *httpProxyUser) allows(dst string) bool {
+	if len(u.AllowDst) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(dst)
+	if err != nil {
+		host = strings.TrimSuffix(strings.TrimPrefix(dst, "["), "]")
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		ip = ip.Unmap()
+		for _, p := range u.prefixes {
+			if p.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return u.names.Contains(canonHost(host))
+}
+
+// canonHost returns host in lower case and without any trailing dot.
+func canonHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
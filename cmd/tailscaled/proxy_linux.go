@@ -0,0 +1,93 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// canIdentifyConnUser is whether connUID works on this platform.
+const canIdentifyConnUser = true
+
+// connUID returns the uid of the owner of the other end of c, a TCP
+// connection from this host, by finding its socket in the kernel's tables.
+func connUID(c net.Conn) (string, error) {
+	la, lok := c.LocalAddr().(*net.TCPAddr)
+	ra, rok := c.RemoteAddr().(*net.TCPAddr)
+	if !lok || !rok {
+		return "", fmt.Errorf("not a TCP connection")
+	}
+	local, remote := unmapAddrPort(la.AddrPort()), unmapAddrPort(ra.AddrPort())
+	if !remote.Addr().IsLoopback() && remote.Addr() != local.Addr() {
+		return "", fmt.Errorf("connection from %v isn't from this host", remote)
+	}
+	for _, f := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		table, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		// The peer's socket is the one whose local address is our
+		// remote one, and vice versa.
+		if uid, ok := tcpSocketUID(table, remote, local); ok {
+			return uid, nil
+		}
+	}
+	return "", fmt.Errorf("socket of connection from %v not found", remote)
+}
+
+// tcpSocketUID returns the uid of the socket from local to remote in
+// table, the contents of /proc/net/tcp or /proc/net/tcp6.
+func tcpSocketUID(table []byte, local, remote netip.AddrPort) (uid string, ok bool) {
+	s := bufio.NewScanner(bytes.NewReader(table))
+	s.Scan() // header
+	for s.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid ...
+		f := strings.Fields(s.Text())
+		if len(f) < 8 {
+			continue
+		}
+		l, lerr := parseProcNetAddr(f[1])
+		r, rerr := parseProcNetAddr(f[2])
+		if lerr == nil && rerr == nil && l == local && r == remote {
+			return f[7], true
+		}
+	}
+	return "", false
+}
+
+// parseProcNetAddr parses an address in /proc/net/tcp{,6}, such as
+// "0100007F:1F90": the address's 32-bit words in hex, each in host byte
+// order, then the port in hex. IPv4-mapped IPv6 addresses are unmapped.
+func parseProcNetAddr(s string) (netip.AddrPort, error) {
+	addrHex, portHex, ok := strings.Cut(s, ":")
+	if !ok {
+		return netip.AddrPort{}, fmt.Errorf("invalid address %q", s)
+	}
+	b, err := hex.DecodeString(addrHex)
+	if err != nil || (len(b) != 4 && len(b) != 16) {
+		return netip.AddrPort{}, fmt.Errorf("invalid address %q", s)
+	}
+	for i := 0; i < len(b); i += 4 {
+		binary.NativeEndian.PutUint32(b[i:], binary.BigEndian.Uint32(b[i:]))
+	}
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid port in %q", s)
+	}
+	ip, _ := netip.AddrFromSlice(b)
+	return netip.AddrPortFrom(ip.Unmap(), uint16(port)), nil
+}
+
+func unmapAddrPort(ap netip.AddrPort) netip.AddrPort {
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+}
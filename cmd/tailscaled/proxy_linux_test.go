@@ -0,0 +1,53 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestConnUID(t *testing.T) {
+	tests := []struct {
+		name       string
+		listenAddr string
+		dialHost   string
+	}{
+		{"ipv4", "127.0.0.1:0", "127.0.0.1"},
+		{"ipv6", "[::1]:0", "::1"},
+		// IPv4 connections to a dual-stack listener appear in
+		// /proc/net/tcp6 with IPv4-mapped addresses.
+		{"ipv4-mapped", "[::]:0", "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", tt.listenAddr)
+			if err != nil {
+				t.Skip(err)
+			}
+			defer ln.Close()
+			port := ln.Addr().(*net.TCPAddr).Port
+			cc, err := net.Dial("tcp", net.JoinHostPort(tt.dialHost, strconv.Itoa(port)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cc.Close()
+			sc, err := ln.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sc.Close()
+
+			uid, err := connUID(sc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := strconv.Itoa(os.Getuid()); uid != want {
+				t.Errorf("connUID = %q, want %q", uid, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// canIdentifyConnUser is whether connUID works on this platform.
+const canIdentifyConnUser = false
+
+func connUID(net.Conn) (string, error) {
+	return "", errors.ErrUnsupported
+}
@@ -0,0 +1,52 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPProxyAuthorize(t *testing.T) {
+	auth := &httpProxyAuth{
+		Users: map[string]*httpProxyUser{
+			"alice": {AllowDst: []string{"db.example.ts.net", "100.64.0.0/10"}},
+			"bob":   {},
+		},
+	}
+	for _, u := range auth.Users {
+		u.parseAllowDst()
+	}
+
+	tests := []struct {
+		user     string
+		userErr  error
+		dst      string
+		wantCode int
+	}{
+		{user: "alice", dst: "db.example.ts.net:5432", wantCode: http.StatusOK},
+		{user: "alice", dst: "DB.example.ts.net.:5432", wantCode: http.StatusOK},
+		{user: "alice", dst: "100.101.102.103:22", wantCode: http.StatusOK},
+		{user: "alice", dst: "web.example.ts.net:443", wantCode: http.StatusForbidden},
+		{user: "bob", dst: "web.example.ts.net:443", wantCode: http.StatusOK},
+		{user: "mallory", dst: "db.example.ts.net:5432", wantCode: http.StatusForbidden},
+		{userErr: errors.New("unknown"), dst: "db.example.ts.net:5432", wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		auth.connUser = func(net.Conn) (string, error) { return tt.user, tt.userErr }
+		r := httptest.NewRequest("CONNECT", "http://"+tt.dst, nil)
+		r = r.WithContext(withConn(context.Background(), &net.TCPConn{}))
+		w := httptest.NewRecorder()
+		if auth.authorize(w, r, tt.dst) {
+			w.WriteHeader(http.StatusOK)
+		}
+		if w.Code != tt.wantCode {
+			t.Errorf("user %q (err %v) to %s: got %d, want %d", tt.user, tt.userErr, tt.dst, w.Code, tt.wantCode)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+
+	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/agentx"
+	"tailscale.com/types/key"
+	"tailscale.com/types/logger"
+)
+
+// snmpRoot is the root of the subtree tailscaled registers with the master
+// SNMP agent. It lives under the experimental arc (RFC 1155) rather than
+// an enterprise number, so monitoring systems need to be configured with
+// the OIDs below rather than a vendor MIB.
+//
+// The layout under it is:
+//
+//	.1.1.0  interface name (OCTET STRING)
+//	.1.2.0  bytes received from peers (Counter64)
+//	.1.3.0  bytes sent to peers (Counter64)
+//	.2.1.0  peers in the netmap (Gauge32)
+//	.2.2.0  peers connected to control (Gauge32)
+//	.2.3.0  peers with recent traffic (Gauge32)
+//	.3.1.0  home DERP region code (OCTET STRING)
+//	.4.1.0  number of health warnings (Gauge32)
+//	.4.2.0  health warnings, newline-separated (OCTET STRING)
+//	.5.1.0  backend state (OCTET STRING)
+var snmpRoot = agentx.MustParseOID("1.3.6.1.3.41641")
+
+// runSNMPAgent runs an AgentX subagent connected to the master agent
+// socket at socketPath, serving statistics from lb until ctx is done.
+func runSNMPAgent(ctx context.Context, logf logger.Logf, lb *ipnlocal.LocalBackend, socketPath string) {
+	var counters snmpByteCounters
+	a := &agentx.Subagent{
+		Logf:        logger.WithPrefix(logf, "snmp: "),
+		Root:        snmpRoot,
+		Description: "tailscaled",
+		Vars: func() []agentx.Var {
+			return snmpVars(lb.Status(), args.tunname, &counters)
+		},
+		Dial: func(ctx context.Context) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socketPath)
+		},
+	}
+	a.Run(ctx)
+}
+
+// snmpByteCounters keeps the totals of bytes received from and sent to
+// peers as the monotonic counters SNMP requires. Summing the peers' own
+// counters isn't enough, as a peer's counters go with it when it leaves
+// the netmap or WireGuard forgets it, so the sum can go down.
+type snmpByteCounters struct {
+	mu     sync.Mutex
+	rx, tx uint64
+	last   map[key.NodePublic]snmpPeerBytes // per-peer counters at the last update
+}
+
+type snmpPeerBytes struct {
+	rx, tx int64
+}
+
+// update adds the bytes the peers in st have exchanged since the last
+// update to the totals, and returns the new totals.
+func (c *snmpByteCounters) update(st *ipnstate.Status) (rx, tx uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	last := make(map[key.NodePublic]snmpPeerBytes, len(st.Peer))
+	for k, ps := range st.Peer {
+		cur := snmpPeerBytes{ps.RxBytes, ps.TxBytes}
+		prev := c.last[k]
+		c.rx += counterDelta(prev.rx, cur.rx)
+		c.tx += counterDelta(prev.tx, cur.tx)
+		last[k] = cur
+	}
+	c.last = last
+	return c.rx, c.tx
+}
+
+// counterDelta returns how much a counter that was prev is now cur. If it
+// went down, it was reset, and counted cur from zero.
+func counterDelta(prev, cur int64) uint64 {
+	if cur < prev {
+		return uint64(cur)
+	}
+	return uint64(cur - prev)
+}
+
+// snmpVars returns the variables under snmpRoot for the status st, with
+// the byte counters from counters.
+func snmpVars(st *ipnstate.Status, tunName string, counters *snmpByteCounters) []agentx.Var {
+	rx, tx := counters.update(st)
+	var online, active uint32
+	for _, ps := range st.Peer {
+		if ps.Online {
+			online++
+		}
+		if ps.Active {
+			active++
+		}
+	}
+	var derp string
+	if st.Self != nil {
+		derp = st.Self.Relay
+	}
+	oid := snmpRoot.Append
+	return []agentx.Var{
+		agentx.String(oid(1, 1, 0), tunName),
+		agentx.Counter64(oid(1, 2, 0), rx),
+		agentx.Counter64(oid(1, 3, 0), tx),
+		agentx.Gauge32(oid(2, 1, 0), uint32(len(st.Peer))),
+		agentx.Gauge32(oid(2, 2, 0), online),
+		agentx.Gauge32(oid(2, 3, 0), active),
+		agentx.String(oid(3, 1, 0), derp),
+		agentx.Gauge32(oid(4, 1, 0), uint32(len(st.Health))),
+		agentx.String(oid(4, 2, 0), strings.Join(st.Health, "\n")),
+		agentx.String(oid(5, 1, 0), st.BackendState),
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"testing"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/key"
+)
+
+func TestSNMPByteCounters(t *testing.T) {
+	a, b := key.NewNode().Public(), key.NewNode().Public()
+	status := func(peers map[key.NodePublic][2]int64) *ipnstate.Status {
+		st := &ipnstate.Status{Peer: map[key.NodePublic]*ipnstate.PeerStatus{}}
+		for k, v := range peers {
+			st.Peer[k] = &ipnstate.PeerStatus{RxBytes: v[0], TxBytes: v[1]}
+		}
+		return st
+	}
+
+	var c snmpByteCounters
+	steps := []struct {
+		name   string
+		peers  map[key.NodePublic][2]int64
+		rx, tx uint64
+	}{
+		{"start", map[key.NodePublic][2]int64{a: {100, 10}, b: {50, 5}}, 150, 15},
+		{"traffic", map[key.NodePublic][2]int64{a: {300, 20}, b: {50, 5}}, 350, 25},
+		// b leaving doesn't take its bytes out of the totals.
+		{"peer-gone", map[key.NodePublic][2]int64{a: {310, 20}}, 360, 25},
+		// b coming back counts from zero again.
+		{"peer-back", map[key.NodePublic][2]int64{a: {310, 20}, b: {7, 1}}, 367, 26},
+		// a's counters reset, such as when WireGuard forgets the peer.
+		{"reset", map[key.NodePublic][2]int64{a: {4, 2}, b: {7, 1}}, 371, 28},
+	}
+	for _, s := range steps {
+		rx, tx := c.update(status(s.peers))
+		if rx != s.rx || tx != s.tx {
+			t.Errorf("%s: got rx=%d tx=%d, want rx=%d tx=%d", s.name, rx, tx, s.rx, s.tx)
+		}
+	}
+}
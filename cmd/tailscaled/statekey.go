@@ -0,0 +1,48 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"tailscale.com/ipn/store"
+	"tailscale.com/paths"
+)
+
+var rotateStateKeyFunc = rotateStateKey // so it can be addressable
+
+// rotateStateKey implements 'tailscaled rotate-state-key', which changes
+// the key source that the state file is encrypted with while tailscaled is
+// stopped.
+func rotateStateKey(args []string) error {
+	fs := flag.NewFlagSet("rotate-state-key", flag.ExitOnError)
+	statePath := fs.String("state", paths.DefaultTailscaledStateFile(), "path of the state file")
+	oldKey := fs.String("old-key", "none", `the state file's current --state-key, or "none" if it's not encrypted`)
+	newKey := fs.String("new-key", "", `the --state-key to re-encrypt the state file with, or "none" to decrypt it`)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: tailscaled rotate-state-key [flags]\n\n")
+		fmt.Fprintf(fs.Output(), "Re-encrypts the state file with a new key. Stop tailscaled first, then\nstart it with the new --state-key.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return errors.New("rotate-state-key takes no non-flag arguments")
+	}
+	if *statePath == "" || *newKey == "" {
+		return errors.New("--state and --new-key are required")
+	}
+	if err := store.RotateStateKey(*statePath, *oldKey, *newKey); err != nil {
+		return fmt.Errorf("rotating key of %s: %w", *statePath, err)
+	}
+	if *newKey == "none" {
+		fmt.Printf("Decrypted %s. Start tailscaled without --state-key.\n", *statePath)
+	} else {
+		fmt.Printf("Re-encrypted %s. Start tailscaled with --state-key=%s.\n", *statePath, *newKey)
+	}
+	return nil
+}
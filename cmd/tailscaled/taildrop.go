@@ -9,6 +9,7 @@
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/types/logger"
@@ -30,6 +31,24 @@ func configureTaildrop(logf logger.Logf, lb *ipnlocal.LocalBackend) {
 		}
 	}
 
+	if dir := args.taildropAutoDir; dir != "" {
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			logf("Taildrop auto-accept: %q is not a directory; not auto-accepting files", dir)
+			return
+		}
+		var from []string
+		for _, f := range strings.Split(args.taildropAutoFrom, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				from = append(from, f)
+			}
+		}
+		if len(from) == 0 {
+			logf("Taildrop auto-accept: no senders given with --taildrop-auto-accept-from; not auto-accepting files")
+			return
+		}
+		logf("Taildrop auto-accept: writing files from %v into %v", from, dir)
+		lb.SetTaildropAutoAccept(ipnlocal.TaildropAutoAccept{Dir: dir, From: from})
+	}
 }
 
 func findTaildropDir(dg distro.Distro) (string, error) {
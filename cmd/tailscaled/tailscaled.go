@@ -44,18 +44,22 @@
 	"tailscale.com/ipn/store"
 	"tailscale.com/logpolicy"
 	"tailscale.com/logtail"
+	"tailscale.com/net/agentx"
+	"tailscale.com/net/carp"
 	"tailscale.com/net/dns"
 	"tailscale.com/net/dnsfallback"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/netns"
 	"tailscale.com/net/proxymux"
 	"tailscale.com/net/socks5"
+	"tailscale.com/net/tlsdial"
 	"tailscale.com/net/tsdial"
 	"tailscale.com/net/tshttpproxy"
 	"tailscale.com/net/tstun"
 	"tailscale.com/paths"
 	"tailscale.com/safesocket"
 	"tailscale.com/syncs"
+	"tailscale.com/tailcfg"
 	"tailscale.com/tsd"
 	"tailscale.com/tsweb/varz"
 	"tailscale.com/types/flagtype"
@@ -64,6 +68,7 @@
 	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/multierr"
 	"tailscale.com/util/osshare"
+	"tailscale.com/util/resourcewatch"
 	"tailscale.com/version"
 	"tailscale.com/version/distro"
 	"tailscale.com/wgengine"
@@ -84,6 +89,12 @@ func defaultTunName() string {
 		return "utun"
 	case "plan9", "aix":
 		return "userspace-networking"
+	case "freebsd":
+		if j := hostinfo.GetJail(); j.Jailed && !j.VNET {
+			// A jail without VNET shares the host's network stack and
+			// can neither create a tun device nor add routes.
+			return "userspace-networking"
+		}
 	case "linux":
 		switch distro.Get() {
 		case distro.Synology:
@@ -123,16 +134,36 @@ func defaultPort() uint16 {
 	confFile       string // empty, file path, or "vm:user-data"
 	debug          string
 	port           uint16
+	portRange      tailcfg.PortRange // UDP ports magicsock may use, or zero for any
 	statepath      string
 	statedir       string
+	stateKey       string // key source to encrypt the state file with, or empty
 	socketpath     string
 	birdSocketPath string
 	verbose        int
 	socksAddr      string // listen address for SOCKS5 server
 	httpProxyAddr  string // listen address for HTTP proxy server
+	httpProxyAuth  string // path of the HTTP proxy's httpProxyAuth file, or empty
 	disableLogs    bool
+	snmpAgentX     string // path of the AgentX master agent socket, or empty
+	tunRDomain     int    // OpenBSD routing domain for the tun interface, or 0
+	bypassRTable   int    // FreeBSD FIB or OpenBSD rtable for tailscaled's own traffic, or 0
+	mtu            uint   // MTU of the tun device, or 0 for the default
+	carp           string // CARP virtual host to follow for subnet router HA, or empty
+	tlsCABundle    string // PEM file of extra CAs for control and DERP, or empty
+	tlsControlPins string // comma-separated SPKI pins of the control server, or empty
+	tlsDERPPins    string // comma-separated SPKI pins of DERP servers, or empty
+
+	taildropAutoDir  string // directory to receive Taildrop files from taildropAutoFrom into, or empty
+	taildropAutoFrom string // comma-separated senders whose Taildrop files are auto-accepted
+
+	flowExport           string // UDP host:port of an IPFIX collector, or empty
+	flowExportEnterprise uint   // IANA enterprise number for Tailscale-specific IPFIX elements, or 0
 }
 
+// carpSpec is the parsed --carp flag.
+var carpSpec carp.Spec
+
 var (
 	installSystemDaemon   func([]string) error                      // non-nil on some platforms
 	uninstallSystemDaemon func([]string) error                      // non-nil on some platforms
@@ -149,6 +180,7 @@ func defaultPort() uint16 {
 	"debug":                   &debugModeFunc,
 	"be-child":                &beChildFunc,
 	"serve-taildrive":         &serveDriveFunc,
+	"rotate-state-key":        &rotateStateKeyFunc,
 }
 
 var beCLI func() // non-nil if CLI is linked in
@@ -165,15 +197,30 @@ func main() {
 	flag.StringVar(&args.debug, "debug", "", "listen address ([ip]:port) of optional debug server")
 	flag.StringVar(&args.socksAddr, "socks5-server", "", `optional [ip]:port to run a SOCK5 server (e.g. "localhost:1080")`)
 	flag.StringVar(&args.httpProxyAddr, "outbound-http-proxy-listen", "", `optional [ip]:port to run an outbound HTTP proxy (e.g. "localhost:8080")`)
-	flag.StringVar(&args.tunname, "tun", defaultTunName(), `tunnel interface name; use "userspace-networking" (beta) to not use TUN`)
+	flag.StringVar(&args.httpProxyAuth, "outbound-http-proxy-auth", "", `optional path of a JSON file of the local users ({"Users": {"alice": {"AllowDst": ["db.example.ts.net", "100.64.0.0/10"]}}}) allowed to use the outbound HTTP proxy, identified by the kernel (Linux only), and the destinations each may reach`)
+	flag.StringVar(&args.tunname, "tun", defaultTunName(), `tunnel interface name; use "userspace-networking" (beta) to not use TUN. On OpenBSD, NetBSD and DragonFly, "tun" uses the first free tun device, or name one such as "tun3"`)
 	flag.Var(flagtype.PortValue(&args.port, defaultPort()), "port", "UDP port to listen on for WireGuard and peer-to-peer traffic; 0 means automatically select")
-	flag.StringVar(&args.statepath, "state", "", "absolute path of state file; use 'kube:<secret-name>' to use Kubernetes secrets or 'arn:aws:ssm:...' to store in AWS SSM; use 'mem:' to not store state and register as an ephemeral node. If empty and --statedir is provided, the default is <statedir>/tailscaled.state. Default: "+paths.DefaultTailscaledStateFile())
+	flag.Var(flagtype.PortRangeValue(&args.portRange), "port-range", `optional range of UDP ports ("41641-41700") to restrict WireGuard and peer-to-peer traffic to, for firewalls that only allow some ports outbound; a --port outside it is ignored`)
+	flag.StringVar(&args.statepath, "state", "", "absolute path of state file; use 'kube:<secret-name>' to use Kubernetes secrets, 'arn:aws:ssm:...' or 'ssm://[region]/<parameter-name>' to store in AWS SSM, or 'gcp-secret://[project]/<secret-name>' to store in GCP Secret Manager; use 'mem:' to not store state and register as an ephemeral node. If empty and --statedir is provided, the default is <statedir>/tailscaled.state. Default: "+paths.DefaultTailscaledStateFile())
 	flag.StringVar(&args.statedir, "statedir", "", "path to directory for storage of config state, TLS certs, temporary incoming Taildrop files, etc. If empty, it's derived from --state when possible.")
+	flag.StringVar(&args.stateKey, "state-key", "", `optional key source to encrypt the --state file at rest with: "tpm2" to seal its key to this machine's TPM2 with systemd-creds, or "cmd:<command>" to derive it from the passphrase printed by command; a cleartext state file is encrypted on startup. See 'tailscaled rotate-state-key -h' to change it`)
 	flag.StringVar(&args.socketpath, "socket", paths.DefaultTailscaledSocket(), "path of the service unix socket")
 	flag.StringVar(&args.birdSocketPath, "bird-socket", "", "path of the bird unix socket")
 	flag.BoolVar(&printVersion, "version", false, "print version information and exit")
 	flag.BoolVar(&args.disableLogs, "no-logs-no-support", false, "disable log uploads; this also disables any technical support")
-	flag.StringVar(&args.confFile, "config", "", "path to config file, or 'vm:user-data' to use the VM's user-data (EC2)")
+	flag.StringVar(&args.confFile, "config", "", "path to config file, or 'vm:user-data' to use the VM's user-data (EC2); reloaded on SIGHUP")
+	flag.StringVar(&args.snmpAgentX, "snmp-agentx", "", `optional path of the SNMP master agent's AgentX socket (e.g. "`+agentx.DefaultSocket+`") to export statistics to`)
+	flag.StringVar(&args.carp, "carp", "", `BSD only: CARP virtual host ("carp0", or "vhid@interface" on FreeBSD) whose state decides whether this subnet router advertises its routes; only the MASTER does`)
+	flag.StringVar(&args.tlsCABundle, "tls-ca-bundle", "", "path of a PEM file of CA certificates to trust, in addition to the system roots, for the control server and DERP servers")
+	flag.StringVar(&args.tlsControlPins, "tls-control-pins", "", `comma-separated public key pins ("sha256/<base64>" of a SubjectPublicKeyInfo), one of which the control server's certificate chain must have`)
+	flag.StringVar(&args.tlsDERPPins, "tls-derp-pins", "", `comma-separated public key pins ("sha256/<base64>" of a SubjectPublicKeyInfo), one of which every DERP server's certificate chain must have`)
+	flag.StringVar(&args.taildropAutoDir, "taildrop-auto-accept-dir", "", "optional existing directory to write Taildrop files from the --taildrop-auto-accept-from senders into directly, without 'tailscale file get'")
+	flag.StringVar(&args.taildropAutoFrom, "taildrop-auto-accept-from", "", `comma-separated senders whose Taildrop files are written into --taildrop-auto-accept-dir: node names or stable IDs, tags ("tag:camera") or users ("user:alice@example.com")`)
+	flag.StringVar(&args.flowExport, "flow-export", "", `optional UDP host:port of an IPFIX collector (e.g. "10.1.2.3:4739") to export records of this node's tailnet, subnet and exit node flows to`)
+	flag.UintVar(&args.flowExportEnterprise, "flow-export-enterprise", 0, "IANA private enterprise number under which --flow-export includes the Tailscale node and user names of each flow's ends; 0 omits them")
+	flag.IntVar(&args.tunRDomain, "tun-rdomain", 0, "OpenBSD only: routing domain to place the tun interface and its routes in; tailscaled's own traffic stays in the routing table it runs in")
+	flag.UintVar(&args.mtu, "mtu", 0, "MTU of the tun device, or 0 for the default; on the BSDs, tailscaled also sets it again if the device is recreated")
+	flag.IntVar(&args.bypassRTable, "bypass-rtable", 0, "FreeBSD and OpenBSD only: routing table (FIB on FreeBSD, rtable on OpenBSD) for tailscaled's own traffic, kept populated with the system's local and default routes, so that an exit node's routes don't capture it")
 
 	if len(os.Args) > 0 && filepath.Base(os.Args[0]) == "tailscale" && beCLI != nil {
 		beCLI()
@@ -227,6 +274,55 @@ func main() {
 		log.Fatalf("--bird-socket is not supported on %s", runtime.GOOS)
 	}
 
+	if err := configureFlowExport(log.Printf); err != nil {
+		log.SetFlags(0)
+		log.Fatalf("--flow-export: %v", err)
+	}
+
+	if args.carp != "" {
+		switch runtime.GOOS {
+		case "freebsd", "openbsd", "netbsd", "dragonfly":
+		default:
+			log.SetFlags(0)
+			log.Fatalf("--carp is not supported on %s", runtime.GOOS)
+		}
+		var err error
+		if carpSpec, err = carp.ParseSpec(args.carp); err != nil {
+			log.SetFlags(0)
+			log.Fatalf("--carp: %v", err)
+		}
+	}
+
+	if args.tunRDomain != 0 {
+		if runtime.GOOS != "openbsd" {
+			log.SetFlags(0)
+			log.Fatalf("--tun-rdomain is only supported on OpenBSD")
+		}
+		envknob.Setenv("TS_TUN_RDOMAIN", strconv.Itoa(args.tunRDomain))
+	}
+	if args.mtu != 0 {
+		if args.mtu < 576 || args.mtu > 65535 {
+			log.SetFlags(0)
+			log.Fatalf("--mtu must be between 576 and 65535")
+		}
+		envknob.Setenv("TS_DEBUG_MTU", strconv.FormatUint(uint64(args.mtu), 10))
+	}
+	if args.bypassRTable != 0 {
+		if runtime.GOOS != "freebsd" && runtime.GOOS != "openbsd" {
+			log.SetFlags(0)
+			log.Fatalf("--bypass-rtable is only supported on FreeBSD and OpenBSD")
+		}
+		if args.bypassRTable < 0 || args.bypassRTable > 255 {
+			log.SetFlags(0)
+			log.Fatalf("--bypass-rtable must be between 1 and 255")
+		}
+		if args.tunRDomain != 0 {
+			log.SetFlags(0)
+			log.Fatalf("--bypass-rtable and --tun-rdomain are alternatives; use one")
+		}
+		netns.SetBypassRoutingTable(args.bypassRTable)
+	}
+
 	// Only apply a default statepath when neither have been provided, so that a
 	// user may specify only --statedir if they wish.
 	if args.statepath == "" && args.statedir == "" {
@@ -333,6 +429,54 @@ func ipnServerOpts() (o serverOptions) {
 	return o
 }
 
+// setTLSTrust sets the custom trust of the control server's and DERP servers'
+// certificates from the config file, if any, and the --tls-* flags, which
+// take precedence.
+func setTLSTrust(logf logger.Logf, conf *conffile.Config) error {
+	var c ipn.TLSTrustConfig
+	if conf != nil && conf.Parsed.TLS != nil {
+		c = *conf.Parsed.TLS
+	}
+	if args.tlsCABundle != "" {
+		c.CABundle = args.tlsCABundle
+	}
+	if args.tlsControlPins != "" {
+		c.ControlPins = strings.Split(args.tlsControlPins, ",")
+	}
+	if args.tlsDERPPins != "" {
+		c.DERPPins = strings.Split(args.tlsDERPPins, ",")
+	}
+	if c.CABundle == "" && len(c.ControlPins) == 0 && len(c.DERPPins) == 0 {
+		return nil
+	}
+	t, err := tlsdial.LoadTrust(c.CABundle, c.ControlPins, c.DERPPins)
+	if err != nil {
+		return fmt.Errorf("TLS trust configuration: %w", err)
+	}
+	tlsdial.SetTrust(t)
+	logf("TLS trust: CA bundle %q, %d control pins, %d DERP pins", c.CABundle, len(t.ControlPins), len(t.DERPPins))
+	return nil
+}
+
+// setProxyCredentials sets the credentials to authenticate to HTTP proxies
+// with from the config file, if any.
+func setProxyCredentials(conf *conffile.Config) error {
+	if conf == nil || conf.Parsed.Proxy == nil {
+		return nil
+	}
+	c := conf.Parsed.Proxy
+	password := c.Password
+	if filename, ok := strings.CutPrefix(password, "file:"); ok {
+		b, err := os.ReadFile(filename)
+		if err != nil {
+			return fmt.Errorf("reading proxy password file: %w", err)
+		}
+		password = strings.TrimSpace(string(b))
+	}
+	tshttpproxy.SetCredentials(c.User, password)
+	return nil
+}
+
 var logPol *logpolicy.Policy
 var debugMux *http.ServeMux
 
@@ -350,6 +494,12 @@ func run() (err error) {
 		}
 		sys.InitialConfig = conf
 	}
+	if err := setTLSTrust(logf, conf); err != nil {
+		return err
+	}
+	if err := setProxyCredentials(conf); err != nil {
+		return err
+	}
 
 	var netMon *netmon.Monitor
 	isWinSvc := isWindowsService()
@@ -412,6 +562,14 @@ func run() (err error) {
 		log.Printf("error in synology migration: %v", err)
 	}
 
+	// Watch for sustained runaway CPU or memory use and capture
+	// diagnostics into the state directory when it happens.
+	if dir := ipnServerOpts().VarRoot; dir != "" && !resourcewatch.Disabled() {
+		rw := resourcewatch.New(logf, sys.HealthTracker(), resourcewatch.DefaultConfig(filepath.Join(dir, "diagnostics")))
+		logf = rw.WrapLogf(logf)
+		go rw.Run(context.Background())
+	}
+
 	if args.debug != "" {
 		debugMux = newDebugMux()
 	}
@@ -427,6 +585,8 @@ func run() (err error) {
 
 var sigPipe os.Signal // set by sigpipe.go
 
+var sigHUP os.Signal // set by sighup.go
+
 func startIPNServer(ctx context.Context, logf logger.Logf, logID logid.PublicID, sys *tsd.System) error {
 	ln, err := safesocket.Listen(args.socketpath)
 	if err != nil {
@@ -500,6 +660,15 @@ func startIPNServer(ctx context.Context, logf logger.Logf, logID logid.PublicID,
 			}
 			srv.SetLocalBackend(lb)
 			close(wgEngineCreated)
+			if args.snmpAgentX != "" {
+				go runSNMPAgent(ctx, logf, lb, args.snmpAgentX)
+			}
+			if args.carp != "" {
+				go runCARPWatcher(ctx, logf, lb, sys.NetMon.Get(), carpSpec)
+			}
+			if args.confFile != "" && sigHUP != nil {
+				go reloadConfigOnSignal(ctx, logf, lb)
+			}
 			return
 		}
 		lbErr.Store(err) // before the following cancel
@@ -520,6 +689,26 @@ func startIPNServer(ctx context.Context, logf logger.Logf, logID logid.PublicID,
 	return nil
 }
 
+// reloadConfigOnSignal reloads the config file each time tailscaled gets
+// SIGHUP, like "tailscale debug reload-config", until ctx is done.
+func reloadConfigOnSignal(ctx context.Context, logf logger.Logf, lb *ipnlocal.LocalBackend) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sigHUP)
+	defer signal.Stop(c)
+	for {
+		select {
+		case s := <-c:
+			if _, err := lb.ReloadConfig(); err != nil {
+				logf("tailscaled got signal %v; reloading config: %v", s, err)
+			} else {
+				logf("tailscaled got signal %v; reloaded config", s)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 func getLocalBackend(ctx context.Context, logf logger.Logf, logID logid.PublicID, sys *tsd.System) (_ *ipnlocal.LocalBackend, retErr error) {
 	if logPol != nil {
 		logPol.Logtail.SetNetMon(sys.NetMon.Get())
@@ -579,7 +768,14 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logID logid.PublicID
 	if socksListener != nil || httpProxyListener != nil {
 		var addrs []string
 		if httpProxyListener != nil {
-			hs := &http.Server{Handler: httpProxyHandler(dialer.UserDial)}
+			auth, err := loadHTTPProxyAuth(args.httpProxyAuth)
+			if err != nil {
+				return nil, fmt.Errorf("--outbound-http-proxy-auth: %w", err)
+			}
+			hs := &http.Server{
+				Handler:     httpProxyHandler(dialer.UserDial, auth),
+				ConnContext: withConn,
+			}
 			go func() {
 				log.Fatalf("HTTP proxy exited: %v", hs.Serve(httpProxyListener))
 			}()
@@ -600,7 +796,13 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logID logid.PublicID
 
 	opts := ipnServerOpts()
 
-	store, err := store.New(logf, statePathOrDefault())
+	newStore := store.New
+	if args.stateKey != "" {
+		newStore = func(logf logger.Logf, path string) (ipn.StateStore, error) {
+			return store.NewEncryptedFileStore(logf, path, args.stateKey)
+		}
+	}
+	store, err := newStore(logf, statePathOrDefault())
 	if err != nil {
 		return nil, fmt.Errorf("store.New: %w", err)
 	}
@@ -626,6 +828,7 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logID logid.PublicID
 		UseSocketOnly: args.socketpath != paths.DefaultTailscaledSocket(),
 	})
 	configureTaildrop(logf, lb)
+	configureFlowExportAttribution(lb)
 	if err := ns.Start(lb); err != nil {
 		log.Fatalf("failed to start netstack: %v", err)
 	}
@@ -666,7 +869,7 @@ func handleSubnetsInNetstack() bool {
 		return true
 	}
 	switch runtime.GOOS {
-	case "windows", "darwin", "freebsd", "openbsd", "solaris", "illumos", "netbsd":
+	case "windows", "darwin", "freebsd", "openbsd", "solaris", "illumos", "netbsd", "aix":
 		// Enable on Windows and tailscaled-on-macOS (this doesn't
 		// affect the GUI clients), and on FreeBSD.
 		return true
@@ -678,14 +881,18 @@ func handleSubnetsInNetstack() bool {
 
 func tryEngine(logf logger.Logf, sys *tsd.System, name string) (onlyNetstack bool, err error) {
 	conf := wgengine.Config{
-		ListenPort:    args.port,
-		NetMon:        sys.NetMon.Get(),
-		HealthTracker: sys.HealthTracker(),
-		Metrics:       sys.UserMetricsRegistry(),
-		Dialer:        sys.Dialer.Get(),
-		SetSubsystem:  sys.Set,
-		ControlKnobs:  sys.ControlKnobs(),
-		DriveForLocal: driveimpl.NewFileSystemForLocal(logf),
+		ListenPort:      args.port,
+		ListenPortRange: args.portRange,
+		NetMon:          sys.NetMon.Get(),
+		HealthTracker:   sys.HealthTracker(),
+		Metrics:         sys.UserMetricsRegistry(),
+		Dialer:          sys.Dialer.Get(),
+		SetSubsystem:    sys.Set,
+		ControlKnobs:    sys.ControlKnobs(),
+		DriveForLocal:   driveimpl.NewFileSystemForLocal(logf),
+	}
+	if flowExporter != nil {
+		conf.FlowExporter = flowExporter
 	}
 
 	sys.HealthTracker().SetMetricsRegistry(sys.UserMetricsRegistry())
@@ -800,6 +1007,11 @@ func newNetstack(logf logger.Logf, sys *tsd.System) (*netstack.Impl, error) {
 	if err != nil {
 		return nil, err
 	}
+	if c := sys.InitialConfig; c != nil && c.Parsed.Netstack != nil {
+		if err := ret.Tune(c.Parsed.Netstack); err != nil {
+			return nil, fmt.Errorf("config file: %w", err)
+		}
+	}
 	// Only register debug info if we have a debug mux
 	if debugMux != nil {
 		expvar.Publish("netstack", ret.ExpVar())
@@ -126,6 +126,7 @@ type Auto struct {
 
 	wantLoggedIn bool   // whether the user wants to be logged in per last method call
 	urlToVisit   string // the last url we were told to visit
+	userCode     string // the code to enter at urlToVisit, for device-code logins
 	expiry       time.Time
 
 	// lastUpdateGen is the gen of last update we had an update worth sending to
@@ -358,8 +359,10 @@ func (c *Auto) authRoutine() {
 			if c.direct.panicOnUse {
 				panic("tainted client")
 			}
+			userCode := c.direct.LoginUserCode()
 			c.mu.Lock()
 			c.urlToVisit = url
+			c.userCode = userCode
 			c.loginGoal = &LoginGoal{
 				flags: LoginDefault,
 				url:   url,
@@ -382,6 +385,7 @@ func (c *Auto) authRoutine() {
 		c.direct.health.SetAuthRoutineInError(nil)
 		c.mu.Lock()
 		c.urlToVisit = ""
+		c.userCode = ""
 		c.loggedIn = true
 		c.loginGoal = nil
 		c.state = StateAuthenticated
@@ -587,6 +591,10 @@ func (c *Auto) sendStatus(who string, err error, url string, nm *netmap.NetworkM
 	state := c.state
 	loggedIn := c.loggedIn
 	inMapPoll := c.inMapPoll
+	var userCode string
+	if url != "" && url == c.urlToVisit {
+		userCode = c.userCode
+	}
 	c.mu.Unlock()
 
 	c.logf("[v1] sendStatus: %s: %v", who, state)
@@ -600,11 +608,12 @@ func (c *Auto) sendStatus(who string, err error, url string, nm *netmap.NetworkM
 		nm = nil
 	}
 	newSt := &Status{
-		URL:     url,
-		Persist: p,
-		NetMap:  nm,
-		Err:     err,
-		state:   state,
+		URL:      url,
+		UserCode: userCode,
+		Persist:  p,
+		NetMap:   nm,
+		Err:      err,
+		state:    state,
 	}
 	c.lastStatus.Store(newSt)
 
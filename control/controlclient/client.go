@@ -28,6 +28,8 @@
 	//
 	// See https://github.com/tailscale/tailscale/issues/6973.
 	LocalBackendStartKeyOSNeutral
+
+	LoginDeviceCode // with LoginInteractive, set RegisterRequest.DeviceCode
 )
 
 // Client represents a client connection to the control server.
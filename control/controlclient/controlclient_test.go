@@ -24,7 +24,7 @@ func fieldsOf(t reflect.Type) (fields []string) {
 
 func TestStatusEqual(t *testing.T) {
 	// Verify that the Equal method stays in sync with reality
-	equalHandles := []string{"Err", "URL", "NetMap", "Persist", "state"}
+	equalHandles := []string{"Err", "URL", "UserCode", "NetMap", "Persist", "state"}
 	if have := fieldsOf(reflect.TypeFor[Status]()); !reflect.DeepEqual(have, equalHandles) {
 		t.Errorf("Status.Equal check might be out of sync\nfields: %q\nhandled: %q\n",
 			have, equalHandles)
@@ -142,7 +142,7 @@ func TestCanSkipStatus(t *testing.T) {
 		})
 	}
 
-	want := []string{"Err", "URL", "NetMap", "Persist", "state"}
+	want := []string{"Err", "URL", "UserCode", "NetMap", "Persist", "state"}
 	if f := fieldsOf(reflect.TypeFor[Status]()); !slices.Equal(f, want) {
 		t.Errorf("Status fields = %q; this code was only written to handle fields %q", f, want)
 	}
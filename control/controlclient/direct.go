@@ -97,6 +97,7 @@ type Direct struct {
 	persist      persist.PersistView
 	authKey      string
 	tryingNewKey key.NodePrivate
+	userCode     string            // device-code login code for the last AuthURL, if any
 	expiry       time.Time         // or zero value if none/unknown
 	hostinfo     *tailcfg.Hostinfo // always non-nil
 	netinfo      *tailcfg.NetInfo
@@ -265,12 +266,12 @@ func NewDirect(opts Options) (*Direct, error) {
 	if httpc == nil {
 		tr := http.DefaultTransport.(*http.Transport).Clone()
 		tr.Proxy = tshttpproxy.ProxyFromEnvironment
-		tshttpproxy.SetTransportGetProxyConnectHeader(tr)
-		tr.TLSClientConfig = tlsdial.Config(serverURL.Hostname(), opts.HealthTracker, tr.TLSClientConfig)
+		tr.TLSClientConfig = tlsdial.ConfigFor(tlsdial.PurposeControl, serverURL.Hostname(), opts.HealthTracker, tr.TLSClientConfig)
 		var dialFunc dialFunc
 		dialFunc, interceptedDial = makeScreenTimeDetectingDialFunc(opts.Dialer.SystemDial)
 		tr.DialContext = dnscache.Dialer(dialFunc, dnsCache)
 		tr.DialTLSContext = dnscache.TLSDialer(dialFunc, dnsCache, tr.TLSClientConfig)
+		tshttpproxy.ConfigureTransport(tr)
 		tr.ForceAttemptHTTP2 = true
 		// Disable implicit gzip compression; the various
 		// handlers (register, map, set-dns, etc) do their own
@@ -424,6 +425,15 @@ func (c *Direct) TryLogin(ctx context.Context, flags LoginFlags) (url string, er
 	return c.doLoginOrRegen(ctx, loginOpt{Flags: flags})
 }
 
+// LoginUserCode returns the code the user must enter at the URL last
+// returned by TryLogin, if a device-code login was requested with
+// LoginDeviceCode and control supports it, or else the empty string.
+func (c *Direct) LoginUserCode() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.userCode
+}
+
 // WaitLoginURL sits in a long poll waiting for the user to authenticate at url.
 //
 // On success, newURL and err will both be nil.
@@ -620,6 +630,7 @@ func (c *Direct) doLogin(ctx context.Context, opt loginOpt) (mustRegen bool, new
 		Followup:         opt.URL,
 		Timestamp:        &now,
 		Ephemeral:        (opt.Flags & LoginEphemeral) != 0,
+		DeviceCode:       (opt.Flags & LoginDeviceCode) != 0,
 		NodeKeySignature: nodeKeySignature,
 		Tailnet:          tailnet,
 	}
@@ -742,6 +753,13 @@ func (c *Direct) doLogin(ctx context.Context, opt loginOpt) (mustRegen bool, new
 		// save it for the retry-with-URL
 		c.tryingNewKey = tryingNewKey
 	}
+	switch {
+	case resp.AuthURL == "":
+		c.userCode = ""
+	case opt.URL == "":
+		// A followup keeps the code of the URL being followed up.
+		c.userCode = resp.UserCode
+	}
 	c.persist = persist.View()
 	c.mu.Unlock()
 
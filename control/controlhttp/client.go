@@ -509,18 +509,17 @@ func (a *Dialer) tryURLUpgrade(ctx context.Context, u *url.URL, optAddr netip.Ad
 	tr := http.DefaultTransport.(*http.Transport).Clone()
 	defer tr.CloseIdleConnections()
 	tr.Proxy = a.getProxyFunc()
-	tshttpproxy.SetTransportGetProxyConnectHeader(tr)
 	tr.DialContext = dnscache.Dialer(dialer, dns)
 	// Disable HTTP2, since h2 can't do protocol switching.
 	tr.TLSClientConfig.NextProtos = []string{}
 	tr.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
-	tr.TLSClientConfig = tlsdial.Config(a.Hostname, a.HealthTracker, tr.TLSClientConfig)
+	tr.TLSClientConfig = tlsdial.ConfigFor(tlsdial.PurposeControl, a.Hostname, a.HealthTracker, tr.TLSClientConfig)
 	if !tr.TLSClientConfig.InsecureSkipVerify {
-		panic("unexpected") // should be set by tlsdial.Config
+		panic("unexpected") // should be set by tlsdial.ConfigFor
 	}
 	verify := tr.TLSClientConfig.VerifyConnection
 	if verify == nil {
-		panic("unexpected") // should be set by tlsdial.Config
+		panic("unexpected") // should be set by tlsdial.ConfigFor
 	}
 	// Demote all cert verification errors to log messages. We don't actually
 	// care about the TLS security (because we just do the Noise crypto atop whatever
@@ -534,6 +533,7 @@ func (a *Dialer) tryURLUpgrade(ctx context.Context, u *url.URL, optAddr netip.Ad
 	}
 
 	tr.DialTLSContext = dnscache.TLSDialer(dialer, dns, tr.TLSClientConfig)
+	tshttpproxy.ConfigureTransport(tr)
 	tr.DisableCompression = true
 
 	// (mis)use httptrace to extract the underlying net.Conn from the
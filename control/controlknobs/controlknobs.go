@@ -110,6 +110,14 @@ type Knobs struct {
 	// of queued netmap.NetworkMap between the controlclient and LocalBackend.
 	// See tailscale/tailscale#14768.
 	DisableSkipStatusQueue atomic.Bool
+
+	// MagicsockPortRange is the range of UDP ports magicsock should bind
+	// to, or the zero value for any port.
+	MagicsockPortRange syncs.AtomicValue[tailcfg.PortRange]
+
+	// MultipathFailover is whether magicsock should duplicate packets
+	// across two paths while failing over from a degraded direct path.
+	MultipathFailover atomic.Bool
 }
 
 // UpdateFromNodeAttributes updates k (if non-nil) based on the provided self
@@ -140,8 +148,16 @@ func (k *Knobs) UpdateFromNodeAttributes(capMap tailcfg.NodeCapMap) {
 		disableCryptorouting                 = has(tailcfg.NodeAttrDisableMagicSockCryptoRouting)
 		disableCaptivePortalDetection        = has(tailcfg.NodeAttrDisableCaptivePortalDetection)
 		disableSkipStatusQueue               = has(tailcfg.NodeAttrDisableSkipStatusQueue)
+		magicsockPortRange                   tailcfg.PortRange
+		multipathFailover                    = has(tailcfg.NodeAttrMagicsockMultipathFailover)
 	)
 
+	if prs, err := tailcfg.UnmarshalNodeCapJSON[tailcfg.PortRange](capMap, tailcfg.NodeAttrMagicsockPortRange); err == nil && len(prs) == 1 {
+		if pr := prs[0]; pr.First != 0 && pr.First <= pr.Last {
+			magicsockPortRange = pr
+		}
+	}
+
 	if has(tailcfg.NodeAttrOneCGNATEnable) {
 		oneCGNAT.Set(true)
 	} else if has(tailcfg.NodeAttrOneCGNATDisable) {
@@ -168,6 +184,8 @@ func (k *Knobs) UpdateFromNodeAttributes(capMap tailcfg.NodeCapMap) {
 	k.DisableCryptorouting.Store(disableCryptorouting)
 	k.DisableCaptivePortalDetection.Store(disableCaptivePortalDetection)
 	k.DisableSkipStatusQueue.Store(disableSkipStatusQueue)
+	k.MagicsockPortRange.Store(magicsockPortRange)
+	k.MultipathFailover.Store(multipathFailover)
 }
 
 // AsDebugJSON returns k as something that can be marshalled with json.Marshal
@@ -186,6 +204,8 @@ func (k *Knobs) AsDebugJSON() map[string]any {
 			ret[name] = v.Load()
 		case *syncs.AtomicValue[opt.Bool]:
 			ret[name] = v.Load()
+		case *syncs.AtomicValue[tailcfg.PortRange]:
+			ret[name] = v.Load()
 		default:
 			panic(fmt.Sprintf("unknown field type %T for %v", v, name))
 		}
@@ -7,6 +7,7 @@
 	"reflect"
 	"testing"
 
+	"tailscale.com/tailcfg"
 	"tailscale.com/types/logger"
 )
 
@@ -22,3 +23,32 @@ func TestAsDebugJSON(t *testing.T) {
 	}
 	t.Logf("Got: %v", logger.AsJSON(got))
 }
+
+func TestMagicsockPortRange(t *testing.T) {
+	tests := []struct {
+		name string
+		vals []tailcfg.RawMessage
+		want tailcfg.PortRange
+	}{
+		{"unset", nil, tailcfg.PortRange{}},
+		{"range", []tailcfg.RawMessage{`{"First":40000,"Last":40100}`}, tailcfg.PortRange{First: 40000, Last: 40100}},
+		{"zero-first", []tailcfg.RawMessage{`{"First":0,"Last":40100}`}, tailcfg.PortRange{}},
+		{"backwards", []tailcfg.RawMessage{`{"First":40100,"Last":40000}`}, tailcfg.PortRange{}},
+		{"two", []tailcfg.RawMessage{`{"First":1,"Last":2}`, `{"First":3,"Last":4}`}, tailcfg.PortRange{}},
+		{"bad-json", []tailcfg.RawMessage{`"40000-40100"`}, tailcfg.PortRange{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := new(Knobs)
+			k.MagicsockPortRange.Store(tailcfg.PortRange{First: 1, Last: 1})
+			capMap := tailcfg.NodeCapMap{}
+			if tt.vals != nil {
+				capMap[tailcfg.NodeAttrMagicsockPortRange] = tt.vals
+			}
+			k.UpdateFromNodeAttributes(capMap)
+			if got := k.MagicsockPortRange.Load(); got != tt.want {
+				t.Errorf("MagicsockPortRange = %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
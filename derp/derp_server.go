@@ -199,6 +199,11 @@ type Server struct {
 	// maps from netip.AddrPort to a client's public key
 	keyOfAddr map[netip.AddrPort]key.NodePublic
 
+	// rateLimit is the limit on packets sent by clients without an
+	// entry in clientRateLimits. See SetRateLimit.
+	rateLimit        RateLimit
+	clientRateLimits map[key.NodePublic]RateLimit
+
 	// Sets the client send queue depth for the server.
 	perClientSendQueueDepth int
 
@@ -440,6 +445,7 @@ func genDroppedCounters() {
 		dropReasonQueueTail,
 		dropReasonWriteError,
 		dropReasonDupClient,
+		dropReasonRateLimited,
 	}
 
 	for _, dr := range dropReasons {
@@ -676,6 +682,7 @@ func (s *Server) registerClient(c *sclient) {
 		s.clientsMesh[c.key] = nil // just for varz of total users in cluster
 	}
 	s.keyOfAddr[c.remoteIPPort] = c.key
+	c.setLimiter(s.rateLimitLocked(c.key))
 	s.curClients.Add(1)
 	if c.isNotIdealConn {
 		s.curClientsNotIdeal.Add(1)
@@ -1150,6 +1157,12 @@ func (c *sclient) handleFrameSendPacket(ft frameType, fl uint32) error {
 		return fmt.Errorf("client %v: recvPacket: %v", c.key, err)
 	}
 
+	if lim := c.lim.Load(); lim != nil && !lim.allow(s.clock.Now(), len(contents)) {
+		s.recordDrop(contents, c.key, dstKey, dropReasonRateLimited)
+		c.debugLogf("SendPacket for %s, dropping with reason=%s", dstKey.ShortString(), dropReasonRateLimited)
+		return nil
+	}
+
 	var fwd PacketForwarder
 	var dstLen int
 	var dst *sclient
@@ -1217,6 +1230,7 @@ type dropReasonKindLabels struct {
 	dropReasonQueueTail        dropReason = "queue_tail"          // destination queue is full, dropped packet at queue tail
 	dropReasonWriteError       dropReason = "write_error"         // OS write() failed
 	dropReasonDupClient        dropReason = "dup_client"          // the public key is connected 2+ times (active/active, fighting)
+	dropReasonRateLimited      dropReason = "rate_limited"        // the source client exceeded its RateLimit
 )
 
 func (s *Server) recordDrop(packetBytes []byte, srcKey, dstKey key.NodePublic, reason dropReason) {
@@ -1622,6 +1636,10 @@ type sclient struct {
 	// client that it's trying to establish a direct connection
 	// through us with a peer we have no record of.
 	peerGoneLim *rate.Limiter
+
+	// lim, if non-nil, limits the packets the client sends; see
+	// Server.SetRateLimit.
+	lim atomic.Pointer[clientLimiter]
 }
 
 func (c *sclient) presentFlags() PeerPresentFlags {
@@ -0,0 +1,152 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derp
+
+import (
+	"maps"
+	"time"
+
+	"golang.org/x/time/rate"
+	"tailscale.com/types/key"
+	"tailscale.com/util/mak"
+)
+
+// RateLimit is a limit on the packets a client may send through a Server,
+// enforced with token buckets of bytes and packets. Packets over either
+// limit are dropped. A zero rate is unlimited.
+type RateLimit struct {
+	// BytesPerSecond is the rate at which the client may send packet
+	// payload bytes.
+	BytesPerSecond int `json:",omitempty"`
+
+	// BytesBurst is how many bytes the client may send at once, above
+	// BytesPerSecond. If zero, it's one second's worth, and at least
+	// MaxPacketSize, so any packet can get through.
+	BytesBurst int `json:",omitempty"`
+
+	// PacketsPerSecond is the rate at which the client may send packets.
+	PacketsPerSecond int `json:",omitempty"`
+
+	// PacketsBurst is how many packets the client may send at once, above
+	// PacketsPerSecond. If zero, it's one second's worth.
+	PacketsBurst int `json:",omitempty"`
+}
+
+// IsZero reports whether rl is unlimited.
+func (rl RateLimit) IsZero() bool {
+	return rl.BytesPerSecond <= 0 && rl.PacketsPerSecond <= 0
+}
+
+// clientLimiter enforces a RateLimit on a client's packets.
+type clientLimiter struct {
+	bytes   *rate.Limiter // or nil if unlimited
+	packets *rate.Limiter // or nil if unlimited
+}
+
+// newClientLimiter returns a limiter enforcing rl, or nil if rl is
+// unlimited.
+func newClientLimiter(rl RateLimit) *clientLimiter {
+	if rl.IsZero() {
+		return nil
+	}
+	l := new(clientLimiter)
+	if rl.BytesPerSecond > 0 {
+		burst := rl.BytesBurst
+		if burst <= 0 {
+			burst = max(rl.BytesPerSecond, MaxPacketSize)
+		}
+		l.bytes = rate.NewLimiter(rate.Limit(rl.BytesPerSecond), burst)
+	}
+	if rl.PacketsPerSecond > 0 {
+		burst := rl.PacketsBurst
+		if burst <= 0 {
+			burst = rl.PacketsPerSecond
+		}
+		l.packets = rate.NewLimiter(rate.Limit(rl.PacketsPerSecond), burst)
+	}
+	return l
+}
+
+// allow reports whether a packet of n bytes may be sent at now, consuming
+// tokens if so.
+func (l *clientLimiter) allow(now time.Time, n int) bool {
+	if l.packets != nil && !l.packets.AllowN(now, 1) {
+		return false
+	}
+	return l.bytes == nil || l.bytes.AllowN(now, n)
+}
+
+// SetRateLimit sets the limit on the packets each client may send through
+// the server, for clients without their own limit set by
+// SetClientRateLimit. Mesh peers aren't limited. It applies to connected
+// clients too.
+func (s *Server) SetRateLimit(rl RateLimit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rateLimit = rl
+	for k := range s.clients {
+		s.updateClientLimiterLocked(k)
+	}
+}
+
+// SetClientRateLimit sets the limit on the packets the client with key k
+// may send through the server, in place of the one set by SetRateLimit. A
+// zero rl exempts the client from limits. It applies to the client if
+// it's connected.
+func (s *Server) SetClientRateLimit(k key.NodePublic, rl RateLimit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mak.Set(&s.clientRateLimits, k, rl)
+	s.updateClientLimiterLocked(k)
+}
+
+// ClearClientRateLimit removes the limit that SetClientRateLimit set for
+// the client with key k, returning it to the one set by SetRateLimit.
+func (s *Server) ClearClientRateLimit(k key.NodePublic) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clientRateLimits, k)
+	s.updateClientLimiterLocked(k)
+}
+
+// RateLimits returns the limits set by SetRateLimit and
+// SetClientRateLimit.
+func (s *Server) RateLimits() (def RateLimit, clients map[key.NodePublic]RateLimit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rateLimit, maps.Clone(s.clientRateLimits)
+}
+
+// rateLimitLocked returns the limit for the client with key k.
+//
+// s.mu must be held.
+func (s *Server) rateLimitLocked(k key.NodePublic) RateLimit {
+	if rl, ok := s.clientRateLimits[k]; ok {
+		return rl
+	}
+	return s.rateLimit
+}
+
+// updateClientLimiterLocked resets the limiters of the connections from
+// the client with key k to its current limit.
+//
+// s.mu must be held.
+func (s *Server) updateClientLimiterLocked(k key.NodePublic) {
+	cs, ok := s.clients[k]
+	if !ok {
+		return
+	}
+	rl := s.rateLimitLocked(k)
+	cs.ForeachClient(func(c *sclient) {
+		c.setLimiter(rl)
+	})
+}
+
+// setLimiter starts enforcing rl on c, unless c is a mesh peer.
+func (c *sclient) setLimiter(rl RateLimit) {
+	if c.canMesh {
+		return
+	}
+	c.lim.Store(newClientLimiter(rl))
+}
@@ -19,6 +19,7 @@
 	"net"
 	"os"
 	"reflect"
+	"slices"
 	"strconv"
 	"sync"
 	"testing"
@@ -1601,6 +1602,97 @@ func TestServerRepliesToPing(t *testing.T) {
 	}
 }
 
+func TestClientLimiter(t *testing.T) {
+	now := time.Now()
+	l := newClientLimiter(RateLimit{BytesPerSecond: 1000})
+	if !l.allow(now, MaxPacketSize) {
+		t.Fatal("max size packet not allowed by fresh limiter")
+	}
+	if l.allow(now, 1) {
+		t.Fatal("packet allowed over byte burst")
+	}
+	if !l.allow(now.Add(time.Second), 1000) {
+		t.Fatal("packet not allowed after a second's worth of bytes")
+	}
+
+	l = newClientLimiter(RateLimit{PacketsPerSecond: 2, PacketsBurst: 4})
+	for i := range 4 {
+		if !l.allow(now, 1) {
+			t.Fatalf("packet %d within burst not allowed", i)
+		}
+	}
+	if l.allow(now, 1) {
+		t.Fatal("packet allowed over packet burst")
+	}
+	if !l.allow(now.Add(500*time.Millisecond), 1) {
+		t.Fatal("packet not allowed after refill")
+	}
+
+	if l := newClientLimiter(RateLimit{}); l != nil {
+		t.Fatalf("newClientLimiter(zero) = %v; want nil", l)
+	}
+}
+
+func TestServerRateLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ts := newTestServer(t, ctx)
+	defer ts.close(t)
+	clock := &tstest.Clock{}
+	ts.s.clock = clock
+	ts.s.SetRateLimit(RateLimit{PacketsPerSecond: 1, PacketsBurst: 3})
+
+	alice := newRegularClient(t, ts, "alice")
+	bob := newRegularClient(t, ts, "bob")
+
+	dropped := packetsDropped.Get(dropReasonKindLabels{
+		Kind:   string(packetKindOther),
+		Reason: string(dropReasonRateLimited),
+	}).(*expvar.Int)
+	dropped0 := dropped.Value()
+	for i := range 5 {
+		if err := alice.c.Send(bob.pub, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Wait for the server to have handled all five, so the limit we set
+	// next doesn't apply to them.
+	if err := tstest.WaitFor(5*time.Second, func() error {
+		if got := dropped.Value() - dropped0; got != 2 {
+			return fmt.Errorf("dropped %d packets; want 2", got)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Exempt alice, and send a final packet past the limit.
+	ts.s.SetClientRateLimit(alice.pub, RateLimit{})
+	if def, clients := ts.s.RateLimits(); def.PacketsPerSecond != 1 || len(clients) != 1 {
+		t.Errorf("RateLimits = %+v, %v", def, clients)
+	}
+	if err := alice.c.Send(bob.pub, []byte("end")); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for {
+		m, err := bob.c.recvTimeout(time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p, ok := m.(ReceivedPacket); ok {
+			got = append(got, string(p.Data))
+			if string(p.Data) == "end" {
+				break
+			}
+		}
+	}
+	if want := []string{"\x00", "\x01", "\x02", "end"}; !slices.Equal(got, want) {
+		t.Errorf("bob got %q; want %q", got, want)
+	}
+}
+
 func TestGetPerClientSendQueueDepth(t *testing.T) {
 	c := qt.New(t)
 	envKey := "TS_DEBUG_DERP_PER_CLIENT_SEND_QUEUE_DEPTH"
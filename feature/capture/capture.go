@@ -35,13 +35,19 @@ func serveLocalAPIDebugCapture(h *localapi.Handler, w http.ResponseWriter, r *ht
 		return
 	}
 
+	filter, err := ParseFilter(r.FormValue("filter"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 	w.(http.Flusher).Flush()
 
 	b := h.LocalBackend()
-	s := b.GetOrSetCaptureSink(newSink)
+	s := b.GetOrSetCaptureSink(newSink).(*Sink)
 
-	unregister := s.RegisterOutput(w)
+	unregister := s.RegisterFilteredOutput(w, filter)
 
 	select {
 	case <-ctx.Done():
@@ -97,10 +103,16 @@ type Sink struct {
 	ctxCancel context.CancelFunc
 
 	mu         sync.Mutex
-	outputs    set.HandleSet[io.Writer]
+	outputs    set.HandleSet[output]
 	flushTimer *time.Timer // or nil if none running
 }
 
+// output is an output registered with a Sink.
+type output struct {
+	w      io.Writer
+	filter *Filter // or nil to write all packets
+}
+
 // RegisterOutput connects an output to this sink, which
 // will be written to with a pcap stream as packets are logged.
 // A function is returned which unregisters the output when
@@ -110,6 +122,12 @@ type Sink struct {
 // or when the sink is closed. If w implements http.Flusher,
 // it will be flushed periodically.
 func (s *Sink) RegisterOutput(w io.Writer) (unregister func()) {
+	return s.RegisterFilteredOutput(w, nil)
+}
+
+// RegisterFilteredOutput is like RegisterOutput, but only writes the
+// packets matching filter to w. A nil filter matches all packets.
+func (s *Sink) RegisterFilteredOutput(w io.Writer, filter *Filter) (unregister func()) {
 	select {
 	case <-s.ctx.Done():
 		return func() {}
@@ -118,7 +136,7 @@ func (s *Sink) RegisterOutput(w io.Writer) (unregister func()) {
 
 	writePcapHeader(w)
 	s.mu.Lock()
-	hnd := s.outputs.Add(w)
+	hnd := s.outputs.Add(output{w, filter})
 	s.mu.Unlock()
 
 	return func() {
@@ -152,8 +170,8 @@ func (s *Sink) Close() error {
 	}
 
 	for _, o := range s.outputs {
-		if o, ok := o.(io.Closer); ok {
-			o.Close()
+		if c, ok := o.w.(io.Closer); ok {
+			c.Close()
 		}
 	}
 	s.outputs = nil
@@ -217,14 +235,17 @@ func (s *Sink) LogPacket(path packet.CapturePath, when time.Time, data []byte, m
 
 	var hadError []set.Handle
 	for hnd, o := range s.outputs {
-		if _, err := o.Write(b.Bytes()); err != nil {
+		if o.filter != nil && !o.filter.Match(path, data) {
+			continue
+		}
+		if _, err := o.w.Write(b.Bytes()); err != nil {
 			hadError = append(hadError, hnd)
 			continue
 		}
 	}
 	for _, hnd := range hadError {
-		if o, ok := s.outputs[hnd].(io.Closer); ok {
-			o.Close()
+		if c, ok := s.outputs[hnd].w.(io.Closer); ok {
+			c.Close()
 		}
 		delete(s.outputs, hnd)
 	}
@@ -234,7 +255,7 @@ func (s *Sink) LogPacket(path packet.CapturePath, when time.Time, data []byte, m
 			s.mu.Lock()
 			defer s.mu.Unlock()
 			for _, o := range s.outputs {
-				if f, ok := o.(http.Flusher); ok {
+				if f, ok := o.w.(http.Flusher); ok {
 					f.Flush()
 				}
 			}
@@ -0,0 +1,312 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package capture
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"tailscale.com/net/packet"
+	"tailscale.com/types/ipproto"
+)
+
+// Filter selects the packets written to a capture output. It is parsed
+// from a subset of the tcpdump (pcap-filter) syntax and matches the inner,
+// decrypted IP packets.
+//
+// The supported primitives are:
+//
+//	[src|dst] host ADDR
+//	[src|dst] net PREFIX
+//	[src|dst] port PORT
+//	proto PROTO   (a name such as "sctp", or a number)
+//	ip, ip6, tcp, udp, icmp, icmp6
+//	disco         (disco frames, which are never matched otherwise)
+//
+// Primitives can be combined with "and" ("&&"), "or" ("||"), "not" ("!")
+// and parentheses. Adjacent primitives are implicitly joined with "and".
+type Filter struct {
+	expr  string
+	match filterFunc
+}
+
+// filterFunc reports whether a packet matches. p is nil for disco frames.
+type filterFunc func(path packet.CapturePath, p *packet.Parsed) bool
+
+// String returns the expression f was parsed from.
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// Match reports whether the packet data, captured at path, matches f.
+func (f *Filter) Match(path packet.CapturePath, data []byte) bool {
+	if path == packet.PathDisco {
+		return f.match(path, nil)
+	}
+	var p packet.Parsed
+	p.Decode(data)
+	return f.match(path, &p)
+}
+
+// ParseFilter parses a filter expression. It returns a nil Filter, which
+// matches everything, if expr is empty.
+func ParseFilter(expr string) (*Filter, error) {
+	fp := &filterParser{toks: tokenizeFilter(expr)}
+	if len(fp.toks) == 0 {
+		return nil, nil
+	}
+	match, err := fp.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid capture filter %q: %w", expr, err)
+	}
+	if tok, ok := fp.peek(); ok {
+		return nil, fmt.Errorf("invalid capture filter %q: unexpected %q", expr, tok)
+	}
+	return &Filter{expr: expr, match: match}, nil
+}
+
+// tokenizeFilter splits expr into words, with parentheses and the "!"
+// operator as separate tokens.
+func tokenizeFilter(expr string) []string {
+	var toks []string
+	for _, f := range strings.Fields(expr) {
+		for f != "" {
+			i := strings.IndexAny(f, "()!")
+			switch {
+			case i < 0:
+				toks = append(toks, f)
+				f = ""
+			case i > 0:
+				toks = append(toks, f[:i])
+				f = f[i:]
+			default:
+				toks = append(toks, f[:1])
+				f = f[1:]
+			}
+		}
+	}
+	return toks
+}
+
+type filterParser struct {
+	toks []string
+}
+
+func (fp *filterParser) peek() (string, bool) {
+	if len(fp.toks) == 0 {
+		return "", false
+	}
+	return fp.toks[0], true
+}
+
+func (fp *filterParser) next() (string, error) {
+	tok, ok := fp.peek()
+	if !ok {
+		return "", fmt.Errorf("unexpected end of expression")
+	}
+	fp.toks = fp.toks[1:]
+	return tok, nil
+}
+
+func (fp *filterParser) parseOr() (filterFunc, error) {
+	left, err := fp.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, _ := fp.peek()
+		if tok != "or" && tok != "||" {
+			return left, nil
+		}
+		fp.next()
+		right, err := fp.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(path packet.CapturePath, p *packet.Parsed) bool {
+			return l(path, p) || right(path, p)
+		}
+	}
+}
+
+func (fp *filterParser) parseAnd() (filterFunc, error) {
+	left, err := fp.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := fp.peek()
+		if !ok || tok == "or" || tok == "||" || tok == ")" {
+			return left, nil
+		}
+		if tok == "and" || tok == "&&" {
+			fp.next()
+		}
+		right, err := fp.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(path packet.CapturePath, p *packet.Parsed) bool {
+			return l(path, p) && right(path, p)
+		}
+	}
+}
+
+func (fp *filterParser) parseNot() (filterFunc, error) {
+	tok, err := fp.next()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case "not", "!":
+		f, err := fp.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(path packet.CapturePath, p *packet.Parsed) bool {
+			return !f(path, p)
+		}, nil
+	case "(":
+		f, err := fp.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok, err := fp.next(); err != nil || tok != ")" {
+			return nil, fmt.Errorf("missing %q", ")")
+		}
+		return f, nil
+	}
+	return fp.parsePrimitive(tok)
+}
+
+// Address directions of the host, net and port primitives.
+const (
+	dirAny = iota
+	dirSrc
+	dirDst
+)
+
+func (fp *filterParser) parsePrimitive(tok string) (filterFunc, error) {
+	dir := dirAny
+	switch tok {
+	case "src", "dst":
+		if tok == "src" {
+			dir = dirSrc
+		} else {
+			dir = dirDst
+		}
+		qual := tok
+		var err error
+		if tok, err = fp.next(); err != nil {
+			return nil, err
+		}
+		if tok != "host" && tok != "net" && tok != "port" {
+			return nil, fmt.Errorf("expected host, net or port after %q, got %q", qual, tok)
+		}
+	}
+
+	switch tok {
+	case "host", "net":
+		arg, err := fp.next()
+		if err != nil {
+			return nil, err
+		}
+		var pfx netip.Prefix
+		if tok == "host" {
+			ip, err := netip.ParseAddr(arg)
+			if err != nil {
+				return nil, err
+			}
+			pfx = netip.PrefixFrom(ip, ip.BitLen())
+		} else if pfx, err = netip.ParsePrefix(arg); err != nil {
+			return nil, err
+		}
+		pfx = pfx.Masked()
+		return matchAddrs(dir, func(ap netip.AddrPort) bool {
+			return pfx.Contains(ap.Addr())
+		}), nil
+	case "port":
+		arg, err := fp.next()
+		if err != nil {
+			return nil, err
+		}
+		port, err := strconv.ParseUint(arg, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", arg)
+		}
+		inAddr := matchAddrs(dir, func(ap netip.AddrPort) bool {
+			return ap.Port() == uint16(port)
+		})
+		return func(path packet.CapturePath, p *packet.Parsed) bool {
+			return p != nil && hasPorts(p.IPProto) && inAddr(path, p)
+		}, nil
+	case "proto":
+		arg, err := fp.next()
+		if err != nil {
+			return nil, err
+		}
+		var proto ipproto.Proto
+		if err := proto.UnmarshalText([]byte(arg)); err != nil {
+			return nil, err
+		}
+		return matchProto(proto), nil
+	case "ip", "ip6":
+		v := uint8(4)
+		if tok == "ip6" {
+			v = 6
+		}
+		return func(path packet.CapturePath, p *packet.Parsed) bool {
+			return p != nil && p.IPVersion == v
+		}, nil
+	case "tcp":
+		return matchProto(ipproto.TCP), nil
+	case "udp":
+		return matchProto(ipproto.UDP), nil
+	case "icmp":
+		return matchProto(ipproto.ICMPv4), nil
+	case "icmp6":
+		return matchProto(ipproto.ICMPv6), nil
+	case "disco":
+		return func(path packet.CapturePath, p *packet.Parsed) bool {
+			return path == packet.PathDisco
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown primitive %q", tok)
+}
+
+// matchAddrs returns a filterFunc matching IP packets whose source and/or
+// destination, as selected by dir, satisfy f.
+func matchAddrs(dir int, f func(netip.AddrPort) bool) filterFunc {
+	return func(path packet.CapturePath, p *packet.Parsed) bool {
+		if p == nil || p.IPVersion == 0 {
+			return false
+		}
+		switch dir {
+		case dirSrc:
+			return f(p.Src)
+		case dirDst:
+			return f(p.Dst)
+		}
+		return f(p.Src) || f(p.Dst)
+	}
+}
+
+func matchProto(proto ipproto.Proto) filterFunc {
+	return func(path packet.CapturePath, p *packet.Parsed) bool {
+		return p != nil && p.IPVersion != 0 && p.IPProto == proto
+	}
+}
+
+// hasPorts reports whether packet.Parsed decodes ports for proto.
+func hasPorts(proto ipproto.Proto) bool {
+	switch proto {
+	case ipproto.TCP, ipproto.UDP, ipproto.SCTP:
+		return true
+	}
+	return false
+}
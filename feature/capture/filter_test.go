@@ -0,0 +1,113 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package capture
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"tailscale.com/net/packet"
+	"tailscale.com/types/ipproto"
+)
+
+func TestFilter(t *testing.T) {
+	var (
+		a  = netip.MustParseAddr("100.64.0.1")
+		b  = netip.MustParseAddr("100.64.0.2")
+		a6 = netip.MustParseAddr("fd7a:115c:a1e0::1")
+		b6 = netip.MustParseAddr("fd7a:115c:a1e0::2")
+	)
+	tcpPayload := make([]byte, 20)
+	binary.BigEndian.PutUint16(tcpPayload[0:], 50000) // source port
+	binary.BigEndian.PutUint16(tcpPayload[2:], 22)    // destination port
+	tcpPayload[12] = 5 << 4                           // data offset
+
+	pkts := map[string][]byte{
+		"udp4": packet.Generate(packet.UDP4Header{
+			IP4Header: packet.IP4Header{Src: a, Dst: b},
+			SrcPort:   41641,
+			DstPort:   53,
+		}, nil),
+		"udp6": packet.Generate(packet.UDP6Header{
+			IP6Header: packet.IP6Header{Src: b6, Dst: a6},
+			SrcPort:   53,
+			DstPort:   41641,
+		}, nil),
+		"tcp4": packet.Generate(packet.IP4Header{IPProto: ipproto.TCP, Src: b, Dst: a}, tcpPayload),
+		"icmp4": packet.Generate(packet.ICMP4Header{
+			IP4Header: packet.IP4Header{Src: a, Dst: b},
+			Type:      packet.ICMP4EchoRequest,
+		}, nil),
+		"disco": {0x00, 0x01, 0x02},
+	}
+	order := []string{"udp4", "udp6", "tcp4", "icmp4", "disco"}
+
+	tests := []struct {
+		expr string
+		want []string // packets matching expr
+	}{
+		{"", order},
+		{"udp", []string{"udp4", "udp6"}},
+		{"tcp", []string{"tcp4"}},
+		{"icmp", []string{"icmp4"}},
+		{"ip6", []string{"udp6"}},
+		{"disco", []string{"disco"}},
+		{"not disco", []string{"udp4", "udp6", "tcp4", "icmp4"}},
+		{"host 100.64.0.1", []string{"udp4", "tcp4", "icmp4"}},
+		{"src host 100.64.0.1", []string{"udp4", "icmp4"}},
+		{"dst host 100.64.0.1", []string{"tcp4"}},
+		{"net fd7a:115c:a1e0::/48", []string{"udp6"}},
+		{"port 53", []string{"udp4", "udp6"}},
+		{"dst port 53", []string{"udp4"}},
+		{"port 0", nil}, // ICMP has no ports
+		{"tcp port 22", []string{"tcp4"}},
+		{"udp and not ip6", []string{"udp4"}},
+		{"proto 17 && !(src port 53)", []string{"udp4"}},
+		{"tcp or icmp", []string{"tcp4", "icmp4"}},
+		{"(tcp or udp) and host 100.64.0.2", []string{"udp4", "tcp4"}},
+		{"proto sctp", nil},
+	}
+	for _, tt := range tests {
+		f, err := ParseFilter(tt.expr)
+		if err != nil {
+			t.Errorf("ParseFilter(%q): %v", tt.expr, err)
+			continue
+		}
+		var got []string
+		for _, name := range order {
+			path := packet.FromLocal
+			if name == "disco" {
+				path = packet.PathDisco
+			}
+			if f == nil || f.Match(path, pkts[name]) {
+				got = append(got, name)
+			}
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q matched %q; want %q", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, expr := range []string{
+		"foo",
+		"host",
+		"host 100.64.0.300",
+		"net 100.64.0.0",
+		"port 70000",
+		"src tcp",
+		"proto bogus",
+		"tcp and",
+		"(tcp",
+		"tcp)",
+		"not",
+	} {
+		if f, err := ParseFilter(expr); err == nil {
+			t.Errorf("ParseFilter(%q) = %v; want error", expr, f)
+		}
+	}
+}
@@ -81,6 +81,7 @@ func New() *tailcfg.Hostinfo {
 	distroCodeName func() string
 	unameMachine   func() string
 	deviceModel    func() string
+	jailState      func() Jail
 )
 
 func condCall[T any](fn func() T) T {
@@ -294,6 +295,11 @@ func getEnvType() EnvType {
 // there's no foolproof way to detect this, but the build tag should catch all
 // official builds from 1.78.0.
 func inContainer() opt.Bool {
+	if runtime.GOOS == "freebsd" {
+		var ret opt.Bool
+		ret.Set(GetJail().Jailed)
+		return ret
+	}
 	if runtime.GOOS != "linux" {
 		return ""
 	}
@@ -479,6 +485,26 @@ func etcAptSourceFileIsDisabled(r io.Reader) bool {
 	return disabled
 }
 
+// Jail describes the FreeBSD jail the current process runs in, if any.
+type Jail struct {
+	// Jailed is whether the process runs inside a jail.
+	Jailed bool
+
+	// VNET is whether the jail has its own virtualized network stack,
+	// and so can create interfaces and manage its own routing table. A
+	// jail without one shares the host's network stack, which it can't
+	// modify.
+	VNET bool
+}
+
+// GetJail returns the FreeBSD jail the current process runs in. On other
+// platforms, it returns the zero value.
+func GetJail() Jail {
+	return lazyJail.Get()
+}
+
+var lazyJail = &lazyAtomicValue[Jail]{f: &jailState}
+
 // IsSELinuxEnforcing reports whether SELinux is in "Enforcing" mode.
 func IsSELinuxEnforcing() bool {
 	if runtime.GOOS != "linux" {
@@ -19,6 +19,7 @@ func init() {
 	osVersion = lazyOSVersion.Get
 	distroName = distroNameFreeBSD
 	distroVersion = distroVersionFreeBSD
+	jailState = jailStateFreeBSD
 }
 
 var (
@@ -62,3 +63,14 @@ func freebsdVersionMeta() (meta versionMeta) {
 	}
 	return
 }
+
+// jailStateFreeBSD reports the jail state using the sysctls that jail(8)
+// documents for the purpose. Both read as 0 outside of a jail.
+func jailStateFreeBSD() Jail {
+	jailed, _ := unix.SysctlUint32("security.jail.jailed")
+	vnet, _ := unix.SysctlUint32("security.jail.vnet")
+	return Jail{
+		Jailed: jailed != 0,
+		VNET:   jailed != 0 && vnet != 0,
+	}
+}
@@ -109,6 +109,11 @@ type Notify struct {
 	Engine        *EngineStatus      // if non-nil, the new or current wireguard stats
 	BrowseToURL   *string            // if non-nil, UI should open a browser right now
 
+	// LoginUserCode, if non-nil, is the code the user must enter at
+	// BrowseToURL to complete a device-code login, which may be done
+	// from another device. It's sent along with BrowseToURL.
+	LoginUserCode *string `json:",omitempty"`
+
 	// FilesWaiting if non-nil means that files are buffered in
 	// the Tailscale daemon and ready for local transfer to the
 	// user's preferred storage location.
@@ -180,6 +185,9 @@ func (n Notify) String() string {
 	if n.BrowseToURL != nil {
 		sb.WriteString("URL=<...> ")
 	}
+	if n.LoginUserCode != nil {
+		sb.WriteString("UserCode=<...> ")
+	}
 	if n.FilesWaiting != nil {
 		sb.WriteString("FilesWaiting ")
 	}
@@ -0,0 +1,148 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// BackupVersion is the current version of the [Backup] format.
+const BackupVersion = 1
+
+// Backup is a backup of a node's configuration, as created by
+// 'tailscale backup create' and applied by 'tailscale backup restore'.
+type Backup struct {
+	// Version is the version of the format, [BackupVersion].
+	Version int
+
+	// Created is when the backup was created.
+	Created time.Time
+
+	// CurrentProfile is the ID of the profile that was in use.
+	CurrentProfile ProfileID `json:",omitempty"`
+
+	// Profiles are the backed up profiles.
+	Profiles []BackupProfile
+
+	// Certs are the node's TLS certs.
+	Certs []BackupCert `json:",omitempty"`
+
+	// ACMEKey is the PEM-encoded key of the node's ACME account, if any.
+	ACMEKey []byte `json:",omitempty"`
+
+	// IncludesKeys is whether the backup includes the machine key and the
+	// profiles' node keys. Without them, a restored node must log in again
+	// and becomes a new node.
+	IncludesKeys bool `json:",omitempty"`
+
+	// MachineKey is the text-encoded machine key, if IncludesKeys.
+	MachineKey []byte `json:",omitempty"`
+}
+
+// BackupProfile is a profile in a [Backup].
+type BackupProfile struct {
+	// Profile is the profile's metadata.
+	Profile *LoginProfile
+
+	// Prefs are the profile's prefs, including its Taildrive shares.
+	// Prefs.Persist is nil unless the backup includes keys.
+	Prefs *Prefs
+
+	// ServeConfig is the profile's serve config, if any.
+	ServeConfig *ServeConfig `json:",omitempty"`
+}
+
+// BackupCert is a TLS cert in a [Backup].
+type BackupCert struct {
+	Domain  string
+	CertPEM []byte
+	KeyPEM  []byte
+}
+
+// backupMagic starts an encrypted backup archive. It's also the additional
+// data authenticated along with the archive's contents.
+const backupMagic = "tailscale-backup-v1\n"
+
+const (
+	backupSaltLen = 16
+
+	// argon2id parameters, as recommended in RFC 9106 for memory-constrained
+	// environments.
+	backupKDFTime    = 3
+	backupKDFMemory  = 64 * 1024 // KiB
+	backupKDFThreads = 4
+)
+
+// ErrBadBackupPassphrase is returned by [OpenBackup] if the passphrase is
+// wrong or the archive has been tampered with.
+var ErrBadBackupPassphrase = errors.New("wrong passphrase or corrupt backup")
+
+func backupKey(passphrase, salt []byte) []byte {
+	return argon2.IDKey(passphrase, salt, backupKDFTime, backupKDFMemory, backupKDFThreads, chacha20poly1305.KeySize)
+}
+
+// SealBackup encodes b as an archive encrypted with a key derived from
+// passphrase.
+func SealBackup(b *Backup, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	plain, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, len(backupMagic)+backupSaltLen+chacha20poly1305.NonceSizeX)
+	copy(buf, backupMagic)
+	salt := buf[len(backupMagic) : len(backupMagic)+backupSaltLen]
+	nonce := buf[len(backupMagic)+backupSaltLen:]
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(backupKey(passphrase, salt))
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(buf, nonce, plain, []byte(backupMagic)), nil
+}
+
+// OpenBackup decrypts and decodes an archive made by [SealBackup] with the
+// same passphrase.
+func OpenBackup(archive, passphrase []byte) (*Backup, error) {
+	rest, ok := bytes.CutPrefix(archive, []byte(backupMagic))
+	if !ok {
+		return nil, errors.New("not a Tailscale backup")
+	}
+	if len(rest) < backupSaltLen+chacha20poly1305.NonceSizeX {
+		return nil, errors.New("truncated backup")
+	}
+	salt, rest := rest[:backupSaltLen], rest[backupSaltLen:]
+	nonce, sealed := rest[:chacha20poly1305.NonceSizeX], rest[chacha20poly1305.NonceSizeX:]
+	aead, err := chacha20poly1305.NewX(backupKey(passphrase, salt))
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, nonce, sealed, []byte(backupMagic))
+	if err != nil {
+		return nil, ErrBadBackupPassphrase
+	}
+	b := new(Backup)
+	if err := json.Unmarshal(plain, b); err != nil {
+		return nil, fmt.Errorf("decoding backup: %w", err)
+	}
+	if b.Version > BackupVersion {
+		return nil, fmt.Errorf("backup version %d is newer than supported version %d; upgrade Tailscale", b.Version, BackupVersion)
+	}
+	return b, nil
+}
@@ -0,0 +1,57 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSealBackup(t *testing.T) {
+	b := &Backup{
+		Version:        BackupVersion,
+		Created:        time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		CurrentProfile: "1234",
+		Profiles: []BackupProfile{{
+			Profile: &LoginProfile{ID: "1234", Key: "profile-1234", Name: "alice@example.com"},
+			Prefs:   &Prefs{Hostname: "foo", RouteAll: true},
+			ServeConfig: &ServeConfig{
+				TCP: map[uint16]*TCPPortHandler{443: {HTTPS: true}},
+			},
+		}},
+		Certs: []BackupCert{{Domain: "foo.example.ts.net", CertPEM: []byte("cert"), KeyPEM: []byte("key")}},
+	}
+	archive, err := SealBackup(b, []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := OpenBackup(archive, []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotj, _ := json.Marshal(got)
+	wantj, _ := json.Marshal(b)
+	if !bytes.Equal(gotj, wantj) {
+		t.Errorf("OpenBackup = %s; want %s", gotj, wantj)
+	}
+
+	if _, err := OpenBackup(archive, []byte("hunter3")); !errors.Is(err, ErrBadBackupPassphrase) {
+		t.Errorf("OpenBackup with wrong passphrase: err = %v; want %v", err, ErrBadBackupPassphrase)
+	}
+	tampered := append([]byte(nil), archive...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := OpenBackup(tampered, []byte("hunter2")); !errors.Is(err, ErrBadBackupPassphrase) {
+		t.Errorf("OpenBackup of tampered archive: err = %v; want %v", err, ErrBadBackupPassphrase)
+	}
+	if _, err := OpenBackup([]byte("{}"), []byte("hunter2")); err == nil {
+		t.Error("OpenBackup of non-archive succeeded")
+	}
+	if _, err := SealBackup(b, nil); err == nil {
+		t.Error("SealBackup with empty passphrase succeeded")
+	}
+}
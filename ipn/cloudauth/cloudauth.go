@@ -0,0 +1,218 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package cloudauth obtains an auth key for a cloud VM at boot by presenting
+// the VM's signed instance identity to an exchange endpoint, so that images
+// and autoscaling groups don't need to carry long-lived pre-auth keys.
+//
+// The endpoint receives a POST of a JSON [Request] and, having verified the
+// identity with the cloud provider's published keys, replies with a JSON
+// [Response] holding a (typically single-use, pre-approved) auth key.
+package cloudauth
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/omit"
+	"tailscale.com/util/cloudenv"
+)
+
+// Request is the body POSTed to the exchange endpoint.
+type Request struct {
+	// Provider is the cloud the identity is from: "aws", "gcp" or "azure".
+	Provider cloudenv.Cloud
+
+	// Document is the identity document: on AWS, the instance identity
+	// document (JSON); on GCP, the instance identity token (a JWT); on
+	// Azure, the attested data document's signature (base64 PKCS#7).
+	Document string
+
+	// Signature is the PKCS#7 signature of Document on AWS, and empty
+	// elsewhere, where Document is self-signed.
+	Signature string `json:",omitempty"`
+
+	// Nonce is the nonce embedded in the Azure attested data document,
+	// for the endpoint to check against replays. Empty elsewhere; GCP
+	// tokens are bound to the audience and expire within the hour.
+	Nonce string `json:",omitempty"`
+
+	// Hostname is the OS hostname, for the endpoint's logs.
+	Hostname string `json:",omitempty"`
+}
+
+// Response is the exchange endpoint's reply.
+type Response struct {
+	AuthKey string
+}
+
+// These are vars for tests.
+var (
+	// metadataBase is the base URL of the metadata servers of all
+	// supported clouds.
+	metadataBase = "http://" + cloudenv.CommonNonRoutableMetadataIP
+
+	httpClient = http.DefaultClient
+)
+
+// timeout bounds fetching the identity and exchanging it.
+const timeout = 30 * time.Second
+
+// GetAuthKey returns an auth key obtained from c.Endpoint in exchange for
+// this VM's instance identity.
+func GetAuthKey(ctx context.Context, c *ipn.CloudAuthConfig, hostname string) (string, error) {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		// The endpoint returns a secret.
+		return "", fmt.Errorf("cloud auth: Endpoint %q is not an https URL", c.Endpoint)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	provider := cloudenv.Cloud(c.Provider)
+	if provider == "" {
+		provider = cloudenv.Get()
+		if provider == "" {
+			return "", errors.New("cloud auth: not running on a known cloud; set Provider")
+		}
+	}
+	req, err := fetchIdentity(ctx, provider, cmp.Or(c.Audience, c.Endpoint))
+	if err != nil {
+		return "", fmt.Errorf("cloud auth: fetching %s instance identity: %w", provider, err)
+	}
+	req.Hostname = hostname
+	key, err := exchange(ctx, c.Endpoint, req)
+	if err != nil {
+		return "", fmt.Errorf("cloud auth: %w", err)
+	}
+	return key, nil
+}
+
+// fetchIdentity returns the identity of the VM on provider, requesting
+// that it be bound to audience where the provider supports that.
+func fetchIdentity(ctx context.Context, provider cloudenv.Cloud, audience string) (*Request, error) {
+	switch provider {
+	case cloudenv.AWS:
+		return fetchAWS(ctx)
+	case cloudenv.GCP:
+		return fetchGCP(ctx, audience)
+	case cloudenv.Azure:
+		return fetchAzure(ctx)
+	}
+	return nil, fmt.Errorf("unsupported cloud %q", provider)
+}
+
+func fetchAWS(ctx context.Context) (*Request, error) {
+	if omit.AWS {
+		return nil, omit.Err
+	}
+	// IMDSv2: get a session token first.
+	token, err := get(ctx, "PUT", "/latest/api/token", "X-aws-ec2-metadata-token-ttl-seconds", "60")
+	if err != nil {
+		return nil, err
+	}
+	doc, err := get(ctx, "GET", "/latest/dynamic/instance-identity/document", "X-aws-ec2-metadata-token", token)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := get(ctx, "GET", "/latest/dynamic/instance-identity/pkcs7", "X-aws-ec2-metadata-token", token)
+	if err != nil {
+		return nil, err
+	}
+	return &Request{Provider: cloudenv.AWS, Document: doc, Signature: sig}, nil
+}
+
+func fetchGCP(ctx context.Context, audience string) (*Request, error) {
+	q := url.Values{"audience": {audience}, "format": {"full"}}
+	tok, err := get(ctx, "GET", "/computeMetadata/v1/instance/service-accounts/default/identity?"+q.Encode(), "Metadata-Flavor", "Google")
+	if err != nil {
+		return nil, err
+	}
+	return &Request{Provider: cloudenv.GCP, Document: tok}, nil
+}
+
+func fetchAzure(ctx context.Context) (*Request, error) {
+	// The nonce must be 10 digits.
+	nonce := fmt.Sprintf("%010d", time.Now().UnixNano()%1e10)
+	body, err := get(ctx, "GET", "/metadata/attested/document?api-version=2020-09-01&nonce="+nonce, "Metadata", "true")
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Encoding  string `json:"encoding"`
+		Signature string `json:"signature"`
+	}
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		return nil, fmt.Errorf("parsing attested document: %w", err)
+	}
+	if doc.Encoding != "pkcs7" || doc.Signature == "" {
+		return nil, fmt.Errorf("unexpected attested document encoding %q", doc.Encoding)
+	}
+	return &Request{Provider: cloudenv.Azure, Document: doc.Signature, Nonce: nonce}, nil
+}
+
+// get makes a metadata server request with the given header set, returning
+// the trimmed response body.
+func get(ctx context.Context, method, path, header, value string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, metadataBase+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(header, value)
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	all, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s %s: %v", method, path, res.Status)
+	}
+	return strings.TrimSpace(string(all)), nil
+}
+
+// exchange POSTs req to endpoint and returns the auth key in the response.
+func exchange(ctx context.Context, endpoint string, req *Request) (string, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	hreq, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	res, err := httpClient.Do(hreq)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	all, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("exchange endpoint: %v: %s", res.Status, bytes.TrimSpace(all))
+	}
+	var resp Response
+	if err := json.Unmarshal(all, &resp); err != nil {
+		return "", fmt.Errorf("parsing exchange endpoint response: %w", err)
+	}
+	if resp.AuthKey == "" {
+		return "", errors.New("exchange endpoint returned no auth key")
+	}
+	return resp.AuthKey, nil
+}
@@ -0,0 +1,110 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cloudauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tstest"
+	"tailscale.com/util/cloudenv"
+)
+
+func TestGetAuthKey(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /latest/api/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+			http.Error(w, "no ttl", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("tok\n"))
+	})
+	awsDoc := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-aws-ec2-metadata-token") != "tok" {
+				http.Error(w, "bad token", http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(body))
+		}
+	}
+	mux.HandleFunc("GET /latest/dynamic/instance-identity/document", awsDoc(`{"instanceId":"i-123"}`))
+	mux.HandleFunc("GET /latest/dynamic/instance-identity/pkcs7", awsDoc("SIG"))
+	mux.HandleFunc("GET /computeMetadata/v1/instance/service-accounts/default/identity", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "no flavor", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("jwt-for-" + r.FormValue("audience")))
+	})
+	mux.HandleFunc("GET /metadata/attested/document", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"encoding": "pkcs7", "signature": "AZSIG"})
+	})
+
+	var got Request
+	mux.HandleFunc("POST /exchange", func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(Response{AuthKey: "tskey-auth-" + string(got.Provider)})
+	})
+
+	ts := httptest.NewTLSServer(mux)
+	defer ts.Close()
+	tstest.Replace(t, &metadataBase, ts.URL)
+	tstest.Replace(t, &httpClient, ts.Client())
+	endpoint := ts.URL + "/exchange"
+
+	tests := []struct {
+		provider cloudenv.Cloud
+		audience string
+		want     Request
+	}{
+		{cloudenv.AWS, "", Request{Provider: cloudenv.AWS, Document: `{"instanceId":"i-123"}`, Signature: "SIG"}},
+		{cloudenv.GCP, "", Request{Provider: cloudenv.GCP, Document: "jwt-for-" + endpoint}},
+		{cloudenv.GCP, "aud", Request{Provider: cloudenv.GCP, Document: "jwt-for-aud"}},
+		{cloudenv.Azure, "", Request{Provider: cloudenv.Azure, Document: "AZSIG"}},
+	}
+	for _, tt := range tests {
+		got = Request{}
+		key, err := GetAuthKey(context.Background(), &ipn.CloudAuthConfig{
+			Provider: string(tt.provider),
+			Endpoint: endpoint,
+			Audience: tt.audience,
+		}, "host")
+		if err != nil {
+			t.Errorf("%s: %v", tt.provider, err)
+			continue
+		}
+		if want := "tskey-auth-" + string(tt.provider); key != want {
+			t.Errorf("%s: key = %q, want %q", tt.provider, key, want)
+		}
+		tt.want.Hostname = "host"
+		if tt.provider == cloudenv.Azure {
+			if len(got.Nonce) != 10 {
+				t.Errorf("azure nonce = %q, want 10 digits", got.Nonce)
+			}
+			tt.want.Nonce = got.Nonce
+		}
+		if got != tt.want {
+			t.Errorf("%s: request = %+v, want %+v", tt.provider, got, tt.want)
+		}
+	}
+
+	for _, c := range []ipn.CloudAuthConfig{
+		{Provider: "aws", Endpoint: "http://example.com/exchange"},
+		{Provider: "aws"},
+		{Provider: "digitalocean", Endpoint: endpoint},
+		{Provider: "aws", Endpoint: ts.URL + "/nope"},
+	} {
+		if _, err := GetAuthKey(context.Background(), &c, "host"); err == nil {
+			t.Errorf("%+v: got no error", c)
+		}
+	}
+}
@@ -5,6 +5,7 @@
 
 import (
 	"net/netip"
+	"reflect"
 
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/opt"
@@ -15,10 +16,12 @@
 type ConfigVAlpha struct {
 	Version string   // "alpha0" for now
 	Locked  opt.Bool `json:",omitempty"` // whether the config is locked from being changed by 'tailscale set'; it defaults to true
+	Strict  opt.Bool `json:",omitempty"` // whether logging out, switching profiles and editing Taildrive shares are also rejected, leaving the config file the only way to change tailscaled; implies Locked; defaults to false
 
-	ServerURL *string  `json:",omitempty"` // defaults to https://controlplane.tailscale.com
-	AuthKey   *string  `json:",omitempty"` // as needed if NeedsLogin. either key or path to a file (if prefixed with "file:")
-	Enabled   opt.Bool `json:",omitempty"` // wantRunning; empty string defaults to true
+	ServerURL *string          `json:",omitempty"` // defaults to https://controlplane.tailscale.com
+	AuthKey   *string          `json:",omitempty"` // as needed if NeedsLogin. either key or path to a file (if prefixed with "file:")
+	CloudAuth *CloudAuthConfig `json:",omitempty"` // obtain AuthKey at boot from the cloud instance identity; ignored if AuthKey is set
+	Enabled   opt.Bool         `json:",omitempty"` // wantRunning; empty string defaults to true
 
 	OperatorUser *string `json:",omitempty"` // local user name who is allowed to operate tailscaled without being root or using sudo
 	Hostname     *string `json:",omitempty"`
@@ -44,16 +47,83 @@ type ConfigVAlpha struct {
 	RunWebClient    opt.Bool         `json:",omitempty"`
 	ShieldsUp       opt.Bool         `json:",omitempty"`
 	AutoUpdate      *AutoUpdatePrefs `json:",omitempty"`
-	ServeConfigTemp *ServeConfig     `json:",omitempty"` // TODO(bradfitz,maisem): make separate stable type for this
+	ServeConfigTemp *ServeConfig     `json:",omitempty"` // replaces the serve config of every profile while set; TODO(bradfitz,maisem): make separate stable type for this
 
 	// StaticEndpoints are additional, user-defined endpoints that this node
 	// should advertise amongst its wireguard endpoints.
 	StaticEndpoints []netip.AddrPort `json:",omitempty"`
 
+	// Netstack tunes the userspace network stack, as used in
+	// userspace-networking mode and for subnet routing without a kernel
+	// tun device.
+	Netstack *NetstackConfig `json:",omitempty"`
+
+	// TLS configures how the certificates of the control server and DERP
+	// servers are verified, beyond the system roots.
+	TLS *TLSTrustConfig `json:",omitempty"`
+
+	// Proxy configures authenticating to the HTTP proxy, if any, through
+	// which the control server, DERP servers and log server are reached.
+	Proxy *ProxyConfig `json:",omitempty"`
+
 	// TODO(bradfitz,maisem): future something like:
 	// Profile map[string]*Config // keyed by alice@gmail.com, corp.com (TailnetSID)
 }
 
+// CloudAuthConfig configures obtaining an auth key at boot, when the node
+// needs to log in, by presenting the cloud VM's signed instance identity
+// document to an exchange endpoint. See package tailscale.com/ipn/cloudauth.
+type CloudAuthConfig struct {
+	Provider string `json:",omitempty"` // "aws", "gcp" or "azure"; if empty, it's detected
+	Endpoint string // URL of the exchange endpoint
+	Audience string `json:",omitempty"` // audience of GCP identity tokens; defaults to Endpoint
+}
+
+// NetstackConfig is the tuning of the userspace network stack.
+type NetstackConfig struct {
+	// Profile is the set of defaults to start from: "throughput" for
+	// proxy-heavy deployments, "low-memory" for small machines, or empty
+	// for the usual defaults. The fields below override it.
+	Profile string `json:",omitempty"`
+
+	TCPReceiveBufferMax int      `json:",omitempty"` // in bytes
+	TCPSendBufferMax    int      `json:",omitempty"` // in bytes
+	SACK                opt.Bool `json:",omitempty"` // TCP selective acknowledgements
+	CongestionControl   string   `json:",omitempty"` // "cubic" or "reno"
+
+	// MaxInFlightConnections limits the number of forwarded TCP
+	// connections being established at once, in total and per peer.
+	MaxInFlightConnections          int `json:",omitempty"`
+	MaxInFlightConnectionsPerClient int `json:",omitempty"`
+}
+
+// TLSTrustConfig is the trust of the control server's and DERP servers'
+// certificates, for private CAs (as used with Headscale) and TLS-intercepting
+// proxies, and to pin the servers' keys.
+type TLSTrustConfig struct {
+	// CABundle is the path of a PEM file of CA certificates trusted, in
+	// addition to the system roots, for the control server and DERP
+	// servers.
+	CABundle string `json:",omitempty"`
+
+	// ControlPins and DERPPins are the public keys, one of which must be
+	// in the certificate chain of the control server, or of every DERP
+	// server, respectively. Each is of the form "sha256/<base64>", the
+	// hash of a certificate's SubjectPublicKeyInfo. The chain must still
+	// be trusted.
+	ControlPins []string `json:",omitempty"`
+	DERPPins    []string `json:",omitempty"`
+}
+
+// ProxyConfig is the credentials to authenticate to an HTTP proxy with,
+// using Basic or NTLM authentication, as the proxy requires. They're not
+// needed for proxies that accept the current user's Kerberos credentials,
+// where supported, or whose URL includes credentials.
+type ProxyConfig struct {
+	User     string // user name, of the form DOMAIN\user for NTLM domain accounts
+	Password string `json:",omitempty"` // either the password or the path of a file containing it (if prefixed with "file:")
+}
+
 func (c *ConfigVAlpha) ToPrefs() (MaskedPrefs, error) {
 	var mp MaskedPrefs
 	if c == nil {
@@ -66,7 +136,7 @@ func (c *ConfigVAlpha) ToPrefs() (MaskedPrefs, error) {
 		mp.ControlURL = *c.ServerURL
 		mp.ControlURLSet = true
 	}
-	if c.AuthKey != nil && *c.AuthKey != "" {
+	if c.AuthKey != nil && *c.AuthKey != "" || c.CloudAuth != nil {
 		mp.LoggedOut = false
 		mp.LoggedOutSet = true
 	}
@@ -87,14 +157,14 @@ func (c *ConfigVAlpha) ToPrefs() (MaskedPrefs, error) {
 		mp.RouteAllSet = true
 	}
 	if c.ExitNode != nil {
-		ip, err := netip.ParseAddr(*c.ExitNode)
-		if err == nil {
+		// Set both, so that the one not given is cleared.
+		if ip, err := netip.ParseAddr(*c.ExitNode); err == nil {
 			mp.ExitNodeIP = ip
-			mp.ExitNodeIPSet = true
 		} else {
 			mp.ExitNodeID = tailcfg.StableNodeID(*c.ExitNode)
-			mp.ExitNodeIDSet = true
 		}
+		mp.ExitNodeIPSet = true
+		mp.ExitNodeIDSet = true
 	}
 	if c.AllowLANWhileUsingExitNode != "" {
 		mp.ExitNodeAllowLANAccess = c.AllowLANWhileUsingExitNode.EqualBool(true)
@@ -106,7 +176,7 @@ func (c *ConfigVAlpha) ToPrefs() (MaskedPrefs, error) {
 	}
 	if c.DisableSNAT != "" {
 		mp.NoSNAT = c.DisableSNAT.EqualBool(true)
-		mp.NoSNAT = true
+		mp.NoSNATSet = true
 	}
 	if c.NoStatefulFiltering != "" {
 		mp.NoStatefulFiltering = c.NoStatefulFiltering
@@ -151,3 +221,39 @@ func (c *ConfigVAlpha) ToPrefs() (MaskedPrefs, error) {
 	}
 	return mp, nil
 }
+
+// ToPrefsOnReload is like ToPrefs, for when c replaces prev as the config
+// of a running tailscaled: the prefs that prev set but c doesn't are reset
+// to their defaults, so removing a setting from the config file undoes it,
+// as restarting tailscaled with the new file would.
+func (c *ConfigVAlpha) ToPrefsOnReload(prev *ConfigVAlpha) (MaskedPrefs, error) {
+	mp, err := c.ToPrefs()
+	if err != nil {
+		return mp, err
+	}
+	pmp, err := prev.ToPrefs()
+	if err != nil {
+		// prev was applied, so it can't fail; but if it does,
+		// there's nothing to undo.
+		return mp, nil
+	}
+	resetRemovedPrefs(reflect.ValueOf(&mp.Prefs).Elem(), reflect.ValueOf(NewPrefs()).Elem(),
+		maskFields(reflect.ValueOf(&mp).Elem()), maskFields(reflect.ValueOf(&pmp).Elem()))
+	return mp, nil
+}
+
+// resetRemovedPrefs sets the fields of dst masked in prev but not in cur to
+// their values in def, and masks them in cur.
+func resetRemovedPrefs(dst, def reflect.Value, cur, prev map[string]reflect.Value) {
+	for n, m := range cur {
+		switch m.Kind() {
+		case reflect.Bool:
+			if !m.Bool() && prev[n].Bool() {
+				dst.FieldByName(n).Set(def.FieldByName(n))
+				m.SetBool(true)
+			}
+		case reflect.Struct:
+			resetRemovedPrefs(dst.FieldByName(n), def.FieldByName(n), maskFields(m), maskFields(prev[n]))
+		}
+	}
+}
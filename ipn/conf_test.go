@@ -0,0 +1,61 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"net/netip"
+	"testing"
+
+	"tailscale.com/types/ptr"
+)
+
+func TestConfigToPrefsOnReload(t *testing.T) {
+	prev := &ConfigVAlpha{
+		Hostname:        ptr.To("foo"),
+		AcceptDNS:       "false",
+		ExitNode:        ptr.To("100.64.0.1"),
+		AdvertiseRoutes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		DisableSNAT:     "true",
+		AutoUpdate:      &AutoUpdatePrefs{Check: false},
+	}
+	cur := &ConfigVAlpha{
+		Hostname: ptr.To("bar"),
+		ExitNode: ptr.To("nExample"),
+	}
+
+	p := NewPrefs()
+	mp, err := prev.ToPrefs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.ApplyEdits(&mp)
+	if !p.NoSNAT || p.CorpDNS || p.AutoUpdate.Check {
+		t.Fatalf("prev config not applied: %v", p.Pretty())
+	}
+
+	mp, err = cur.ToPrefsOnReload(prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.ApplyEdits(&mp)
+	if p.Hostname != "bar" {
+		t.Errorf("Hostname = %q; want bar", p.Hostname)
+	}
+	if p.ExitNodeID != "nExample" || p.ExitNodeIP.IsValid() {
+		t.Errorf("exit node = %q, %v; want nExample and no IP", p.ExitNodeID, p.ExitNodeIP)
+	}
+	// Settings removed from the config revert to their defaults.
+	if !p.CorpDNS {
+		t.Error("CorpDNS not reset")
+	}
+	if len(p.AdvertiseRoutes) != 0 {
+		t.Errorf("AdvertiseRoutes = %v; want none", p.AdvertiseRoutes)
+	}
+	if p.NoSNAT {
+		t.Error("NoSNAT not reset")
+	}
+	if !p.AutoUpdate.Check {
+		t.Error("AutoUpdate.Check not reset")
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/views"
+)
+
+// ExitNodeExpression is an expression selecting the exit nodes that may be
+// chosen automatically, rather than naming a single exit node. Of the exit
+// nodes it matches, the one with the lowest latency is used, and the choice
+// is re-evaluated when the netmap or the network changes.
+//
+// It is a comma-separated list of terms, all of which a node must match:
+//
+//   - "any": any exit node
+//   - "online": the node is online
+//   - "tag:<name>": the node has the tag
+//   - "region:<code>": the node's home DERP region has the region code
+//     (e.g. "nyc"), or, for nodes without one such as Mullvad's, its
+//     location has the country or city code (e.g. "se" or "got")
+//
+// Multiple "tag:" or "region:" terms match nodes with any of those tags or
+// in any of those regions.
+type ExitNodeExpression string
+
+// AnyExitNode is the ExitNodeExpression matching any exit node.
+const AnyExitNode ExitNodeExpression = "any"
+
+// autoExitNodePrefix is the prefix of an exit node ID or CLI argument that
+// is an ExitNodeExpression, as in "auto:any".
+const autoExitNodePrefix = "auto:"
+
+// ParseAutoExitNodeString parses s as an exit node of the form
+// "auto:<expression>", as accepted in place of an exit node ID by the
+// ExitNodeID policy setting and the CLI. It reports whether s is of that form
+// with a valid expression.
+func ParseAutoExitNodeString(s string) (_ ExitNodeExpression, ok bool) {
+	expr, ok := strings.CutPrefix(s, autoExitNodePrefix)
+	if !ok {
+		return "", false
+	}
+	e := ExitNodeExpression(expr)
+	if _, err := e.Parse(); err != nil {
+		return "", false
+	}
+	return e, true
+}
+
+// AutoString returns e in the "auto:<expression>" form accepted by
+// [ParseAutoExitNodeString].
+func (e ExitNodeExpression) AutoString() string {
+	return autoExitNodePrefix + string(e)
+}
+
+// ExitNodeFilter is a parsed ExitNodeExpression.
+// The zero value matches any exit node.
+type ExitNodeFilter struct {
+	Online  bool     // only match online nodes
+	Tags    []string // if non-empty, only match nodes with one of these tags
+	Regions []string // if non-empty, only match nodes in one of these regions
+}
+
+// Parse parses e into an ExitNodeFilter.
+func (e ExitNodeExpression) Parse() (ExitNodeFilter, error) {
+	var f ExitNodeFilter
+	if e == "" {
+		return f, errors.New("empty exit node expression")
+	}
+	for _, term := range strings.Split(string(e), ",") {
+		term = strings.TrimSpace(term)
+		switch {
+		case term == string(AnyExitNode):
+		case term == "online":
+			f.Online = true
+		case strings.HasPrefix(term, "tag:") && len(term) > len("tag:"):
+			f.Tags = append(f.Tags, term)
+		case strings.HasPrefix(term, "region:") && len(term) > len("region:"):
+			f.Regions = append(f.Regions, strings.ToLower(strings.TrimPrefix(term, "region:")))
+		default:
+			return f, fmt.Errorf("invalid exit node expression %q: unknown term %q", e, term)
+		}
+	}
+	return f, nil
+}
+
+// Match reports whether the exit node n matches f. dm is the DERP map used
+// to look up the region code of n's home DERP region; it may be nil.
+func (f ExitNodeFilter) Match(n tailcfg.NodeView, dm *tailcfg.DERPMap) bool {
+	if f.Online {
+		if online, ok := n.Online().GetOk(); !ok || !online {
+			return false
+		}
+	}
+	if len(f.Tags) > 0 && !slices.ContainsFunc(f.Tags, func(t string) bool { return views.SliceContains(n.Tags(), t) }) {
+		return false
+	}
+	if len(f.Regions) > 0 && !slices.ContainsFunc(f.Regions, func(r string) bool { return nodeInRegion(n, dm, r) }) {
+		return false
+	}
+	return true
+}
+
+// nodeInRegion reports whether n is in the region with the lowercase code
+// region, as described on ExitNodeExpression.
+func nodeInRegion(n tailcfg.NodeView, dm *tailcfg.DERPMap, region string) bool {
+	if derp := n.HomeDERP(); derp != 0 {
+		if dm == nil {
+			return false
+		}
+		r, ok := dm.Regions[derp]
+		return ok && r != nil && strings.EqualFold(r.RegionCode, region)
+	}
+	hi := n.Hostinfo()
+	if !hi.Valid() {
+		return false
+	}
+	loc := hi.Location()
+	if !loc.Valid() {
+		return false
+	}
+	return strings.EqualFold(loc.CountryCode(), region) || strings.EqualFold(loc.CityCode(), region)
+}
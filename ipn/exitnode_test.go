@@ -0,0 +1,78 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/ptr"
+)
+
+func TestParseAutoExitNodeString(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   ExitNodeExpression
+		wantOK bool
+	}{
+		{"auto:any", AnyExitNode, true},
+		{"auto:online,tag:exit,region:nyc", "online,tag:exit,region:nyc", true},
+		{"auto:", "", false},
+		{"auto", "", false},
+		{"auto:foo", "", false},
+		{"auto:tag:", "", false},
+		{"nABCDEF", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseAutoExitNodeString(tt.in)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("ParseAutoExitNodeString(%q) = %q, %v; want %q, %v", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestExitNodeFilterMatch(t *testing.T) {
+	dm := &tailcfg.DERPMap{Regions: map[int]*tailcfg.DERPRegion{
+		1: {RegionID: 1, RegionCode: "nyc"},
+		2: {RegionID: 2, RegionCode: "fra"},
+	}}
+	nyc := (&tailcfg.Node{
+		HomeDERP: 1,
+		Tags:     []string{"tag:exit"},
+		Online:   ptr.To(true),
+	}).View()
+	fra := (&tailcfg.Node{
+		HomeDERP: 2,
+		Tags:     []string{"tag:other"},
+		Online:   ptr.To(false),
+	}).View()
+	mullvad := (&tailcfg.Node{
+		Hostinfo: (&tailcfg.Hostinfo{Location: &tailcfg.Location{CountryCode: "SE", CityCode: "GOT"}}).View(),
+	}).View()
+
+	tests := []struct {
+		expr ExitNodeExpression
+		want []bool // nyc, fra, mullvad
+	}{
+		{AnyExitNode, []bool{true, true, true}},
+		{"online", []bool{true, false, false}},
+		{"tag:exit", []bool{true, false, false}},
+		{"tag:exit,tag:other", []bool{true, true, false}},
+		{"region:FRA", []bool{false, true, false}},
+		{"region:se", []bool{false, false, true}},
+		{"region:got,region:nyc", []bool{true, false, true}},
+		{"online, region:fra", []bool{false, false, false}},
+	}
+	for _, tt := range tests {
+		f, err := tt.expr.Parse()
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.expr, err)
+		}
+		for i, n := range []tailcfg.NodeView{nyc, fra, mullvad} {
+			if got := f.Match(n, dm); got != tt.want[i] {
+				t.Errorf("%q: Match(node %d) = %v, want %v", tt.expr, i, got, tt.want[i])
+			}
+		}
+	}
+}
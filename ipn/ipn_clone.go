@@ -71,6 +71,7 @@ func (src *Prefs) Clone() *Prefs {
 	RouteAll               bool
 	ExitNodeID             tailcfg.StableNodeID
 	ExitNodeIP             netip.Addr
+	AutoExitNode           ExitNodeExpression
 	InternalExitNodePrior  tailcfg.StableNodeID
 	ExitNodeAllowLANAccess bool
 	CorpDNS                bool
@@ -93,7 +94,11 @@ func (src *Prefs) Clone() *Prefs {
 	ProfileName            string
 	AutoUpdate             AutoUpdatePrefs
 	AppConnector           AppConnectorPrefs
+	PortMapping            PortMappingPrefs
 	PostureChecking        bool
+	RemoteDiagnostics      string
+	AddrConflictMitigation string
+	SubnetRouteMetric      uint32
 	NetfilterKind          string
 	DriveShares            []*drive.Share
 	AllowSingleHosts       marshalAsTrueInJSON
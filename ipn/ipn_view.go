@@ -135,6 +135,7 @@ func (v PrefsView) ControlURL() string                          { return v.ж.Co
 func (v PrefsView) RouteAll() bool                              { return v.ж.RouteAll }
 func (v PrefsView) ExitNodeID() tailcfg.StableNodeID            { return v.ж.ExitNodeID }
 func (v PrefsView) ExitNodeIP() netip.Addr                      { return v.ж.ExitNodeIP }
+func (v PrefsView) AutoExitNode() ExitNodeExpression            { return v.ж.AutoExitNode }
 func (v PrefsView) InternalExitNodePrior() tailcfg.StableNodeID { return v.ж.InternalExitNodePrior }
 func (v PrefsView) ExitNodeAllowLANAccess() bool                { return v.ж.ExitNodeAllowLANAccess }
 func (v PrefsView) CorpDNS() bool                               { return v.ж.CorpDNS }
@@ -161,7 +162,11 @@ func (v PrefsView) OperatorUser() string                  { return v.ж.Operator
 func (v PrefsView) ProfileName() string                   { return v.ж.ProfileName }
 func (v PrefsView) AutoUpdate() AutoUpdatePrefs           { return v.ж.AutoUpdate }
 func (v PrefsView) AppConnector() AppConnectorPrefs       { return v.ж.AppConnector }
+func (v PrefsView) PortMapping() PortMappingPrefs         { return v.ж.PortMapping }
 func (v PrefsView) PostureChecking() bool                 { return v.ж.PostureChecking }
+func (v PrefsView) RemoteDiagnostics() string             { return v.ж.RemoteDiagnostics }
+func (v PrefsView) AddrConflictMitigation() string        { return v.ж.AddrConflictMitigation }
+func (v PrefsView) SubnetRouteMetric() uint32             { return v.ж.SubnetRouteMetric }
 func (v PrefsView) NetfilterKind() string                 { return v.ж.NetfilterKind }
 func (v PrefsView) DriveShares() views.SliceView[*drive.Share, drive.ShareView] {
 	return views.SliceOfViews[*drive.Share, drive.ShareView](v.ж.DriveShares)
@@ -175,6 +180,7 @@ func (v PrefsView) Persist() persist.PersistView          { return v.ж.Persist.
 	RouteAll               bool
 	ExitNodeID             tailcfg.StableNodeID
 	ExitNodeIP             netip.Addr
+	AutoExitNode           ExitNodeExpression
 	InternalExitNodePrior  tailcfg.StableNodeID
 	ExitNodeAllowLANAccess bool
 	CorpDNS                bool
@@ -197,7 +203,11 @@ func (v PrefsView) Persist() persist.PersistView          { return v.ж.Persist.
 	ProfileName            string
 	AutoUpdate             AutoUpdatePrefs
 	AppConnector           AppConnectorPrefs
+	PortMapping            PortMappingPrefs
 	PostureChecking        bool
+	RemoteDiagnostics      string
+	AddrConflictMitigation string
+	SubnetRouteMetric      uint32
 	NetfilterKind          string
 	DriveShares            []*drive.Share
 	AllowSingleHosts       marshalAsTrueInJSON
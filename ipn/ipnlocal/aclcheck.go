@@ -0,0 +1,151 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/ipproto"
+	"tailscale.com/types/views"
+	"tailscale.com/wgengine/filter"
+)
+
+// CheckACL reports whether the node's current packet filter permits traffic
+// from src to dst using proto, and which of the netmap's rules permits it.
+// For TCP traffic to port 22 while running Tailscale SSH, it also reports
+// which SSH rule applies to src.
+func (b *LocalBackend) CheckACL(src netip.Addr, dst netip.AddrPort, proto ipproto.Proto) (*apitype.ACLCheckResponse, error) {
+	f := b.e.GetFilter()
+	if f == nil {
+		return nil, errors.New("no packet filter installed")
+	}
+	nm := b.NetMap()
+	if nm == nil {
+		return nil, errors.New("no netmap")
+	}
+	r, why := f.Explain(src, dst.Addr(), dst.Port(), proto)
+	res := &apitype.ACLCheckResponse{
+		Allowed:   r == filter.Accept,
+		Reason:    why,
+		ShieldsUp: f.ShieldsUp(),
+		RuleIndex: -1,
+	}
+	if !res.ShieldsUp {
+		if i := filter.MatchIndex(nm.PacketFilter, src, dst.Addr(), dst.Port(), proto, b.srcIPHasCapForFilter); i >= 0 {
+			res.RuleIndex = i
+			res.Match = nm.PacketFilter[i].String()
+			if nm.PacketFilterRules.Len() == len(nm.PacketFilter) {
+				rule := nm.PacketFilterRules.At(i)
+				res.Rule = &rule
+			}
+		}
+	}
+	if proto == ipproto.TCP && dst.Port() == 22 && b.ShouldRunSSH() {
+		res.SSH = b.checkSSHPolicy(nm.SSHPolicy, src)
+	}
+	return res, nil
+}
+
+// checkSSHPolicy returns the first unexpired rule of pol whose principals
+// match the node or user at src, the way the SSH server matches them.
+func (b *LocalBackend) checkSSHPolicy(pol *tailcfg.SSHPolicy, src netip.Addr) *apitype.ACLCheckSSH {
+	res := &apitype.ACLCheckSSH{RuleIndex: -1}
+	if pol == nil {
+		return res
+	}
+	n, u, ok := b.WhoIs("tcp", netip.AddrPortFrom(src, 0))
+	matches := func(p *tailcfg.SSHPrincipal) bool {
+		switch {
+		case p == nil:
+			return false
+		case p.Any:
+			return true
+		case !p.Node.IsZero() && ok && p.Node == n.StableID():
+			return true
+		case p.NodeIP != "":
+			if ip, _ := netip.ParseAddr(p.NodeIP); ip == src {
+				return true
+			}
+		}
+		return p.UserLogin != "" && ok && p.UserLogin == u.LoginName
+	}
+	now := b.clock.Now()
+	for i, r := range pol.Rules {
+		if r == nil || r.Action == nil || (r.RuleExpires != nil && r.RuleExpires.Before(now)) {
+			continue
+		}
+		if slices.ContainsFunc(r.Principals, matches) {
+			res.RuleIndex = i
+			res.Rule = r
+			break
+		}
+	}
+	return res
+}
+
+// SetFilterStats sets whether the packet filter counts the flows each rule
+// accepts and logs the packets it drops, for FilterStats. The counters are
+// discarded when disabled.
+func (b *LocalBackend) SetFilterStats(enable bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.filterStats = enable
+	if f := b.e.GetFilter(); f != nil {
+		if enable {
+			f.EnableStats()
+		} else {
+			f.DisableStats()
+		}
+	}
+}
+
+// FilterStats returns the packet filter's counters, as enabled by
+// SetFilterStats.
+func (b *LocalBackend) FilterStats() (*apitype.FilterStats, error) {
+	f := b.e.GetFilter()
+	if f == nil {
+		return nil, errors.New("no packet filter installed")
+	}
+	st, ok := f.Stats()
+	if !ok {
+		return &apitype.FilterStats{}, nil
+	}
+	res := &apitype.FilterStats{
+		Enabled:        true,
+		DropsByReason:  st.DropsByReason,
+		DropsNotLogged: st.DropsNotLogged,
+	}
+	// The filter's rules are the netmap's, unless they were replaced
+	// since, or the filter didn't use them at all.
+	var rules views.Slice[tailcfg.FilterRule]
+	if nm := b.NetMap(); nm != nil && nm.PacketFilterRules.Len() == len(st.Rules) && len(nm.PacketFilter) == len(st.Rules) {
+		rules = nm.PacketFilterRules
+	}
+	for i, r := range st.Rules {
+		rs := apitype.FilterRuleStats{
+			RuleIndex: i,
+			Match:     r.Match.String(),
+			Packets:   r.Packets,
+		}
+		if rules.Len() > 0 {
+			rule := rules.At(i)
+			rs.Rule = &rule
+		}
+		res.Rules = append(res.Rules, rs)
+	}
+	for _, d := range st.Drops {
+		res.Drops = append(res.Drops, apitype.FilterDrop{
+			Time:   d.Time,
+			Src:    d.Src,
+			Dst:    d.Dst,
+			Proto:  d.Proto.String(),
+			Reason: d.Reason,
+		})
+	}
+	return res, nil
+}
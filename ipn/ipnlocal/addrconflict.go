@@ -0,0 +1,88 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"fmt"
+	"maps"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+	"tailscale.com/net/netmon"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/wgengine/wgcfg"
+)
+
+// addrConflictWarnable is a Warnable set while a local network uses
+// addresses in Tailscale's CGNAT or IPv6 ULA ranges.
+var addrConflictWarnable = health.Register(&health.Warnable{
+	Code:     "addr-range-conflict",
+	Title:    "Local network uses Tailscale addresses",
+	Severity: health.SeverityMedium,
+	Text: func(args health.Args) string {
+		return args[health.ArgError]
+	},
+})
+
+// updateAddrConflictWarning updates the warning about the local networks of
+// state using addresses in Tailscale's ranges, mentioning how p works around
+// it, if at all.
+func updateAddrConflictWarning(p ipn.PrefsView, state *netmon.State, healthTracker *health.Tracker) {
+	conflicts := state.TailscaleRangeConflicts()
+	if len(conflicts) == 0 {
+		healthTracker.SetHealthy(addrConflictWarnable)
+		return
+	}
+	var ifs []string
+	for _, name := range slices.Sorted(maps.Keys(conflicts)) {
+		ifs = append(ifs, fmt.Sprintf("%s: %v", name, conflicts[name]))
+	}
+	msg := fmt.Sprintf("Your local network uses addresses in Tailscale's ranges (%s), so traffic to some local or Tailscale addresses may be sent to the wrong network.", strings.Join(ifs, "; "))
+	switch p.AddrConflictMitigation() {
+	case ipn.AddrConflictMitigationRoutes:
+		msg += " Only peers' addresses are routed over Tailscale to work around it."
+	case ipn.AddrConflictMitigationSNAT:
+		msg += " Only peers' addresses are routed over Tailscale, and traffic forwarded from the tailnet is SNATed, to work around it."
+	default:
+		msg += " Run 'tailscale set --addr-conflict-mitigation=routes' to route only peers' addresses over Tailscale."
+	}
+	healthTracker.SetUnhealthy(addrConflictWarnable, health.Args{health.ArgError: msg})
+}
+
+// addrConflictsChanged reports whether the local networks using addresses in
+// Tailscale's ranges differ between the two states.
+func addrConflictsChanged(old, new *netmon.State) bool {
+	return !maps.EqualFunc(old.TailscaleRangeConflicts(), new.TailscaleRangeConflicts(), slices.Equal[[]netip.Prefix])
+}
+
+// narrowTailscaleRoutes returns routes with any route to the whole of
+// Tailscale's CGNAT or IPv6 ULA range replaced by routes to the individual
+// addresses of the peers in it, so that the rest of the range stays
+// reachable on a local network that also uses it.
+func narrowTailscaleRoutes(routes []netip.Prefix, peers []wgcfg.Peer) []netip.Prefix {
+	cgnat, ula := tsaddr.CGNATRange(), tsaddr.TailscaleULARange()
+	hasCGNAT, hasULA := slices.Contains(routes, cgnat), slices.Contains(routes, ula)
+	if !hasCGNAT && !hasULA {
+		return routes
+	}
+	ret := slices.DeleteFunc(slices.Clone(routes), func(p netip.Prefix) bool {
+		return p == cgnat || p == ula
+	})
+	for _, peer := range peers {
+		for _, aip := range peer.AllowedIPs {
+			aip = unmapIPPrefix(aip)
+			if !aip.IsSingleIP() {
+				continue
+			}
+			if (hasCGNAT && cgnat.Contains(aip.Addr())) || (hasULA && ula.Contains(aip.Addr())) {
+				ret = append(ret, aip)
+			}
+		}
+	}
+	tsaddr.SortPrefixes(ret)
+	return slices.Compact(ret)
+}
@@ -0,0 +1,88 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+	"tailscale.com/net/netmon"
+	"tailscale.com/wgengine/wgcfg"
+)
+
+func TestNarrowTailscaleRoutes(t *testing.T) {
+	pp := netip.MustParsePrefix
+	peers := []wgcfg.Peer{
+		{AllowedIPs: []netip.Prefix{pp("100.64.0.2/32"), pp("fd7a:115c:a1e0::2/128"), pp("10.0.0.0/8")}},
+		{AllowedIPs: []netip.Prefix{pp("100.64.0.1/32"), pp("fd7a:115c:a1e0::1/128")}},
+	}
+	for _, tt := range []struct {
+		name   string
+		routes []netip.Prefix
+		want   []netip.Prefix
+	}{
+		{
+			name:   "already-narrow",
+			routes: []netip.Prefix{pp("10.0.0.0/8"), pp("100.64.0.1/32"), pp("100.64.0.2/32")},
+			want:   []netip.Prefix{pp("10.0.0.0/8"), pp("100.64.0.1/32"), pp("100.64.0.2/32")},
+		},
+		{
+			name:   "whole-ranges",
+			routes: []netip.Prefix{pp("10.0.0.0/8"), pp("100.64.0.0/10"), pp("fd7a:115c:a1e0::/48")},
+			want: []netip.Prefix{
+				pp("10.0.0.0/8"),
+				pp("100.64.0.1/32"),
+				pp("100.64.0.2/32"),
+				pp("fd7a:115c:a1e0::1/128"),
+				pp("fd7a:115c:a1e0::2/128"),
+			},
+		},
+		{
+			name:   "ula-only",
+			routes: []netip.Prefix{pp("100.64.0.1/32"), pp("100.64.0.2/32"), pp("fd7a:115c:a1e0::/48")},
+			want: []netip.Prefix{
+				pp("100.64.0.1/32"),
+				pp("100.64.0.2/32"),
+				pp("fd7a:115c:a1e0::1/128"),
+				pp("fd7a:115c:a1e0::2/128"),
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := narrowTailscaleRoutes(tt.routes, peers); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateAddrConflictWarning(t *testing.T) {
+	up := netmon.Interface{Interface: &net.Interface{Flags: net.FlagUp}}
+	conflicting := &netmon.State{
+		Interface:    map[string]netmon.Interface{"eth0": up},
+		InterfaceIPs: map[string][]netip.Prefix{"eth0": {netip.MustParsePrefix("100.72.0.5/16")}},
+	}
+	ht := new(health.Tracker)
+	warning := func() string {
+		return ht.CurrentState().Warnings[addrConflictWarnable.Code].Text
+	}
+
+	updateAddrConflictWarning((&ipn.Prefs{}).View(), conflicting, ht)
+	if got := warning(); !strings.Contains(got, "eth0: [100.72.0.5/16]") || !strings.Contains(got, "--addr-conflict-mitigation") {
+		t.Errorf("warning without mitigation = %q", got)
+	}
+	updateAddrConflictWarning((&ipn.Prefs{AddrConflictMitigation: ipn.AddrConflictMitigationSNAT}).View(), conflicting, ht)
+	if got := warning(); !strings.Contains(got, "SNAT") {
+		t.Errorf("warning with SNAT mitigation = %q", got)
+	}
+	updateAddrConflictWarning((&ipn.Prefs{}).View(), &netmon.State{}, ht)
+	if got := warning(); got != "" {
+		t.Errorf("warning without conflicts = %q; want none", got)
+	}
+}
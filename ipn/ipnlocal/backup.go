@@ -0,0 +1,169 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"tailscale.com/envknob"
+	"tailscale.com/ipn"
+	"tailscale.com/types/key"
+)
+
+// CreateBackup returns a backup of the node's configuration: the metadata,
+// prefs (including Taildrive shares) and serve config of each profile
+// accessible to the current user, and the node's TLS certs.
+//
+// Unless includeKeys, the machine key and the profiles' node keys are left
+// out, so that the backup can't be used to impersonate the node, and a
+// node it's restored to must log in again.
+func (b *LocalBackend) CreateBackup(includeKeys bool) (*ipn.Backup, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bk := &ipn.Backup{
+		Version:        ipn.BackupVersion,
+		Created:        b.clock.Now(),
+		CurrentProfile: b.pm.CurrentProfile().ID(),
+		IncludesKeys:   includeKeys,
+	}
+	for _, lp := range b.pm.Profiles() {
+		prefs, err := b.pm.ProfilePrefs(lp.ID())
+		if err != nil {
+			return nil, fmt.Errorf("reading prefs of profile %q: %w", lp.ID(), err)
+		}
+		bp := ipn.BackupProfile{
+			Profile: lp.AsStruct(),
+			Prefs:   prefs.AsStruct(),
+		}
+		if !includeKeys {
+			bp.Prefs.Persist = nil
+		}
+		confj, err := b.store.ReadState(ipn.ServeConfigKey(lp.ID()))
+		if err != nil && !errors.Is(err, ipn.ErrStateNotExist) {
+			return nil, fmt.Errorf("reading serve config of profile %q: %w", lp.ID(), err)
+		}
+		if len(confj) > 0 {
+			bp.ServeConfig = new(ipn.ServeConfig)
+			if err := json.Unmarshal(confj, bp.ServeConfig); err != nil {
+				return nil, fmt.Errorf("decoding serve config of profile %q: %w", lp.ID(), err)
+			}
+		}
+		bk.Profiles = append(bk.Profiles, bp)
+	}
+
+	if b.netMap != nil && len(b.netMap.DNS.CertDomains) > 0 {
+		if err := b.backupCerts(bk, b.netMap.DNS.CertDomains); err != nil {
+			return nil, fmt.Errorf("backing up certs: %w", err)
+		}
+	}
+
+	if includeKeys {
+		mk, err := b.store.ReadState(ipn.MachineKeyStateKey)
+		if err != nil && !errors.Is(err, ipn.ErrStateNotExist) {
+			return nil, fmt.Errorf("reading machine key: %w", err)
+		}
+		bk.MachineKey = mk
+	}
+	return bk, nil
+}
+
+// RestoreBackup restores the configuration in bk, as returned by
+// [LocalBackend.CreateBackup]. Its profiles are added, replacing any known
+// profiles with the same IDs, and the backed up current profile becomes the
+// current profile. If bk has no keys, profiles it replaces keep theirs.
+func (b *LocalBackend) RestoreBackup(bk *ipn.Backup) error {
+	if bk.Version > ipn.BackupVersion {
+		return fmt.Errorf("unsupported backup version %d", bk.Version)
+	}
+	var mk key.MachinePrivate
+	if len(bk.MachineKey) > 0 {
+		if !bk.IncludesKeys {
+			return errors.New("invalid backup: machine key in backup without keys")
+		}
+		if err := mk.UnmarshalText(bk.MachineKey); err != nil || mk.IsZero() {
+			return fmt.Errorf("invalid machine key in backup: %v", err)
+		}
+	}
+
+	unlock := b.lockAndGetUnlock()
+	defer unlock()
+
+	// Check all the profiles before restoring any of them, so that an
+	// invalid one doesn't leave a partial restore behind.
+	prefs := make([]*ipn.Prefs, len(bk.Profiles))
+	for i, bp := range bk.Profiles {
+		if bp.Profile == nil || bp.Prefs == nil {
+			return errors.New("invalid backup: profile without metadata or prefs")
+		}
+		prefs[i] = bp.Prefs.Clone()
+		if !bk.IncludesKeys {
+			// Without keys, a profile that's already here stays logged
+			// in with its own node key.
+			prefs[i].Persist = nil
+			if old, err := b.pm.ProfilePrefs(bp.Profile.ID); err == nil && old.Persist().Valid() {
+				prefs[i].Persist = old.Persist().AsStruct()
+			}
+		}
+		if err := b.checkRestoredPrefsLocked(bp.Profile.ID, prefs[i]); err != nil {
+			return fmt.Errorf("invalid prefs for profile %q: %w", bp.Profile.ID, err)
+		}
+	}
+	for i, bp := range bk.Profiles {
+		if err := b.pm.restoreProfile(bp.Profile.Clone(), prefs[i].View()); err != nil {
+			return fmt.Errorf("restoring profile %q: %w", bp.Profile.ID, err)
+		}
+		if bp.ServeConfig != nil {
+			confj, err := json.Marshal(bp.ServeConfig)
+			if err != nil {
+				return err
+			}
+			if err := b.store.WriteState(ipn.ServeConfigKey(bp.Profile.ID), confj); err != nil {
+				return fmt.Errorf("writing serve config of profile %q: %w", bp.Profile.ID, err)
+			}
+		}
+	}
+	if err := b.restoreCerts(bk); err != nil {
+		return fmt.Errorf("restoring certs: %w", err)
+	}
+	if !mk.IsZero() {
+		if err := ipn.WriteState(b.store, ipn.MachineKeyStateKey, bk.MachineKey); err != nil {
+			return fmt.Errorf("writing machine key: %w", err)
+		}
+		b.machinePrivKey = mk
+	}
+
+	if bk.CurrentProfile != "" {
+		if err := b.pm.SwitchProfile(bk.CurrentProfile); err != nil {
+			return fmt.Errorf("switching to profile %q: %w", bk.CurrentProfile, err)
+		}
+	}
+	b.resetDialPlan()
+	return b.resetForProfileChangeLockedOnEntry(unlock)
+}
+
+// checkRestoredPrefsLocked checks the prefs p of the profile id from a
+// backup the same way EditPrefs checks edited prefs. The only difference is
+// that p's profile name may be that of the profile id, which it replaces,
+// rather than only the current profile's.
+//
+// b.mu must be held.
+func (b *LocalBackend) checkRestoredPrefsLocked(id ipn.ProfileID, p *ipn.Prefs) error {
+	if p.ProfileName != "" {
+		if other := b.pm.ProfileIDForName(p.ProfileName); other != "" && other != id {
+			return fmt.Errorf("profile name %q already in use", p.ProfileName)
+		}
+	}
+	unnamed := p.Clone()
+	unnamed.ProfileName = ""
+	if err := b.checkPrefsLocked(unnamed); err != nil {
+		return err
+	}
+	if p.RunSSH && !envknob.CanSSHD() {
+		return errors.New("Tailscale SSH server administratively disabled.")
+	}
+	return nil
+}
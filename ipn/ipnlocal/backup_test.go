@@ -0,0 +1,55 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/persist"
+)
+
+// TestRestoreBackupWithoutKeys tests that restoring a backup without keys
+// over a profile that's logged in keeps it logged in.
+func TestRestoreBackupWithoutKeys(t *testing.T) {
+	b := newTestLocalBackend(t)
+
+	nodeKey := key.NewNode()
+	p := b.pm.CurrentPrefs().AsStruct()
+	p.Hostname = "before"
+	p.Persist = &persist.Persist{
+		NodeID:         "node1",
+		PrivateNodeKey: nodeKey,
+		UserProfile:    tailcfg.UserProfile{ID: 1, LoginName: "user@example.com"},
+	}
+	if err := b.pm.SetPrefs(p.View(), ipn.NetworkProfile{}); err != nil {
+		t.Fatal(err)
+	}
+	id := b.pm.CurrentProfile().ID()
+
+	bk, err := b.CreateBackup(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bk.Profiles) != 1 || bk.Profiles[0].Prefs.Persist != nil {
+		t.Fatalf("backup profiles = %+v, want one without keys", bk.Profiles)
+	}
+	bk.Profiles[0].Prefs.Hostname = "after"
+
+	if err := b.RestoreBackup(bk); err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.pm.ProfilePrefs(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Hostname() != "after" {
+		t.Errorf("Hostname = %q, want %q", got.Hostname(), "after")
+	}
+	if !got.Persist().Valid() || !got.Persist().PrivateNodeKey().Equal(nodeKey) {
+		t.Errorf("node key not kept: Persist = %v", got.Persist())
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/httpm"
+)
+
+const (
+	// maxBenchDuration is the longest a peer can make us send or receive
+	// bench data for in one request.
+	maxBenchDuration = 30 * time.Second
+
+	// benchIdlePings is the number of pings measuring the idle latency.
+	benchIdlePings = 5
+
+	// benchPingInterval is the time between pings while measuring latency.
+	benchPingInterval = 200 * time.Millisecond
+)
+
+// benchUploadResponse is the peerapi response to a bench upload.
+type benchUploadResponse struct {
+	Bytes int64 // bytes received
+}
+
+// canBench reports whether h can run bandwidth tests against this node,
+// which could otherwise be used to saturate its link.
+func (h *peerAPIHandler) canBench() bool {
+	if h.peerNode.UnsignedPeerAPIOnly() {
+		return false
+	}
+	return h.isSelf || h.peerHasCap(tailcfg.PeerCapabilityDebugPeer)
+}
+
+// handleServeBench is the peer side of a bandwidth test started with
+// LocalBackend.Bench. A GET sends data for the requested duration; a POST
+// discards the body and reports how many bytes it got.
+func (h *peerAPIHandler) handleServeBench(w http.ResponseWriter, r *http.Request) {
+	if !h.canBench() {
+		http.Error(w, "denied; no bench access", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case httpm.GET:
+		d, err := time.ParseDuration(r.FormValue("duration"))
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid 'duration' parameter", http.StatusBadRequest)
+			return
+		}
+		d = min(d, maxBenchDuration)
+		h.logf("bench: sending to %v for %v", h.remoteAddr.Addr(), d)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		buf := make([]byte, 64<<10)
+		for deadline := time.Now().Add(d); time.Now().Before(deadline); {
+			if _, err := w.Write(buf); err != nil {
+				return
+			}
+		}
+	case httpm.POST:
+		h.logf("bench: receiving from %v", h.remoteAddr.Addr())
+		// Bound the upload, as we don't trust the peer to stop.
+		http.NewResponseController(w).SetReadDeadline(time.Now().Add(maxBenchDuration + 5*time.Second))
+		n, err := io.Copy(io.Discard, r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(benchUploadResponse{Bytes: n})
+	default:
+		http.Error(w, "bad method", http.StatusMethodNotAllowed)
+	}
+}
+
+// Bench runs a bandwidth test against the peer with Tailscale IP ip through
+// its peerapi: it measures the idle latency, then uploads and downloads data
+// for d each, as selected, while measuring the latency under that load.
+// Latencies are measured with TSMP pings, which go through WireGuard like
+// the bench data.
+func (b *LocalBackend) Bench(ctx context.Context, ip netip.Addr, d time.Duration, upload, download bool) (*apitype.BenchResult, error) {
+	if d <= 0 || d > maxBenchDuration {
+		return nil, fmt.Errorf("duration must be between 0 and %v", maxBenchDuration)
+	}
+	nm := b.NetMap()
+	if nm == nil {
+		return nil, errors.New("no netmap")
+	}
+	peer, ok := nm.PeerByTailscaleIP(ip)
+	if !ok {
+		return nil, fmt.Errorf("no peer found with Tailscale IP %v", ip)
+	}
+	base := peerAPIBase(nm, peer)
+	if base == "" {
+		return nil, fmt.Errorf("no PeerAPI base found for peer %v (%v)", peer.ID(), ip)
+	}
+	hc := &http.Client{Transport: b.Dialer().PeerAPITransport()}
+
+	res := &apitype.BenchResult{
+		NodeName: peer.Name(),
+		NodeIP:   ip,
+		Path:     b.benchPath(ctx, ip),
+	}
+	res.IdleLatency = b.benchPings(ctx, ip, benchIdlePings)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	var err error
+	if upload {
+		res.Upload, err = b.benchPhase(ctx, ip, func(ctx context.Context) (int64, error) {
+			return benchUpload(ctx, hc, base, d)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("upload: %w", err)
+		}
+	}
+	if download {
+		res.Download, err = b.benchPhase(ctx, ip, func(ctx context.Context) (int64, error) {
+			return benchDownload(ctx, hc, base, d)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("download: %w", err)
+		}
+	}
+	res.PathAfter = b.benchPath(ctx, ip)
+	return res, nil
+}
+
+// benchPath returns how the peer with Tailscale IP ip is reached, as found
+// with a disco ping.
+func (b *LocalBackend) benchPath(ctx context.Context, ip netip.Addr) string {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	pr, err := b.Ping(ctx, ip, tailcfg.PingDisco, 0)
+	switch {
+	case err != nil:
+		return "unknown"
+	case pr.Err != "":
+		return "unknown (" + pr.Err + ")"
+	case pr.DERPRegionID != 0:
+		return fmt.Sprintf("DERP(%s)", pr.DERPRegionCode)
+	}
+	return "direct " + pr.Endpoint
+}
+
+// benchPhase runs transfer, which returns the number of bytes transferred,
+// while measuring the latency to ip.
+func (b *LocalBackend) benchPhase(ctx context.Context, ip netip.Addr, transfer func(context.Context) (int64, error)) (*apitype.BenchPhase, error) {
+	pingCtx, stopPings := context.WithCancel(ctx)
+	latc := make(chan apitype.BenchLatency, 1)
+	go func() { latc <- b.benchPings(pingCtx, ip, 0) }()
+
+	t0 := b.clock.Now()
+	n, err := transfer(ctx)
+	elapsed := b.clock.Since(t0)
+	stopPings()
+	lat := <-latc
+	if err != nil {
+		return nil, err
+	}
+	return &apitype.BenchPhase{
+		Bytes:         n,
+		Seconds:       elapsed.Seconds(),
+		BitsPerSecond: float64(n*8) / elapsed.Seconds(),
+		Latency:       lat,
+	}, nil
+}
+
+// benchPings sends TSMP pings to ip every benchPingInterval, count of them
+// or, if count is zero, until ctx is done.
+func (b *LocalBackend) benchPings(ctx context.Context, ip netip.Addr, count int) apitype.BenchLatency {
+	var lat apitype.BenchLatency
+	var sum float64
+	for count == 0 || lat.Pings < count {
+		pingCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		pr, err := b.Ping(pingCtx, ip, tailcfg.PingTSMP, 0)
+		cancel()
+		if ctx.Err() != nil {
+			// Don't count the ping cut short by the end of the phase.
+			break
+		}
+		lat.Pings++
+		if err == nil && pr.Err == "" {
+			s := pr.LatencySeconds
+			if lat.Pongs == 0 || s < lat.MinSeconds {
+				lat.MinSeconds = s
+			}
+			lat.MaxSeconds = max(lat.MaxSeconds, s)
+			sum += s
+			lat.Pongs++
+		}
+		if count != 0 && lat.Pings == count {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(benchPingInterval):
+		}
+	}
+	if lat.Pongs > 0 {
+		lat.AvgSeconds = sum / float64(lat.Pongs)
+	}
+	return lat
+}
+
+// benchReader is the body of a bench upload: zeros until a deadline.
+type benchReader struct {
+	deadline time.Time
+}
+
+func (r *benchReader) Read(p []byte) (int, error) {
+	if !time.Now().Before(r.deadline) {
+		return 0, io.EOF
+	}
+	clear(p)
+	return len(p), nil
+}
+
+func benchUpload(ctx context.Context, hc *http.Client, base string, d time.Duration) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, httpm.POST, base+"/v0/bench", &benchReader{deadline: time.Now().Add(d)})
+	if err != nil {
+		return 0, err
+	}
+	res, err := hc.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return 0, benchHTTPError(res)
+	}
+	var got benchUploadResponse
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		return 0, err
+	}
+	return got.Bytes, nil
+}
+
+func benchDownload(ctx context.Context, hc *http.Client, base string, d time.Duration) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, httpm.GET, base+"/v0/bench?duration="+url.QueryEscape(d.String()), nil)
+	if err != nil {
+		return 0, err
+	}
+	res, err := hc.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return 0, benchHTTPError(res)
+	}
+	return io.Copy(io.Discard, res.Body)
+}
+
+func benchHTTPError(res *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+	return fmt.Errorf("peer: %s: %s", res.Status, strings.TrimSpace(string(msg)))
+}
@@ -147,6 +147,7 @@ func isNotableNotify(n *ipn.Notify) bool {
 	return n.State != nil ||
 		n.SessionID != "" ||
 		n.BrowseToURL != nil ||
+		n.LoginUserCode != nil ||
 		n.LocalTCPPort != nil ||
 		n.ClientVersion != nil ||
 		n.Prefs != nil ||
@@ -46,6 +46,9 @@
 	req("/debug/component-logging"): handleC2NDebugComponentLogging,
 	req("/debug/logheap"):           handleC2NDebugLogHeap,
 
+	// Remote diagnostics, subject to the local policy.
+	req("POST /debug/remote-diag"): handleC2NRemoteDiag,
+
 	// PPROF - We only expose a subset of typical pprof endpoints for security.
 	req("/debug/pprof/heap"):   handleC2NPprof,
 	req("/debug/pprof/allocs"): handleC2NPprof,
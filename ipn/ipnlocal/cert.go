@@ -42,6 +42,7 @@
 	"tailscale.com/net/bakedroots"
 	"tailscale.com/tempfork/acme"
 	"tailscale.com/types/logger"
+	"tailscale.com/util/mak"
 	"tailscale.com/util/testenv"
 	"tailscale.com/version"
 	"tailscale.com/version/distro"
@@ -120,6 +121,7 @@ func (b *LocalBackend) GetCertPEMWithValidity(ctx context.Context, domain string
 
 	if pair, err := getCertPEMCached(cs, domain, now); err == nil {
 		// If we got here, we have a valid unexpired cert.
+		b.stapleOCSP(domain, pair)
 		// Check whether we should start an async renewal.
 		shouldRenew, err := b.shouldStartDomainRenewal(cs, domain, now, pair, minValidity)
 		if err != nil {
@@ -182,8 +184,9 @@ func (b *LocalBackend) shouldStartDomainRenewal(cs certStore, domain string, now
 
 func (b *LocalBackend) domainRenewed(domain string) {
 	renewMu.Lock()
-	defer renewMu.Unlock()
 	delete(renewCertAt, domain)
+	renewMu.Unlock()
+	certRenewed(domain, b.clock.Now())
 }
 
 func (b *LocalBackend) domainRenewalTimeByExpiry(pair *TLSCertKeyPair) (time.Time, error) {
@@ -266,6 +269,19 @@ type certStore interface {
 var testX509Roots *x509.CertPool // set non-nil by tests
 
 func (b *LocalBackend) getCertStore() (certStore, error) {
+	if b.certStoreOverride == nil {
+		return b.getDefaultCertStore()
+	}
+	acme, err := b.getDefaultCertStore()
+	if err != nil {
+		return nil, err
+	}
+	return &certCustomStore{cs: b.certStoreOverride, acme: acme, testRoots: testX509Roots}, nil
+}
+
+// getDefaultCertStore returns the certStore used when SetCertStore has not
+// been called.
+func (b *LocalBackend) getDefaultCertStore() (certStore, error) {
 	switch b.store.(type) {
 	case *store.FileStore:
 	case *mem.Store:
@@ -384,12 +400,83 @@ func (s certStateStore) WriteACMEKey(key []byte) error {
 	return ipn.WriteState(s.StateStore, ipn.StateKey(acmePEMName), key)
 }
 
+// certCustomStore implements certStore by storing the cert & key files in an
+// ipn.CertStore set by SetCertStore. The ACME account key stays in the
+// default certStore.
+type certCustomStore struct {
+	cs   ipn.CertStore
+	acme certStore
+
+	// This field allows a test to override the CA root(s) for certificate
+	// verification. If nil the default system pool is used.
+	testRoots *x509.CertPool
+
+	mu      sync.Mutex
+	pending map[string]*TLSCertKeyPair // domain => half written by WriteCert or WriteKey
+}
+
+func (s *certCustomStore) Read(domain string, now time.Time) (*TLSCertKeyPair, error) {
+	certPEM, keyPEM, err := s.cs.Get(domain)
+	if err != nil {
+		return nil, err
+	}
+	if !validCertPEM(domain, keyPEM, certPEM, s.testRoots, now) {
+		return nil, errCertExpired
+	}
+	return &TLSCertKeyPair{CertPEM: certPEM, KeyPEM: keyPEM, Cached: true}, nil
+}
+
+func (s *certCustomStore) WriteCert(domain string, cert []byte) error {
+	return s.write(domain, cert, nil)
+}
+
+func (s *certCustomStore) WriteKey(domain string, key []byte) error {
+	return s.write(domain, nil, key)
+}
+
+// write records the non-nil one of cert and key for domain. An ipn.CertStore
+// stores the two together, so they're only passed on to it once both have
+// been written.
+func (s *certCustomStore) write(domain string, cert, key []byte) error {
+	s.mu.Lock()
+	p := s.pending[domain]
+	if p == nil {
+		p = new(TLSCertKeyPair)
+		mak.Set(&s.pending, domain, p)
+	}
+	if cert != nil {
+		p.CertPEM = cert
+	}
+	if key != nil {
+		p.KeyPEM = key
+	}
+	if p.CertPEM == nil || p.KeyPEM == nil {
+		s.mu.Unlock()
+		return nil
+	}
+	delete(s.pending, domain)
+	s.mu.Unlock()
+	return s.cs.Put(domain, p.CertPEM, p.KeyPEM)
+}
+
+func (s *certCustomStore) ACMEKey() ([]byte, error) {
+	return s.acme.ACMEKey()
+}
+
+func (s *certCustomStore) WriteACMEKey(key []byte) error {
+	return s.acme.WriteACMEKey(key)
+}
+
 // TLSCertKeyPair is a TLS public and private key, and whether they were obtained
 // from cache or freshly obtained.
 type TLSCertKeyPair struct {
 	CertPEM []byte // public key, in PEM form
 	KeyPEM  []byte // private key, in PEM form
 	Cached  bool   // whether result came from cache
+
+	// OCSPStaple, if non-nil, is a current OCSP response for the cert, in
+	// DER form, to staple to TLS handshakes. See certRenewLoop.
+	OCSPStaple []byte
 }
 
 func (kp TLSCertKeyPair) parseCertificate() (*x509.Certificate, error) {
@@ -403,6 +490,64 @@ func (kp TLSCertKeyPair) parseCertificate() (*x509.Certificate, error) {
 	return x509.ParseCertificate(block.Bytes)
 }
 
+// backupCerts adds the unexpired certs for domains in the cert store, and
+// the ACME account key, to bk.
+func (b *LocalBackend) backupCerts(bk *ipn.Backup, domains []string) error {
+	cs, err := b.getCertStore()
+	if err != nil {
+		return err
+	}
+	now := b.clock.Now()
+	for _, domain := range domains {
+		pair, err := getCertPEMCached(cs, domain, now)
+		if errors.Is(err, ipn.ErrStateNotExist) || errors.Is(err, errCertExpired) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("reading cert for %q: %w", domain, err)
+		}
+		bk.Certs = append(bk.Certs, ipn.BackupCert{
+			Domain:  domain,
+			CertPEM: pair.CertPEM,
+			KeyPEM:  pair.KeyPEM,
+		})
+	}
+	acmeKey, err := cs.ACMEKey()
+	if err != nil && !errors.Is(err, ipn.ErrStateNotExist) {
+		return fmt.Errorf("reading ACME key: %w", err)
+	}
+	bk.ACMEKey = acmeKey
+	return nil
+}
+
+// restoreCerts writes the certs and ACME account key in bk to the cert store.
+func (b *LocalBackend) restoreCerts(bk *ipn.Backup) error {
+	if len(bk.Certs) == 0 && len(bk.ACMEKey) == 0 {
+		return nil
+	}
+	cs, err := b.getCertStore()
+	if err != nil {
+		return err
+	}
+	for _, c := range bk.Certs {
+		if !validLookingCertDomain(c.Domain) {
+			return fmt.Errorf("invalid cert domain %q", c.Domain)
+		}
+		if err := cs.WriteKey(c.Domain, c.KeyPEM); err != nil {
+			return fmt.Errorf("writing key for %q: %w", c.Domain, err)
+		}
+		if err := cs.WriteCert(c.Domain, c.CertPEM); err != nil {
+			return fmt.Errorf("writing cert for %q: %w", c.Domain, err)
+		}
+	}
+	if len(bk.ACMEKey) > 0 {
+		if err := cs.WriteACMEKey(bk.ACMEKey); err != nil {
+			return fmt.Errorf("writing ACME key: %w", err)
+		}
+	}
+	return nil
+}
+
 func keyFile(dir, domain string) string  { return filepath.Join(dir, domain+".key") }
 func certFile(dir, domain string) string { return filepath.Join(dir, domain+".crt") }
 
@@ -7,10 +7,13 @@
 	"context"
 	"errors"
 	"time"
+
+	"tailscale.com/ipn"
 )
 
 type TLSCertKeyPair struct {
 	CertPEM, KeyPEM []byte
+	OCSPStaple      []byte
 }
 
 func (b *LocalBackend) GetCertPEM(ctx context.Context, domain string) (*TLSCertKeyPair, error) {
@@ -28,3 +31,16 @@ func getCertPEMCached(cs certStore, domain string, now time.Time) (p *TLSCertKey
 func (b *LocalBackend) getCertStore() (certStore, error) {
 	return nil, errors.New("not implemented for js/wasm")
 }
+
+func (b *LocalBackend) certRenewLoop(ctx context.Context) {}
+
+func (b *LocalBackend) backupCerts(bk *ipn.Backup, domains []string) error {
+	return nil
+}
+
+func (b *LocalBackend) restoreCerts(bk *ipn.Backup) error {
+	if len(bk.Certs) > 0 {
+		return errors.New("certs not implemented for js/wasm")
+	}
+	return nil
+}
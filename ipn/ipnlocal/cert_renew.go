@@ -0,0 +1,355 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !js
+
+package ipnlocal
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	randv2 "math/rand/v2"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+	"tailscale.com/types/logger"
+)
+
+const (
+	// certRenewFirstCheck is how long after the backend starts running
+	// certRenewLoop first checks the node's certs, to let the netmap settle.
+	certRenewFirstCheck = time.Minute
+
+	// certRenewInterval is how often certRenewLoop checks the node's certs
+	// after that, plus up to 10% jitter.
+	certRenewInterval = time.Hour
+
+	// certWarnBefore is how long before a cert expires that a failure to
+	// renew it raises certRenewalWarnable.
+	certWarnBefore = 7 * 24 * time.Hour
+)
+
+// certRenewalWarnable is a Warnable raised when HTTPS certs of the node that
+// are expired or about to expire can't be renewed.
+var certRenewalWarnable = health.Register(&health.Warnable{
+	Code:     "cert-renewal-failed",
+	Title:    "HTTPS certificate renewal failed",
+	Severity: health.SeverityMedium,
+	Text: func(args health.Args) string {
+		return fmt.Sprintf("Failed to renew the HTTPS certificate for %s, which is expiring: %s", args[health.ArgServerName], args[health.ArgError])
+	},
+})
+
+// Process-wide renewal status, like renewCertAt.
+var (
+	certStatusMu sync.Mutex
+	certStatus   = map[string]*apitype.CertStatus{} // by domain
+	ocspStaples  = map[string]*ocspStaple{}         // by domain
+)
+
+// ocspStaple is a cached OCSP response for a domain's cert.
+type ocspStaple struct {
+	leaf       []byte    // DER of the cert the response is for
+	resp       []byte    // DER of the response
+	nextUpdate time.Time // when resp expires, or zero if it doesn't
+	refreshAt  time.Time // when to fetch a new one
+}
+
+// certRenewLoop periodically renews the node's existing HTTPS certs that are
+// due for renewal and refreshes their OCSP staples, until ctx is done. It
+// keeps long-running servers from serving expired certs, even if nothing asks
+// for a cert in the meantime.
+func (b *LocalBackend) certRenewLoop(ctx context.Context) {
+	tmr, c := b.clock.NewTimer(certRenewFirstCheck)
+	defer tmr.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c:
+		}
+		b.renewCerts(ctx)
+		tmr.Reset(certRenewInterval + randv2.N(certRenewInterval/10))
+	}
+}
+
+// renewCerts checks the certs of the node's cert domains and updates
+// certRenewalWarnable. Only domains that already have a cert are checked, so
+// that certs aren't issued for domains that are never used.
+func (b *LocalBackend) renewCerts(ctx context.Context) {
+	nm := b.NetMap()
+	if nm == nil || len(nm.DNS.CertDomains) == 0 {
+		return
+	}
+	cs, err := b.getCertStore()
+	if err != nil {
+		return
+	}
+	var failed []string
+	var lastErr error
+	for _, domain := range nm.DNS.CertDomains {
+		if !validLookingCertDomain(domain) {
+			continue
+		}
+		st, err := b.renewCert(ctx, cs, domain)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil && (st.Expired || st.NotAfter.Sub(b.clock.Now()) < certWarnBefore) {
+			failed = append(failed, domain)
+			lastErr = err
+		}
+	}
+	if len(failed) > 0 {
+		b.health.SetUnhealthy(certRenewalWarnable, health.Args{
+			health.ArgServerName: strings.Join(failed, ", "),
+			health.ArgError:      lastErr.Error(),
+		})
+	} else {
+		b.health.SetHealthy(certRenewalWarnable)
+	}
+}
+
+// renewCert renews domain's cert in cs if it's expired or due for renewal,
+// refreshes its OCSP staple, and records its status. It returns that status
+// and the renewal error, if any. If there's no cert for domain, it does
+// nothing and returns a nil status.
+func (b *LocalBackend) renewCert(ctx context.Context, cs certStore, domain string) (*apitype.CertStatus, error) {
+	logf := logger.WithPrefix(b.logf, fmt.Sprintf("cert(%q): ", domain))
+	now := b.clock.Now()
+	pair, err := getCertPEMCached(cs, domain, now)
+	expired := errors.Is(err, errCertExpired)
+	if err != nil && !expired {
+		if !errors.Is(err, ipn.ErrStateNotExist) {
+			logf("reading cert: %v", err)
+		}
+		return nil, nil
+	}
+
+	renew := expired
+	if !expired {
+		renew, err = b.shouldStartDomainRenewal(cs, domain, now, pair, 0)
+		if err != nil {
+			logf("error checking for certificate renewal: %v", err)
+		}
+	}
+	var renewErr error
+	if renew {
+		logf("starting background renewal")
+		p, err := b.getCertPEM(ctx, cs, logf, func(any) {}, domain, now, 0)
+		if err != nil {
+			logf("background renewal: %v", err)
+			renewErr = err
+		} else {
+			pair, expired = p, false
+		}
+	}
+
+	var notAfter time.Time
+	var ocspErr error
+	if !expired {
+		if cert, err := pair.parseCertificate(); err == nil {
+			notAfter = cert.NotAfter
+		}
+		ocspErr = refreshOCSPStaple(ctx, domain, pair, now)
+		if ocspErr != nil {
+			logf("refreshing OCSP staple: %v", ocspErr)
+		}
+	}
+	renewMu.Lock()
+	renewAt := renewCertAt[domain]
+	renewMu.Unlock()
+
+	certStatusMu.Lock()
+	defer certStatusMu.Unlock()
+	st := certStatusLocked(domain)
+	st.NotAfter = notAfter
+	st.RenewAt = renewAt
+	st.Expired = expired
+	st.LastChecked = now
+	if renewErr != nil {
+		st.LastError = renewErr.Error()
+	}
+	st.OCSPError = ""
+	if ocspErr != nil {
+		st.OCSPError = ocspErr.Error()
+	}
+	ret := *st
+	return &ret, renewErr
+}
+
+// certRenewed records that domain's cert was renewed at now.
+func certRenewed(domain string, now time.Time) {
+	certStatusMu.Lock()
+	defer certStatusMu.Unlock()
+	st := certStatusLocked(domain)
+	st.LastRenewed = now
+	st.LastError = ""
+	st.Expired = false
+}
+
+// certStatusLocked returns the status of domain, creating it if needed.
+// certStatusMu must be held.
+func certStatusLocked(domain string) *apitype.CertStatus {
+	st, ok := certStatus[domain]
+	if !ok {
+		st = &apitype.CertStatus{Domain: domain}
+		certStatus[domain] = st
+	}
+	return st
+}
+
+// CertStatus returns the status of the node's HTTPS certs as of their last
+// check for renewal, sorted by domain.
+func (b *LocalBackend) CertStatus() []apitype.CertStatus {
+	now := b.clock.Now()
+	certStatusMu.Lock()
+	defer certStatusMu.Unlock()
+	ret := make([]apitype.CertStatus, 0, len(certStatus))
+	for domain, st := range certStatus {
+		s := *st
+		if staple := ocspStaples[domain]; staple != nil && staple.validAt(now) {
+			s.OCSPStapled = true
+			s.OCSPNextUpdate = staple.nextUpdate
+		}
+		ret = append(ret, s)
+	}
+	slices.SortFunc(ret, func(a, b apitype.CertStatus) int {
+		return cmp.Compare(a.Domain, b.Domain)
+	})
+	return ret
+}
+
+func (s *ocspStaple) validAt(now time.Time) bool {
+	return s.nextUpdate.IsZero() || now.Before(s.nextUpdate)
+}
+
+// stapleOCSP sets pair.OCSPStaple to the cached OCSP response for domain, if
+// there's an unexpired one for pair's cert.
+func (b *LocalBackend) stapleOCSP(domain string, pair *TLSCertKeyPair) {
+	block, _ := pem.Decode(pair.CertPEM)
+	if block == nil {
+		return
+	}
+	now := b.clock.Now()
+	certStatusMu.Lock()
+	defer certStatusMu.Unlock()
+	if s := ocspStaples[domain]; s != nil && bytes.Equal(s.leaf, block.Bytes) && s.validAt(now) {
+		pair.OCSPStaple = s.resp
+	}
+}
+
+// refreshOCSPStaple fetches an OCSP response for pair, domain's cert, unless
+// the cached one is for the same cert and not yet due for refresh. Certs
+// without an OCSP server aren't stapled. On failure, any cached response for
+// the cert is kept until it expires.
+func refreshOCSPStaple(ctx context.Context, domain string, pair *TLSCertKeyPair, now time.Time) error {
+	leaf, issuer, err := pair.parseLeafAndIssuer()
+	if err != nil {
+		return err
+	}
+	certStatusMu.Lock()
+	old := ocspStaples[domain]
+	certStatusMu.Unlock()
+	if old != nil && bytes.Equal(old.leaf, leaf.Raw) && now.Before(old.refreshAt) {
+		return nil
+	}
+	if len(leaf.OCSPServer) == 0 || issuer == nil {
+		certStatusMu.Lock()
+		delete(ocspStaples, domain)
+		certStatusMu.Unlock()
+		return nil
+	}
+
+	resp, der, err := fetchOCSPResponse(ctx, leaf.OCSPServer[0], leaf, issuer)
+	if err != nil {
+		return err
+	}
+	if resp.Status != ocsp.Good {
+		certStatusMu.Lock()
+		delete(ocspStaples, domain)
+		certStatusMu.Unlock()
+		if resp.Status == ocsp.Revoked {
+			return fmt.Errorf("cert was revoked at %v", resp.RevokedAt)
+		}
+		return errors.New("OCSP status of cert is unknown")
+	}
+	staple := &ocspStaple{
+		leaf:       leaf.Raw,
+		resp:       der,
+		nextUpdate: resp.NextUpdate,
+		refreshAt:  now.Add(certRenewInterval),
+	}
+	if !resp.NextUpdate.IsZero() {
+		// Refresh halfway through the response's validity, like
+		// renewing certs ahead of their expiry.
+		staple.refreshAt = resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate) / 2)
+	}
+	certStatusMu.Lock()
+	ocspStaples[domain] = staple
+	certStatusMu.Unlock()
+	return nil
+}
+
+// fetchOCSPResponse fetches the OCSP response for leaf, issued by issuer,
+// from server. It returns the parsed response and its DER encoding.
+func fetchOCSPResponse(ctx context.Context, server string, leaf, issuer *x509.Certificate) (*ocsp.Response, []byte, error) {
+	reqb, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating OCSP request: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	hreq, err := http.NewRequestWithContext(ctx, "POST", server, bytes.NewReader(reqb))
+	if err != nil {
+		return nil, nil, err
+	}
+	hreq.Header.Set("Content-Type", "application/ocsp-request")
+	hreq.Header.Set("Accept", "application/ocsp-response")
+	hres, err := http.DefaultClient.Do(hreq)
+	if err != nil {
+		return nil, nil, fmt.Errorf("OCSP request: %w", err)
+	}
+	defer hres.Body.Close()
+	if hres.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("OCSP server %s: %s", server, hres.Status)
+	}
+	der, err := io.ReadAll(io.LimitReader(hres.Body, 1<<20))
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading OCSP response: %w", err)
+	}
+	resp, err := ocsp.ParseResponseForCert(der, leaf, issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing OCSP response: %w", err)
+	}
+	return resp, der, nil
+}
+
+// parseLeafAndIssuer parses the first two certificates of kp's chain. The
+// issuer is nil if the chain has only the leaf.
+func (kp TLSCertKeyPair) parseLeafAndIssuer() (leaf, issuer *x509.Certificate, err error) {
+	leaf, err = kp.parseCertificate()
+	if err != nil {
+		return nil, nil, err
+	}
+	_, rest := pem.Decode(kp.CertPEM)
+	if block, _ := pem.Decode(rest); block != nil && block.Type == "CERTIFICATE" {
+		if issuer, err = x509.ParseCertificate(block.Bytes); err != nil {
+			return nil, nil, fmt.Errorf("parsing issuer certificate: %w", err)
+		}
+	}
+	return leaf, issuer, nil
+}
@@ -14,11 +14,14 @@
 	"embed"
 	"encoding/pem"
 	"math/big"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"tailscale.com/ipn"
 	"tailscale.com/ipn/store/mem"
+	"tailscale.com/util/mak"
 )
 
 func TestValidLookingCertDomain(t *testing.T) {
@@ -83,6 +86,7 @@ func TestCertStoreRoundTrip(t *testing.T) {
 	}{
 		{"FileStore", certFileStore{dir: t.TempDir(), testRoots: roots}},
 		{"StateStore", certStateStore{StateStore: new(mem.Store), testRoots: roots}},
+		{"CustomStore", &certCustomStore{cs: new(memCertStore), acme: certStateStore{StateStore: new(mem.Store)}, testRoots: roots}},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -107,6 +111,29 @@ func TestCertStoreRoundTrip(t *testing.T) {
 	}
 }
 
+// memCertStore is an in-memory ipn.CertStore.
+type memCertStore struct {
+	mu    sync.Mutex
+	certs map[string]*TLSCertKeyPair
+}
+
+func (s *memCertStore) Get(domain string) (certPEM, keyPEM []byte, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.certs[domain]
+	if !ok {
+		return nil, nil, ipn.ErrStateNotExist
+	}
+	return p.CertPEM, p.KeyPEM, nil
+}
+
+func (s *memCertStore) Put(domain string, certPEM, keyPEM []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mak.Set(&s.certs, domain, &TLSCertKeyPair{CertPEM: certPEM, KeyPEM: keyPEM})
+	return nil
+}
+
 func TestShouldStartDomainRenewal(t *testing.T) {
 	reset := func() {
 		renewMu.Lock()
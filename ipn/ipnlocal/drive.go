@@ -85,6 +85,9 @@ func (b *LocalBackend) DriveSetShare(share *drive.Share) error {
 
 func (b *LocalBackend) driveSetShareLocked(share *drive.Share) (views.SliceView[*drive.Share, drive.ShareView], error) {
 	existingShares := b.pm.prefs.DriveShares()
+	if b.isConfigStrict_Locked() {
+		return existingShares, errConfigStrict
+	}
 
 	fs, ok := b.sys.DriveForRemote.GetOK()
 	if !ok {
@@ -143,6 +146,9 @@ func (b *LocalBackend) DriveRenameShare(oldName, newName string) error {
 
 func (b *LocalBackend) driveRenameShareLocked(oldName, newName string) (views.SliceView[*drive.Share, drive.ShareView], error) {
 	existingShares := b.pm.prefs.DriveShares()
+	if b.isConfigStrict_Locked() {
+		return existingShares, errConfigStrict
+	}
 
 	fs, ok := b.sys.DriveForRemote.GetOK()
 	if !ok {
@@ -203,6 +209,9 @@ func (b *LocalBackend) DriveRemoveShare(name string) error {
 
 func (b *LocalBackend) driveRemoveShareLocked(name string) (views.SliceView[*drive.Share, drive.ShareView], error) {
 	existingShares := b.pm.prefs.DriveShares()
+	if b.isConfigStrict_Locked() {
+		return existingShares, errConfigStrict
+	}
 
 	fs, ok := b.sys.DriveForRemote.GetOK()
 	if !ok {
@@ -0,0 +1,180 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/envknob"
+	"tailscale.com/tstime"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/testenv"
+)
+
+var (
+	// keyExpiryWall, if set, also broadcasts node key expiry warnings to
+	// logged-in users with wall(1).
+	keyExpiryWall = envknob.RegisterBool("TS_KEY_EXPIRY_WALL")
+
+	// keyExpiryHook is the path of a program to run with each node key
+	// expiry warning as its argument, and the expiry time, in RFC 3339
+	// format, in its TS_KEY_EXPIRY environment variable.
+	keyExpiryHook = envknob.RegisterString("TS_KEY_EXPIRY_HOOK")
+)
+
+// keyExpiryWarnings are how long before the node key expires to warn about
+// it, at decreasing intervals, ending with a warning once it has expired.
+var keyExpiryWarnings = []time.Duration{
+	7 * 24 * time.Hour,
+	3 * 24 * time.Hour,
+	24 * time.Hour,
+	6 * time.Hour,
+	time.Hour,
+	15 * time.Minute,
+	0,
+}
+
+// keyExpiryNotifier warns that the node key is about to expire, for the
+// benefit of headless servers that would otherwise silently drop off the
+// tailnet when it does. It warns as each of keyExpiryWarnings is reached,
+// starting with the last one already reached when it learns of the expiry.
+type keyExpiryNotifier struct {
+	logf   logger.Logf
+	clock  tstime.Clock
+	notify func(msg string, expiry time.Time) // sends a warning
+
+	changed chan struct{} // signals run that expiry changed; buffered
+
+	mu     sync.Mutex
+	expiry time.Time // of the node key, or zero if it doesn't expire
+}
+
+func newKeyExpiryNotifier(logf logger.Logf, clock tstime.Clock, notify func(string, time.Time)) *keyExpiryNotifier {
+	return &keyExpiryNotifier{
+		logf:    logger.WithPrefix(logf, "key-expiry: "),
+		clock:   clock,
+		notify:  notify,
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// setExpiry sets when the node key expires, or the zero time if it doesn't
+// or there's no node key. It doesn't block.
+func (n *keyExpiryNotifier) setExpiry(expiry time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if expiry.Equal(n.expiry) {
+		return
+	}
+	n.expiry = expiry
+	select {
+	case n.changed <- struct{}{}:
+	default:
+	}
+}
+
+// run sends warnings as the node key expiry approaches until ctx is done.
+func (n *keyExpiryNotifier) run(ctx context.Context) {
+	var (
+		expiry  time.Time
+		reached int // number of keyExpiryWarnings warned about for expiry
+		tmr     tstime.TimerController
+		tc      <-chan time.Time
+	)
+	defer func() {
+		if tmr != nil {
+			tmr.Stop()
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-n.changed:
+			n.mu.Lock()
+			e := n.expiry
+			n.mu.Unlock()
+			if e.Equal(expiry) {
+				continue
+			}
+			if e.After(expiry) && reached > 0 {
+				n.logf("node key expiry extended to %v", e.Format(time.RFC3339))
+			}
+			expiry, reached = e, 0
+		case <-tc:
+		}
+		if tmr != nil {
+			tmr.Stop()
+			tmr, tc = nil, nil
+		}
+		if expiry.IsZero() {
+			continue
+		}
+		now := n.clock.Now()
+		left := expiry.Sub(now)
+		r := keyExpiryWarningsReached(left)
+		if r > reached {
+			reached = r
+			n.notify(keyExpiryMessage(expiry, now), expiry)
+		}
+		if r < len(keyExpiryWarnings) {
+			tmr, tc = n.clock.NewTimer(left - keyExpiryWarnings[r])
+		}
+	}
+}
+
+// keyExpiryWarningsReached returns how many of keyExpiryWarnings have been
+// reached with left until the node key expires.
+func keyExpiryWarningsReached(left time.Duration) int {
+	r := 0
+	for r < len(keyExpiryWarnings) && left <= keyExpiryWarnings[r] {
+		r++
+	}
+	return r
+}
+
+// keyExpiryMessage returns the warning to send at now about the node key
+// expiring at expiry.
+func keyExpiryMessage(expiry, now time.Time) string {
+	at := expiry.Local().Format(time.RFC3339)
+	if !expiry.After(now) {
+		return fmt.Sprintf("Tailscale node key expired at %s; this machine is disconnected from the tailnet until it's reauthenticated with 'tailscale up --force-reauth'.", at)
+	}
+	return fmt.Sprintf("Tailscale node key expires in %v, at %s; reauthenticate with 'tailscale up --force-reauth', or disable key expiry for this machine in the admin console, to keep it connected.", expiry.Sub(now).Round(time.Minute), at)
+}
+
+// sendKeyExpiryWarning sends msg, a warning that the node key expires at
+// expiry, to the log and the system log, and with wall(1) and the program of
+// TS_KEY_EXPIRY_HOOK if configured.
+func (b *LocalBackend) sendKeyExpiryWarning(msg string, expiry time.Time) {
+	b.logf("%s", msg)
+	if !testenv.InTest() {
+		if err := syslogWarning(msg); err != nil {
+			b.logf("key-expiry: syslog: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(b.ctx, time.Minute)
+	defer cancel()
+	if keyExpiryWall() {
+		cmd := exec.CommandContext(ctx, "wall")
+		cmd.Stdin = strings.NewReader(msg + "\n")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			b.logf("key-expiry: wall: %v: %s", err, out)
+		}
+	}
+	if hook := keyExpiryHook(); hook != "" {
+		cmd := exec.CommandContext(ctx, hook, msg)
+		cmd.Env = append(os.Environ(), "TS_KEY_EXPIRY="+expiry.UTC().Format(time.RFC3339))
+		if out, err := cmd.CombinedOutput(); err != nil {
+			b.logf("key-expiry: hook %s: %v: %s", hook, err, out)
+		}
+	}
+}
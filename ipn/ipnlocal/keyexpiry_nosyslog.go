@@ -0,0 +1,9 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build windows || plan9 || js || wasip1 || ios || android
+
+package ipnlocal
+
+// syslogWarning does nothing; there's no system log to send warnings to.
+func syslogWarning(msg string) error { return nil }
@@ -0,0 +1,18 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !windows && !plan9 && !js && !wasip1 && !ios && !android
+
+package ipnlocal
+
+import "log/syslog"
+
+// syslogWarning logs msg to the system log as a warning.
+func syslogWarning(msg string) error {
+	w, err := syslog.New(syslog.LOG_WARNING|syslog.LOG_DAEMON, "tailscaled")
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	return w.Warning(msg)
+}
@@ -0,0 +1,68 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/tstest"
+)
+
+func TestKeyExpiryWarningsReached(t *testing.T) {
+	for _, tt := range []struct {
+		left time.Duration
+		want int
+	}{
+		{30 * 24 * time.Hour, 0},
+		{7 * 24 * time.Hour, 1},
+		{2 * 24 * time.Hour, 2},
+		{12 * time.Hour, 3},
+		{5 * time.Minute, 6},
+		{0, 7},
+		{-time.Hour, 7},
+	} {
+		if got := keyExpiryWarningsReached(tt.left); got != tt.want {
+			t.Errorf("keyExpiryWarningsReached(%v) = %d, want %d", tt.left, got, tt.want)
+		}
+	}
+}
+
+func TestKeyExpiryNotifier(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := tstest.NewClock(tstest.ClockOpts{Start: start})
+	msgs := make(chan string, 10)
+	n := newKeyExpiryNotifier(t.Logf, clk, func(msg string, _ time.Time) { msgs <- msg })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go n.run(ctx)
+
+	// An expiry two days out warns once right away, not for each of the
+	// warnings already reached.
+	n.setExpiry(start.Add(48 * time.Hour))
+	if msg := <-msgs; !strings.Contains(msg, "expires in 48h0m0s") {
+		t.Errorf("first warning = %q", msg)
+	}
+	select {
+	case msg := <-msgs:
+		t.Fatalf("unexpected second warning %q", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Once expired, it warns about that.
+	n.setExpiry(start.Add(-time.Minute))
+	if msg := <-msgs; !strings.Contains(msg, "expired at") {
+		t.Errorf("expired warning = %q", msg)
+	}
+
+	// Extending the expiry beyond the first warning silences it.
+	n.setExpiry(start.Add(30 * 24 * time.Hour))
+	select {
+	case msg := <-msgs:
+		t.Fatalf("unexpected warning %q after extension", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
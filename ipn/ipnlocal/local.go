@@ -57,6 +57,7 @@
 	"tailscale.com/health/healthmsg"
 	"tailscale.com/hostinfo"
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/cloudauth"
 	"tailscale.com/ipn/conffile"
 	"tailscale.com/ipn/ipnauth"
 	"tailscale.com/ipn/ipnstate"
@@ -74,6 +75,7 @@
 	"tailscale.com/net/netns"
 	"tailscale.com/net/netutil"
 	"tailscale.com/net/packet"
+	"tailscale.com/net/portmapper"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tsdial"
 	"tailscale.com/paths"
@@ -199,8 +201,11 @@ type LocalBackend struct {
 	portpoll                 *portlist.Poller // may be nil
 	portpollOnce             sync.Once        // guards starting readPoller
 	varRoot                  string           // or empty if SetVarRoot never called
+	certStoreOverride        ipn.CertStore    // or nil if SetCertStore never called
 	logFlushFunc             func()           // or nil if SetLogFlusher wasn't called
 	em                       *expiryManager   // non-nil
+	routeFailover            *routeFailover   // or nil if subnet router failover is disabled
+	keyExpiry                *keyExpiryNotifier
 	sshAtomicBool            atomic.Bool
 	// webClientAtomicBool controls whether the web client is running. This should
 	// be true unless the disable-web-client node attribute has been set.
@@ -243,6 +248,7 @@ type LocalBackend struct {
 	conf           *conffile.Config // latest parsed config, or nil if not in declarative mode
 	pm             *profileManager  // mu guards access
 	filterHash     deephash.Sum
+	filterStats    bool               // whether new packet filters count packets; see SetFilterStats
 	httpTestClient *http.Client       // for controlclient. nil by default, used by tests.
 	ccGen          clientGen          // function for producing controlclient; lazily populated
 	sshServer      SSHServer          // or nil, initialized lazily.
@@ -256,6 +262,7 @@ type LocalBackend struct {
 	state          ipn.State
 	capFileSharing bool // whether netMap contains the file sharing capability
 	capTailnetLock bool // whether netMap contains the tailnet lock capability
+	routesStandby  bool // whether advertised subnet routes are withheld (see SetRoutesStandby)
 	// hostinfo is mutated in-place while mu is held.
 	hostinfo *tailcfg.Hostinfo
 	// netMap is the most recently set full netmap from the controlclient.
@@ -268,7 +275,12 @@ type LocalBackend struct {
 	// peers is the set of current peers and their current values after applying
 	// delta node mutations as they come in (with mu held). The map values can
 	// be given out to callers, but the map itself must not escape the LocalBackend.
-	peers            map[tailcfg.NodeID]tailcfg.NodeView
+	peers map[tailcfg.NodeID]tailcfg.NodeView
+	// sortedPeers is the values of peers sorted by NodeID, or nil if it
+	// needs to be recomputed. Delta updates patch it in place, unless
+	// sortedShared is set, in which case they patch a copy.
+	sortedPeers      []tailcfg.NodeView
+	sortedShared     bool                          // sortedPeers was handed out (in a Notify) and must not be mutated
 	nodeByAddr       map[netip.Addr]tailcfg.NodeID // by Node.Addresses only (not subnet routes)
 	nmExpiryTimer    tstime.TimerController        // for updating netMap on node expiry; can be nil
 	activeLogin      string                        // last logged LoginName from netMap
@@ -278,6 +290,7 @@ type LocalBackend struct {
 	keyExpired       bool
 	authURL          string        // non-empty if not Running
 	authURLTime      time.Time     // when the authURL was received from the control server
+	authUserCode     string        // code to enter at authURL, if it's for a device-code login
 	authActor        ipnauth.Actor // an actor who called [LocalBackend.StartLoginInteractive] last, or nil
 	egg              bool
 	prevIfState      *netmon.State
@@ -299,8 +312,12 @@ type LocalBackend struct {
 	// It's also used on several NAS platforms (Synology, TrueNAS, etc)
 	// but in that case DoFinalRename is also set true, which moves the
 	// *.partial file to its final name on completion.
-	directFileRoot    string
-	componentLogUntil map[string]componentLogState
+	directFileRoot string
+	// taildropAutoAccept, if its Dir is non-empty, is where files
+	// from the senders it allows are written directly, as with
+	// directFileRoot, rather than staged for "tailscale file get".
+	taildropAutoAccept TaildropAutoAccept
+	componentLogUntil  map[string]componentLogState
 	// c2nUpdateStatus is the status of c2n-triggered client update.
 	c2nUpdateStatus     updateStatus
 	currentUser         ipnauth.Actor
@@ -381,6 +398,13 @@ type LocalBackend struct {
 	// running, and is set to nil after being canceled.
 	captiveCtx    context.Context
 	captiveCancel context.CancelFunc
+	// remoteDiagPending are the requests for diagnostics from the control
+	// plane waiting for the local user's consent, by ID. Protected by 'mu'.
+	remoteDiagPending map[string]*remoteDiagRequest
+
+	// certRenewCancel stops certRenewLoop. It's non-nil while the loop is
+	// running, which is while the backend is Running. Protected by 'mu'.
+	certRenewCancel context.CancelFunc
 	// needsCaptiveDetection is a channel that is used to signal either
 	// that captive portal detection is required (sending true) or that the
 	// backend is healthy and captive portal detection is not required
@@ -502,6 +526,17 @@ func NewLocalBackend(logf logger.Logf, logID logid.PublicID, sys *tsd.System, lo
 	}
 	mConn.SetNetInfoCallback(b.setNetInfo)
 
+	if subnetFailoverEnabled() {
+		b.routeFailover = newRouteFailover(logf, b.pingSubnetRouter, b.authReconfig)
+		go b.routeFailover.run(ctx)
+	}
+	b.keyExpiry = newKeyExpiryNotifier(logf, clock, b.sendKeyExpiryWarning)
+	go b.keyExpiry.run(ctx)
+	go b.pruneAppConnectorRoutesLoop(ctx)
+	if dm, ok := sys.DNSManager.GetOK(); ok {
+		dm.Resolver().SetResponseObserver(b.observeLocalDNSResponse)
+	}
+
 	if sys.InitialConfig != nil {
 		if err := b.initPrefsFromConfig(sys.InitialConfig); err != nil {
 			return nil, err
@@ -741,8 +776,24 @@ func (b *LocalBackend) SetDirectFileRoot(dir string) {
 	b.directFileRoot = dir
 }
 
+// SetTaildropAutoAccept sets the directory to receive files from some
+// senders into directly, without "tailscale file get", and which
+// senders those are. It has no effect with SetDirectFileRoot, as all
+// files are then received directly.
+//
+// This must be called before the LocalBackend starts being used.
+func (b *LocalBackend) SetTaildropAutoAccept(c TaildropAutoAccept) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.taildropAutoAccept = c
+}
+
 // ReloadConfig reloads the backend's config from disk.
 //
+// The new config replaces the old one as a whole: settings removed from the
+// file revert to their defaults, and if the node needs to log in and the
+// file now has an AuthKey or CloudAuth, it logs in with it.
+//
 // It returns (false, nil) if not running in declarative mode, (true, nil) on
 // success, or (false, error) on failure.
 func (b *LocalBackend) ReloadConfig() (ok bool, err error) {
@@ -759,6 +810,12 @@ func (b *LocalBackend) ReloadConfig() (ok bool, err error) {
 		return false, fmt.Errorf("error setting config: %w", err)
 	}
 
+	if b.State() == ipn.NeedsLogin && (conf.Parsed.AuthKey != nil || conf.Parsed.CloudAuth != nil) {
+		// Start picks up the auth key from the new config.
+		if err := b.Start(ipn.Options{}); err != nil {
+			return false, fmt.Errorf("logging in with the config's auth key: %w", err)
+		}
+	}
 	return true, nil
 }
 
@@ -806,16 +863,21 @@ func (b *LocalBackend) setStaticEndpointsFromConfigLocked(conf *conffile.Config)
 // and other state.
 func (b *LocalBackend) setConfigLockedOnEntry(conf *conffile.Config, unlock unlockOnce) error {
 	defer unlock()
+	var prev *ipn.ConfigVAlpha
+	if b.conf != nil {
+		prev = &b.conf.Parsed
+	}
 	p := b.pm.CurrentPrefs().AsStruct()
-	mp, err := conf.Parsed.ToPrefs()
+	mp, err := conf.Parsed.ToPrefsOnReload(prev)
 	if err != nil {
 		return fmt.Errorf("error parsing config to prefs: %w", err)
 	}
 	p.ApplyEdits(&mp)
 	b.setStaticEndpointsFromConfigLocked(conf)
-	b.setPrefsLockedOnEntry(p, unlock)
 
+	// Set b.conf before the prefs, so the serve config is reloaded from it.
 	b.conf = conf
+	b.setPrefsLockedOnEntry(p, unlock)
 	return nil
 }
 
@@ -858,9 +920,10 @@ func (b *LocalBackend) linkChange(delta *netmon.ChangeDelta) {
 
 	ifst := delta.New
 	hadPAC := b.prevIfState.HasPAC()
+	conflictsChanged := addrConflictsChanged(b.prevIfState, ifst)
 	b.prevIfState = ifst
 	b.pauseOrResumeControlClientLocked()
-	if delta.Major && shouldAutoExitNode() {
+	if delta.Major && b.autoExitNodeLocked() != "" {
 		b.refreshAutoExitNode = true
 	}
 
@@ -875,6 +938,12 @@ func (b *LocalBackend) linkChange(delta *netmon.ChangeDelta) {
 		b.logf("linkChange: in state %v; PAC changed from %v->%v", b.state, hadPAC, ifst.HasPAC())
 		needReconfig = true
 	}
+	// If local networks started or stopped using Tailscale's address
+	// ranges, reconfig to apply or lift the mitigation, if any.
+	if conflictsChanged && b.pm.CurrentPrefs().AddrConflictMitigation() != ipn.AddrConflictMitigationNone {
+		b.logf("linkChange: in state %v; local networks using Tailscale addresses changed", b.state)
+		needReconfig = true
+	}
 	if needReconfig {
 		switch b.state {
 		case ipn.NoState, ipn.Stopped:
@@ -891,6 +960,7 @@ func (b *LocalBackend) linkChange(delta *netmon.ChangeDelta) {
 	// need updating to tweak default routes.
 	b.updateFilterLocked(b.netMap, b.pm.CurrentPrefs())
 	updateExitNodeUsageWarning(b.pm.CurrentPrefs(), delta.New, b.health)
+	updateAddrConflictWarning(b.pm.CurrentPrefs(), delta.New, b.health)
 
 	if peerAPIListenAsync && b.netMap != nil && b.state == ipn.Running {
 		want := b.netMap.GetAddresses().Len()
@@ -1010,7 +1080,7 @@ func (b *LocalBackend) Shutdown() {
 		ctx, cancel := context.WithTimeout(b.ctx, 5*time.Second)
 		defer cancel()
 		t0 := time.Now()
-		err := b.Logout(ctx) // best effort
+		err := b.logout(ctx) // best effort
 		td := time.Since(t0).Round(time.Millisecond)
 		if err != nil {
 			b.logf("failed to log out ephemeral node on shutdown after %v: %v", td, err)
@@ -1043,6 +1113,7 @@ func (b *LocalBackend) Shutdown() {
 	}
 	if b.peerAPIServer != nil {
 		b.peerAPIServer.taildrop.Shutdown()
+		b.peerAPIServer.autoTaildrop.Shutdown()
 	}
 	b.stopOfflineAutoUpdate()
 
@@ -1565,7 +1636,7 @@ func (b *LocalBackend) SetControlClientStatus(c controlclient.Client, st control
 			prefsChanged = true
 		}
 	}
-	if shouldAutoExitNode() {
+	if b.autoExitNodeLocked() != "" {
 		// Re-evaluate exit node suggestion in case circumstances have changed.
 		_, err := b.suggestExitNodeLocked(curNetMap)
 		if err != nil && !errors.Is(err, ErrNoPreferredDERP) {
@@ -1575,6 +1646,9 @@ func (b *LocalBackend) SetControlClientStatus(c controlclient.Client, st control
 	if applySysPolicy(prefs, b.lastSuggestedExitNode, b.overrideAlwaysOn) {
 		prefsChanged = true
 	}
+	if applyAutoExitNode(prefs, b.lastSuggestedExitNode) {
+		prefsChanged = true
+	}
 	if setExitNodeID(prefs, curNetMap) {
 		prefsChanged = true
 	}
@@ -1680,7 +1754,7 @@ func (b *LocalBackend) SetControlClientStatus(c controlclient.Client, st control
 	}
 	if st.URL != "" {
 		b.logf("Received auth URL: %.20v...", st.URL)
-		b.setAuthURL(st.URL)
+		b.setAuthURL(st.URL, st.UserCode)
 	}
 	b.stateMachine()
 	// This is currently (2020-07-28) necessary; conditionally disabling it is fragile!
@@ -1787,19 +1861,21 @@ func applySysPolicy(prefs *ipn.Prefs, lastSuggestedExitNode tailcfg.StableNodeID
 		// then exitNodeID is now "auto" which will never match a peer's node ID.
 		// When there is no a peer matching the node ID, traffic will blackhole,
 		// preventing accidental non-exit-node usage when a policy is in effect that requires an exit node.
-		if prefs.ExitNodeID != exitNodeID || prefs.ExitNodeIP.IsValid() {
+		if prefs.ExitNodeID != exitNodeID || prefs.ExitNodeIP.IsValid() || prefs.AutoExitNode != "" {
 			anyChange = true
 		}
 		prefs.ExitNodeID = exitNodeID
 		prefs.ExitNodeIP = netip.Addr{}
+		prefs.AutoExitNode = ""
 	} else if exitNodeIPStr, _ := syspolicy.GetString(syspolicy.ExitNodeIP, ""); exitNodeIPStr != "" {
 		exitNodeIP, err := netip.ParseAddr(exitNodeIPStr)
 		if exitNodeIP.IsValid() && err == nil {
-			if prefs.ExitNodeID != "" || prefs.ExitNodeIP != exitNodeIP {
+			if prefs.ExitNodeID != "" || prefs.ExitNodeIP != exitNodeIP || prefs.AutoExitNode != "" {
 				anyChange = true
 			}
 			prefs.ExitNodeID = ""
 			prefs.ExitNodeIP = exitNodeIP
+			prefs.AutoExitNode = ""
 		}
 	}
 
@@ -1900,15 +1976,11 @@ func (b *LocalBackend) UpdateNetmapDelta(muts []netmap.NodeMutation) (handled bo
 		return false
 	}
 
-	if b.netMap != nil && mutationsAreWorthyOfTellingIPNBus(muts) {
+	// Only materialize the peer list if someone's listening; on large
+	// tailnets it's the only O(peers) part of applying a delta.
+	if b.netMap != nil && len(b.notifyWatchers) > 0 && mutationsAreWorthyOfTellingIPNBus(muts) {
 		nm := ptr.To(*b.netMap) // shallow clone
-		nm.Peers = make([]tailcfg.NodeView, 0, len(b.peers))
-		for _, p := range b.peers {
-			nm.Peers = append(nm.Peers, p)
-		}
-		slices.SortFunc(nm.Peers, func(a, b tailcfg.NodeView) int {
-			return cmp.Compare(a.ID(), b.ID())
-		})
+		nm.Peers = b.sortedPeersLocked()
 		notify = &ipn.Notify{NetMap: nm}
 	} else if testenv.InTest() {
 		// In tests, send an empty Notify as a wake-up so end-to-end
@@ -1974,16 +2046,51 @@ func (b *LocalBackend) updateNetmapDeltaLocked(muts []netmap.NodeMutation) (hand
 
 		// If our exit node went offline, we need to schedule picking
 		// a new one.
-		if mo, ok := m.(netmap.NodeMutationOnline); ok && !mo.Online && n.StableID == b.pm.prefs.ExitNodeID() && shouldAutoExitNode() {
+		if mo, ok := m.(netmap.NodeMutationOnline); ok && !mo.Online && n.StableID == b.pm.prefs.ExitNodeID() && b.autoExitNodeLocked() != "" {
 			b.goTracker.Go(b.pickNewAutoExitNode)
 		}
 	}
+	// None of the mutation types change a node's addresses, AllowedIPs or
+	// capabilities, so there's no need to recompute the packet filter here.
+	sorted := b.sortedPeers
+	if sorted != nil && b.sortedShared {
+		// Copy-on-write: the old slice was handed out in a Notify.
+		sorted = slices.Clone(sorted)
+		b.sortedShared = false
+	}
 	for nid, n := range mutableNodes {
-		b.peers[nid] = n.View()
+		nv := n.View()
+		b.peers[nid] = nv
+		if sorted == nil {
+			continue
+		}
+		if i, ok := slices.BinarySearchFunc(sorted, nid, func(v tailcfg.NodeView, id tailcfg.NodeID) int {
+			return cmp.Compare(v.ID(), id)
+		}); ok {
+			sorted[i] = nv
+		} else {
+			sorted = nil // shouldn't happen; rebuild lazily
+		}
 	}
+	b.sortedPeers = sorted
 	return true
 }
 
+// sortedPeersLocked returns the current peers sorted by NodeID.
+// The returned slice must not be mutated; later deltas won't modify it.
+//
+// b.mu must be held.
+func (b *LocalBackend) sortedPeersLocked() []tailcfg.NodeView {
+	if b.sortedPeers == nil && len(b.peers) > 0 {
+		b.sortedPeers = slicesx.MapValues(b.peers)
+		slices.SortFunc(b.sortedPeers, func(a, b tailcfg.NodeView) int {
+			return cmp.Compare(a.ID(), b.ID())
+		})
+	}
+	b.sortedShared = true
+	return b.sortedPeers
+}
+
 // setExitNodeID updates prefs to reference an exit node by ID, rather
 // than by IP. It returns whether prefs was mutated.
 func setExitNodeID(prefs *ipn.Prefs, nm *netmap.NetworkMap) (prefsChanged bool) {
@@ -2180,6 +2287,10 @@ func (b *LocalBackend) getNewControlClientFuncLocked() clientGen {
 func (b *LocalBackend) Start(opts ipn.Options) error {
 	b.logf("Start")
 
+	if opts.AuthKey == "" {
+		opts.AuthKey = b.cloudAuthKey()
+	}
+
 	var clientToShutdown controlclient.Client
 	defer func() {
 		if clientToShutdown != nil {
@@ -2509,13 +2620,18 @@ func (b *LocalBackend) updateFilterLocked(netMap *netmap.NetworkMap, prefs ipn.P
 	}
 
 	oldFilter := b.e.GetFilter()
+	var filt *filter.Filter
 	if shieldsUp {
 		b.logf("[v1] netmap packet filter: (shields up)")
-		b.setFilter(filter.NewShieldsUpFilter(localNets, logNets, oldFilter, b.logf))
+		filt = filter.NewShieldsUpFilter(localNets, logNets, oldFilter, b.logf)
 	} else {
 		b.logf("[v1] netmap packet filter: %v filters", len(packetFilter))
-		b.setFilter(filter.New(packetFilter, b.srcIPHasCapForFilter, localNets, logNets, oldFilter, b.logf))
+		filt = filter.New(packetFilter, b.srcIPHasCapForFilter, localNets, logNets, oldFilter, b.logf)
 	}
+	if b.filterStats {
+		filt.EnableStats()
+	}
+	b.setFilter(filt)
 	// The filter for a jailed node is the exact same as a ShieldsUp filter.
 	oldJailedFilter := b.e.GetJailedFilter()
 	b.e.SetJailedFilter(filter.NewShieldsUpFilter(localNets, logNets, oldJailedFilter, b.logf))
@@ -2942,6 +3058,9 @@ func (b *LocalBackend) WatchNotificationsAs(ctx context.Context, actor ipnauth.A
 			ini.State = ptr.To(b.state)
 			if b.state == ipn.NeedsLogin && b.authURL != "" {
 				ini.BrowseToURL = ptr.To(b.authURL)
+				if b.authUserCode != "" {
+					ini.LoginUserCode = ptr.To(b.authUserCode)
+				}
 			}
 		}
 		if mask&ipn.NotifyInitialPrefs != 0 {
@@ -3197,6 +3316,9 @@ func (b *LocalBackend) sendFileNotify() {
 	// in JSON to clients. They distinguish between empty and non-nil
 	// to know whether a Notify should be able about files.
 	n.IncomingFiles = apiSrv.taildrop.IncomingFiles()
+	if apiSrv.autoTaildrop != nil {
+		n.IncomingFiles = append(n.IncomingFiles, apiSrv.autoTaildrop.IncomingFiles()...)
+	}
 	b.mu.Unlock()
 
 	sort.Slice(n.IncomingFiles, func(i, j int) bool {
@@ -3211,8 +3333,9 @@ func (b *LocalBackend) sendFileNotify() {
 // has started a new interactive login (e.g., by running `tailscale login` or clicking Login in the GUI),
 // or the control plane was unable to authenticate this node non-interactively (e.g., due to key expiration).
 // A non-nil b.authActor indicates that an interactive login is in progress and was initiated by the specified actor.
+// userCode is non-empty if url is for a device-code login, and is the code the user must enter there.
 // If url is "", it is equivalent to calling [LocalBackend.resetAuthURLLocked] with b.mu held.
-func (b *LocalBackend) setAuthURL(url string) {
+func (b *LocalBackend) setAuthURL(url, userCode string) {
 	var popBrowser, keyExpired bool
 	var recipient ipnauth.Actor
 
@@ -3222,9 +3345,10 @@ func (b *LocalBackend) setAuthURL(url string) {
 		b.resetAuthURLLocked()
 		b.mu.Unlock()
 		return
-	case b.authURL != url:
+	case b.authURL != url || b.authUserCode != userCode:
 		b.authURL = url
 		b.authURLTime = b.clock.Now()
+		b.authUserCode = userCode
 		// Always open the browser if the URL has changed.
 		// This includes the transition from no URL -> some URL.
 		popBrowser = true
@@ -3240,16 +3364,17 @@ func (b *LocalBackend) setAuthURL(url string) {
 	b.mu.Unlock()
 
 	if popBrowser {
-		b.popBrowserAuthNow(url, keyExpired, recipient)
+		b.popBrowserAuthNow(url, userCode, keyExpired, recipient)
 	}
 }
 
 // popBrowserAuthNow shuts down the data plane and sends the URL to the recipient's
 // [watchSession]s if the recipient is non-nil; otherwise, it sends the URL to all watchSessions.
+// userCode, if non-empty, is sent along with the URL for a device-code login.
 // keyExpired is the value of b.keyExpired upon entry and indicates
 // whether the node's key has expired.
 // It must not be called with b.mu held.
-func (b *LocalBackend) popBrowserAuthNow(url string, keyExpired bool, recipient ipnauth.Actor) {
+func (b *LocalBackend) popBrowserAuthNow(url, userCode string, keyExpired bool, recipient ipnauth.Actor) {
 	b.logf("popBrowserAuthNow(%q): url=%v, key-expired=%v, seamless-key-renewal=%v", maybeUsernameOf(recipient), url != "", keyExpired, b.seamlessRenewalEnabled())
 
 	// Deconfigure the local network data plane if:
@@ -3259,7 +3384,7 @@ func (b *LocalBackend) popBrowserAuthNow(url string, keyExpired bool, recipient
 		b.blockEngineUpdates(true)
 		b.stopEngineAndWait()
 	}
-	b.tellRecipientToBrowseToURL(url, toNotificationTarget(recipient))
+	b.tellRecipientToBrowseToURL(url, userCode, toNotificationTarget(recipient))
 	if b.State() == ipn.Running {
 		b.enterState(ipn.Starting)
 	}
@@ -3300,13 +3425,18 @@ func (b *LocalBackend) validPopBrowserURL(urlStr string) bool {
 }
 
 func (b *LocalBackend) tellClientToBrowseToURL(url string) {
-	b.tellRecipientToBrowseToURL(url, allClients)
+	b.tellRecipientToBrowseToURL(url, "", allClients)
 }
 
-// tellRecipientToBrowseToURL is like tellClientToBrowseToURL but allows specifying a recipient.
-func (b *LocalBackend) tellRecipientToBrowseToURL(url string, recipient notificationTarget) {
+// tellRecipientToBrowseToURL is like tellClientToBrowseToURL but allows specifying
+// a recipient and the code to enter at url for a device-code login, if any.
+func (b *LocalBackend) tellRecipientToBrowseToURL(url, userCode string, recipient notificationTarget) {
 	if b.validPopBrowserURL(url) {
-		b.sendTo(ipn.Notify{BrowseToURL: &url}, recipient)
+		n := ipn.Notify{BrowseToURL: &url}
+		if userCode != "" {
+			n.LoginUserCode = &userCode
+		}
+		b.sendTo(n, recipient)
 	}
 }
 
@@ -3527,10 +3657,13 @@ func (b *LocalBackend) setVIPServicesTCPPortsInterceptedLocked(svcPorts map[tail
 
 // setAtomicValuesFromPrefsLocked populates sshAtomicBool, containsViaIPFuncAtomic,
 // shouldInterceptTCPPortAtomic, and exposeRemoteWebClientAtomicBool from the prefs p,
-// which may be !Valid().
+// which may be !Valid(). It also passes the port mapping prefs to magicsock.
 func (b *LocalBackend) setAtomicValuesFromPrefsLocked(p ipn.PrefsView) {
 	b.sshAtomicBool.Store(p.Valid() && p.RunSSH() && envknob.CanSSHD())
 	b.setExposeRemoteWebClientAtomicBoolLocked(p)
+	if mc, ok := b.sys.MagicSock.GetOK(); ok {
+		mc.SetPortMapperPreferences(portMapperPrefs(p))
+	}
 
 	if !p.Valid() {
 		b.containsViaIPFuncAtomic.Store(ipset.FalseContainsIPFunc())
@@ -3629,17 +3762,33 @@ func (b *LocalBackend) StartLoginInteractive(ctx context.Context) error {
 // the control plane sends us one. Otherwise, the notification will be delivered to all
 // active [watchSession]s.
 func (b *LocalBackend) StartLoginInteractiveAs(ctx context.Context, user ipnauth.Actor) error {
+	return b.startLoginInteractive(ctx, user, controlclient.LoginInteractive)
+}
+
+// StartDeviceCodeLoginAs is like StartLoginInteractiveAs but requests a
+// device-code login, for machines without a browser: the BrowseToURL
+// notification carries a LoginUserCode, and the user completes the login by
+// entering that code at the URL from any device.
+func (b *LocalBackend) StartDeviceCodeLoginAs(ctx context.Context, user ipnauth.Actor) error {
+	return b.startLoginInteractive(ctx, user, controlclient.LoginInteractive|controlclient.LoginDeviceCode)
+}
+
+func (b *LocalBackend) startLoginInteractive(ctx context.Context, user ipnauth.Actor, flags controlclient.LoginFlags) error {
 	b.mu.Lock()
 	if b.cc == nil {
 		panic("LocalBackend.assertClient: b.cc == nil")
 	}
 	url := b.authURL
+	userCode := b.authUserCode
 	keyExpired := b.keyExpired
 	timeSinceAuthURLCreated := b.clock.Since(b.authURLTime)
 	// Only use an authURL if it was sent down from control in the last
 	// 6 days and 23 hours. Avoids using a stale URL that is no longer valid
 	// server-side. Server-side URLs expire after 7 days.
 	hasValidURL := url != "" && timeSinceAuthURLCreated < ((7*24*time.Hour)-(1*time.Hour))
+	// Nor if it's for the other kind of login.
+	deviceCode := flags&controlclient.LoginDeviceCode != 0
+	hasValidURL = hasValidURL && (userCode != "") == deviceCode
 	if !hasValidURL {
 		// A user wants to log in interactively, but we don't have a valid authURL.
 		// Remember the user who initiated the login, so that we can notify them
@@ -3649,12 +3798,12 @@ func (b *LocalBackend) StartLoginInteractiveAs(ctx context.Context, user ipnauth
 	cc := b.cc
 	b.mu.Unlock()
 
-	b.logf("StartLoginInteractiveAs(%q): url=%v", maybeUsernameOf(user), hasValidURL)
+	b.logf("StartLoginInteractiveAs(%q): url=%v, device-code=%v", maybeUsernameOf(user), hasValidURL, deviceCode)
 
 	if hasValidURL {
-		b.popBrowserAuthNow(url, keyExpired, user)
+		b.popBrowserAuthNow(url, userCode, keyExpired, user)
 	} else {
-		cc.Login(b.loginFlags | controlclient.LoginInteractive)
+		cc.Login(b.loginFlags | flags)
 	}
 	return nil
 }
@@ -3696,6 +3845,20 @@ func (b *LocalBackend) Ping(ctx context.Context, ip netip.Addr, pingType tailcfg
 	}
 }
 
+// pingSubnetRouter probes the subnet router with Tailscale IP ip for
+// b.routeFailover, returning an error if it doesn't answer before ctx is
+// done.
+func (b *LocalBackend) pingSubnetRouter(ctx context.Context, ip netip.Addr) error {
+	pr, err := b.Ping(ctx, ip, tailcfg.PingDisco, 0)
+	if err != nil {
+		return err
+	}
+	if pr.Err != "" {
+		return errors.New(pr.Err)
+	}
+	return nil
+}
+
 func (b *LocalBackend) pingPeerAPI(ctx context.Context, ip netip.Addr) (peer tailcfg.NodeView, peerBase string, err error) {
 	var zero tailcfg.NodeView
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
@@ -3836,7 +3999,18 @@ func (b *LocalBackend) isConfigLocked_Locked() bool {
 	// TODO(bradfitz,maisem): make this more fine-grained, permit changing
 	// some things if they're not explicitly set in the config. But for now
 	// (2023-10-16), just blanket disable everything.
-	return b.conf != nil && !b.conf.Parsed.Locked.EqualBool(false)
+	return b.conf != nil && (!b.conf.Parsed.Locked.EqualBool(false) || b.isConfigStrict_Locked())
+}
+
+// errConfigStrict is returned for changes that a config file in strict mode
+// doesn't permit.
+var errConfigStrict = errors.New("can't reconfigure tailscaled when using a config file in strict mode; edit the config file and reload it instead")
+
+// isConfigStrict_Locked reports whether the parsed config file is in strict
+// mode, where it's the only way to change tailscaled.
+// b.mu must be held.
+func (b *LocalBackend) isConfigStrict_Locked() bool {
+	return b.conf != nil && b.conf.Parsed.Strict.EqualBool(true)
 }
 
 func (b *LocalBackend) checkPrefsLocked(p *ipn.Prefs) error {
@@ -3863,6 +4037,15 @@ func (b *LocalBackend) checkPrefsLocked(p *ipn.Prefs) error {
 	if err := b.checkAutoUpdatePrefsLocked(p); err != nil {
 		errs = append(errs, err)
 	}
+	if err := p.PortMapping.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := ipn.ValidateRemoteDiagnostics(p.RemoteDiagnostics); err != nil {
+		errs = append(errs, err)
+	}
+	if err := ipn.ValidateAddrConflictMitigation(p.AddrConflictMitigation); err != nil {
+		errs = append(errs, err)
+	}
 	return multierr.New(errs...)
 }
 
@@ -3951,10 +4134,15 @@ func updateExitNodeUsageWarning(p ipn.PrefsView, state *netmon.State, healthTrac
 }
 
 func (b *LocalBackend) checkExitNodePrefsLocked(p *ipn.Prefs) error {
-	tryingToUseExitNode := p.ExitNodeIP.IsValid() || p.ExitNodeID != ""
+	tryingToUseExitNode := p.ExitNodeIP.IsValid() || p.ExitNodeID != "" || p.AutoExitNode != ""
 	if !tryingToUseExitNode {
 		return nil
 	}
+	if p.AutoExitNode != "" {
+		if _, err := p.AutoExitNode.Parse(); err != nil {
+			return err
+		}
+	}
 
 	if err := featureknob.CanUseExitNode(); err != nil {
 		return err
@@ -3973,6 +4161,21 @@ func (b *LocalBackend) checkFunnelEnabledLocked(p *ipn.Prefs) error {
 	return nil
 }
 
+// portMapperPrefs returns the portmapper preferences per the PortMapping
+// prefs in p, which may be !Valid().
+func portMapperPrefs(p ipn.PrefsView) portmapper.Preferences {
+	if !p.Valid() {
+		return portmapper.Preferences{}
+	}
+	pm := p.PortMapping()
+	return portmapper.Preferences{
+		Protocols:    pm.ProtocolList(),
+		Lifetime:     time.Duration(pm.LeaseSeconds) * time.Second,
+		RenewMargin:  time.Duration(pm.RenewMarginSeconds) * time.Second,
+		ExternalPort: pm.ExternalPort,
+	}
+}
+
 func (b *LocalBackend) checkAutoUpdatePrefsLocked(p *ipn.Prefs) error {
 	if p.AutoUpdate.Apply.EqualBool(true) && !clientupdate.CanAutoUpdate() {
 		return errors.New("Auto-updates are not supported on this platform.")
@@ -4014,6 +4217,8 @@ func (b *LocalBackend) SetUseExitNodeEnabled(v bool) (ipn.PrefsView, error) {
 	} else {
 		mp.ExitNodeIDSet = true
 		mp.ExitNodeID = ""
+		mp.AutoExitNodeSet = true
+		mp.AutoExitNode = ""
 		mp.InternalExitNodePriorSet = true
 		mp.InternalExitNodePrior = p0.ExitNodeID()
 	}
@@ -4052,6 +4257,12 @@ func (b *LocalBackend) EditPrefsAs(mp *ipn.MaskedPrefs, actor ipnauth.Actor) (ip
 		mp.InternalExitNodePriorSet = true
 	}
 
+	// Choosing an exit node stops choosing one automatically.
+	if (mp.ExitNodeIDSet || mp.ExitNodeIPSet) && !mp.AutoExitNodeSet {
+		mp.AutoExitNode = ""
+		mp.AutoExitNodeSet = true
+	}
+
 	// Acquire the lock before checking the profile access to prevent
 	// TOCTOU issues caused by the current profile changing between the
 	// check and the actual edit.
@@ -4166,10 +4377,20 @@ func (b *LocalBackend) setPrefsLockedOnEntry(newp *ipn.Prefs, unlock unlockOnce)
 	if oldp.Valid() {
 		newp.Persist = oldp.Persist().AsStruct() // caller isn't allowed to override this
 	}
+	// If the expression selecting the exit node changed, the last
+	// suggestion no longer applies.
+	if _, ok := autoExitNodePolicy(); !ok && newp.AutoExitNode != "" && (!oldp.Valid() || newp.AutoExitNode != oldp.AutoExitNode()) {
+		b.lastSuggestedExitNode = ""
+		if _, err := b.suggestAutoExitNodeLocked(netMap, newp.AutoExitNode); err != nil && !errors.Is(err, ErrNoPreferredDERP) {
+			b.logf("failed to select auto exit node: %v", err)
+		}
+	}
 	// applySysPolicyToPrefsLocked returns whether it updated newp,
 	// but everything in this function treats b.prefs as completely new
 	// anyway, so its return value can be ignored here.
 	applySysPolicy(newp, b.lastSuggestedExitNode, b.overrideAlwaysOn)
+	// applyAutoExitNode does likewise.
+	applyAutoExitNode(newp, b.lastSuggestedExitNode)
 	// setExitNodeID does likewise. No-op if no exit node resolution is needed.
 	setExitNodeID(newp, netMap)
 	// We do this to avoid holding the lock while doing everything else.
@@ -4379,7 +4600,7 @@ func (b *LocalBackend) peerAPIServicesLocked() (ret []tailcfg.Service) {
 		})
 	}
 	switch runtime.GOOS {
-	case "linux", "freebsd", "openbsd", "illumos", "solaris", "darwin", "windows", "android", "ios":
+	case "linux", "freebsd", "openbsd", "netbsd", "dragonfly", "illumos", "solaris", "darwin", "windows", "android", "ios":
 		// These are the platforms currently supported by
 		// net/dns/resolver/tsdns.go:Resolver.HandleExitNodeDNSQuery.
 		ret = append(ret, tailcfg.Service{
@@ -4390,6 +4611,37 @@ func (b *LocalBackend) peerAPIServicesLocked() (ret []tailcfg.Service) {
 	return ret
 }
 
+// SetRoutesStandby sets whether this node is the standby of a pair of
+// highly available subnet routers, such as the CARP backup. A standby keeps
+// its advertised subnet routes in its prefs but withholds them from
+// control, so that peers route through the other router. Exit node routes
+// are unaffected.
+func (b *LocalBackend) SetRoutesStandby(standby bool) {
+	b.mu.Lock()
+	if b.routesStandby == standby {
+		b.mu.Unlock()
+		return
+	}
+	b.routesStandby = standby
+	if b.hostinfo != nil {
+		hi := b.hostinfo.Clone()
+		b.applyPrefsToHostinfoLocked(hi, b.pm.CurrentPrefs())
+		b.hostinfo = hi
+	}
+	b.mu.Unlock()
+
+	b.logf("routes standby: %v", standby)
+	b.doSetHostinfoFilterServices()
+}
+
+// RoutesStandby reports whether advertised subnet routes are being withheld
+// by SetRoutesStandby.
+func (b *LocalBackend) RoutesStandby() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.routesStandby
+}
+
 // doSetHostinfoFilterServices calls SetHostinfo on the controlclient,
 // possibly after mangling the given hostinfo.
 //
@@ -4522,6 +4774,47 @@ func (b *LocalBackend) reconfigAppConnectorLocked(nm *netmap.NetworkMap, prefs i
 	b.appConnector.UpdateDomainsAndRoutes(domains, routes)
 }
 
+const (
+	// appConnectorRouteMaxAge is how long an app connector keeps advertising
+	// a route learned from DNS after the address was last seen in a DNS
+	// response for one of its domains.
+	appConnectorRouteMaxAge = 7 * 24 * time.Hour
+
+	// appConnectorPruneInterval is how often app connector routes older than
+	// appConnectorRouteMaxAge are pruned.
+	appConnectorPruneInterval = time.Hour
+)
+
+// pruneAppConnectorRoutesLoop periodically stops advertising app connector
+// routes that have aged out, until ctx is done.
+func (b *LocalBackend) pruneAppConnectorRoutesLoop(ctx context.Context) {
+	tick, tc := b.clock.NewTicker(appConnectorPruneInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tc:
+		}
+		b.mu.Lock()
+		appConnector := b.appConnector
+		b.mu.Unlock()
+		if appConnector != nil {
+			appConnector.PruneRoutes(appConnectorRouteMaxAge)
+		}
+	}
+}
+
+// observeLocalDNSResponse is called by the DNS resolver with each response
+// to a local query that it forwarded upstream, so that the app connector
+// also learns the addresses of its domains from lookups made through this
+// node's own resolver, not only from peers' lookups over the PeerAPI.
+func (b *LocalBackend) observeLocalDNSResponse(res []byte) {
+	if err := b.ObserveDNSResponse(res); err != nil {
+		b.logf("[v1] ObserveDNSResponse error: %v", err)
+	}
+}
+
 func (b *LocalBackend) readvertiseAppConnectorRoutes() {
 	// Note: we should never call b.appConnector methods while holding b.mu.
 	// This can lead to a deadlock, like
@@ -4566,6 +4859,7 @@ func (b *LocalBackend) authReconfig() {
 	prefs := b.pm.CurrentPrefs()
 	nm := b.netMap
 	hasPAC := b.prevIfState.HasPAC()
+	ifState := b.prevIfState
 	disableSubnetsIfPAC := nm.HasCap(tailcfg.NodeAttrDisableSubnetsIfPAC)
 	userDialUseRoutes := nm.HasCap(tailcfg.NodeAttrUserDialUseRoutes)
 	dohURL, dohURLOK := exitNodeCanProxyDNS(nm, b.peers, prefs.ExitNodeID())
@@ -4592,6 +4886,7 @@ func (b *LocalBackend) authReconfig() {
 		b.logf("[v1] authReconfig: skipping because !WantRunning.")
 		return
 	}
+	updateAddrConflictWarning(prefs, ifState, b.health)
 
 	var flags netmap.WGConfigFlags
 	if prefs.RouteAll() {
@@ -4618,6 +4913,9 @@ func (b *LocalBackend) authReconfig() {
 		b.logf("wgcfg: %v", err)
 		return
 	}
+	if b.routeFailover != nil {
+		b.routeFailover.apply(cfg)
+	}
 
 	oneCGNATRoute := shouldUseOneCGNATRoute(b.logf, b.sys.ControlKnobs(), version.OS())
 	rcfg := b.routerConfig(cfg, prefs, oneCGNATRoute)
@@ -4872,6 +5170,15 @@ func (b *LocalBackend) SetVarRoot(dir string) {
 	b.varRoot = dir
 }
 
+// SetCertStore sets where TLS certificates and their keys for HTTPS and
+// Funnel are stored, instead of under the var root or in the state store.
+// The ACME account key is still stored there.
+//
+// It should only be called before the LocalBackend is used.
+func (b *LocalBackend) SetCertStore(cs ipn.CertStore) {
+	b.certStoreOverride = cs
+}
+
 // SetLogFlusher sets a func to be called to flush log uploads.
 //
 // It should only be called before the LocalBackend is used.
@@ -5006,6 +5313,17 @@ func (b *LocalBackend) initPeerAPIListener() {
 			SendFileNotify: b.sendFileNotify,
 		}.New(),
 	}
+	if c := b.taildropAutoAccept; c.Dir != "" && b.directFileRoot == "" {
+		ps.autoAccept = c
+		ps.autoTaildrop = taildrop.ManagerOptions{
+			Logf:           b.logf,
+			Clock:          tstime.DefaultClock{Clock: b.clock},
+			State:          b.store,
+			Dir:            c.Dir,
+			DirectFileMode: true,
+			SendFileNotify: b.sendFileNotify,
+		}.New()
+	}
 	if dm, ok := b.sys.DNSManager.GetOK(); ok {
 		ps.resolver = dm.Resolver()
 	}
@@ -5122,6 +5440,25 @@ func peerRoutes(logf logger.Logf, peers []wgcfg.Peer, cgnatThreshold int) (route
 	return routes
 }
 
+// subnetRouteMetrics returns the routes among routes to peers' advertised
+// subnets, mapped to metric: those other than default routes and routes
+// into Tailscale's own address ranges.
+func subnetRouteMetrics(routes []netip.Prefix, metric uint32) map[netip.Prefix]uint32 {
+	ret := map[netip.Prefix]uint32{}
+	for _, r := range routes {
+		if r.Bits() == 0 || r.Overlaps(tsaddr.CGNATRange()) || r.Overlaps(tsaddr.TailscaleULARange()) {
+			continue
+		}
+		ret[r] = metric
+	}
+	return ret
+}
+
+// acceptTunRouterAdvertisements is whether to leave the Tailscale
+// interface accepting IPv6 router advertisements, as the system would by
+// default. Tailscale never sends any, so any that arrive over it are rogue.
+var acceptTunRouterAdvertisements = envknob.RegisterBool("TS_DEBUG_TUN_ACCEPT_RA")
+
 // routerConfig produces a router.Config from a wireguard config and IPN prefs.
 func (b *LocalBackend) routerConfig(cfg *wgcfg.Config, prefs ipn.PrefsView, oneCGNATRoute bool) *router.Config {
 	singleRouteThreshold := 10_000
@@ -5131,6 +5468,7 @@ func (b *LocalBackend) routerConfig(cfg *wgcfg.Config, prefs ipn.PrefsView, oneC
 
 	b.mu.Lock()
 	netfilterKind := b.capForcedNetfilter // protected by b.mu
+	addrConflicts := b.prevIfState.TailscaleRangeConflicts()
 	b.mu.Unlock()
 
 	if prefs.NetfilterKind() != "" {
@@ -5156,6 +5494,24 @@ func (b *LocalBackend) routerConfig(cfg *wgcfg.Config, prefs ipn.PrefsView, oneC
 		NetfilterMode:     prefs.NetfilterMode(),
 		Routes:            peerRoutes(b.logf, cfg.Peers, singleRouteThreshold),
 		NetfilterKind:     netfilterKind,
+
+		IgnoreRouterAdvertisements: !acceptTunRouterAdvertisements(),
+	}
+
+	// If a local network uses Tailscale's address ranges, work around it
+	// as configured.
+	if len(addrConflicts) > 0 {
+		switch prefs.AddrConflictMitigation() {
+		case ipn.AddrConflictMitigationSNAT:
+			rs.SNATSubnetRoutes = true
+			fallthrough
+		case ipn.AddrConflictMitigationRoutes:
+			rs.Routes = narrowTailscaleRoutes(rs.Routes, cfg.Peers)
+		}
+	}
+
+	if m := prefs.SubnetRouteMetric(); m != 0 {
+		rs.RouteMetrics = subnetRouteMetrics(rs.Routes, m)
 	}
 
 	if distro.Get() == distro.Synology {
@@ -5193,7 +5549,7 @@ func (b *LocalBackend) routerConfig(cfg *wgcfg.Config, prefs ipn.PrefsView, oneC
 			b.logf("failed to discover interface ips: %v", err)
 		}
 		switch runtime.GOOS {
-		case "linux", "windows", "darwin", "ios", "android":
+		case "linux", "windows", "darwin", "ios", "android", "freebsd", "netbsd":
 			rs.LocalRoutes = internalIPs // unconditionally allow access to guest VM networks
 			if prefs.ExitNodeAllowLANAccess() {
 				rs.LocalRoutes = append(rs.LocalRoutes, externalIPs...)
@@ -5239,6 +5595,9 @@ func (b *LocalBackend) applyPrefsToHostinfoLocked(hi *tailcfg.Hostinfo, prefs ip
 		hi.Hostname = h
 	}
 	hi.RoutableIPs = prefs.AdvertiseRoutes().AsSlice()
+	if b.routesStandby {
+		hi.RoutableIPs = tsaddr.FilterPrefixesCopy(prefs.AdvertiseRoutes(), tsaddr.IsExitRoute)
+	}
 	hi.RequestTags = prefs.AdvertiseTags().AsSlice()
 	hi.ShieldsUp = prefs.ShieldsUp()
 	hi.AllowsUpdate = envknob.AllowsRemoteUpdate() || prefs.AutoUpdate().Apply.EqualBool(true)
@@ -5315,6 +5674,13 @@ func (b *LocalBackend) enterStateLockedOnEntry(newState ipn.State, unlock unlock
 			b.captiveCtx, b.captiveCancel = context.WithCancel(b.ctx)
 			b.goTracker.Go(func() { b.checkCaptivePortalLoop(b.captiveCtx) })
 		}
+
+		// Likewise, keep the node's HTTPS certs renewed in the background.
+		if b.certRenewCancel == nil {
+			var ctx context.Context
+			ctx, b.certRenewCancel = context.WithCancel(b.ctx)
+			b.goTracker.Go(func() { b.certRenewLoop(ctx) })
+		}
 	} else if oldState == ipn.Running {
 		// Transitioning away from running.
 		b.closePeerAPIListenersLocked()
@@ -5328,6 +5694,10 @@ func (b *LocalBackend) enterStateLockedOnEntry(newState ipn.State, unlock unlock
 			// that we always have a (canceled) context to wait on
 			// in onHealthChange.
 		}
+		if b.certRenewCancel != nil {
+			b.certRenewCancel()
+			b.certRenewCancel = nil
+		}
 	}
 	b.pauseOrResumeControlClientLocked()
 
@@ -5384,6 +5754,28 @@ func (b *LocalBackend) enterStateLockedOnEntry(newState ipn.State, unlock unlock
 	}
 }
 
+// cloudAuthKey returns an auth key obtained in exchange for the cloud VM's
+// instance identity, as configured by the config file's CloudAuth, if the
+// node needs to log in. Otherwise, or on failure, it returns the empty
+// string. It must be called without b.mu held, as it makes network requests.
+func (b *LocalBackend) cloudAuthKey() string {
+	b.mu.Lock()
+	conf := b.conf
+	needKey := b.state != ipn.Running && !b.hasNodeKeyLocked()
+	b.mu.Unlock()
+	if !needKey || conf == nil || conf.Parsed.CloudAuth == nil || conf.Parsed.AuthKey != nil {
+		return ""
+	}
+	hostname, _ := os.Hostname()
+	key, err := cloudauth.GetAuthKey(b.ctx, conf.Parsed.CloudAuth, hostname)
+	if err != nil {
+		b.logf("Start: %v", err)
+		return ""
+	}
+	b.logf("Start: got auth key from cloud identity exchange, len=%v", len(key))
+	return key
+}
+
 func (b *LocalBackend) hasNodeKeyLocked() bool {
 	// we can't use b.Prefs(), because it strips the keys, oops!
 	p := b.pm.CurrentPrefs()
@@ -5612,6 +6004,7 @@ func (b *LocalBackend) resetControlClientLocked() controlclient.Client {
 func (b *LocalBackend) resetAuthURLLocked() {
 	b.authURL = ""
 	b.authURLTime = time.Time{}
+	b.authUserCode = ""
 	b.authActor = nil
 }
 
@@ -5668,6 +6061,18 @@ func (b *LocalBackend) ShouldHandleViaIP(ip netip.Addr) bool {
 // Logout logs out the current profile, if any, and waits for the logout to
 // complete.
 func (b *LocalBackend) Logout(ctx context.Context) error {
+	b.mu.Lock()
+	strict := b.isConfigStrict_Locked()
+	b.mu.Unlock()
+	if strict {
+		return errConfigStrict
+	}
+	return b.logout(ctx)
+}
+
+// logout is Logout without the check of the config file's strict mode, for
+// logging out ephemeral nodes on shutdown.
+func (b *LocalBackend) logout(ctx context.Context) error {
 	unlock := b.lockAndGetUnlock()
 	defer unlock()
 
@@ -5807,6 +6212,16 @@ func (b *LocalBackend) setNetMapLocked(nm *netmap.NetworkMap) {
 	}
 	b.netMap = nm
 	b.updatePeersFromNetmapLocked(nm)
+	if b.routeFailover != nil {
+		b.routeFailover.setNetMap(nm)
+	}
+	if b.keyExpiry != nil {
+		var expiry time.Time
+		if nm != nil {
+			expiry = nm.Expiry
+		}
+		b.keyExpiry.setExpiry(expiry)
+	}
 	if login != b.activeLogin {
 		b.logf("active login: %v", login)
 		b.activeLogin = login
@@ -5895,6 +6310,7 @@ func (b *LocalBackend) setNetMapLocked(nm *netmap.NetworkMap) {
 }
 
 func (b *LocalBackend) updatePeersFromNetmapLocked(nm *netmap.NetworkMap) {
+	b.sortedPeers, b.sortedShared = nil, false
 	if nm == nil {
 		b.peers = nil
 		return
@@ -6083,7 +6499,8 @@ func (b *LocalBackend) setDebugLogsByCapabilityLocked(nm *netmap.NetworkMap) {
 	}
 }
 
-// reloadServeConfigLocked reloads the serve config from the store or resets the
+// reloadServeConfigLocked reloads the serve config from the store (or from the
+// config file, if it has one) or resets the
 // serve config to nil if not logged in. The "changed" parameter, when false, instructs
 // the method to only run the reset-logic and not reload the store from memory to ensure
 // foreground sessions are not removed if they are not saved on disk.
@@ -6097,9 +6514,16 @@ func (b *LocalBackend) reloadServeConfigLocked(prefs ipn.PrefsView) {
 	}
 
 	confKey := ipn.ServeConfigKey(b.pm.CurrentProfile().ID())
-	// TODO(maisem,bradfitz): prevent reading the config from disk
-	// if the profile has not changed.
-	confj, err := b.store.ReadState(confKey)
+	var confj []byte
+	var err error
+	if b.conf != nil && b.conf.Parsed.ServeConfigTemp != nil {
+		// The config file's serve config replaces the stored one.
+		confj, err = json.Marshal(b.conf.Parsed.ServeConfigTemp)
+	} else {
+		// TODO(maisem,bradfitz): prevent reading the config from disk
+		// if the profile has not changed.
+		confj, err = b.store.ReadState(confKey)
+	}
 	if err != nil {
 		b.lastServeConfJSON = mem.B(nil)
 		b.serveConfig = ipn.ServeConfigView{}
@@ -7055,6 +7479,9 @@ func (b *LocalBackend) SwitchProfile(profile ipn.ProfileID) error {
 	unlock := b.lockAndGetUnlock()
 	defer unlock()
 
+	if b.isConfigStrict_Locked() {
+		return errConfigStrict
+	}
 	oldControlURL := b.pm.CurrentPrefs().ControlURLOrDefault()
 	if err := b.pm.SwitchProfile(profile); err != nil {
 		return err
@@ -7167,6 +7594,9 @@ func (b *LocalBackend) DeleteProfile(p ipn.ProfileID) error {
 	unlock := b.lockAndGetUnlock()
 	defer unlock()
 
+	if b.isConfigStrict_Locked() {
+		return errConfigStrict
+	}
 	needToRestart := b.pm.CurrentProfile().ID() == p
 	if err := b.pm.DeleteProfile(p); err != nil {
 		if err == errProfileNotFound {
@@ -7193,6 +7623,9 @@ func (b *LocalBackend) NewProfile() error {
 	unlock := b.lockAndGetUnlock()
 	defer unlock()
 
+	if b.isConfigStrict_Locked() {
+		return errConfigStrict
+	}
 	b.pm.NewProfile()
 
 	// The new profile doesn't yet have a ControlURL because it hasn't been
@@ -7309,8 +7742,9 @@ func (b *LocalBackend) DoSelfUpdate() {
 	}
 }
 
-// ObserveDNSResponse passes a DNS response from the PeerAPI DNS server to the
-// App Connector to enable route discovery.
+// ObserveDNSResponse passes a DNS response from the DNS forwarder, for a local
+// query or a peer's query over the PeerAPI, to the App Connector to enable
+// route discovery.
 func (b *LocalBackend) ObserveDNSResponse(res []byte) error {
 	var appConnector *appc.AppConnector
 	b.mu.Lock()
@@ -7505,6 +7939,14 @@ func mayDeref[T any](p *T) (v T) {
 //
 // b.mu.lock() must be held.
 func (b *LocalBackend) suggestExitNodeLocked(netMap *netmap.NetworkMap) (response apitype.ExitNodeSuggestionResponse, err error) {
+	return b.suggestAutoExitNodeLocked(netMap, b.autoExitNodeLocked())
+}
+
+// suggestAutoExitNodeLocked is like suggestExitNodeLocked, but only suggests
+// exit nodes matching expr, if non-empty.
+//
+// b.mu.lock() must be held.
+func (b *LocalBackend) suggestAutoExitNodeLocked(netMap *netmap.NetworkMap, expr ipn.ExitNodeExpression) (response apitype.ExitNodeSuggestionResponse, err error) {
 	// netMap is an optional netmap to use that overrides b.netMap (needed for SetControlClientStatus before b.netMap is updated). If netMap is nil, then b.netMap is used.
 	if netMap == nil {
 		netMap = b.netMap
@@ -7512,7 +7954,14 @@ func (b *LocalBackend) suggestExitNodeLocked(netMap *netmap.NetworkMap) (respons
 	lastReport := b.MagicConn().GetLastNetcheckReport(b.ctx)
 	prevSuggestion := b.lastSuggestedExitNode
 
-	res, err := suggestExitNode(lastReport, netMap, prevSuggestion, randomRegion, randomNode, b.getAllowedSuggestions())
+	var filter ipn.ExitNodeFilter
+	if expr != "" {
+		if filter, err = expr.Parse(); err != nil {
+			return response, err
+		}
+	}
+
+	res, err := suggestExitNode(lastReport, netMap, prevSuggestion, randomRegion, randomNode, b.getAllowedSuggestions(), filter)
 	if err != nil {
 		return res, err
 	}
@@ -7567,7 +8016,7 @@ func fillAllowedSuggestions() set.Set[tailcfg.StableNodeID] {
 	return s
 }
 
-func suggestExitNode(report *netcheck.Report, netMap *netmap.NetworkMap, prevSuggestion tailcfg.StableNodeID, selectRegion selectRegionFunc, selectNode selectNodeFunc, allowList set.Set[tailcfg.StableNodeID]) (res apitype.ExitNodeSuggestionResponse, err error) {
+func suggestExitNode(report *netcheck.Report, netMap *netmap.NetworkMap, prevSuggestion tailcfg.StableNodeID, selectRegion selectRegionFunc, selectNode selectNodeFunc, allowList set.Set[tailcfg.StableNodeID], filter ipn.ExitNodeFilter) (res apitype.ExitNodeSuggestionResponse, err error) {
 	if report == nil || report.PreferredDERP == 0 || netMap == nil || netMap.DERPMap == nil {
 		return res, ErrNoPreferredDERP
 	}
@@ -7579,6 +8028,9 @@ func suggestExitNode(report *netcheck.Report, netMap *netmap.NetworkMap, prevSug
 		if allowList != nil && !allowList.Contains(peer.StableID()) {
 			continue
 		}
+		if !filter.Match(peer, netMap.DERPMap) {
+			continue
+		}
 		if peer.CapMap().Contains(tailcfg.NodeAttrSuggestExitNode) && tsaddr.ContainsExitRoutes(peer.AllowedIPs()) {
 			candidates = append(candidates, peer)
 		}
@@ -7772,8 +8224,49 @@ func longLatDistance(fromLat, fromLong, toLat, toLong float64) float64 {
 
 // shouldAutoExitNode checks for the auto exit node MDM policy.
 func shouldAutoExitNode() bool {
+	_, ok := autoExitNodePolicy()
+	return ok
+}
+
+// autoExitNodePolicy returns the expression selecting exit nodes in the
+// [syspolicy.ExitNodeID] policy setting, if it has the form
+// "auto:<expression>".
+func autoExitNodePolicy() (_ ipn.ExitNodeExpression, ok bool) {
 	exitNodeIDStr, _ := syspolicy.GetString(syspolicy.ExitNodeID, "")
-	return exitNodeIDStr == "auto:any"
+	return ipn.ParseAutoExitNodeString(exitNodeIDStr)
+}
+
+// autoExitNodeLocked returns the expression selecting the exit nodes to
+// choose from automatically, from the [syspolicy.ExitNodeID] policy setting
+// or else the current prefs, or "" if the exit node isn't chosen
+// automatically.
+//
+// b.mu must be held.
+func (b *LocalBackend) autoExitNodeLocked() ipn.ExitNodeExpression {
+	if expr, ok := autoExitNodePolicy(); ok {
+		return expr
+	}
+	if prefs := b.pm.CurrentPrefs(); prefs.Valid() {
+		return prefs.AutoExitNode()
+	}
+	return ""
+}
+
+// applyAutoExitNode sets prefs.ExitNodeID to lastSuggestedExitNode if
+// prefs.AutoExitNode is set, or to a value matching no node if there's no
+// suggestion, so that traffic is blackholed rather than escaping. It
+// reports whether prefs was changed.
+func applyAutoExitNode(prefs *ipn.Prefs, lastSuggestedExitNode tailcfg.StableNodeID) (anyChange bool) {
+	if prefs.AutoExitNode == "" {
+		return false
+	}
+	exitNodeID := cmp.Or(lastSuggestedExitNode, tailcfg.StableNodeID(prefs.AutoExitNode.AutoString()))
+	if prefs.ExitNodeID != exitNodeID || prefs.ExitNodeIP.IsValid() {
+		anyChange = true
+	}
+	prefs.ExitNodeID = exitNodeID
+	prefs.ExitNodeIP = netip.Addr{}
+	return anyChange
 }
 
 // startAutoUpdate triggers an auto-update attempt. The actual update happens
@@ -289,6 +289,29 @@ func TestPeerRoutes(t *testing.T) {
 	}
 }
 
+func TestSubnetRouteMetrics(t *testing.T) {
+	pp := netip.MustParsePrefix
+	routes := []netip.Prefix{
+		pp("0.0.0.0/0"),
+		pp("::/0"),
+		pp("10.0.0.0/8"),
+		pp("100.64.0.0/10"),
+		pp("100.101.102.103/32"),
+		pp("192.168.1.0/24"),
+		pp("2001:db8::/32"),
+		pp("fd7a:115c:a1e0::/48"),
+	}
+	got := subnetRouteMetrics(routes, 100)
+	want := map[netip.Prefix]uint32{
+		pp("10.0.0.0/8"):     100,
+		pp("192.168.1.0/24"): 100,
+		pp("2001:db8::/32"):  100,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("subnetRouteMetrics = %v; want %v", got, want)
+	}
+}
+
 func TestPeerAPIBase(t *testing.T) {
 	tests := []struct {
 		name string
@@ -975,6 +998,7 @@ func TestUpdateNetmapDelta(t *testing.T) {
 		b.netMap.Peers = append(b.netMap.Peers, (&tailcfg.Node{ID: (tailcfg.NodeID(i) + 1)}).View())
 	}
 	b.updatePeersFromNetmapLocked(b.netMap)
+	sortedBefore := b.sortedPeersLocked()
 
 	someTime := time.Unix(123, 0)
 	muts, ok := netmap.MutationsFromMapResponse(&tailcfg.MapResponse{
@@ -1034,6 +1058,46 @@ func TestUpdateNetmapDelta(t *testing.T) {
 			t.Errorf("netmap.Peer %v wrong.\n got: %v\nwant: %v", want.ID, logger.AsJSON(got), logger.AsJSON(want))
 		}
 	}
+
+	// The sorted peers slice must reflect the mutations without having
+	// modified the slice previously handed out.
+	sorted := b.sortedPeersLocked()
+	if len(sorted) != 5 {
+		t.Fatalf("sortedPeersLocked() has %d peers, want 5", len(sorted))
+	}
+	for i, p := range sorted {
+		if p.ID() != tailcfg.NodeID(i+1) {
+			t.Errorf("sorted[%d].ID = %v, want %v", i, p.ID(), i+1)
+		}
+		if p != b.peers[p.ID()] {
+			t.Errorf("sorted[%d] doesn't match b.peers", i)
+		}
+	}
+	if sortedBefore[0].HomeDERP() != 0 {
+		t.Errorf("delta mutated previously returned sorted peers slice")
+	}
+
+	// Once copied, and until it's handed out again, later deltas patch
+	// the slice in place rather than copying all the peers again.
+	b.sortedShared = false
+	muts, ok = netmap.MutationsFromMapResponse(&tailcfg.MapResponse{
+		PeersChangedPatch: []*tailcfg.PeerChange{{NodeID: 5, DERPRegion: 2}},
+	}, someTime)
+	if !ok {
+		t.Fatal("netmap.MutationsFromMapResponse failed")
+	}
+	if !b.updateNetmapDeltaLocked(muts) {
+		t.Fatalf("updateNetmapDeltaLocked() = false, want true")
+	}
+	if &b.sortedPeers[0] != &sorted[0] {
+		t.Errorf("delta copied the sorted peers slice when it wasn't shared")
+	}
+	if got := sorted[4].HomeDERP(); got != 2 {
+		t.Errorf("sorted[4].HomeDERP = %v, want 2", got)
+	}
+	if b.netMap.Peers[4].HomeDERP() != 0 {
+		t.Errorf("delta mutated the netmap's peers slice")
+	}
 }
 
 // tests WhoIs and indirectly that setNetMapLocked updates b.nodeByAddr correctly.
@@ -3819,7 +3883,7 @@ func TestSuggestExitNode(t *testing.T) {
 				allowList = set.SetOf(tt.allowPolicy)
 			}
 
-			got, err := suggestExitNode(tt.lastReport, tt.netMap, tt.lastSuggestion, selectRegion, selectNode, allowList)
+			got, err := suggestExitNode(tt.lastReport, tt.netMap, tt.lastSuggestion, selectRegion, selectNode, allowList, ipn.ExitNodeFilter{})
 			if got.Name != tt.wantName {
 				t.Errorf("name=%v, want %v", got.Name, tt.wantName)
 			}
@@ -4007,6 +4071,11 @@ func TestShouldAutoExitNode(t *testing.T) {
 			exitNodeIDPolicyValue: "auto:any",
 			expectedBool:          true,
 		},
+		{
+			name:                  "auto expression",
+			exitNodeIDPolicyValue: "auto:online,tag:exit",
+			expectedBool:          true,
+		},
 		{
 			name:                  "no auto prefix",
 			exitNodeIDPolicyValue: "foo",
@@ -4046,6 +4115,56 @@ func TestShouldAutoExitNode(t *testing.T) {
 	}
 }
 
+func TestApplyAutoExitNode(t *testing.T) {
+	tests := []struct {
+		name          string
+		prefs         ipn.Prefs
+		lastSuggested tailcfg.StableNodeID
+		wantID        tailcfg.StableNodeID
+		wantChange    bool
+	}{
+		{
+			name:          "not-auto",
+			prefs:         ipn.Prefs{ExitNodeID: "n1"},
+			lastSuggested: "n2",
+			wantID:        "n1",
+		},
+		{
+			name:          "suggestion",
+			prefs:         ipn.Prefs{AutoExitNode: "tag:exit", ExitNodeIP: netip.MustParseAddr("100.64.1.1")},
+			lastSuggested: "n2",
+			wantID:        "n2",
+			wantChange:    true,
+		},
+		{
+			name:          "unchanged",
+			prefs:         ipn.Prefs{AutoExitNode: "tag:exit", ExitNodeID: "n2"},
+			lastSuggested: "n2",
+			wantID:        "n2",
+		},
+		{
+			name:       "no-suggestion",
+			prefs:      ipn.Prefs{AutoExitNode: "tag:exit", ExitNodeID: "n2"},
+			wantID:     "auto:tag:exit",
+			wantChange: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefs := tt.prefs
+			if got := applyAutoExitNode(&prefs, tt.lastSuggested); got != tt.wantChange {
+				t.Errorf("applyAutoExitNode = %v, want %v", got, tt.wantChange)
+			}
+			if prefs.ExitNodeID != tt.wantID {
+				t.Errorf("ExitNodeID = %q, want %q", prefs.ExitNodeID, tt.wantID)
+			}
+			if prefs.AutoExitNode != "" && prefs.ExitNodeIP.IsValid() {
+				t.Errorf("ExitNodeIP = %v, want none", prefs.ExitNodeIP)
+			}
+		})
+	}
+}
+
 func TestEnableAutoUpdates(t *testing.T) {
 	lb := newTestLocalBackend(t)
 
@@ -4772,6 +4891,47 @@ func TestConfigFileReload(t *testing.T) {
 	}
 }
 
+// TestConfigFileReloadDeclarative tests that reloading the config file
+// reverts the settings removed from it, and that a strict config rejects
+// changes made other than through the file.
+func TestConfigFileReloadDeclarative(t *testing.T) {
+	cfg1 := `{"Version": "alpha0", "Hostname": "foo", "AcceptDNS": false, "Strict": true}`
+	f := filepath.Join(t.TempDir(), "cfg")
+	must.Do(os.WriteFile(f, []byte(cfg1), 0600))
+	sys := new(tsd.System)
+	sys.InitialConfig = must.Get(conffile.Load(f))
+	lb := newTestLocalBackendWithSys(t, sys)
+	must.Do(lb.Start(ipn.Options{}))
+
+	if lb.Prefs().CorpDNS() {
+		t.Fatal("CorpDNS set despite AcceptDNS false")
+	}
+	if err := lb.NewProfile(); err != errConfigStrict {
+		t.Errorf("NewProfile error = %v; want %v", err, errConfigStrict)
+	}
+	if err := lb.Logout(context.Background()); err != errConfigStrict {
+		t.Errorf("Logout error = %v; want %v", err, errConfigStrict)
+	}
+	if _, err := lb.EditPrefs(&ipn.MaskedPrefs{HostnameSet: true, Prefs: ipn.Prefs{Hostname: "baz"}}); err == nil {
+		t.Error("EditPrefs succeeded with a strict config")
+	}
+
+	cfg2 := `{"Version": "alpha0", "Hostname": "bar"}`
+	must.Do(os.WriteFile(f, []byte(cfg2), 0600))
+	if !must.Get(lb.ReloadConfig()) {
+		t.Fatal("reload failed")
+	}
+	if got := lb.Prefs().Hostname(); got != "bar" {
+		t.Errorf("Hostname = %q; want bar", got)
+	}
+	if !lb.Prefs().CorpDNS() {
+		t.Error("CorpDNS not reset after AcceptDNS was removed from the config")
+	}
+	if err := lb.NewProfile(); err == errConfigStrict {
+		t.Error("NewProfile rejected after Strict was removed from the config")
+	}
+}
+
 func TestGetVIPServices(t *testing.T) {
 	tests := []struct {
 		name        string
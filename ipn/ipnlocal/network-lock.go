@@ -14,6 +14,7 @@
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
 	"net/netip"
 	"os"
@@ -768,6 +769,42 @@ func (b *LocalBackend) NetworkLockSign(nodeKey key.NodePublic, rotationPublic []
 	return nil
 }
 
+// NetworkLockSubmitSignature submits a node-key signature made elsewhere,
+// such as by a tailnet lock key kept on an offline machine, to the control
+// plane, having checked that it authorizes its node key.
+func (b *LocalBackend) NetworkLockSubmitSignature(sig tkatype.MarshaledSignature) error {
+	var nks tka.NodeKeySignature
+	if err := nks.Unserialize(sig); err != nil {
+		return fmt.Errorf("decoding signature: %w", err)
+	}
+	var nodeKey key.NodePublic
+	if err := nodeKey.UnmarshalBinary(nks.Pubkey); err != nil {
+		return fmt.Errorf("decoding signed node-key: %w", err)
+	}
+
+	b.mu.Lock()
+	if b.tka == nil {
+		b.mu.Unlock()
+		return errNetworkLockNotActive
+	}
+	err := b.tka.authority.NodeKeyAuthorized(nodeKey, sig)
+	var ourNodeKey key.NodePublic
+	if p := b.pm.CurrentPrefs(); p.Valid() && p.Persist().Valid() {
+		ourNodeKey = p.Persist().PublicNodeKey()
+	}
+	b.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("signature doesn't authorize %v: %w", nodeKey, err)
+	}
+	if ourNodeKey.IsZero() {
+		return errors.New("no node-key: is tailscale logged in?")
+	}
+
+	b.logf("Submitting network-lock signature for %v made elsewhere to control plane", nodeKey)
+	_, err = b.tkaSubmitSignature(ourNodeKey, sig)
+	return err
+}
+
 // NetworkLockModify adds and/or removes keys in the tailnet's key authority.
 func (b *LocalBackend) NetworkLockModify(addKeys, removeKeys []tka.Key) (err error) {
 	defer func() {
@@ -917,6 +954,51 @@ func (b *LocalBackend) NetworkLockLog(maxEntries int) ([]ipnstate.NetworkLockUpd
 	return out, nil
 }
 
+// NetworkLockExportLog returns an export of the tailnet key authority's
+// chain of updates, with summaries of the signatures of this node and its
+// peers, signed by this node's tailnet lock key.
+func (b *LocalBackend) NetworkLockExportLog() (*tka.LogExport, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.tka == nil {
+		return nil, errNetworkLockNotActive
+	}
+	var nlPriv key.NLPrivate
+	if p := b.pm.CurrentPrefs(); p.Valid() && p.Persist().Valid() {
+		nlPriv = p.Persist().NetworkLockKey()
+	}
+	if nlPriv.IsZero() {
+		return nil, errors.New("no network-lock key")
+	}
+
+	// Only nodes whose signatures are valid now can be verified against
+	// the exported chain.
+	var nodes []tka.NodeSummary
+	addNode := func(n tailcfg.NodeView) {
+		if !n.Valid() || n.KeySignature().Len() == 0 {
+			return
+		}
+		sig := n.KeySignature().AsSlice()
+		if b.tka.authority.NodeKeyAuthorized(n.Key(), sig) != nil {
+			return
+		}
+		nodes = append(nodes, tka.NodeSummary{
+			Name:      n.Name(),
+			NodeKey:   n.Key(),
+			Signature: sig,
+			Created:   n.Created().UTC(),
+		})
+	}
+	if b.netMap != nil {
+		addNode(b.netMap.SelfNode)
+	}
+	for _, id := range slices.Sorted(maps.Keys(b.peers)) {
+		addNode(b.peers[id])
+	}
+	return b.tka.authority.ExportLog(b.tka.storage, nodes, nlPriv)
+}
+
 // NetworkLockAffectedSigs returns the signatures which would be invalidated
 // by removing trust in the specified KeyID.
 func (b *LocalBackend) NetworkLockAffectedSigs(keyID tkatype.KeyID) ([]tkatype.MarshaledSignature, error) {
@@ -1062,6 +1144,98 @@ func (b *LocalBackend) NetworkLockSubmitRecoveryAUM(aum *tka.AUM) error {
 	return err
 }
 
+// NetworkLockGenerateRotationAUM generates an AUM which trusts addKeys,
+// stops trusting removeKeys and, if disablementValues is non-nil, replaces
+// the disablement values, signed by this node's tailnet lock key.
+//
+// As it can change anything about the tailnet key authority, the AUM must be
+// co-signed by enough trusted keys (see NetworkLockCosignRecoveryAUM) to
+// make up a quorum before NetworkLockSubmitRotationAUM accepts it.
+func (b *LocalBackend) NetworkLockGenerateRotationAUM(addKeys []tka.Key, removeKeys []tkatype.KeyID, disablementValues [][]byte) (*tka.AUM, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tka == nil {
+		return nil, errNetworkLockNotActive
+	}
+	var nlPriv key.NLPrivate
+	if p := b.pm.CurrentPrefs(); p.Valid() && p.Persist().Valid() {
+		nlPriv = p.Persist().NetworkLockKey()
+	}
+	if nlPriv.IsZero() {
+		return nil, errMissingNetmap
+	}
+	if !b.tka.authority.KeyTrusted(nlPriv.KeyID()) {
+		return nil, errors.New("this node does not have a trusted tailnet lock key")
+	}
+
+	aum, err := b.tka.authority.MakeRotation(addKeys, removeKeys, disablementValues)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sign it ourselves.
+	aum.Signatures, err = nlPriv.SignAUM(aum.SigHash())
+	if err != nil {
+		return nil, fmt.Errorf("signing failed: %w", err)
+	}
+
+	return aum, nil
+}
+
+// NetworkLockSubmitRotationAUM submits a rotation AUM, as generated by
+// NetworkLockGenerateRotationAUM, to the control plane, having checked that
+// it still follows the current head and is signed by trusted keys holding a
+// majority of the votes.
+//
+// Pre-auth keys don't count towards the quorum, as nobody holds them to
+// co-sign with.
+func (b *LocalBackend) NetworkLockSubmitRotationAUM(aum *tka.AUM) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tka == nil {
+		return errNetworkLockNotActive
+	}
+	var ourNodeKey key.NodePublic
+	if p := b.pm.CurrentPrefs(); p.Valid() && p.Persist().Valid() && !p.Persist().PrivateNodeKey().IsZero() {
+		ourNodeKey = p.Persist().PublicNodeKey()
+	}
+	if ourNodeKey.IsZero() {
+		return errors.New("no node-key: is tailscale logged in?")
+	}
+
+	signed, err := b.tka.authority.SignedVotes(aum)
+	if err != nil {
+		return fmt.Errorf("invalid rotation AUM: %w", err)
+	}
+	var total uint
+	for _, k := range b.tka.authority.Keys() {
+		if k.Meta["purpose"] == "pre-auth key" {
+			continue
+		}
+		total += k.Votes
+	}
+	if signed*2 <= total {
+		return fmt.Errorf("rotation AUM is signed by keys with %d of %d votes; it needs co-signing by keys with more than half", signed, total)
+	}
+
+	head := b.tka.authority.Head()
+	b.mu.Unlock()
+	resp, err := b.tkaDoSyncSend(ourNodeKey, head, []tka.AUM{*aum}, true)
+	b.mu.Lock()
+	if err != nil {
+		return err
+	}
+
+	var controlHead tka.AUMHash
+	if err := controlHead.UnmarshalText([]byte(resp.Head)); err != nil {
+		return err
+	}
+	if controlHead != aum.Hash() {
+		return errors.New("central tka head differs from submitted AUM, try again")
+	}
+	return nil
+}
+
 var tkaSuffixEncoder = base64.RawStdEncoding
 
 // NetworkLockWrapPreauthKey wraps a pre-auth key with information to
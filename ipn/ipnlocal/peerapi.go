@@ -4,6 +4,7 @@
 package ipnlocal
 
 import (
+	"cmp"
 	"context"
 	"encoding/base64"
 	"encoding/json"
@@ -65,6 +66,11 @@ type peerAPIServer struct {
 	resolver peerDNSQueryHandler
 
 	taildrop *taildrop.Manager
+
+	// autoTaildrop, if non-nil, receives files directly into
+	// autoAccept.Dir from the senders autoAccept allows.
+	autoTaildrop *taildrop.Manager
+	autoAccept   TaildropAutoAccept
 }
 
 func (s *peerAPIServer) listen(ip netip.Addr, ifState *netmon.State) (ln net.Listener, err error) {
@@ -388,6 +394,9 @@ func (h *peerAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		metricIngressCalls.Add(1)
 		h.handleServeIngress(w, r)
 		return
+	case "/v0/bench":
+		h.handleServeBench(w, r)
+		return
 	}
 	if ph, ok := peerAPIHandlers[r.URL.Path]; ok {
 		ph(h, w, r)
@@ -694,7 +703,7 @@ func (h *peerAPIHandler) handlePeerPut(w http.ResponseWriter, r *http.Request) {
 		id := taildrop.ClientID(h.peerNode.StableID())
 		if prefix == "" {
 			// List all the partial files.
-			files, err := h.ps.taildrop.PartialFiles(id)
+			files, err := h.taildrop().PartialFiles(id)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
@@ -704,9 +713,24 @@ func (h *peerAPIHandler) handlePeerPut(w http.ResponseWriter, r *http.Request) {
 				h.logf("json.Encoder.Encode error: %v", err)
 				return
 			}
+		} else if r.FormValue("chunks") != "" {
+			// Return the checksums of the chunks received so far of a
+			// file being sent in chunks.
+			chunks, err := h.taildrop().PartialChunks(id, baseName)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if chunks == nil {
+				chunks = []taildrop.ChunkChecksum{}
+			}
+			if err := enc.Encode(chunks); err != nil {
+				h.logf("json.Encoder.Encode error: %v", err)
+				return
+			}
 		} else {
 			// Stream all the block hashes for the specified file.
-			next, close, err := h.ps.taildrop.HashPartialFile(id, baseName)
+			next, close, err := h.taildrop().HashPartialFile(id, baseName)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
@@ -742,18 +766,45 @@ func (h *peerAPIHandler) handlePeerPut(w http.ResponseWriter, r *http.Request) {
 			}
 			offset = ranges[0].Start
 		}
-		n, err := h.ps.taildrop.PutFile(taildrop.ClientID(fmt.Sprint(id)), baseName, r.Body, offset, r.ContentLength)
-		switch err {
-		case nil:
-			d := h.ps.b.clock.Since(t0).Round(time.Second / 10)
-			h.logf("got put of %s in %v from %v/%v", approxSize(n), d, h.remoteAddr.Addr(), h.peerNode.ComputedName)
-			io.WriteString(w, "{}\n")
-		case taildrop.ErrNoTaildrop:
+		md, err := taildrop.FileMetadataFromHeader(r.Header)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var n int64
+		var chunk taildrop.ChunkChecksum
+		chunked := r.FormValue("chunked") != ""
+		final := !chunked || r.FormValue("final") != ""
+		if chunked {
+			totalSize, perr := strconv.ParseInt(cmp.Or(r.FormValue("size"), "-1"), 10, 64)
+			if perr != nil {
+				http.Error(w, "invalid size", http.StatusBadRequest)
+				return
+			}
+			chunk, err = h.taildrop().PutFileChunk(id, baseName, r.Body, offset, r.ContentLength, totalSize, final, md)
+			n = chunk.Offset + chunk.Size
+		} else {
+			n, err = h.taildrop().PutFileWithMetadata(taildrop.ClientID(fmt.Sprint(id)), baseName, r.Body, offset, r.ContentLength, md)
+		}
+		switch {
+		case err == nil:
+			if final {
+				d := h.ps.b.clock.Since(t0).Round(time.Second / 10)
+				h.logf("got put of %s in %v from %v/%v", approxSize(n), d, h.remoteAddr.Addr(), h.peerNode.ComputedName)
+			}
+			if chunked {
+				enc.Encode(chunk)
+			} else {
+				io.WriteString(w, "{}\n")
+			}
+		case err == taildrop.ErrNoTaildrop:
 			http.Error(w, err.Error(), http.StatusForbidden)
-		case taildrop.ErrInvalidFileName:
+		case err == taildrop.ErrInvalidFileName:
 			http.Error(w, err.Error(), http.StatusBadRequest)
-		case taildrop.ErrFileExists:
+		case err == taildrop.ErrFileExists:
 			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, taildrop.ErrChecksumMismatch):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 		default:
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
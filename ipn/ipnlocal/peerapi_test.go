@@ -515,6 +515,33 @@ func(t *testing.T, env *peerAPITestEnv) {
 				},
 			),
 		},
+		{
+			name:   "bench/deny-nonself",
+			isSelf: false,
+			reqs:   []*http.Request{httptest.NewRequest("POST", "/v0/bench", strings.NewReader("fizz"))},
+			checks: checks(httpStatus(http.StatusForbidden)),
+		},
+		{
+			name:   "bench/upload",
+			isSelf: true,
+			reqs:   []*http.Request{httptest.NewRequest("POST", "/v0/bench", strings.NewReader("fizz"))},
+			checks: checks(
+				httpStatus(200),
+				bodyContains(`{"Bytes":4}`),
+			),
+		},
+		{
+			name:   "bench/download",
+			isSelf: true,
+			reqs:   []*http.Request{httptest.NewRequest("GET", "/v0/bench?duration=10ms", nil)},
+			checks: checks(httpStatus(200)),
+		},
+		{
+			name:   "bench/download-bad-duration",
+			isSelf: true,
+			reqs:   []*http.Request{httptest.NewRequest("GET", "/v0/bench?duration=forever", nil)},
+			checks: checks(httpStatus(http.StatusBadRequest)),
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -633,6 +660,86 @@ func TestFileDeleteRace(t *testing.T) {
 	}
 }
 
+func TestTaildropAutoAccept(t *testing.T) {
+	c := TaildropAutoAccept{
+		Dir:  t.TempDir(),
+		From: []string{"nas-feeder", "nodeid-2", "tag:camera", "user:alice@example.com"},
+	}
+	alice := tailcfg.UserProfile{LoginName: "alice@example.com"}
+	bob := tailcfg.UserProfile{LoginName: "bob@example.com"}
+	tests := []struct {
+		name string
+		node *tailcfg.Node
+		user tailcfg.UserProfile
+		want bool
+	}{
+		{"short-name", &tailcfg.Node{StableID: "nodeid-1", ComputedName: "nas-feeder", Name: "nas-feeder.tail-scale.ts.net."}, bob, true},
+		{"stable-id", &tailcfg.Node{StableID: "nodeid-2", ComputedName: "laptop"}, bob, true},
+		{"tag", &tailcfg.Node{StableID: "nodeid-3", Tags: []string{"tag:camera"}}, tailcfg.UserProfile{LoginName: "tagged-devices"}, true},
+		{"user", &tailcfg.Node{StableID: "nodeid-4", ComputedName: "phone"}, alice, true},
+		{"tagged-node-of-user", &tailcfg.Node{StableID: "nodeid-5", Tags: []string{"tag:server"}}, alice, false},
+		{"other", &tailcfg.Node{StableID: "nodeid-6", ComputedName: "laptop"}, bob, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.allows(tt.node.View(), tt.user); got != tt.want {
+				t.Errorf("allows = %v; want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Files from allowed senders are written into c.Dir, and others are
+	// received as usual.
+	inbox := t.TempDir()
+	ps := &peerAPIServer{
+		b: &LocalBackend{
+			logf:           t.Logf,
+			capFileSharing: true,
+			clock:          &tstest.Clock{},
+		},
+		taildrop: taildrop.ManagerOptions{
+			Logf: t.Logf,
+			Dir:  inbox,
+		}.New(),
+		autoTaildrop: taildrop.ManagerOptions{
+			Logf:           t.Logf,
+			Dir:            c.Dir,
+			DirectFileMode: true,
+		}.New(),
+		autoAccept: c,
+	}
+	selfNode := (&tailcfg.Node{
+		Addresses: []netip.Prefix{netip.MustParsePrefix("100.100.100.101/32")},
+	}).View()
+	put := func(peer *tailcfg.Node, name string) {
+		t.Helper()
+		ph := &peerAPIHandler{
+			isSelf:   true,
+			peerNode: peer.View(),
+			peerUser: bob,
+			selfNode: selfNode,
+			ps:       ps,
+		}
+		rr := httptest.NewRecorder()
+		ph.ServeHTTP(rr, httptest.NewRequest("PUT", "http://100.100.100.101:123/v0/put/"+name, strings.NewReader("contents")))
+		if res := rr.Result(); res.StatusCode != 200 {
+			t.Fatalf("put %s: %v", name, res.Status)
+		}
+	}
+	put(&tailcfg.Node{StableID: "nodeid-2"}, "auto.txt")
+	put(&tailcfg.Node{StableID: "nodeid-6"}, "inbox.txt")
+	if _, err := os.Stat(filepath.Join(c.Dir, "auto.txt")); err != nil {
+		t.Errorf("auto-accepted file: %v", err)
+	}
+	wfs, err := ps.taildrop.WaitingFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wfs) != 1 || wfs[0].Name != "inbox.txt" {
+		t.Errorf("waiting files = %+v; want only inbox.txt", wfs)
+	}
+}
+
 func TestPeerAPIReplyToDNSQueries(t *testing.T) {
 	var h peerAPIHandler
 
@@ -618,6 +618,46 @@ func (pm *profileManager) DeleteAllProfilesForUser() error {
 	return writeKnownProfiles()
 }
 
+// restoreProfile adds lp to the known profiles with the given prefs, replacing
+// any known profile with the same ID, as when restoring a backup. The current
+// user, if any, becomes the profile's owner. It does not switch profiles, but
+// updates the current profile if lp has its ID.
+func (pm *profileManager) restoreProfile(lp *ipn.LoginProfile, prefs ipn.PrefsView) error {
+	if lp.ID == "" || lp.Key != ipn.StateKey("profile-"+lp.ID) {
+		return fmt.Errorf("invalid profile ID %q or key %q", lp.ID, lp.Key)
+	}
+	if old, ok := pm.knownProfiles[lp.ID]; ok {
+		if err := pm.checkProfileAccess(old); err != nil {
+			return fmt.Errorf("%w: profile %q is not accessible to the current user", err, lp.ID)
+		}
+	}
+	if pm.currentUserID != "" {
+		lp.LocalUserID = pm.currentUserID
+	}
+	profile := lp.View()
+	clonedPrefs := prefs.AsStruct().View()
+	// Write the prefs before the profile, so that a failure doesn't leave
+	// a known profile without prefs behind.
+	if err := pm.writePrefsToStore(profile.Key(), clonedPrefs); err != nil {
+		return err
+	}
+	old, hadOld := pm.knownProfiles[lp.ID]
+	pm.knownProfiles[lp.ID] = profile
+	if err := pm.writeKnownProfiles(); err != nil {
+		if hadOld {
+			pm.knownProfiles[lp.ID] = old
+		} else {
+			delete(pm.knownProfiles, lp.ID)
+		}
+		return err
+	}
+	if lp.ID != pm.currentProfile.ID() {
+		return nil
+	}
+	pm.currentProfile = profile
+	return pm.setProfilePrefsNoPermCheck(profile, clonedPrefs)
+}
+
 func (pm *profileManager) writeKnownProfiles() error {
 	b, err := json.Marshal(pm.knownProfiles)
 	if err != nil {
@@ -140,6 +140,40 @@ func TestProfileList(t *testing.T) {
 	checkProfiles(t, "carol")
 }
 
+func TestRestoreProfile(t *testing.T) {
+	pm, err := newProfileManagerWithGOOS(new(mem.Store), logger.Discard, new(health.Tracker), "linux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lp := &ipn.LoginProfile{ID: "1234", Key: "profile-1234", Name: "alice@example.com"}
+	prefs := &ipn.Prefs{Hostname: "restored", WantRunning: true}
+	if err := pm.restoreProfile(lp.Clone(), prefs.View()); err != nil {
+		t.Fatal(err)
+	}
+	if got := pm.Profiles(); len(got) != 1 || got[0].Name() != "alice@example.com" {
+		t.Fatalf("profiles after restore = %v; want one for alice", got)
+	}
+	if got := must.Get(pm.ProfilePrefs("1234")); got.Hostname() != "restored" {
+		t.Errorf("restored prefs hostname = %q; want %q", got.Hostname(), "restored")
+	}
+
+	// Restoring over the current profile updates its prefs.
+	must.Do(pm.SwitchProfile("1234"))
+	prefs.Hostname = "restored-again"
+	if err := pm.restoreProfile(lp.Clone(), prefs.View()); err != nil {
+		t.Fatal(err)
+	}
+	if got := pm.CurrentPrefs().Hostname(); got != "restored-again" {
+		t.Errorf("current prefs hostname = %q; want %q", got, "restored-again")
+	}
+
+	// Profiles can't be restored to arbitrary state keys.
+	bad := &ipn.LoginProfile{ID: "5678", Key: ipn.MachineKeyStateKey}
+	if err := pm.restoreProfile(bad, prefs.View()); err == nil {
+		t.Error("restoring profile with invalid key succeeded")
+	}
+}
+
 func TestProfileDupe(t *testing.T) {
 	newPersist := func(user, node int) *persist.Persist {
 		return &persist.Persist{
@@ -0,0 +1,224 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/envknob"
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+	"tailscale.com/net/routetable"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/mak"
+	"tailscale.com/util/rands"
+	"tailscale.com/util/syspolicy"
+)
+
+const (
+	// remoteDiagPromptTimeout is how long a request for diagnostics waits
+	// for the local user's consent, when the policy is to prompt.
+	remoteDiagPromptTimeout = 2 * time.Minute
+
+	// remoteDiagMaxRoutes is the most routes sent in a route table dump.
+	remoteDiagMaxRoutes = 1000
+)
+
+// remoteDiagWarnable is a Warnable set while the control plane waits for the
+// local user's consent to collect diagnostics.
+var remoteDiagWarnable = health.Register(&health.Warnable{
+	Code:     "remote-diagnostics-requested",
+	Title:    "Diagnostics requested",
+	Severity: health.SeverityLow,
+	Text:     health.StaticMessage("Your tailnet administrator requests diagnostics from this device. Run 'tailscale debug remote-diag' to review the request, and approve or deny it."),
+})
+
+// remoteDiagRequest is a request for diagnostics waiting for the local user's
+// consent.
+type remoteDiagRequest struct {
+	apitype.RemoteDiagRequest
+	decision chan bool // buffered; receives whether the user approved
+}
+
+// remoteDiagPolicy returns the policy for collecting diagnostics remotely,
+// one of the ipn.RemoteDiagnostics* constants: from syspolicy if set, or else
+// from prefs.
+func (b *LocalBackend) remoteDiagPolicy() string {
+	v, err := syspolicy.GetString(syspolicy.RemoteDiagnostics, "")
+	if err != nil {
+		b.logf("remote-diag: failed to read RemoteDiagnostics from syspolicy: %v", err)
+	}
+	if v == "" {
+		v = b.Prefs().RemoteDiagnostics()
+	}
+	switch v {
+	case ipn.RemoteDiagnosticsPrompt, ipn.RemoteDiagnosticsAllow:
+		return v
+	}
+	return ipn.RemoteDiagnosticsOff
+}
+
+// handleC2NRemoteDiag handles a request from the control plane for the
+// diagnostics of the kind given by the "kind" query parameter, if the local
+// policy permits it. What's sent is summarized in the local log.
+func handleC2NRemoteDiag(b *LocalBackend, w http.ResponseWriter, r *http.Request) {
+	kind := r.FormValue("kind")
+	b.logf("c2n: POST /debug/remote-diag received for %q", kind)
+	switch kind {
+	case "netcheck", "bugreport", "routes":
+	default:
+		http.Error(w, fmt.Sprintf("unknown diagnostics kind %q", kind), http.StatusBadRequest)
+		return
+	}
+	if err := b.consentToRemoteDiag(r.Context(), kind); err != nil {
+		b.logf("c2n: remote diagnostics %q refused: %v", kind, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	res, summary := b.collectRemoteDiag(r.Context(), kind)
+	b.logf("c2n: sending remote diagnostics %q: %s", kind, summary)
+	writeJSON(w, res)
+}
+
+// consentToRemoteDiag returns nil if the control plane may collect kind
+// diagnostics per the local policy, first waiting for the local user's
+// consent if the policy is to prompt.
+func (b *LocalBackend) consentToRemoteDiag(ctx context.Context, kind string) error {
+	switch b.remoteDiagPolicy() {
+	case ipn.RemoteDiagnosticsAllow:
+		return nil
+	case ipn.RemoteDiagnosticsPrompt:
+	default:
+		return errors.New("remote diagnostics are disabled on this node")
+	}
+
+	req := &remoteDiagRequest{
+		RemoteDiagRequest: apitype.RemoteDiagRequest{
+			ID:        rands.HexString(8),
+			Kind:      kind,
+			Requested: b.clock.Now(),
+		},
+		decision: make(chan bool, 1),
+	}
+	b.mu.Lock()
+	mak.Set(&b.remoteDiagPending, req.ID, req)
+	b.mu.Unlock()
+	b.health.SetUnhealthy(remoteDiagWarnable, nil)
+	b.logf("remote-diag: waiting for the local user's consent to request %s for %q", req.ID, kind)
+	defer func() {
+		b.mu.Lock()
+		delete(b.remoteDiagPending, req.ID)
+		done := len(b.remoteDiagPending) == 0
+		b.mu.Unlock()
+		if done {
+			b.health.SetHealthy(remoteDiagWarnable)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, remoteDiagPromptTimeout)
+	defer cancel()
+	select {
+	case ok := <-req.decision:
+		if !ok {
+			return errors.New("the local user denied the request")
+		}
+		return nil
+	case <-ctx.Done():
+		return errors.New("the local user didn't respond to the request in time")
+	}
+}
+
+// RemoteDiagRequests returns the requests for diagnostics from the control
+// plane waiting for the local user's consent, oldest first.
+func (b *LocalBackend) RemoteDiagRequests() []apitype.RemoteDiagRequest {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ret := make([]apitype.RemoteDiagRequest, 0, len(b.remoteDiagPending))
+	for _, req := range b.remoteDiagPending {
+		ret = append(ret, req.RemoteDiagRequest)
+	}
+	slices.SortFunc(ret, func(a, b apitype.RemoteDiagRequest) int {
+		return cmp.Or(a.Requested.Compare(b.Requested), cmp.Compare(a.ID, b.ID))
+	})
+	return ret
+}
+
+// DecideRemoteDiag approves or denies the pending request for diagnostics
+// with the given ID.
+func (b *LocalBackend) DecideRemoteDiag(id string, approve bool) error {
+	b.mu.Lock()
+	req, ok := b.remoteDiagPending[id]
+	b.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no pending request for diagnostics with ID %q", id)
+	}
+	select {
+	case req.decision <- approve:
+	default:
+		return fmt.Errorf("request %q was already decided", id)
+	}
+	b.logf("remote-diag: local user decided request %s for %q: approve=%v", id, req.Kind, approve)
+	return nil
+}
+
+// collectRemoteDiag collects kind diagnostics, returning them and a summary
+// of what was collected for the local log. Failures to collect parts of the
+// diagnostics are included in the summary.
+func (b *LocalBackend) collectRemoteDiag(ctx context.Context, kind string) (_ *tailcfg.C2NRemoteDiagResponse, summary string) {
+	res := &tailcfg.C2NRemoteDiagResponse{Kind: kind}
+	var parts []string
+	addNetcheck := func() {
+		r := b.MagicConn().GetLastNetcheckReport(ctx)
+		if r == nil {
+			parts = append(parts, "no netcheck report yet")
+			return
+		}
+		j, err := json.Marshal(r)
+		if err != nil {
+			parts = append(parts, fmt.Sprintf("netcheck report: %v", err))
+			return
+		}
+		res.Netcheck = j
+		parts = append(parts, fmt.Sprintf("netcheck report (UDP=%v, preferred DERP %d, %d DERP latencies)", r.UDP, r.PreferredDERP, len(r.RegionLatency)))
+	}
+	addRoutes := func() {
+		rs, err := routetable.Get(remoteDiagMaxRoutes)
+		if err != nil {
+			parts = append(parts, fmt.Sprintf("route table: %v", err))
+			return
+		}
+		for _, r := range rs {
+			res.Routes = append(res.Routes, fmt.Sprint(r))
+		}
+		parts = append(parts, fmt.Sprintf("%d routes", len(rs)))
+	}
+
+	switch kind {
+	case "netcheck":
+		addNetcheck()
+	case "routes":
+		addRoutes()
+	case "bugreport":
+		res.BugReportMarker = fmt.Sprintf("BUG-%v-%v-%v", b.backendLogID, b.clock.Now().UTC().Format("20060102150405Z"), rands.HexString(16))
+		if envknob.NoLogsNoSupport() {
+			res.BugReportMarker = "BUG-NO-LOGS-NO-SUPPORT-this-node-has-had-its-logging-disabled"
+		}
+		b.logf("remote bugreport: %s", res.BugReportMarker)
+		defer b.TryFlushLogs()
+		res.Health = b.health.Strings()
+		parts = append(parts, fmt.Sprintf("bug report marker %s", res.BugReportMarker), fmt.Sprintf("%d health warnings", len(res.Health)))
+		addNetcheck()
+		addRoutes()
+	}
+	return res, strings.Join(parts, ", ")
+}
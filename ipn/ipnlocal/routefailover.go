@@ -0,0 +1,383 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"cmp"
+	"context"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/envknob"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
+	"tailscale.com/types/key"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/netmap"
+	"tailscale.com/types/views"
+	"tailscale.com/util/syspolicy"
+	"tailscale.com/wgengine/wgcfg"
+)
+
+var (
+	// subnetFailover enables client-side failover between subnet routers
+	// approved for the same routes, like the [syspolicy.SubnetRouterFailover]
+	// policy.
+	subnetFailover = envknob.RegisterBool("TS_SUBNET_FAILOVER")
+
+	// subnetFailoverPreference is a comma-separated list of the names,
+	// stable node IDs or Tailscale IPs of subnet routers, most preferred
+	// first, giving the order in which standby routers are failed over to.
+	// Routers not listed come after those that are, in stable ID order.
+	// The [syspolicy.SubnetRouterFailoverPreference] policy takes precedence.
+	subnetFailoverPreference = envknob.RegisterString("TS_SUBNET_FAILOVER_PREFERENCE")
+)
+
+// subnetFailoverEnabled reports whether subnet router failover is enabled by
+// policy or the environment.
+func subnetFailoverEnabled() bool {
+	if on, _ := syspolicy.GetBoolean(syspolicy.SubnetRouterFailover, false); on {
+		return true
+	}
+	return subnetFailover()
+}
+
+const (
+	// failoverProbeInterval is how often subnet routers taking part in
+	// failover are probed with a disco ping. A probe not answered within
+	// the interval counts as a miss.
+	failoverProbeInterval = 500 * time.Millisecond
+
+	// failoverMaxMisses is the number of consecutive probes a router
+	// must miss to be considered down, so that a primary's routes move
+	// to a standby about two seconds after it fails.
+	failoverMaxMisses = 4
+
+	// failoverMinHits is the number of consecutive probes a router that
+	// is down must answer to be considered up again, so that routes don't
+	// flap back to a router that's only intermittently reachable.
+	failoverMinHits = 6
+)
+
+// routeFailover moves subnet routes away from their primary router, as
+// chosen by control, to a standby router approved for the same routes when
+// the primary stops answering probes, and back once it recovers. Control
+// eventually makes the same choice, but only after noticing the primary is
+// gone, which takes much longer.
+type routeFailover struct {
+	logf       logger.Logf
+	clock      tstime.Clock
+	ping       func(ctx context.Context, ip netip.Addr) error // probes a router
+	onChange   func()                                         // called when the routes to install changed
+	preference []string                                       // from policy or subnetFailoverPreference
+
+	mu     sync.Mutex
+	groups []failoverGroup
+	nodes  map[tailcfg.StableNodeID]*failoverNode
+	active map[netip.Prefix]tailcfg.StableNodeID // last router each route was installed for
+}
+
+// failoverGroup is a subnet route approved for more than one router.
+type failoverGroup struct {
+	route    netip.Prefix
+	primary  tailcfg.StableNodeID
+	standbys []tailcfg.StableNodeID // in preference order
+}
+
+// failoverNode is the probe state of a router in a failoverGroup.
+type failoverNode struct {
+	name   string
+	key    key.NodePublic
+	ip     netip.Addr // Tailscale IP probed
+	up     bool
+	misses int // consecutive probes missed while up
+	hits   int // consecutive probes answered while down
+}
+
+func newRouteFailover(logf logger.Logf, ping func(context.Context, netip.Addr) error, onChange func()) *routeFailover {
+	pref, _ := syspolicy.GetStringArray(syspolicy.SubnetRouterFailoverPreference, nil)
+	if len(pref) == 0 {
+		for _, s := range strings.Split(subnetFailoverPreference(), ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				pref = append(pref, s)
+			}
+		}
+	}
+	return &routeFailover{
+		logf:       logger.WithPrefix(logf, "subnet-failover: "),
+		clock:      tstime.StdClock{},
+		ping:       ping,
+		onChange:   onChange,
+		preference: pref,
+		nodes:      map[tailcfg.StableNodeID]*failoverNode{},
+		active:     map[netip.Prefix]tailcfg.StableNodeID{},
+	}
+}
+
+// rank returns the position of n in rf.preference, or len(rf.preference)
+// if it's not listed.
+func (rf *routeFailover) rank(n tailcfg.NodeView) int {
+	for i, p := range rf.preference {
+		if p == string(n.StableID()) || p == n.ComputedName() {
+			return i
+		}
+		if ip, err := netip.ParseAddr(p); err == nil && views.SliceContains(n.Addresses(), netip.PrefixFrom(ip, ip.BitLen())) {
+			return i
+		}
+	}
+	return len(rf.preference)
+}
+
+// approvedRoutes returns the subnet routes control has approved for n: those
+// in its AllowedIPs or PrimaryRoutes, other than its own addresses and exit
+// node routes. Unlike the routes n advertises in its Hostinfo, which it
+// reports itself, these can be trusted.
+func approvedRoutes(n tailcfg.NodeView) []netip.Prefix {
+	var routes []netip.Prefix
+	for _, rs := range []views.Slice[netip.Prefix]{n.AllowedIPs(), n.PrimaryRoutes()} {
+		for _, r := range rs.All() {
+			if r.Bits() == 0 || views.SliceContains(n.Addresses(), r) || slices.Contains(routes, r) {
+				continue
+			}
+			routes = append(routes, r)
+		}
+	}
+	return routes
+}
+
+// setNetMap updates the routes and routers taking part in failover from
+// nm, keeping the probe state of routers that are still in use.
+func (rf *routeFailover) setNetMap(nm *netmap.NetworkMap) {
+	var groups []failoverGroup
+	nodes := map[tailcfg.StableNodeID]*failoverNode{}
+	if nm != nil {
+		var routers []tailcfg.NodeView
+		approved := map[tailcfg.StableNodeID][]netip.Prefix{}
+		for _, p := range nm.Peers {
+			if p.Expired() {
+				continue
+			}
+			if routes := approvedRoutes(p); len(routes) > 0 {
+				routers = append(routers, p)
+				approved[p.StableID()] = routes
+			}
+		}
+		slices.SortFunc(routers, func(a, b tailcfg.NodeView) int {
+			return cmp.Or(cmp.Compare(rf.rank(a), rf.rank(b)), cmp.Compare(a.StableID(), b.StableID()))
+		})
+		for _, p := range routers {
+			for _, route := range p.PrimaryRoutes().All() {
+				if route.Bits() == 0 {
+					// Exit node routes are chosen by the user.
+					continue
+				}
+				g := failoverGroup{route: route, primary: p.StableID()}
+				for _, s := range routers {
+					if s.StableID() == p.StableID() {
+						continue
+					}
+					// A router approved for a wider route may take over
+					// the narrower one.
+					if slices.ContainsFunc(approved[s.StableID()], func(a netip.Prefix) bool {
+						return a.Bits() <= route.Bits() && a.Contains(route.Addr())
+					}) {
+						g.standbys = append(g.standbys, s.StableID())
+					}
+				}
+				if len(g.standbys) == 0 {
+					continue
+				}
+				groups = append(groups, g)
+				for _, id := range append([]tailcfg.StableNodeID{g.primary}, g.standbys...) {
+					if _, ok := nodes[id]; ok {
+						continue
+					}
+					n, _ := nm.PeerWithStableID(id)
+					nodes[id] = rf.nodeFor(n)
+				}
+			}
+		}
+	}
+
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	for id, n := range nodes {
+		if old, ok := rf.nodes[id]; ok && old.ip == n.ip {
+			n.up, n.misses, n.hits = old.up, old.misses, old.hits
+		} else {
+			// Trust control's choice of primary until probes say
+			// otherwise; standbys must prove themselves first.
+			n.up = slices.ContainsFunc(groups, func(g failoverGroup) bool { return g.primary == id })
+		}
+	}
+	rf.groups = groups
+	rf.nodes = nodes
+}
+
+func (rf *routeFailover) nodeFor(n tailcfg.NodeView) *failoverNode {
+	fn := &failoverNode{name: n.ComputedName(), key: n.Key()}
+	for _, a := range n.Addresses().All() {
+		if a.IsSingleIP() && (!fn.ip.IsValid() || a.Addr().Is4()) {
+			fn.ip = a.Addr()
+		}
+	}
+	return fn
+}
+
+// chooseLocked returns the router that g's route should be installed for:
+// its primary unless that's down, in which case the first standby that's
+// up. rf.mu must be held.
+func (rf *routeFailover) chooseLocked(g failoverGroup) tailcfg.StableNodeID {
+	if n := rf.nodes[g.primary]; n == nil || n.up {
+		return g.primary
+	}
+	for _, id := range g.standbys {
+		if n := rf.nodes[id]; n != nil && n.up {
+			return id
+		}
+	}
+	// Nothing better; leave the route with the primary.
+	return g.primary
+}
+
+// apply moves the routes in cfg from primary routers that are down to
+// standby routers.
+func (rf *routeFailover) apply(cfg *wgcfg.Config) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	peerIndex := map[key.NodePublic]int{}
+	for i, p := range cfg.Peers {
+		peerIndex[p.PublicKey] = i
+	}
+	active := map[netip.Prefix]tailcfg.StableNodeID{}
+	for _, g := range rf.groups {
+		want := rf.chooseLocked(g)
+		from, to := rf.nodes[g.primary], rf.nodes[want]
+		pi, ok := peerIndex[from.key]
+		if !ok || !slices.Contains(cfg.Peers[pi].AllowedIPs, g.route) {
+			// Not accepting this route.
+			continue
+		}
+		active[g.route] = want
+		if prev, ok := rf.active[g.route]; ok && prev != want {
+			rf.logf("moving %v from %v to %s", g.route, prev, to.name)
+		} else if !ok && want != g.primary {
+			rf.logf("installing %v for %s; primary %s is down", g.route, to.name, from.name)
+		}
+		si, ok := peerIndex[to.key]
+		if !ok {
+			continue
+		}
+		// Standbys may have been given the route too; leave it with the
+		// chosen router alone.
+		for _, id := range append([]tailcfg.StableNodeID{g.primary}, g.standbys...) {
+			if i, ok := peerIndex[rf.nodes[id].key]; ok && i != si {
+				cfg.Peers[i].AllowedIPs = slices.DeleteFunc(cfg.Peers[i].AllowedIPs, func(p netip.Prefix) bool { return p == g.route })
+			}
+		}
+		if !slices.Contains(cfg.Peers[si].AllowedIPs, g.route) {
+			cfg.Peers[si].AllowedIPs = append(cfg.Peers[si].AllowedIPs, g.route)
+		}
+	}
+	rf.active = active
+}
+
+// run probes the routers taking part in failover until ctx is done,
+// calling rf.onChange whenever a route needs to move.
+func (rf *routeFailover) run(ctx context.Context) {
+	t, tc := rf.clock.NewTicker(failoverProbeInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tc:
+		}
+		if rf.probe(ctx) {
+			rf.onChange()
+		}
+	}
+}
+
+// probe pings every router taking part in failover once, updating their
+// state, and reports whether the router chosen for any route changed.
+func (rf *routeFailover) probe(ctx context.Context) (changed bool) {
+	rf.mu.Lock()
+	ips := map[tailcfg.StableNodeID]netip.Addr{}
+	for id, n := range rf.nodes {
+		if n.ip.IsValid() {
+			ips[id] = n.ip
+		}
+	}
+	rf.mu.Unlock()
+	if len(ips) == 0 {
+		return false
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, failoverProbeInterval)
+	defer cancel()
+	var (
+		wg      sync.WaitGroup
+		resMu   sync.Mutex
+		results = map[tailcfg.StableNodeID]bool{}
+	)
+	for id, ip := range ips {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := rf.ping(probeCtx, ip)
+			resMu.Lock()
+			defer resMu.Unlock()
+			results[id] = err == nil
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		// Shutting down; the probes failed for that reason alone.
+		return false
+	}
+
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	before := make([]tailcfg.StableNodeID, len(rf.groups))
+	for i, g := range rf.groups {
+		before[i] = rf.chooseLocked(g)
+	}
+	for id, ok := range results {
+		if n := rf.nodes[id]; n != nil {
+			rf.updateLocked(n, ok)
+		}
+	}
+	for i, g := range rf.groups {
+		if rf.chooseLocked(g) != before[i] {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// updateLocked records the result of a probe of n. rf.mu must be held.
+func (rf *routeFailover) updateLocked(n *failoverNode, ok bool) {
+	switch {
+	case n.up && ok:
+		n.misses = 0
+	case n.up && !ok:
+		n.misses++
+		if n.misses >= failoverMaxMisses {
+			rf.logf("%s (%v) is down", n.name, n.ip)
+			n.up, n.misses = false, 0
+		}
+	case !n.up && ok:
+		n.hits++
+		if n.hits >= failoverMinHits {
+			rf.logf("%s (%v) is up", n.name, n.ip)
+			n.up, n.hits = true, 0
+		}
+	default:
+		n.hits = 0
+	}
+}
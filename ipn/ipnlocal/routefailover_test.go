@@ -0,0 +1,166 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/netmap"
+	"tailscale.com/util/syspolicy"
+	"tailscale.com/util/syspolicy/setting"
+	"tailscale.com/util/syspolicy/source"
+	"tailscale.com/wgengine/wgcfg"
+)
+
+func TestRouteFailover(t *testing.T) {
+	subnet := netip.MustParsePrefix("10.0.0.0/24")
+	// Routers are the primary for subnet, approved for it as a standby,
+	// or merely advertising it, which doesn't make them a standby.
+	const (
+		primary = iota
+		approved
+		advertised
+	)
+	router := func(id int, name string, role int) tailcfg.NodeView {
+		n := &tailcfg.Node{
+			ID:           tailcfg.NodeID(id),
+			StableID:     tailcfg.StableNodeID(name),
+			ComputedName: name,
+			Key:          key.NewNode().Public(),
+			Addresses:    []netip.Prefix{netip.PrefixFrom(netip.AddrFrom4([4]byte{100, 64, 0, byte(id)}), 32)},
+			Hostinfo:     (&tailcfg.Hostinfo{RoutableIPs: []netip.Prefix{subnet}}).View(),
+		}
+		n.AllowedIPs = append(n.AllowedIPs, n.Addresses...)
+		switch role {
+		case primary:
+			n.AllowedIPs = append(n.AllowedIPs, subnet)
+			n.PrimaryRoutes = []netip.Prefix{subnet}
+		case approved:
+			n.AllowedIPs = append(n.AllowedIPs, subnet)
+		}
+		return n.View()
+	}
+	nm := &netmap.NetworkMap{
+		Peers: []tailcfg.NodeView{
+			router(1, "a", primary),
+			router(2, "b", approved),
+			router(3, "c", approved),
+			router(4, "d", advertised),
+		},
+	}
+	wgConfig := func() *wgcfg.Config {
+		cfg := &wgcfg.Config{}
+		for _, p := range nm.Peers {
+			cfg.Peers = append(cfg.Peers, wgcfg.Peer{PublicKey: p.Key(), AllowedIPs: p.AllowedIPs().AsSlice()})
+		}
+		return cfg
+	}
+	routedBy := func(cfg *wgcfg.Config) (names []string) {
+		for i, p := range cfg.Peers {
+			if slices.Contains(p.AllowedIPs, subnet) {
+				names = append(names, nm.Peers[i].ComputedName())
+			}
+		}
+		return names
+	}
+
+	down := map[netip.Addr]bool{}
+	ping := func(ctx context.Context, ip netip.Addr) error {
+		if down[ip] {
+			return errors.New("timeout")
+		}
+		return nil
+	}
+	ipOf := func(i int) netip.Addr { return nm.Peers[i].Addresses().At(0).Addr() }
+
+	rf := newRouteFailover(t.Logf, ping, func() {})
+	rf.preference = []string{"d", "c"}
+	rf.setNetMap(nm)
+	if len(rf.groups) != 1 {
+		t.Fatalf("got %d groups, want 1", len(rf.groups))
+	}
+	if got, want := rf.groups[0].standbys, []tailcfg.StableNodeID{"c", "b"}; !slices.Equal(got, want) {
+		t.Errorf("standbys = %v, want %v (approved only, preferred first)", got, want)
+	}
+
+	probe := func(n int) (changed bool) {
+		for range n {
+			if rf.probe(context.Background()) {
+				changed = true
+			}
+		}
+		return changed
+	}
+	check := func(want ...string) {
+		t.Helper()
+		cfg := wgConfig()
+		rf.apply(cfg)
+		if got := routedBy(cfg); !slices.Equal(got, want) {
+			t.Errorf("route installed for %v, want %v", got, want)
+		}
+	}
+
+	// Standbys must answer failoverMinHits probes before they're used.
+	// Until then, and after, the route is installed for the primary
+	// alone, though the standbys were given it too.
+	check("a")
+	probe(failoverMinHits)
+	check("a")
+
+	// The primary fails; the route moves to the preferred standby once
+	// it has missed enough probes.
+	down[ipOf(0)] = true
+	if probe(failoverMaxMisses - 1) {
+		t.Errorf("route moved before primary missed %d probes", failoverMaxMisses)
+	}
+	check("a")
+	if !probe(1) {
+		t.Errorf("route didn't move after primary missed %d probes", failoverMaxMisses)
+	}
+	check("c")
+
+	// With the preferred standby down too, the other one is used.
+	down[ipOf(2)] = true
+	probe(failoverMaxMisses)
+	check("b")
+
+	// A netmap update keeps the probe state.
+	rf.setNetMap(nm)
+	check("b")
+
+	// The primary recovers and the route moves back.
+	down[ipOf(0)] = false
+	if probe(failoverMinHits - 1) {
+		t.Errorf("route moved back before primary answered %d probes", failoverMinHits)
+	}
+	if !probe(1) {
+		t.Errorf("route didn't move back after primary answered %d probes", failoverMinHits)
+	}
+	check("a")
+}
+
+func TestRouteFailoverPolicy(t *testing.T) {
+	if subnetFailoverEnabled() {
+		t.Fatal("failover enabled with no policy set")
+	}
+	syspolicy.RegisterWellKnownSettingsForTest(t)
+	policyStore := source.NewTestStore(t)
+	policyStore.SetBooleans(source.TestSettingOf(syspolicy.SubnetRouterFailover, true))
+	policyStore.SetStringLists(source.TestSettingOf(syspolicy.SubnetRouterFailoverPreference, []string{"b", "a"}))
+	syspolicy.MustRegisterStoreForTest(t, "TestStore", setting.DeviceScope, policyStore)
+
+	if !subnetFailoverEnabled() {
+		t.Error("failover not enabled by policy")
+	}
+	rf := newRouteFailover(t.Logf, nil, nil)
+	if want := []string{"b", "a"}; !slices.Equal(rf.preference, want) {
+		t.Errorf("preference = %v, want %v", rf.preference, want)
+	}
+}
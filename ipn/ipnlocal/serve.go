@@ -517,6 +517,7 @@ func (b *LocalBackend) tcpHandlerForVIPService(dstAddr, srcAddr netip.AddrPort)
 						if err != nil {
 							return nil, err
 						}
+						cert.OCSPStaple = pair.OCSPStaple
 						return &cert, nil
 					},
 				})
@@ -604,6 +605,7 @@ func (b *LocalBackend) tcpHandlerForServe(dport uint16, srcAddr netip.AddrPort,
 						if err != nil {
 							return nil, err
 						}
+						cert.OCSPStaple = pair.OCSPStaple
 						return &cert, nil
 					},
 				})
@@ -1041,6 +1043,7 @@ func (b *LocalBackend) getTLSServeCertForPort(port uint16, forVIPService tailcfg
 		if err != nil {
 			return nil, err
 		}
+		cert.OCSPStaple = pair.OCSPStaple
 		return &cert, nil
 	}
 }
@@ -0,0 +1,50 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build (linux || (darwin && !ios) || freebsd || openbsd || netbsd) && !ts_omit_ssh
+
+package ipnlocal
+
+import (
+	"slices"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/sessionrecording"
+	"tailscale.com/tailcfg"
+)
+
+// SSHRecordings returns the Tailscale SSH session recordings kept on this
+// node, oldest first, from the directories the current SSH policy records
+// to and the default one.
+func (b *LocalBackend) SSHRecordings() ([]apitype.SSHRecording, error) {
+	varRoot := b.TailscaleVarRoot()
+	var dirs []string
+	addDir := func(lr tailcfg.SSHLocalRecording) {
+		if dir, err := sessionrecording.LocalDir(varRoot, lr); err == nil && !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	addDir(tailcfg.SSHLocalRecording{})
+	if nm := b.NetMap(); nm != nil && nm.SSHPolicy != nil {
+		for _, r := range nm.SSHPolicy.Rules {
+			if r.Action != nil && r.Action.LocalRecording != nil {
+				addDir(*r.Action.LocalRecording)
+			}
+		}
+	}
+
+	var files []apitype.SSHRecording
+	for _, dir := range dirs {
+		fs, err := sessionrecording.ListLocal(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range fs {
+			files = append(files, apitype.SSHRecording(f))
+		}
+	}
+	slices.SortStableFunc(files, func(a, b apitype.SSHRecording) int {
+		return a.ModTime.Compare(b.ModTime)
+	})
+	return files, nil
+}
@@ -0,0 +1,12 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build ios || (!linux && !darwin && !freebsd && !openbsd && !netbsd) || ts_omit_ssh
+
+package ipnlocal
+
+import "tailscale.com/client/tailscale/apitype"
+
+func (b *LocalBackend) SSHRecordings() ([]apitype.SSHRecording, error) {
+	return nil, nil
+}
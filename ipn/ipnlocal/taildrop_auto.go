@@ -0,0 +1,62 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"strings"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/taildrop"
+	"tailscale.com/types/views"
+)
+
+// TaildropAutoAccept configures receiving Taildrop files from some senders
+// directly into a directory, as on NAS platforms, so that they need not be
+// fetched with "tailscale file get". Files from other senders are received
+// as usual.
+type TaildropAutoAccept struct {
+	// Dir is the directory to write the files into. It must exist.
+	Dir string
+
+	// From are the senders whose files are written into Dir. Each is one
+	// of a node's stable ID, its MagicDNS name (short or fully qualified),
+	// "tag:" and one of its tags, or "user:" and its owner's login name.
+	From []string
+}
+
+// allows reports whether c allows files from the node peer, owned by user,
+// to be written directly into c.Dir.
+func (c TaildropAutoAccept) allows(peer tailcfg.NodeView, user tailcfg.UserProfile) bool {
+	if c.Dir == "" || !peer.Valid() {
+		return false
+	}
+	for _, from := range c.From {
+		switch {
+		case strings.HasPrefix(from, "user:"):
+			if peer.Tags().Len() == 0 && strings.EqualFold(strings.TrimPrefix(from, "user:"), user.LoginName) {
+				return true
+			}
+		case strings.HasPrefix(from, "tag:"):
+			if views.SliceContains(peer.Tags(), from) {
+				return true
+			}
+		case from == string(peer.StableID()):
+			return true
+		case strings.EqualFold(from, peer.ComputedName()),
+			strings.EqualFold(strings.TrimSuffix(from, "."), strings.TrimSuffix(peer.Name(), ".")):
+			return true
+		}
+	}
+	return false
+}
+
+// taildrop returns the taildrop.Manager to receive files from h's peer
+// with: the one writing directly to the auto-accept directory, if its
+// policy allows the peer, or else the usual one.
+func (h *peerAPIHandler) taildrop() *taildrop.Manager {
+	if h.ps.autoTaildrop != nil && h.ps.autoAccept.allows(h.peerNode, h.peerUser) {
+		return h.ps.autoTaildrop
+	}
+	return h.ps.taildrop
+}
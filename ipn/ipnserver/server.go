@@ -209,6 +209,7 @@ func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
 		if actor, ok := ci.(*actor); ok {
 			lah.PermitRead, lah.PermitWrite = actor.Permissions(lb.OperatorUserID())
 			lah.PermitCert = actor.CanFetchCerts()
+			lah.PermitKeys = actor.CanReadKeys()
 		} else if testenv.InTest() {
 			lah.PermitRead, lah.PermitWrite = true, true
 		}
@@ -389,6 +390,24 @@ func (a *actor) CanFetchCerts() bool {
 	return false
 }
 
+// CanReadKeys reports whether the actor may read the node's private keys,
+// such as in a backup. That's limited to root (or LocalSystem or an
+// elevated admin on Windows), and in particular excludes the operator user,
+// who could otherwise impersonate the node.
+func (a *actor) CanReadKeys() bool {
+	if a.isLocalSystem {
+		return true
+	}
+	if envknob.GOOS() == "windows" {
+		return connIsLocalAdmin(a.logf, a.ci, "")
+	}
+	if a.ci.IsUnixSock() && a.ci.Creds() != nil {
+		uid, ok := a.ci.Creds().UserID()
+		return ok && uid == "0"
+	}
+	return false
+}
+
 // addActiveHTTPRequest adds c to the server's list of active HTTP requests.
 //
 // It returns an error if the specified actor is not allowed to connect.
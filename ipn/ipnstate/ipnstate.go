@@ -85,6 +85,28 @@ type Status struct {
 	// version of the Tailscale client that's available. Depending on
 	// the platform and client settings, it may not be available.
 	ClientVersion *tailcfg.ClientVersion
+
+	// WireGuardPeers, if non-nil, reports how many peers are currently
+	// configured in the WireGuard engine and how many are only
+	// configured lazily, on their first packet.
+	WireGuardPeers *WireGuardPeerCounts `json:",omitempty"`
+}
+
+// WireGuardPeerCounts describes how many of a node's peers are configured
+// in its WireGuard engine.
+//
+// To save memory and handshake churn on large tailnets, peers that haven't
+// exchanged traffic recently are left out of the WireGuard configuration
+// and only added when a packet to or from them shows up. This only covers
+// WireGuard's per-peer state: magicsock keeps an endpoint for every peer
+// either way, so it can recognize their first packets.
+type WireGuardPeerCounts struct {
+	// Active is the number of peers currently configured in WireGuard.
+	Active int
+
+	// Lazy is the number of peers that are known but not currently
+	// configured in WireGuard.
+	Lazy int
 }
 
 // TKAKey describes a key trusted by network lock.
@@ -316,6 +338,53 @@ type PeerStatus struct {
 	KeyExpiry *time.Time `json:",omitempty"`
 
 	Location *tailcfg.Location `json:",omitempty"`
+
+	// PathStats are statistics about the paths packets take to and from
+	// the peer, if magicsock is tracking it.
+	PathStats *PeerPathStats `json:",omitempty"`
+}
+
+// PeerPathStats are statistics about the paths packets take between this
+// node and a peer, as kept by magicsock. They're cumulative for as long as
+// magicsock has tracked the peer, for dashboards to sample over time.
+type PeerPathStats struct {
+	// DirectRxBytes and DirectTxBytes are the bytes of WireGuard packets
+	// received from and sent to the peer over direct UDP paths.
+	DirectRxBytes int64
+	DirectTxBytes int64
+
+	// DERPRxBytes and DERPTxBytes are the bytes of WireGuard packets
+	// received from and sent to the peer over DERP.
+	DERPRxBytes int64
+	DERPTxBytes int64
+
+	// CurAddr is the direct UDP address packets to the peer are sent
+	// to, or empty if they're only sent over DERP.
+	CurAddr string `json:",omitempty"`
+
+	// LastHandshake is the time of the last WireGuard handshake with
+	// the peer, or zero if there's been none.
+	LastHandshake time.Time
+
+	// LastPathChange is when the peer's direct path last changed, or
+	// zero if it never has, and LastPathChangeReason is why, such as
+	// "pong-better-addr" or "bad-endpoint".
+	LastPathChange       time.Time
+	LastPathChangeReason string `json:",omitempty"`
+
+	// RTTHistogram counts the round-trip times of disco pings to the
+	// peer over direct paths.
+	RTTHistogram []RTTBucket `json:",omitempty"`
+}
+
+// RTTBucket is a bucket of a PeerPathStats.RTTHistogram.
+type RTTBucket struct {
+	// MaxMS is the inclusive upper bound of the bucket in milliseconds,
+	// or zero for the last bucket, which has none.
+	MaxMS int `json:",omitempty"`
+	// Count is the number of round trips in the bucket, and not in the
+	// buckets before it.
+	Count int64
 }
 
 // HasCap reports whether ps has the given capability.
@@ -513,6 +582,12 @@ func (sb *StatusBuilder) AddPeer(peer key.NodePublic, st *PeerStatus) {
 		e.Capabilities = v
 	}
 	e.Location = st.Location
+	if v := st.PathStats; v != nil {
+		e.PathStats = v
+	}
+	if e.PathStats != nil {
+		e.PathStats.LastHandshake = e.LastHandshake
+	}
 }
 
 type StatusUpdater interface {
@@ -748,6 +823,63 @@ type DebugDERPRegionReport struct {
 	Errors   []string
 }
 
+// TUNCapabilities describes the tun driver tailscaled is using, as
+// reported by "tailscale debug tun-caps".
+type TUNCapabilities struct {
+	// Driver names the driver, such as "tun(4)" or "utun".
+	Driver string
+	// OSVersion is the kernel release the capabilities were probed on.
+	OSVersion string `json:",omitempty"`
+	// MultiQueue is whether the driver can spread packets over several
+	// queues (IFF_MULTI_QUEUE on Linux).
+	MultiQueue bool
+	// LinkLayerModes lists the framing modes the driver supports, such
+	// as "p2p", "multi-af" (an address family header per packet) or
+	// "tap" (Ethernet frames).
+	LinkLayerModes []string
+	// MaxMTU is the largest MTU the driver accepts.
+	MaxMTU int
+	// ChecksumOffload is whether the driver can exchange packets with
+	// the kernel without finished checksums (e.g. virtio-net headers).
+	ChecksumOffload bool
+	// DeviceBatchSize is the number of packets the device returns per
+	// read.
+	DeviceBatchSize int
+	// IOMode is how tailscaled reads from the device: "batch" if the
+	// device batches natively, or "single".
+	IOMode string
+}
+
+// RouterState is the state of tailscaled's router after it was last
+// configured, as reported by "tailscale debug routes".
+type RouterState struct {
+	// Interface is the name of the Tailscale interface.
+	Interface string
+	// LocalAddrs are the addresses configured on Interface.
+	LocalAddrs []netip.Prefix
+	// Routes are the routes the router installed or was asked to.
+	Routes []RouteState
+	// Error is why configuring the router last failed, if it did.
+	Error string `json:",omitempty"`
+}
+
+// RouteState is the state of one of the router's routes.
+type RouteState struct {
+	Dst netip.Prefix
+	// Dev is the local interface the route points into, for routes that
+	// bypass Tailscale, or empty for routes into the Tailscale interface.
+	Dev string `json:",omitempty"`
+	// Priority is the route's priority on OpenBSD, or 0 for the default.
+	Priority int `json:",omitempty"`
+	// Installed is whether the route is in the kernel's routing table.
+	Installed bool
+	// Wanted is whether the router's configuration has the route. A
+	// route that's installed but not wanted failed to be removed.
+	Wanted bool
+	// Error is why the router last failed to change the route, if it did.
+	Error string `json:",omitempty"`
+}
+
 type SelfUpdateStatus string
 
 const (
@@ -6,12 +6,14 @@
 package localapi
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
 	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/util/httpm"
 )
 
 func (h *Handler) serveCert(w http.ResponseWriter, r *http.Request) {
@@ -46,6 +48,19 @@ func (h *Handler) serveCert(w http.ResponseWriter, r *http.Request) {
 	serveKeyPair(w, r, pair)
 }
 
+func (h *Handler) serveCertStatus(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "cert-status access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "want GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.b.CertStatus())
+}
+
 func serveKeyPair(w http.ResponseWriter, r *http.Request, p *ipnlocal.TLSCertKeyPair) {
 	w.Header().Set("Content-Type", "text/plain")
 	switch r.URL.Query().Get("type") {
@@ -13,3 +13,7 @@
 func (h *Handler) serveCert(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "disabled on "+runtime.GOOS, http.StatusNotFound)
 }
+
+func (h *Handler) serveCertStatus(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "disabled on "+runtime.GOOS, http.StatusNotFound)
+}
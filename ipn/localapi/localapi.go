@@ -34,6 +34,7 @@
 	"golang.org/x/net/dns/dnsmessage"
 	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/clientupdate"
+	"tailscale.com/disco"
 	"tailscale.com/drive"
 	"tailscale.com/envknob"
 	"tailscale.com/hostinfo"
@@ -42,14 +43,18 @@
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/logtail"
+	"tailscale.com/net/carp"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/netutil"
 	"tailscale.com/net/portmapper"
+	"tailscale.com/net/tstun"
+	"tailscale.com/safesocket"
 	"tailscale.com/tailcfg"
 	"tailscale.com/taildrop"
 	"tailscale.com/tka"
 	"tailscale.com/tstime"
 	"tailscale.com/types/dnstype"
+	"tailscale.com/types/ipproto"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
@@ -60,12 +65,14 @@
 	"tailscale.com/util/httpm"
 	"tailscale.com/util/mak"
 	"tailscale.com/util/osdiag"
+	"tailscale.com/util/osuser"
 	"tailscale.com/util/progresstracking"
 	"tailscale.com/util/rands"
 	"tailscale.com/util/syspolicy/rsop"
 	"tailscale.com/util/syspolicy/setting"
 	"tailscale.com/version"
 	"tailscale.com/wgengine/magicsock"
+	"tailscale.com/wgengine/router"
 )
 
 type LocalAPIHandler func(*Handler, http.ResponseWriter, *http.Request)
@@ -75,16 +82,22 @@
 // then it's a prefix match.
 var handler = map[string]LocalAPIHandler{
 	// The prefix match handlers end with a slash:
-	"cert/":     (*Handler).serveCert,
-	"file-put/": (*Handler).serveFilePut,
-	"files/":    (*Handler).serveFiles,
-	"policy/":   (*Handler).servePolicy,
-	"profiles/": (*Handler).serveProfiles,
+	"cert/":               (*Handler).serveCert,
+	"file-put/":           (*Handler).serveFilePut,
+	"file-resume-offset/": (*Handler).serveFileResumeOffset,
+	"files/":              (*Handler).serveFiles,
+	"policy/":             (*Handler).servePolicy,
+	"profiles/":           (*Handler).serveProfiles,
 
 	// The other /localapi/v0/NAME handlers are exact matches and contain only NAME
 	// without a trailing slash:
+	"acl-check":                   (*Handler).serveACLCheck,
 	"alpha-set-device-attrs":      (*Handler).serveSetDeviceAttrs, // see tailscale/corp#24690
+	"backup":                      (*Handler).serveBackup,
+	"bench":                       (*Handler).serveBench,
 	"bugreport":                   (*Handler).serveBugReport,
+	"carp-state":                  (*Handler).serveCARPState,
+	"cert-status":                 (*Handler).serveCertStatus,
 	"check-ip-forwarding":         (*Handler).serveCheckIPForwarding,
 	"check-prefs":                 (*Handler).serveCheckPrefs,
 	"check-udp-gro-forwarding":    (*Handler).serveCheckUDPGROForwarding,
@@ -97,6 +110,9 @@
 	"debug-packet-filter-rules":   (*Handler).serveDebugPacketFilterRules,
 	"debug-peer-endpoint-changes": (*Handler).serveDebugPeerEndpointChanges,
 	"debug-portmap":               (*Handler).serveDebugPortmap,
+	"debug-router-changes":        (*Handler).serveDebugRouterChanges,
+	"debug-router-state":          (*Handler).serveDebugRouterState,
+	"debug-tun-caps":              (*Handler).serveDebugTUNCaps,
 	"derpmap":                     (*Handler).serveDERPMap,
 	"dev-set-state-store":         (*Handler).serveDevSetStateStore,
 	"dial":                        (*Handler).serveDial,
@@ -106,6 +122,7 @@
 	"drive/fileserver-address":    (*Handler).serveDriveServerAddr,
 	"drive/shares":                (*Handler).serveShares,
 	"file-targets":                (*Handler).serveFileTargets,
+	"filter-stats":                (*Handler).serveFilterStats,
 	"goroutines":                  (*Handler).serveGoroutines,
 	"handle-push-message":         (*Handler).serveHandlePushMessage,
 	"id-token":                    (*Handler).serveIDToken,
@@ -113,11 +130,15 @@
 	"logout":                      (*Handler).serveLogout,
 	"logtap":                      (*Handler).serveLogTap,
 	"metrics":                     (*Handler).serveMetrics,
+	"metrics/prometheus":          (*Handler).servePrometheusMetrics,
+	"network-change":              (*Handler).serveNetworkChange,
+	"peer-path-stats":             (*Handler).servePeerPathStats,
 	"ping":                        (*Handler).servePing,
 	"pprof":                       (*Handler).servePprof,
 	"prefs":                       (*Handler).servePrefs,
 	"query-feature":               (*Handler).serveQueryFeature,
 	"reload-config":               (*Handler).reloadConfig,
+	"remote-diag":                 (*Handler).serveRemoteDiag,
 	"reset-auth":                  (*Handler).serveResetAuth,
 	"serve-config":                (*Handler).serveServeConfig,
 	"set-dns":                     (*Handler).serveSetDNS,
@@ -126,20 +147,25 @@
 	"set-push-device-token":       (*Handler).serveSetPushDeviceToken,
 	"set-udp-gro-forwarding":      (*Handler).serveSetUDPGROForwarding,
 	"set-use-exit-node-enabled":   (*Handler).serveSetUseExitNodeEnabled,
+	"ssh-recordings":              (*Handler).serveSSHRecordings,
 	"start":                       (*Handler).serveStart,
 	"status":                      (*Handler).serveStatus,
 	"suggest-exit-node":           (*Handler).serveSuggestExitNode,
 	"tka/affected-sigs":           (*Handler).serveTKAAffectedSigs,
 	"tka/cosign-recovery-aum":     (*Handler).serveTKACosignRecoveryAUM,
 	"tka/disable":                 (*Handler).serveTKADisable,
+	"tka/export-log":              (*Handler).serveTKAExportLog,
 	"tka/force-local-disable":     (*Handler).serveTKALocalDisable,
 	"tka/generate-recovery-aum":   (*Handler).serveTKAGenerateRecoveryAUM,
+	"tka/generate-rotation-aum":   (*Handler).serveTKAGenerateRotationAUM,
 	"tka/init":                    (*Handler).serveTKAInit,
 	"tka/log":                     (*Handler).serveTKALog,
 	"tka/modify":                  (*Handler).serveTKAModify,
 	"tka/sign":                    (*Handler).serveTKASign,
 	"tka/status":                  (*Handler).serveTKAStatus,
 	"tka/submit-recovery-aum":     (*Handler).serveTKASubmitRecoveryAUM,
+	"tka/submit-rotation-aum":     (*Handler).serveTKASubmitRotationAUM,
+	"tka/submit-signature":        (*Handler).serveTKASubmitSignature,
 	"tka/verify-deeplink":         (*Handler).serveTKAVerifySigningDeeplink,
 	"tka/wrap-preauth-key":        (*Handler).serveTKAWrapPreauthKey,
 	"update/check":                (*Handler).serveUpdateCheck,
@@ -148,6 +174,7 @@
 	"upload-client-metrics":       (*Handler).serveUploadClientMetrics,
 	"usermetrics":                 (*Handler).serveUserMetrics,
 	"watch-ipn-bus":               (*Handler).serveWatchIPNBus,
+	"watch-network-changes":       (*Handler).serveWatchNetworkChanges,
 	"whois":                       (*Handler).serveWhoIs,
 }
 
@@ -193,6 +220,11 @@ type Handler struct {
 	// cert fetching access.
 	PermitCert bool
 
+	// PermitKeys is whether the client is additionally granted access
+	// to the node's private keys, as in backups that include them.
+	// Unlike PermitWrite, it's not granted to the operator user.
+	PermitKeys bool
+
 	// Actor is the identity of the client connected to the Handler.
 	Actor ipnauth.Actor
 
@@ -619,6 +651,36 @@ func (h *Handler) serveUserMetrics(w http.ResponseWriter, r *http.Request) {
 	h.b.UserMetricsRegistry().Handler(w, r)
 }
 
+// servePrometheusMetrics returns all of tailscaled's metrics in Prometheus
+// text exposition format, for scraping: the user-facing metrics, the packet
+// filter's drops by reason if filter stats are enabled, and the internal
+// clientmetrics. Only the names starting with "tailscaled_" are stable; the
+// clientmetrics come and go between releases.
+func (h *Handler) servePrometheusMetrics(w http.ResponseWriter, r *http.Request) {
+	metricPromMetricsCalls.Add(1)
+	// Like serveMetrics, as this includes the clientmetrics.
+	if !h.PermitWrite {
+		http.Error(w, "metric access denied", http.StatusForbidden)
+		return
+	}
+	h.b.UserMetricsRegistry().Handler(w, r)
+	if st, err := h.b.FilterStats(); err == nil && st.Enabled {
+		writeFilterDropMetrics(w, st.DropsByReason)
+	}
+	clientmetric.WritePrometheusExpositionFormat(w)
+}
+
+// writeFilterDropMetrics writes the packet filter's drop counts, keyed by
+// the reason the filter gave, as a Prometheus counter.
+func writeFilterDropMetrics(w io.Writer, dropsByReason map[string]uint64) {
+	const name = "tailscaled_filter_dropped_packets_total"
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	fmt.Fprintf(w, "# HELP %s Counts the incoming packets dropped by the packet filter while filter stats are enabled, by the filter's reason\n", name)
+	for _, reason := range slices.Sorted(maps.Keys(dropsByReason)) {
+		fmt.Fprintf(w, "%s{reason=%q} %d\n", name, reason, dropsByReason[reason])
+	}
+}
+
 func (h *Handler) serveDebug(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "debug access denied", http.StatusForbidden)
@@ -780,6 +842,16 @@ func (h *Handler) serveDebugPortmap(w http.ResponseWriter, r *http.Request) {
 		debugKnobs.LogHTTP = true
 	}
 
+	verify := defBool(r.FormValue("verify"), false)
+	var verifyPeer netip.Addr
+	if v := r.FormValue("verify_peer"); v != "" {
+		verifyPeer, err = netip.ParseAddr(v)
+		if err != nil {
+			http.Error(w, "invalid 'verify_peer' parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
 	var (
 		logLock     sync.Mutex
 		handlerDone bool
@@ -891,6 +963,83 @@ func (h *Handler) serveDebugPortmap(w http.ResponseWriter, r *http.Request) {
 		} else {
 			h.logf("serveDebugPortmap: context done: %v", ctx.Err())
 		}
+		return
+	}
+
+	if verify {
+		ext, ok := c.GetCachedMappingOrStartCreatingOne()
+		if !ok {
+			logf("verify: no mapping to verify")
+			return
+		}
+		h.verifyPortmap(r.Context(), logf, uc, ext, verifyPeer)
+	}
+}
+
+// portmapVerifyTimeout is how long verifyPortmap waits for a peer's probe
+// to arrive through the port mapping being verified.
+const portmapVerifyTimeout = 10 * time.Second
+
+// verifyPortmap checks that packets sent to ext, the external address of a
+// port mapping to uc, actually arrive on uc: port mapping services
+// sometimes report success for mappings that nothing arrives through.
+//
+// It asks a peer, over DERP, to send disco pings to ext, and waits for one
+// to arrive. As uc isn't used by magicsock, a ping arriving on it can only
+// have come through the mapping. If peerIP is invalid, the first online
+// peer with a home DERP region is asked. It reports whether a ping arrived.
+func (h *Handler) verifyPortmap(ctx context.Context, logf logger.Logf, uc net.PacketConn, ext netip.AddrPort, peerIP netip.Addr) bool {
+	nm := h.b.NetMap()
+	if nm == nil {
+		logf("verify: no netmap")
+		return false
+	}
+	var peer tailcfg.NodeView
+	if peerIP.IsValid() {
+		p, ok := nm.PeerByTailscaleIP(peerIP)
+		if !ok {
+			logf("verify: no peer with IP %v", peerIP)
+			return false
+		}
+		peer = p
+	} else {
+		for _, p := range nm.Peers {
+			if online, ok := p.Online().GetOk(); ok && online && p.HomeDERP() != 0 && !p.DiscoKey().IsZero() {
+				peer = p
+				break
+			}
+		}
+		if !peer.Valid() {
+			logf("verify: no online peer to probe from")
+			return false
+		}
+	}
+
+	logf("verify: asking %v (%v) to probe %v", peer.Name(), peer.Key().ShortString(), ext)
+	peerDisco, err := h.b.MagicConn().DebugSendCallMeMaybe(peer.Key(), []netip.AddrPort{ext})
+	if err != nil {
+		logf("verify: %v", err)
+		return false
+	}
+	peerDiscoRaw := peerDisco.Raw32()
+
+	ctx, cancel := context.WithTimeout(ctx, portmapVerifyTimeout)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { uc.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	buf := make([]byte, 1500)
+	for {
+		n, src, err := uc.ReadFrom(buf)
+		if err != nil {
+			logf("verify: FAILED: no probe from %v arrived through %v within %v", peer.Key().ShortString(), ext, portmapVerifyTimeout)
+			return false
+		}
+		if sender, ok := disco.Source(buf[:n]); ok && bytes.Equal(sender, peerDiscoRaw[:]) {
+			logf("verify: OK: probe from %v (%v) arrived through %v", peer.Key().ShortString(), src, ext)
+			return true
+		}
+		logf("verify: ignoring unexpected %d byte packet from %v", n, src)
 	}
 }
 
@@ -1036,6 +1185,142 @@ func (h *Handler) reloadConfig(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(&res)
 }
 
+// serveNetworkChange tells tailscaled that the network configuration has
+// probably changed, such as when a DHCP client obtains a new lease, so that
+// it rechecks the network state right away rather than waiting to notice.
+//
+// The optional "reason" query parameter is logged.
+func (h *Handler) serveNetworkChange(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "network-change access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	reason := r.FormValue("reason")
+	if reason == "" {
+		reason = "unspecified"
+	}
+	h.logf("network change reported by client: %s", reason)
+	h.b.NetMon().InjectEvent()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveWatchNetworkChanges streams the network changes seen by tailscaled's
+// network monitor, as JSON netmon.ChangeEvents, starting with one describing
+// the current network state, until the client goes away.
+func (h *Handler) serveWatchNetworkChanges(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "watch network changes access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "not a flusher", http.StatusInternalServerError)
+		return
+	}
+
+	ctx := r.Context()
+	netMon := h.b.NetMon()
+	changes := netMon.Subscribe(ctx)
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(netmon.StateEvent(netMon.InterfaceState())); err != nil {
+		return
+	}
+	f.Flush()
+	for delta := range changes {
+		if err := enc.Encode(delta.Event()); err != nil {
+			h.logf("json.Encode: %v", err)
+			return
+		}
+		f.Flush()
+	}
+}
+
+// serveCARPState is called by CARP state change hooks (see 'tailscale
+// configure carp') to hand subnet routes over to or from the other router
+// of an HA pair immediately, rather than when tailscaled next polls.
+func (h *Handler) serveCARPState(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "carp-state access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	st, err := carp.ParseState(r.FormValue("state"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.logf("CARP state %v reported by client", st)
+	h.b.SetRoutesStandby(st != carp.Master)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveRemoteDiag lists (GET) the control plane's requests for diagnostics
+// waiting for the local user's consent, or approves or denies one (POST).
+// serveSSHRecordings lists the Tailscale SSH session recordings kept on
+// this node. Like the recordings themselves, it's for admins only.
+func (h *Handler) serveSSHRecordings(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "ssh-recordings access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	files, err := h.b.SSHRecordings()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if files == nil {
+		files = []apitype.SSHRecording{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(files)
+}
+
+func (h *Handler) serveRemoteDiag(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case httpm.GET:
+		if !h.PermitRead {
+			http.Error(w, "remote-diag access denied", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.b.RemoteDiagRequests())
+	case httpm.POST:
+		if !h.PermitWrite {
+			http.Error(w, "remote-diag modify access denied", http.StatusForbidden)
+			return
+		}
+		approve, err := strconv.ParseBool(r.FormValue("approve"))
+		if err != nil {
+			http.Error(w, "invalid 'approve' parameter", http.StatusBadRequest)
+			return
+		}
+		if err := h.b.DecideRemoteDiag(r.FormValue("id"), approve); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "use GET or POST", http.StatusMethodNotAllowed)
+	}
+}
+
 func (h *Handler) serveResetAuth(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "reset-auth modify access denied", http.StatusForbidden)
@@ -1105,6 +1390,58 @@ func (h *Handler) serveServeConfig(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// serveBackup returns a backup of the node's configuration on GET, with its
+// keys if the "keys" parameter is true, and restores the backup in the
+// request body on POST.
+func (h *Handler) serveBackup(w http.ResponseWriter, r *http.Request) {
+	// Backups hold secrets, such as TLS keys, even without node keys.
+	if !h.PermitWrite {
+		http.Error(w, "backup access denied", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		includeKeys, _ := strconv.ParseBool(r.FormValue("keys"))
+		if includeKeys && !h.PermitKeys {
+			http.Error(w, "backup of node keys access denied", http.StatusForbidden)
+			return
+		}
+		b, err := h.b.CreateBackup(includeKeys)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(b)
+	case "POST":
+		b := new(ipn.Backup)
+		if err := json.NewDecoder(r.Body).Decode(b); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if b.IncludesKeys && !h.PermitKeys {
+			http.Error(w, "restore of node keys access denied", http.StatusForbidden)
+			return
+		}
+		for _, p := range b.Profiles {
+			if p.ServeConfig == nil {
+				continue
+			}
+			if err := authorizeServeConfigForGOOSAndUserContext(runtime.GOOS, p.ServeConfig, h); err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+		}
+		if err := h.b.RestoreBackup(b); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 func authorizeServeConfigForGOOSAndUserContext(goos string, configIn *ipn.ServeConfig, h *Handler) error {
 	switch goos {
 	case "windows", "linux", "darwin", "illumos", "solaris":
@@ -1137,6 +1474,78 @@ func authorizeServeConfigForGOOSAndUserContext(goos string, configIn *ipn.ServeC
 
 }
 
+// serveACLCheck reports whether the node's packet filter permits traffic
+// from the "src" IP to the "dst" ip:port using protocol "proto" (TCP if
+// empty).
+func (h *Handler) serveACLCheck(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "acl-check access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "want GET", http.StatusMethodNotAllowed)
+		return
+	}
+	src, err := netip.ParseAddr(r.FormValue("src"))
+	if err != nil {
+		http.Error(w, "invalid 'src' parameter", http.StatusBadRequest)
+		return
+	}
+	dst, err := netip.ParseAddrPort(r.FormValue("dst"))
+	if err != nil {
+		http.Error(w, "invalid 'dst' parameter", http.StatusBadRequest)
+		return
+	}
+	proto := ipproto.TCP
+	if v := r.FormValue("proto"); v != "" {
+		if err := proto.UnmarshalText([]byte(v)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	res, err := h.b.CheckACL(src.Unmap(), netip.AddrPortFrom(dst.Addr().Unmap(), dst.Port()), proto)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// serveFilterStats returns the packet filter's per-rule counters and
+// recent drops on GET, and enables or disables them on POST with the
+// "enable" parameter.
+func (h *Handler) serveFilterStats(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case httpm.GET:
+		if !h.PermitRead {
+			http.Error(w, "filter-stats access denied", http.StatusForbidden)
+			return
+		}
+	case httpm.POST:
+		if !h.PermitWrite {
+			http.Error(w, "filter-stats access denied", http.StatusForbidden)
+			return
+		}
+		enable, err := strconv.ParseBool(r.FormValue("enable"))
+		if err != nil {
+			http.Error(w, "invalid 'enable' parameter", http.StatusBadRequest)
+			return
+		}
+		h.b.SetFilterStats(enable)
+	default:
+		http.Error(w, "want GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+	res, err := h.b.FilterStats()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
 func (h *Handler) serveCheckIPForwarding(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitRead {
 		http.Error(w, "IP forwarding check access denied", http.StatusForbidden)
@@ -1205,6 +1614,25 @@ func (h *Handler) serveStatus(w http.ResponseWriter, r *http.Request) {
 	e.Encode(st)
 }
 
+// servePeerPathStats serves the ipnstate.PeerPathStats of each peer that
+// has them, keyed by node key.
+func (h *Handler) servePeerPathStats(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "status access denied", http.StatusForbidden)
+		return
+	}
+	res := make(map[key.NodePublic]*ipnstate.PeerPathStats)
+	for k, ps := range h.b.Status().Peer {
+		if ps.PathStats != nil {
+			res[k] = ps.PathStats
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(res)
+}
+
 func (h *Handler) serveDebugPeerEndpointChanges(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitRead {
 		http.Error(w, "status access denied", http.StatusForbidden)
@@ -1233,6 +1661,58 @@ func (h *Handler) serveDebugPeerEndpointChanges(w http.ResponseWriter, r *http.R
 	e.Encode(chs)
 }
 
+func (h *Handler) serveDebugTUNCaps(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	caps, ok := tstun.Capabilities()
+	if !ok {
+		http.Error(w, "no tun device in use", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(caps)
+}
+
+// serveDebugRouterChanges returns the address and route changes that the
+// router skipped in dry-run mode.
+func (h *Handler) serveDebugRouterChanges(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	changes, ok := router.PendingChanges()
+	if !ok {
+		http.Error(w, "router not in dry-run mode; set TS_DEBUG_ROUTER_DRY_RUN", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(changes)
+}
+
+// serveDebugRouterState returns the router's local addresses and routes,
+// and why changing them last failed, if it did.
+func (h *Handler) serveDebugRouterState(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	st, ok := router.State()
+	if !ok {
+		http.Error(w, "router state not available on this platform, or not configured yet", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(st)
+}
+
 // InUseOtherUserIPNStream reports whether r is a request for the watch-ipn-bus
 // handler. If so, it writes an ipn.Notify InUseOtherUser message to the user
 // and returns true. Otherwise it returns false, in which case it doesn't write
@@ -1311,7 +1791,15 @@ func (h *Handler) serveLoginInteractive(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "want POST", http.StatusBadRequest)
 		return
 	}
-	h.b.StartLoginInteractiveAs(r.Context(), h.Actor)
+	switch flow := r.FormValue("flow"); flow {
+	case "", "browser":
+		h.b.StartLoginInteractiveAs(r.Context(), h.Actor)
+	case "device-code":
+		h.b.StartDeviceCodeLoginAs(r.Context(), h.Actor)
+	default:
+		http.Error(w, fmt.Sprintf("unknown login flow %q", flow), http.StatusBadRequest)
+		return
+	}
 	w.WriteHeader(http.StatusNoContent)
 	return
 }
@@ -1674,7 +2162,12 @@ func (h *Handler) serveFilePut(w http.ResponseWriter, r *http.Request) {
 			Name:         filenameEscaped,
 			DeclaredSize: r.ContentLength,
 		}
-		h.singleFilePut(r.Context(), progressUpdates, w, r.Body, dstURL, file)
+		md, err := taildrop.FileMetadataFromHeader(r.Header)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.singleFilePut(r.Context(), progressUpdates, w, r.Body, dstURL, file, md)
 	case "POST":
 		h.multiFilePost(progressUpdates, w, r, peerID, dstURL)
 	default:
@@ -1732,7 +2225,12 @@ func (h *Handler) multiFilePost(progressUpdates chan (ipn.OutgoingFile), w http.
 			continue
 		}
 
-		if !h.singleFilePut(r.Context(), progressUpdates, ww, part, dstURL, outgoingFilesByName[part.FileName()]) {
+		md, err := taildrop.FileMetadataFromHeader(http.Header(part.Header))
+		if err != nil {
+			http.Error(ww, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !h.singleFilePut(r.Context(), progressUpdates, ww, part, dstURL, outgoingFilesByName[part.FileName()], md) {
 			return
 		}
 
@@ -1792,6 +2290,7 @@ func (h *Handler) singleFilePut(
 	body io.Reader,
 	dstURL *url.URL,
 	outgoingFile ipn.OutgoingFile,
+	md taildrop.FileMetadata,
 ) bool {
 	outgoingFile.Started = time.Now()
 	body = progresstracking.NewReader(body, 1*time.Second, func(n int, err error) {
@@ -1815,6 +2314,44 @@ func (h *Handler) singleFilePut(
 		Transport: h.b.Dialer().PeerAPITransport(),
 		Timeout:   10 * time.Second,
 	}
+
+	// Peers that can receive files in chunks report the chunks they have
+	// of this one, if any. Send to those in chunks, so that a chunk that
+	// fails to send is retried rather than the whole transfer failing.
+	//
+	// Unless the client sent the file's checksum, compute it as it's sent,
+	// for the peer to verify the whole file against once it has the last
+	// chunk.
+	if have, ok := h.partialChunks(ctx, client, dstURL, outgoingFile.Name); ok {
+		sum := sha256.New()
+		resumed, err := taildrop.SendChunks(ctx, h.logf, io.TeeReader(body, sum), have, func(ctx context.Context, offset int64, chunk []byte, final bool) (taildrop.ChunkChecksum, error) {
+			if final && md.SHA256 == "" {
+				md.SHA256 = hex.EncodeToString(sum.Sum(nil))
+			}
+			return h.putChunk(ctx, dstURL, outgoingFile, offset, chunk, final, md)
+		})
+		if resumed > 0 {
+			h.logf("resumed chunked put at offset %d", resumed)
+		}
+		if err != nil {
+			h.logf("chunked put: %v", err)
+			status := http.StatusBadGateway
+			var pe *peerPutError
+			if errors.As(err, &pe) {
+				status = pe.status
+			}
+			http.Error(w, err.Error(), status)
+			fail()
+			return false
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, "{}\n")
+		outgoingFile.Finished = true
+		outgoingFile.Succeeded = true
+		progressUpdates <- outgoingFile
+		return true
+	}
+
 	req, err := http.NewRequestWithContext(ctx, "GET", dstURL.String()+"/v0/put/"+outgoingFile.Name, nil)
 	if err != nil {
 		http.Error(w, "bogus peer URL", http.StatusInternalServerError)
@@ -1848,6 +2385,7 @@ func (h *Handler) singleFilePut(
 		return false
 	}
 	outReq.ContentLength = outgoingFile.DeclaredSize
+	md.SetHeader(outReq.Header)
 	if offset > 0 {
 		h.logf("resuming put at offset %d after %v", offset, resumeDuration)
 		rangeHdr, _ := httphdr.FormatRange([]httphdr.Range{{Start: offset, Length: 0}})
@@ -1868,6 +2406,138 @@ func (h *Handler) singleFilePut(
 	return true
 }
 
+// serveFileResumeOffset reports how much of a file the peer has from an
+// earlier, interrupted file-put, as an apitype.FileResumeOffset. Its URL
+// is of the same form as file-put's for a PUT.
+func (h *Handler) serveFileResumeOffset(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "file access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusBadRequest)
+		return
+	}
+	upath, ok := strings.CutPrefix(r.URL.EscapedPath(), "/localapi/v0/file-resume-offset/")
+	if !ok {
+		http.Error(w, "misconfigured", http.StatusInternalServerError)
+		return
+	}
+	peerIDStr, filenameEscaped, ok := strings.Cut(upath, "/")
+	if !ok || filenameEscaped == "" {
+		http.Error(w, "bogus URL", http.StatusBadRequest)
+		return
+	}
+	fts, err := h.b.FileTargets()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	i := slices.IndexFunc(fts, func(ft *apitype.FileTarget) bool {
+		return ft.Node.StableID == tailcfg.StableNodeID(peerIDStr)
+	})
+	if i < 0 {
+		http.Error(w, "node not found", http.StatusNotFound)
+		return
+	}
+	dstURL, err := url.Parse(fts[i].PeerAPIURL)
+	if err != nil {
+		http.Error(w, "bogus peer URL", http.StatusInternalServerError)
+		return
+	}
+	client := &http.Client{
+		Transport: h.b.Dialer().PeerAPITransport(),
+		Timeout:   10 * time.Second,
+	}
+	var res apitype.FileResumeOffset
+	if have, ok := h.partialChunks(r.Context(), client, dstURL, filenameEscaped); ok {
+		res.Offset = taildrop.ResumeOffset(have)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// partialChunks returns the chunks of the file name that the peer at dstURL
+// has from an earlier attempt to send it in chunks, and whether the peer
+// supports receiving files in chunks at all.
+func (h *Handler) partialChunks(ctx context.Context, client *http.Client, dstURL *url.URL, name string) (_ []taildrop.ChunkChecksum, ok bool) {
+	req, err := http.NewRequestWithContext(ctx, "GET", dstURL.String()+"/v0/put/"+name+"?chunks=1", nil)
+	if err != nil {
+		return nil, false
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		h.logf("could not fetch remote chunks: %v", err)
+		return nil, false
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, false
+	}
+	// Older peers ignore the chunks parameter and stream block hashes,
+	// which don't decode as a list.
+	var chunks []taildrop.ChunkChecksum
+	if err := json.NewDecoder(res.Body).Decode(&chunks); err != nil {
+		return nil, false
+	}
+	return chunks, true
+}
+
+// peerPutError is an error response from a peer to a chunk PUT.
+type peerPutError struct {
+	status int
+	msg    string
+}
+
+func (e *peerPutError) Error() string { return e.msg }
+
+// Unwrap returns taildrop.ErrChunkRejected if the chunk shouldn't be sent
+// again.
+func (e *peerPutError) Unwrap() error {
+	if e.status >= 400 && e.status < 500 {
+		return taildrop.ErrChunkRejected
+	}
+	return nil
+}
+
+// putChunk sends chunk, found at offset in f, to the peer at dstURL, along
+// with md if it's the final chunk.
+func (h *Handler) putChunk(ctx context.Context, dstURL *url.URL, f ipn.OutgoingFile, offset int64, chunk []byte, final bool, md taildrop.FileMetadata) (taildrop.ChunkChecksum, error) {
+	q := url.Values{"chunked": {"1"}, "size": {strconv.FormatInt(f.DeclaredSize, 10)}}
+	if final {
+		q.Set("final", "1")
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", dstURL.String()+"/v0/put/"+f.Name+"?"+q.Encode(), bytes.NewReader(chunk))
+	if err != nil {
+		return taildrop.ChunkChecksum{}, err
+	}
+	if offset > 0 {
+		rangeHdr, _ := httphdr.FormatRange([]httphdr.Range{{Start: offset, Length: 0}})
+		req.Header.Set("Range", rangeHdr)
+	}
+	if final {
+		md.SetHeader(req.Header)
+	}
+	client := &http.Client{Transport: h.b.Dialer().PeerAPITransport()}
+	res, err := client.Do(req)
+	if err != nil {
+		return taildrop.ChunkChecksum{}, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return taildrop.ChunkChecksum{}, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return taildrop.ChunkChecksum{}, &peerPutError{res.StatusCode, fmt.Sprintf("%s: %s", res.Status, bytes.TrimSpace(body))}
+	}
+	var cs taildrop.ChunkChecksum
+	if err := json.Unmarshal(body, &cs); err != nil {
+		return taildrop.ChunkChecksum{}, fmt.Errorf("invalid chunk PUT response: %w", err)
+	}
+	return cs, nil
+}
+
 func (h *Handler) serveSetDNS(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "access denied", http.StatusForbidden)
@@ -1931,6 +2601,49 @@ func (h *Handler) serveSetExpirySooner(w http.ResponseWriter, r *http.Request) {
 	io.WriteString(w, "done\n")
 }
 
+// serveBench runs a bandwidth test against the peer with the Tailscale IP
+// in the "ip" parameter, for the "duration" in each "direction" ("upload",
+// "download" or "both", the default).
+func (h *Handler) serveBench(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "bench access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "want POST", http.StatusMethodNotAllowed)
+		return
+	}
+	ip, err := netip.ParseAddr(r.FormValue("ip"))
+	if err != nil {
+		http.Error(w, "invalid 'ip' parameter", http.StatusBadRequest)
+		return
+	}
+	d, err := time.ParseDuration(r.FormValue("duration"))
+	if err != nil {
+		http.Error(w, "invalid 'duration' parameter", http.StatusBadRequest)
+		return
+	}
+	var upload, download bool
+	switch r.FormValue("direction") {
+	case "", "both":
+		upload, download = true, true
+	case "upload":
+		upload = true
+	case "download":
+		download = true
+	default:
+		http.Error(w, "invalid 'direction' parameter", http.StatusBadRequest)
+		return
+	}
+	res, err := h.b.Bench(r.Context(), ip, d, upload, download)
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
 func (h *Handler) servePing(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	if r.Method != "POST" {
@@ -2003,7 +2716,13 @@ func (h *Handler) serveDial(w http.ResponseWriter, r *http.Request) {
 	network := cmp.Or(r.Header.Get("Dial-Network"), "tcp")
 
 	addr := net.JoinHostPort(hostStr, portStr)
-	outConn, err := h.b.Dialer().UserDial(r.Context(), network, addr)
+	var outConn net.Conn
+	var err error
+	if via := r.Header.Get("Dial-Via"); via != "" {
+		outConn, err = h.b.Dialer().UserDialVia(r.Context(), network, addr, via)
+	} else {
+		outConn, err = h.b.Dialer().UserDial(r.Context(), network, addr)
+	}
 	if err != nil {
 		http.Error(w, "dial failure: "+err.Error(), http.StatusBadGateway)
 		return
@@ -2217,6 +2936,29 @@ type signRequest struct {
 	w.WriteHeader(http.StatusOK)
 }
 
+func (h *Handler) serveTKASubmitSignature(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "lock sign access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sig, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 4096))
+	if err != nil {
+		http.Error(w, "reading signature", http.StatusBadRequest)
+		return
+	}
+	if err := h.b.NetworkLockSubmitSignature(sig); err != nil {
+		http.Error(w, "submitting signature failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
 func (h *Handler) serveTKAInit(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "lock init access denied", http.StatusForbidden)
@@ -2421,6 +3163,27 @@ func (h *Handler) serveTKALog(w http.ResponseWriter, r *http.Request) {
 	w.Write(j)
 }
 
+func (h *Handler) serveTKAExportLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	export, err := h.b.NetworkLockExportLog()
+	if err != nil {
+		http.Error(w, "exporting log failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	j, err := json.MarshalIndent(export, "", "\t")
+	if err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(j)
+}
+
 func (h *Handler) serveTKAAffectedSigs(w http.ResponseWriter, r *http.Request) {
 	if r.Method != httpm.POST {
 		http.Error(w, "use POST", http.StatusMethodNotAllowed)
@@ -2544,6 +3307,65 @@ func (h *Handler) serveTKASubmitRecoveryAUM(w http.ResponseWriter, r *http.Reque
 	w.WriteHeader(http.StatusOK)
 }
 
+func (h *Handler) serveTKAGenerateRotationAUM(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	type rotateRequest struct {
+		AddKeys           []tka.Key
+		RemoveKeys        []tkatype.KeyID
+		DisablementValues [][]byte
+	}
+	var req rotateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON for rotateRequest body", http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.b.NetworkLockGenerateRotationAUM(req.AddKeys, req.RemoveKeys, req.DisablementValues)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(res.Serialize())
+}
+
+func (h *Handler) serveTKASubmitRotationAUM(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body := io.LimitReader(r.Body, 1024*1024)
+	aumBytes, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(w, "reading AUM", http.StatusBadRequest)
+		return
+	}
+	var aum tka.AUM
+	if err := aum.Unserialize(aumBytes); err != nil {
+		http.Error(w, "decoding AUM", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.b.NetworkLockSubmitRotationAUM(&aum); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 // serveProfiles serves profile switching-related endpoints. Supported methods
 // and paths are:
 //   - GET /profiles/: list all profiles (JSON-encoded array of ipn.LoginProfiles)
@@ -2899,6 +3721,19 @@ func (h *Handler) serveDriveServerAddr(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 }
 
+// shareAsUser returns the name of the user named by the client to share as,
+// when we can't tell who the client is.
+func shareAsUser(name string) (string, error) {
+	u, err := osuser.LookupByUsername(name)
+	if err != nil {
+		return "", fmt.Errorf("can't share as %q: %w", name, err)
+	}
+	if u.Uid == "0" {
+		return "", errors.New("refusing to share as root")
+	}
+	return u.Username, nil
+}
+
 // serveShares handles the management of Taildrive shares.
 //
 // PUT - adds or updates an existing share
@@ -2931,11 +3766,23 @@ func (h *Handler) serveShares(w http.ResponseWriter, r *http.Request) {
 		if drive.AllowShareAs() {
 			// share as the connected user
 			username, err := h.Actor.Username()
+			if err != nil && share.As != "" && !safesocket.PlatformUsesPeerCreds() {
+				// Without peer credentials (as on OpenBSD and NetBSD) we
+				// can't tell who's connected, but then only root can
+				// connect, and it may share as whichever user it names.
+				username, err = shareAsUser(share.As)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
 			share.As = username
+		} else {
+			share.As = ""
 		}
 		err = h.b.DriveSetShare(&share)
 		if err != nil {
@@ -3007,6 +3854,7 @@ func (h *Handler) serveShares(w http.ResponseWriter, r *http.Request) {
 	metricFilePutCalls      = clientmetric.NewCounter("localapi_file_put")
 	metricDebugMetricsCalls = clientmetric.NewCounter("localapi_debugmetric_requests")
 	metricUserMetricsCalls  = clientmetric.NewCounter("localapi_usermetric_requests")
+	metricPromMetricsCalls  = clientmetric.NewCounter("localapi_prometheus_metric_requests")
 )
 
 // serveSuggestExitNode serves a POST endpoint for returning a suggested exit node.
@@ -334,6 +334,60 @@ func TestServeWatchIPNBus(t *testing.T) {
 	}
 }
 
+// TestServeBackupKeys tests that only clients permitted to read keys, and
+// not merely to write, such as a non-root operator user, can back up the
+// node keys.
+func TestServeBackupKeys(t *testing.T) {
+	tstest.Replace(t, &validLocalHostForTesting, true)
+
+	tests := []struct {
+		desc        string
+		permitKeys  bool
+		keys        bool
+		wantStatus  int
+		wantHasKeys bool
+	}{
+		{desc: "operator-no-keys", keys: false, wantStatus: http.StatusOK},
+		{desc: "operator-keys", keys: true, wantStatus: http.StatusForbidden},
+		{desc: "root-keys", permitKeys: true, keys: true, wantStatus: http.StatusOK, wantHasKeys: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			h := &Handler{
+				PermitRead:  true,
+				PermitWrite: true,
+				PermitKeys:  tt.permitKeys,
+				b:           newTestLocalBackend(t),
+			}
+			s := httptest.NewServer(h)
+			defer s.Close()
+
+			res, err := s.Client().Get(fmt.Sprintf("%s/localapi/v0/backup?keys=%v", s.URL, tt.keys))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("res.StatusCode=%d, want %d. body: %s", res.StatusCode, tt.wantStatus, body)
+			}
+			if res.StatusCode != http.StatusOK {
+				return
+			}
+			var bk ipn.Backup
+			if err := json.Unmarshal(body, &bk); err != nil {
+				t.Fatal(err)
+			}
+			if bk.IncludesKeys != tt.wantHasKeys {
+				t.Errorf("IncludesKeys = %v, want %v", bk.IncludesKeys, tt.wantHasKeys)
+			}
+		})
+	}
+}
+
 func newTestLocalBackend(t testing.TB) *ipnlocal.LocalBackend {
 	var logf logger.Logf = logger.Discard
 	sys := new(tsd.System)
@@ -93,6 +93,14 @@ type Prefs struct {
 	ExitNodeID tailcfg.StableNodeID
 	ExitNodeIP netip.Addr
 
+	// AutoExitNode, if non-empty, is an expression selecting the exit
+	// nodes to choose from automatically, in which case ExitNodeID is set
+	// by the backend to the best matching node, and changed as the netmap
+	// or the network changes. If no node matches, ExitNodeID is set to
+	// AutoExitNode.AutoString(), which matches no node, so that traffic is
+	// blackholed rather than not using an exit node.
+	AutoExitNode ExitNodeExpression `json:",omitempty"`
+
 	// InternalExitNodePrior is the most recently used ExitNodeID in string form. It is set by
 	// the backend on transition from exit node on to off and used by the
 	// backend.
@@ -232,10 +240,34 @@ type Prefs struct {
 	// AppConnectorPrefs docs for more details.
 	AppConnector AppConnectorPrefs
 
+	// PortMapping sets the preferences for mapping a port on the local
+	// gateway with PCP, NAT-PMP or UPnP. See PortMappingPrefs docs for
+	// more details.
+	PortMapping PortMappingPrefs
+
 	// PostureChecking enables the collection of information used for device
 	// posture checks.
 	PostureChecking bool
 
+	// RemoteDiagnostics is whether the control plane may collect
+	// diagnostics, such as a netcheck report or the route table, from the
+	// node, for debugging it remotely. It's one of the RemoteDiagnostics*
+	// constants; empty means off. The RemoteDiagnostics system policy, if
+	// set, takes precedence.
+	RemoteDiagnostics string `json:",omitempty"`
+
+	// AddrConflictMitigation is how to work around the local network
+	// using addresses in Tailscale's CGNAT or IPv6 ULA ranges, which is
+	// always warned about. It's one of the AddrConflictMitigation*
+	// constants; empty means to only warn.
+	AddrConflictMitigation string `json:",omitempty"`
+
+	// SubnetRouteMetric is the metric to add routes to peers' advertised
+	// subnets with, so that they lose to the system's own routes to the
+	// same networks; see router.Config.RouteMetrics for how each platform
+	// treats it. Zero means the platform's default, which wins over them.
+	SubnetRouteMetric uint32 `json:",omitempty"`
+
 	// NetfilterKind specifies what netfilter implementation to use.
 	//
 	// Linux-only.
@@ -299,6 +331,101 @@ type AppConnectorPrefs struct {
 	Advertise bool
 }
 
+// PortMappingPrefs are the port mapping settings for the node agent, for
+// working around gateways that misbehave with particular protocols or lease
+// lifetimes. The zero value uses the defaults.
+type PortMappingPrefs struct {
+	// Protocols is a comma-separated list of the port mapping protocols to
+	// use, most preferred first, from "pcp", "pmp" (NAT-PMP) and "upnp".
+	// Protocols not listed aren't used. If empty, all are used.
+	Protocols string `json:",omitempty"`
+
+	// LeaseSeconds, if non-zero, is the lease lifetime to request for
+	// mappings, instead of two hours.
+	LeaseSeconds uint32 `json:",omitempty"`
+
+	// RenewMarginSeconds, if non-zero, is how long before a mapping's lease
+	// expires to renew it, instead of halfway through the lease.
+	RenewMarginSeconds uint32 `json:",omitempty"`
+
+	// ExternalPort, if non-zero, is the external port to request for
+	// mappings, instead of letting the gateway pick one.
+	ExternalPort uint16 `json:",omitempty"`
+}
+
+// Values of Prefs.RemoteDiagnostics.
+const (
+	RemoteDiagnosticsOff    = "off"    // the control plane may not collect diagnostics
+	RemoteDiagnosticsPrompt = "prompt" // the local user is asked for each collection
+	RemoteDiagnosticsAllow  = "allow"  // the control plane may collect diagnostics
+)
+
+// ValidateRemoteDiagnostics returns an error if v isn't a valid value of
+// Prefs.RemoteDiagnostics.
+func ValidateRemoteDiagnostics(v string) error {
+	switch v {
+	case "", RemoteDiagnosticsOff, RemoteDiagnosticsPrompt, RemoteDiagnosticsAllow:
+		return nil
+	}
+	return fmt.Errorf("invalid remote diagnostics setting %q; want off, prompt or allow", v)
+}
+
+// Values of Prefs.AddrConflictMitigation.
+const (
+	AddrConflictMitigationNone   = ""       // only warn about conflicts
+	AddrConflictMitigationRoutes = "routes" // route each peer rather than the whole CGNAT range
+	AddrConflictMitigationSNAT   = "snat"   // as "routes", and also SNAT traffic forwarded from the tailnet (Linux only)
+)
+
+// ValidateAddrConflictMitigation returns an error if v isn't a valid value
+// of Prefs.AddrConflictMitigation.
+func ValidateAddrConflictMitigation(v string) error {
+	switch v {
+	case AddrConflictMitigationNone, AddrConflictMitigationRoutes, AddrConflictMitigationSNAT:
+		return nil
+	}
+	return fmt.Errorf("invalid address conflict mitigation %q; want routes, snat or empty", v)
+}
+
+// minPortMappingLeaseSeconds is the shortest LeaseSeconds allowed, to not
+// hammer gateways with renewals.
+const minPortMappingLeaseSeconds = 60
+
+// ProtocolList returns the protocols in pm.Protocols, or nil if all are
+// to be used.
+func (pm PortMappingPrefs) ProtocolList() []string {
+	if pm.Protocols == "" {
+		return nil
+	}
+	protos := strings.Split(pm.Protocols, ",")
+	for i, p := range protos {
+		protos[i] = strings.TrimSpace(p)
+	}
+	return protos
+}
+
+// Validate returns an error if pm isn't valid.
+func (pm PortMappingPrefs) Validate() error {
+	protos := pm.ProtocolList()
+	for i, p := range protos {
+		switch p {
+		case "pcp", "pmp", "upnp":
+		default:
+			return fmt.Errorf("unknown port mapping protocol %q; want pcp, pmp or upnp", p)
+		}
+		if slices.Contains(protos[:i], p) {
+			return fmt.Errorf("port mapping protocol %q listed twice", p)
+		}
+	}
+	if pm.LeaseSeconds != 0 && pm.LeaseSeconds < minPortMappingLeaseSeconds {
+		return fmt.Errorf("port mapping lease of %ds is too short; must be at least %ds", pm.LeaseSeconds, minPortMappingLeaseSeconds)
+	}
+	if pm.LeaseSeconds != 0 && pm.RenewMarginSeconds >= pm.LeaseSeconds {
+		return fmt.Errorf("port mapping renewal margin of %ds must be shorter than the %ds lease", pm.RenewMarginSeconds, pm.LeaseSeconds)
+	}
+	return nil
+}
+
 // MaskedPrefs is a Prefs with an associated bitmask of which fields are set.
 //
 // Each FooSet field maps to a corresponding Foo field in Prefs. FooSet can be
@@ -307,35 +434,40 @@ type AppConnectorPrefs struct {
 type MaskedPrefs struct {
 	Prefs
 
-	ControlURLSet             bool                `json:",omitempty"`
-	RouteAllSet               bool                `json:",omitempty"`
-	ExitNodeIDSet             bool                `json:",omitempty"`
-	ExitNodeIPSet             bool                `json:",omitempty"`
-	InternalExitNodePriorSet  bool                `json:",omitempty"` // Internal; can't be set by LocalAPI clients
-	ExitNodeAllowLANAccessSet bool                `json:",omitempty"`
-	CorpDNSSet                bool                `json:",omitempty"`
-	RunSSHSet                 bool                `json:",omitempty"`
-	RunWebClientSet           bool                `json:",omitempty"`
-	WantRunningSet            bool                `json:",omitempty"`
-	LoggedOutSet              bool                `json:",omitempty"`
-	ShieldsUpSet              bool                `json:",omitempty"`
-	AdvertiseTagsSet          bool                `json:",omitempty"`
-	HostnameSet               bool                `json:",omitempty"`
-	NotepadURLsSet            bool                `json:",omitempty"`
-	ForceDaemonSet            bool                `json:",omitempty"`
-	EggSet                    bool                `json:",omitempty"`
-	AdvertiseRoutesSet        bool                `json:",omitempty"`
-	AdvertiseServicesSet      bool                `json:",omitempty"`
-	NoSNATSet                 bool                `json:",omitempty"`
-	NoStatefulFilteringSet    bool                `json:",omitempty"`
-	NetfilterModeSet          bool                `json:",omitempty"`
-	OperatorUserSet           bool                `json:",omitempty"`
-	ProfileNameSet            bool                `json:",omitempty"`
-	AutoUpdateSet             AutoUpdatePrefsMask `json:",omitempty"`
-	AppConnectorSet           bool                `json:",omitempty"`
-	PostureCheckingSet        bool                `json:",omitempty"`
-	NetfilterKindSet          bool                `json:",omitempty"`
-	DriveSharesSet            bool                `json:",omitempty"`
+	ControlURLSet             bool                 `json:",omitempty"`
+	RouteAllSet               bool                 `json:",omitempty"`
+	ExitNodeIDSet             bool                 `json:",omitempty"`
+	ExitNodeIPSet             bool                 `json:",omitempty"`
+	AutoExitNodeSet           bool                 `json:",omitempty"`
+	InternalExitNodePriorSet  bool                 `json:",omitempty"` // Internal; can't be set by LocalAPI clients
+	ExitNodeAllowLANAccessSet bool                 `json:",omitempty"`
+	CorpDNSSet                bool                 `json:",omitempty"`
+	RunSSHSet                 bool                 `json:",omitempty"`
+	RunWebClientSet           bool                 `json:",omitempty"`
+	WantRunningSet            bool                 `json:",omitempty"`
+	LoggedOutSet              bool                 `json:",omitempty"`
+	ShieldsUpSet              bool                 `json:",omitempty"`
+	AdvertiseTagsSet          bool                 `json:",omitempty"`
+	HostnameSet               bool                 `json:",omitempty"`
+	NotepadURLsSet            bool                 `json:",omitempty"`
+	ForceDaemonSet            bool                 `json:",omitempty"`
+	EggSet                    bool                 `json:",omitempty"`
+	AdvertiseRoutesSet        bool                 `json:",omitempty"`
+	AdvertiseServicesSet      bool                 `json:",omitempty"`
+	NoSNATSet                 bool                 `json:",omitempty"`
+	NoStatefulFilteringSet    bool                 `json:",omitempty"`
+	NetfilterModeSet          bool                 `json:",omitempty"`
+	OperatorUserSet           bool                 `json:",omitempty"`
+	ProfileNameSet            bool                 `json:",omitempty"`
+	AutoUpdateSet             AutoUpdatePrefsMask  `json:",omitempty"`
+	AppConnectorSet           bool                 `json:",omitempty"`
+	PortMappingSet            PortMappingPrefsMask `json:",omitempty"`
+	PostureCheckingSet        bool                 `json:",omitempty"`
+	RemoteDiagnosticsSet      bool                 `json:",omitempty"`
+	AddrConflictMitigationSet bool                 `json:",omitempty"`
+	SubnetRouteMetricSet      bool                 `json:",omitempty"`
+	NetfilterKindSet          bool                 `json:",omitempty"`
+	DriveSharesSet            bool                 `json:",omitempty"`
 }
 
 // SetsInternal reports whether mp has any of the Internal*Set field bools set
@@ -360,6 +492,30 @@ func (m AutoUpdatePrefsMask) Pretty(au AutoUpdatePrefs) string {
 	return strings.Join(fields, " ")
 }
 
+type PortMappingPrefsMask struct {
+	ProtocolsSet          bool `json:",omitempty"`
+	LeaseSecondsSet       bool `json:",omitempty"`
+	RenewMarginSecondsSet bool `json:",omitempty"`
+	ExternalPortSet       bool `json:",omitempty"`
+}
+
+func (m PortMappingPrefsMask) Pretty(pm PortMappingPrefs) string {
+	var fields []string
+	if m.ProtocolsSet {
+		fields = append(fields, fmt.Sprintf("Protocols=%q", pm.Protocols))
+	}
+	if m.LeaseSecondsSet {
+		fields = append(fields, fmt.Sprintf("LeaseSeconds=%v", pm.LeaseSeconds))
+	}
+	if m.RenewMarginSecondsSet {
+		fields = append(fields, fmt.Sprintf("RenewMarginSeconds=%v", pm.RenewMarginSeconds))
+	}
+	if m.ExternalPortSet {
+		fields = append(fields, fmt.Sprintf("ExternalPort=%v", pm.ExternalPort))
+	}
+	return strings.Join(fields, " ")
+}
+
 // ApplyEdits mutates p, assigning fields from m.Prefs for each MaskedPrefs
 // Set field that's true.
 func (p *Prefs) ApplyEdits(m *MaskedPrefs) {
@@ -475,6 +631,9 @@ func (m *MaskedPrefs) Pretty() string {
 			case "AutoUpdateSet":
 				p := mf.Interface().(AutoUpdatePrefsMask).Pretty(mpf.Interface().(AutoUpdatePrefs))
 				fmt.Fprintf(&sb, "%s={%s}", strings.TrimSuffix(name, "Set"), p)
+			case "PortMappingSet":
+				p := mf.Interface().(PortMappingPrefsMask).Pretty(mpf.Interface().(PortMappingPrefs))
+				fmt.Fprintf(&sb, "%s={%s}", strings.TrimSuffix(name, "Set"), p)
 			default:
 				panic(fmt.Sprintf("unexpected MaskedPrefs field %q", name))
 			}
@@ -515,6 +674,8 @@ func (p *Prefs) pretty(goos string) string {
 	}
 	if p.ExitNodeIP.IsValid() {
 		fmt.Fprintf(&sb, "exit=%v lan=%t ", p.ExitNodeIP, p.ExitNodeAllowLANAccess)
+	} else if p.AutoExitNode != "" {
+		fmt.Fprintf(&sb, "exit=%v(%v) lan=%t ", p.AutoExitNode.AutoString(), p.ExitNodeID, p.ExitNodeAllowLANAccess)
 	} else if !p.ExitNodeID.IsZero() {
 		fmt.Fprintf(&sb, "exit=%v lan=%t ", p.ExitNodeID, p.ExitNodeAllowLANAccess)
 	}
@@ -552,8 +713,18 @@ func (p *Prefs) pretty(goos string) string {
 	if p.NetfilterKind != "" {
 		fmt.Fprintf(&sb, "netfilterKind=%s ", p.NetfilterKind)
 	}
+	if p.RemoteDiagnostics != "" {
+		fmt.Fprintf(&sb, "remoteDiag=%s ", p.RemoteDiagnostics)
+	}
+	if p.AddrConflictMitigation != "" {
+		fmt.Fprintf(&sb, "addrConflict=%s ", p.AddrConflictMitigation)
+	}
+	if p.SubnetRouteMetric != 0 {
+		fmt.Fprintf(&sb, "subnetMetric=%d ", p.SubnetRouteMetric)
+	}
 	sb.WriteString(p.AutoUpdate.Pretty())
 	sb.WriteString(p.AppConnector.Pretty())
+	sb.WriteString(p.PortMapping.Pretty())
 	if p.Persist != nil {
 		sb.WriteString(p.Persist.Pretty())
 	} else {
@@ -591,6 +762,7 @@ func (p *Prefs) Equals(p2 *Prefs) bool {
 		p.RouteAll == p2.RouteAll &&
 		p.ExitNodeID == p2.ExitNodeID &&
 		p.ExitNodeIP == p2.ExitNodeIP &&
+		p.AutoExitNode == p2.AutoExitNode &&
 		p.InternalExitNodePrior == p2.InternalExitNodePrior &&
 		p.ExitNodeAllowLANAccess == p2.ExitNodeAllowLANAccess &&
 		p.CorpDNS == p2.CorpDNS &&
@@ -613,7 +785,11 @@ func (p *Prefs) Equals(p2 *Prefs) bool {
 		p.ProfileName == p2.ProfileName &&
 		p.AutoUpdate.Equals(p2.AutoUpdate) &&
 		p.AppConnector == p2.AppConnector &&
+		p.PortMapping == p2.PortMapping &&
 		p.PostureChecking == p2.PostureChecking &&
+		p.RemoteDiagnostics == p2.RemoteDiagnostics &&
+		p.AddrConflictMitigation == p2.AddrConflictMitigation &&
+		p.SubnetRouteMetric == p2.SubnetRouteMetric &&
 		slices.EqualFunc(p.DriveShares, p2.DriveShares, drive.SharesEqual) &&
 		p.NetfilterKind == p2.NetfilterKind
 }
@@ -635,6 +811,13 @@ func (ap AppConnectorPrefs) Pretty() string {
 	return ""
 }
 
+func (pm PortMappingPrefs) Pretty() string {
+	if pm == (PortMappingPrefs{}) {
+		return ""
+	}
+	return fmt.Sprintf("portmap=%+v ", pm)
+}
+
 func compareIPNets(a, b []netip.Prefix) bool {
 	if len(a) != len(b) {
 		return false
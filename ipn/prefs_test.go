@@ -40,6 +40,7 @@ func TestPrefsEqual(t *testing.T) {
 		"RouteAll",
 		"ExitNodeID",
 		"ExitNodeIP",
+		"AutoExitNode",
 		"InternalExitNodePrior",
 		"ExitNodeAllowLANAccess",
 		"CorpDNS",
@@ -62,7 +63,11 @@ func TestPrefsEqual(t *testing.T) {
 		"ProfileName",
 		"AutoUpdate",
 		"AppConnector",
+		"PortMapping",
 		"PostureChecking",
+		"RemoteDiagnostics",
+		"AddrConflictMitigation",
+		"SubnetRouteMetric",
 		"NetfilterKind",
 		"DriveShares",
 		"AllowSingleHosts",
@@ -146,6 +151,17 @@ func TestPrefsEqual(t *testing.T) {
 			true,
 		},
 
+		{
+			&Prefs{AutoExitNode: AnyExitNode},
+			&Prefs{},
+			false,
+		},
+		{
+			&Prefs{AutoExitNode: AnyExitNode},
+			&Prefs{AutoExitNode: AnyExitNode},
+			true,
+		},
+
 		{
 			&Prefs{},
 			&Prefs{ExitNodeAllowLANAccess: true},
@@ -311,6 +327,16 @@ func TestPrefsEqual(t *testing.T) {
 			&Prefs{AppConnector: AppConnectorPrefs{Advertise: false}},
 			false,
 		},
+		{
+			&Prefs{PortMapping: PortMappingPrefs{Protocols: "upnp"}},
+			&Prefs{PortMapping: PortMappingPrefs{Protocols: "upnp"}},
+			true,
+		},
+		{
+			&Prefs{PortMapping: PortMappingPrefs{Protocols: "upnp"}},
+			&Prefs{PortMapping: PortMappingPrefs{Protocols: "upnp", ExternalPort: 41641}},
+			false,
+		},
 		{
 			&Prefs{PostureChecking: true},
 			&Prefs{PostureChecking: true},
@@ -321,6 +347,36 @@ func TestPrefsEqual(t *testing.T) {
 			&Prefs{PostureChecking: false},
 			false,
 		},
+		{
+			&Prefs{RemoteDiagnostics: RemoteDiagnosticsPrompt},
+			&Prefs{RemoteDiagnostics: RemoteDiagnosticsPrompt},
+			true,
+		},
+		{
+			&Prefs{RemoteDiagnostics: RemoteDiagnosticsPrompt},
+			&Prefs{RemoteDiagnostics: RemoteDiagnosticsAllow},
+			false,
+		},
+		{
+			&Prefs{AddrConflictMitigation: AddrConflictMitigationSNAT},
+			&Prefs{AddrConflictMitigation: AddrConflictMitigationSNAT},
+			true,
+		},
+		{
+			&Prefs{AddrConflictMitigation: AddrConflictMitigationRoutes},
+			&Prefs{},
+			false,
+		},
+		{
+			&Prefs{SubnetRouteMetric: 100},
+			&Prefs{SubnetRouteMetric: 100},
+			true,
+		},
+		{
+			&Prefs{SubnetRouteMetric: 100},
+			&Prefs{},
+			false,
+		},
 		{
 			&Prefs{NetfilterKind: "iptables"},
 			&Prefs{NetfilterKind: "iptables"},
@@ -804,6 +860,15 @@ func TestMaskedPrefsPretty(t *testing.T) {
 			},
 			want: `MaskedPrefs{}`,
 		},
+		{
+			m: &MaskedPrefs{
+				Prefs: Prefs{
+					PortMapping: PortMappingPrefs{Protocols: "pcp,upnp", LeaseSeconds: 600},
+				},
+				PortMappingSet: PortMappingPrefsMask{ProtocolsSet: true},
+			},
+			want: `MaskedPrefs{PortMapping={Protocols="pcp,upnp"}}`,
+		},
 	}
 	for i, tt := range tests {
 		got := tt.m.Pretty()
@@ -813,6 +878,28 @@ func TestMaskedPrefsPretty(t *testing.T) {
 	}
 }
 
+func TestPortMappingPrefsValidate(t *testing.T) {
+	tests := []struct {
+		pm      PortMappingPrefs
+		wantErr bool
+	}{
+		{PortMappingPrefs{}, false},
+		{PortMappingPrefs{Protocols: "upnp, pcp", LeaseSeconds: 600, RenewMarginSeconds: 60, ExternalPort: 41641}, false},
+		{PortMappingPrefs{RenewMarginSeconds: 600}, false},
+		{PortMappingPrefs{Protocols: "natpmp"}, true},
+		{PortMappingPrefs{Protocols: "pcp,pcp"}, true},
+		{PortMappingPrefs{Protocols: "pcp,"}, true},
+		{PortMappingPrefs{LeaseSeconds: 10}, true},
+		{PortMappingPrefs{LeaseSeconds: 600, RenewMarginSeconds: 600}, true},
+	}
+	for _, tt := range tests {
+		err := tt.pm.Validate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%+v.Validate() = %v, want error: %v", tt.pm, err, tt.wantErr)
+		}
+	}
+}
+
 func TestPrefsExitNode(t *testing.T) {
 	var p *Prefs
 	if p.AdvertisesExitNode() {
@@ -100,6 +100,20 @@ type StateStoreDialerSetter interface {
 	SetDialer(d func(ctx context.Context, network, address string) (net.Conn, error))
 }
 
+// CertStore persists the TLS certificates and private keys obtained for
+// HTTPS and Funnel, in place of the default storage under the state
+// directory. It is set with LocalBackend.SetCertStore.
+// Implementations of CertStore are expected to be safe for concurrent use.
+type CertStore interface {
+	// Get returns the PEM-encoded certificate chain and private key
+	// previously stored for domain. Returns ErrStateNotExist if there
+	// are none.
+	Get(domain string) (certPEM, keyPEM []byte, err error)
+	// Put stores the PEM-encoded certificate chain and private key for
+	// domain, replacing any previously stored.
+	Put(domain string, certPEM, keyPEM []byte) error
+}
+
 // ReadStoreInt reads an integer from a StateStore.
 func ReadStoreInt(store StateStore, id StateKey) (int64, error) {
 	v, err := store.ReadState(id)
@@ -11,6 +11,7 @@
 	"errors"
 	"fmt"
 	"regexp"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/arn"
@@ -28,6 +29,11 @@
 
 var parameterNameRx = regexp.MustCompile(parameterNameRxStr)
 
+// URLPrefix is the prefix of the shorter form of the --state values
+// selecting this store, "ssm://[region]/parameter-name", as an alternative
+// to a full ARN. If the region is empty, the instance's region is used.
+const URLPrefix = "ssm://"
+
 // awsSSMClient is an interface allowing us to mock the couple of
 // API calls we are leveraging with the AWSStore provider
 type awsSSMClient interface {
@@ -50,7 +56,8 @@ type awsStore struct {
 }
 
 // New returns a new ipn.StateStore using the AWS SSM storage
-// location given by ssmARN.
+// location given by ssmARN, which is either the ARN of the parameter or of
+// the form "ssm://[region]/parameter-name".
 //
 // Note that we store the entire store in a single parameter
 // key, therefore if the state is above 8kb, it can cause
@@ -71,7 +78,11 @@ func newStore(ssmARN string, client awsSSMClient) (ipn.StateStore, error) {
 	var err error
 
 	// Parse the ARN
-	if s.ssmARN, err = arn.Parse(ssmARN); err != nil {
+	if strings.HasPrefix(ssmARN, URLPrefix) {
+		if s.ssmARN, err = parseURL(ssmARN); err != nil {
+			return nil, err
+		}
+	} else if s.ssmARN, err = arn.Parse(ssmARN); err != nil {
 		return nil, fmt.Errorf("unable to parse the ARN correctly: %v", err)
 	}
 
@@ -86,13 +97,16 @@ func newStore(ssmARN string, client awsSSMClient) (ipn.StateStore, error) {
 	}
 
 	if s.ssmClient == nil {
+		regionOpt := config.WithRegion(s.ssmARN.Region)
+		if s.ssmARN.Region == "" {
+			// Use the instance's region, from IMDS.
+			regionOpt = config.WithEC2IMDSRegion()
+		}
 		var cfg aws.Config
-		if cfg, err = config.LoadDefaultConfig(
-			context.TODO(),
-			config.WithRegion(s.ssmARN.Region),
-		); err != nil {
+		if cfg, err = config.LoadDefaultConfig(context.TODO(), regionOpt); err != nil {
 			return nil, err
 		}
+		s.ssmARN.Region = cfg.Region
 		s.ssmClient = ssm.NewFromConfig(cfg)
 	}
 
@@ -104,6 +118,22 @@ func newStore(ssmARN string, client awsSSMClient) (ipn.StateStore, error) {
 
 }
 
+// parseURL returns the ARN of the parameter named by a --state value of
+// the form "ssm://[region]/parameter-name". The returned ARN has no
+// account ID, and no region if u has none.
+func parseURL(u string) (arn.ARN, error) {
+	region, name, ok := strings.Cut(strings.TrimPrefix(u, URLPrefix), "/")
+	if !ok || name == "" || strings.HasSuffix(name, "/") {
+		return arn.ARN{}, fmt.Errorf("invalid SSM parameter %q, expected %s[region]/parameter-name", u, URLPrefix)
+	}
+	return arn.ARN{
+		Partition: "aws",
+		Service:   "ssm",
+		Region:    region,
+		Resource:  "parameter/" + name,
+	}, nil
+}
+
 // LoadState attempts to read the state from AWS SSM parameter store key.
 func (s *awsStore) LoadState() error {
 	param, err := s.ssmClient.GetParameter(
@@ -98,6 +98,38 @@ func TestNewAWSStore(t *testing.T) {
 	}
 }
 
+func TestNewAWSStoreURL(t *testing.T) {
+	tests := []struct {
+		url       string
+		wantARN   string
+		wantParam string
+		wantErr   bool
+	}{
+		{url: "ssm://eu-west-1/tailscale/node", wantARN: "arn:aws:ssm:eu-west-1::parameter/tailscale/node", wantParam: "/tailscale/node"},
+		{url: "ssm:///node", wantARN: "arn:aws:ssm:::parameter/node", wantParam: "/node"},
+		{url: "ssm://eu-west-1", wantErr: true},
+		{url: "ssm://eu-west-1/", wantErr: true},
+		{url: "ssm://eu-west-1/node/", wantErr: true},
+	}
+	for _, tt := range tests {
+		s, err := newStore(tt.url, &mockedAWSSSMClient{})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newStore(%q) error = %v; want error %v", tt.url, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		as := s.(*awsStore)
+		if got := as.ssmARN.String(); got != tt.wantARN {
+			t.Errorf("newStore(%q) ARN = %q; want %q", tt.url, got, tt.wantARN)
+		}
+		if got := as.ParameterName(); got != tt.wantParam {
+			t.Errorf("newStore(%q) parameter = %q; want %q", tt.url, got, tt.wantParam)
+		}
+	}
+}
+
 func testStoreSemantics(t *testing.T, store ipn.StateStore) {
 	t.Helper()
 
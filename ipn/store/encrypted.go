@@ -0,0 +1,444 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package store
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"tailscale.com/atomicfile"
+	"tailscale.com/ipn"
+	"tailscale.com/paths"
+	"tailscale.com/types/logger"
+)
+
+// encryptedStateMagic starts an encrypted state file. It's also the
+// additional data authenticated along with the sealed state and keys.
+const encryptedStateMagic = "tailscale-encrypted-state-v1\n"
+
+// encryptedState is the JSON following encryptedStateMagic in an encrypted
+// state file.
+//
+// The state is sealed with a random data key, which is itself wrapped by
+// the key source, so that the key source (which can be slow: a KDF or a
+// TPM) is only used when the file is opened, not on every write.
+type encryptedState struct {
+	// KeySource is the kind of key source that wrapped the data key:
+	// "cmd" or "tpm2".
+	KeySource string
+
+	// WrappedKey is the data key, wrapped by the key source.
+	WrappedKey []byte
+
+	// Nonce is the XChaCha20-Poly1305 nonce Data was sealed with.
+	Nonce []byte
+
+	// Data is the JSON state, as in a FileStore, sealed with the data key.
+	Data []byte
+}
+
+// keySource wraps and unwraps the data key of an encrypted state file.
+type keySource interface {
+	// kind returns the encryptedState.KeySource value for this source.
+	kind() string
+	wrap(dataKey []byte) ([]byte, error)
+	unwrap(wrapped []byte) ([]byte, error)
+}
+
+// parseKeySource parses a --state-key value: "cmd:<command>" or "tpm2".
+func parseKeySource(s string) (keySource, error) {
+	if s == "tpm2" {
+		return tpm2KeySource{}, nil
+	}
+	if cmd, ok := strings.CutPrefix(s, "cmd:"); ok {
+		args := strings.Fields(cmd)
+		if len(args) == 0 {
+			return nil, errors.New("empty state key command")
+		}
+		return &passphraseKeySource{get: func() ([]byte, error) {
+			out, err := exec.Command(args[0], args[1:]...).Output()
+			if err != nil {
+				return nil, fmt.Errorf("running state key command: %w", err)
+			}
+			return bytes.TrimRight(out, "\r\n"), nil
+		}}, nil
+	}
+	return nil, fmt.Errorf(`invalid state key source %q; want "tpm2" or "cmd:<command>"`, s)
+}
+
+const (
+	stateKeySaltLen = 16
+
+	// argon2id parameters, as recommended in RFC 9106 for memory-constrained
+	// environments, and as used for backups.
+	stateKDFTime    = 3
+	stateKDFMemory  = 64 * 1024 // KiB
+	stateKDFThreads = 4
+)
+
+// passphraseKeySource wraps the data key with a key derived from a
+// passphrase, printed by an external command such as a secrets manager's
+// CLI.
+type passphraseKeySource struct {
+	get func() ([]byte, error)
+
+	once sync.Once
+	pass []byte
+	err  error
+}
+
+func (s *passphraseKeySource) kind() string { return "cmd" }
+
+func (s *passphraseKeySource) passphrase() ([]byte, error) {
+	s.once.Do(func() {
+		s.pass, s.err = s.get()
+		if s.err == nil && len(s.pass) == 0 {
+			s.err = errors.New("state key command printed an empty passphrase")
+		}
+	})
+	return s.pass, s.err
+}
+
+func (s *passphraseKeySource) aead(salt []byte) (cipher.AEAD, error) {
+	pass, err := s.passphrase()
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.NewX(argon2.IDKey(pass, salt, stateKDFTime, stateKDFMemory, stateKDFThreads, chacha20poly1305.KeySize))
+}
+
+// wrap returns salt || nonce || sealed data key.
+func (s *passphraseKeySource) wrap(dataKey []byte) ([]byte, error) {
+	buf := make([]byte, stateKeySaltLen+chacha20poly1305.NonceSizeX)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	salt, nonce := buf[:stateKeySaltLen], buf[stateKeySaltLen:]
+	aead, err := s.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(buf, nonce, dataKey, []byte(encryptedStateMagic)), nil
+}
+
+func (s *passphraseKeySource) unwrap(wrapped []byte) ([]byte, error) {
+	if len(wrapped) < stateKeySaltLen+chacha20poly1305.NonceSizeX {
+		return nil, errors.New("truncated wrapped key")
+	}
+	salt, rest := wrapped[:stateKeySaltLen], wrapped[stateKeySaltLen:]
+	nonce, sealed := rest[:chacha20poly1305.NonceSizeX], rest[chacha20poly1305.NonceSizeX:]
+	aead, err := s.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := aead.Open(nil, nonce, sealed, []byte(encryptedStateMagic))
+	if err != nil {
+		return nil, errors.New("wrong state key passphrase")
+	}
+	return dataKey, nil
+}
+
+// tpm2CredName is the name the data key is sealed under with systemd-creds,
+// which refuses to unseal it under another name.
+const tpm2CredName = "tailscaled-state"
+
+// tpm2KeySource seals the data key to the machine's TPM2 with systemd-creds,
+// so the state file can only be decrypted on this machine.
+type tpm2KeySource struct{}
+
+func (tpm2KeySource) kind() string { return "tpm2" }
+
+func (tpm2KeySource) wrap(dataKey []byte) ([]byte, error) {
+	return systemdCreds(dataKey, "encrypt", "--with-key=tpm2", "--name="+tpm2CredName, "-", "-")
+}
+
+func (tpm2KeySource) unwrap(wrapped []byte) ([]byte, error) {
+	return systemdCreds(wrapped, "decrypt", "--name="+tpm2CredName, "-", "-")
+}
+
+// systemdCreds runs systemd-creds with args and in on its stdin, returning
+// its stdout.
+func systemdCreds(in []byte, args ...string) ([]byte, error) {
+	if _, err := os.Stat("/dev/tpmrm0"); err != nil {
+		return nil, errors.New("tpm2 state key: no TPM2 device found")
+	}
+	bin, err := exec.LookPath("systemd-creds")
+	if err != nil {
+		return nil, errors.New("tpm2 state key: systemd-creds (systemd 250 or later) is required")
+	}
+	cmd := exec.Command(bin, args...)
+	cmd.Stdin = bytes.NewReader(in)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("tpm2 state key: systemd-creds %s: %v: %s", args[0], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
+
+// isEncryptedState reports whether the contents of a state file are
+// encrypted.
+func isEncryptedState(bs []byte) bool {
+	return bytes.HasPrefix(bs, []byte(encryptedStateMagic))
+}
+
+// sealState encrypts the JSON state plain with dataKey, whose wrapped form
+// by ks is wrappedKey, returning the contents of an encrypted state file.
+func sealState(ks keySource, dataKey, wrappedKey, plain []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	es := encryptedState{
+		KeySource:  ks.kind(),
+		WrappedKey: wrappedKey,
+		Nonce:      make([]byte, chacha20poly1305.NonceSizeX),
+	}
+	if _, err := rand.Read(es.Nonce); err != nil {
+		return nil, err
+	}
+	es.Data = aead.Seal(nil, es.Nonce, plain, []byte(encryptedStateMagic))
+	j, err := json.Marshal(es)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(encryptedStateMagic), j...), nil
+}
+
+// openState decrypts the contents of an encrypted state file with ks,
+// returning the JSON state, the data key and its wrapped form.
+func openState(ks keySource, bs []byte) (plain, dataKey, wrapped []byte, err error) {
+	var es encryptedState
+	if err := json.Unmarshal(bs[len(encryptedStateMagic):], &es); err != nil {
+		return nil, nil, nil, fmt.Errorf("decoding encrypted state: %w", err)
+	}
+	if es.KeySource != ks.kind() {
+		return nil, nil, nil, fmt.Errorf("state is encrypted with a %q key, not %q", es.KeySource, ks.kind())
+	}
+	if dataKey, err = ks.unwrap(es.WrappedKey); err != nil {
+		return nil, nil, nil, err
+	}
+	aead, err := chacha20poly1305.NewX(dataKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(es.Nonce) != aead.NonceSize() {
+		return nil, nil, nil, errors.New("corrupt encrypted state: bad nonce")
+	}
+	if plain, err = aead.Open(nil, es.Nonce, es.Data, []byte(encryptedStateMagic)); err != nil {
+		return nil, nil, nil, errors.New("corrupt encrypted state")
+	}
+	return plain, dataKey, es.WrappedKey, nil
+}
+
+// newDataKey returns a new random data key, and its form wrapped by ks.
+func newDataKey(ks keySource) (dataKey, wrapped []byte, err error) {
+	dataKey = make([]byte, chacha20poly1305.KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	if wrapped, err = ks.wrap(dataKey); err != nil {
+		return nil, nil, err
+	}
+	return dataKey, wrapped, nil
+}
+
+// EncryptedFileStore is a StateStore that persists to a file like
+// FileStore, but encrypted at rest, so the node's private keys can't be
+// read from a copy of the disk.
+type EncryptedFileStore struct {
+	path string
+	ks   keySource
+
+	mu         sync.RWMutex
+	dataKey    []byte
+	wrappedKey []byte
+	cache      map[ipn.StateKey][]byte
+}
+
+// Path returns the path that NewEncryptedFileStore was called with.
+func (s *EncryptedFileStore) Path() string { return s.path }
+
+func (s *EncryptedFileStore) String() string {
+	return fmt.Sprintf("EncryptedFileStore(%q, %s)", s.path, s.ks.kind())
+}
+
+// NewEncryptedFileStore returns a new store that persists to the file at
+// path, encrypted with a key from keySource: "tpm2" to seal the key to the
+// machine's TPM2 with systemd-creds, or "cmd:<command>" to derive it from
+// the passphrase printed by command.
+//
+// If path holds cleartext state, as written by a FileStore, it's encrypted
+// in place.
+func NewEncryptedFileStore(logf logger.Logf, path, keySource string) (ipn.StateStore, error) {
+	regOnce.Do(registerDefaultStores)
+	for prefix := range knownStores {
+		if strings.HasPrefix(path, prefix) {
+			return nil, fmt.Errorf("state encryption is only supported for state files, not %q", prefix)
+		}
+	}
+	ks, err := parseKeySource(keySource)
+	if err != nil {
+		return nil, err
+	}
+	s, err := newEncryptedFileStore(logf, path, ks)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func newEncryptedFileStore(logf logger.Logf, path string, ks keySource) (*EncryptedFileStore, error) {
+	// We unconditionally call this to ensure that our perms are correct
+	if err := paths.MkStateDir(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("creating state directory: %w", err)
+	}
+	s := &EncryptedFileStore{
+		path:  path,
+		ks:    ks,
+		cache: map[ipn.StateKey][]byte{},
+	}
+
+	bs, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	switch {
+	case isEncryptedState(bs):
+		plain, dataKey, wrapped, err := openState(ks, bs)
+		if err != nil {
+			return nil, fmt.Errorf("opening encrypted state %q: %w", path, err)
+		}
+		if err := json.Unmarshal(plain, &s.cache); err != nil {
+			return nil, err
+		}
+		s.dataKey, s.wrappedKey = dataKey, wrapped
+		return s, nil
+	case len(bs) > 0:
+		// Migrate from cleartext state.
+		if err := json.Unmarshal(bs, &s.cache); err != nil {
+			return nil, err
+		}
+		logf("store: encrypting cleartext state file %q", path)
+	}
+	if s.dataKey, s.wrappedKey, err = newDataKey(ks); err != nil {
+		return nil, err
+	}
+	// Write out the file, to verify that we can write to the path, and to
+	// replace any cleartext state.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.writeLocked(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ReadState implements the StateStore interface.
+func (s *EncryptedFileStore) ReadState(id ipn.StateKey) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	bs, ok := s.cache[id]
+	if !ok {
+		return nil, ipn.ErrStateNotExist
+	}
+	return bs, nil
+}
+
+// WriteState implements the StateStore interface.
+func (s *EncryptedFileStore) WriteState(id ipn.StateKey, bs []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if bytes.Equal(s.cache[id], bs) {
+		return nil
+	}
+	s.cache[id] = bytes.Clone(bs)
+	return s.writeLocked()
+}
+
+func (s *EncryptedFileStore) writeLocked() error {
+	plain, err := json.Marshal(s.cache)
+	if err != nil {
+		return err
+	}
+	bs, err := sealState(s.ks, s.dataKey, s.wrappedKey, plain)
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(s.path, bs, 0600)
+}
+
+// RotateStateKey re-encrypts the state file at path, which must not be in
+// use by tailscaled, with a new data key wrapped by newKeySource. Its
+// current key source is oldKeySource.
+//
+// Either key source may be "none", for cleartext state: an old source of
+// "none" encrypts a cleartext state file, and a new source of "none"
+// decrypts it back to cleartext.
+func RotateStateKey(path, oldKeySource, newKeySource string) error {
+	var oldKS, newKS keySource
+	var err error
+	if oldKeySource != "none" {
+		if oldKS, err = parseKeySource(oldKeySource); err != nil {
+			return err
+		}
+	}
+	if newKeySource != "none" {
+		if newKS, err = parseKeySource(newKeySource); err != nil {
+			return err
+		}
+	}
+	return rotateStateKey(path, oldKS, newKS)
+}
+
+// rotateStateKey implements RotateStateKey, with nil key sources for
+// cleartext state.
+func rotateStateKey(path string, oldKS, newKS keySource) error {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	plain := bs
+	switch {
+	case oldKS == nil && isEncryptedState(bs):
+		return errors.New("state file is encrypted; specify its current key source")
+	case oldKS != nil && !isEncryptedState(bs):
+		return errors.New(`state file is not encrypted; use an old key source of "none"`)
+	case oldKS != nil:
+		if plain, _, _, err = openState(oldKS, bs); err != nil {
+			return err
+		}
+	}
+	var cache map[ipn.StateKey][]byte
+	if err := json.Unmarshal(plain, &cache); err != nil {
+		return fmt.Errorf("decoding state: %w", err)
+	}
+
+	var out []byte
+	if newKS == nil {
+		out, err = json.MarshalIndent(cache, "", "  ")
+	} else {
+		var dataKey, wrapped []byte
+		if dataKey, wrapped, err = newDataKey(newKS); err != nil {
+			return err
+		}
+		out, err = sealState(newKS, dataKey, wrapped, plain)
+	}
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(path, out, 0600)
+}
@@ -0,0 +1,104 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package store
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"tailscale.com/ipn"
+)
+
+func testPassphrase(pass string) *passphraseKeySource {
+	return &passphraseKeySource{get: func() ([]byte, error) {
+		return []byte(pass), nil
+	}}
+}
+
+func TestEncryptedFileStore(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tailscaled.state")
+
+	// Start from cleartext state, which is migrated.
+	fs, err := NewFileStore(t.Logf, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteState("foo", []byte("secret-node-key")); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := newEncryptedFileStore(t.Logf, path, testPassphrase("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.ReadState("foo"); err != nil || string(got) != "secret-node-key" {
+		t.Fatalf("ReadState after migration = %q, %v", got, err)
+	}
+	if err := s.WriteState("bar", []byte("baz")); err != nil {
+		t.Fatal(err)
+	}
+	checkEncrypted := func() {
+		t.Helper()
+		bs, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isEncryptedState(bs) || bytes.Contains(bs, []byte("secret")) {
+			t.Fatalf("state file is not encrypted: %q", bs)
+		}
+	}
+	checkEncrypted()
+	if _, err := NewFileStore(t.Logf, path); err == nil {
+		t.Error("NewFileStore opened an encrypted state file")
+	}
+
+	// Reopen, as after a restart.
+	if _, err := newEncryptedFileStore(t.Logf, path, testPassphrase("wrong")); err == nil {
+		t.Error("opened encrypted state with the wrong passphrase")
+	}
+	s2, err := newEncryptedFileStore(t.Logf, path, testPassphrase("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantState := map[ipn.StateKey]string{"foo": "secret-node-key", "bar": "baz"}
+	checkState := func(s ipn.StateStore) {
+		t.Helper()
+		for id, want := range wantState {
+			if got, err := s.ReadState(id); err != nil || string(got) != want {
+				t.Errorf("ReadState(%q) = %q, %v; want %q", id, got, err, want)
+			}
+		}
+	}
+	checkState(s2)
+
+	// Rotate to a new passphrase.
+	if err := rotateStateKey(path, testPassphrase("hunter2"), testPassphrase("correct horse")); err != nil {
+		t.Fatal(err)
+	}
+	checkEncrypted()
+	if _, err := newEncryptedFileStore(t.Logf, path, testPassphrase("hunter2")); err == nil {
+		t.Error("opened encrypted state with the passphrase before rotation")
+	}
+	s3, err := newEncryptedFileStore(t.Logf, path, testPassphrase("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkState(s3)
+
+	// And back to cleartext.
+	if err := rotateStateKey(path, testPassphrase("correct horse"), nil); err != nil {
+		t.Fatal(err)
+	}
+	fs2, err := NewFileStore(t.Logf, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkState(fs2)
+	if err := rotateStateKey(path, testPassphrase("correct horse"), nil); err == nil {
+		t.Error("rotated cleartext state from a passphrase")
+	}
+}
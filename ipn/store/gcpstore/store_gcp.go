@@ -0,0 +1,314 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package gcpstore contains an ipn.StateStore implementation using GCP
+// Secret Manager.
+//
+// It talks to the Secret Manager REST API directly, authenticating as the
+// VM's (or Cloud Run service's) attached service account with access tokens
+// from the metadata server, so no credentials need to be configured: the
+// service account only needs the roles/secretmanager.secretAccessor and
+// roles/secretmanager.secretVersionManager roles on the secret (plus
+// roles/secretmanager.admin on the project, if the secret is to be
+// created on first use).
+package gcpstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/mem"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/cloudenv"
+)
+
+// Prefix is the prefix of the --state values selecting this store, as in
+// "gcp-secret://my-project/tailscaled-state". The project may be empty
+// ("gcp-secret:///tailscaled-state") to use the VM's own project.
+const Prefix = "gcp-secret://"
+
+// These are vars for tests.
+var (
+	metadataBase  = "http://" + cloudenv.CommonNonRoutableMetadataIP
+	secretManager = "https://secretmanager.googleapis.com"
+
+	httpClient = http.DefaultClient
+)
+
+// timeout bounds each Secret Manager operation, including getting a token.
+const timeout = 30 * time.Second
+
+// gcpStore is a store which persists the state as the payload of a
+// Secret Manager secret, adding a version on each write.
+type gcpStore struct {
+	logf    logger.Logf
+	project string
+	secret  string
+
+	mu          sync.Mutex
+	token       string    // OAuth2 access token from the metadata server
+	tokenExpiry time.Time // when token expires
+	version     string    // resource name of the latest version, or ""
+
+	memory mem.Store
+}
+
+// New returns a new ipn.StateStore using the GCP Secret Manager secret
+// named by arg, of the form "gcp-secret://[project]/secret".
+//
+// The secret is created if it doesn't exist. Each state write adds a new
+// secret version and destroys the previous one, so only one version is
+// ever kept. Secret payloads are limited to 64 KiB.
+func New(logf logger.Logf, arg string) (ipn.StateStore, error) {
+	project, secret, err := parseArg(arg)
+	if err != nil {
+		return nil, err
+	}
+	s := &gcpStore{
+		logf:    logf,
+		project: project,
+		secret:  secret,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if s.project == "" {
+		if s.project, err = s.metadata(ctx, "/computeMetadata/v1/project/project-id"); err != nil {
+			return nil, fmt.Errorf("gcp-secret: getting project ID: %w", err)
+		}
+	}
+	// Hydrate cache with the potentially current state.
+	if err := s.loadState(ctx); err != nil {
+		return nil, fmt.Errorf("gcp-secret: %w", err)
+	}
+	return s, nil
+}
+
+// parseArg parses a --state value of the form "gcp-secret://[project]/secret".
+func parseArg(arg string) (project, secret string, err error) {
+	rest, ok := strings.CutPrefix(arg, Prefix)
+	if !ok {
+		return "", "", fmt.Errorf("gcp-secret: %q does not start with %q", arg, Prefix)
+	}
+	project, secret, ok = strings.Cut(rest, "/")
+	if !ok || secret == "" || strings.Contains(secret, "/") {
+		return "", "", fmt.Errorf("gcp-secret: %q is not of the form %s[project]/secret", arg, Prefix)
+	}
+	return project, secret, nil
+}
+
+// String returns the gcpStore and the name of the secret configured to
+// store the state.
+func (s *gcpStore) String() string {
+	return fmt.Sprintf("gcpStore(%q)", s.secretName())
+}
+
+// secretName returns the resource name of the secret.
+func (s *gcpStore) secretName() string {
+	return "projects/" + s.project + "/secrets/" + s.secret
+}
+
+// ReadState implements the StateStore interface.
+func (s *gcpStore) ReadState(id ipn.StateKey) ([]byte, error) {
+	return s.memory.ReadState(id)
+}
+
+// WriteState implements the StateStore interface.
+func (s *gcpStore) WriteState(id ipn.StateKey, bs []byte) error {
+	if err := s.memory.WriteState(id, bs); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.persistState(ctx)
+}
+
+// secretPayload is the payload of a secret version.
+type secretPayload struct {
+	Data []byte `json:"data"` // base64 encoded by encoding/json, as the API wants
+}
+
+// loadState reads the state from the latest version of the secret,
+// creating the secret if it doesn't exist yet.
+func (s *gcpStore) loadState(ctx context.Context) error {
+	var res struct {
+		Name    string        `json:"name"`
+		Payload secretPayload `json:"payload"`
+	}
+	err := s.call(ctx, "GET", "/v1/"+s.secretName()+"/versions/latest:access", nil, &res)
+	if isStatus(err, http.StatusNotFound) {
+		// Either the secret or any version of it doesn't exist yet.
+		if err := s.call(ctx, "POST", "/v1/projects/"+s.project+"/secrets?secretId="+url.QueryEscape(s.secret), map[string]any{
+			"replication": map[string]any{"automatic": map[string]any{}},
+		}, nil); err != nil && !isStatus(err, http.StatusConflict) {
+			return fmt.Errorf("creating secret: %w", err)
+		}
+		return s.persistState(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.version = res.Name
+	s.mu.Unlock()
+	return s.memory.LoadFromJSON(res.Payload.Data)
+}
+
+// persistState adds a secret version with the in-memory state and destroys
+// the previous one.
+func (s *gcpStore) persistState(ctx context.Context) error {
+	bs, err := s.memory.ExportToJSON()
+	if err != nil {
+		return err
+	}
+
+	// Serialize writes, so versions are added and destroyed in order.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res struct {
+		Name string `json:"name"`
+	}
+	if err := s.callLocked(ctx, "POST", "/v1/"+s.secretName()+":addVersion", map[string]any{
+		"payload": secretPayload{Data: bs},
+	}, &res); err != nil {
+		return fmt.Errorf("adding secret version: %w", err)
+	}
+	prev := s.version
+	s.version = res.Name
+	if prev != "" && prev != res.Name {
+		if err := s.callLocked(ctx, "POST", "/v1/"+prev+":destroy", map[string]any{}, nil); err != nil {
+			// Not fatal: the new state is saved.
+			s.logf("gcp-secret: destroying old version %s: %v", prev, err)
+		}
+	}
+	return nil
+}
+
+// statusError is a non-2xx response from the Secret Manager API.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.code, http.StatusText(e.code), e.msg)
+}
+
+// isStatus reports whether err is a statusError with the given code.
+func isStatus(err error, code int) bool {
+	var se *statusError
+	return errors.As(err, &se) && se.code == code
+}
+
+func (s *gcpStore) call(ctx context.Context, method, path string, body, res any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.callLocked(ctx, method, path, body, res)
+}
+
+// callLocked makes a Secret Manager API request, sending body and decoding
+// the response into res unless they're nil. s.mu must be held.
+func (s *gcpStore) callLocked(ctx context.Context, method, path string, body, res any) error {
+	token, err := s.tokenLocked(ctx)
+	if err != nil {
+		return fmt.Errorf("getting access token: %w", err)
+	}
+	var rbody io.Reader
+	if body != nil {
+		j, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rbody = bytes.NewReader(j)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, secretManager+path, rbody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	hres, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer hres.Body.Close()
+	all, err := io.ReadAll(io.LimitReader(hres.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if hres.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		msg := string(bytes.TrimSpace(all))
+		if json.Unmarshal(all, &apiErr) == nil && apiErr.Error.Message != "" {
+			msg = apiErr.Error.Message
+		}
+		return &statusError{hres.StatusCode, msg}
+	}
+	if res == nil {
+		return nil
+	}
+	return json.Unmarshal(all, res)
+}
+
+// tokenLocked returns an access token for the attached service account,
+// fetching a new one from the metadata server if needed. s.mu must be held.
+func (s *gcpStore) tokenLocked(ctx context.Context) (string, error) {
+	if s.token != "" && time.Now().Before(s.tokenExpiry) {
+		return s.token, nil
+	}
+	body, err := s.metadata(ctx, "/computeMetadata/v1/instance/service-accounts/default/token")
+	if err != nil {
+		return "", err
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"` // seconds
+	}
+	if err := json.Unmarshal([]byte(body), &tok); err != nil {
+		return "", err
+	}
+	if tok.AccessToken == "" {
+		return "", errors.New("metadata server returned no access token")
+	}
+	s.token = tok.AccessToken
+	// Refresh a minute early, to not use a token expiring mid-request.
+	s.tokenExpiry = time.Now().Add(time.Duration(tok.ExpiresIn)*time.Second - time.Minute)
+	return s.token, nil
+}
+
+// metadata returns the trimmed response of a GCP metadata server request.
+func (s *gcpStore) metadata(ctx context.Context, path string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", metadataBase+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	all, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: %v", path, res.Status)
+	}
+	return strings.TrimSpace(string(all)), nil
+}
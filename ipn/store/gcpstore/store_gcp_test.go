@@ -0,0 +1,197 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package gcpstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"tailscale.com/ipn"
+)
+
+// fakeGCP is a fake of the GCP metadata server and the parts of the
+// Secret Manager API used by gcpStore, for a single project.
+type fakeGCP struct {
+	mu        sync.Mutex
+	secrets   map[string][][]byte // secret ID => versions' payloads, nil if destroyed
+	tokenGets int
+}
+
+func (f *fakeGCP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	const secretsPrefix = "/v1/projects/proj/secrets"
+	path := r.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/computeMetadata/"):
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "missing Metadata-Flavor", http.StatusForbidden)
+			return
+		}
+		switch path {
+		case "/computeMetadata/v1/project/project-id":
+			fmt.Fprintln(w, "proj")
+		case "/computeMetadata/v1/instance/service-accounts/default/token":
+			f.tokenGets++
+			fmt.Fprintln(w, `{"access_token":"tok","expires_in":3599,"token_type":"Bearer"}`)
+		default:
+			http.NotFound(w, r)
+		}
+		return
+	case r.Header.Get("Authorization") != "Bearer tok":
+		http.Error(w, `{"error":{"message":"bad token"}}`, http.StatusUnauthorized)
+		return
+	case path == secretsPrefix && r.Method == "POST":
+		id := r.URL.Query().Get("secretId")
+		if _, ok := f.secrets[id]; ok {
+			http.Error(w, `{"error":{"message":"exists"}}`, http.StatusConflict)
+			return
+		}
+		f.secrets[id] = nil
+		fmt.Fprintln(w, "{}")
+		return
+	}
+
+	// Version names use the project number, not its ID.
+	rest, ok := strings.CutPrefix(path, secretsPrefix+"/")
+	if !ok {
+		rest, ok = strings.CutPrefix(path, "/v1/projects/123/secrets/")
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if id, ok := strings.CutSuffix(rest, ":addVersion"); ok {
+		var req struct {
+			Payload struct {
+				Data []byte `json:"data"`
+			} `json:"payload"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.secrets[id] = append(f.secrets[id], req.Payload.Data)
+		fmt.Fprintf(w, `{"name":"projects/123/secrets/%s/versions/%d"}`, id, len(f.secrets[id]))
+		return
+	}
+	if v, ok := strings.CutSuffix(rest, ":destroy"); ok {
+		var id string
+		var n int
+		if _, err := fmt.Sscanf(strings.Replace(v, "/versions/", " ", 1), "%s %d", &id, &n); err != nil || n < 1 || n > len(f.secrets[id]) {
+			http.NotFound(w, r)
+			return
+		}
+		f.secrets[id][n-1] = nil
+		fmt.Fprintln(w, "{}")
+		return
+	}
+	if id, ok := strings.CutSuffix(rest, "/versions/latest:access"); ok {
+		vers := f.secrets[id]
+		if len(vers) == 0 {
+			http.Error(w, `{"error":{"message":"not found"}}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"name":    fmt.Sprintf("projects/123/secrets/%s/versions/%d", id, len(vers)),
+			"payload": map[string]any{"data": vers[len(vers)-1]},
+		})
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// liveVersions returns the number of versions of secret id not destroyed.
+func (f *fakeGCP) liveVersions(id string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, v := range f.secrets[id] {
+		if v != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func TestGCPStore(t *testing.T) {
+	fake := &fakeGCP{secrets: map[string][][]byte{}}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	oldMetadata, oldSecretManager := metadataBase, secretManager
+	metadataBase, secretManager = ts.URL, ts.URL
+	defer func() { metadataBase, secretManager = oldMetadata, oldSecretManager }()
+
+	s, err := New(t.Logf, "gcp-secret:///state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.(*gcpStore).String(), `gcpStore("projects/proj/secrets/state")`; got != want {
+		t.Errorf("String = %q; want %q", got, want)
+	}
+	if _, err := s.ReadState("foo"); err != ipn.ErrStateNotExist {
+		t.Errorf("reading missing key: err = %v; want ErrStateNotExist", err)
+	}
+	if err := s.WriteState("foo", []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteState("baz", []byte("quux")); err != nil {
+		t.Fatal(err)
+	}
+	if n := fake.liveVersions("state"); n != 1 {
+		t.Errorf("%d live versions after writes; want 1", n)
+	}
+	if fake.tokenGets != 1 {
+		t.Errorf("got %d access tokens; want 1", fake.tokenGets)
+	}
+
+	// A new store, as after a restart, sees the state.
+	s2, err := New(t.Logf, "gcp-secret://proj/state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[ipn.StateKey]string{"foo": "bar", "baz": "quux"} {
+		got, err := s2.ReadState(id)
+		if err != nil || string(got) != want {
+			t.Errorf("reading %q from new store = %q, %v; want %q", id, got, err, want)
+		}
+	}
+	if err := s2.WriteState("foo", []byte("bar2")); err != nil {
+		t.Fatal(err)
+	}
+	if n := fake.liveVersions("state"); n != 1 {
+		t.Errorf("%d live versions after writes from new store; want 1", n)
+	}
+}
+
+func TestParseArg(t *testing.T) {
+	tests := []struct {
+		arg     string
+		project string
+		secret  string
+		wantErr bool
+	}{
+		{arg: "gcp-secret://proj/state", project: "proj", secret: "state"},
+		{arg: "gcp-secret:///state", secret: "state"},
+		{arg: "gcp-secret://proj", wantErr: true},
+		{arg: "gcp-secret://proj/", wantErr: true},
+		{arg: "gcp-secret://proj/a/b", wantErr: true},
+		{arg: "gcp-secret:proj/state", wantErr: true},
+	}
+	for _, tt := range tests {
+		project, secret, err := parseArg(tt.arg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseArg(%q) error = %v; want error %v", tt.arg, err, tt.wantErr)
+			continue
+		}
+		if project != tt.project || secret != tt.secret {
+			t.Errorf("parseArg(%q) = %q, %q; want %q, %q", tt.arg, project, secret, tt.project, tt.secret)
+		}
+	}
+}
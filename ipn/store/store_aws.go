@@ -15,4 +15,5 @@ func init() {
 
 func registerAWSStore() {
 	Register("arn:", awsstore.New)
+	Register(awsstore.URLPrefix, awsstore.New)
 }
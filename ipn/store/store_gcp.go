@@ -0,0 +1,18 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build (ts_gcp || (linux && (arm64 || amd64))) && !ts_omit_gcp
+
+package store
+
+import (
+	"tailscale.com/ipn/store/gcpstore"
+)
+
+func init() {
+	registerAvailableExternalStores = append(registerAvailableExternalStores, registerGCPStore)
+}
+
+func registerGCPStore() {
+	Register(gcpstore.Prefix, gcpstore.New)
+}
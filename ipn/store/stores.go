@@ -51,6 +51,10 @@ func registerDefaultStores() {
 //     is ignored and an in-memory store is used.
 //   - (Linux-only) if the string begins with "arn:",
 //     the suffix an AWS ARN for an SSM.
+//   - (Linux-only) if the string begins with "ssm://", the suffix
+//     is "[region]/parameter-name" for an AWS SSM parameter.
+//   - (Linux-only) if the string begins with "gcp-secret://", the suffix
+//     is "[project]/secret" for a GCP Secret Manager secret.
 //   - (Linux-only) if the string begins with "kube:",
 //     the suffix is a Kubernetes secret name
 //   - In all other cases, the path is treated as a filepath.
@@ -150,6 +154,9 @@ func NewFileStore(logf logger.Logf, path string) (ipn.StateStore, error) {
 		return nil, err
 	}
 
+	if isEncryptedState(bs) {
+		return nil, fmt.Errorf("state file %q is encrypted; its key source must be given with --state-key", path)
+	}
 	ret := &FileStore{
 		path:  path,
 		cache: map[ipn.StateKey][]byte{},
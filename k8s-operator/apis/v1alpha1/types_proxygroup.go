@@ -16,7 +16,6 @@
 // +kubebuilder:printcolumn:name="Type",type="string",JSONPath=`.spec.type`,description="ProxyGroup type."
 
 // ProxyGroup defines a set of Tailscale devices that will act as proxies.
-// Currently only egress ProxyGroups are supported.
 //
 // Use the tailscale.com/proxy-group annotation on a Service to specify that
 // the egress proxy should be implemented by a ProxyGroup instead of a single
@@ -24,6 +23,17 @@
 // ProxyGroup also allows for serving many annotated Services from a single
 // set of proxies to minimise resource consumption.
 //
+// A ProxyGroup of type dns does not proxy any Services. Instead it runs a
+// set of Tailscale devices that answer DNS queries for tailnet MagicDNS
+// names on behalf of cluster workloads that are not themselves on the
+// tailnet. The operator exposes the proxies via a ClusterIP Service and
+// writes its address to status.nameserver.ip. Configure that address as a
+// stub nameserver for your tailnet's ts.net domain in your cluster DNS
+// configuration, for example with a CoreDNS server block for the domain
+// that forwards to it.
+// Cluster workloads will also need a route to the tailnet IPs that the
+// names resolve to, for example via egress proxies or a subnet router.
+//
 // More info: https://tailscale.com/kb/1438/kubernetes-operator-cluster-egress
 type ProxyGroup struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -48,7 +58,8 @@ type ProxyGroupList struct {
 }
 
 type ProxyGroupSpec struct {
-	// Type of the ProxyGroup proxies. Supported types are egress and ingress.
+	// Type of the ProxyGroup proxies. Supported types are egress, ingress
+	// and dns.
 	// Type is immutable once a ProxyGroup is created.
 	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="ProxyGroup type is immutable"
 	Type ProxyGroupType `json:"type"`
@@ -98,6 +109,20 @@ type ProxyGroupStatus struct {
 	// +listMapKey=hostname
 	// +optional
 	Devices []TailnetDevice `json:"devices,omitempty"`
+
+	// Nameserver describes the Service fronting the MagicDNS forwarders of
+	// a ProxyGroup of type dns. It is not set for other ProxyGroup types.
+	// +optional
+	Nameserver *ProxyGroupNameserverStatus `json:"nameserver,omitempty"`
+}
+
+type ProxyGroupNameserverStatus struct {
+	// IP is the ClusterIP of the Service fronting the ProxyGroup's MagicDNS
+	// forwarders. Add this address to your cluster DNS configuration as the
+	// stub nameserver for your tailnet's ts.net domain.
+	// The IP address will change if you delete and recreate the ProxyGroup.
+	// +optional
+	IP string `json:"ip,omitempty"`
 }
 
 type TailnetDevice struct {
@@ -113,12 +138,13 @@ type TailnetDevice struct {
 }
 
 // +kubebuilder:validation:Type=string
-// +kubebuilder:validation:Enum=egress;ingress
+// +kubebuilder:validation:Enum=egress;ingress;dns
 type ProxyGroupType string
 
 const (
 	ProxyGroupTypeEgress  ProxyGroupType = "egress"
 	ProxyGroupTypeIngress ProxyGroupType = "ingress"
+	ProxyGroupTypeDNS     ProxyGroupType = "dns"
 )
 
 // +kubebuilder:validation:Type=string
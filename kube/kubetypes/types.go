@@ -13,6 +13,7 @@
 	AppConnector         = "k8s-operator-connector-resource"
 	AppProxyGroupEgress  = "k8s-operator-proxygroup-egress"
 	AppProxyGroupIngress = "k8s-operator-proxygroup-ingress"
+	AppProxyGroupDNS     = "k8s-operator-proxygroup-dns"
 
 	// Clientmetrics for Tailscale Kubernetes Operator components
 	MetricIngressProxyCount              = "k8s_ingress_proxies"      // L3
@@ -28,6 +29,7 @@
 	MetricEgressServiceCount             = "k8s_egress_service_resources"
 	MetricProxyGroupEgressCount          = "k8s_proxygroup_egress_resources"
 	MetricProxyGroupIngressCount         = "k8s_proxygroup_ingress_resources"
+	MetricProxyGroupDNSCount             = "k8s_proxygroup_dns_resources"
 
 	// Keys that containerboot writes to state file that can be used to determine its state.
 	// fields set in Tailscale state Secret. These are mostly used by the Tailscale Kubernetes operator to determine
@@ -868,7 +868,6 @@ func (opts TransportOptions) New() http.RoundTripper {
 	}
 
 	tr.Proxy = tshttpproxy.ProxyFromEnvironment
-	tshttpproxy.SetTransportGetProxyConnectHeader(tr)
 
 	// We do our own zstd compression on uploads, and responses never contain any payload,
 	// so don't send "Accept-Encoding: gzip" to save a few bytes on the wire, since there
@@ -906,6 +905,10 @@ func (opts TransportOptions) New() http.RoundTripper {
 	// Force TLS 1.3 since we know log.tailscale.com supports it.
 	tr.TLSClientConfig.MinVersion = tls.VersionTLS13
 
+	// Tunnel through any proxy ourselves, so proxies requiring
+	// authentication work.
+	tshttpproxy.ConfigureTransport(tr)
+
 	return tr
 }
 
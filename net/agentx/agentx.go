@@ -0,0 +1,367 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package agentx implements a minimal read-only AgentX (RFC 2741) subagent.
+//
+// A subagent connects to the master SNMP agent (typically snmpd), registers
+// a single MIB subtree, and answers Get, GetNext and GetBulk requests for it
+// from a snapshot of variables produced on demand. Set requests are refused.
+package agentx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"tailscale.com/types/logger"
+)
+
+// DefaultSocket is the default path of the master agent's AgentX socket,
+// as used by net-snmp.
+const DefaultSocket = "/var/agentx/master"
+
+// Subagent is an AgentX subagent serving one MIB subtree.
+type Subagent struct {
+	// Logf is the logger to use. It must be non-nil.
+	Logf logger.Logf
+
+	// Root is the OID of the subtree to register.
+	Root OID
+
+	// Description is sent to the master agent when opening the session.
+	Description string
+
+	// Vars returns the current values of all variables under Root.
+	// The result need not be sorted. It's called once per request.
+	Vars func() []Var
+
+	// Dial, if non-nil, connects to the master agent. The default
+	// dials the unix socket DefaultSocket.
+	Dial func(ctx context.Context) (net.Conn, error)
+
+	// RetryInterval is how long to wait before reconnecting after the
+	// connection to the master agent fails. The default is 15 seconds.
+	RetryInterval time.Duration
+
+	start time.Time // for sysUpTime in responses
+
+	mu        sync.Mutex
+	sessionID uint32
+	packetID  uint32
+}
+
+func (a *Subagent) retryInterval() time.Duration {
+	if a.RetryInterval > 0 {
+		return a.RetryInterval
+	}
+	return 15 * time.Second
+}
+
+func (a *Subagent) dial(ctx context.Context) (net.Conn, error) {
+	if a.Dial != nil {
+		return a.Dial(ctx)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "unix", DefaultSocket)
+}
+
+// Run connects to the master agent and serves requests until ctx is done,
+// reconnecting as needed. It always returns a non-nil error.
+func (a *Subagent) Run(ctx context.Context) error {
+	if a.Vars == nil || len(a.Root) == 0 {
+		return errors.New("agentx: Root and Vars must be set")
+	}
+	a.start = time.Now()
+	for {
+		err := a.runOnce(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		a.Logf("agentx: session ended: %v; retrying in %v", err, a.retryInterval())
+		select {
+		case <-time.After(a.retryInterval()):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (a *Subagent) runOnce(ctx context.Context) error {
+	c, err := a.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		a.sendClose(c, closeReasonShutdown)
+		c.Close()
+	}()
+
+	if err := a.open(c); err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	if err := a.register(c); err != nil {
+		return fmt.Errorf("register %v: %w", a.Root, err)
+	}
+	a.Logf("agentx: registered %v", a.Root)
+	return a.serve(c)
+}
+
+func (a *Subagent) nextPacketID() uint32 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.packetID++
+	return a.packetID
+}
+
+// request sends a PDU initiated by us and waits for its response,
+// returning the response header and payload.
+func (a *Subagent) request(c net.Conn, typ uint8, e *encoder) (header, []byte, error) {
+	a.mu.Lock()
+	sid := a.sessionID
+	a.mu.Unlock()
+	h := header{Type: typ, SessionID: sid, PacketID: a.nextPacketID()}
+	if _, err := c.Write(e.finish(h)); err != nil {
+		return header{}, nil, err
+	}
+	rh, payload, err := readPDU(c)
+	if err != nil {
+		return header{}, nil, err
+	}
+	if rh.Type != pduResponse || rh.PacketID != h.PacketID {
+		return header{}, nil, fmt.Errorf("unexpected PDU type %d (packet %d)", rh.Type, rh.PacketID)
+	}
+	d := &decoder{bo: rh.byteOrder(), b: payload}
+	d.u32() // sysUpTime
+	if code := d.u16(); code != errNoError {
+		return header{}, nil, fmt.Errorf("master agent returned error %d", code)
+	}
+	return rh, payload, d.err
+}
+
+func (a *Subagent) open(c net.Conn) error {
+	a.mu.Lock()
+	a.sessionID = 0 // assigned by the master in its response
+	a.mu.Unlock()
+
+	e := new(encoder)
+	e.u8(uint8(a.retryInterval() / time.Second))
+	e.u8(0)
+	e.u16(0)
+	e.oid(a.Root, false)
+	e.octets([]byte(a.Description))
+	rh, _, err := a.request(c, pduOpen, e)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.sessionID = rh.SessionID
+	a.mu.Unlock()
+	return nil
+}
+
+func (a *Subagent) register(c net.Conn) error {
+	e := new(encoder)
+	e.u8(0)   // timeout: use the session default
+	e.u8(127) // priority: the RFC's default
+	e.u8(0)   // range_subid
+	e.u8(0)
+	e.oid(a.Root, false)
+	_, _, err := a.request(c, pduRegister, e)
+	return err
+}
+
+func (a *Subagent) sendClose(c net.Conn, reason uint8) {
+	a.mu.Lock()
+	sid := a.sessionID
+	a.mu.Unlock()
+	e := new(encoder)
+	e.u8(reason)
+	e.u8(0)
+	e.u16(0)
+	c.SetWriteDeadline(time.Now().Add(time.Second))
+	c.Write(e.finish(header{Type: pduClose, SessionID: sid, PacketID: a.nextPacketID()}))
+}
+
+func readPDU(r io.Reader) (header, []byte, error) {
+	var hb [headerLen]byte
+	if _, err := io.ReadFull(r, hb[:]); err != nil {
+		return header{}, nil, err
+	}
+	h, err := parseHeader(hb[:])
+	if err != nil {
+		return header{}, nil, err
+	}
+	payload := make([]byte, h.PayloadLen)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return header{}, nil, err
+	}
+	return h, payload, nil
+}
+
+// serve answers requests from the master agent until the connection fails
+// or the master closes the session.
+func (a *Subagent) serve(c net.Conn) error {
+	for {
+		h, payload, err := readPDU(c)
+		if err != nil {
+			return err
+		}
+		if h.Type == pduClose {
+			return errors.New("closed by master agent")
+		}
+		resp := a.handle(h, payload)
+		if resp == nil {
+			continue
+		}
+		if _, err := c.Write(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// handle returns the response to the request PDU h, or nil if none is
+// needed.
+func (a *Subagent) handle(h header, payload []byte) []byte {
+	d := &decoder{bo: h.byteOrder(), b: payload}
+	if h.Flags&flagNonDefaultContext != 0 {
+		d.octets()
+	}
+
+	var (
+		vars []Var
+		code uint16
+	)
+	switch h.Type {
+	case pduGet, pduGetNext:
+		ranges := d.searchRanges()
+		if d.err == nil {
+			vars = a.lookup(h.Type, ranges)
+		}
+	case pduGetBulk:
+		nonRep := int(d.u16())
+		maxRep := int(d.u16())
+		ranges := d.searchRanges()
+		if d.err == nil {
+			vars = a.bulk(nonRep, maxRep, ranges)
+		}
+	case pduTestSet:
+		code = errNotWritable
+	case pduCommitSet, pduUndoSet:
+		// Only sent after a successful TestSet, which we never return;
+		// acknowledge them anyway.
+	case pduCleanupSet:
+		return nil
+	case pduResponse:
+		// Stray response to something we sent; ignore it.
+		return nil
+	default:
+		code = errGenErr
+	}
+	if d.err != nil {
+		code = errParseError
+		vars = nil
+	}
+
+	e := new(encoder)
+	e.u32(a.upTime())
+	e.u16(code)
+	e.u16(0)
+	for _, v := range vars {
+		if err := e.varbind(v); err != nil {
+			a.Logf("agentx: %v", err)
+			e = new(encoder)
+			e.u32(a.upTime())
+			e.u16(errGenErr)
+			e.u16(0)
+			break
+		}
+	}
+	return e.finish(header{
+		Type:          pduResponse,
+		SessionID:     h.SessionID,
+		TransactionID: h.TransactionID,
+		PacketID:      h.PacketID,
+	})
+}
+
+func (a *Subagent) upTime() uint32 {
+	return uint32(time.Since(a.start) / (10 * time.Millisecond))
+}
+
+// snapshot returns the current variables, sorted by name and restricted
+// to the registered subtree.
+func (a *Subagent) snapshot() []Var {
+	vars := slices.DeleteFunc(a.Vars(), func(v Var) bool {
+		return !v.Name.HasPrefix(a.Root)
+	})
+	slices.SortFunc(vars, func(x, y Var) int { return x.Name.Compare(y.Name) })
+	return vars
+}
+
+func (a *Subagent) lookup(typ uint8, ranges []searchRange) []Var {
+	vars := a.snapshot()
+	out := make([]Var, 0, len(ranges))
+	for _, r := range ranges {
+		if typ == pduGet {
+			out = append(out, get(vars, r.Start))
+		} else {
+			out = append(out, getNext(vars, r))
+		}
+	}
+	return out
+}
+
+// bulk implements GetBulk as described in RFC 2741 section 7.2.3.3.
+func (a *Subagent) bulk(nonRep, maxRep int, ranges []searchRange) []Var {
+	vars := a.snapshot()
+	nonRep = min(nonRep, len(ranges))
+	var out []Var
+	for _, r := range ranges[:nonRep] {
+		out = append(out, getNext(vars, r))
+	}
+	rep := slices.Clone(ranges[nonRep:])
+	for range maxRep {
+		done := true
+		for i, r := range rep {
+			v := getNext(vars, r)
+			out = append(out, v)
+			if v.Type != TypeEndOfMIBView {
+				done = false
+				rep[i] = searchRange{Start: v.Name, End: r.End}
+			}
+		}
+		if done {
+			break
+		}
+	}
+	return out
+}
+
+func get(vars []Var, name OID) Var {
+	i, ok := slices.BinarySearchFunc(vars, name, func(v Var, o OID) int { return v.Name.Compare(o) })
+	if ok {
+		return vars[i]
+	}
+	return Var{Name: name, Type: TypeNoSuchObject}
+}
+
+func getNext(vars []Var, r searchRange) Var {
+	i, ok := slices.BinarySearchFunc(vars, r.Start, func(v Var, o OID) int { return v.Name.Compare(o) })
+	if ok && !r.Include {
+		i++
+	}
+	if i < len(vars) && (len(r.End) == 0 || vars[i].Name.Compare(r.End) < 0) {
+		return vars[i]
+	}
+	return Var{Name: r.Start, Type: TypeEndOfMIBView}
+}
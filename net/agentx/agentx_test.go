@@ -0,0 +1,243 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package agentx
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseOID(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    OID
+		wantErr bool
+	}{
+		{in: "1.3.6.1", want: OID{1, 3, 6, 1}},
+		{in: ".1.3.6.1.4.1", want: OID{1, 3, 6, 1, 4, 1}},
+		{in: "", wantErr: true},
+		{in: "1..3", wantErr: true},
+		{in: "1.3.x", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseOID(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseOID(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got.Compare(tt.want) != 0 {
+			t.Errorf("ParseOID(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestOIDRoundTrip(t *testing.T) {
+	for _, o := range []OID{
+		{1, 3, 6, 1, 4, 1, 99999, 1},
+		{1, 3, 6, 1},
+		{1, 3, 6, 1, 300, 2},
+		{2, 5},
+	} {
+		e := new(encoder)
+		e.oid(o, true)
+		d := &decoder{bo: binary.BigEndian, b: e.b}
+		got, include := d.oid()
+		if d.err != nil {
+			t.Fatalf("%v: %v", o, d.err)
+		}
+		if got.Compare(o) != 0 || !include {
+			t.Errorf("round trip of %v = %v (include=%v)", o, got, include)
+		}
+	}
+}
+
+var testRoot = MustParseOID("1.3.6.1.3.4242")
+
+func testVars() []Var {
+	return []Var{
+		Gauge32(testRoot.Append(2, 0), 7),
+		String(testRoot.Append(1, 0), "tailscale0"),
+		Counter64(testRoot.Append(3, 0), 1<<40),
+		Integer(OID{1, 3, 6, 1, 2, 1, 1, 3, 0}, 1), // outside root; dropped
+	}
+}
+
+func TestGetNextAndBulk(t *testing.T) {
+	a := &Subagent{Logf: t.Logf, Root: testRoot, Vars: testVars}
+	vars := a.snapshot()
+	if len(vars) != 3 {
+		t.Fatalf("snapshot has %d vars, want 3", len(vars))
+	}
+
+	names := func(vs []Var) []string {
+		var out []string
+		for _, v := range vs {
+			if v.Type == TypeEndOfMIBView || v.Type == TypeNoSuchObject {
+				out = append(out, "end:"+v.Name.String())
+				continue
+			}
+			out = append(out, v.Name.String())
+		}
+		return out
+	}
+
+	got := names(a.lookup(pduGetNext, []searchRange{
+		{Start: testRoot},
+		{Start: testRoot.Append(1, 0), Include: true},
+		{Start: testRoot.Append(1, 0)},
+		{Start: testRoot.Append(3, 0)},
+		{Start: testRoot.Append(1, 0), End: testRoot.Append(2, 0)},
+	}))
+	want := []string{
+		"1.3.6.1.3.4242.1.0",
+		"1.3.6.1.3.4242.1.0",
+		"1.3.6.1.3.4242.2.0",
+		"end:1.3.6.1.3.4242.3.0",
+		"end:1.3.6.1.3.4242.1.0",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("GetNext (-want +got):\n%s", diff)
+	}
+
+	got = names(a.lookup(pduGet, []searchRange{
+		{Start: testRoot.Append(2, 0)},
+		{Start: testRoot.Append(9, 0)},
+	}))
+	want = []string{"1.3.6.1.3.4242.2.0", "end:1.3.6.1.3.4242.9.0"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Get (-want +got):\n%s", diff)
+	}
+
+	got = names(a.bulk(0, 10, []searchRange{{Start: testRoot}}))
+	want = []string{
+		"1.3.6.1.3.4242.1.0",
+		"1.3.6.1.3.4242.2.0",
+		"1.3.6.1.3.4242.3.0",
+		"end:1.3.6.1.3.4242.3.0",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("GetBulk (-want +got):\n%s", diff)
+	}
+}
+
+// TestSession runs a Subagent against a fake master agent and checks the
+// open, register and get exchange on the wire.
+func TestSession(t *testing.T) {
+	master, sub := net.Pipe()
+	defer master.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := &Subagent{
+		Logf:        t.Logf,
+		Root:        testRoot,
+		Description: "test",
+		Vars:        testVars,
+		Dial: func(context.Context) (net.Conn, error) {
+			return sub, nil
+		},
+		RetryInterval: time.Hour,
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- a.Run(ctx) }()
+
+	respond := func(req header, sessionID uint32) {
+		t.Helper()
+		e := new(encoder)
+		e.u32(0)
+		e.u16(errNoError)
+		e.u16(0)
+		resp := e.finish(header{Type: pduResponse, SessionID: sessionID, PacketID: req.PacketID})
+		if _, err := master.Write(resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h, payload, err := readPDU(master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Type != pduOpen {
+		t.Fatalf("first PDU type = %d, want Open", h.Type)
+	}
+	d := &decoder{bo: h.byteOrder(), b: payload}
+	d.u32()
+	if id, _ := d.oid(); id.Compare(testRoot) != 0 {
+		t.Errorf("Open id = %v, want %v", id, testRoot)
+	}
+	if descr := string(d.octets()); descr != "test" {
+		t.Errorf("Open descr = %q, want %q", descr, "test")
+	}
+	respond(h, 42)
+
+	h, payload, err = readPDU(master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Type != pduRegister || h.SessionID != 42 {
+		t.Fatalf("second PDU = type %d session %d, want Register in session 42", h.Type, h.SessionID)
+	}
+	d = &decoder{bo: h.byteOrder(), b: payload}
+	d.u32()
+	if tree, _ := d.oid(); tree.Compare(testRoot) != 0 {
+		t.Errorf("Register subtree = %v, want %v", tree, testRoot)
+	}
+	respond(h, 42)
+
+	// Send a Get in little-endian byte order to exercise that path.
+	var req []byte
+	req = append(req, 1, pduGet, 0, 0)
+	req = binary.LittleEndian.AppendUint32(req, 42)
+	req = binary.LittleEndian.AppendUint32(req, 7)
+	req = binary.LittleEndian.AppendUint32(req, 9)
+	name := testRoot.Append(3, 0)
+	var body []byte
+	body = append(body, byte(len(name)-5), byte(name[4]), 0, 0)
+	for _, v := range name[5:] {
+		body = binary.LittleEndian.AppendUint32(body, v)
+	}
+	body = append(body, 0, 0, 0, 0) // null end OID
+	req = binary.LittleEndian.AppendUint32(req, uint32(len(body)))
+	req = append(req, body...)
+	if _, err := master.Write(req); err != nil {
+		t.Fatal(err)
+	}
+
+	h, payload, err = readPDU(master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Type != pduResponse || h.TransactionID != 7 || h.PacketID != 9 {
+		t.Fatalf("got PDU %+v, want Response to transaction 7 packet 9", h)
+	}
+	d = &decoder{bo: h.byteOrder(), b: payload}
+	d.u32()
+	if code := d.u16(); code != errNoError {
+		t.Fatalf("response error = %d", code)
+	}
+	d.u16()
+	typ := Type(d.u16())
+	d.u16()
+	got, _ := d.oid()
+	val := uint64(d.u32())<<32 | uint64(d.u32())
+	if d.err != nil {
+		t.Fatal(d.err)
+	}
+	if typ != TypeCounter64 || got.Compare(name) != 0 || val != 1<<40 {
+		t.Errorf("got varbind %v type %d = %d; want %v Counter64 = %d", got, typ, val, name, uint64(1<<40))
+	}
+
+	cancel()
+	if h, _, err := readPDU(master); err != nil || h.Type != pduClose {
+		t.Errorf("after cancel: got PDU type %d, err %v; want Close", h.Type, err)
+	}
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("Run = %v, want context.Canceled", err)
+	}
+}
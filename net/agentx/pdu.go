@@ -0,0 +1,389 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package agentx
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// PDU types, from RFC 2741 section 6.1.
+const (
+	pduOpen       = 1
+	pduClose      = 2
+	pduRegister   = 3
+	pduGet        = 5
+	pduGetNext    = 6
+	pduGetBulk    = 7
+	pduTestSet    = 8
+	pduCommitSet  = 9
+	pduUndoSet    = 10
+	pduCleanupSet = 11
+	pduResponse   = 18
+)
+
+// Header flags, from RFC 2741 section 6.1.
+const (
+	flagNonDefaultContext = 0x08
+	flagNetworkByteOrder  = 0x10
+)
+
+// Response error codes, from RFC 2741 section 6.2.16.
+const (
+	errNoError     = 0
+	errGenErr      = 5
+	errNotWritable = 17
+	errParseError  = 266
+)
+
+// Close reasons, from RFC 2741 section 6.2.2.
+const (
+	closeReasonShutdown = 5
+)
+
+const headerLen = 20
+
+// maxPayload bounds the size of PDUs we're willing to read from the
+// master agent. Real requests are tiny; this is just a sanity limit.
+const maxPayload = 1 << 20
+
+// internetPrefix is the OID prefix that AgentX can compress into a single
+// byte of the encoded form.
+var internetPrefix = OID{1, 3, 6, 1}
+
+// OID is an SNMP object identifier.
+type OID []uint32
+
+// ParseOID parses a dotted-decimal OID such as "1.3.6.1.2.1".
+// A single leading dot is permitted.
+func ParseOID(s string) (OID, error) {
+	s = strings.TrimPrefix(s, ".")
+	if s == "" {
+		return nil, errors.New("empty OID")
+	}
+	parts := strings.Split(s, ".")
+	oid := make(OID, len(parts))
+	for i, p := range parts {
+		v, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %q: %w", s, err)
+		}
+		oid[i] = uint32(v)
+	}
+	return oid, nil
+}
+
+// MustParseOID is like ParseOID but panics on error.
+func MustParseOID(s string) OID {
+	oid, err := ParseOID(s)
+	if err != nil {
+		panic(err)
+	}
+	return oid
+}
+
+func (o OID) String() string {
+	var sb strings.Builder
+	for i, v := range o {
+		if i > 0 {
+			sb.WriteByte('.')
+		}
+		sb.WriteString(strconv.FormatUint(uint64(v), 10))
+	}
+	return sb.String()
+}
+
+// Append returns a new OID consisting of o followed by sub.
+func (o OID) Append(sub ...uint32) OID {
+	return append(slices.Clip(o), sub...)
+}
+
+// Compare compares o and p lexicographically, returning -1, 0 or 1.
+func (o OID) Compare(p OID) int {
+	return slices.Compare(o, p)
+}
+
+// HasPrefix reports whether o lies within the subtree rooted at p.
+func (o OID) HasPrefix(p OID) bool {
+	return len(o) >= len(p) && slices.Equal(o[:len(p)], p)
+}
+
+// Type is the type of a variable binding's value.
+type Type uint16
+
+// Value types, from RFC 2741 section 5.4.
+const (
+	TypeInteger        Type = 2
+	TypeOctetString    Type = 4
+	TypeNull           Type = 5
+	TypeObjectID       Type = 6
+	TypeIPAddress      Type = 64
+	TypeCounter32      Type = 65
+	TypeGauge32        Type = 66
+	TypeTimeTicks      Type = 67
+	TypeOpaque         Type = 68
+	TypeCounter64      Type = 70
+	TypeNoSuchObject   Type = 128
+	TypeNoSuchInstance Type = 129
+	TypeEndOfMIBView   Type = 130
+)
+
+// Var is a single variable binding: a name and its typed value.
+type Var struct {
+	Name OID
+	Type Type
+
+	// Value holds the value, according to Type:
+	//
+	//   - TypeInteger: int32
+	//   - TypeCounter32, TypeGauge32, TypeTimeTicks: uint32
+	//   - TypeCounter64: uint64
+	//   - TypeOctetString, TypeOpaque, TypeIPAddress: []byte or string
+	//   - TypeObjectID: OID
+	//   - TypeNull and the exception types: nil
+	Value any
+}
+
+// Integer returns an INTEGER variable.
+func Integer(name OID, v int32) Var { return Var{name, TypeInteger, v} }
+
+// String returns an OCTET STRING variable.
+func String(name OID, v string) Var { return Var{name, TypeOctetString, v} }
+
+// Counter64 returns a Counter64 variable.
+func Counter64(name OID, v uint64) Var { return Var{name, TypeCounter64, v} }
+
+// Gauge32 returns a Gauge32 variable.
+func Gauge32(name OID, v uint32) Var { return Var{name, TypeGauge32, v} }
+
+// TimeTicks returns a TimeTicks variable, in hundredths of a second.
+func TimeTicks(name OID, v uint32) Var { return Var{name, TypeTimeTicks, v} }
+
+// header is the fixed-size header preceding every AgentX PDU.
+type header struct {
+	Type          uint8
+	Flags         uint8
+	SessionID     uint32
+	TransactionID uint32
+	PacketID      uint32
+	PayloadLen    uint32
+}
+
+func (h header) byteOrder() binary.ByteOrder {
+	if h.Flags&flagNetworkByteOrder != 0 {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+func parseHeader(b []byte) (header, error) {
+	if len(b) < headerLen {
+		return header{}, errors.New("short header")
+	}
+	if b[0] != 1 {
+		return header{}, fmt.Errorf("unsupported AgentX version %d", b[0])
+	}
+	h := header{Type: b[1], Flags: b[2]}
+	bo := h.byteOrder()
+	h.SessionID = bo.Uint32(b[4:])
+	h.TransactionID = bo.Uint32(b[8:])
+	h.PacketID = bo.Uint32(b[12:])
+	h.PayloadLen = bo.Uint32(b[16:])
+	if h.PayloadLen%4 != 0 || h.PayloadLen > maxPayload {
+		return header{}, fmt.Errorf("bad payload length %d", h.PayloadLen)
+	}
+	return h, nil
+}
+
+// encoder builds an outgoing PDU. We always send in network byte order.
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) u8(v uint8)   { e.b = append(e.b, v) }
+func (e *encoder) u16(v uint16) { e.b = binary.BigEndian.AppendUint16(e.b, v) }
+func (e *encoder) u32(v uint32) { e.b = binary.BigEndian.AppendUint32(e.b, v) }
+func (e *encoder) u64(v uint64) { e.b = binary.BigEndian.AppendUint64(e.b, v) }
+
+func (e *encoder) oid(o OID, include bool) {
+	prefix := uint8(0)
+	if len(o) > len(internetPrefix) && o.HasPrefix(internetPrefix) && o[4] > 0 && o[4] < 256 {
+		prefix = uint8(o[4])
+		o = o[5:]
+	}
+	e.u8(uint8(len(o)))
+	e.u8(prefix)
+	if include {
+		e.u8(1)
+	} else {
+		e.u8(0)
+	}
+	e.u8(0)
+	for _, v := range o {
+		e.u32(v)
+	}
+}
+
+func (e *encoder) octets(s []byte) {
+	e.u32(uint32(len(s)))
+	e.b = append(e.b, s...)
+	for len(e.b)%4 != 0 {
+		e.b = append(e.b, 0)
+	}
+}
+
+func (e *encoder) varbind(v Var) error {
+	e.u16(uint16(v.Type))
+	e.u16(0)
+	e.oid(v.Name, false)
+	switch v.Type {
+	case TypeInteger:
+		n, ok := v.Value.(int32)
+		if !ok {
+			return fmt.Errorf("%v: INTEGER value has type %T", v.Name, v.Value)
+		}
+		e.u32(uint32(n))
+	case TypeCounter32, TypeGauge32, TypeTimeTicks:
+		n, ok := v.Value.(uint32)
+		if !ok {
+			return fmt.Errorf("%v: value of type %d has type %T", v.Name, v.Type, v.Value)
+		}
+		e.u32(n)
+	case TypeCounter64:
+		n, ok := v.Value.(uint64)
+		if !ok {
+			return fmt.Errorf("%v: Counter64 value has type %T", v.Name, v.Value)
+		}
+		e.u64(n)
+	case TypeOctetString, TypeOpaque, TypeIPAddress:
+		switch s := v.Value.(type) {
+		case string:
+			e.octets([]byte(s))
+		case []byte:
+			e.octets(s)
+		default:
+			return fmt.Errorf("%v: string value has type %T", v.Name, v.Value)
+		}
+	case TypeObjectID:
+		o, ok := v.Value.(OID)
+		if !ok {
+			return fmt.Errorf("%v: OID value has type %T", v.Name, v.Value)
+		}
+		e.oid(o, false)
+	case TypeNull, TypeNoSuchObject, TypeNoSuchInstance, TypeEndOfMIBView:
+	default:
+		return fmt.Errorf("%v: unknown type %d", v.Name, v.Type)
+	}
+	return nil
+}
+
+// finish prepends the header for a PDU whose payload is in e.b.
+func (e *encoder) finish(h header) []byte {
+	out := make([]byte, headerLen, headerLen+len(e.b))
+	out[0] = 1
+	out[1] = h.Type
+	out[2] = h.Flags | flagNetworkByteOrder
+	binary.BigEndian.PutUint32(out[4:], h.SessionID)
+	binary.BigEndian.PutUint32(out[8:], h.TransactionID)
+	binary.BigEndian.PutUint32(out[12:], h.PacketID)
+	binary.BigEndian.PutUint32(out[16:], uint32(len(e.b)))
+	return append(out, e.b...)
+}
+
+// decoder reads fields from an incoming PDU payload.
+type decoder struct {
+	bo  binary.ByteOrder
+	b   []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.b) < n {
+		d.err = errors.New("truncated PDU")
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) u8() uint8 {
+	if b := d.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) u16() uint16 {
+	if b := d.take(2); b != nil {
+		return d.bo.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) u32() uint32 {
+	if b := d.take(4); b != nil {
+		return d.bo.Uint32(b)
+	}
+	return 0
+}
+
+// oid decodes an OID, also returning its include flag.
+func (d *decoder) oid() (o OID, include bool) {
+	n := int(d.u8())
+	prefix := d.u8()
+	include = d.u8() != 0
+	d.u8()
+	if d.err != nil {
+		return nil, false
+	}
+	if prefix != 0 {
+		o = append(o, internetPrefix...)
+		o = append(o, uint32(prefix))
+	}
+	for range n {
+		o = append(o, d.u32())
+	}
+	return o, include
+}
+
+func (d *decoder) octets() []byte {
+	n := int(d.u32())
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.b) {
+		d.err = errors.New("truncated octet string")
+		return nil
+	}
+	s := d.take(n)
+	if pad := (4 - n%4) % 4; pad > 0 {
+		d.take(pad)
+	}
+	return s
+}
+
+// searchRange is a SearchRange from RFC 2741 section 5.2.
+type searchRange struct {
+	Start   OID
+	End     OID // empty means unbounded
+	Include bool
+}
+
+func (d *decoder) searchRanges() []searchRange {
+	var rs []searchRange
+	for len(d.b) > 0 && d.err == nil {
+		start, include := d.oid()
+		end, _ := d.oid()
+		rs = append(rs, searchRange{Start: start, End: end, Include: include})
+	}
+	return rs
+}
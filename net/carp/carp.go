@@ -0,0 +1,120 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package carp reads the state of CARP (Common Address Redundancy
+// Protocol) virtual hosts on the BSDs, for pairs of subnet routers that
+// fail over between each other along with the LAN addresses they share.
+package carp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// State is the state of a CARP virtual host.
+type State string
+
+const (
+	Master State = "MASTER"
+	Backup State = "BACKUP"
+	Init   State = "INIT"
+)
+
+// ParseState parses s, case-insensitively, as a State.
+func ParseState(s string) (State, error) {
+	switch st := State(strings.ToUpper(s)); st {
+	case Master, Backup, Init:
+		return st, nil
+	}
+	return "", fmt.Errorf("invalid CARP state %q", s)
+}
+
+// Spec identifies a CARP virtual host: an interface, and on FreeBSD,
+// where virtual hosts live on the parent interface, optionally a vhid.
+type Spec struct {
+	Interface string
+	VHID      int // or 0 for any virtual host on Interface
+}
+
+// ParseSpec parses s as either an interface name ("carp0", "em0") or, in
+// the notation of FreeBSD's devd events, "vhid@interface" ("1@em0").
+func ParseSpec(s string) (Spec, error) {
+	vhid, ifname, ok := strings.Cut(s, "@")
+	if !ok {
+		if s == "" {
+			return Spec{}, errors.New("empty CARP interface")
+		}
+		return Spec{Interface: s}, nil
+	}
+	n, err := strconv.Atoi(vhid)
+	if err != nil || n < 1 || n > 255 || ifname == "" {
+		return Spec{}, fmt.Errorf("invalid CARP virtual host %q; want vhid@interface", s)
+	}
+	return Spec{Interface: ifname, VHID: n}, nil
+}
+
+func (s Spec) String() string {
+	if s.VHID == 0 {
+		return s.Interface
+	}
+	return fmt.Sprintf("%d@%s", s.VHID, s.Interface)
+}
+
+// Get returns the state of the virtual host s, as reported by ifconfig.
+func Get(s Spec) (State, error) {
+	out, err := exec.Command("ifconfig", s.Interface).Output()
+	if err != nil {
+		return "", fmt.Errorf("ifconfig %s: %w", s.Interface, err)
+	}
+	return parseIfconfig(out, s.VHID)
+}
+
+// parseIfconfig returns the CARP state in the ifconfig output out, which
+// has lines like these on FreeBSD and on OpenBSD and NetBSD respectively:
+//
+//	carp: MASTER vhid 1 advbase 1 advskew 0
+//	carp: BACKUP carpdev em0 vhid 1 advbase 1 advskew 100
+//
+// If vhid is zero and there are several virtual hosts, the interface
+// counts as Master if any of them is, so that a router that holds any of
+// the shared addresses keeps serving them.
+func parseIfconfig(out []byte, vhid int) (State, error) {
+	var found []State
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) < 2 || f[0] != "carp:" {
+			continue
+		}
+		st, err := ParseState(f[1])
+		if err != nil {
+			continue
+		}
+		if vhid != 0 {
+			i := slices.Index(f, "vhid")
+			if i < 0 || i+1 >= len(f) || f[i+1] != strconv.Itoa(vhid) {
+				continue
+			}
+		}
+		found = append(found, st)
+	}
+	if len(found) == 0 {
+		return "", errors.New("no CARP virtual host found")
+	}
+	ret := found[0]
+	for _, st := range found {
+		if st == Master {
+			return Master, nil
+		}
+		if st == Backup {
+			ret = Backup
+		}
+	}
+	return ret, nil
+}
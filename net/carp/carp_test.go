@@ -0,0 +1,66 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package carp
+
+import "testing"
+
+func TestParseIfconfig(t *testing.T) {
+	const freebsd = `em0: flags=1008843<UP,BROADCAST,RUNNING,SIMPLEX,MULTICAST,LOWER_UP> metric 0 mtu 1500
+	inet 192.168.1.2 netmask 0xffffff00 broadcast 192.168.1.255
+	inet 192.168.1.1 netmask 0xffffff00 broadcast 192.168.1.255 vhid 1
+	inet 192.168.2.1 netmask 0xffffff00 broadcast 192.168.2.255 vhid 2
+	carp: BACKUP vhid 1 advbase 1 advskew 100
+	carp: MASTER vhid 2 advbase 1 advskew 0
+`
+	const openbsd = `carp0: flags=8843<UP,BROADCAST,RUNNING,SIMPLEX,MULTICAST> mtu 1500
+	lladdr 00:00:5e:00:01:01
+	carp: BACKUP carpdev em0 vhid 1 advbase 1 advskew 100
+	inet 192.168.1.1 netmask 0xffffff00 broadcast 192.168.1.255
+`
+	tests := []struct {
+		out     string
+		vhid    int
+		want    State
+		wantErr bool
+	}{
+		{out: freebsd, want: Master},
+		{out: freebsd, vhid: 1, want: Backup},
+		{out: freebsd, vhid: 2, want: Master},
+		{out: freebsd, vhid: 3, wantErr: true},
+		{out: openbsd, want: Backup},
+		{out: "lo0: flags=8049<UP,LOOPBACK,RUNNING,MULTICAST> mtu 16384\n", wantErr: true},
+	}
+	for i, tt := range tests {
+		got, err := parseIfconfig([]byte(tt.out), tt.vhid)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%d: err = %v, wantErr %v", i, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%d: got %q, want %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestParseSpec(t *testing.T) {
+	for _, tt := range []struct {
+		in      string
+		want    Spec
+		wantErr bool
+	}{
+		{in: "carp0", want: Spec{Interface: "carp0"}},
+		{in: "3@em0", want: Spec{Interface: "em0", VHID: 3}},
+		{in: "x@em0", wantErr: true},
+		{in: "1@", wantErr: true},
+		{in: "", wantErr: true},
+	} {
+		got, err := ParseSpec(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseSpec(%q) = %v, %v; want %v, err=%v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+		if err == nil && got.String() != tt.in {
+			t.Errorf("%v.String() = %q; want %q", got, got.String(), tt.in)
+		}
+	}
+}
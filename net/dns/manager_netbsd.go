@@ -0,0 +1,38 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package dns
+
+import (
+	"fmt"
+	"os"
+
+	"tailscale.com/control/controlknobs"
+	"tailscale.com/health"
+	"tailscale.com/types/logger"
+)
+
+// NewOSConfigurator creates a new OS configurator.
+//
+// The health tracker may be nil; the knobs may be nil and are ignored on this platform.
+func NewOSConfigurator(logf logger.Logf, health *health.Tracker, _ *controlknobs.Knobs, _ string) (OSConfigurator, error) {
+	bs, err := os.ReadFile(resolvConf)
+	if os.IsNotExist(err) {
+		return newDirectManager(logf, health), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", resolvConf, err)
+	}
+
+	// NetBSD ships openresolv as resolvconf(8). Use it if it's managing
+	// resolv.conf. Otherwise, rewrite resolv.conf directly, backing up
+	// the original to restore on shutdown.
+	if resolvOwner(bs) == "resolvconf" {
+		if style := resolvconfStyle(); style == "openresolv" {
+			return newOpenresolvManager(logf)
+		} else if style != "" {
+			logf("[unexpected] got unknown flavor of resolvconf %q, falling back to direct manager", style)
+		}
+	}
+	return newDirectManager(logf, health), nil
+}
@@ -208,6 +208,10 @@ type Resolver struct {
 	// forwarder forwards requests to upstream nameservers.
 	forwarder *forwarder
 
+	// responseObserver, if non-nil, is called with each forwarded response
+	// to a local query. See SetResponseObserver.
+	responseObserver syncs.AtomicValue[func(res []byte)]
+
 	// closed signals all goroutines to stop.
 	closed chan struct{}
 
@@ -260,6 +264,17 @@ func (r *Resolver) SetMissingUpstreamRecovery(f func()) {
 	r.forwarder.missingUpstreamRecovery = f
 }
 
+// SetResponseObserver sets f to be called with each response the forwarder
+// receives from upstream nameservers for a local query, before it's returned
+// to the querier. A nil f removes the observer. The app connector uses it to
+// learn the addresses of its domains from lookups made through this node's
+// own resolver; peers' queries over the PeerAPI are observed separately.
+//
+// f must not modify res or retain it after returning.
+func (r *Resolver) SetResponseObserver(f func(res []byte)) {
+	r.responseObserver.Store(f)
+}
+
 func (r *Resolver) TestOnlySetHook(hook func(Config)) { r.saveConfigForTests = hook }
 
 func (r *Resolver) SetConfig(cfg Config) error {
@@ -323,7 +338,11 @@ func (r *Resolver) Query(ctx context.Context, bs []byte, family string, from net
 		if err != nil {
 			return nil, err
 		}
-		return (<-responses).bs, nil
+		res := (<-responses).bs
+		if obs := r.responseObserver.Load(); obs != nil {
+			obs(res)
+		}
+		return res, nil
 	}
 
 	return out, err
@@ -384,7 +403,7 @@ func (r *Resolver) HandlePeerDNSQuery(ctx context.Context, q []byte, from netip.
 		// but for now that's probably good enough. Later we'll
 		// want to blend in everything from scutil --dns.
 		fallthrough
-	case "linux", "freebsd", "openbsd", "illumos", "solaris", "ios", "netbsd":
+	case "linux", "freebsd", "openbsd", "illumos", "solaris", "ios", "netbsd", "dragonfly":
 		nameserver, err := stubResolverForOS()
 		if err != nil {
 			r.logf("stubResolverForOS: %v", err)
@@ -18,6 +18,7 @@
 	"net/http"
 	"net/netip"
 	"runtime"
+	"slices"
 	"sort"
 	"sync"
 	"syscall"
@@ -379,6 +380,18 @@ type probe struct {
 	wait time.Duration
 }
 
+// deleteIPv4 removes the probes over IPv4 from p, and the sets left empty.
+func (p probePlan) deleteIPv4() {
+	for name, set := range p {
+		set = slices.DeleteFunc(set, func(pr probe) bool { return pr.proto == probeIPv4 })
+		if len(set) == 0 {
+			delete(p, name)
+		} else {
+			p[name] = set
+		}
+	}
+}
+
 // probePlan is a set of node probes to run.
 // The map key is a descriptive name, only used for tests.
 //
@@ -772,6 +785,11 @@ type GetReportOpts struct {
 	// OnlyTCP443 constrains netcheck reporting to measurements over TCP port
 	// 443.
 	OnlyTCP443 bool
+	// OnlyIPv6 constrains netcheck to probing over IPv6, to diagnose
+	// IPv6-only networks: DERP nodes are only sent STUN probes at their
+	// IPv6 addresses, and the HTTPS and ICMP fallbacks for blocked UDP,
+	// which may use IPv4, are skipped.
+	OnlyIPv6 bool
 }
 
 // getLastDERPActivity calls o.GetLastDERPActivity if both o and
@@ -897,6 +915,10 @@ func (c *Client) GetReport(ctx context.Context, dm *tailcfg.DERPMap, opts *GetRe
 	if opts == nil || !opts.OnlyTCP443 {
 		plan = makeProbePlan(dm, ifState, last, preferredDERP)
 	}
+	onlyIPv6 := opts != nil && opts.OnlyIPv6
+	if onlyIPv6 {
+		plan.deleteIPv4()
+	}
 
 	// If we're doing a full probe, also check for a captive portal. We
 	// delay by a bit to wait for UDP STUN to finish, to avoid the probe if
@@ -979,7 +1001,7 @@ func (c *Client) GetReport(ctx context.Context, dm *tailcfg.DERPMap, opts *GetRe
 	// Try HTTPS and ICMP latency check if all STUN probes failed due to
 	// UDP presumably being blocked.
 	// TODO: this should be moved into the probePlan, using probeProto probeHTTPS.
-	if !rs.anyUDP() && ctx.Err() == nil {
+	if !rs.anyUDP() && ctx.Err() == nil && !onlyIPv6 {
 		var wg sync.WaitGroup
 		var need []*tailcfg.DERPRegion
 		for rid, reg := range dm.Regions {
@@ -706,6 +706,22 @@ func TestMakeProbePlan(t *testing.T) {
 	}
 }
 
+func TestProbePlanDeleteIPv4(t *testing.T) {
+	plan := probePlan{
+		"region-1-v4": []probe{{node: "1a", proto: probeIPv4}, {node: "1a", proto: probeIPv4, delay: 100 * time.Millisecond}},
+		"region-1-v6": []probe{{node: "1a", proto: probeIPv6}, {node: "1a", proto: probeIPv6, delay: 100 * time.Millisecond}},
+		"region-2-v6": []probe{{node: "2a", proto: probeIPv6}},
+	}
+	plan.deleteIPv4()
+	want := probePlan{
+		"region-1-v6": []probe{{node: "1a", proto: probeIPv6}, {node: "1a", proto: probeIPv6, delay: 100 * time.Millisecond}},
+		"region-2-v6": []probe{{node: "2a", proto: probeIPv6}},
+	}
+	if !reflect.DeepEqual(plan, want) {
+		t.Errorf("got:\n%v\nwant:\n%v", plan, want)
+	}
+}
+
 func (plan probePlan) String() string {
 	var sb strings.Builder
 	for _, key := range slices.Sorted(maps.Keys(plan)) {
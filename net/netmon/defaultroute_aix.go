@@ -0,0 +1,49 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netmon
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"os/exec"
+	"strings"
+)
+
+// defaultRoute finds the default route's interface in the output of
+// netstat(1), as AIX has no routing socket dump that Go can parse.
+func defaultRoute() (d DefaultRouteDetails, err error) {
+	out, err := exec.Command("netstat", "-rn", "-f", "inet").Output()
+	if err != nil {
+		return d, err
+	}
+	name := netstatDefaultInterface(out)
+	if name == "" {
+		return d, errors.New("no default route found")
+	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return d, err
+	}
+	d.InterfaceName = iface.Name
+	d.InterfaceIndex = iface.Index
+	return d, nil
+}
+
+// netstatDefaultInterface returns the interface of the default route in
+// the output of "netstat -rn", whose lines look like:
+//
+//	Destination        Gateway           Flags   Refs     Use  If   Exp  Groups
+//	default            10.0.0.1          UG        3    12345 en0      -      -
+func netstatDefaultInterface(out []byte) string {
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) >= 6 && f[0] == "default" {
+			return f[5]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,49 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netmon
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"os/exec"
+	"strings"
+)
+
+// defaultRoute asks route(8) which interface the default route uses, as
+// illumos has no sysctl to dump the routing table with.
+func defaultRoute() (d DefaultRouteDetails, err error) {
+	out, err := exec.Command("route", "-n", "get", "default").Output()
+	if err != nil {
+		return d, err
+	}
+	name := routeGetInterface(out)
+	if name == "" {
+		return d, errors.New("no default route interface found")
+	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return d, err
+	}
+	d.InterfaceName = iface.Name
+	d.InterfaceIndex = iface.Index
+	return d, nil
+}
+
+// routeGetInterface returns the interface name in the output of
+// "route get", or the empty string if there's none.
+func routeGetInterface(out []byte) string {
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		k, v, ok := strings.Cut(s.Text(), ":")
+		if ok && strings.TrimSpace(k) == "interface" {
+			// Logical interfaces are named like "e1000g0:1"; the
+			// default route belongs to the physical one.
+			name, _, _ := strings.Cut(strings.TrimSpace(v), ":")
+			return name
+		}
+	}
+	return ""
+}
@@ -7,6 +7,7 @@
 	"encoding/json"
 	"net"
 	"net/netip"
+	"reflect"
 	"testing"
 
 	"tailscale.com/tstest"
@@ -284,6 +285,36 @@ func TestStateString(t *testing.T) {
 	}
 }
 
+func TestTailscaleRangeConflicts(t *testing.T) {
+	up := Interface{Interface: &net.Interface{Flags: net.FlagUp}}
+	down := Interface{Interface: &net.Interface{}}
+	s := &State{
+		Interface: map[string]Interface{
+			"eth0":       up,
+			"eth1":       up,
+			"eth2":       down,
+			"arc0":       up,
+			"tailscale0": up,
+		},
+		InterfaceIPs: map[string][]netip.Prefix{
+			"eth0":       {netip.MustParsePrefix("192.168.1.2/24"), netip.MustParsePrefix("100.72.0.5/16"), netip.MustParsePrefix("fd7a:115c:a1e0:ab12::1/64")},
+			"eth1":       {netip.MustParsePrefix("10.0.0.2/8")},
+			"eth2":       {netip.MustParsePrefix("100.64.0.1/24")},
+			"arc0":       {netip.MustParsePrefix("100.115.92.1/30")},
+			"tailscale0": {netip.MustParsePrefix("100.101.102.103/32")},
+		},
+	}
+	want := map[string][]netip.Prefix{
+		"eth0": {netip.MustParsePrefix("100.72.0.5/16"), netip.MustParsePrefix("fd7a:115c:a1e0:ab12::1/64")},
+	}
+	if got := s.TailscaleRangeConflicts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("TailscaleRangeConflicts = %v; want %v", got, want)
+	}
+	if got := (*State)(nil).TailscaleRangeConflicts(); got != nil {
+		t.Errorf("nil State conflicts = %v; want nil", got)
+	}
+}
+
 // tests (*State).Equal
 func TestEqual(t *testing.T) {
 	pfxs := func(addrs ...string) (ret []netip.Prefix) {
@@ -1,6 +1,8 @@
 // Copyright (c) Tailscale Inc & AUTHORS
 // SPDX-License-Identifier: BSD-3-Clause
 
+//go:build darwin || netbsd || openbsd
+
 package netmon
 
 import (
@@ -24,25 +26,32 @@ type unspecifiedMessage struct{
 
 func (unspecifiedMessage) ignore() bool { return false }
 
+// newOSMon returns an osMon that reads the changes to interfaces, addresses
+// and routes the kernel reports on a routing socket (see route(4)).
 func newOSMon(logf logger.Logf, _ *Monitor) (osMon, error) {
 	fd, err := unix.Socket(unix.AF_ROUTE, unix.SOCK_RAW, 0)
 	if err != nil {
 		return nil, err
 	}
-	return &darwinRouteMon{
+	return &bsdRouteMon{
 		logf: logf,
 		fd:   fd,
 	}, nil
 }
 
-type darwinRouteMon struct {
+type bsdRouteMon struct {
 	logf      logger.Logf
 	fd        int // AF_ROUTE socket
 	buf       [2 << 10]byte
 	closeOnce sync.Once
 }
 
-func (m *darwinRouteMon) Close() error {
+// isUnparsedChange, if non-nil, reports whether the routing messages in b,
+// some of which golang.org/x/net/route can't parse, include a change to an
+// interface or its addresses.
+var isUnparsedChange func(b []byte) bool
+
+func (m *bsdRouteMon) Close() error {
 	var err error
 	m.closeOnce.Do(func() {
 		err = unix.Close(m.fd)
@@ -50,12 +59,18 @@ func (m *darwinRouteMon) Close() error {
 	return err
 }
 
-func (m *darwinRouteMon) Receive() (message, error) {
+func (m *bsdRouteMon) Receive() (message, error) {
 	for {
 		n, err := unix.Read(m.fd, m.buf[:])
 		if err != nil {
 			return nil, err
 		}
+		if isUnparsedChange != nil && isUnparsedChange(m.buf[:n]) {
+			if debugRouteMessages {
+				m.logf("read %d bytes with an unparsed address change (% 02x)", n, m.buf[:n])
+			}
+			return unspecifiedMessage{}, nil
+		}
 		msgs, err := func() (msgs []route.Message, err error) {
 			defer func() {
 				// #14201: permanent panic protection, as we have been burned by
@@ -100,7 +115,7 @@ func (m *darwinRouteMon) Receive() (message, error) {
 	}
 }
 
-func (m *darwinRouteMon) skipMessage(msg route.Message) bool {
+func (m *bsdRouteMon) skipMessage(msg route.Message) bool {
 	switch msg := msg.(type) {
 	case *route.InterfaceMulticastAddrMessage:
 		return true
@@ -124,7 +139,7 @@ func addrType(addrs []route.Addr, rtaxType int) route.Addr {
 	return nil
 }
 
-func (m *darwinRouteMon) IsInterestingInterface(iface string) bool {
+func (m *bsdRouteMon) IsInterestingInterface(iface string) bool {
 	baseName := strings.TrimRight(iface, "0123456789")
 	switch baseName {
 	// TODO(maisem): figure out what this list should actually be.
@@ -134,7 +149,7 @@ func (m *darwinRouteMon) IsInterestingInterface(iface string) bool {
 	return true
 }
 
-func (m *darwinRouteMon) skipInterfaceAddrMessage(msg *route.InterfaceAddrMessage) bool {
+func (m *bsdRouteMon) skipInterfaceAddrMessage(msg *route.InterfaceAddrMessage) bool {
 	if la, ok := addrType(msg.Addrs, unix.RTAX_IFP).(*route.LinkAddr); ok {
 		if !m.IsInterestingInterface(la.Name) {
 			return true
@@ -143,7 +158,7 @@ func (m *darwinRouteMon) skipInterfaceAddrMessage(msg *route.InterfaceAddrMessag
 	return false
 }
 
-func (m *darwinRouteMon) skipRouteMessage(msg *route.RouteMessage) bool {
+func (m *bsdRouteMon) skipRouteMessage(msg *route.RouteMessage) bool {
 	if ip := ipOfAddr(addrType(msg.Addrs, unix.RTAX_DST)); ip.IsLinkLocalUnicast() {
 		// Skip those like:
 		// dst = fe80::b476:66ff:fe30:c8f6%15
@@ -152,7 +167,7 @@ func (m *darwinRouteMon) skipRouteMessage(msg *route.RouteMessage) bool {
 	return false
 }
 
-func (m *darwinRouteMon) logMessages(msgs []route.Message) {
+func (m *bsdRouteMon) logMessages(msgs []route.Message) {
 	for i, msg := range msgs {
 		switch msg := msg.(type) {
 		default:
@@ -173,7 +188,7 @@ func (m *darwinRouteMon) logMessages(msgs []route.Message) {
 	}
 }
 
-func (m *darwinRouteMon) logAddrs(addrs []route.Addr) {
+func (m *bsdRouteMon) logAddrs(addrs []route.Addr) {
 	for i, a := range addrs {
 		if a == nil {
 			continue
@@ -45,8 +45,9 @@ func (c *devdConn) Receive() (message, error) {
 		if err != nil {
 			return nil, fmt.Errorf("reading devd socket: %v", err)
 		}
-		// Only return messages related to the network subsystem.
-		if !strings.Contains(msg, "system=IFNET") {
+		// Only return messages related to the network subsystem,
+		// including CARP state changes.
+		if !strings.Contains(msg, "system=IFNET") && !strings.Contains(msg, "system=CARP") {
 			continue
 		}
 		// TODO: this is where the devd-specific message would
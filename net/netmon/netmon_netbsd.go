@@ -0,0 +1,44 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netmon
+
+import (
+	"encoding/binary"
+
+	"golang.org/x/sys/unix"
+)
+
+// NetBSD 8 renumbered the routing socket messages for address changes,
+// keeping the old numbers for binaries built against older headers (see
+// RTM_ONEWADDR in NetBSD's sys/net/route.h). x/net/route only knows the old
+// numbers, so it skips the messages that the kernel now sends whenever an
+// address is added or removed, such as by dhcpcd(8).
+const (
+	rtmNewAddr = 0x16 // RTM_NEWADDR
+	rtmDelAddr = 0x17 // RTM_DELADDR
+	rtmChgAddr = 0x18 // RTM_CHGADDR
+)
+
+func init() {
+	isUnparsedChange = hasAddrMessage
+}
+
+// hasAddrMessage reports whether b, as read from a routing socket, holds
+// any message about an address change.
+func hasAddrMessage(b []byte) bool {
+	for len(b) >= 4 {
+		l := int(binary.NativeEndian.Uint16(b))
+		if l < 4 || l > len(b) {
+			return false
+		}
+		if b[2] == unix.RTM_VERSION {
+			switch b[3] {
+			case rtmNewAddr, rtmDelAddr, rtmChgAddr:
+				return true
+			}
+		}
+		b = b[l:]
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netmon
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// rtmsg returns a routing message header of the given type, padded to l
+// bytes.
+func rtmsg(typ byte, l int) []byte {
+	b := make([]byte, l)
+	binary.NativeEndian.PutUint16(b, uint16(l))
+	b[2] = unix.RTM_VERSION
+	b[3] = typ
+	return b
+}
+
+func TestHasAddrMessage(t *testing.T) {
+	cat := func(msgs ...[]byte) (b []byte) {
+		for _, m := range msgs {
+			b = append(b, m...)
+		}
+		return b
+	}
+	tests := []struct {
+		name string
+		b    []byte
+		want bool
+	}{
+		{"empty", nil, false},
+		{"newaddr", rtmsg(rtmNewAddr, 72), true},
+		{"deladdr", rtmsg(rtmDelAddr, 72), true},
+		{"chgaddr-after-route", cat(rtmsg(unix.RTM_ADD, 136), rtmsg(rtmChgAddr, 72)), true},
+		{"route-only", rtmsg(unix.RTM_ADD, 136), false},
+		{"old-newaddr", rtmsg(unix.RTM_NEWADDR, 72), false},
+		{"truncated", rtmsg(rtmNewAddr, 72)[:40], false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasAddrMessage(tt.b); got != tt.want {
+				t.Errorf("hasAddrMessage = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
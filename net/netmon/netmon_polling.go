@@ -1,7 +1,7 @@
 // Copyright (c) Tailscale Inc & AUTHORS
 // SPDX-License-Identifier: BSD-3-Clause
 
-//go:build (!linux && !freebsd && !windows && !darwin) || android
+//go:build (!linux && !freebsd && !windows && !darwin && !solaris && !netbsd && !openbsd) || android
 
 package netmon
 
@@ -0,0 +1,73 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netmon
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/sys/unix"
+	"tailscale.com/types/logger"
+)
+
+// unspecifiedMessage is a minimal message implementation that should not
+// be ignored. In general, OS-specific implementations should use better
+// types and avoid this if they can.
+type unspecifiedMessage struct{}
+
+func (unspecifiedMessage) ignore() bool { return false }
+
+// routeSockMon implements osMon using an illumos routing socket (see
+// route(4P)). golang.org/x/net/route doesn't support illumos, so only the
+// message type is decoded.
+type routeSockMon struct {
+	logf      logger.Logf
+	fd        int // AF_ROUTE socket
+	buf       [2 << 10]byte
+	closeOnce sync.Once
+}
+
+func newOSMon(logf logger.Logf, m *Monitor) (osMon, error) {
+	fd, err := unix.Socket(unix.AF_ROUTE, unix.SOCK_RAW, unix.AF_UNSPEC)
+	if err != nil {
+		logf("routing socket error: %v, falling back to polling method", err)
+		return newPollingMon(logf, m)
+	}
+	return &routeSockMon{
+		logf: logf,
+		fd:   fd,
+	}, nil
+}
+
+func (m *routeSockMon) IsInterestingInterface(iface string) bool { return true }
+
+func (m *routeSockMon) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		err = unix.Close(m.fd)
+	})
+	return err
+}
+
+func (m *routeSockMon) Receive() (message, error) {
+	for {
+		n, err := unix.Read(m.fd, m.buf[:])
+		if err != nil {
+			return nil, fmt.Errorf("reading routing socket: %w", err)
+		}
+		// struct rt_msghdr starts with a uint16 length, then uint8
+		// version and type.
+		if n < 4 || m.buf[2] != unix.RTM_VERSION {
+			continue
+		}
+		switch m.buf[3] {
+		case unix.RTM_ADD, unix.RTM_DELETE, unix.RTM_CHANGE,
+			unix.RTM_NEWADDR, unix.RTM_DELADDR, unix.RTM_IFINFO,
+			unix.RTM_CHGADDR, unix.RTM_FREEADDR:
+			return unspecifiedMessage{}, nil
+		}
+		// RTM_GET replies, RTM_MISS and the like don't change
+		// anything.
+	}
+}
@@ -4,6 +4,7 @@
 package netmon
 
 import (
+	"context"
 	"flag"
 	"net"
 	"net/netip"
@@ -63,6 +64,42 @@ func TestMonitorInjectEvent(t *testing.T) {
 	monitorDuration = flag.Duration("monitor-duration", 0, "if non-zero, how long to run TestMonitorMode. Zero means forever.")
 )
 
+func TestMonitorSubscribe(t *testing.T) {
+	mon, err := New(t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mon.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := mon.Subscribe(ctx)
+	mon.Start()
+	mon.InjectEvent()
+	select {
+	case delta := <-ch:
+		if delta.New == nil {
+			t.Error("delta has no new state")
+		}
+		if ev := delta.Event(); ev.Initial {
+			t.Error("event of change is initial")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for change")
+	}
+
+	cancel()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return // Pass.
+			}
+		case <-timeout:
+			t.Fatal("timeout waiting for channel to close")
+		}
+	}
+}
+
 func TestMonitorMode(t *testing.T) {
 	switch *monitor {
 	case "":
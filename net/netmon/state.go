@@ -396,6 +396,31 @@ func (s *State) HasIP(ip netip.Addr) bool {
 	return false
 }
 
+// TailscaleRangeConflicts returns the addresses of the non-Tailscale
+// interfaces that are up and overlap Tailscale's CGNAT or IPv6 ULA ranges,
+// keyed by interface name. Traffic to such addresses may be routed into the
+// tailnet instead of onto the local network.
+//
+// The ChromeOS VM range is not a conflict: Tailscale doesn't use it.
+func (s *State) TailscaleRangeConflicts() map[string][]netip.Prefix {
+	if s == nil {
+		return nil
+	}
+	cgnat, ula, chromeOSVM := tsaddr.CGNATRange(), tsaddr.TailscaleULARange(), tsaddr.ChromeOSVMRange()
+	var ret map[string][]netip.Prefix
+	for name, pfxs := range s.InterfaceIPs {
+		if i := s.Interface[name]; i.Interface == nil || !i.IsUp() || isTailscaleInterface(name, pfxs) {
+			continue
+		}
+		for _, pfx := range pfxs {
+			if (cgnat.Overlaps(pfx) && !chromeOSVM.Contains(pfx.Addr())) || ula.Overlaps(pfx) {
+				mak.Set(&ret, name, append(ret[name], pfx))
+			}
+		}
+	}
+	return ret
+}
+
 func (a Interface) Equal(b Interface) bool {
 	if (a.Interface == nil) != (b.Interface == nil) {
 		return false
@@ -0,0 +1,127 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netmon
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"time"
+
+	"tailscale.com/util/clientmetric"
+)
+
+// subscribeBuffer is how many changes a channel returned by
+// [Monitor.Subscribe] buffers before dropping changes.
+const subscribeBuffer = 16
+
+var metricSubscriberDropped = clientmetric.NewCounter("netmon_subscriber_dropped")
+
+// Subscribe returns a channel on which the changes passed to callbacks
+// registered with [Monitor.RegisterChangeCallback] are sent, until ctx is
+// done, when the channel is closed.
+//
+// Changes are dropped rather than blocking the monitor if the receiver falls
+// behind by more than a few, so receivers should treat each change as a
+// prompt to look at the current state (in its New field) rather than rely on
+// seeing every transition.
+func (m *Monitor) Subscribe(ctx context.Context) <-chan *ChangeDelta {
+	ch := make(chan *ChangeDelta, subscribeBuffer)
+	var (
+		mu     sync.Mutex
+		closed bool
+	)
+	unregister := m.RegisterChangeCallback(func(delta *ChangeDelta) {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case ch <- delta:
+		default:
+			metricSubscriberDropped.Add(1)
+		}
+	})
+	context.AfterFunc(ctx, func() {
+		unregister()
+		mu.Lock()
+		defer mu.Unlock()
+		closed = true
+		close(ch)
+	})
+	return ch
+}
+
+// DefaultRouteChanged reports whether the interface of the default route
+// changed.
+func (d *ChangeDelta) DefaultRouteChanged() bool {
+	if d.Old == nil {
+		return d.New.DefaultRouteInterface != ""
+	}
+	return d.Old.DefaultRouteInterface != d.New.DefaultRouteInterface
+}
+
+// ChangeEvent is a summary of a network change, or of the network state, in
+// a form suitable for sending to other processes, as by the LocalAPI.
+type ChangeEvent struct {
+	// Time is when the event was created.
+	Time time.Time
+
+	// Initial is whether this event isn't a change but describes the
+	// network state at the time of subscribing.
+	Initial bool `json:",omitempty"`
+
+	// Major and TimeJumped are as in [ChangeDelta].
+	Major      bool `json:",omitempty"`
+	TimeJumped bool `json:",omitempty"`
+
+	// DefaultRouteInterface is the interface of the default route, if
+	// known, and DefaultRouteChanged whether it changed.
+	DefaultRouteInterface string `json:",omitempty"`
+	DefaultRouteChanged   bool   `json:",omitempty"`
+
+	// InterfaceIPs maps the names of the interfaces that are up to their
+	// addresses.
+	InterfaceIPs map[string][]netip.Prefix `json:",omitempty"`
+
+	// HaveV4 and HaveV6 are as in [State].
+	HaveV4 bool `json:",omitempty"`
+	HaveV6 bool `json:",omitempty"`
+}
+
+// Event returns the ChangeEvent summarizing d.
+func (d *ChangeDelta) Event() ChangeEvent {
+	ev := StateEvent(d.New)
+	ev.Initial = false
+	ev.Major = d.Major
+	ev.TimeJumped = d.TimeJumped
+	ev.DefaultRouteChanged = d.DefaultRouteChanged()
+	return ev
+}
+
+// StateEvent returns the initial ChangeEvent describing st, as sent to
+// subscribers before any changes.
+func StateEvent(st *State) ChangeEvent {
+	ev := ChangeEvent{
+		Time:    time.Now(),
+		Initial: true,
+	}
+	if st == nil {
+		return ev
+	}
+	ev.DefaultRouteInterface = st.DefaultRouteInterface
+	ev.HaveV4 = st.HaveV4
+	ev.HaveV6 = st.HaveV6
+	for name, iface := range st.Interface {
+		if iface.Interface == nil || !iface.IsUp() {
+			continue
+		}
+		if ev.InterfaceIPs == nil {
+			ev.InterfaceIPs = make(map[string][]netip.Prefix)
+		}
+		ev.InterfaceIPs[name] = st.InterfaceIPs[name]
+	}
+	return ev
+}
@@ -53,6 +53,25 @@ func SetDisableBindConnToInterface(v bool) {
 	disableBindConnToInterface.Store(v)
 }
 
+var bypassRoutingTable atomic.Int32
+
+// SetBypassRoutingTable sets the routing table that Tailscale's own sockets
+// use, such as magicsock's and those to control and DERP, so that their
+// traffic escapes Tailscale's routes, such as an exit node's default route,
+// with the router keeping the system's routes in it. Zero, the default,
+// means the routing table the process runs in.
+//
+// It's a FIB (see setfib(2)) on FreeBSD and an rtable(4) on OpenBSD, and it
+// has no effect elsewhere.
+func SetBypassRoutingTable(n int) {
+	bypassRoutingTable.Store(int32(n))
+}
+
+// BypassRoutingTable returns the routing table set by SetBypassRoutingTable.
+func BypassRoutingTable() int {
+	return int(bypassRoutingTable.Load())
+}
+
 // Listener returns a new net.Listener with its Control hook func
 // initialized as necessary to run in logical network namespace that
 // doesn't route back into Tailscale.
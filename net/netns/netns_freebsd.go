@@ -0,0 +1,9 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netns
+
+import "golang.org/x/sys/unix"
+
+// rtableSockopt is the socket option selecting a socket's FIB.
+const rtableSockopt = unix.SO_SETFIB
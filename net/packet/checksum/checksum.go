@@ -70,6 +70,53 @@ func UpdateDstAddr(q *packet.Parsed, dst netip.Addr) {
 	}
 }
 
+// ClampTCPMSS lowers the maximum segment size option of q, a TCP SYN or
+// SYN-ACK packet, to mss if it's larger, and updates the TCP checksum. It
+// reports whether q was modified.
+func ClampTCPMSS(q *packet.Parsed, mss uint16) bool {
+	if q.IPProto != ipproto.TCP || q.TCPFlags&packet.TCPSyn == 0 {
+		return false
+	}
+	tr := q.Transport()
+	if len(tr) < header.TCPMinimumSize {
+		return false
+	}
+	hdrLen := int(tr[12]>>4) * 4
+	if hdrLen < header.TCPMinimumSize || hdrLen > len(tr) {
+		return false
+	}
+	for i := header.TCPMinimumSize; i < hdrLen; {
+		switch tr[i] {
+		case 0: // end of option list
+			return false
+		case 1: // no-operation
+			i++
+			continue
+		}
+		if i+1 >= hdrLen || tr[i+1] < 2 || i+int(tr[i+1]) > hdrLen {
+			// Malformed options.
+			return false
+		}
+		if tr[i] != 2 || tr[i+1] != 4 { // not MSS
+			i += int(tr[i+1])
+			continue
+		}
+		if binary.BigEndian.Uint16(tr[i+2:i+4]) <= mss {
+			return false
+		}
+		// The checksum is over 16-bit words, so update it with the
+		// word-aligned bytes spanning the MSS value, which follows
+		// an odd number of NOPs in some packets.
+		start, end := (i+2)&^1, (i+5)&^1
+		var old [4]byte
+		n := copy(old[:], tr[start:end])
+		binary.BigEndian.PutUint16(tr[i+2:i+4], mss)
+		updateV4Checksum(tr[16:18], old[:n], tr[start:end])
+		return true
+	}
+	return false
+}
+
 // updateV4PacketChecksums updates the checksums in the packet buffer.
 // Currently (2023-03-01) only TCP/UDP/ICMP over IPv4 is supported.
 // p is modified in place.
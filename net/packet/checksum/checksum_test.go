@@ -4,6 +4,7 @@
 package checksum
 
 import (
+	"bytes"
 	"encoding/binary"
 	"math/rand/v2"
 	"net/netip"
@@ -221,3 +222,134 @@ func TestNatChecksumsV6TCP(t *testing.T) {
 		t.Fatal("incorrect checksum after updating destination address")
 	}
 }
+
+// tcp4WithOptions returns an IPv4 TCP packet with the given flags and TCP
+// options, and a valid TCP checksum.
+func tcp4WithOptions(flags packet.TCPFlag, opts ...byte) []byte {
+	hdrLen := header.TCPMinimumSize + len(opts)
+	b := make([]byte, 20+hdrLen)
+	b[0] = 0x45
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	b[8] = 64
+	b[9] = 6 // TCP
+	copy(b[12:16], []byte{100, 64, 0, 1})
+	copy(b[16:20], []byte{100, 64, 0, 2})
+	tcp := b[20:]
+	binary.BigEndian.PutUint16(tcp[0:2], 1234)
+	binary.BigEndian.PutUint16(tcp[2:4], 443)
+	tcp[12] = byte(hdrLen/4) << 4
+	tcp[13] = byte(flags)
+	copy(tcp[header.TCPMinimumSize:], opts)
+	binary.BigEndian.PutUint16(tcp[16:18], tcpChecksumV4(b))
+	return b
+}
+
+// tcpChecksumV4 returns the TCP checksum of the IPv4 packet b, computed
+// as if its checksum field were zero.
+func tcpChecksumV4(b []byte) uint16 {
+	tcp := b[20:]
+	s := uint32(6 + len(tcp))
+	for i := 12; i < 20; i += 2 {
+		s += uint32(binary.BigEndian.Uint16(b[i : i+2]))
+	}
+	for i := 0; i < len(tcp); i += 2 {
+		if i == 16 {
+			continue
+		}
+		w := uint32(tcp[i]) << 8
+		if i+1 < len(tcp) {
+			w |= uint32(tcp[i+1])
+		}
+		s += w
+	}
+	for s>>16 > 0 {
+		s = s&0xFFFF + s>>16
+	}
+	return ^uint16(s)
+}
+
+func TestClampTCPMSS(t *testing.T) {
+	tests := []struct {
+		name    string
+		pkt     []byte
+		mss     uint16
+		want    bool
+		wantMSS uint16 // at wantOfs within the TCP options, if want
+		wantOfs int
+	}{
+		{
+			name:    "syn",
+			pkt:     tcp4WithOptions(packet.TCPSyn, 2, 4, 0x23, 0x28, 1, 1, 1, 0), // MSS 9000
+			mss:     1200,
+			want:    true,
+			wantMSS: 1200,
+		},
+		{
+			name:    "syn-ack",
+			pkt:     tcp4WithOptions(packet.TCPSynAck, 2, 4, 0x05, 0xb4, 1, 1, 1, 0), // MSS 1460
+			mss:     1380,
+			want:    true,
+			wantMSS: 1380,
+		},
+		{
+			name:    "unaligned",
+			pkt:     tcp4WithOptions(packet.TCPSyn, 1, 2, 4, 0x23, 0x28, 1, 1, 0), // NOP, MSS 9000
+			mss:     1200,
+			want:    true,
+			wantMSS: 1200,
+			wantOfs: 1,
+		},
+		{
+			name:    "after-other-options",
+			pkt:     tcp4WithOptions(packet.TCPSyn, 4, 2, 3, 3, 7, 2, 4, 0x23, 0x28, 0, 0, 0), // SACK-permitted, window scale, MSS 9000
+			mss:     1200,
+			want:    true,
+			wantMSS: 1200,
+			wantOfs: 5,
+		},
+		{
+			name: "already-smaller",
+			pkt:  tcp4WithOptions(packet.TCPSyn, 2, 4, 0x04, 0xb0, 1, 1, 1, 0), // MSS 1200
+			mss:  1380,
+		},
+		{
+			name: "no-mss",
+			pkt:  tcp4WithOptions(packet.TCPSyn, 4, 2, 1, 0),
+			mss:  1200,
+		},
+		{
+			name: "not-syn",
+			pkt:  tcp4WithOptions(packet.TCPAck, 2, 4, 0x23, 0x28, 1, 1, 1, 0),
+			mss:  1200,
+		},
+		{
+			name: "truncated-option",
+			pkt:  tcp4WithOptions(packet.TCPSyn, 1, 1, 1, 2),
+			mss:  1200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orig := append([]byte(nil), tt.pkt...)
+			var p packet.Parsed
+			p.Decode(tt.pkt)
+			if got := ClampTCPMSS(&p, tt.mss); got != tt.want {
+				t.Fatalf("ClampTCPMSS = %v; want %v", got, tt.want)
+			}
+			tcp := tt.pkt[20:]
+			if got, want := binary.BigEndian.Uint16(tcp[16:18]), tcpChecksumV4(tt.pkt); got != want {
+				t.Errorf("TCP checksum = %#04x; want %#04x", got, want)
+			}
+			if !tt.want {
+				if !bytes.Equal(tt.pkt, orig) {
+					t.Errorf("packet modified:\n got %x\nwant %x", tt.pkt, orig)
+				}
+				return
+			}
+			i := header.TCPMinimumSize + tt.wantOfs + 2
+			if got := binary.BigEndian.Uint16(tcp[i : i+2]); got != tt.wantMSS {
+				t.Errorf("MSS = %d; want %d", got, tt.wantMSS)
+			}
+		})
+	}
+}
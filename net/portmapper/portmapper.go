@@ -121,6 +121,12 @@ type Client struct {
 
 	localPort uint16
 
+	prefs Preferences // see SetPreferences
+
+	// staleMappings are mappings replaced by SetPreferences, to be
+	// released before the next mapping is made.
+	staleMappings []mapping
+
 	mapping mapping // non-nil if we have a mapping
 }
 
@@ -255,6 +261,10 @@ func (c *Client) Close() error {
 		return nil
 	}
 	c.closed = true
+	for _, m := range c.staleMappings {
+		m.Release(context.Background())
+	}
+	c.staleMappings = nil
 	c.invalidateMappingsLocked(true)
 	// TODO: close some future ever-listening UDP socket(s),
 	// waiting for multicast announcements from router.
@@ -497,18 +507,36 @@ func (c *Client) createOrGetMapping(ctx context.Context) (external netip.AddrPor
 		return netip.AddrPort{}, NoMappingError{ErrGatewayIPv6}
 	}
 
+	// Release any mappings made with previous preferences first, so
+	// they can't clash with the new one.
+	c.mu.Lock()
+	stale := c.staleMappings
+	c.staleMappings = nil
+	c.mu.Unlock()
+	for _, m := range stale {
+		m.Release(ctx)
+	}
+
 	now := time.Now()
 
 	// Log what kind of portmap we obtained
 	reusedExisting := false
+	renewingType := "" // type of the mapping being renewed, if any
 	defer func() {
 		if err != nil {
+			if renewingType != "" {
+				metricRenewFailed(renewingType).Add(1)
+			}
 			return
 		}
 
 		c.mu.Lock()
 		defer c.mu.Unlock()
 
+		if c.mapping != nil && !reusedExisting {
+			metricMappingOK(c.mapping.MappingType()).Add(1)
+		}
+
 		portmapType := "none"
 		if c.mapping != nil {
 			portmapType = c.mapping.MappingType()
@@ -538,6 +566,14 @@ func (c *Client) createOrGetMapping(ctx context.Context) (external netip.AddrPor
 	c.mu.Lock()
 	localPort := c.localPort
 	internalAddr := netip.AddrPortFrom(myIP, localPort)
+	prefs := c.prefs
+	disablePMP := c.debug.DisablePMP || !prefs.allows("pmp")
+	disablePCP := c.debug.DisablePCP || !prefs.allows("pcp")
+	disableUPnP := c.debug.DisableUPnP || !prefs.allows("upnp")
+	if disablePMP && disablePCP && disableUPnP {
+		c.mu.Unlock()
+		return netip.AddrPort{}, NoMappingError{ErrNoPortMappingServices}
+	}
 
 	// prevPort is the port we had most previously, if any. We try
 	// to ask for the same port. 0 means to give us any port.
@@ -552,9 +588,11 @@ func (c *Client) createOrGetMapping(ctx context.Context) (external netip.AddrPor
 		}
 		// The mapping might still be valid, so just try to renew it.
 		prevPort = m.External().Port()
+		renewingType = m.MappingType()
 	}
+	prevPort = prefs.requestPort(prevPort)
 
-	if c.debug.DisablePCP && c.debug.DisablePMP {
+	if disablePCP && disablePMP {
 		c.mu.Unlock()
 		if external, ok := c.getUPnPPortMapping(ctx, gw, internalAddr, prevPort); ok {
 			return external, nil
@@ -568,6 +606,7 @@ func (c *Client) createOrGetMapping(ctx context.Context) (external netip.AddrPor
 	// again. Cuts down latency for most clients.
 	haveRecentPMP := c.sawPMPRecentlyLocked()
 	haveRecentPCP := c.sawPCPRecentlyLocked()
+	haveRecentUPnP := c.uPnPSawTime.After(now.Add(-trustServiceStillAvailableDuration))
 
 	// Since PMP mapping may require multiple calls, and it's not clear from the outset
 	// whether we're doing a PCP or PMP call, initialize the PMP mapping here,
@@ -585,6 +624,9 @@ func (c *Client) createOrGetMapping(ctx context.Context) (external netip.AddrPor
 	}
 	if c.lastProbe.After(now.Add(-5*time.Second)) && !haveRecentPMP && !haveRecentPCP {
 		c.mu.Unlock()
+		if disableUPnP {
+			return netip.AddrPort{}, NoMappingError{ErrNoPortMappingServices}
+		}
 		// fallback to UPnP portmapping
 		if external, ok := c.getUPnPPortMapping(ctx, gw, internalAddr, prevPort); ok {
 			return external, nil
@@ -594,6 +636,15 @@ func (c *Client) createOrGetMapping(ctx context.Context) (external netip.AddrPor
 	}
 	c.mu.Unlock()
 
+	// Try UPnP first if it's preferred and was seen recently, falling
+	// back to PCP or PMP.
+	if !disableUPnP && haveRecentUPnP && prefs.prefers("upnp", "pmp") && prefs.prefers("upnp", "pcp") {
+		if external, ok := c.getUPnPPortMapping(ctx, gw, internalAddr, prevPort); ok {
+			return external, nil
+		}
+		c.vlogf("preferred UPnP failed; trying PCP and PMP")
+	}
+
 	uc, err := c.listenPacket(ctx, "udp4", ":0")
 	if err != nil {
 		return netip.AddrPort{}, err
@@ -605,13 +656,13 @@ func (c *Client) createOrGetMapping(ctx context.Context) (external netip.AddrPor
 
 	pxpAddr := netip.AddrPortFrom(gw, c.pxpPort())
 
-	preferPCP := !c.debug.DisablePCP && (c.debug.DisablePMP || (!haveRecentPMP && haveRecentPCP))
+	preferPCP := !disablePCP && (disablePMP || (haveRecentPCP && (!haveRecentPMP || prefs.prefers("pcp", "pmp"))))
 
 	// Create a mapping, defaulting to PMP unless only PCP was seen recently.
 	if preferPCP {
 		// TODO replace wildcardIP here with previous external if known.
 		// Only do PCP mapping in the case when PMP did not appear to be available recently.
-		pkt := buildPCPRequestMappingPacket(myIP, localPort, prevPort, pcpMapLifetimeSec, wildcardIP)
+		pkt := buildPCPRequestMappingPacket(myIP, localPort, prevPort, prefs.lifetimeSec(pcpMapLifetimeSec), wildcardIP)
 		if _, err := uc.WriteToUDPAddrPort(pkt, pxpAddr); err != nil {
 			if neterror.TreatAsLostUDP(err) {
 				err = NoMappingError{ErrNoPortMappingServices}
@@ -629,7 +680,7 @@ func (c *Client) createOrGetMapping(ctx context.Context) (external netip.AddrPor
 			}
 		}
 
-		pkt := buildPMPRequestMappingPacket(localPort, prevPort, pmpMapLifetimeSec)
+		pkt := buildPMPRequestMappingPacket(localPort, prevPort, prefs.lifetimeSec(pmpMapLifetimeSec))
 		if _, err := uc.WriteToUDPAddrPort(pkt, pxpAddr); err != nil {
 			if neterror.TreatAsLostUDP(err) {
 				err = NoMappingError{ErrNoPortMappingServices}
@@ -675,7 +726,7 @@ func (c *Client) createOrGetMapping(ctx context.Context) (external netip.AddrPor
 					d := time.Duration(pres.MappingValidSeconds) * time.Second
 					now := time.Now()
 					m.goodUntil = now.Add(d)
-					m.renewAfter = now.Add(d / 2) // renew in half the time
+					m.renewAfter = prefs.renewAfter(now, m.goodUntil)
 					m.epoch = pres.SecondsSinceEpoch
 				}
 			case pcpVersion:
@@ -686,6 +737,7 @@ func (c *Client) createOrGetMapping(ctx context.Context) (external netip.AddrPor
 					return netip.AddrPort{}, NoMappingError{ErrNoPortMappingServices}
 				}
 				pcpMapping.c = c
+				pcpMapping.renewAfter = prefs.renewAfter(time.Now(), pcpMapping.goodUntil)
 				pcpMapping.internal = m.internal
 				pcpMapping.gw = netip.AddrPortFrom(gw, c.pxpPort())
 				c.mu.Lock()
@@ -1267,6 +1319,55 @@ func (c *Client) maybeInvalidatePCPMappingLocked(epoch uint32) {
 	metricUPnPUpdatedMeta = clientmetric.NewCounter("portmap_upnp_updated_meta")
 )
 
+// Mapping metrics, by protocol
+var (
+	// metricPMPMappingOK, metricPCPMappingOK and metricUPnPMappingOK
+	// count the number of times we created or renewed a mapping with
+	// each protocol.
+	metricPMPMappingOK  = clientmetric.NewCounter("portmap_pmp_mapping_ok")
+	metricPCPMappingOK  = clientmetric.NewCounter("portmap_pcp_mapping_ok")
+	metricUPnPMappingOK = clientmetric.NewCounter("portmap_upnp_mapping_ok")
+
+	// metricPMPRenewFailed, metricPCPRenewFailed and metricUPnPRenewFailed
+	// count the number of times we failed to renew a mapping made with
+	// each protocol, whether or not another protocol then succeeded.
+	metricPMPRenewFailed  = clientmetric.NewCounter("portmap_pmp_renew_failed")
+	metricPCPRenewFailed  = clientmetric.NewCounter("portmap_pcp_renew_failed")
+	metricUPnPRenewFailed = clientmetric.NewCounter("portmap_upnp_renew_failed")
+
+	// metricUnknownMappingType counts mapping events for a mapping type
+	// not listed above, which shouldn't happen.
+	metricUnknownMappingType = clientmetric.NewCounter("portmap_unknown_mapping_type")
+)
+
+// metricMappingOK returns the metric counting mappings obtained with the
+// protocol named by a mapping's MappingType.
+func metricMappingOK(mappingType string) *clientmetric.Metric {
+	switch mappingType {
+	case "pmp":
+		return metricPMPMappingOK
+	case "pcp":
+		return metricPCPMappingOK
+	case "upnp":
+		return metricUPnPMappingOK
+	}
+	return metricUnknownMappingType
+}
+
+// metricRenewFailed returns the metric counting failed renewals of mappings
+// obtained with the protocol named by a mapping's MappingType.
+func metricRenewFailed(mappingType string) *clientmetric.Metric {
+	switch mappingType {
+	case "pmp":
+		return metricPMPRenewFailed
+	case "pcp":
+		return metricPCPRenewFailed
+	case "upnp":
+		return metricUPnPRenewFailed
+	}
+	return metricUnknownMappingType
+}
+
 // UPnP error metric that's keyed by code; lazily registered on first read
 var (
 	metricUPnPErrorsByCode syncs.Map[int, *clientmetric.Metric]
@@ -126,6 +126,87 @@ func TestPCPIntegration(t *testing.T) {
 	}
 }
 
+func TestMappingMetrics(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{PMP: false, PCP: true, UPnP: false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	c := newTestClient(t, igd)
+	defer c.Close()
+	if _, err := c.Probe(context.Background()); err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+
+	okBefore := metricPCPMappingOK.Value()
+	if _, err := c.createOrGetMapping(context.Background()); err != nil {
+		t.Fatalf("failed to get mapping: %v", err)
+	}
+	if _, err := c.createOrGetMapping(context.Background()); err != nil {
+		t.Fatalf("failed to get existing mapping: %v", err)
+	}
+	if got := metricPCPMappingOK.Value() - okBefore; got != 1 {
+		t.Errorf("portmap_pcp_mapping_ok increased by %d, want 1", got)
+	}
+
+	// Make the mapping due for renewal, with the gateway gone.
+	igd.Close()
+	c.mu.Lock()
+	c.mapping.(*pcpMapping).renewAfter = time.Now().Add(-time.Second)
+	c.mu.Unlock()
+	failedBefore := metricPCPRenewFailed.Value()
+	if _, err := c.createOrGetMapping(context.Background()); err == nil {
+		t.Fatal("renewal unexpectedly succeeded")
+	}
+	if got := metricPCPRenewFailed.Value() - failedBefore; got != 1 {
+		t.Errorf("portmap_pcp_renew_failed increased by %d, want 1", got)
+	}
+}
+
+func TestPreferences(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{PMP: true, PCP: true, UPnP: false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	c := newTestClient(t, igd)
+	defer c.Close()
+
+	// The test IGD doesn't do PMP mappings, so with only PMP and UPnP
+	// allowed, no mapping can be made, and PCP mustn't be tried.
+	c.SetPreferences(Preferences{Protocols: []string{"pmp", "upnp"}})
+	if _, err := c.Probe(context.Background()); err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	pcpMaps := igd.stats().numPCPMapRecv
+	if _, err := c.createOrGetMapping(context.Background()); err == nil {
+		t.Error("unexpectedly got a mapping")
+	}
+	if got := igd.stats().numPCPMapRecv; got != pcpMaps {
+		t.Errorf("got %d PCP map requests with PCP disallowed", got-pcpMaps)
+	}
+
+	const margin = time.Minute
+	c.SetPreferences(Preferences{Protocols: []string{"pcp"}, RenewMargin: margin})
+	if _, err := c.Probe(context.Background()); err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	if _, err := c.createOrGetMapping(context.Background()); err != nil {
+		t.Fatalf("failed to get mapping: %v", err)
+	}
+	c.mu.Lock()
+	m := c.mapping
+	c.mu.Unlock()
+	if got := m.MappingType(); got != "pcp" {
+		t.Errorf("mapping type = %q, want pcp", got)
+	}
+	if got := m.GoodUntil().Sub(m.RenewAfter()); got != margin {
+		t.Errorf("renewing %v before expiry, want %v", got, margin)
+	}
+}
+
 // Test to ensure that metric names generated by this function do not contain
 // invalid characters.
 //
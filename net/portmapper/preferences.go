@@ -0,0 +1,102 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"slices"
+	"time"
+)
+
+// Preferences are user preferences for how a Client maps ports, to work
+// around gateways that misbehave with particular protocols or lease
+// lifetimes. The zero value uses the defaults.
+type Preferences struct {
+	// Protocols, if non-empty, are the protocols to use, from "pmp", "pcp"
+	// and "upnp", most preferred first. Protocols not listed aren't used.
+	Protocols []string
+
+	// Lifetime, if non-zero, is the lease lifetime to request for
+	// mappings, instead of two hours.
+	Lifetime time.Duration
+
+	// RenewMargin, if non-zero, is how long before a mapping expires to
+	// renew it, instead of halfway through its lifetime. It's ignored for
+	// mappings granted with a shorter lifetime.
+	RenewMargin time.Duration
+
+	// ExternalPort, if non-zero, is the external port to request, instead
+	// of the port of the previous mapping, if any.
+	ExternalPort uint16
+}
+
+// SetPreferences sets c's port mapping preferences. If they changed, any
+// existing mapping is dropped, to be released before the next one is made
+// per p. It doesn't block on the network.
+func (c *Client) SetPreferences(p Preferences) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.prefs.equal(p) {
+		return
+	}
+	p.Protocols = slices.Clone(p.Protocols)
+	c.prefs = p
+	if c.mapping != nil {
+		c.staleMappings = append(c.staleMappings, c.mapping)
+	}
+	c.invalidateMappingsLocked(false)
+}
+
+// preferences returns c's port mapping preferences.
+func (c *Client) preferences() Preferences {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.prefs
+}
+
+func (p Preferences) equal(p2 Preferences) bool {
+	return slices.Equal(p.Protocols, p2.Protocols) &&
+		p.Lifetime == p2.Lifetime &&
+		p.RenewMargin == p2.RenewMargin &&
+		p.ExternalPort == p2.ExternalPort
+}
+
+// allows reports whether p allows mapping with the named protocol.
+func (p Preferences) allows(proto string) bool {
+	return len(p.Protocols) == 0 || slices.Contains(p.Protocols, proto)
+}
+
+// prefers reports whether p lists protocol a before protocol b, or lists a
+// but not b.
+func (p Preferences) prefers(a, b string) bool {
+	ia, ib := slices.Index(p.Protocols, a), slices.Index(p.Protocols, b)
+	return ia >= 0 && (ib < 0 || ia < ib)
+}
+
+// lifetimeSec returns the lease lifetime to request, in seconds, given the
+// protocol's default.
+func (p Preferences) lifetimeSec(def uint32) uint32 {
+	if sec := p.Lifetime / time.Second; sec > 0 {
+		return uint32(sec)
+	}
+	return def
+}
+
+// requestPort returns the external port to request, given the port of the
+// previous mapping, or 0 if none.
+func (p Preferences) requestPort(prevPort uint16) uint16 {
+	if p.ExternalPort != 0 {
+		return p.ExternalPort
+	}
+	return prevPort
+}
+
+// renewAfter returns when to renew a mapping obtained at now that's good
+// until goodUntil.
+func (p Preferences) renewAfter(now, goodUntil time.Time) time.Time {
+	lifetime := goodUntil.Sub(now)
+	if p.RenewMargin > 0 && p.RenewMargin < lifetime {
+		return goodUntil.Add(-p.RenewMargin)
+	}
+	return now.Add(lifetime / 2)
+}
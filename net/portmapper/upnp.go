@@ -452,6 +452,10 @@ func (c *Client) getUPnPPortMapping(
 	// Start by grabbing the list of metas, any existing mapping, and
 	// creating a HTTP client for use.
 	c.mu.Lock()
+	if !c.prefs.allows("upnp") {
+		c.mu.Unlock()
+		return netip.AddrPort{}, false
+	}
 	oldMapping, ok := c.mapping.(*upnpMapping)
 	metas := c.uPnPMetas
 	ctx = goupnp.WithHTTPClient(ctx, c.upnpHTTPClientLocked())
@@ -529,9 +533,9 @@ type step struct {
 		// NOTE: this time might not technically be accurate if we created a
 		// permanent lease above, but we should still re-check the presence of
 		// the lease on a regular basis so we use it anyway.
-		d := time.Duration(pmpMapLifetimeSec) * time.Second
-		upnp.goodUntil = now.Add(d)
-		upnp.renewAfter = now.Add(d / 2)
+		prefs := c.preferences()
+		upnp.goodUntil = now.Add(time.Duration(prefs.lifetimeSec(pmpMapLifetimeSec)) * time.Second)
+		upnp.renewAfter = prefs.renewAfter(now, upnp.goodUntil)
 		upnp.external = externalAddrPort
 		upnp.rootDev = rootDev
 		upnp.loc = loc
@@ -595,7 +599,7 @@ func (c *Client) tryUPnPPortmapWithDevice(
 		prevPort,
 		internal.Port(),
 		internal.Addr().String(),
-		pmpMapLifetimeSec*time.Second,
+		time.Duration(c.preferences().lifetimeSec(pmpMapLifetimeSec))*time.Second,
 	)
 	c.vlogf("addAnyPortMapping: %v, err=%q", newPort, err)
 
@@ -21,6 +21,7 @@
 	"io"
 	"log"
 	"net"
+	"net/netip"
 	"strconv"
 	"time"
 
@@ -266,9 +267,7 @@ func (c *Conn) handleUDP() error {
 	// to the association.
 	// @see Page 6, https://datatracker.ietf.org/doc/html/rfc1928.
 	//
-	// We do NOT limit the access from the client currently in this implementation.
-	_ = c.request.destination
-
+	// We do, in udpClientAllowed.
 	addr := c.clientConn.LocalAddr()
 	host, _, err := net.SplitHostPort(addr.String())
 	if err != nil {
@@ -402,6 +401,10 @@ func (c *Conn) handleUDPRequest(
 	if err != nil {
 		return fmt.Errorf("read from client: %w", err)
 	}
+	if !c.udpClientAllowed(addr) {
+		// Drop it silently, like a datagram to a closed port.
+		return nil
+	}
 	c.udpClientAddr = addr
 	req, data, err := parseUDPRequest(buf[:n])
 	if err != nil {
@@ -452,6 +455,31 @@ func (c *Conn) handleUDPResponse(
 	return nil
 }
 
+// udpClientAllowed reports whether a datagram from addr may use the UDP
+// association. It must be from the host of the TCP connection that
+// requested the association and, if the request named them, from the
+// address and port the client said it would send from.
+func (c *Conn) udpClientAllowed(addr net.Addr) bool {
+	ua, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+	from := ua.AddrPort()
+	if ta, ok := c.clientConn.RemoteAddr().(*net.TCPAddr); ok && ta.AddrPort().Addr().Unmap() != from.Addr().Unmap() {
+		return false
+	}
+	dst := c.request.destination
+	if dst.port != 0 && dst.port != from.Port() {
+		return false
+	}
+	if dst.addrType != domainName {
+		if ip, err := netip.ParseAddr(dst.addr); err == nil && !ip.IsUnspecified() && ip.Unmap() != from.Addr().Unmap() {
+			return false
+		}
+	}
+	return true
+}
+
 func isTimeout(err error) bool {
 	terr, ok := errors.Unwrap(err).(interface{ Timeout() bool })
 	return ok && terr.Timeout()
@@ -9,6 +9,7 @@
 	"fmt"
 	"io"
 	"net"
+	"net/netip"
 	"testing"
 
 	"golang.org/x/net/proxy"
@@ -287,3 +288,48 @@ func TestUDP(t *testing.T) {
 		}
 	}
 }
+
+func TestUDPClientAllowed(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	serverSide, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer serverSide.Close()
+
+	tests := []struct {
+		name string
+		dst  socksAddr
+		from string
+		want bool
+	}{
+		{"any", zeroSocksAddr, "127.0.0.1:1234", true},
+		{"other-host", zeroSocksAddr, "127.0.0.2:1234", false},
+		{"port", socksAddr{addrType: ipv4, addr: "0.0.0.0", port: 1234}, "127.0.0.1:1234", true},
+		{"wrong-port", socksAddr{addrType: ipv4, addr: "0.0.0.0", port: 1234}, "127.0.0.1:1235", false},
+		{"addr", socksAddr{addrType: ipv4, addr: "127.0.0.1", port: 1234}, "127.0.0.1:1234", true},
+		{"wrong-addr", socksAddr{addrType: ipv4, addr: "127.0.0.2", port: 1234}, "127.0.0.1:1234", false},
+		{"domain", socksAddr{addrType: domainName, addr: "localhost", port: 1234}, "127.0.0.1:1234", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Conn{
+				clientConn: serverSide,
+				request:    &request{command: udpAssociate, destination: tt.dst},
+			}
+			from := net.UDPAddrFromAddrPort(netip.MustParseAddrPort(tt.from))
+			if got := c.udpClientAllowed(from); got != tt.want {
+				t.Errorf("udpClientAllowed(%v) = %v; want %v", from, got, tt.want)
+			}
+		})
+	}
+}
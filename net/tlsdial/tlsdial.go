@@ -61,6 +61,12 @@
 // being configured and returned.
 // If ht is non-nil, it's used to report health errors.
 func Config(host string, ht *health.Tracker, base *tls.Config) *tls.Config {
+	return ConfigFor(PurposeOther, host, ht, base)
+}
+
+// ConfigFor is like Config but for a connection for purpose p, so that the
+// custom trust set with [SetTrust] for p applies to it.
+func ConfigFor(p Purpose, host string, ht *health.Tracker, base *tls.Config) *tls.Config {
 	var conf *tls.Config
 	if base == nil {
 		conf = new(tls.Config)
@@ -100,6 +106,7 @@ func Config(host string, ht *health.Tracker, base *tls.Config) *tls.Config {
 		// any verification.
 		var cert *x509.Certificate
 		var selfSignedIssuer string
+		customRoots, pins := trustFor(p)
 		if certs := cs.PeerCertificates; len(certs) > 0 {
 			cert = certs[0]
 			if certIsSelfSigned(cert) {
@@ -121,7 +128,19 @@ func Config(host string, ht *health.Tracker, base *tls.Config) *tls.Config {
 				} else {
 					ht.SetHealthy(mitmBlockWarnable)
 				}
-				if retErr != nil && selfSignedIssuer != "" {
+				if len(pins) > 0 {
+					if errors.Is(retErr, errPinMismatch) {
+						ht.SetUnhealthy(pinMismatchWarnable, health.Args{"purpose": p.String(), health.ArgServerName: host})
+					} else if retErr == nil {
+						ht.SetHealthy(pinMismatchWarnable)
+					}
+				}
+				if errors.Is(retErr, errPinMismatch) {
+					// Reported above.
+					ht.SetTLSConnectionError(cs.ServerName, nil)
+				} else if retErr != nil && customRoots != nil {
+					ht.SetTLSConnectionError(cs.ServerName, fmt.Errorf("certificate not trusted by the system roots or the configured CA bundle: %w", retErr))
+				} else if retErr != nil && selfSignedIssuer != "" {
 					// Self-signed certs are never valid.
 					//
 					// TODO(bradfitz): plumb down the selfSignedIssuer as a
@@ -147,7 +166,7 @@ func Config(host string, ht *health.Tracker, base *tls.Config) *tls.Config {
 		for _, cert := range cs.PeerCertificates[1:] {
 			opts.Intermediates.AddCert(cert)
 		}
-		_, errSys := cs.PeerCertificates[0].Verify(opts)
+		chains, errSys := cs.PeerCertificates[0].Verify(opts)
 		if debug() {
 			log.Printf("tlsdial(sys %q): %v", host, errSys)
 		}
@@ -156,10 +175,10 @@ func Config(host string, ht *health.Tracker, base *tls.Config) *tls.Config {
 		// so we can log an informational message. This is useful for
 		// detecting SSL MiTM.
 		opts.Roots = bakedroots.Get()
-		_, bakedErr := cs.PeerCertificates[0].Verify(opts)
+		bakedChains, bakedErr := cs.PeerCertificates[0].Verify(opts)
 		if debug() {
 			log.Printf("tlsdial(bake %q): %v", host, bakedErr)
-		} else if bakedErr != nil {
+		} else if bakedErr != nil && customRoots == nil {
 			if _, loaded := tlsdialWarningPrinted.LoadOrStore(host, true); !loaded {
 				if errSys == nil {
 					log.Printf("tlsdial: warning: server cert for %q is not a Let's Encrypt cert", host)
@@ -170,10 +189,20 @@ func Config(host string, ht *health.Tracker, base *tls.Config) *tls.Config {
 		}
 
 		if errSys == nil {
-			return nil
+			return checkPins(chains, pins)
 		} else if bakedErr == nil {
 			atomic.AddInt32(&counterFallbackOK, 1)
-			return nil
+			return checkPins(bakedChains, pins)
+		}
+		if customRoots != nil {
+			opts.Roots = customRoots
+			customChains, err := cs.PeerCertificates[0].Verify(opts)
+			if debug() {
+				log.Printf("tlsdial(custom %q): %v", host, err)
+			}
+			if err == nil {
+				return checkPins(customChains, pins)
+			}
 		}
 		return errSys
 	}
@@ -191,6 +220,7 @@ func certIsSelfSigned(cert *x509.Certificate) bool {
 //
 // This is for user-configurable client-side domain fronting support,
 // where we send one SNI value but validate a different cert.
+// It's only used for DERP, so the custom trust for [PurposeDERP] applies.
 func SetConfigExpectedCert(c *tls.Config, certDNSName string) {
 	if c.ServerName == certDNSName {
 		return
@@ -227,20 +257,31 @@ func SetConfigExpectedCert(c *tls.Config, certDNSName string) {
 		for _, cert := range certs[1:] {
 			opts.Intermediates.AddCert(cert)
 		}
-		_, errSys := certs[0].Verify(opts)
+		customRoots, pins := trustFor(PurposeDERP)
+		chains, errSys := certs[0].Verify(opts)
 		if debug() {
 			log.Printf("tlsdial(sys %q/%q): %v", c.ServerName, certDNSName, errSys)
 		}
 		if errSys == nil {
-			return nil
+			return checkPins(chains, pins)
 		}
 		opts.Roots = bakedroots.Get()
-		_, err := certs[0].Verify(opts)
+		chains, err := certs[0].Verify(opts)
 		if debug() {
 			log.Printf("tlsdial(bake %q/%q): %v", c.ServerName, certDNSName, err)
 		}
 		if err == nil {
-			return nil
+			return checkPins(chains, pins)
+		}
+		if customRoots != nil {
+			opts.Roots = customRoots
+			chains, err := certs[0].Verify(opts)
+			if debug() {
+				log.Printf("tlsdial(custom %q/%q): %v", c.ServerName, certDNSName, err)
+			}
+			if err == nil {
+				return checkPins(chains, pins)
+			}
 		}
 		return errSys
 	}
@@ -4,15 +4,24 @@
 package tlsdial
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"io"
+	"math/big"
 	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"tailscale.com/health"
 	"tailscale.com/net/bakedroots"
@@ -109,3 +118,114 @@ func TestFallbackRootWorks(t *testing.T) {
 func sayHi(w http.ResponseWriter, r *http.Request) {
 	io.WriteString(w, "hi")
 }
+
+func TestCustomTrust(t *testing.T) {
+	defer SetTrust(nil)
+
+	newKey := func() *ecdsa.PrivateKey {
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	caKey, leafKey := newKey(), newKey()
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tlsdial test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		DNSNames:     []string{"tlsdial.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConf := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{leafDER}, PrivateKey: leafKey}},
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				c.(*tls.Conn).Handshake()
+				c.Close()
+			}()
+		}
+	}()
+	handshake := func(p Purpose, ht *health.Tracker) error {
+		c, err := tls.Dial("tcp", ln.Addr().String(), ConfigFor(p, "tlsdial.test", ht, nil))
+		if err != nil {
+			return err
+		}
+		return c.Close()
+	}
+
+	if err := handshake(PurposeControl, nil); err == nil {
+		t.Error("untrusted CA accepted without custom trust")
+	}
+
+	SetTrust(&Trust{Roots: roots})
+	if err := handshake(PurposeControl, nil); err != nil {
+		t.Errorf("control with custom roots: %v", err)
+	}
+	if err := handshake(PurposeOther, nil); err == nil {
+		t.Error("custom roots applied to PurposeOther")
+	}
+
+	ht := new(health.Tracker)
+	SetTrust(&Trust{Roots: roots, ControlPins: []Pin{PinOf(ca)}, DERPPins: []Pin{{1, 2, 3}}})
+	if err := handshake(PurposeControl, ht); err != nil {
+		t.Errorf("control with CA pinned: %v", err)
+	}
+	if err := handshake(PurposeDERP, ht); err == nil || !strings.Contains(err.Error(), errPinMismatch.Error()) {
+		t.Errorf("DERP with wrong pin: got %v, want pin mismatch", err)
+	}
+	if !strings.Contains(strings.Join(ht.Strings(), "\n"), "none of the public keys pinned") {
+		t.Error("pin mismatch not reported to health")
+	}
+}
+
+func TestParsePin(t *testing.T) {
+	const b64 = "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+	for _, s := range []string{"sha256/" + b64, "sha256//" + b64} {
+		p, err := ParsePin(s)
+		if err != nil {
+			t.Errorf("ParsePin(%q): %v", s, err)
+		} else if p.String() != "sha256/"+b64 {
+			t.Errorf("ParsePin(%q) = %v", s, p)
+		}
+	}
+	for _, s := range []string{b64, "sha1/" + b64, "sha256/AAAA", "sha256/!"} {
+		if _, err := ParsePin(s); err == nil {
+			t.Errorf("ParsePin(%q): got no error", s)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tlsdial
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"tailscale.com/health"
+)
+
+// Purpose is what a TLS connection is for, which decides the custom [Trust]
+// that applies to it.
+type Purpose int
+
+const (
+	PurposeOther   Purpose = iota // logs and anything else; no custom trust
+	PurposeControl                // the control server
+	PurposeDERP                   // DERP servers
+)
+
+func (p Purpose) String() string {
+	switch p {
+	case PurposeControl:
+		return "control"
+	case PurposeDERP:
+		return "DERP"
+	}
+	return "other"
+}
+
+// Pin is the SHA-256 hash of a certificate's DER-encoded
+// SubjectPublicKeyInfo, as in HTTP Public Key Pinning (RFC 7469).
+type Pin [sha256.Size]byte
+
+// ParsePin parses a pin of the form "sha256/<base64>", as printed by
+//
+//	openssl x509 -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+//
+// with "sha256/" prepended. curl's "sha256//<base64>" form is accepted too.
+func ParsePin(s string) (Pin, error) {
+	b64, ok := strings.CutPrefix(s, "sha256/")
+	if !ok {
+		return Pin{}, fmt.Errorf("invalid pin %q: want sha256/<base64>", s)
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(b64, "/"))
+	if err != nil || len(b) != sha256.Size {
+		return Pin{}, fmt.Errorf("invalid pin %q: want the base64 of a SHA-256 hash", s)
+	}
+	return Pin(b), nil
+}
+
+func (p Pin) String() string {
+	return "sha256/" + base64.StdEncoding.EncodeToString(p[:])
+}
+
+// PinOf returns the pin of cert's public key.
+func PinOf(cert *x509.Certificate) Pin {
+	return sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+}
+
+// Trust is trust configuration for TLS connections to the control server and
+// DERP servers beyond the system's and the baked-in roots, for private CAs
+// (as used with Headscale) and TLS-intercepting proxies, and to pin the keys
+// of those servers.
+type Trust struct {
+	// Roots are CAs trusted, in addition to the system and baked-in
+	// roots, for the control server and DERP servers. It may be nil.
+	Roots *x509.CertPool
+
+	// ControlPins and DERPPins, if non-empty, are the keys one of which
+	// must appear in the verified certificate chain of the control server,
+	// or of any DERP server, respectively. The chain must still verify.
+	ControlPins []Pin
+	DERPPins    []Pin
+}
+
+// LoadTrust returns the Trust with the CAs in the PEM file caBundle, if
+// non-empty, and the given pins, in the form accepted by [ParsePin].
+func LoadTrust(caBundle string, controlPins, derpPins []string) (*Trust, error) {
+	t := new(Trust)
+	if caBundle != "" {
+		pem, err := os.ReadFile(caBundle)
+		if err != nil {
+			return nil, err
+		}
+		t.Roots = x509.NewCertPool()
+		if !t.Roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", caBundle)
+		}
+	}
+	for _, s := range controlPins {
+		p, err := ParsePin(s)
+		if err != nil {
+			return nil, err
+		}
+		t.ControlPins = append(t.ControlPins, p)
+	}
+	for _, s := range derpPins {
+		p, err := ParsePin(s)
+		if err != nil {
+			return nil, err
+		}
+		t.DERPPins = append(t.DERPPins, p)
+	}
+	return t, nil
+}
+
+var customTrust atomic.Pointer[Trust]
+
+// SetTrust sets the custom trust for subsequent connections to the control
+// server and DERP servers. A nil t removes it.
+func SetTrust(t *Trust) {
+	customTrust.Store(t)
+}
+
+// trustFor returns the custom roots and pins that apply to connections for
+// purpose p, if any.
+func trustFor(p Purpose) (roots *x509.CertPool, pins []Pin) {
+	t := customTrust.Load()
+	if t == nil {
+		return nil, nil
+	}
+	switch p {
+	case PurposeControl:
+		return t.Roots, t.ControlPins
+	case PurposeDERP:
+		return t.Roots, t.DERPPins
+	}
+	return nil, nil
+}
+
+// errPinMismatch is returned, wrapped, when a certificate chain verifies but
+// has none of the pinned keys.
+var errPinMismatch = errors.New("certificate chain has none of the pinned public keys")
+
+// checkPins returns an error wrapping errPinMismatch unless one of chains,
+// as returned by [x509.Certificate.Verify], contains a certificate with one
+// of pins. It returns nil if pins is empty.
+func checkPins(chains [][]*x509.Certificate, pins []Pin) error {
+	if len(pins) == 0 {
+		return nil
+	}
+	for _, chain := range chains {
+		for _, cert := range chain {
+			got := PinOf(cert)
+			for _, p := range pins {
+				if got == p {
+					return nil
+				}
+			}
+		}
+	}
+	var got []string
+	if len(chains) > 0 {
+		for _, cert := range chains[0] {
+			got = append(got, PinOf(cert).String())
+		}
+	}
+	return fmt.Errorf("%w (chain has %s)", errPinMismatch, strings.Join(got, ", "))
+}
+
+var pinMismatchWarnable = health.Register(&health.Warnable{
+	Code:  "tls-pin-mismatch",
+	Title: "Server certificate doesn't match pinned keys",
+	Text: func(args health.Args) string {
+		return fmt.Sprintf("The certificate of the %s server %q is trusted but has none of the public keys pinned in this device's TLS configuration. The connection may be intercepted, or the server's keys have changed and the pins need updating.", args["purpose"], args[health.ArgServerName])
+	},
+	Severity:            health.SeverityHigh,
+	ImpactsConnectivity: true,
+})
@@ -77,6 +77,7 @@ type Dialer struct {
 	mu               sync.Mutex
 	closed           bool
 	dns              dnsMap
+	via              viaMap
 	tunName          string // tun device name
 	netMon           *netmon.Monitor
 	netMonUnregister func()
@@ -288,10 +289,12 @@ func (d *Dialer) PeerDialControlFunc() func(network, address string, c syscall.R
 // in its DNS configuration.
 func (d *Dialer) SetNetMap(nm *netmap.NetworkMap) {
 	m := dnsMapFromNetworkMap(nm)
+	via := viaMapFromNetworkMap(nm)
 
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	d.dns = m
+	d.via = via
 }
 
 // userDialResolve resolves addr as if a user initiating the dial. (e.g. from a
@@ -407,6 +410,39 @@ func (d *Dialer) UserDial(ctx context.Context, network, addr string) (net.Conn,
 	if err != nil {
 		return nil, err
 	}
+	return d.userDialAddr(ctx, network, ipp)
+}
+
+// UserDialVia is like UserDial, but reaches addr via the tailnet peer named
+// by via, a MagicDNS name or Tailscale IP. If the peer advertises a 4via6
+// route for addr's IPv4 address, addr is dialed through it, which reaches
+// addr on the peer's network even when other subnet routers advertise
+// overlapping routes. Otherwise, the peer must have a subnet route to addr.
+func (d *Dialer) UserDialVia(ctx context.Context, network, addr, via string) (net.Conn, error) {
+	d.mu.Lock()
+	vm := d.via
+	d.mu.Unlock()
+	routes, ok := vm.routes(via)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a peer with subnet routes", via)
+	}
+	ipp, err := d.userDialResolve(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	dst, ok := routeVia(routes, ipp.Addr())
+	if !ok {
+		return nil, fmt.Errorf("%q has no route to %v", via, ipp.Addr())
+	}
+	if dst != ipp.Addr() {
+		// 4via6 addresses are IPv6.
+		network = strings.TrimSuffix(network, "4")
+	}
+	return d.userDialAddr(ctx, network, netip.AddrPortFrom(dst, ipp.Port()))
+}
+
+// userDialAddr connects to ipp as if a user were initiating the dial.
+func (d *Dialer) userDialAddr(ctx context.Context, network string, ipp netip.AddrPort) (net.Conn, error) {
 	if d.UseNetstackForIP != nil && d.UseNetstackForIP(ipp.Addr()) {
 		if d.NetstackDialTCP == nil || d.NetstackDialUDP == nil {
 			return nil, errors.New("Dialer not initialized correctly")
@@ -0,0 +1,86 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsdial
+
+import (
+	"encoding/binary"
+	"net/netip"
+
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/types/netmap"
+	"tailscale.com/types/views"
+	"tailscale.com/util/dnsname"
+)
+
+// viaMap maps the MagicDNS names (as dnsMap keys) and Tailscale IPs of peers
+// to the routes they're used for, other than to their own addresses.
+// It must not be mutated once created.
+type viaMap map[string][]netip.Prefix
+
+func viaMapFromNetworkMap(nm *netmap.NetworkMap) viaMap {
+	if nm == nil {
+		return nil
+	}
+	ret := make(viaMap)
+	suffix := nm.MagicDNSSuffix()
+	for _, p := range nm.Peers {
+		var routes []netip.Prefix
+		for _, r := range p.AllowedIPs().All() {
+			if !views.SliceContains(p.Addresses(), r) {
+				routes = append(routes, r)
+			}
+		}
+		if len(routes) == 0 {
+			continue
+		}
+		if p.Name() != "" {
+			ret[canonMapKey(p.Name())] = routes
+			if dnsname.HasSuffix(p.Name(), suffix) {
+				ret[canonMapKey(dnsname.TrimSuffix(p.Name(), suffix))] = routes
+			}
+		}
+		for _, pfx := range p.Addresses().All() {
+			ret[pfx.Addr().String()] = routes
+		}
+	}
+	return ret
+}
+
+// routes returns the routes of the peer named peer, by MagicDNS name or
+// Tailscale IP, and whether it has any.
+func (m viaMap) routes(peer string) ([]netip.Prefix, bool) {
+	if ip, err := netip.ParseAddr(peer); err == nil {
+		peer = ip.String()
+	}
+	routes, ok := m[canonMapKey(peer)]
+	return routes, ok
+}
+
+// routeVia returns the address to dial to reach ip via the peer with the
+// given routes, and whether the peer routes ip at all. That's the 4via6
+// address of ip in a site the peer has a route to ip in, if any, or else ip
+// itself if the peer has a subnet route to it.
+func routeVia(routes []netip.Prefix, ip netip.Addr) (netip.Addr, bool) {
+	if ip.Is4() {
+		for _, r := range routes {
+			if !tsaddr.IsViaPrefix(r) || r.Bits() < 96 {
+				continue
+			}
+			a := r.Addr().As16()
+			siteID := binary.BigEndian.Uint32(a[8:12])
+			if !netip.PrefixFrom(tsaddr.UnmapVia(r.Addr()), r.Bits()-96).Contains(ip) {
+				continue
+			}
+			if via, err := tsaddr.MapVia(siteID, netip.PrefixFrom(ip, 32)); err == nil {
+				return via.Addr(), true
+			}
+		}
+	}
+	for _, r := range routes {
+		if !tsaddr.IsViaPrefix(r) && r.Contains(ip) {
+			return ip, true
+		}
+	}
+	return netip.Addr{}, false
+}
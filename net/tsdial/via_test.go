@@ -0,0 +1,58 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsdial
+
+import (
+	"net/netip"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+func TestRouteVia(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	ip := netip.MustParseAddr
+	nm := &netmap.NetworkMap{
+		Name: "self.tailnet",
+		Peers: nodeViews([]*tailcfg.Node{
+			{
+				Name:       "gateway.tailnet",
+				Addresses:  []netip.Prefix{pfx("100.64.0.2/32")},
+				AllowedIPs: []netip.Prefix{pfx("100.64.0.2/32"), pfx("fd7a:115c:a1e0:b1a:0:7:a00:0/120"), pfx("192.168.0.0/16")},
+			},
+			{
+				Name:       "plain.tailnet",
+				Addresses:  []netip.Prefix{pfx("100.64.0.3/32")},
+				AllowedIPs: []netip.Prefix{pfx("100.64.0.3/32")},
+			},
+		}),
+	}
+	vm := viaMapFromNetworkMap(nm)
+	if _, ok := vm.routes("plain"); ok {
+		t.Errorf("peer without routes found")
+	}
+	for _, peer := range []string{"gateway", "GATEWAY.tailnet.", "100.64.0.2"} {
+		if _, ok := vm.routes(peer); !ok {
+			t.Errorf("peer %q not found", peer)
+		}
+	}
+
+	routes, _ := vm.routes("gateway")
+	tests := []struct {
+		ip     string
+		want   netip.Addr
+		wantOK bool
+	}{
+		{"10.0.0.5", ip("fd7a:115c:a1e0:b1a:0:7:a00:5"), true},
+		{"192.168.1.1", ip("192.168.1.1"), true},
+		{"10.0.1.5", netip.Addr{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := routeVia(routes, ip(tt.ip))
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("routeVia(%s) = %v, %v; want %v, %v", tt.ip, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
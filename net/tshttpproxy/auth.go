@@ -0,0 +1,386 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tshttpproxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/envknob"
+)
+
+var (
+	// proxyUserEnv and proxyPasswordEnv are the credentials to authenticate
+	// to HTTP proxies with, if not set by SetCredentials or in the proxy URL.
+	// The user may be of the form DOMAIN\user for NTLM.
+	proxyUserEnv     = envknob.RegisterString("TS_PROXY_USER")
+	proxyPasswordEnv = envknob.RegisterString("TS_PROXY_PASSWORD")
+)
+
+var (
+	credsMu     sync.Mutex
+	credsUser   string // from SetCredentials, or empty
+	credsPasswd string
+)
+
+// SetCredentials sets the user name and password to authenticate to HTTP
+// proxies with, such as from the config file. They take precedence over the
+// TS_PROXY_USER and TS_PROXY_PASSWORD environment variables, but not over
+// credentials in the proxy URL. The user may be of the form DOMAIN\user for
+// NTLM. An empty user clears them.
+func SetCredentials(user, password string) {
+	credsMu.Lock()
+	defer credsMu.Unlock()
+	credsUser, credsPasswd = user, password
+}
+
+// proxyCredentials returns the user name and password to authenticate to
+// the proxy u with, if any.
+func proxyCredentials(u *url.URL) (user, password string, ok bool) {
+	if user := u.User.Username(); user != "" {
+		password, ok := u.User.Password()
+		return user, password, ok
+	}
+	credsMu.Lock()
+	user, password = credsUser, credsPasswd
+	credsMu.Unlock()
+	if user != "" {
+		return user, password, true
+	}
+	if user := proxyUserEnv(); user != "" {
+		return user, proxyPasswordEnv(), true
+	}
+	return "", "", false
+}
+
+// maxAuthLegs is the most CONNECT requests Connect sends to authenticate
+// to a proxy, enough for NTLM's handshake after a failed first attempt.
+const maxAuthLegs = 4
+
+// Connect returns a connection to target, a host:port, tunneled through the
+// HTTP proxy at proxyURL with a CONNECT request. It authenticates to the
+// proxy as it demands, with Basic or NTLM authentication using the
+// credentials from the proxy URL, SetCredentials or the environment, or with
+// Negotiate (Kerberos) as the current user where the OS supports it.
+//
+// dialProxy dials the proxy, including any TLS to it. It's called again if
+// the proxy closes the connection between legs of the authentication.
+func Connect(ctx context.Context, dialProxy func(context.Context) (net.Conn, error), proxyURL *url.URL, target string) (_ net.Conn, retErr error) {
+	auth := &proxyAuth{proxyURL: proxyURL}
+	var (
+		conn net.Conn
+		br   *bufio.Reader
+	)
+	defer func() {
+		if retErr != nil && conn != nil {
+			conn.Close()
+		}
+	}()
+	hdr, err := auth.initial()
+	if err != nil {
+		log.Printf("tshttpproxy: getting auth header for %v; ignoring: %v", proxyURL.Redacted(), err)
+	}
+	for range maxAuthLegs {
+		if conn == nil {
+			conn, err = dialProxy(ctx)
+			if err != nil {
+				return nil, err
+			}
+			br = bufio.NewReader(conn)
+		}
+		res, err := connectLeg(ctx, conn, br, target, hdr)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("tshttpproxy: CONNECT response from %v for target %q (auth %s): %v", proxyURL.Redacted(), target, auth.scheme, res.Status)
+		switch res.StatusCode {
+		case http.StatusOK:
+			if br.Buffered() > 0 {
+				return &bufferedConn{Conn: conn, r: br}, nil
+			}
+			return conn, nil
+		case http.StatusProxyAuthRequired:
+		default:
+			return nil, fmt.Errorf("invalid response status from HTTP proxy %s on CONNECT to %s: %v", proxyURL.Redacted(), target, res.Status)
+		}
+		// Drain the body so the connection can be reused for the next leg.
+		_, err = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+		res.Body.Close()
+		if err != nil || res.Close {
+			conn.Close()
+			conn = nil
+		}
+		hdr, err = auth.respond(res.Header.Values("Proxy-Authenticate"))
+		if err != nil {
+			return nil, fmt.Errorf("HTTP proxy %s: %w", proxyURL.Redacted(), err)
+		}
+	}
+	return nil, fmt.Errorf("HTTP proxy %s: authentication failed", proxyURL.Redacted())
+}
+
+// connectLeg sends a CONNECT request for target with the Proxy-Authorization
+// header authHeader, if non-empty, on conn and reads the response from br.
+func connectLeg(ctx context.Context, conn net.Conn, br *bufio.Reader, target, authHeader string) (*http.Response, error) {
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer func() {
+		if stop() {
+			conn.SetDeadline(time.Time{})
+		}
+	}()
+
+	req := "CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n"
+	if authHeader != "" {
+		req += proxyAuthHeader + ": " + authHeader + "\r\n"
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	res, err := http.ReadResponse(br, &http.Request{Method: "CONNECT"})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+// proxyAuth is the state of authenticating to a proxy over the legs of a
+// CONNECT.
+type proxyAuth struct {
+	proxyURL *url.URL
+	scheme   string          // of the last Proxy-Authorization sent, or "none"
+	tried    map[string]bool // schemes already tried, by lower case name
+	ntlmUser string          // non-empty while an NTLM handshake is in progress
+	ntlmPass string
+}
+
+// initial returns the Proxy-Authorization header to send with the first
+// CONNECT, before the proxy has asked for any, or the empty string.
+func (a *proxyAuth) initial() (string, error) {
+	a.scheme = "none"
+	v, err := GetAuthHeader(a.proxyURL)
+	if err != nil || v == "" {
+		return "", err
+	}
+	a.scheme, _, _ = strings.Cut(v, " ")
+	a.markTried(a.scheme)
+	return v, nil
+}
+
+func (a *proxyAuth) markTried(scheme string) {
+	if a.tried == nil {
+		a.tried = map[string]bool{}
+	}
+	a.tried[strings.ToLower(scheme)] = true
+}
+
+// respond returns the Proxy-Authorization header answering the proxy's
+// Proxy-Authenticate challenges, or an error if there's no way to.
+func (a *proxyAuth) respond(challenges []string) (string, error) {
+	offered := map[string]string{} // lower case scheme => challenge data
+	var names []string
+	for _, c := range challenges {
+		scheme, data, _ := strings.Cut(strings.TrimSpace(c), " ")
+		offered[strings.ToLower(scheme)] = strings.TrimSpace(data)
+		names = append(names, scheme)
+	}
+
+	if a.ntlmUser != "" {
+		user, pass := a.ntlmUser, a.ntlmPass
+		a.ntlmUser, a.ntlmPass = "", ""
+		data, ok := offered["ntlm"]
+		if !ok || data == "" {
+			return "", errors.New("NTLM authentication rejected")
+		}
+		msg, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return "", fmt.Errorf("bad NTLM challenge: %w", err)
+		}
+		c, err := parseNTLMChallenge(msg)
+		if err != nil {
+			return "", err
+		}
+		u, domain := splitNTLMUser(user)
+		msg, err = ntlmAuthenticateMessage(c, u, domain, pass, time.Now())
+		if err != nil {
+			return "", err
+		}
+		return "NTLM " + base64.StdEncoding.EncodeToString(msg), nil
+	}
+
+	user, pass, haveCreds := proxyCredentials(a.proxyURL)
+	if _, ok := offered["negotiate"]; ok && !a.tried["negotiate"] && sysAuthHeader != nil {
+		a.markTried("negotiate")
+		if v, err := sysAuthHeader(a.proxyURL); err == nil && v != "" {
+			a.scheme = "Negotiate"
+			return v, nil
+		} else if err != nil {
+			log.Printf("tshttpproxy: Negotiate authentication to %v: %v", a.proxyURL.Redacted(), err)
+		}
+	}
+	if _, ok := offered["ntlm"]; ok && !a.tried["ntlm"] && haveCreds {
+		a.markTried("ntlm")
+		a.scheme = "NTLM"
+		a.ntlmUser, a.ntlmPass = user, pass
+		return "NTLM " + base64.StdEncoding.EncodeToString(ntlmNegotiateMessage()), nil
+	}
+	if _, ok := offered["basic"]; ok && !a.tried["basic"] && haveCreds {
+		a.markTried("basic")
+		a.scheme = "Basic"
+		req := &http.Request{Header: make(http.Header)}
+		req.SetBasicAuth(user, pass)
+		return req.Header.Get("Authorization"), nil
+	}
+	if !haveCreds {
+		return "", fmt.Errorf("proxy requires authentication (%s) and no credentials are configured; set TS_PROXY_USER and TS_PROXY_PASSWORD", strings.Join(names, ", "))
+	}
+	return "", fmt.Errorf("proxy rejected the credentials or requires unsupported authentication (%s)", strings.Join(names, ", "))
+}
+
+// bufferedConn is a net.Conn whose reads start with what's left in a
+// bufio.Reader that read from the Conn.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// ConfigureTransport configures tr to use its Proxy func, and otherwise
+// the system's HTTP proxy, for HTTPS requests by tunneling through it with
+// Connect, so that proxies requiring authentication, including multi-leg
+// NTLM that the standard library can't do, work. Plain HTTP requests use
+// the proxy as usual, with SetTransportGetProxyConnectHeader.
+//
+// It must be called after tr's Proxy, DialContext, DialTLSContext and
+// TLSClientConfig are set.
+func ConfigureTransport(tr *http.Transport) {
+	SetTransportGetProxyConnectHeader(tr)
+	proxy := tr.Proxy
+	if proxy == nil {
+		proxy = ProxyFromEnvironment
+	}
+	tr.Proxy = func(req *http.Request) (*url.URL, error) {
+		u, err := proxy(req)
+		if req.URL.Scheme == "https" && isHTTPProxy(u) {
+			return nil, nil // tunneled by DialTLSContext
+		}
+		return u, err
+	}
+
+	dial := tr.DialContext
+	if dial == nil {
+		var d net.Dialer
+		dial = d.DialContext
+	}
+	dialTLS := tr.DialTLSContext
+	tr.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		u := &url.URL{Scheme: "https", Host: host}
+		if port != "443" {
+			u.Host = addr
+		}
+		proxyURL, err := proxy(&http.Request{URL: u})
+		if err != nil || !isHTTPProxy(proxyURL) || proxyAddr(proxyURL) == addr {
+			// No proxy, or this is the Transport itself dialing an
+			// https proxy for a plain HTTP request.
+			proxyURL = nil
+		}
+		if proxyURL == nil && dialTLS != nil {
+			return dialTLS(ctx, network, addr)
+		}
+		var conn net.Conn
+		if proxyURL == nil {
+			conn, err = dial(ctx, network, addr)
+		} else {
+			conn, err = Connect(ctx, func(ctx context.Context) (net.Conn, error) {
+				return DialProxy(ctx, dial, proxyURL, tr.TLSClientConfig)
+			}, proxyURL, addr)
+		}
+		if err != nil {
+			return nil, err
+		}
+		tc := tls.Client(conn, tlsConfigFor(tr, host))
+		if err := tc.HandshakeContext(ctx); err != nil {
+			tc.Close()
+			return nil, err
+		}
+		return tc, nil
+	}
+}
+
+// isHTTPProxy reports whether u is the URL of an HTTP or HTTPS proxy, as
+// opposed to a SOCKS one, or none.
+func isHTTPProxy(u *url.URL) bool {
+	return u != nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
+// tlsConfigFor returns the TLS config for a connection made by tr to host.
+func tlsConfigFor(tr *http.Transport, host string) *tls.Config {
+	var c *tls.Config
+	if tr.TLSClientConfig != nil {
+		c = tr.TLSClientConfig.Clone()
+	} else {
+		c = new(tls.Config)
+	}
+	if c.ServerName == "" {
+		c.ServerName = host
+	}
+	return c
+}
+
+// proxyAddr returns the host:port of the HTTP or HTTPS proxy at proxyURL.
+func proxyAddr(proxyURL *url.URL) string {
+	port := proxyURL.Port()
+	if port == "" {
+		port = "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(proxyURL.Hostname(), port)
+}
+
+// DialProxy dials the HTTP proxy at proxyURL using dial, with TLS if it's an
+// https proxy. tlsConf, if non-nil, is the base TLS config to use for https
+// proxies.
+func DialProxy(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error), proxyURL *url.URL, tlsConf *tls.Config) (net.Conn, error) {
+	conn, err := dial(ctx, "tcp", proxyAddr(proxyURL))
+	if err != nil || proxyURL.Scheme != "https" {
+		return conn, err
+	}
+	if tlsConf == nil {
+		tlsConf = new(tls.Config)
+	} else {
+		tlsConf = tlsConf.Clone()
+	}
+	tlsConf.ServerName = proxyURL.Hostname()
+	tc := tls.Client(conn, tlsConf)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tc, nil
+}
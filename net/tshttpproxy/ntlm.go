@@ -0,0 +1,203 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tshttpproxy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4"
+)
+
+// This file implements the client side of NTLMv2 authentication (MS-NLMP),
+// as used by HTTP proxies that offer the "NTLM" scheme. Only what's needed to
+// authenticate a CONNECT request is implemented: no signing or sealing.
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+const (
+	ntlmNegotiateUnicode           = 0x00000001
+	ntlmNegotiateOEM               = 0x00000002
+	ntlmRequestTarget              = 0x00000004
+	ntlmNegotiateNTLM              = 0x00000200
+	ntlmNegotiateAlwaysSign        = 0x00008000
+	ntlmNegotiateExtendedSecurity  = 0x00080000
+	ntlmNegotiateTargetInfo        = 0x00800000
+	ntlmNegotiate128               = 0x20000000
+	ntlmNegotiate56                = 0x80000000
+	ntlmNegotiateFlags             = ntlmNegotiateUnicode | ntlmNegotiateOEM | ntlmRequestTarget | ntlmNegotiateNTLM | ntlmNegotiateAlwaysSign | ntlmNegotiateExtendedSecurity | ntlmNegotiateTargetInfo | ntlmNegotiate128 | ntlmNegotiate56
+	ntlmAvIDEOL                    = 0
+	ntlmAvIDTimestamp              = 7
+	ntlmNegotiateMessageType       = 1
+	ntlmChallengeMessageType       = 2
+	ntlmAuthenticateMessageType    = 3
+	ntlmAuthenticateMessageHdrSize = 64 // without the optional version and MIC
+)
+
+// ntlmNegotiateMessage returns the NEGOTIATE_MESSAGE that starts NTLM
+// authentication.
+func ntlmNegotiateMessage() []byte {
+	b := make([]byte, 32)
+	copy(b, ntlmSignature)
+	binary.LittleEndian.PutUint32(b[8:], ntlmNegotiateMessageType)
+	binary.LittleEndian.PutUint32(b[12:], ntlmNegotiateFlags)
+	// The domain and workstation fields are left empty.
+	return b
+}
+
+// ntlmChallenge is the part of a CHALLENGE_MESSAGE needed to respond to it.
+type ntlmChallenge struct {
+	flags           uint32
+	serverChallenge [8]byte
+	targetInfo      []byte
+}
+
+// parseNTLMChallenge parses a CHALLENGE_MESSAGE from the server.
+func parseNTLMChallenge(b []byte) (*ntlmChallenge, error) {
+	if len(b) < 32 || !bytes.Equal(b[:8], ntlmSignature) {
+		return nil, errors.New("not an NTLM message")
+	}
+	if binary.LittleEndian.Uint32(b[8:]) != ntlmChallengeMessageType {
+		return nil, errors.New("not an NTLM challenge message")
+	}
+	c := &ntlmChallenge{flags: binary.LittleEndian.Uint32(b[20:])}
+	copy(c.serverChallenge[:], b[24:32])
+	if len(b) >= 48 {
+		n := int(binary.LittleEndian.Uint16(b[40:]))
+		off := int(binary.LittleEndian.Uint32(b[44:]))
+		if off > len(b) || n > len(b)-off {
+			return nil, errors.New("NTLM challenge target info out of bounds")
+		}
+		c.targetInfo = b[off : off+n]
+	}
+	return c, nil
+}
+
+// timestamp returns the server's timestamp from the challenge's target info,
+// and whether it has one.
+func (c *ntlmChallenge) timestamp() (ts []byte, ok bool) {
+	for b := c.targetInfo; len(b) >= 4; {
+		id := binary.LittleEndian.Uint16(b)
+		n := int(binary.LittleEndian.Uint16(b[2:]))
+		b = b[4:]
+		if id == ntlmAvIDEOL || n > len(b) {
+			break
+		}
+		if id == ntlmAvIDTimestamp && n == 8 {
+			return b[:n], true
+		}
+		b = b[n:]
+	}
+	return nil, false
+}
+
+// ntlmV2Hash returns NTOWFv2 of the user's credentials.
+func ntlmV2Hash(user, domain, password string) []byte {
+	h := md4.New()
+	h.Write(utf16LE(password))
+	ntHash := h.Sum(nil)
+	return hmacMD5(ntHash, utf16LE(strings.ToUpper(user)+domain))
+}
+
+// ntlmV2Response returns the NTLMv2 and LMv2 responses to serverChallenge
+// with the given client challenge and NTLMv2 "temp" blob.
+func ntlmV2Response(hash []byte, serverChallenge, clientChallenge [8]byte, temp []byte) (nt, lm []byte) {
+	proof := hmacMD5(hash, serverChallenge[:], temp)
+	nt = append(proof, temp...)
+	lm = append(hmacMD5(hash, serverChallenge[:], clientChallenge[:]), clientChallenge[:]...)
+	return nt, lm
+}
+
+// ntlmTemp returns the NTLMv2 client "temp" blob.
+func ntlmTemp(ts []byte, clientChallenge [8]byte, targetInfo []byte) []byte {
+	temp := []byte{1, 1, 0, 0, 0, 0, 0, 0}
+	temp = append(temp, ts...)
+	temp = append(temp, clientChallenge[:]...)
+	temp = append(temp, 0, 0, 0, 0)
+	temp = append(temp, targetInfo...)
+	temp = append(temp, 0, 0, 0, 0)
+	return temp
+}
+
+// ntlmAuthenticateMessage returns the AUTHENTICATE_MESSAGE answering c for
+// the given credentials, as of now.
+func ntlmAuthenticateMessage(c *ntlmChallenge, user, domain, password string, now time.Time) ([]byte, error) {
+	var clientChallenge [8]byte
+	if _, err := rand.Read(clientChallenge[:]); err != nil {
+		return nil, err
+	}
+	ts, serverTS := c.timestamp()
+	if !serverTS {
+		ts = binary.LittleEndian.AppendUint64(nil, windowsFileTime(now))
+	}
+	hash := ntlmV2Hash(user, domain, password)
+	nt, lm := ntlmV2Response(hash, c.serverChallenge, clientChallenge, ntlmTemp(ts, clientChallenge, c.targetInfo))
+	if serverTS {
+		// With a server timestamp, the LMv2 response must be zeroed
+		// (MS-NLMP 3.1.5.1.2).
+		lm = make([]byte, 24)
+	}
+
+	flags := c.flags & ntlmNegotiateFlags
+	fields := [][]byte{lm, nt, utf16LE(domain), utf16LE(user), nil /* workstation */, nil /* session key */}
+	b := make([]byte, ntlmAuthenticateMessageHdrSize)
+	copy(b, ntlmSignature)
+	binary.LittleEndian.PutUint32(b[8:], ntlmAuthenticateMessageType)
+	off := ntlmAuthenticateMessageHdrSize
+	for i, f := range fields {
+		hdr := b[12+8*i:]
+		binary.LittleEndian.PutUint16(hdr, uint16(len(f)))
+		binary.LittleEndian.PutUint16(hdr[2:], uint16(len(f)))
+		binary.LittleEndian.PutUint32(hdr[4:], uint32(off))
+		off += len(f)
+	}
+	binary.LittleEndian.PutUint32(b[60:], flags|ntlmNegotiateUnicode)
+	for _, f := range fields {
+		b = append(b, f...)
+	}
+	return b, nil
+}
+
+// splitNTLMUser splits a user name of the form DOMAIN\user or user@DOMAIN
+// into its user and domain.
+func splitNTLMUser(s string) (user, domain string) {
+	if d, u, ok := strings.Cut(s, `\`); ok {
+		return u, d
+	}
+	if u, d, ok := strings.Cut(s, "@"); ok {
+		return u, d
+	}
+	return s, ""
+}
+
+// windowsFileTime returns t as a Windows FILETIME: the number of 100ns
+// intervals since 1601-01-01 UTC.
+func windowsFileTime(t time.Time) uint64 {
+	const epochDelta = 116444736000000000 // 1601 to 1970, in 100ns
+	return uint64(t.UnixNano()/100) + epochDelta
+}
+
+func utf16LE(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(u))
+	for i, r := range u {
+		binary.LittleEndian.PutUint16(b[2*i:], r)
+	}
+	return b
+}
+
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	h := hmac.New(md5.New, key)
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
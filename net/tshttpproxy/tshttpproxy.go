@@ -170,20 +170,19 @@ func ProxyFromEnvironment(req *http.Request) (ret *url.URL, _ error) {
 
 var sysAuthHeader func(*url.URL) (string, error)
 
-// GetAuthHeader returns the Authorization header value to send to proxy u.
+// GetAuthHeader returns the Authorization header value to send to proxy u
+// before it asks for any: Basic authentication if there are credentials for
+// it (see Connect), or else the OS's, if any.
 func GetAuthHeader(u *url.URL) (string, error) {
 	if fake := os.Getenv("TS_DEBUG_FAKE_PROXY_AUTH"); fake != "" {
 		return fake, nil
 	}
-	if user := u.User.Username(); user != "" {
-		pass, ok := u.User.Password()
-		if !ok {
-			return "", nil
-		}
-
+	if user, pass, ok := proxyCredentials(u); ok {
 		req := &http.Request{Header: make(http.Header)}
 		req.SetBasicAuth(user, pass)
 		return req.Header.Get("Authorization"), nil
+	} else if user != "" {
+		return "", nil
 	}
 	if sysAuthHeader != nil {
 		return sysAuthHeader(u)
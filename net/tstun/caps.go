@@ -0,0 +1,92 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tstun
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+// I/O modes reported in ipnstate.TUNCapabilities.IOMode.
+const (
+	ioModeBatch  = "batch"  // the device batches natively
+	ioModeSingle = "single" // one packet per wireguard-go read
+)
+
+// lastCaps is the capabilities of the most recently created tun device.
+var lastCaps atomic.Pointer[ipnstate.TUNCapabilities]
+
+// Capabilities returns the capabilities of the tun device created by the
+// most recent call to New, and whether there was one.
+func Capabilities() (caps ipnstate.TUNCapabilities, ok bool) {
+	p := lastCaps.Load()
+	if p == nil {
+		return caps, false
+	}
+	return *p, true
+}
+
+// driverCaps returns what the tun driver of goos supports at kernel
+// release, as far as is known without creating a device.
+func driverCaps(goos, release string) ipnstate.TUNCapabilities {
+	c := ipnstate.TUNCapabilities{
+		Driver:    "tun",
+		OSVersion: release,
+		MaxMTU:    int(maxTUNMTU),
+	}
+	switch goos {
+	case "linux":
+		c.MultiQueue = true
+		c.LinkLayerModes = []string{"p2p", "tap"}
+		c.MaxMTU = 65535
+		c.ChecksumOffload = true // IFF_VNET_HDR
+	case "darwin", "ios":
+		c.Driver = "utun"
+		c.LinkLayerModes = []string{"p2p"}
+		c.MaxMTU = 16000
+	case "freebsd":
+		c.Driver = "tun(4)"
+		c.LinkLayerModes = []string{"p2p", "multi-af", "tap"}
+		c.MaxMTU = 16384 // TUNMRU
+		// FreeBSD 14 added virtio-net header support to tun and tap.
+		c.ChecksumOffload = majorVersion(release) >= 14
+	case "dragonfly", "netbsd":
+		c.Driver = "tun(4)"
+		c.LinkLayerModes = []string{"p2p", "multi-af", "tap"}
+		c.MaxMTU = 16384 // TUNMRU
+	case "openbsd":
+		// OpenBSD's tun always prefixes packets with their address
+		// family.
+		c.Driver = "tun(4)"
+		c.LinkLayerModes = []string{"multi-af", "tap"}
+		c.MaxMTU = 16384 // TUNMRU
+	case "windows":
+		c.Driver = "wintun"
+		c.LinkLayerModes = []string{"p2p"}
+	case "illumos", "solaris":
+		c.Driver = "tuntap"
+		c.LinkLayerModes = []string{"p2p", "tap"}
+	}
+	return c
+}
+
+// majorVersion returns the major version number of a kernel release such as
+// "14.1-RELEASE", or 0 if it can't be parsed.
+func majorVersion(release string) int {
+	major, _, _ := strings.Cut(release, ".")
+	n, _ := strconv.Atoi(major)
+	return n
+}
+
+// pickIOMode returns how wireguard-go reads from a device that returns
+// devBatch packets per read.
+func pickIOMode(devBatch int) string {
+	if devBatch > 1 {
+		return ioModeBatch
+	}
+	return ioModeSingle
+}
@@ -0,0 +1,41 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tstun
+
+import "testing"
+
+func TestDriverCaps(t *testing.T) {
+	tests := []struct {
+		goos, release string
+		wantOffload   bool
+		wantMaxMTU    int
+	}{
+		{"freebsd", "13.3-RELEASE", false, 16384},
+		{"freebsd", "14.1-RELEASE-p5", true, 16384},
+		{"openbsd", "7.6", false, 16384},
+		{"netbsd", "10.1", false, 16384},
+		{"linux", "6.8.0", true, 65535},
+	}
+	for _, tt := range tests {
+		c := driverCaps(tt.goos, tt.release)
+		if c.ChecksumOffload != tt.wantOffload || c.MaxMTU != tt.wantMaxMTU {
+			t.Errorf("%s %s: offload=%v maxmtu=%d; want %v, %d", tt.goos, tt.release, c.ChecksumOffload, c.MaxMTU, tt.wantOffload, tt.wantMaxMTU)
+		}
+	}
+}
+
+func TestPickIOMode(t *testing.T) {
+	tests := []struct {
+		devBatch int
+		want     string
+	}{
+		{128, ioModeBatch},
+		{1, ioModeSingle},
+	}
+	for _, tt := range tests {
+		if got := pickIOMode(tt.devBatch); got != tt.want {
+			t.Errorf("pickIOMode(%d) = %q; want %q", tt.devBatch, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build unix && !aix
+
+package tstun
+
+import "golang.org/x/sys/unix"
+
+// kernelRelease returns the running kernel's release, such as
+// "14.1-RELEASE".
+func kernelRelease() string {
+	var un unix.Utsname
+	if err := unix.Uname(&un); err != nil {
+		return ""
+	}
+	return unix.ByteSliceToString(un.Release[:])
+}
@@ -0,0 +1,11 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tstun
+
+import "tailscale.com/hostinfo"
+
+// kernelRelease returns the running Windows version.
+func kernelRelease() string {
+	return hostinfo.GetOSVersion()
+}
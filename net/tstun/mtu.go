@@ -123,6 +123,22 @@ func WireToTUNMTU(w WireMTU) TUNMTU {
 	return TUNMTU(w - wgHeaderLen)
 }
 
+// tcpMSSForWireMTU returns the largest TCP MSS for which a segment over
+// IPv4, or IPv6 if is6, fits in a packet tunneled over a path with wire MTU
+// w, or 0 if none does.
+func tcpMSSForWireMTU(w WireMTU, is6 bool) uint16 {
+	const tcpHeaderLen = 20
+	ipHeaderLen := TUNMTU(20)
+	if is6 {
+		ipHeaderLen = 40
+	}
+	t := WireToTUNMTU(w)
+	if t <= ipHeaderLen+tcpHeaderLen {
+		return 0
+	}
+	return uint16(min(t-ipHeaderLen-tcpHeaderLen, 1<<16-1))
+}
+
 // DefaultTUNMTU returns the MTU we use to set the Tailscale TUN
 // MTU. It is also the path MTU that we default to if we have no
 // information about the path to a peer.
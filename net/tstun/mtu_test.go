@@ -97,3 +97,27 @@ func TestMTUConversion(t *testing.T) {
 		}
 	}
 }
+
+func TestTCPMSSForWireMTU(t *testing.T) {
+	tests := []struct {
+		w    WireMTU
+		is6  bool
+		want uint16
+	}{
+		{w: 0, want: 0},
+		{w: wgHeaderLen + 40, want: 0},
+		{w: wgHeaderLen + 41, want: 1},
+		{w: wgHeaderLen + 60, is6: true, want: 0},
+		{w: 1360, want: 1240},
+		{w: 1360, is6: true, want: 1220},
+		{w: 1500, want: 1380},
+		{w: 1500, is6: true, want: 1360},
+		{w: 9000, want: 8880},
+		{w: 1 << 20, want: 1<<16 - 1},
+	}
+	for _, tt := range tests {
+		if got := tcpMSSForWireMTU(tt.w, tt.is6); got != tt.want {
+			t.Errorf("tcpMSSForWireMTU(%v, %v) = %v; want %v", tt.w, tt.is6, got, tt.want)
+		}
+	}
+}
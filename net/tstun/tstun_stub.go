@@ -6,14 +6,19 @@
 package tstun
 
 import (
+	"fmt"
+	"runtime"
+
 	"github.com/tailscale/wireguard-go/tun"
 	"tailscale.com/types/logger"
 )
 
+// New returns an error, as wireguard-go has no tun driver for this
+// platform. Use userspace networking instead.
 func New(logf logger.Logf, tunName string) (tun.Device, string, error) {
-	panic("not implemented")
+	return nil, "", fmt.Errorf("tun devices are not supported on %s; use --tun=userspace-networking", runtime.GOOS)
 }
 
 func Diagnose(logf logger.Logf, tunName string, err error) {
-	panic("not implemented")
+	logf("no tun driver for %s; run tailscaled with --tun=userspace-networking", runtime.GOOS)
 }
@@ -45,7 +45,18 @@ func New(logf logger.Logf, tunName string) (tun.Device, string, error) {
 		}
 		dev, err = CreateTAP.Get()(logf, tapName, bridgeName)
 	} else {
+		if err := checkTUNName(runtime.GOOS, tunName); err != nil {
+			return nil, "", err
+		}
+		if tunInUse != nil {
+			if err := tunInUse(tunName); err != nil {
+				return nil, "", err
+			}
+		}
 		dev, err = tun.CreateTUN(tunName, int(DefaultTUNMTU()))
+		if err != nil {
+			err = explainCreateError(runtime.GOOS, tunName, err)
+		}
 	}
 	if err != nil {
 		return nil, "", err
@@ -62,9 +73,20 @@ func New(logf logger.Logf, tunName string) (tun.Device, string, error) {
 		dev.Close()
 		return nil, "", err
 	}
+	probeCaps(logf, dev, runtime.GOOS)
 	return dev, name, nil
 }
 
+// probeCaps records and logs the capabilities of dev.
+func probeCaps(logf logger.Logf, dev tun.Device, goos string) {
+	c := driverCaps(goos, kernelRelease())
+	c.DeviceBatchSize = dev.BatchSize()
+	c.IOMode = pickIOMode(c.DeviceBatchSize)
+	logf("tun capabilities: driver=%s os=%q multiqueue=%v modes=%v maxmtu=%d csum-offload=%v batch=%d io=%s",
+		c.Driver, c.OSVersion, c.MultiQueue, c.LinkLayerModes, c.MaxMTU, c.ChecksumOffload, c.DeviceBatchSize, c.IOMode)
+	lastCaps.Store(&c)
+}
+
 // tunDiagnoseFailure, if non-nil, does OS-specific diagnostics of why
 // TUN failed to work.
 var tunDiagnoseFailure func(tunName string, logf logger.Logf, err error)
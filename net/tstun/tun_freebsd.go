@@ -0,0 +1,54 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tstun
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"tailscale.com/hostinfo"
+	"tailscale.com/types/logger"
+)
+
+func init() {
+	tunDiagnoseFailure = diagnoseFreeBSDTUNFailure
+	tunInUse = freebsdTUNInUse
+}
+
+// freebsdTUNInUse returns an error if the tun interface named tunName is
+// open in another process. Creating a tun device with the name of an
+// existing interface destroys the interface, taking it from that process.
+func freebsdTUNInUse(tunName string) error {
+	out, err := exec.Command("ifconfig", tunName).Output()
+	if err != nil {
+		// No such interface.
+		return nil
+	}
+	if pid := tunOpenedBy(out); pid != 0 && pid != os.Getpid() {
+		return fmt.Errorf("tun device %s is in use by process %d; pick another with --tun", tunName, pid)
+	}
+	return nil
+}
+
+func diagnoseFreeBSDTUNFailure(tunName string, logf logger.Logf, createErr error) {
+	if j := hostinfo.GetJail(); j.Jailed {
+		if !j.VNET {
+			logf("running in a jail without VNET, which shares the host's network stack and can't create %s", tunName)
+			logf("either give the jail VNET (see 'tailscale configure jail') or run tailscaled with --tun=userspace-networking")
+			return
+		}
+		// Jails can't load kernel modules, and devfs hides tun devices
+		// from jails by default.
+		if _, err := os.Stat("/dev/tun"); err != nil {
+			logf("running in a VNET jail, but /dev/tun isn't visible; the jail's devfs_ruleset must unhide tun devices (see 'tailscale configure jail')")
+		} else {
+			logf("running in a VNET jail; make sure if_tun is loaded on the host ('kldload if_tun')")
+		}
+		return
+	}
+	if err := exec.Command("kldstat", "-q", "-m", "if_tun").Run(); err != nil {
+		logf("if_tun isn't loaded; 'kldload if_tun' (or if_tun_load=\"YES\" in /boot/loader.conf) may fix this")
+	}
+}
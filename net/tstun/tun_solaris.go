@@ -0,0 +1,31 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tstun
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+
+	"tailscale.com/types/logger"
+)
+
+func init() {
+	tunDiagnoseFailure = diagnoseSunosTUNFailure
+}
+
+func diagnoseSunosTUNFailure(tunName string, logf logger.Logf, createErr error) {
+	if out, err := exec.Command("zonename").Output(); err == nil {
+		if zone := strings.TrimSpace(string(out)); zone != "global" {
+			logf("running in non-global zone %q; the zone's configuration must pass through /dev/tun (zonecfg: add device; set match=/dev/tun; end)", zone)
+		}
+	}
+	if _, err := os.Stat("/dev/tun"); err != nil {
+		logf("/dev/tun does not exist; the tuntap driver isn't installed (on OmniOS: pkg install driver/network/tuntap)")
+		return
+	}
+	if out, err := exec.Command("modinfo").Output(); err == nil && !strings.Contains(string(out), " tun ") {
+		logf("/dev/tun exists, but the tun module isn't loaded")
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !wasm && !plan9 && !tamago && !aix
+
+package tstun
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// tunInUse, if non-nil, returns an error if the tun device named tunName
+// exists and is open in another process, for OSes where creating it would
+// otherwise take it over.
+var tunInUse func(tunName string) error
+
+// isFixedTUNOS reports whether goos has a fixed set of tun devices,
+// /dev/tun0, /dev/tun1 and so on, rather than cloning new ones with any name.
+func isFixedTUNOS(goos string) bool {
+	switch goos {
+	case "openbsd", "netbsd", "dragonfly":
+		return true
+	}
+	return false
+}
+
+// checkTUNName returns an error if tunName can't name a tun device on goos.
+func checkTUNName(goos, tunName string) error {
+	if !isFixedTUNOS(goos) || tunName == "tun" {
+		// "tun" picks the first free device.
+		return nil
+	}
+	if n, ok := strings.CutPrefix(tunName, "tun"); ok {
+		if _, err := strconv.ParseUint(n, 10, 16); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid tun device name %q; on %s, use a device such as tun3, or tun for the first free one", tunName, goos)
+}
+
+// explainCreateError returns err, the error creating the tun device named
+// tunName on goos, with an explanation of the common BSD failures.
+func explainCreateError(goos, tunName string, err error) error {
+	switch {
+	case goos != "freebsd" && !isFixedTUNOS(goos):
+		return err
+	case errors.Is(err, syscall.EBUSY):
+		return fmt.Errorf("tun device %s is in use by another program; pick another with --tun: %w", tunName, err)
+	case isFixedTUNOS(goos) && errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("no device node /dev/%s; create it with MAKEDEV(8) or pick another with --tun: %w", tunName, err)
+	}
+	return err
+}
+
+// tunOpenedBy returns the process ID that FreeBSD's ifconfig(8) output for
+// a tun interface says has it open, or 0 if none does.
+func tunOpenedBy(ifconfigOut []byte) int {
+	s := bufio.NewScanner(bytes.NewReader(ifconfigOut))
+	for s.Scan() {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(s.Text()), "Opened by PID "); ok {
+			pid, _ := strconv.Atoi(v)
+			return pid
+		}
+	}
+	return 0
+}
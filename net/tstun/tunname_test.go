@@ -0,0 +1,65 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tstun
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestCheckTUNName(t *testing.T) {
+	tests := []struct {
+		goos, name string
+		wantErr    bool
+	}{
+		{"netbsd", "tun", false},
+		{"netbsd", "tun3", false},
+		{"openbsd", "tun12", false},
+		{"netbsd", "tailscale0", true},
+		{"netbsd", "tun-a", true},
+		{"openbsd", "tun3x", true},
+		{"freebsd", "tailscale0", false},
+		{"linux", "tailscale0", false},
+	}
+	for _, tt := range tests {
+		err := checkTUNName(tt.goos, tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkTUNName(%q, %q) = %v; want error: %v", tt.goos, tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestExplainCreateError(t *testing.T) {
+	busy := &fs.PathError{Op: "open", Path: "/dev/tun3", Err: syscall.EBUSY}
+	err := explainCreateError("netbsd", "tun3", busy)
+	if !errors.Is(err, syscall.EBUSY) || !strings.Contains(err.Error(), "in use by another program") {
+		t.Errorf("busy on netbsd: %v", err)
+	}
+	missing := &fs.PathError{Op: "open", Path: "/dev/tun9", Err: syscall.ENOENT}
+	err = explainCreateError("openbsd", "tun9", missing)
+	if !errors.Is(err, fs.ErrNotExist) || !strings.Contains(err.Error(), "MAKEDEV") {
+		t.Errorf("missing on openbsd: %v", err)
+	}
+	if err := explainCreateError("linux", "tailscale0", busy); err != error(busy) {
+		t.Errorf("busy on linux = %v; want unchanged", err)
+	}
+}
+
+func TestTUNOpenedBy(t *testing.T) {
+	out := []byte(`tun3: flags=8051<UP,POINTOPOINT,RUNNING,MULTICAST> metric 0 mtu 1500
+	options=80000<LINKSTATE>
+	groups: tun
+	nd6 options=29<PERFORMNUD,IFDISABLED,AUTO_LINKLOCAL>
+	Opened by PID 4242
+`)
+	if got := tunOpenedBy(out); got != 4242 {
+		t.Errorf("tunOpenedBy = %d; want 4242", got)
+	}
+	if got := tunOpenedBy([]byte("tun3: flags=8010<POINTOPOINT,MULTICAST> metric 0 mtu 1500\n")); got != 0 {
+		t.Errorf("tunOpenedBy of closed tun = %d; want 0", got)
+	}
+}
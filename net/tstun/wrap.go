@@ -117,6 +117,10 @@ type Wrapper struct {
 	// peerConfig stores the current NAT configuration.
 	peerConfig atomic.Pointer[peerConfigTable]
 
+	// peerKeys maps the AllowedIPs of each peer to its node key, for
+	// PeerPathMTU lookups. It's nil if PeerPathMTU is nil.
+	peerKeys atomic.Pointer[bart.Table[key.NodePublic]]
+
 	// vectorBuffer stores the oldest unconsumed packet vector from tdev. It is
 	// allocated in wrap() and the underlying arrays should never grow.
 	vectorBuffer [][]byte
@@ -198,6 +202,13 @@ type Wrapper struct {
 	// running for the given IP address.
 	PeerAPIPort func(netip.Addr) (port uint16, ok bool)
 
+	// PeerPathMTU, if non-nil, returns the path MTU to the peer with the
+	// given node key, if it's known. TCP SYN and SYN-ACK packets to and
+	// from the peer have their MSS clamped to fit in it, so connections
+	// over paths smaller than the TUN MTU don't stall on large packets
+	// that never arrive. It must be set before SetWGConfig is called.
+	PeerPathMTU func(key.NodePublic) (mtu WireMTU, ok bool)
+
 	// disableFilter disables all filtering when set. This should only be used in tests.
 	disableFilter bool
 
@@ -806,6 +817,9 @@ func (t *Wrapper) SetWGConfig(wcfg *wgcfg.Config) {
 			sip.SetIP(findV4(wcfg.Addresses), findV6(wcfg.Addresses))
 		}
 	}
+	if t.PeerPathMTU != nil {
+		t.peerKeys.Store(peerKeysFromWGConfig(wcfg))
+	}
 	cfg := peerConfigTableFromWGConfig(wcfg)
 	old := t.peerConfig.Swap(cfg)
 	if !reflect.DeepEqual(old, cfg) {
@@ -813,6 +827,45 @@ func (t *Wrapper) SetWGConfig(wcfg *wgcfg.Config) {
 	}
 }
 
+// peerKeysFromWGConfig returns a table mapping the AllowedIPs of each peer
+// in wcfg to its node key.
+func peerKeysFromWGConfig(wcfg *wgcfg.Config) *bart.Table[key.NodePublic] {
+	ret := new(bart.Table[key.NodePublic])
+	if wcfg == nil {
+		return ret
+	}
+	for _, p := range wcfg.Peers {
+		for _, ip := range p.AllowedIPs {
+			ret.Insert(ip, p.PublicKey)
+		}
+	}
+	return ret
+}
+
+// clampPeerMSS clamps the MSS of p, if it's a TCP SYN or SYN-ACK to or
+// from the peer that handles peerIP, to fit in the path MTU to that peer.
+func (t *Wrapper) clampPeerMSS(p *packet.Parsed, peerIP netip.Addr) {
+	if p.IPProto != ipproto.TCP || p.TCPFlags&packet.TCPSyn == 0 || t.PeerPathMTU == nil {
+		return
+	}
+	peers := t.peerKeys.Load()
+	if peers == nil {
+		return
+	}
+	k, ok := peers.Lookup(peerIP)
+	if !ok {
+		return
+	}
+	mtu, ok := t.PeerPathMTU(k)
+	if !ok {
+		return
+	}
+	mss := tcpMSSForWireMTU(mtu, p.IPVersion == 6)
+	if mss != 0 && checksum.ClampTCPMSS(p, mss) {
+		metricPacketTCPMSSClamped.Add(1)
+	}
+}
+
 var (
 	magicDNSIPPort   = netip.AddrPortFrom(tsaddr.TailscaleServiceIP(), 0) // 100.100.100.100:0
 	magicDNSIPPortv6 = netip.AddrPortFrom(tsaddr.TailscaleServiceIPv6(), 0)
@@ -886,6 +939,8 @@ func (t *Wrapper) filterPacketOutboundToWireGuard(p *packet.Parsed, pc *peerConf
 		return filter.Drop, gro
 	}
 
+	t.clampPeerMSS(p, p.Dst.Addr())
+
 	if t.PostFilterPacketOutboundToWireGuard != nil {
 		if res := t.PostFilterPacketOutboundToWireGuard(p, t); res.IsDrop() {
 			return res, gro
@@ -1195,6 +1250,8 @@ func (t *Wrapper) filterPacketInboundFromWireGuard(p *packet.Parsed, captHook pa
 		return filter.Drop, gro
 	}
 
+	t.clampPeerMSS(p, p.Src.Addr())
+
 	if t.PostFilterPacketInboundFromWireGuard != nil {
 		var res filter.Response
 		res, gro = t.PostFilterPacketInboundFromWireGuard(p, t, gro)
@@ -1488,6 +1545,8 @@ func (t *Wrapper) SetStatistics(stats *connstats.Statistics) {
 	metricPacketOutDrop          = clientmetric.NewCounter("tstun_out_to_wg_drop")
 	metricPacketOutDropFilter    = clientmetric.NewCounter("tstun_out_to_wg_drop_filter")
 	metricPacketOutDropSelfDisco = clientmetric.NewCounter("tstun_out_to_wg_drop_self_disco")
+
+	metricPacketTCPMSSClamped = clientmetric.NewCounter("tstun_tcp_mss_clamped")
 )
 
 func (t *Wrapper) InstallCaptureHook(cb packet.CaptureCallback) {
@@ -958,3 +958,65 @@ type captureRecord struct {
 			captured, want)
 	}
 }
+
+// tcp4synMSS returns a TCP SYN packet from src to dst with an MSS option.
+func tcp4synMSS(src, dst string, mss uint16) []byte {
+	ipHeader := packet.IP4Header{
+		IPProto: ipproto.TCP,
+		Src:     netip.MustParseAddr(src),
+		Dst:     netip.MustParseAddr(dst),
+	}
+	tcpHeader := make([]byte, 24)
+	binary.BigEndian.PutUint16(tcpHeader[0:], 1234)
+	binary.BigEndian.PutUint16(tcpHeader[2:], 443)
+	tcpHeader[12] = 6 << 4 // data offset: 24 bytes
+	tcpHeader[13] |= 2     // SYN
+	tcpHeader[20], tcpHeader[21] = 2, 4
+	binary.BigEndian.PutUint16(tcpHeader[22:], mss)
+	return packet.Generate(ipHeader, tcpHeader)
+}
+
+func TestClampPeerMSS(t *testing.T) {
+	peer := key.NewNode().Public()
+	unprobed := key.NewNode().Public()
+	w := &Wrapper{
+		logf: t.Logf,
+		PeerPathMTU: func(k key.NodePublic) (WireMTU, bool) {
+			if k == peer {
+				return 1360, true
+			}
+			return 0, false
+		},
+	}
+	w.SetWGConfig(&wgcfg.Config{
+		Addresses: nets("100.64.0.1"),
+		Peers: []wgcfg.Peer{
+			{PublicKey: peer, AllowedIPs: nets("100.64.0.2", "10.0.0.0/8")},
+			{PublicKey: unprobed, AllowedIPs: nets("100.64.0.3")},
+		},
+	})
+
+	tests := []struct {
+		name    string
+		pkt     []byte
+		peerIP  string
+		wantMSS uint16
+	}{
+		{"to-peer", tcp4synMSS("100.64.0.1", "100.64.0.2", 8880), "100.64.0.2", 1240},
+		{"from-peer", tcp4synMSS("100.64.0.2", "100.64.0.1", 8880), "100.64.0.2", 1240},
+		{"peer-subnet-route", tcp4synMSS("100.64.0.1", "10.1.2.3", 1460), "10.1.2.3", 1240},
+		{"smaller-mss", tcp4synMSS("100.64.0.1", "100.64.0.2", 1200), "100.64.0.2", 1200},
+		{"unprobed-peer", tcp4synMSS("100.64.0.1", "100.64.0.3", 8880), "100.64.0.3", 8880},
+		{"no-peer", tcp4synMSS("100.64.0.1", "100.64.0.4", 8880), "100.64.0.4", 8880},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := new(packet.Parsed)
+			p.Decode(tt.pkt)
+			w.clampPeerMSS(p, netip.MustParseAddr(tt.peerIP))
+			if got := binary.BigEndian.Uint16(tt.pkt[20+22:]); got != tt.wantMSS {
+				t.Errorf("MSS = %d; want %d", got, tt.wantMSS)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package sessionrecording
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+// LocalFile is a session recording kept on the recorded node, rather than
+// uploaded to a recorder.
+type LocalFile struct {
+	Path    string    // absolute path of the asciinema cast file
+	Size    int64     // size in bytes, which grows while the session is open
+	ModTime time.Time // when last written to
+}
+
+// LocalDir returns the directory lr says to keep recordings in, given
+// tailscaled's state directory varRoot, which may be empty.
+func LocalDir(varRoot string, lr tailcfg.SSHLocalRecording) (string, error) {
+	if lr.Dir != "" {
+		if !filepath.IsAbs(lr.Dir) {
+			return "", fmt.Errorf("recording directory %q is not an absolute path", lr.Dir)
+		}
+		return lr.Dir, nil
+	}
+	if varRoot == "" {
+		return "", errors.New("no var root for recording storage")
+	}
+	return filepath.Join(varRoot, "ssh-sessions"), nil
+}
+
+// CreateLocal creates the file to record a session started at now in dir,
+// first deleting the oldest recordings there as needed to stay within
+// lr's MaxFiles and MaxBytes. It refuses to use a dir that other users
+// could swap for a symlink to elsewhere; see checkLocalDir.
+func CreateLocal(dir string, lr tailcfg.SSHLocalRecording, now time.Time) (*os.File, error) {
+	if err := checkLocalDir(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := pruneLocal(dir, lr); err != nil {
+		return nil, fmt.Errorf("deleting old recordings: %w", err)
+	}
+	return os.CreateTemp(dir, fmt.Sprintf("ssh-session-%v-*.cast", now.UnixNano()))
+}
+
+// ListLocal returns the recordings in dir, oldest first. It returns none,
+// rather than an error, if dir does not exist.
+func ListLocal(dir string) ([]LocalFile, error) {
+	des, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []LocalFile
+	for _, de := range des {
+		name := de.Name()
+		if !de.Type().IsRegular() || !strings.HasPrefix(name, "ssh-session-") || !strings.HasSuffix(name, ".cast") {
+			continue
+		}
+		fi, err := de.Info()
+		if os.IsNotExist(err) {
+			continue // deleted since ReadDir
+		}
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, LocalFile{
+			Path:    filepath.Join(dir, name),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+		})
+	}
+	slices.SortFunc(files, func(a, b LocalFile) int {
+		return cmp.Or(a.ModTime.Compare(b.ModTime), strings.Compare(a.Path, b.Path))
+	})
+	return files, nil
+}
+
+// pruneLocal deletes the oldest recordings in dir until there is room for
+// one more within lr's MaxFiles, and those left are within its MaxBytes.
+func pruneLocal(dir string, lr tailcfg.SSHLocalRecording) error {
+	if lr.MaxFiles <= 0 && lr.MaxBytes <= 0 {
+		return nil
+	}
+	files, err := ListLocal(dir)
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, f := range files {
+		total += f.Size
+	}
+	for len(files) > 0 && (lr.MaxFiles > 0 && len(files) >= lr.MaxFiles || lr.MaxBytes > 0 && total > lr.MaxBytes) {
+		if err := os.Remove(files[0].Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= files[0].Size
+		files = files[1:]
+	}
+	return nil
+}
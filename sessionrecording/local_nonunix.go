@@ -0,0 +1,12 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !unix
+
+package sessionrecording
+
+// checkLocalDir does nothing; Tailscale SSH, and so local recording, only
+// runs on unix platforms.
+func checkLocalDir(dir string) error {
+	return nil
+}
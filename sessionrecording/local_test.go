@@ -0,0 +1,91 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package sessionrecording
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+func TestLocalRecordings(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "ssh-sessions")
+	if got, err := ListLocal(dir); err != nil || len(got) != 0 {
+		t.Fatalf("ListLocal of missing dir = %v, %v; want none", got, err)
+	}
+
+	start := time.Unix(1700000000, 0)
+	var paths []string
+	create := func(lr tailcfg.SSHLocalRecording, size int) {
+		t.Helper()
+		now := start.Add(time.Duration(len(paths)) * time.Minute)
+		f, err := CreateLocal(dir, lr, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(make([]byte, size)); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(f.Name(), now, now); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, f.Name())
+	}
+	check := func(want ...int) {
+		t.Helper()
+		got, err := ListLocal(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("got %d recordings; want %d", len(got), len(want))
+		}
+		for i, f := range got {
+			if f.Path != paths[want[i]] {
+				t.Errorf("recording %d = %s; want %s", i, f.Path, paths[want[i]])
+			}
+		}
+	}
+
+	for range 4 {
+		create(tailcfg.SSHLocalRecording{}, 100)
+	}
+	// Other files are neither listed nor deleted.
+	other := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(other, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	check(0, 1, 2, 3)
+
+	create(tailcfg.SSHLocalRecording{MaxFiles: 3}, 100)
+	check(2, 3, 4)
+
+	create(tailcfg.SSHLocalRecording{MaxBytes: 250}, 100)
+	check(3, 4, 5)
+
+	if _, err := os.Stat(other); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLocalDir(t *testing.T) {
+	if got, err := LocalDir("/var/lib/tailscale", tailcfg.SSHLocalRecording{}); err != nil || got != filepath.Join("/var/lib/tailscale", "ssh-sessions") {
+		t.Errorf("default = %q, %v", got, err)
+	}
+	if got, err := LocalDir("", tailcfg.SSHLocalRecording{Dir: "/srv/recordings"}); err != nil || got != "/srv/recordings" {
+		t.Errorf("Dir = %q, %v", got, err)
+	}
+	if _, err := LocalDir("", tailcfg.SSHLocalRecording{}); err == nil {
+		t.Error("no error without var root or Dir")
+	}
+	if _, err := LocalDir("", tailcfg.SSHLocalRecording{Dir: "recordings"}); err == nil {
+		t.Error("no error for relative Dir")
+	}
+}
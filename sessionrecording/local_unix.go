@@ -0,0 +1,59 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build unix
+
+package sessionrecording
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// checkLocalDir reports an error if another user could redirect where
+// recordings in dir are written or which files are pruned from it. Like
+// sshd's StrictModes, each existing component of dir must be a directory,
+// not a symlink, owned by root or by us. A world-writable component is only
+// allowed if it's sticky (such as /tmp) and isn't dir itself, and the
+// component below it must already exist so that it isn't created in a
+// directory where anyone can create names.
+func checkLocalDir(dir string) error {
+	dir = filepath.Clean(dir)
+	rest := strings.Split(strings.TrimPrefix(dir, "/"), "/")
+	var inSticky string // world-writable sticky directory that p is in, if any
+	for p := "/"; ; p, rest = filepath.Join(p, rest[0]), rest[1:] {
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			if inSticky != "" {
+				return fmt.Errorf("recording directory %q would be created in world-writable %s", dir, inSticky)
+			}
+			return nil // the rest are created with mode 0700
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("recording directory %q: %s is a symlink", dir, p)
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("recording directory %q: %s is not a directory", dir, p)
+		}
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok && st.Uid != 0 && int(st.Uid) != os.Geteuid() {
+			return fmt.Errorf("recording directory %q: %s is owned by uid %d", dir, p, st.Uid)
+		}
+		inSticky = ""
+		if fi.Mode().Perm()&0o002 != 0 {
+			if p == dir || fi.Mode()&fs.ModeSticky == 0 {
+				return fmt.Errorf("recording directory %q: %s is world-writable", dir, p)
+			}
+			inSticky = p
+		}
+		if p == dir {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build unix
+
+package sessionrecording
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+func TestCheckLocalDir(t *testing.T) {
+	tmp := t.TempDir()
+	mkdir := func(name string, mode os.FileMode) string {
+		t.Helper()
+		p := filepath.Join(tmp, name)
+		if err := os.Mkdir(p, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(p, mode); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	plain := mkdir("plain", 0700)
+	link := filepath.Join(tmp, "link")
+	if err := os.Symlink(plain, link); err != nil {
+		t.Fatal(err)
+	}
+	open := mkdir("open", 0777)
+	sticky := mkdir("sticky", 0777|os.ModeSticky)
+	if err := os.Mkdir(filepath.Join(sticky, "mine"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		dir    string
+		wantOK bool
+	}{
+		{plain, true},
+		{filepath.Join(plain, "new", "sub"), true},
+		{link, false},
+		{filepath.Join(link, "sub"), false},
+		{open, false},
+		{filepath.Join(open, "sub"), false},
+		{sticky, false},
+		{filepath.Join(sticky, "new"), false},
+		{filepath.Join(sticky, "mine"), true},
+		{filepath.Join(sticky, "mine", "new"), true},
+	} {
+		err := checkLocalDir(tt.dir)
+		if gotOK := err == nil; gotOK != tt.wantOK {
+			t.Errorf("checkLocalDir(%q) = %v; want ok=%v", tt.dir, err, tt.wantOK)
+		}
+	}
+
+	if f, err := CreateLocal(filepath.Join(link, "sub"), tailcfg.SSHLocalRecording{}, time.Now()); err == nil {
+		f.Close()
+		t.Error("CreateLocal through a symlink succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(plain, "sub")); !os.IsNotExist(err) {
+		t.Errorf("CreateLocal created a directory through a symlink: %v", err)
+	}
+}
@@ -32,8 +32,6 @@
 
 	"github.com/creack/pty"
 	"github.com/pkg/sftp"
-	"github.com/u-root/u-root/pkg/termios"
-	gossh "golang.org/x/crypto/ssh"
 	"golang.org/x/sys/unix"
 	"tailscale.com/cmd/tailscaled/childproc"
 	"tailscale.com/hostinfo"
@@ -71,6 +69,16 @@ func init() {
 	return nil
 }
 
+// applyLoginClass sets up the resource limits, umask and priority of the
+// user's login class, returning environ with the variables the class sets.
+// It's called as root before dropping privileges, for sessions that aren't
+// started by login(1), which would otherwise do this itself.
+// This is best effort; errors are only logged.
+// See applyLoginClassBSD.
+var applyLoginClass = func(dlogf logger.Logf, ia incubatorArgs, environ []string) []string {
+	return environ
+}
+
 // newIncubatorCommand returns a new exec.Cmd configured with
 // `tailscaled be-child ssh` as the entrypoint.
 //
@@ -160,7 +168,13 @@ func (ss *sshSession) newIncubatorCommand(logf logger.Logf) (cmd *exec.Cmd, err
 	case isShell:
 		incubatorArgs = append(incubatorArgs, "--shell")
 	default:
-		incubatorArgs = append(incubatorArgs, "--cmd="+ss.RawCommand())
+		cmd := ss.RawCommand()
+		if scpCmd, ok := builtinSCPCommand(ss.conn.srv.tailscaledPath, cmd); ok {
+			logf("no scp on the host; serving scp with tailscaled")
+			metricBuiltinSCP.Add(1)
+			cmd = scpCmd
+		}
+		incubatorArgs = append(incubatorArgs, "--cmd="+cmd)
 	}
 
 	allowSendEnv := nm.HasCap(tailcfg.NodeAttrSSHEnvironmentVariables)
@@ -362,6 +376,7 @@ func handleSFTPInProcess(dlogf logger.Logf, ia incubatorArgs) error {
 		defer sessionCloser()
 	}
 
+	applyLoginClass(dlogf, ia, nil)
 	if err := dropPrivileges(dlogf, ia); err != nil {
 		return err
 	}
@@ -579,12 +594,13 @@ func handleSSHInProcess(dlogf logger.Logf, ia incubatorArgs) error {
 		defer sessionCloser()
 	}
 
-	if err := dropPrivileges(dlogf, ia); err != nil {
+	environ, _, err := ia.forwadedEnviron()
+	if err != nil {
 		return err
 	}
+	environ = applyLoginClass(dlogf, ia, environ)
 
-	environ, _, err := ia.forwadedEnviron()
-	if err != nil {
+	if err := dropPrivileges(dlogf, ia); err != nil {
 		return err
 	}
 
@@ -830,68 +846,6 @@ func resizeWindow(fd int, winCh <-chan ssh.Window) {
 	}
 }
 
-// opcodeShortName is a mapping of SSH opcode
-// to mnemonic names expected by the termios package.
-// These are meant to be platform independent.
-var opcodeShortName = map[uint8]string{
-	gossh.VINTR:         "intr",
-	gossh.VQUIT:         "quit",
-	gossh.VERASE:        "erase",
-	gossh.VKILL:         "kill",
-	gossh.VEOF:          "eof",
-	gossh.VEOL:          "eol",
-	gossh.VEOL2:         "eol2",
-	gossh.VSTART:        "start",
-	gossh.VSTOP:         "stop",
-	gossh.VSUSP:         "susp",
-	gossh.VDSUSP:        "dsusp",
-	gossh.VREPRINT:      "rprnt",
-	gossh.VWERASE:       "werase",
-	gossh.VLNEXT:        "lnext",
-	gossh.VFLUSH:        "flush",
-	gossh.VSWTCH:        "swtch",
-	gossh.VSTATUS:       "status",
-	gossh.VDISCARD:      "discard",
-	gossh.IGNPAR:        "ignpar",
-	gossh.PARMRK:        "parmrk",
-	gossh.INPCK:         "inpck",
-	gossh.ISTRIP:        "istrip",
-	gossh.INLCR:         "inlcr",
-	gossh.IGNCR:         "igncr",
-	gossh.ICRNL:         "icrnl",
-	gossh.IUCLC:         "iuclc",
-	gossh.IXON:          "ixon",
-	gossh.IXANY:         "ixany",
-	gossh.IXOFF:         "ixoff",
-	gossh.IMAXBEL:       "imaxbel",
-	gossh.IUTF8:         "iutf8",
-	gossh.ISIG:          "isig",
-	gossh.ICANON:        "icanon",
-	gossh.XCASE:         "xcase",
-	gossh.ECHO:          "echo",
-	gossh.ECHOE:         "echoe",
-	gossh.ECHOK:         "echok",
-	gossh.ECHONL:        "echonl",
-	gossh.NOFLSH:        "noflsh",
-	gossh.TOSTOP:        "tostop",
-	gossh.IEXTEN:        "iexten",
-	gossh.ECHOCTL:       "echoctl",
-	gossh.ECHOKE:        "echoke",
-	gossh.PENDIN:        "pendin",
-	gossh.OPOST:         "opost",
-	gossh.OLCUC:         "olcuc",
-	gossh.ONLCR:         "onlcr",
-	gossh.OCRNL:         "ocrnl",
-	gossh.ONOCR:         "onocr",
-	gossh.ONLRET:        "onlret",
-	gossh.CS7:           "cs7",
-	gossh.CS8:           "cs8",
-	gossh.PARENB:        "parenb",
-	gossh.PARODD:        "parodd",
-	gossh.TTY_OP_ISPEED: "tty_op_ispeed",
-	gossh.TTY_OP_OSPEED: "tty_op_ospeed",
-}
-
 // startWithPTY starts cmd with a pseudo-terminal attached to Stdin, Stdout and Stderr.
 func (ss *sshSession) startWithPTY() (ptyFile, tty *os.File, err error) {
 	ptyReq := ss.ptyReq
@@ -920,48 +874,7 @@ func (ss *sshSession) startWithPTY() (ptyFile, tty *os.File, err error) {
 	}
 	var ctlErr error
 	if err := ptyRawConn.Control(func(fd uintptr) {
-		// Load existing PTY settings to modify them & save them back.
-		tios, err := termios.GTTY(int(fd))
-		if err != nil {
-			ctlErr = fmt.Errorf("GTTY: %w", err)
-			return
-		}
-
-		// Set the rows & cols to those advertised from the ptyReq frame
-		// received over SSH.
-		tios.Row = int(ptyReq.Window.Height)
-		tios.Col = int(ptyReq.Window.Width)
-
-		for c, v := range ptyReq.Modes {
-			if c == gossh.TTY_OP_ISPEED {
-				tios.Ispeed = int(v)
-				continue
-			}
-			if c == gossh.TTY_OP_OSPEED {
-				tios.Ospeed = int(v)
-				continue
-			}
-			k, ok := opcodeShortName[c]
-			if !ok {
-				ss.vlogf("unknown opcode: %d", c)
-				continue
-			}
-			if _, ok := tios.CC[k]; ok {
-				tios.CC[k] = uint8(v)
-				continue
-			}
-			if _, ok := tios.Opts[k]; ok {
-				tios.Opts[k] = v > 0
-				continue
-			}
-			ss.vlogf("unsupported opcode: %v(%d)=%v", k, c, v)
-		}
-
-		// Save PTY settings.
-		if _, err := tios.STTY(int(fd)); err != nil {
-			ctlErr = fmt.Errorf("STTY: %w", err)
-			return
-		}
+		ctlErr = setPTYModes(int(fd), ptyReq, ss.vlogf)
 	}); err != nil {
 		return nil, nil, fmt.Errorf("ptyRawConn.Control: %w", err)
 	}
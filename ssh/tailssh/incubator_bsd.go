@@ -0,0 +1,115 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build freebsd || openbsd || netbsd
+
+package tailssh
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+	"tailscale.com/types/logger"
+)
+
+func init() {
+	applyLoginClass = applyLoginClassBSD
+}
+
+// loginClassLimit is a resource limit capability of login.conf(5) and the
+// rlimit it sets.
+type loginClassLimit struct {
+	name     string
+	resource int
+	parse    func(string) (uint64, error)
+}
+
+// loginClassLimits are the limits common to FreeBSD, NetBSD and OpenBSD.
+var loginClassLimits = []loginClassLimit{
+	{"cputime", unix.RLIMIT_CPU, parseLoginCapTime},
+	{"filesize", unix.RLIMIT_FSIZE, parseLoginCapSize},
+	{"datasize", unix.RLIMIT_DATA, parseLoginCapSize},
+	{"stacksize", unix.RLIMIT_STACK, parseLoginCapSize},
+	{"coredumpsize", unix.RLIMIT_CORE, parseLoginCapSize},
+	{"memoryuse", unix.RLIMIT_RSS, parseLoginCapSize},
+	{"memorylocked", unix.RLIMIT_MEMLOCK, parseLoginCapSize},
+	{"maxproc", unix.RLIMIT_NPROC, parseLoginCapCount},
+	{"openfiles", unix.RLIMIT_NOFILE, parseLoginCapCount},
+}
+
+// applyLoginClassBSD applies the user's login class from /etc/login.conf,
+// as setusercontext(3) would. Shells with a TTY are started with login(1),
+// which does this and also records the session in utmpx, but commands,
+// shells without a TTY and SFTP are started by the incubator itself.
+func applyLoginClassBSD(dlogf logger.Logf, ia incubatorArgs, environ []string) []string {
+	if os.Geteuid() != 0 {
+		// We can't read the user's class, and couldn't raise their
+		// limits anyway.
+		return environ
+	}
+
+	class := ""
+	if f, err := os.Open("/etc/master.passwd"); err == nil {
+		class, err = loginClassFromMasterPasswd(f, ia.localUser)
+		f.Close()
+		if err != nil {
+			dlogf("login class: %v", err)
+		}
+	}
+	f, err := os.Open("/etc/login.conf")
+	if err != nil {
+		dlogf("login class: %v", err)
+		return environ
+	}
+	db, err := parseLoginCapDB(f)
+	f.Close()
+	if err != nil {
+		dlogf("login class: parsing login.conf: %v", err)
+		return environ
+	}
+	caps, class := db.userClass(class, ia.uid)
+	dlogf("applying login class %q", class)
+
+	for _, l := range loginClassLimits {
+		var rl unix.Rlimit
+		if err := unix.Getrlimit(l.resource, &rl); err != nil {
+			dlogf("login class: getrlimit(%s): %v", l.name, err)
+			continue
+		}
+		cur, max, ok, err := caps.limit(l.name, l.parse, uint64(rl.Cur), uint64(rl.Max))
+		if err != nil {
+			dlogf("login class %q: %v", class, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		setRlimitValue(&rl.Cur, cur)
+		setRlimitValue(&rl.Max, max)
+		if err := unix.Setrlimit(l.resource, &rl); err != nil {
+			dlogf("login class: setrlimit(%s): %v", l.name, err)
+		}
+	}
+
+	if n, ok, err := caps.num("umask"); err != nil {
+		dlogf("login class %q: %v", class, err)
+	} else if ok {
+		unix.Umask(int(n))
+	}
+	if n, ok, err := caps.num("priority"); err != nil {
+		dlogf("login class %q: %v", class, err)
+	} else if ok {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, 0, int(n)); err != nil {
+			dlogf("login class: setpriority: %v", err)
+		}
+	}
+
+	return loginClassEnviron(caps, ia.localUser, ia.homeDir, environ)
+}
+
+// setRlimitValue sets a field of unix.Rlimit, which is signed on FreeBSD
+// and unsigned on NetBSD and OpenBSD. v is at most loginCapInfinity, so it
+// fits both.
+func setRlimitValue[T int64 | uint64](p *T, v uint64) {
+	*p = T(v)
+}
@@ -0,0 +1,31 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build freebsd || openbsd || netbsd
+
+package tailssh
+
+import (
+	"runtime"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestLoginClassLimits(t *testing.T) {
+	seen := map[string]bool{}
+	for _, l := range loginClassLimits {
+		if seen[l.name] {
+			t.Errorf("duplicate limit %q", l.name)
+		}
+		seen[l.name] = true
+		var rl unix.Rlimit
+		if err := unix.Getrlimit(l.resource, &rl); err != nil {
+			t.Errorf("getrlimit(%s): %v", l.name, err)
+		}
+	}
+	// OpenBSD has no RLIMIT_AS.
+	if want := runtime.GOOS != "openbsd"; seen["vmemoryuse"] != want {
+		t.Errorf("vmemoryuse limit = %v; want %v", seen["vmemoryuse"], want)
+	}
+}
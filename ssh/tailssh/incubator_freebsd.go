@@ -0,0 +1,10 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tailssh
+
+import "golang.org/x/sys/unix"
+
+func init() {
+	loginClassLimits = append(loginClassLimits, loginClassLimit{"vmemoryuse", unix.RLIMIT_AS, parseLoginCapSize})
+}
@@ -0,0 +1,395 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux || (darwin && !ios) || freebsd || openbsd || netbsd
+
+package tailssh
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// This file parses the login class capability database, login.conf(5), that
+// the BSDs use to set up user sessions. Only incubator_bsd.go uses it, but
+// nothing here is BSD-specific, so it's tested on every platform.
+
+// loginCapInfinity is the value of a resource limit of "infinity", the same
+// as RLIM_INFINITY on FreeBSD, NetBSD and OpenBSD.
+const loginCapInfinity = math.MaxInt64
+
+// loginCapDB is a login class capability database in the getcap(3) format.
+// Each record is a line of ':'-separated fields, the first of which lists
+// the names of the record separated by '|'. The map is keyed by name, and
+// the values are the remaining fields, still escaped.
+type loginCapDB map[string][]string
+
+// loginCaps is the ordered list of capabilities of a login class. The first
+// occurrence of a capability wins, so a class can override capabilities it
+// includes with tc= by listing them first.
+type loginCaps []string
+
+// parseLoginCapDB parses a login.conf(5) file.
+func parseLoginCapDB(r io.Reader) (loginCapDB, error) {
+	db := make(loginCapDB)
+	var rec strings.Builder
+	flush := func() {
+		fields := splitLoginCapFields(rec.String())
+		rec.Reset()
+		if len(fields) == 0 || fields[0] == "" {
+			return
+		}
+		var caps []string
+		for _, f := range fields[1:] {
+			if f != "" {
+				caps = append(caps, f)
+			}
+		}
+		for _, name := range strings.Split(fields[0], "|") {
+			if _, ok := db[name]; !ok {
+				db[name] = caps
+			}
+		}
+	}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if rec.Len() == 0 && (strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "") {
+			continue
+		}
+		if s, ok := strings.CutSuffix(line, `\`); ok {
+			rec.WriteString(s)
+			continue
+		}
+		rec.WriteString(line)
+		flush()
+	}
+	flush()
+	return db, sc.Err()
+}
+
+// splitLoginCapFields splits a record on the colons that aren't escaped
+// with a backslash, trimming the whitespace around each field.
+func splitLoginCapFields(rec string) []string {
+	var fields []string
+	start := 0
+	for i := 0; i < len(rec); i++ {
+		switch rec[i] {
+		case '\\':
+			i++
+		case ':':
+			fields = append(fields, strings.TrimSpace(rec[start:i]))
+			start = i + 1
+		}
+	}
+	if s := strings.TrimSpace(rec[start:]); s != "" || len(fields) > 0 {
+		fields = append(fields, s)
+	}
+	return fields
+}
+
+// class returns the capabilities of the named class, with the records it
+// includes with tc= expanded in place. It reports whether the class exists.
+func (db loginCapDB) class(name string) (loginCaps, bool) {
+	return db.expand(name, 0)
+}
+
+func (db loginCapDB) expand(name string, depth int) (loginCaps, bool) {
+	fields, ok := db[name]
+	if !ok || depth > 32 {
+		return nil, false
+	}
+	var caps loginCaps
+	for _, f := range fields {
+		if tc, ok := strings.CutPrefix(f, "tc="); ok {
+			inc, _ := db.expand(tc, depth+1)
+			caps = append(caps, inc...)
+			continue
+		}
+		caps = append(caps, f)
+	}
+	return caps, true
+}
+
+// userClass returns the capabilities for a user whose password entry names
+// class, along with the name of the class that was used. As in
+// login_getpwclass(3), an empty class means "root" for uid 0 if there's
+// such a class, and anything not in the database means "default".
+func (db loginCapDB) userClass(class string, uid int) (loginCaps, string) {
+	if class == "" && uid == 0 {
+		if _, ok := db["root"]; ok {
+			class = "root"
+		}
+	}
+	if class != "" {
+		if caps, ok := db.class(class); ok {
+			return caps, class
+		}
+	}
+	caps, _ := db.class("default")
+	return caps, "default"
+}
+
+// value returns the value of the first capability named name, which may be
+// given as name=value or name#value. Boolean and cancelled (name@)
+// capabilities have no value.
+func (c loginCaps) value(name string) (string, bool) {
+	for _, f := range c {
+		rest, ok := strings.CutPrefix(f, name)
+		if !ok {
+			continue
+		}
+		if rest == "" || rest == "@" {
+			return "", false
+		}
+		if rest[0] == '=' || rest[0] == '#' {
+			return unescapeLoginCap(rest[1:]), true
+		}
+	}
+	return "", false
+}
+
+// num returns the value of the numeric capability name. Like strtol(3) with
+// a base of 0, a leading 0 means octal and 0x means hexadecimal.
+func (c loginCaps) num(name string) (int64, bool, error) {
+	v, ok := c.value(name)
+	if !ok {
+		return 0, false, nil
+	}
+	if isLoginCapInfinity(v) {
+		return loginCapInfinity, true, nil
+	}
+	n, err := strconv.ParseInt(v, 0, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", name, err)
+	}
+	return n, true, nil
+}
+
+// limit returns the resource limit set by the capability name and its
+// name-cur and name-max variants, starting from the current limits.
+// The parse func is one of parseLoginCapSize, parseLoginCapTime or
+// parseLoginCapCount. It reports whether the class sets the limit at all.
+func (c loginCaps) limit(name string, parse func(string) (uint64, error), cur, max uint64) (newCur, newMax uint64, ok bool, err error) {
+	for _, v := range []struct {
+		suffix   string
+		cur, max bool
+	}{
+		{"", true, true},
+		{"-cur", true, false},
+		{"-max", false, true},
+	} {
+		s, found := c.value(name + v.suffix)
+		if !found {
+			continue
+		}
+		n, err := parse(s)
+		if err != nil {
+			return 0, 0, false, fmt.Errorf("%s%s: %w", name, v.suffix, err)
+		}
+		if v.cur {
+			cur = n
+		}
+		if v.max {
+			max = n
+		}
+		ok = true
+	}
+	return min(cur, max), max, ok, nil
+}
+
+// unescapeLoginCap undoes the getcap(3) escapes in a capability value.
+func unescapeLoginCap(s string) string {
+	if !strings.ContainsAny(s, `\^`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch c = s[i]; c {
+			case 'E', 'e':
+				c = '\x1b'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			}
+		case c == '^' && i+1 < len(s):
+			i++
+			c = s[i] & 037
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+func isLoginCapInfinity(s string) bool {
+	switch strings.ToLower(s) {
+	case "infinity", "inf", "unlimited", "unlimit":
+		return true
+	}
+	return false
+}
+
+// parseLoginCapUnits parses a sum of numbers with optional unit suffixes,
+// such as "1g512m" or "1h30m", saturating at loginCapInfinity.
+func parseLoginCapUnits(s string, units map[byte]uint64) (uint64, error) {
+	if isLoginCapInfinity(s) {
+		return loginCapInfinity, nil
+	}
+	if s == "" {
+		return 0, fmt.Errorf("empty value")
+	}
+	var total uint64
+	for rest := s; rest != ""; {
+		i := 0
+		for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+			i++
+		}
+		if i == 0 {
+			return 0, fmt.Errorf("invalid value %q", s)
+		}
+		n, err := strconv.ParseUint(rest[:i], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid value %q", s)
+		}
+		unit := uint64(1)
+		if i < len(rest) {
+			u, ok := units[rest[i]|0x20] // lowercase
+			if !ok {
+				return 0, fmt.Errorf("invalid unit in %q", s)
+			}
+			unit = u
+			i++
+		}
+		rest = rest[i:]
+		if n > loginCapInfinity/unit || total+n*unit > loginCapInfinity {
+			return loginCapInfinity, nil
+		}
+		total += n * unit
+	}
+	return total, nil
+}
+
+var (
+	loginCapSizeUnits = map[byte]uint64{
+		'b': 512,
+		'k': 1 << 10,
+		'm': 1 << 20,
+		'g': 1 << 30,
+		't': 1 << 40,
+	}
+	loginCapTimeUnits = map[byte]uint64{
+		's': 1,
+		'm': 60,
+		'h': 60 * 60,
+		'd': 24 * 60 * 60,
+		'w': 7 * 24 * 60 * 60,
+		'y': 365 * 24 * 60 * 60,
+	}
+)
+
+// parseLoginCapSize parses a size in bytes, as used by limits like datasize.
+func parseLoginCapSize(s string) (uint64, error) {
+	return parseLoginCapUnits(s, loginCapSizeUnits)
+}
+
+// parseLoginCapTime parses a duration in seconds, as used by cputime.
+func parseLoginCapTime(s string) (uint64, error) {
+	return parseLoginCapUnits(s, loginCapTimeUnits)
+}
+
+// parseLoginCapCount parses a plain count, as used by limits like openfiles.
+func parseLoginCapCount(s string) (uint64, error) {
+	return parseLoginCapUnits(s, nil)
+}
+
+// loginClassEnviron returns environ with the variables that the class
+// caps sets, as setclassenvironment(3) does for login(1). In values, a
+// leading '~' is replaced by the user's home directory and '$' by their
+// user name. The class's PATH replaces the one we inherited from
+// tailscaled; other variables are left alone if already set.
+func loginClassEnviron(caps loginCaps, user, home string, environ []string) []string {
+	subst := func(v string) string {
+		if rest, ok := strings.CutPrefix(v, "~"); ok {
+			v = strings.TrimSuffix(home, "/") + "/" + strings.TrimPrefix(rest, "/")
+		}
+		return strings.ReplaceAll(v, "$", user)
+	}
+	set := func(key, val string, override bool) {
+		for i, kv := range environ {
+			if k, _, _ := strings.Cut(kv, "="); k == key {
+				if override {
+					environ[i] = key + "=" + val
+				}
+				return
+			}
+		}
+		environ = append(environ, key+"="+val)
+	}
+
+	for _, v := range []struct{ cap, env string }{
+		{"path", "PATH"},
+		{"cdpath", "CDPATH"},
+		{"manpath", "MANPATH"},
+	} {
+		s, ok := caps.value(v.cap)
+		if !ok {
+			continue
+		}
+		var dirs []string
+		for _, d := range strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == '\t' || r == ',' }) {
+			dirs = append(dirs, subst(d))
+		}
+		set(v.env, strings.Join(dirs, ":"), v.env == "PATH")
+	}
+	for _, v := range []struct{ cap, env string }{
+		{"lang", "LANG"},
+		{"charset", "MM_CHARSET"},
+		{"mail", "MAIL"},
+		{"timezone", "TZ"},
+		{"term", "TERM"},
+	} {
+		if s, ok := caps.value(v.cap); ok {
+			set(v.env, subst(s), false)
+		}
+	}
+	if s, ok := caps.value("setenv"); ok {
+		for _, kv := range strings.Split(s, ",") {
+			k, v, ok := strings.Cut(strings.TrimSpace(kv), "=")
+			if ok && k != "" {
+				set(k, subst(v), false)
+			}
+		}
+	}
+	return environ
+}
+
+// loginClassFromMasterPasswd returns the login class of user from a
+// master.passwd(5) file, which is "" if the entry doesn't name one.
+func loginClassFromMasterPasswd(r io.Reader, user string) (string, error) {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		fields := strings.Split(sc.Text(), ":")
+		if len(fields) >= 10 && fields[0] == user {
+			return fields[4], nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("user %q not found", user)
+}
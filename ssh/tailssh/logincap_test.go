@@ -0,0 +1,153 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux || (darwin && !ios) || freebsd || openbsd || netbsd
+
+package tailssh
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testLoginConf = `# A comment.
+default:\
+	:path=/sbin /bin ~/bin:\
+	:umask=022:\
+	:datasize-cur=512m:\
+	:datasize-max=1g:\
+	:openfiles=1024:\
+	:lang=C.UTF-8:\
+	:setenv=BLOCKSIZE=K,MAIL=/var/mail/$:\
+	:priority=0:
+
+staff|Staff Users:\
+	:openfiles@:\
+	:cputime=1h30m:\
+	:tc=default:
+
+root:\
+	:ignorenologin:\
+	:lang=en\:US:\
+	:tc=default:
+
+loop:\
+	:tc=loop:
+`
+
+func TestLoginCapDB(t *testing.T) {
+	db, err := parseLoginCapDB(strings.NewReader(testLoginConf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		class string
+		uid   int
+		want  string
+	}{
+		{"", 1000, "default"},
+		{"", 0, "root"},
+		{"staff", 1000, "staff"},
+		{"Staff Users", 1000, "Staff Users"},
+		{"nope", 1000, "default"},
+		{"loop", 1000, "loop"},
+	}
+	for _, tt := range tests {
+		if _, got := db.userClass(tt.class, tt.uid); got != tt.want {
+			t.Errorf("userClass(%q, %d) = %q; want %q", tt.class, tt.uid, got, tt.want)
+		}
+	}
+
+	staff, _ := db.class("staff")
+	if v, ok := staff.value("openfiles"); ok {
+		t.Errorf("staff openfiles = %q; want cancelled", v)
+	}
+	if v, ok := staff.value("umask"); !ok || v != "022" {
+		t.Errorf("staff umask = %q, %v; want inherited 022", v, ok)
+	}
+	if n, ok, err := staff.num("umask"); err != nil || !ok || n != 0o22 {
+		t.Errorf("staff umask num = %o, %v, %v; want 22", n, ok, err)
+	}
+	root, _ := db.class("root")
+	if v, ok := root.value("lang"); v != "en:US" {
+		t.Errorf("root lang = %q, %v; want %q", v, ok, "en:US")
+	}
+	if v, ok := root.value("ignorenologin"); ok {
+		t.Errorf("boolean capability has value %q", v)
+	}
+
+	cur, max, ok, err := staff.limit("datasize", parseLoginCapSize, 1, loginCapInfinity)
+	if err != nil || !ok || cur != 512<<20 || max != 1<<30 {
+		t.Errorf("datasize = %d, %d, %v, %v", cur, max, ok, err)
+	}
+	cur, max, ok, err = staff.limit("cputime", parseLoginCapTime, 1, 2)
+	if err != nil || !ok || cur != 5400 || max != 5400 {
+		t.Errorf("cputime = %d, %d, %v, %v", cur, max, ok, err)
+	}
+	if _, _, ok, _ := staff.limit("openfiles", parseLoginCapCount, 1, 2); ok {
+		t.Errorf("cancelled openfiles was applied")
+	}
+}
+
+func TestParseLoginCapUnits(t *testing.T) {
+	tests := []struct {
+		parse   func(string) (uint64, error)
+		in      string
+		want    uint64
+		wantErr bool
+	}{
+		{parseLoginCapSize, "100", 100, false},
+		{parseLoginCapSize, "2b", 1024, false},
+		{parseLoginCapSize, "1G512M", 3 << 29, false},
+		{parseLoginCapSize, "infinity", loginCapInfinity, false},
+		{parseLoginCapSize, "99999999t", loginCapInfinity, false},
+		{parseLoginCapSize, "1x", 0, true},
+		{parseLoginCapSize, "", 0, true},
+		{parseLoginCapTime, "1w1d", 8 * 24 * 3600, false},
+		{parseLoginCapTime, "30", 30, false},
+		{parseLoginCapCount, "unlimited", loginCapInfinity, false},
+		{parseLoginCapCount, "10k", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := tt.parse(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parse(%q) = %d, %v; want %d, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestLoginClassEnviron(t *testing.T) {
+	db, err := parseLoginCapDB(strings.NewReader(testLoginConf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	caps, _ := db.class("default")
+	got := loginClassEnviron(caps, "alice", "/home/alice", []string{"PATH=/usr/bin", "LANG=fr_FR", "SSH_AUTH_SOCK=/tmp/x"})
+	want := []string{
+		"PATH=/sbin:/bin:/home/alice/bin",
+		"LANG=fr_FR",
+		"SSH_AUTH_SOCK=/tmp/x",
+		"BLOCKSIZE=K",
+		"MAIL=/var/mail/alice",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q\nwant %q", got, want)
+	}
+}
+
+func TestLoginClassFromMasterPasswd(t *testing.T) {
+	const passwd = `root:*:0:0::0:0:Charlie &:/root:/bin/sh
+alice:*:1001:1001:staff:0:0:Alice:/home/alice:/bin/sh
+`
+	for user, want := range map[string]string{"root": "", "alice": "staff"} {
+		got, err := loginClassFromMasterPasswd(strings.NewReader(passwd), user)
+		if err != nil || got != want {
+			t.Errorf("%s: got %q, %v; want %q", user, got, err, want)
+		}
+	}
+	if _, err := loginClassFromMasterPasswd(strings.NewReader(passwd), "bob"); err == nil {
+		t.Error("bob: no error")
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux || (darwin && !ios) || freebsd || openbsd
+
+package tailssh
+
+import (
+	"fmt"
+
+	"github.com/u-root/u-root/pkg/termios"
+	gossh "golang.org/x/crypto/ssh"
+	"tailscale.com/tempfork/gliderlabs/ssh"
+	"tailscale.com/types/logger"
+)
+
+// opcodeShortName is a mapping of SSH opcode
+// to mnemonic names expected by the termios package.
+// These are meant to be platform independent.
+var opcodeShortName = map[uint8]string{
+	gossh.VINTR:         "intr",
+	gossh.VQUIT:         "quit",
+	gossh.VERASE:        "erase",
+	gossh.VKILL:         "kill",
+	gossh.VEOF:          "eof",
+	gossh.VEOL:          "eol",
+	gossh.VEOL2:         "eol2",
+	gossh.VSTART:        "start",
+	gossh.VSTOP:         "stop",
+	gossh.VSUSP:         "susp",
+	gossh.VDSUSP:        "dsusp",
+	gossh.VREPRINT:      "rprnt",
+	gossh.VWERASE:       "werase",
+	gossh.VLNEXT:        "lnext",
+	gossh.VFLUSH:        "flush",
+	gossh.VSWTCH:        "swtch",
+	gossh.VSTATUS:       "status",
+	gossh.VDISCARD:      "discard",
+	gossh.IGNPAR:        "ignpar",
+	gossh.PARMRK:        "parmrk",
+	gossh.INPCK:         "inpck",
+	gossh.ISTRIP:        "istrip",
+	gossh.INLCR:         "inlcr",
+	gossh.IGNCR:         "igncr",
+	gossh.ICRNL:         "icrnl",
+	gossh.IUCLC:         "iuclc",
+	gossh.IXON:          "ixon",
+	gossh.IXANY:         "ixany",
+	gossh.IXOFF:         "ixoff",
+	gossh.IMAXBEL:       "imaxbel",
+	gossh.IUTF8:         "iutf8",
+	gossh.ISIG:          "isig",
+	gossh.ICANON:        "icanon",
+	gossh.XCASE:         "xcase",
+	gossh.ECHO:          "echo",
+	gossh.ECHOE:         "echoe",
+	gossh.ECHOK:         "echok",
+	gossh.ECHONL:        "echonl",
+	gossh.NOFLSH:        "noflsh",
+	gossh.TOSTOP:        "tostop",
+	gossh.IEXTEN:        "iexten",
+	gossh.ECHOCTL:       "echoctl",
+	gossh.ECHOKE:        "echoke",
+	gossh.PENDIN:        "pendin",
+	gossh.OPOST:         "opost",
+	gossh.OLCUC:         "olcuc",
+	gossh.ONLCR:         "onlcr",
+	gossh.OCRNL:         "ocrnl",
+	gossh.ONOCR:         "onocr",
+	gossh.ONLRET:        "onlret",
+	gossh.CS7:           "cs7",
+	gossh.CS8:           "cs8",
+	gossh.PARENB:        "parenb",
+	gossh.PARODD:        "parodd",
+	gossh.TTY_OP_ISPEED: "tty_op_ispeed",
+	gossh.TTY_OP_OSPEED: "tty_op_ospeed",
+}
+
+// setPTYModes applies the window size and terminal modes of ptyReq to the
+// terminal fd.
+func setPTYModes(fd int, ptyReq *ssh.Pty, vlogf logger.Logf) error {
+	// Load existing PTY settings to modify them & save them back.
+	tios, err := termios.GTTY(fd)
+	if err != nil {
+		return fmt.Errorf("GTTY: %w", err)
+	}
+
+	// Set the rows & cols to those advertised from the ptyReq frame
+	// received over SSH.
+	tios.Row = int(ptyReq.Window.Height)
+	tios.Col = int(ptyReq.Window.Width)
+
+	for c, v := range ptyReq.Modes {
+		if c == gossh.TTY_OP_ISPEED {
+			tios.Ispeed = int(v)
+			continue
+		}
+		if c == gossh.TTY_OP_OSPEED {
+			tios.Ospeed = int(v)
+			continue
+		}
+		k, ok := opcodeShortName[c]
+		if !ok {
+			vlogf("unknown opcode: %d", c)
+			continue
+		}
+		if _, ok := tios.CC[k]; ok {
+			tios.CC[k] = uint8(v)
+			continue
+		}
+		if _, ok := tios.Opts[k]; ok {
+			tios.Opts[k] = v > 0
+			continue
+		}
+		vlogf("unsupported opcode: %v(%d)=%v", k, c, v)
+	}
+
+	// Save PTY settings.
+	if _, err := tios.STTY(fd); err != nil {
+		return fmt.Errorf("STTY: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build (darwin && !ios) || freebsd || openbsd || netbsd
+
+package tailssh
+
+import "golang.org/x/sys/unix"
+
+const ioctlGetTermios = unix.TIOCGETA
@@ -0,0 +1,8 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tailssh
+
+import "golang.org/x/sys/unix"
+
+const ioctlGetTermios = unix.TCGETS
@@ -0,0 +1,125 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tailssh
+
+import (
+	"fmt"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/sys/unix"
+	"tailscale.com/tempfork/gliderlabs/ssh"
+	"tailscale.com/types/logger"
+)
+
+// The termios package used on the other platforms doesn't support NetBSD, so
+// the terminal modes are applied to the termios struct directly here.
+
+// ptyModeCC maps SSH terminal mode opcodes to the termios control characters
+// they set.
+var ptyModeCC = map[uint8]int{
+	gossh.VINTR:    unix.VINTR,
+	gossh.VQUIT:    unix.VQUIT,
+	gossh.VERASE:   unix.VERASE,
+	gossh.VKILL:    unix.VKILL,
+	gossh.VEOF:     unix.VEOF,
+	gossh.VEOL:     unix.VEOL,
+	gossh.VEOL2:    unix.VEOL2,
+	gossh.VSTART:   unix.VSTART,
+	gossh.VSTOP:    unix.VSTOP,
+	gossh.VSUSP:    unix.VSUSP,
+	gossh.VDSUSP:   unix.VDSUSP,
+	gossh.VREPRINT: unix.VREPRINT,
+	gossh.VWERASE:  unix.VWERASE,
+	gossh.VLNEXT:   unix.VLNEXT,
+	gossh.VDISCARD: unix.VDISCARD,
+	gossh.VSTATUS:  unix.VSTATUS,
+}
+
+// ptyModeFlag is a termios flag that an SSH terminal mode opcode sets or
+// clears.
+type ptyModeFlag struct {
+	field func(*unix.Termios) *uint32
+	mask  uint32
+}
+
+func iflag(t *unix.Termios) *uint32 { return &t.Iflag }
+func oflag(t *unix.Termios) *uint32 { return &t.Oflag }
+func cflag(t *unix.Termios) *uint32 { return &t.Cflag }
+func lflag(t *unix.Termios) *uint32 { return &t.Lflag }
+
+var ptyModeFlags = map[uint8]ptyModeFlag{
+	gossh.IGNPAR:  {iflag, unix.IGNPAR},
+	gossh.PARMRK:  {iflag, unix.PARMRK},
+	gossh.INPCK:   {iflag, unix.INPCK},
+	gossh.ISTRIP:  {iflag, unix.ISTRIP},
+	gossh.INLCR:   {iflag, unix.INLCR},
+	gossh.IGNCR:   {iflag, unix.IGNCR},
+	gossh.ICRNL:   {iflag, unix.ICRNL},
+	gossh.IXON:    {iflag, unix.IXON},
+	gossh.IXANY:   {iflag, unix.IXANY},
+	gossh.IXOFF:   {iflag, unix.IXOFF},
+	gossh.IMAXBEL: {iflag, unix.IMAXBEL},
+	gossh.ISIG:    {lflag, unix.ISIG},
+	gossh.ICANON:  {lflag, unix.ICANON},
+	gossh.ECHO:    {lflag, unix.ECHO},
+	gossh.ECHOE:   {lflag, unix.ECHOE},
+	gossh.ECHOK:   {lflag, unix.ECHOK},
+	gossh.ECHONL:  {lflag, unix.ECHONL},
+	gossh.NOFLSH:  {lflag, unix.NOFLSH},
+	gossh.TOSTOP:  {lflag, unix.TOSTOP},
+	gossh.IEXTEN:  {lflag, unix.IEXTEN},
+	gossh.ECHOCTL: {lflag, unix.ECHOCTL},
+	gossh.ECHOKE:  {lflag, unix.ECHOKE},
+	gossh.PENDIN:  {lflag, unix.PENDIN},
+	gossh.OPOST:   {oflag, unix.OPOST},
+	gossh.ONLCR:   {oflag, unix.ONLCR},
+	gossh.OCRNL:   {oflag, unix.OCRNL},
+	gossh.ONOCR:   {oflag, unix.ONOCR},
+	gossh.ONLRET:  {oflag, unix.ONLRET},
+	gossh.CS7:     {cflag, unix.CS7},
+	gossh.CS8:     {cflag, unix.CS8},
+	gossh.PARENB:  {cflag, unix.PARENB},
+	gossh.PARODD:  {cflag, unix.PARODD},
+}
+
+// setPTYModes applies the window size and terminal modes of ptyReq to the
+// terminal fd.
+func setPTYModes(fd int, ptyReq *ssh.Pty, vlogf logger.Logf) error {
+	tios, err := unix.IoctlGetTermios(fd, unix.TIOCGETA)
+	if err != nil {
+		return fmt.Errorf("TIOCGETA: %w", err)
+	}
+	for c, v := range ptyReq.Modes {
+		if i, ok := ptyModeCC[c]; ok {
+			tios.Cc[i] = uint8(v)
+			continue
+		}
+		if f, ok := ptyModeFlags[c]; ok {
+			if v > 0 {
+				*f.field(tios) |= f.mask
+			} else {
+				*f.field(tios) &^= f.mask
+			}
+			continue
+		}
+		switch c {
+		case gossh.TTY_OP_ISPEED:
+			tios.Ispeed = int32(v)
+		case gossh.TTY_OP_OSPEED:
+			tios.Ospeed = int32(v)
+		default:
+			vlogf("unsupported opcode: %d=%v", c, v)
+		}
+	}
+	if err := unix.IoctlSetTermios(fd, unix.TIOCSETA, tios); err != nil {
+		return fmt.Errorf("TIOCSETA: %w", err)
+	}
+	if err := unix.IoctlSetWinsize(fd, unix.TIOCSWINSZ, &unix.Winsize{
+		Row: uint16(ptyReq.Window.Height),
+		Col: uint16(ptyReq.Window.Width),
+	}); err != nil {
+		return fmt.Errorf("TIOCSWINSZ: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux || (darwin && !ios) || freebsd || openbsd || netbsd
+
+package tailssh
+
+import (
+	"testing"
+
+	"github.com/creack/pty"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/sys/unix"
+	"tailscale.com/tempfork/gliderlabs/ssh"
+)
+
+func TestSetPTYModes(t *testing.T) {
+	ptyFile, tty, err := pty.Open()
+	if err != nil {
+		t.Skipf("no pty: %v", err)
+	}
+	defer ptyFile.Close()
+	defer tty.Close()
+	fd := int(tty.Fd())
+
+	req := &ssh.Pty{
+		Window: ssh.Window{Width: 132, Height: 43},
+		Modes: gossh.TerminalModes{
+			gossh.VINTR:  7,
+			gossh.ECHO:   0,
+			gossh.ICRNL:  1,
+			gossh.ONLCR:  0,
+			gossh.ISTRIP: 1,
+		},
+	}
+	if err := setPTYModes(fd, req, t.Logf); err != nil {
+		t.Fatal(err)
+	}
+
+	tios, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tios.Cc[unix.VINTR]; got != 7 {
+		t.Errorf("VINTR = %d; want 7", got)
+	}
+	for _, f := range []struct {
+		name  string
+		flags uint32
+		mask  uint32
+		want  bool
+	}{
+		{"ECHO", uint32(tios.Lflag), unix.ECHO, false},
+		{"ICRNL", uint32(tios.Iflag), unix.ICRNL, true},
+		{"ONLCR", uint32(tios.Oflag), unix.ONLCR, false},
+		{"ISTRIP", uint32(tios.Iflag), unix.ISTRIP, true},
+	} {
+		if got := f.flags&f.mask != 0; got != f.want {
+			t.Errorf("%s = %v; want %v", f.name, got, f.want)
+		}
+	}
+	ws, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws.Col != 132 || ws.Row != 43 {
+		t.Errorf("window = %dx%d; want 132x43", ws.Col, ws.Row)
+	}
+}
@@ -0,0 +1,519 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux || (darwin && !ios) || freebsd || openbsd || netbsd
+
+package tailssh
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anmitsu/go-shlex"
+	"tailscale.com/cmd/tailscaled/childproc"
+)
+
+// This file implements the remote end of the scp protocol ("scp -t" to
+// receive files, "scp -f" to send them), for hosts without an scp binary,
+// such as minimal containers and some NAS systems. Recent scp clients use
+// SFTP instead, which tailscaled always serves itself; older ones, and
+// "scp -O", run scp on the remote host.
+//
+// Like SFTP, it's run as "tailscaled be-child scp" by the incubator, so as
+// the local user, after the same policy checks as any other session.
+
+func init() {
+	childproc.Add("scp", beSCP)
+}
+
+// builtinSCPCommand returns the command to run for the SSH exec request
+// raw, with the built-in scp server in place of scp, if raw runs the scp
+// server and the host has no scp of its own.
+func builtinSCPCommand(tailscaledPath, raw string) (cmd string, ok bool) {
+	if tailscaledPath == "" || sshDisableSFTP() {
+		return "", false
+	}
+	rest, ok := scpServerArgs(raw)
+	if !ok {
+		return "", false
+	}
+	if _, err := exec.LookPath("scp"); err == nil {
+		return "", false
+	}
+	return tailscaledPath + " be-child scp" + rest, true
+}
+
+// scpServerArgs reports whether the shell command raw runs the remote end of
+// scp, splitting it into words as the shell would. If so, it returns what
+// follows "scp" in raw, still quoted, so the shell passes the same arguments
+// to the built-in server as it would have to scp.
+func scpServerArgs(raw string) (rest string, ok bool) {
+	args, err := shlex.Split(raw, true)
+	if err != nil || len(args) < 2 || args[0] != "scp" {
+		return "", false
+	}
+	if _, err := parseSCPArgs(args[1:]); err != nil {
+		return "", false
+	}
+	rest, ok = strings.CutPrefix(strings.TrimLeft(raw, " \t"), "scp")
+	if !ok {
+		return "", false // "scp" itself was quoted or escaped
+	}
+	return rest, true
+}
+
+// scpArgs are the options of the remote end of scp.
+type scpArgs struct {
+	sink      bool     // -t: receive files
+	source    bool     // -f: send files
+	recursive bool     // -r: copy directories
+	preserve  bool     // -p: copy modification times and modes
+	targetDir bool     // -d: the sink's target must be a directory
+	paths     []string // target for -t, files to send for -f
+}
+
+// parseSCPArgs parses the arguments scp clients pass to the remote scp.
+// The options may be combined, as in "-rt".
+func parseSCPArgs(args []string) (scpArgs, error) {
+	var a scpArgs
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") && args[0] != "-" {
+		opts := args[0][1:]
+		args = args[1:]
+		if opts == "-" {
+			break
+		}
+		for _, o := range opts {
+			switch o {
+			case 't':
+				a.sink = true
+			case 'f':
+				a.source = true
+			case 'r':
+				a.recursive = true
+			case 'p':
+				a.preserve = true
+			case 'd':
+				a.targetDir = true
+			case 'v', 'q', 'E':
+				// Verbose, quiet and extended attributes: nothing to do.
+			default:
+				return a, fmt.Errorf("unsupported option -%c", o)
+			}
+		}
+	}
+	a.paths = args
+	switch {
+	case a.sink == a.source:
+		return a, errors.New("exactly one of -t and -f is required")
+	case a.sink && len(a.paths) != 1:
+		return a, errors.New("-t takes exactly one target")
+	case a.source && len(a.paths) == 0:
+		return a, errors.New("-f needs files to send")
+	}
+	return a, nil
+}
+
+// beSCP serves the remote end of scp on stdin and stdout, in-process.
+func beSCP(args []string) error {
+	a, err := parseSCPArgs(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "scp: %v\n", err)
+		return err
+	}
+	if a.sink {
+		return scpSink(os.Stdin, os.Stdout, a)
+	}
+	return scpSource(os.Stdin, os.Stdout, a)
+}
+
+// scpSink receives files from the scp client, reading its messages from r
+// and writing responses to w.
+func scpSink(r io.Reader, w io.Writer, a scpArgs) error {
+	br := bufio.NewReader(r)
+	target := a.paths[0]
+	if fi, err := os.Stat(target); a.targetDir && (err != nil || !fi.IsDir()) {
+		return scpFatal(w, fmt.Errorf("%s: not a directory", target))
+	}
+	if err := scpAck(w); err != nil {
+		return err
+	}
+
+	type dir struct {
+		path  string
+		mtime time.Time // to set on leaving, if non-zero
+	}
+	var (
+		dirs   []dir     // directories entered with "D", innermost last
+		mtime  time.Time // from the last "T" message, for the next file or directory
+		warned bool      // whether any file failed
+	)
+	// dest returns where to write the file or directory name from the
+	// client: in the current directory, or the target itself if it's not
+	// an existing directory.
+	dest := func(name string) string {
+		if len(dirs) > 0 {
+			return filepath.Join(dirs[len(dirs)-1].path, name)
+		}
+		if fi, err := os.Stat(target); err == nil && fi.IsDir() {
+			return filepath.Join(target, name)
+		}
+		return target
+	}
+	// warn reports err for one file or directory and carries on.
+	warn := func(err error) error {
+		warned = true
+		return scpWarn(w, err)
+	}
+
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF && line == "" {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return scpFatal(w, errors.New("protocol error: empty message"))
+		}
+		switch line[0] {
+		case 1:
+			// An error from the client, which it has shown its user.
+			warned = true
+			continue
+		case 2:
+			return errors.New(line[1:])
+		case 'T':
+			var mt, mtUsec, at, atUsec int64
+			if _, err := fmt.Sscanf(line[1:], "%d %d %d %d", &mt, &mtUsec, &at, &atUsec); err != nil {
+				return scpFatal(w, fmt.Errorf("protocol error: bad T message %q", line))
+			}
+			mtime = time.Unix(mt, mtUsec*1000)
+			if err := scpAck(w); err != nil {
+				return err
+			}
+			continue
+		case 'E':
+			if len(dirs) == 0 {
+				return scpFatal(w, errors.New("protocol error: unexpected E message"))
+			}
+			d := dirs[len(dirs)-1]
+			dirs = dirs[:len(dirs)-1]
+			if !d.mtime.IsZero() {
+				os.Chtimes(d.path, d.mtime, d.mtime)
+			}
+			if err := scpAck(w); err != nil {
+				return err
+			}
+			continue
+		case 'C', 'D':
+		default:
+			return scpFatal(w, fmt.Errorf("protocol error: unexpected message %q", line))
+		}
+
+		mode, size, name, err := parseSCPEntry(line)
+		if err != nil {
+			return scpFatal(w, err)
+		}
+		path := dest(name)
+		entryMtime := mtime
+		mtime = time.Time{}
+		if !a.preserve {
+			entryMtime = time.Time{}
+		}
+
+		if line[0] == 'D' {
+			if !a.recursive {
+				return scpFatal(w, errors.New("received directory without -r"))
+			}
+			// On failure, the client skips the directory's contents.
+			if err := scpMkdir(path, mode, a.preserve); err != nil {
+				if err := warn(err); err != nil {
+					return err
+				}
+				continue
+			}
+			dirs = append(dirs, dir{path, entryMtime})
+			if err := scpAck(w); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// On failure to open the file, the client skips its contents.
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+		if err != nil {
+			if err := warn(err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := scpAck(w); err != nil {
+			f.Close()
+			return err
+		}
+		// Keep reading the contents after a write error, to stay in step
+		// with the client.
+		lr := &io.LimitedReader{R: br, N: size}
+		_, writeErr := io.Copy(f, lr)
+		if _, err := io.Copy(io.Discard, lr); err != nil || lr.N > 0 {
+			f.Close()
+			return cmp.Or(err, io.ErrUnexpectedEOF)
+		}
+		if err := f.Close(); writeErr == nil {
+			writeErr = err
+		}
+		// The client ends the contents with a response of its own.
+		if err := scpReadResponse(br); err != nil {
+			if !errors.As(err, new(scpWarning)) {
+				return err
+			}
+			warned = true
+		}
+		if writeErr == nil && a.preserve {
+			writeErr = os.Chmod(path, mode)
+		}
+		if writeErr == nil && !entryMtime.IsZero() {
+			writeErr = os.Chtimes(path, entryMtime, entryMtime)
+		}
+		if writeErr != nil {
+			if err := warn(fmt.Errorf("%s: %w", path, writeErr)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := scpAck(w); err != nil {
+			return err
+		}
+	}
+	if warned {
+		return errors.New("some files could not be copied")
+	}
+	return nil
+}
+
+// parseSCPEntry parses a "C" or "D" message, "Cmmmm size name", validating
+// that name is a single path element.
+func parseSCPEntry(line string) (mode fs.FileMode, size int64, name string, err error) {
+	f := strings.SplitN(line[1:], " ", 3)
+	if len(f) != 3 {
+		return 0, 0, "", fmt.Errorf("protocol error: bad message %q", line)
+	}
+	m, err := strconv.ParseUint(f[0], 8, 32)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("protocol error: bad mode in %q", line)
+	}
+	size, err = strconv.ParseInt(f[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, 0, "", fmt.Errorf("protocol error: bad size in %q", line)
+	}
+	name = f[2]
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return 0, 0, "", fmt.Errorf("protocol error: bad file name %q", name)
+	}
+	return fs.FileMode(m).Perm(), size, name, nil
+}
+
+// scpMkdir creates the directory path, or uses the existing one.
+func scpMkdir(path string, mode fs.FileMode, preserve bool) error {
+	fi, err := os.Stat(path)
+	if err == nil {
+		if !fi.IsDir() {
+			return fmt.Errorf("%s: not a directory", path)
+		}
+		if preserve {
+			return os.Chmod(path, mode|0o700)
+		}
+		return nil
+	}
+	// Keep the directory writable by its owner, so its files can be
+	// written.
+	return os.Mkdir(path, mode|0o700)
+}
+
+// scpSource sends a.paths to the scp client, writing messages to w and
+// reading the client's responses from r.
+func scpSource(r io.Reader, w io.Writer, a scpArgs) error {
+	br := bufio.NewReader(r)
+	// The client responds first, once it's ready.
+	if err := scpReadResponse(br); err != nil {
+		return err
+	}
+	var warned bool
+	for _, p := range a.paths {
+		if err := scpSend(br, w, a, p, &warned); err != nil {
+			return err
+		}
+	}
+	if warned {
+		return errors.New("some files could not be copied")
+	}
+	return nil
+}
+
+// scpSend sends the file or directory at path. Errors that only affect it
+// are reported to the client and set *warned; those that end the copy are
+// returned.
+func scpSend(br *bufio.Reader, w io.Writer, a scpArgs, path string, warned *bool) error {
+	// skip reports err, from the client or to it, and moves on.
+	skip := func(err error) error {
+		*warned = true
+		if errors.As(err, new(scpWarning)) {
+			return nil
+		}
+		return scpWarn(w, err)
+	}
+	// response reads the client's response, returning a scpWarning for a
+	// skippable error.
+	response := func() error { return scpReadResponse(br) }
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return skip(err)
+	}
+	if (fi.IsDir() && !a.recursive) || (!fi.IsDir() && !fi.Mode().IsRegular()) {
+		return skip(fmt.Errorf("%s: not a regular file", path))
+	}
+	if a.preserve {
+		mt := fi.ModTime()
+		if _, err := fmt.Fprintf(w, "T%d %d %d %d\n", mt.Unix(), mt.Nanosecond()/1000, mt.Unix(), mt.Nanosecond()/1000); err != nil {
+			return err
+		}
+		if err := response(); err != nil {
+			if !errors.As(err, new(scpWarning)) {
+				return err
+			}
+			return skip(err)
+		}
+	}
+	name := filepath.Base(path)
+
+	if fi.IsDir() {
+		des, err := os.ReadDir(path)
+		if err != nil {
+			return skip(err)
+		}
+		if _, err := fmt.Fprintf(w, "D%04o 0 %s\n", fi.Mode().Perm(), name); err != nil {
+			return err
+		}
+		if err := response(); err != nil {
+			if !errors.As(err, new(scpWarning)) {
+				return err
+			}
+			return skip(err)
+		}
+		for _, de := range des {
+			if err := scpSend(br, w, a, filepath.Join(path, de.Name()), warned); err != nil {
+				return err
+			}
+		}
+		if _, err := io.WriteString(w, "E\n"); err != nil {
+			return err
+		}
+		return response()
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return skip(err)
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(w, "C%04o %d %s\n", fi.Mode().Perm(), fi.Size(), name); err != nil {
+		return err
+	}
+	if err := response(); err != nil {
+		if !errors.As(err, new(scpWarning)) {
+			return err
+		}
+		return skip(err)
+	}
+	// Send exactly the size announced, padding if the file shrank, and
+	// then say whether that worked.
+	n, err := io.CopyN(w, f, fi.Size())
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if n < fi.Size() {
+		if _, err := io.CopyN(w, zeroReader{}, fi.Size()-n); err != nil {
+			return err
+		}
+		if err := skip(fmt.Errorf("%s: file changed size while sending", path)); err != nil {
+			return err
+		}
+	} else if err := scpAck(w); err != nil {
+		return err
+	}
+	if err := response(); err != nil {
+		if !errors.As(err, new(scpWarning)) {
+			return err
+		}
+		return skip(err)
+	}
+	return nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// scpWarning is an error the other end reported for one file, after which
+// the copy goes on.
+type scpWarning struct{ msg string }
+
+func (w scpWarning) Error() string { return w.msg }
+
+// scpAck tells the other end the last message was handled.
+func scpAck(w io.Writer) error {
+	_, err := w.Write([]byte{0})
+	return err
+}
+
+// scpWarn reports an error with one file to the other end, after which the
+// copy goes on.
+func scpWarn(w io.Writer, err error) error {
+	_, werr := fmt.Fprintf(w, "\x01scp: %v\n", err)
+	return werr
+}
+
+// scpFatal reports an error to the other end that ends the copy, and
+// returns it.
+func scpFatal(w io.Writer, err error) error {
+	fmt.Fprintf(w, "\x02scp: %v\n", err)
+	return err
+}
+
+// scpReadResponse reads the other end's response to the last message. It
+// returns a scpWarning if it reported an error with one file, or another
+// error if it reported one that ends the copy.
+func scpReadResponse(br *bufio.Reader) error {
+	b, err := br.ReadByte()
+	if err != nil {
+		return err
+	}
+	if b == 0 {
+		return nil
+	}
+	msg, err := br.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	msg = strings.TrimSuffix(msg, "\n")
+	if b == 1 {
+		return scpWarning{msg}
+	}
+	return errors.New(msg)
+}
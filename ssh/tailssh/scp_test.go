@@ -0,0 +1,189 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux || (darwin && !ios) || freebsd || openbsd || netbsd
+
+package tailssh
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anmitsu/go-shlex"
+)
+
+func TestParseSCPArgs(t *testing.T) {
+	tests := []struct {
+		args    string
+		want    scpArgs
+		wantErr bool
+	}{
+		{args: "-t /tmp", want: scpArgs{sink: true, paths: []string{"/tmp"}}},
+		{args: "-v -r -p -d -t -- dir", want: scpArgs{sink: true, recursive: true, preserve: true, targetDir: true, paths: []string{"dir"}}},
+		{args: "-rf a b", want: scpArgs{source: true, recursive: true, paths: []string{"a", "b"}}},
+		{args: "-f -- -dash", want: scpArgs{source: true, paths: []string{"-dash"}}},
+		{args: "-t", wantErr: true},
+		{args: "-t a b", wantErr: true},
+		{args: "-f", wantErr: true},
+		{args: "-t -f a", wantErr: true},
+		{args: "-x -t a", wantErr: true},
+		{args: "a", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSCPArgs(strings.Fields(tt.args))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSCPArgs(%q) error = %v; want error %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSCPArgs(%q) = %+v; want %+v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestSCPServerArgs(t *testing.T) {
+	tests := []struct {
+		raw       string
+		wantRest  string
+		wantPaths []string
+		wantOK    bool
+	}{
+		{raw: "scp -t /tmp", wantRest: " -t /tmp", wantPaths: []string{"/tmp"}, wantOK: true},
+		{raw: "  scp -rt dir", wantRest: " -rt dir", wantPaths: []string{"dir"}, wantOK: true},
+		{raw: "scp -t 'my dir/'", wantRest: " -t 'my dir/'", wantPaths: []string{"my dir/"}, wantOK: true},
+		{raw: `scp -f "a b" c\ d`, wantRest: ` -f "a b" c\ d`, wantPaths: []string{"a b", "c d"}, wantOK: true},
+		{raw: "scp -t my dir/"},
+		{raw: "scp -t 'unterminated"},
+		{raw: `"scp" -t /tmp`},
+		{raw: "scp"},
+		{raw: "ls -t /tmp"},
+	}
+	for _, tt := range tests {
+		rest, ok := scpServerArgs(tt.raw)
+		if ok != tt.wantOK || rest != tt.wantRest {
+			t.Errorf("scpServerArgs(%q) = %q, %v; want %q, %v", tt.raw, rest, ok, tt.wantRest, tt.wantOK)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		args, err := shlex.Split(rest, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := parseSCPArgs(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(a.paths, tt.wantPaths) {
+			t.Errorf("scpServerArgs(%q) paths = %q; want %q", tt.raw, a.paths, tt.wantPaths)
+		}
+	}
+}
+
+// runSCP copies with scpSource and scpSink talking to each other, as
+// an scp client and the built-in server would, in either direction.
+func runSCP(t *testing.T, source, sink scpArgs) (sourceErr, sinkErr error) {
+	t.Helper()
+	toSink, fromSource := io.Pipe()
+	toSource, fromSink := io.Pipe()
+	sinkDone := make(chan error, 1)
+	go func() {
+		err := scpSink(toSink, fromSink, sink)
+		fromSink.Close()
+		toSink.Close()
+		sinkDone <- err
+	}()
+	sourceErr = scpSource(toSource, fromSource, source)
+	fromSource.Close()
+	io.Copy(io.Discard, toSource)
+	return sourceErr, <-sinkDone
+}
+
+func TestSCPRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	mtime := time.Unix(1700000000, 0)
+	files := map[string]string{
+		"top.txt":       "top",
+		"dir/a.txt":     "a",
+		"dir/sub/b.txt": strings.Repeat("b", 100_000),
+		"dir/empty.txt": "",
+	}
+	for name, contents := range files {
+		path := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0640); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dst := t.TempDir()
+	sourceErr, sinkErr := runSCP(t,
+		scpArgs{source: true, recursive: true, preserve: true, paths: []string{filepath.Join(src, "dir"), filepath.Join(src, "top.txt")}},
+		scpArgs{sink: true, recursive: true, preserve: true, targetDir: true, paths: []string{dst}})
+	if sourceErr != nil || sinkErr != nil {
+		t.Fatalf("source error %v, sink error %v", sourceErr, sinkErr)
+	}
+	for name, contents := range files {
+		path := filepath.Join(dst, name)
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !bytes.Equal(got, []byte(contents)) {
+			t.Errorf("%s: got %d bytes; want %d", name, len(got), len(contents))
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0640 {
+			t.Errorf("%s: mode %v; want %v", name, fi.Mode().Perm(), fs.FileMode(0640))
+		}
+		if !fi.ModTime().Equal(mtime) {
+			t.Errorf("%s: mtime %v; want %v", name, fi.ModTime(), mtime)
+		}
+	}
+
+	// A single file can be copied to a new name, and a missing file is
+	// reported without stopping the copy.
+	sourceErr, sinkErr = runSCP(t,
+		scpArgs{source: true, paths: []string{filepath.Join(src, "missing"), filepath.Join(src, "top.txt")}},
+		scpArgs{sink: true, paths: []string{filepath.Join(dst, "renamed.txt")}})
+	if sourceErr == nil || sinkErr == nil {
+		t.Errorf("source error %v, sink error %v; want errors for missing file", sourceErr, sinkErr)
+	}
+	if got, err := os.ReadFile(filepath.Join(dst, "renamed.txt")); err != nil || string(got) != "top" {
+		t.Errorf("renamed.txt = %q, %v; want %q", got, err, "top")
+	}
+}
+
+func TestSCPSinkRejectsPaths(t *testing.T) {
+	for _, name := range []string{"../evil", "a/b", "..", "."} {
+		dst := t.TempDir()
+		var out bytes.Buffer
+		in := strings.NewReader("C0644 4 " + name + "\nevil\x00")
+		if err := scpSink(in, &out, scpArgs{sink: true, paths: []string{dst}}); err == nil {
+			t.Errorf("%q: no error", name)
+		}
+		if !strings.Contains(out.String(), "\x02scp: protocol error") {
+			t.Errorf("%q: response %q; want fatal error", name, out.String())
+		}
+		if _, err := os.Stat(filepath.Join(filepath.Dir(dst), "evil")); err == nil {
+			t.Errorf("%q: wrote outside target", name)
+		}
+	}
+}
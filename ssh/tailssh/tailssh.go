@@ -1,7 +1,7 @@
 // Copyright (c) Tailscale Inc & AUTHORS
 // SPDX-License-Identifier: BSD-3-Clause
 
-//go:build linux || (darwin && !ios) || freebsd || openbsd
+//go:build linux || (darwin && !ios) || freebsd || openbsd || netbsd
 
 // Package tailssh is an SSH server integrated into Tailscale.
 package tailssh
@@ -791,41 +791,67 @@ func (ss *sshSession) handleSSHAgentForwarding(s ssh.Session, lu *userMeta) erro
 		return nil
 	}
 	ss.logf("ssh: agent forwarding requested")
-	ln, err := ssh.NewAgentListener()
+	uid, err := strconv.ParseUint(lu.Uid, 10, 32)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if err != nil && ln != nil {
-			ln.Close()
-		}
-	}()
-
-	uid, err := strconv.ParseUint(lu.Uid, 10, 32)
+	gid, err := strconv.ParseUint(lu.Gid, 10, 32)
 	if err != nil {
 		return err
 	}
-	gid, err := strconv.ParseUint(lu.Gid, 10, 32)
+	ln, err := listenAgentSocket(int(uid), int(gid))
 	if err != nil {
 		return err
 	}
+	go ssh.ForwardAgentConnections(ln, s)
+	ss.agentListener = ln
+	return nil
+}
+
+// agentSocket is the listener for the SSH_AUTH_SOCK of a session with
+// agent forwarding. Closing it removes its directory too.
+type agentSocket struct {
+	net.Listener
+	dir string
+}
+
+func (a agentSocket) Close() error {
+	err := a.Listener.Close()
+	os.RemoveAll(a.dir)
+	return err
+}
+
+// listenAgentSocket returns a new listener for forwarded SSH agent
+// connections, on a socket that, like OpenSSH's, is in a directory of its
+// own that only the user uid can access.
+func listenAgentSocket(uid, gid int) (_ net.Listener, err error) {
+	ln, err := ssh.NewAgentListener()
+	if err != nil {
+		return nil, err
+	}
 	socket := ln.Addr().String()
-	dir := filepath.Dir(socket)
-	// Make sure the socket is accessible only by the user.
+	a := agentSocket{ln, filepath.Dir(socket)}
+	defer func() {
+		if err != nil {
+			a.Close()
+		}
+	}()
+	// Set up the socket while its directory is still ours alone (os.MkdirTemp
+	// creates it 0700), and only then hand the directory to the user, so that
+	// nothing the user can swap for a symlink is followed.
 	if err := os.Chmod(socket, 0600); err != nil {
-		return err
+		return nil, err
 	}
-	if err := os.Chown(socket, int(uid), int(gid)); err != nil {
-		return err
+	if err := os.Lchown(socket, uid, gid); err != nil {
+		return nil, err
 	}
-	// Make sure the dir is also accessible.
-	if err := os.Chmod(dir, 0755); err != nil {
-		return err
+	if err := os.Chmod(a.dir, 0700); err != nil {
+		return nil, err
 	}
-
-	go ssh.ForwardAgentConnections(ln, s)
-	ss.agentListener = ln
-	return nil
+	if err := os.Lchown(a.dir, uid, gid); err != nil {
+		return nil, err
+	}
+	return a, nil
 }
 
 // run is the entrypoint for a newly accepted SSH session.
@@ -1003,16 +1029,36 @@ func (ss *sshSession) run() {
 // If the final action has a non-empty list of recorders, that list is
 // returned. Otherwise, the list of recorders from the initial action
 // is returned.
+//
+// The failure action is the final action's if it configures any recording,
+// remote or local, and otherwise the initial action's. It applies to the
+// local recording too.
 func (ss *sshSession) recorders() ([]netip.AddrPort, *tailcfg.SSHRecorderFailureAction) {
-	if len(ss.conn.finalAction.Recorders) > 0 {
-		return ss.conn.finalAction.Recorders, ss.conn.finalAction.OnRecordingFailure
+	final, action0 := ss.conn.finalAction, ss.conn.action0
+	recs := final.Recorders
+	if len(recs) == 0 {
+		recs = action0.Recorders
+	}
+	if len(final.Recorders) > 0 || final.LocalRecording != nil {
+		return recs, final.OnRecordingFailure
 	}
-	return ss.conn.action0.Recorders, ss.conn.action0.OnRecordingFailure
+	return recs, action0.OnRecordingFailure
+}
+
+// localRecording returns where to record this session on this node, if
+// anywhere: the final action's LocalRecording if set, and otherwise the
+// initial action's. It's independent of recorders, so a session can be
+// recorded both locally and remotely.
+func (ss *sshSession) localRecording() *tailcfg.SSHLocalRecording {
+	if lr := ss.conn.finalAction.LocalRecording; lr != nil {
+		return lr
+	}
+	return ss.conn.action0.LocalRecording
 }
 
 func (ss *sshSession) shouldRecord() bool {
 	recs, _ := ss.recorders()
-	return len(recs) > 0 || recordSSHToLocalDisk()
+	return len(recs) > 0 || ss.localRecording() != nil || recordSSHToLocalDisk()
 }
 
 type sshConnInfo struct {
@@ -1150,16 +1196,12 @@ func randBytes(n int) []byte {
 	return b
 }
 
-func (ss *sshSession) openFileForRecording(now time.Time) (_ io.WriteCloser, err error) {
-	varRoot := ss.conn.srv.lb.TailscaleVarRoot()
-	if varRoot == "" {
-		return nil, errors.New("no var root for recording storage")
-	}
-	dir := filepath.Join(varRoot, "ssh-sessions")
-	if err := os.MkdirAll(dir, 0700); err != nil {
+func (ss *sshSession) openFileForRecording(now time.Time, lr tailcfg.SSHLocalRecording) (_ io.WriteCloser, err error) {
+	dir, err := sessionrecording.LocalDir(ss.conn.srv.lb.TailscaleVarRoot(), lr)
+	if err != nil {
 		return nil, err
 	}
-	f, err := os.CreateTemp(dir, fmt.Sprintf("ssh-session-%v-*.cast", now.UnixNano()))
+	f, err := sessionrecording.CreateLocal(dir, lr, now)
 	if err != nil {
 		return nil, err
 	}
@@ -1177,10 +1219,10 @@ func (ss *sshSession) startNewRecording() (_ *recording, err error) {
 	}
 
 	recorders, onFailure := ss.recorders()
-	var localRecording bool
-	if len(recorders) == 0 {
+	localRecording := ss.localRecording()
+	if len(recorders) == 0 && localRecording == nil {
 		if recordSSHToLocalDisk() {
-			localRecording = true
+			localRecording = new(tailcfg.SSHLocalRecording)
 		} else {
 			return nil, errors.New("no recorders configured")
 		}
@@ -1207,15 +1249,36 @@ func (ss *sshSession) startNewRecording() (_ *recording, err error) {
 	// ss.ctx is closed when the session closes, but we don't want to break the upload at that time.
 	// Instead we want to wait for the session to close the writer when it finishes.
 	ctx := context.Background()
-	if localRecording {
-		rec.out, err = ss.openFileForRecording(now)
+	var localOut io.WriteCloser
+	defer func() {
+		if err != nil && localOut != nil {
+			// The session isn't going ahead, so don't leave an empty
+			// recording of it behind.
+			localOut.Close()
+			if f, ok := localOut.(*os.File); ok {
+				os.Remove(f.Name())
+			}
+		}
+	}()
+	if localRecording != nil {
+		localOut, err = ss.openFileForRecording(now, *localRecording)
 		if err != nil {
-			return nil, err
+			if onFailure != nil && onFailure.RejectSessionWithMessage != "" {
+				ss.logf("recording: error starting local recording (rejecting session): %v", err)
+				return nil, userVisibleError{
+					error: err,
+					msg:   onFailure.RejectSessionWithMessage,
+				}
+			}
+			if len(recorders) == 0 {
+				return nil, err
+			}
+			ss.logf("recording: error starting local recording (failing open): %v", err)
 		}
-	} else {
-		var errChan <-chan error
-		var attempts []*tailcfg.SSHRecordingAttempt
-		rec.out, attempts, errChan, err = sessionrecording.ConnectToRecorder(ctx, recorders, ss.conn.srv.lb.Dialer().UserDial)
+		rec.out = localOut
+	}
+	if len(recorders) > 0 {
+		out, attempts, errChan, err := sessionrecording.ConnectToRecorder(ctx, recorders, ss.conn.srv.lb.Dialer().UserDial)
 		if err != nil {
 			if onFailure != nil && onFailure.NotifyURL != "" && len(attempts) > 0 {
 				eventType := tailcfg.SSHSessionRecordingFailed
@@ -1233,41 +1296,49 @@ func (ss *sshSession) startNewRecording() (_ *recording, err error) {
 				}
 			}
 			ss.logf("recording: error starting recording (failing open): %v", err)
-			return nil, nil
-		}
-		go func() {
-			err := <-errChan
-			if err == nil {
-				select {
-				case <-ss.ctx.Done():
-					// Success.
-					ss.logf("recording: finished uploading recording")
-					return
-				default:
-					err = errors.New("recording upload ended before the SSH session")
-				}
+		} else {
+			if localOut != nil {
+				out = multiWriteCloser{localOut, out}
 			}
-			if onFailure != nil && onFailure.NotifyURL != "" && len(attempts) > 0 {
-				lastAttempt := attempts[len(attempts)-1]
-				lastAttempt.FailureMessage = err.Error()
-
-				eventType := tailcfg.SSHSessionRecordingFailed
-				if onFailure.TerminateSessionWithMessage != "" {
-					eventType = tailcfg.SSHSessionRecordingTerminated
+			rec.out = out
+			go func() {
+				err := <-errChan
+				if err == nil {
+					select {
+					case <-ss.ctx.Done():
+						// Success.
+						ss.logf("recording: finished uploading recording")
+						return
+					default:
+						err = errors.New("recording upload ended before the SSH session")
+					}
 				}
+				if onFailure != nil && onFailure.NotifyURL != "" && len(attempts) > 0 {
+					lastAttempt := attempts[len(attempts)-1]
+					lastAttempt.FailureMessage = err.Error()
 
-				ss.notifyControl(ctx, nodeKey, eventType, attempts, onFailure.NotifyURL)
-			}
-			if onFailure != nil && onFailure.TerminateSessionWithMessage != "" {
-				ss.logf("recording: error uploading recording (closing session): %v", err)
-				ss.cancelCtx(userVisibleError{
-					error: err,
-					msg:   onFailure.TerminateSessionWithMessage,
-				})
-				return
-			}
-			ss.logf("recording: error uploading recording (failing open): %v", err)
-		}()
+					eventType := tailcfg.SSHSessionRecordingFailed
+					if onFailure.TerminateSessionWithMessage != "" {
+						eventType = tailcfg.SSHSessionRecordingTerminated
+					}
+
+					ss.notifyControl(ctx, nodeKey, eventType, attempts, onFailure.NotifyURL)
+				}
+				if onFailure != nil && onFailure.TerminateSessionWithMessage != "" {
+					ss.logf("recording: error uploading recording (closing session): %v", err)
+					ss.cancelCtx(userVisibleError{
+						error: err,
+						msg:   onFailure.TerminateSessionWithMessage,
+					})
+					return
+				}
+				ss.logf("recording: error uploading recording (failing open): %v", err)
+			}()
+		}
+	}
+	if rec.out == nil {
+		// Recording failed open.
+		return nil, nil
 	}
 
 	ch := sessionrecording.CastHeader{
@@ -1445,6 +1516,33 @@ func (w loggingWriter) writeCastLine(j []byte) error {
 	return nil
 }
 
+// multiWriteCloser writes a recording to several destinations, such as a
+// local file and a recorder node. A write fails if it fails for any of them.
+type multiWriteCloser []io.WriteCloser
+
+func (m multiWriteCloser) Write(p []byte) (int, error) {
+	var errs []error
+	for _, w := range m {
+		if _, err := w.Write(p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return 0, errors.Join(errs...)
+	}
+	return len(p), nil
+}
+
+func (m multiWriteCloser) Close() error {
+	var errs []error
+	for _, w := range m {
+		if err := w.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
 func envValFromList(env []string, wantKey string) (v string) {
 	for _, kv := range env {
 		if thisKey, v, ok := strings.Cut(kv, "="); ok && envEq(thisKey, wantKey) {
@@ -1474,6 +1572,7 @@ func envEq(a, b string) bool {
 	metricHolds               = clientmetric.NewCounter("ssh_holds")
 	metricPolicyChangeKick    = clientmetric.NewCounter("ssh_policy_change_kick")
 	metricSFTP                = clientmetric.NewCounter("ssh_sftp_sessions")
+	metricBuiltinSCP          = clientmetric.NewCounter("ssh_builtin_scp_sessions")
 	metricLocalPortForward    = clientmetric.NewCounter("ssh_local_port_forward_requests")
 	metricRemotePortForward   = clientmetric.NewCounter("ssh_remote_port_forward_requests")
 )
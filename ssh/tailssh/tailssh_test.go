@@ -14,6 +14,7 @@
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -21,6 +22,7 @@
 	"os"
 	"os/exec"
 	"os/user"
+	"path/filepath"
 	"reflect"
 	"runtime"
 	"slices"
@@ -779,6 +781,262 @@ func TestSSHRecordingNonInteractive(t *testing.T) {
 	}
 }
 
+// TestSSHRecordingLocal tests that the SSH server records the SSH session
+// to a local file when the SSH policy says to, without a recorder.
+func TestSSHRecordingLocal(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skipf("skipping on %q; only runs on linux and darwin", runtime.GOOS)
+	}
+	dir := t.TempDir()
+	s := &server{
+		logf: t.Logf,
+		lb: &localState{
+			sshEnabled: true,
+			matchingRule: newSSHRule(
+				&tailcfg.SSHAction{
+					Accept:         true,
+					LocalRecording: &tailcfg.SSHLocalRecording{Dir: dir, MaxFiles: 1},
+				},
+			),
+		},
+	}
+	defer s.Shutdown()
+
+	const sshUser = "alice"
+	cfg := &gossh.ClientConfig{
+		User:            sshUser,
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	}
+	// With MaxFiles of 1, the second session's recording replaces the first's.
+	for _, cmd := range []string{"echo one", "echo two"} {
+		src, dst := must.Get(netip.ParseAddrPort("100.100.100.101:2231")), must.Get(netip.ParseAddrPort("100.100.100.102:22"))
+		sc, dc := memnet.NewTCPConn(src, dst, 1024)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, chans, reqs, err := gossh.NewClientConn(sc, sc.RemoteAddr().String(), cfg)
+			if err != nil {
+				t.Errorf("client: %v", err)
+				return
+			}
+			client := gossh.NewClient(c, chans, reqs)
+			defer client.Close()
+			session, err := client.NewSession()
+			if err != nil {
+				t.Errorf("client: %v", err)
+				return
+			}
+			defer session.Close()
+			if _, err := session.CombinedOutput(cmd); err != nil {
+				t.Errorf("client: %v", err)
+			}
+		}()
+		if err := s.HandleSSHConn(dc); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		wg.Wait()
+	}
+
+	files, err := sessionrecording.ListLocal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("got %d recordings; want 1", len(files))
+	}
+	f, err := os.Open(files[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var ch sessionrecording.CastHeader
+	if err := json.NewDecoder(f).Decode(&ch); err != nil {
+		t.Fatal(err)
+	}
+	if ch.SSHUser != sshUser {
+		t.Errorf("SSHUser = %q; want %q", ch.SSHUser, sshUser)
+	}
+	if ch.Command != "echo two" {
+		t.Errorf("Command = %q; want %q", ch.Command, "echo two")
+	}
+}
+
+func TestSSHRecordingLocalRejected(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skipf("skipping on %q; only runs on linux and darwin", runtime.GOOS)
+	}
+	recordingServer := mockRecordingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+	dir := t.TempDir()
+	s := &server{
+		logf: t.Logf,
+		lb: &localState{
+			sshEnabled: true,
+			matchingRule: newSSHRule(
+				&tailcfg.SSHAction{
+					Accept: true,
+					Recorders: []netip.AddrPort{
+						netip.MustParseAddrPort(recordingServer.Listener.Addr().String()),
+					},
+					LocalRecording: &tailcfg.SSHLocalRecording{Dir: dir},
+					OnRecordingFailure: &tailcfg.SSHRecorderFailureAction{
+						RejectSessionWithMessage: "session rejected",
+					},
+				},
+			),
+		},
+	}
+	defer s.Shutdown()
+
+	cfg := &gossh.ClientConfig{
+		User:            "alice",
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	}
+	src, dst := must.Get(netip.ParseAddrPort("100.100.100.101:2231")), must.Get(netip.ParseAddrPort("100.100.100.102:22"))
+	sc, dc := memnet.NewTCPConn(src, dst, 1024)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c, chans, reqs, err := gossh.NewClientConn(sc, sc.RemoteAddr().String(), cfg)
+		if err != nil {
+			t.Errorf("client: %v", err)
+			return
+		}
+		client := gossh.NewClient(c, chans, reqs)
+		defer client.Close()
+		session, err := client.NewSession()
+		if err != nil {
+			t.Errorf("client: %v", err)
+			return
+		}
+		defer session.Close()
+		got, err := session.CombinedOutput("echo hello")
+		if err == nil {
+			t.Errorf("client did not get rejected: %q", got)
+		}
+		if !strings.HasSuffix(string(got), "session rejected\r\n") {
+			t.Errorf("client got %q, want session rejected", got)
+		}
+	}()
+	if err := s.HandleSSHConn(dc); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	wg.Wait()
+
+	// The local recording was opened before the upload was rejected; it
+	// must not be left behind for a session that never ran.
+	files, err := sessionrecording.ListLocal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("got %d recordings of a rejected session; want 0", len(files))
+	}
+}
+
+func TestSSHRecordersPrecedence(t *testing.T) {
+	rec1 := []netip.AddrPort{netip.MustParseAddrPort("100.64.0.1:80")}
+	rec2 := []netip.AddrPort{netip.MustParseAddrPort("100.64.0.2:80")}
+	lr1 := &tailcfg.SSHLocalRecording{Dir: "/one"}
+	lr2 := &tailcfg.SSHLocalRecording{Dir: "/two"}
+	fail1 := &tailcfg.SSHRecorderFailureAction{RejectSessionWithMessage: "one"}
+	fail2 := &tailcfg.SSHRecorderFailureAction{RejectSessionWithMessage: "two"}
+
+	tests := []struct {
+		name          string
+		action0       *tailcfg.SSHAction
+		final         *tailcfg.SSHAction
+		wantRecorders []netip.AddrPort
+		wantLocal     *tailcfg.SSHLocalRecording
+		wantFailure   *tailcfg.SSHRecorderFailureAction
+	}{
+		{
+			name:          "initial-only",
+			action0:       &tailcfg.SSHAction{Recorders: rec1, LocalRecording: lr1, OnRecordingFailure: fail1},
+			final:         &tailcfg.SSHAction{Accept: true},
+			wantRecorders: rec1,
+			wantLocal:     lr1,
+			wantFailure:   fail1,
+		},
+		{
+			name:          "final-overrides",
+			action0:       &tailcfg.SSHAction{Recorders: rec1, LocalRecording: lr1, OnRecordingFailure: fail1},
+			final:         &tailcfg.SSHAction{Recorders: rec2, LocalRecording: lr2, OnRecordingFailure: fail2},
+			wantRecorders: rec2,
+			wantLocal:     lr2,
+			wantFailure:   fail2,
+		},
+		{
+			// A final action adding only local recording keeps the
+			// initial action's recorders: the session is recorded both
+			// locally and remotely.
+			name:          "final-local-keeps-initial-recorders",
+			action0:       &tailcfg.SSHAction{Recorders: rec1, OnRecordingFailure: fail1},
+			final:         &tailcfg.SSHAction{LocalRecording: lr2, OnRecordingFailure: fail2},
+			wantRecorders: rec1,
+			wantLocal:     lr2,
+			wantFailure:   fail2,
+		},
+		{
+			name:          "final-recorders-keep-initial-local",
+			action0:       &tailcfg.SSHAction{LocalRecording: lr1, OnRecordingFailure: fail1},
+			final:         &tailcfg.SSHAction{Recorders: rec2, OnRecordingFailure: fail2},
+			wantRecorders: rec2,
+			wantLocal:     lr1,
+			wantFailure:   fail2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ss := &sshSession{conn: &conn{action0: tt.action0, finalAction: tt.final}}
+			recs, onFailure := ss.recorders()
+			if !slices.Equal(recs, tt.wantRecorders) {
+				t.Errorf("recorders = %v; want %v", recs, tt.wantRecorders)
+			}
+			if onFailure != tt.wantFailure {
+				t.Errorf("failure action = %v; want %v", onFailure, tt.wantFailure)
+			}
+			if lr := ss.localRecording(); lr != tt.wantLocal {
+				t.Errorf("localRecording = %v; want %v", lr, tt.wantLocal)
+			}
+		})
+	}
+}
+
+func TestListenAgentSocket(t *testing.T) {
+	ln, err := listenAgentSocket(os.Getuid(), os.Getgid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := ln.Addr().String()
+	dir := filepath.Dir(socket)
+	for path, want := range map[string]fs.FileMode{dir: 0700, socket: 0600} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fi.Mode().Perm(); got != want {
+			t.Errorf("%s: mode %v; want %v", path, got, want)
+		}
+	}
+	c, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	if err := ln.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("socket directory not removed: %v", err)
+	}
+}
+
 func TestSSHAuthFlow(t *testing.T) {
 	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
 		t.Skipf("skipping on %q; only runs on linux and darwin", runtime.GOOS)
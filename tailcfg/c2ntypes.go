@@ -5,7 +5,10 @@
 
 package tailcfg
 
-import "net/netip"
+import (
+	"encoding/json"
+	"net/netip"
+)
 
 // C2NSSHUsernamesRequest is the request for the /ssh/usernames.
 // A GET request without a request body is equivalent to the zero value of this type.
@@ -117,3 +120,25 @@ type C2NVIPServicesResponse struct {
 	// changes. This value matches what is reported in latest [Hostinfo.ServicesHash].
 	ServicesHash string
 }
+
+// C2NRemoteDiagResponse is the response (from node to control) from the
+// /debug/remote-diag handler, with which control collects diagnostics from a
+// node whose local policy permits it.
+type C2NRemoteDiagResponse struct {
+	// Kind is the kind of diagnostics requested: "netcheck", "bugreport"
+	// or "routes".
+	Kind string
+
+	// Netcheck is the node's latest netcheck report, in JSON, for the
+	// "netcheck" and "bugreport" kinds.
+	Netcheck json.RawMessage `json:",omitempty"`
+
+	// BugReportMarker, for the "bugreport" kind, is the marker logged by the
+	// node, which finds its logs. Health is the node's health warnings.
+	BugReportMarker string   `json:",omitempty"`
+	Health          []string `json:",omitempty"`
+
+	// Routes is the node's OS route table, one route per line, for the
+	// "routes" and "bugreport" kinds.
+	Routes []string `json:",omitempty"`
+}
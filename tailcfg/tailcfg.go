@@ -158,7 +158,10 @@
 //   - 111: 2025-01-14: Client supports a peer having Node.HomeDERP (issue #14636)
 //   - 112: 2025-01-14: Client interprets AllowedIPs of nil as meaning same as Addresses
 //   - 113: 2025-01-20: Client communicates to control whether funnel is enabled by sending Hostinfo.IngressEnabled (#14688)
-const CurrentCapabilityVersion CapabilityVersion = 113
+//   - 114: 2026-10-16: Client supports device-code interactive login (RegisterRequest.DeviceCode, RegisterResponse.UserCode)
+//   - 115: 2026-10-16: Client supports c2n /debug/remote-diag, subject to Prefs.RemoteDiagnostics
+//   - 116: 2026-10-16: Client supports SSHAction.LocalRecording
+const CurrentCapabilityVersion CapabilityVersion = 116
 
 // ID is an integer ID for a user, node, or login allocated by the
 // control plane.
@@ -1266,6 +1269,12 @@ type RegisterRequest struct {
 	// when it stops being active.
 	Ephemeral bool `json:",omitempty"`
 
+	// DeviceCode is whether the client, if interactive login is needed,
+	// is requesting an OAuth 2.0 device authorization grant (RFC 8628)
+	// with the identity provider: a short code for the user to enter at
+	// AuthURL on another device, for machines without a browser.
+	DeviceCode bool `json:",omitempty"`
+
 	// NodeKeySignature is the node's own node-key signature, re-signed
 	// for its new node key using its network-lock key.
 	//
@@ -1300,6 +1309,11 @@ type RegisterResponse struct {
 	MachineAuthorized bool   // TODO(crawshaw): move to using MachineStatus
 	AuthURL           string // if set, authorization pending
 
+	// UserCode, if non-empty, is the code the user must enter at AuthURL
+	// to log in, in response to a RegisterRequest with DeviceCode set.
+	// Completion is awaited with Followup as for any other AuthURL.
+	UserCode string `json:",omitempty"`
+
 	// If set, this is the current node-key signature that needs to be
 	// re-signed for the node's new node-key.
 	NodeKeySignature tkatype.MarshaledSignature
@@ -2376,6 +2390,18 @@ type Oauth2Token struct {
 	// fixed port.
 	NodeAttrRandomizeClientPort NodeCapability = "randomize-client-port"
 
+	// NodeAttrMagicsockPortRange restricts the UDP ports magicsock binds
+	// to to a range, for networks whose firewalls only allow some ports
+	// outbound. Its value is a single PortRange with a non-zero First.
+	// A port range given to tailscaled locally takes precedence.
+	NodeAttrMagicsockPortRange NodeCapability = "magicsock-port-range"
+
+	// NodeAttrMagicsockMultipathFailover makes magicsock duplicate packets
+	// to a peer over both its old direct path and DERP (or a new direct
+	// path) for a few seconds after the old path degrades, to avoid gaps
+	// while failing over, such as from Wi-Fi to cellular.
+	NodeAttrMagicsockMultipathFailover NodeCapability = "magicsock-multipath-failover"
+
 	// NodeAttrSilentDisco makes the client suppress disco heartbeats to its
 	// peers.
 	NodeAttrSilentDisco NodeCapability = "silent-disco"
@@ -2723,6 +2749,32 @@ type SSHAction struct {
 	// OnRecorderFailure is the action to take if recording fails.
 	// If nil, the default action is to fail open.
 	OnRecordingFailure *SSHRecorderFailureAction `json:"onRecordingFailure,omitempty"`
+
+	// LocalRecording, if non-nil, specifies that sessions are also
+	// recorded to files on the destination node, for tailnets without a
+	// recorder node. It may be used with or without Recorders, and
+	// OnRecordingFailure applies to it too.
+	LocalRecording *SSHLocalRecording `json:"localRecording,omitempty"`
+}
+
+// SSHLocalRecording is where and how many SSH session recordings are kept
+// on the destination node. The recordings are asciinema cast files, the
+// same as those sent to recorder nodes.
+type SSHLocalRecording struct {
+	// Dir is the absolute path of the directory to write recordings in.
+	// If empty, it is the "ssh-sessions" directory in tailscaled's state
+	// directory. Recording fails, as handled by OnRecordingFailure, if Dir
+	// traverses a symlink, is owned by a user other than root or
+	// tailscaled's, or is world-writable.
+	Dir string `json:",omitempty"`
+
+	// MaxFiles, if positive, is how many recordings to keep in Dir. The
+	// oldest are deleted as new sessions start.
+	MaxFiles int `json:",omitempty"`
+
+	// MaxBytes, if positive, is how many bytes of recordings to keep in
+	// Dir. The oldest are deleted as new sessions start.
+	MaxBytes int64 `json:",omitempty"`
 }
 
 // SSHRecorderFailureAction is the action to take if recording fails.
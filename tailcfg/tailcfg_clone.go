@@ -312,6 +312,7 @@ func (src *RegisterResponse) Clone() *RegisterResponse {
 	NodeKeyExpired    bool
 	MachineAuthorized bool
 	AuthURL           string
+	UserCode          string
 	NodeKeySignature  tkatype.MarshaledSignature
 	Error             string
 }{})
@@ -368,6 +369,7 @@ func (src *RegisterRequest) Clone() *RegisterRequest {
 	Followup         string
 	Hostinfo         *Hostinfo
 	Ephemeral        bool
+	DeviceCode       bool
 	NodeKeySignature tkatype.MarshaledSignature
 	SignatureType    SignatureType
 	Timestamp        *time.Time
@@ -529,6 +531,9 @@ func (src *SSHAction) Clone() *SSHAction {
 	if dst.OnRecordingFailure != nil {
 		dst.OnRecordingFailure = ptr.To(*src.OnRecordingFailure)
 	}
+	if dst.LocalRecording != nil {
+		dst.LocalRecording = ptr.To(*src.LocalRecording)
+	}
 	return dst
 }
 
@@ -544,6 +549,7 @@ func (src *SSHAction) Clone() *SSHAction {
 	AllowRemotePortForwarding bool
 	Recorders                 []netip.AddrPort
 	OnRecordingFailure        *SSHRecorderFailureAction
+	LocalRecording            *SSHLocalRecording
 }{})
 
 // Clone makes a deep copy of SSHPrincipal.
@@ -617,6 +617,7 @@ func (v RegisterResponseView) Login() Login            { return v.ж.Login }
 func (v RegisterResponseView) NodeKeyExpired() bool    { return v.ж.NodeKeyExpired }
 func (v RegisterResponseView) MachineAuthorized() bool { return v.ж.MachineAuthorized }
 func (v RegisterResponseView) AuthURL() string         { return v.ж.AuthURL }
+func (v RegisterResponseView) UserCode() string        { return v.ж.UserCode }
 func (v RegisterResponseView) NodeKeySignature() views.ByteSlice[tkatype.MarshaledSignature] {
 	return views.ByteSliceOf(v.ж.NodeKeySignature)
 }
@@ -629,6 +630,7 @@ func (v RegisterResponseView) Error() string { return v.ж.Error }
 	NodeKeyExpired    bool
 	MachineAuthorized bool
 	AuthURL           string
+	UserCode          string
 	NodeKeySignature  tkatype.MarshaledSignature
 	Error             string
 }{})
@@ -745,6 +747,7 @@ func (v RegisterRequestView) Expiry() time.Time              { return v.ж.Expir
 func (v RegisterRequestView) Followup() string               { return v.ж.Followup }
 func (v RegisterRequestView) Hostinfo() HostinfoView         { return v.ж.Hostinfo.View() }
 func (v RegisterRequestView) Ephemeral() bool                { return v.ж.Ephemeral }
+func (v RegisterRequestView) DeviceCode() bool               { return v.ж.DeviceCode }
 func (v RegisterRequestView) NodeKeySignature() views.ByteSlice[tkatype.MarshaledSignature] {
 	return views.ByteSliceOf(v.ж.NodeKeySignature)
 }
@@ -773,6 +776,7 @@ func (v RegisterRequestView) Tailnet() string { return v.ж.Tailnet }
 	Followup         string
 	Hostinfo         *Hostinfo
 	Ephemeral        bool
+	DeviceCode       bool
 	NodeKeySignature tkatype.MarshaledSignature
 	SignatureType    SignatureType
 	Timestamp        *time.Time
@@ -1160,6 +1164,10 @@ func (v SSHActionView) OnRecordingFailure() views.ValuePointer[SSHRecorderFailur
 	return views.ValuePointerOf(v.ж.OnRecordingFailure)
 }
 
+func (v SSHActionView) LocalRecording() views.ValuePointer[SSHLocalRecording] {
+	return views.ValuePointerOf(v.ж.LocalRecording)
+}
+
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _SSHActionViewNeedsRegeneration = SSHAction(struct {
 	Message                   string
@@ -1172,6 +1180,7 @@ func (v SSHActionView) OnRecordingFailure() views.ValuePointer[SSHRecorderFailur
 	AllowRemotePortForwarding bool
 	Recorders                 []netip.AddrPort
 	OnRecordingFailure        *SSHRecorderFailureAction
+	LocalRecording            *SSHLocalRecording
 }{})
 
 // View returns a read-only view of SSHPrincipal.
@@ -0,0 +1,251 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"tailscale.com/logtail/backoff"
+	"tailscale.com/types/logger"
+)
+
+// Files can be sent in chunks, each a separate request to the receiver, so
+// that a transfer interrupted by a network blip resumes from the start of the
+// chunk that failed rather than from the start of the file. The receiver
+// persists the checksum of each chunk it has received next to the partial
+// file, so that a later attempt to send the file can also skip the chunks
+// the receiver already has without having it hash the whole partial file.
+
+// These are vars for tests.
+var (
+	// chunkSize is the size of the chunks sent by SendChunks.
+	chunkSize = 16 << 20
+
+	// chunkRetryTimeout is how long SendChunks retries a chunk for.
+	chunkRetryTimeout = 2 * time.Minute
+)
+
+// ErrChunkRejected is wrapped by errors returned from a [ChunkPutFunc] when
+// the receiver refused the chunk, such that sending it again is pointless.
+var ErrChunkRejected = errors.New("chunk rejected")
+
+// ChunkChecksum is the checksum of a chunk of a file sent in chunks.
+type ChunkChecksum struct {
+	Offset   int64    `json:"offset"`
+	Size     int64    `json:"size"`
+	Checksum Checksum `json:"checksum"` // SHA-256
+}
+
+// PutFileChunk is like [Manager.PutFile] but for a file sent in chunks: it
+// writes the chunk read from r at offset in the partial file, and unless
+// final is set, leaves the partial file in place for the next chunk and
+// records the chunk's checksum, as returned by [Manager.PartialChunks].
+// totalSize is the size of the whole file, or -1 if unknown. md is the
+// metadata of the whole file, as with [Manager.PutFileWithMetadata], and is
+// only used with the final chunk.
+// It returns the checksum of the chunk.
+func (m *Manager) PutFileChunk(id ClientID, baseName string, r io.Reader, offset, length, totalSize int64, final bool, md FileMetadata) (ChunkChecksum, error) {
+	n, sum, err := m.putFile(id, baseName, r, offset, length, &putChunk{totalSize: totalSize, final: final}, md)
+	if err != nil {
+		return ChunkChecksum{}, err
+	}
+	return ChunkChecksum{Offset: offset, Size: n - offset, Checksum: sum}, nil
+}
+
+// PartialChunks returns the checksums of the chunks of the partial file sent
+// by the provided id that were received with [Manager.PutFileChunk], in
+// order from the start of the file.
+func (m *Manager) PartialChunks(id ClientID, baseName string) ([]ChunkChecksum, error) {
+	if m == nil || m.opts.Dir == "" {
+		return nil, ErrNoTaildrop
+	}
+	dstPath, err := joinDir(m.opts.Dir, baseName)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(dstPath + id.partialSuffix())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, redactError(err)
+	}
+	chunks, err := readChunks(dstPath + id.chunksSuffix())
+	if err != nil {
+		return nil, redactError(err)
+	}
+	// The partial file may have been truncated or written to by PutFile
+	// since; only report the chunks it still holds.
+	var end int64
+	for i, c := range chunks {
+		if c.Offset != end || c.Offset+c.Size > fi.Size() {
+			return chunks[:i], nil
+		}
+		end += c.Size
+	}
+	return chunks, nil
+}
+
+// ResumeOffset returns the offset from which a send of the file whose
+// receiver has chunks, as returned by [Manager.PartialChunks], resumes if
+// the chunks match the file's content: the end of the chunks that follow
+// one another from the start of the file.
+func ResumeOffset(chunks []ChunkChecksum) int64 {
+	var end int64
+	for _, c := range chunks {
+		if c.Offset != end || c.Size <= 0 {
+			break
+		}
+		end += c.Size
+	}
+	return end
+}
+
+// readChunks returns the chunk checksums in the file at path, or none if it
+// doesn't exist.
+func readChunks(path string) ([]ChunkChecksum, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var chunks []ChunkChecksum
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		var c ChunkChecksum
+		if err := json.Unmarshal(sc.Bytes(), &c); err != nil {
+			// A torn write; ignore it and what follows.
+			break
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks, nil
+}
+
+// appendChunk records c in the chunk checksums file at path.
+func appendChunk(path string, c ChunkChecksum) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// trimChunks removes the checksums of chunks extending past offset from the
+// chunk checksums file at path, if any.
+func trimChunks(path string, offset int64) error {
+	chunks, err := readChunks(path)
+	if err != nil || len(chunks) == 0 {
+		return err
+	}
+	keep := chunks[:0]
+	for _, c := range chunks {
+		if c.Offset+c.Size <= offset {
+			keep = append(keep, c)
+		}
+	}
+	if len(keep) == len(chunks) {
+		return nil
+	}
+	if len(keep) == 0 {
+		return os.Remove(path)
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, c := range keep {
+		if err := enc.Encode(c); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, buf.Bytes(), 0666)
+}
+
+// ChunkPutFunc sends chunk, found at offset in the file, to the receiver,
+// which must pass it to [Manager.PutFileChunk], and returns the checksum the
+// receiver computed. final is whether it's the last chunk.
+type ChunkPutFunc func(ctx context.Context, offset int64, chunk []byte, final bool) (ChunkChecksum, error)
+
+// SendChunks sends the content of r using put, one chunk at a time. A chunk
+// that fails to send is retried, for up to a couple of minutes, unless the
+// error wraps [ErrChunkRejected].
+//
+// have is the chunks the receiver already has, as returned by its
+// [Manager.PartialChunks]; those at the start of r with matching checksums
+// are skipped. SendChunks returns the number of bytes skipped that way.
+func SendChunks(ctx context.Context, logf logger.Logf, r io.Reader, have []ChunkChecksum, put ChunkPutFunc) (resumed int64, err error) {
+	buf := make([]byte, chunkSize)
+
+	// Skip the chunks the receiver has. The first that doesn't match,
+	// having been read, starts the first chunk to send.
+	var n int
+	for _, c := range have {
+		if c.Offset != resumed || c.Size <= 0 || c.Size > int64(len(buf)) {
+			break
+		}
+		n, err = io.ReadFull(r, buf[:c.Size])
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return resumed, err
+		}
+		if int64(n) != c.Size || hash(buf[:n]) != c.Checksum {
+			break
+		}
+		resumed += int64(n)
+		n = 0
+	}
+
+	offset := resumed
+	for {
+		m, err := io.ReadFull(r, buf[n:])
+		n += m
+		final := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !final {
+			return resumed, err
+		}
+		if err := sendChunk(ctx, logf, put, offset, buf[:n], final); err != nil {
+			return resumed, err
+		}
+		offset += int64(n)
+		n = 0
+		if final {
+			return resumed, nil
+		}
+	}
+}
+
+// sendChunk sends chunk with put, retrying on failure.
+func sendChunk(ctx context.Context, logf logger.Logf, put ChunkPutFunc, offset int64, chunk []byte, final bool) error {
+	want := ChunkChecksum{Offset: offset, Size: int64(len(chunk)), Checksum: hash(chunk)}
+	bo := backoff.NewBackoff("taildrop-chunk", logf, 10*time.Second)
+	deadline := time.Now().Add(chunkRetryTimeout)
+	for {
+		got, err := put(ctx, offset, chunk, final)
+		if err == nil && got != want {
+			err = fmt.Errorf("receiver got chunk %+v, want %+v", got, want)
+		}
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || errors.Is(err, ErrChunkRejected) || time.Now().After(deadline) {
+			return err
+		}
+		logf("retrying chunk at offset %d: %v", offset, err)
+		bo.BackOff(ctx, err)
+	}
+}
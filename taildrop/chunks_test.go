@@ -0,0 +1,129 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+	"testing/iotest"
+
+	"tailscale.com/util/must"
+)
+
+func TestSendChunks(t *testing.T) {
+	oldChunkSize := chunkSize
+	defer func() { chunkSize = oldChunkSize }()
+	chunkSize = 1024
+
+	m := ManagerOptions{Logf: t.Logf, Dir: t.TempDir()}.New()
+	defer m.Shutdown()
+
+	rn := rand.New(rand.NewSource(0))
+	want := make([]byte, 10*chunkSize+123)
+	must.Get(io.ReadFull(rn, want))
+
+	var calls int
+	put := func(failAt func(call int) error) ChunkPutFunc {
+		return func(ctx context.Context, offset int64, chunk []byte, final bool) (ChunkChecksum, error) {
+			calls++
+			r := io.Reader(bytes.NewReader(chunk))
+			if err := failAt(calls); err != nil {
+				// Fail halfway through, like a connection that drops.
+				r = io.MultiReader(io.LimitReader(r, int64(len(chunk)/2)), iotest.ErrReader(err))
+			}
+			return m.PutFileChunk("id", "foo", r, offset, -1, int64(len(want)), final, FileMetadata{})
+		}
+	}
+
+	// Send four chunks, with the third failing once and being retried,
+	// then give up.
+	_, err := SendChunks(context.Background(), t.Logf, bytes.NewReader(want), nil, put(func(call int) error {
+		switch call {
+		case 3:
+			return io.ErrClosedPipe
+		case 6:
+			return ErrChunkRejected
+		}
+		return nil
+	}))
+	if !errors.Is(err, ErrChunkRejected) {
+		t.Fatalf("SendChunks = %v, want ErrChunkRejected", err)
+	}
+	have := must.Get(m.PartialChunks("id", "foo"))
+	if len(have) != 4 {
+		t.Fatalf("receiver has %d chunks, want 4", len(have))
+	}
+	if files := must.Get(m.PartialFiles("id")); len(files) != 1 {
+		t.Errorf("PartialFiles = %q, want just the partial file", files)
+	}
+
+	// Resume.
+	calls = 0
+	resumed, err := SendChunks(context.Background(), t.Logf, bytes.NewReader(want), have, put(func(int) error { return nil }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed != int64(4*chunkSize) {
+		t.Errorf("resumed at %d, want %d", resumed, 4*chunkSize)
+	}
+	if calls != 7 {
+		t.Errorf("sent %d chunks, want 7", calls)
+	}
+	got := must.Get(os.ReadFile(must.Get(joinDir(m.opts.Dir, "foo"))))
+	if !bytes.Equal(got, want) {
+		t.Errorf("content mismatches")
+	}
+	if files := must.Get(m.PartialFiles("id")); len(files) != 0 {
+		t.Errorf("PartialFiles = %q after completion", files)
+	}
+	if _, err := os.Stat(must.Get(joinDir(m.opts.Dir, "foo")) + ClientID("id").chunksSuffix()); !os.IsNotExist(err) {
+		t.Errorf("chunk checksums not removed: %v", err)
+	}
+}
+
+func TestPartialChunksAfterTruncate(t *testing.T) {
+	m := ManagerOptions{Logf: t.Logf, Dir: t.TempDir()}.New()
+	defer m.Shutdown()
+
+	chunk := bytes.Repeat([]byte("x"), 100)
+	for off := int64(0); off < 300; off += 100 {
+		must.Get(m.PutFileChunk("", "foo", bytes.NewReader(chunk), off, -1, -1, false, FileMetadata{}))
+	}
+	if got := must.Get(m.PartialChunks("", "foo")); len(got) != 3 {
+		t.Fatalf("got %d chunks, want 3", len(got))
+	}
+
+	// An interrupted PutFile resuming at 150 overwrites the last
+	// chunk and a half.
+	r := io.MultiReader(bytes.NewReader(chunk), iotest.ErrReader(io.ErrClosedPipe))
+	if _, err := m.PutFile("", "foo", r, 150, -1); err == nil {
+		t.Fatal("PutFile succeeded")
+	}
+	if got := must.Get(m.PartialChunks("", "foo")); len(got) != 1 {
+		t.Errorf("got %d chunks, want 1", len(got))
+	}
+}
+
+func TestResumeOffset(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []ChunkChecksum
+		want   int64
+	}{
+		{"none", nil, 0},
+		{"contiguous", []ChunkChecksum{{Offset: 0, Size: 100}, {Offset: 100, Size: 50}}, 150},
+		{"gap", []ChunkChecksum{{Offset: 0, Size: 100}, {Offset: 200, Size: 100}}, 100},
+		{"not-from-start", []ChunkChecksum{{Offset: 100, Size: 100}}, 0},
+	}
+	for _, tt := range tests {
+		if got := ResumeOffset(tt.chunks); got != tt.want {
+			t.Errorf("%s: ResumeOffset = %d; want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -94,6 +94,7 @@ func (d *fileDeleter) Init(m *Manager, eventHook func(string)) {
 				// Best-effort immediate deletion of deleted files.
 				name := strings.TrimSuffix(de.Name(), deletedSuffix)
 				if os.Remove(filepath.Join(d.dir, name)) == nil {
+					os.Remove(filepath.Join(d.dir, name+metaSuffix))
 					if os.Remove(filepath.Join(d.dir, de.Name())) == nil {
 						break
 					}
@@ -154,6 +155,7 @@ func (d *fileDeleter) waitAndDelete(wait time.Duration) {
 					failed = append(failed, elem)
 					continue
 				}
+				os.Remove(filepath.Join(d.dir, name+metaSuffix))
 			}
 			if err := os.Remove(filepath.Join(d.dir, file.name)); err != nil && !os.IsNotExist(err) {
 				d.logf("could not delete: %v", redactError(err))
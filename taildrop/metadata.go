@@ -0,0 +1,158 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// ErrChecksumMismatch is returned by [Manager.PutFileWithMetadata] and
+// [Manager.PutFileChunk] when the received file doesn't match the SHA-256
+// the sender declared for it.
+var ErrChecksumMismatch = errors.New("file doesn't match its checksum")
+
+// FileMetadata is metadata about a file sent along with it.
+type FileMetadata struct {
+	// SHA256, if non-empty, is the hex SHA-256 of the whole file.
+	// The file is rejected if the received contents don't match.
+	SHA256 string `json:",omitempty"`
+
+	// ModTime and Mode, if non-zero, are the modification time and
+	// permission bits the file had on the sender, to be preserved.
+	ModTime time.Time   `json:",omitzero"`
+	Mode    fs.FileMode `json:",omitempty"`
+
+	// Archive, if non-empty, is the format of the archive of a directory
+	// the file is: "tar". It's passed on to whoever picks the file up,
+	// to extract it.
+	Archive string `json:",omitempty"`
+}
+
+func (md FileMetadata) isZero() bool {
+	return md == FileMetadata{}
+}
+
+// FileMetadataFromHeader returns the metadata in the apitype.Taildrop*Header
+// headers of h, as set by [FileMetadata.SetHeader].
+func FileMetadataFromHeader(h http.Header) (md FileMetadata, err error) {
+	if v := h.Get(apitype.TaildropSHA256Header); v != "" {
+		b, err := hex.DecodeString(v)
+		if err != nil || len(b) != sha256.Size {
+			return md, fmt.Errorf("invalid %s %q", apitype.TaildropSHA256Header, v)
+		}
+		md.SHA256 = hex.EncodeToString(b)
+	}
+	if v := h.Get(apitype.TaildropModTimeHeader); v != "" {
+		md.ModTime, err = time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return md, fmt.Errorf("invalid %s %q", apitype.TaildropModTimeHeader, v)
+		}
+	}
+	if v := h.Get(apitype.TaildropModeHeader); v != "" {
+		mode, err := strconv.ParseUint(v, 8, 32)
+		if err != nil || fs.FileMode(mode) != fs.FileMode(mode).Perm() {
+			return md, fmt.Errorf("invalid %s %q", apitype.TaildropModeHeader, v)
+		}
+		md.Mode = fs.FileMode(mode)
+	}
+	if v := h.Get(apitype.TaildropArchiveHeader); v != "" {
+		if v != "tar" {
+			return md, fmt.Errorf("unsupported %s %q", apitype.TaildropArchiveHeader, v)
+		}
+		md.Archive = v
+	}
+	return md, nil
+}
+
+// SetHeader sets the apitype.Taildrop*Header headers in h for the non-zero
+// fields of md.
+func (md FileMetadata) SetHeader(h http.Header) {
+	if md.SHA256 != "" {
+		h.Set(apitype.TaildropSHA256Header, md.SHA256)
+	}
+	if !md.ModTime.IsZero() {
+		h.Set(apitype.TaildropModTimeHeader, md.ModTime.UTC().Format(time.RFC3339Nano))
+	}
+	if md.Mode != 0 {
+		h.Set(apitype.TaildropModeHeader, strconv.FormatUint(uint64(md.Mode.Perm()), 8))
+	}
+	if md.Archive != "" {
+		h.Set(apitype.TaildropArchiveHeader, md.Archive)
+	}
+}
+
+// verify returns ErrChecksumMismatch if md.SHA256 is set and the file at
+// path doesn't match it.
+func (md FileMetadata) verify(path string) error {
+	if md.SHA256 == "" {
+		return nil
+	}
+	sum, err := sha256File(path)
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(sum[:]) != md.SHA256 {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// apply sets the modification time and permission bits of the file at path
+// to those in md, where set. Only the sender's owner permission bits and the
+// read and execute bits of the others are honored, so a sender can't make a
+// file writable by other users of the receiving machine.
+func (md FileMetadata) apply(path string) error {
+	if md.Mode != 0 {
+		if err := os.Chmod(path, md.Mode.Perm()&^0o022); err != nil {
+			return err
+		}
+	}
+	if !md.ModTime.IsZero() {
+		if err := os.Chtimes(path, time.Time{}, md.ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMetadata records md in the metadata file next to the file at path, in
+// which it waits to be picked up, or removes the metadata file of a previous
+// file of that name if md is zero.
+func writeMetadata(path string, md FileMetadata) error {
+	if md.isZero() {
+		if err := os.Remove(path + metaSuffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	b, err := json.Marshal(md)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+metaSuffix, b, 0666)
+}
+
+// readMetadata returns the metadata recorded for the file at path with
+// writeMetadata, if any.
+func readMetadata(path string) (md FileMetadata, err error) {
+	b, err := os.ReadFile(path + metaSuffix)
+	if os.IsNotExist(err) {
+		return md, nil
+	} else if err != nil {
+		return md, err
+	}
+	err = json.Unmarshal(b, &md)
+	return md, err
+}
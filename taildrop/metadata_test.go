@@ -0,0 +1,101 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/util/must"
+)
+
+func TestPutFileWithMetadata(t *testing.T) {
+	content := "hello, world"
+	sum := sha256.Sum256([]byte(content))
+	md := FileMetadata{
+		SHA256:  hex.EncodeToString(sum[:]),
+		ModTime: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Mode:    0o755,
+	}
+
+	dir := t.TempDir()
+	m := ManagerOptions{Logf: t.Logf, Dir: dir}.New()
+	defer m.Shutdown()
+
+	bad := md
+	bad.SHA256 = strings.Repeat("0", 64)
+	_, err := m.PutFileWithMetadata("", "foo", strings.NewReader(content), 0, -1, bad)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("PutFileWithMetadata with wrong checksum = %v, want ErrChecksumMismatch", err)
+	}
+	if files := must.Get(m.PartialFiles("")); len(files) != 0 {
+		t.Errorf("PartialFiles = %q after checksum mismatch", files)
+	}
+
+	must.Get(m.PutFileWithMetadata("", "foo", strings.NewReader(content), 0, -1, md))
+	wfs := must.Get(m.WaitingFiles())
+	if len(wfs) != 1 {
+		t.Fatalf("WaitingFiles = %+v, want one file", wfs)
+	}
+	if wf := wfs[0]; wf.SHA256 != md.SHA256 || !wf.ModTime.Equal(md.ModTime) || wf.Mode != md.Mode {
+		t.Errorf("WaitingFiles = %+v, want metadata %+v", wf, md)
+	}
+	if err := m.DeleteFile("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "foo"+metaSuffix)); !os.IsNotExist(err) {
+		t.Errorf("metadata not deleted with file: %v", err)
+	}
+
+	// In direct file mode, the metadata is applied to the file.
+	dir = t.TempDir()
+	m = ManagerOptions{Logf: t.Logf, Dir: dir, DirectFileMode: true}.New()
+	defer m.Shutdown()
+	must.Get(m.PutFileWithMetadata("", "foo", strings.NewReader(content), 0, -1, md))
+	fi := must.Get(os.Stat(filepath.Join(dir, "foo")))
+	if !fi.ModTime().Equal(md.ModTime) {
+		t.Errorf("ModTime = %v, want %v", fi.ModTime(), md.ModTime)
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm() != md.Mode {
+		t.Errorf("Mode = %v, want %v", fi.Mode().Perm(), md.Mode)
+	}
+}
+
+func TestFileMetadataHeader(t *testing.T) {
+	md := FileMetadata{
+		SHA256:  strings.Repeat("ab", sha256.Size),
+		ModTime: time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC),
+		Mode:    0o640,
+		Archive: "tar",
+	}
+	h := make(http.Header)
+	md.SetHeader(h)
+	got, err := FileMetadataFromHeader(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != md {
+		t.Errorf("round trip = %+v, want %+v", got, md)
+	}
+
+	for _, kv := range [][2]string{
+		{"Taildrop-Sha256", "abc"},
+		{"Taildrop-Mod-Time", "yesterday"},
+		{"Taildrop-Mode", "4755"},
+		{"Taildrop-Archive", "zip"},
+	} {
+		h := http.Header{kv[0]: {kv[1]}}
+		if _, err := FileMetadataFromHeader(h); err == nil {
+			t.Errorf("%s: %q: got no error", kv[0], kv[1])
+		}
+	}
+}
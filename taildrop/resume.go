@@ -58,7 +58,7 @@ func (m *Manager) PartialFiles(id ClientID) (ret []string, err error) {
 
 	suffix := id.partialSuffix()
 	if err := rangeDir(m.opts.Dir, func(de fs.DirEntry) bool {
-		if name := de.Name(); strings.HasSuffix(name, suffix) {
+		if name := de.Name(); strings.HasSuffix(name, suffix) && !strings.HasSuffix(name, chunksSuffix) {
 			ret = append(ret, name)
 		}
 		return true
@@ -79,9 +79,17 @@ func (m *Manager) WaitingFiles() (ret []apitype.WaitingFile, err error) {
 			if err != nil {
 				return true
 			}
+			md, err := readMetadata(filepath.Join(m.opts.Dir, name))
+			if err != nil {
+				m.opts.Logf("reading metadata: %v", redactError(err))
+			}
 			ret = append(ret, apitype.WaitingFile{
-				Name: filepath.Base(name),
-				Size: fi.Size(),
+				Name:    filepath.Base(name),
+				Size:    fi.Size(),
+				SHA256:  md.SHA256,
+				ModTime: md.ModTime,
+				Mode:    md.Mode,
+				Archive: md.Archive,
 			})
 		}
 		return true
@@ -137,6 +145,9 @@ func (m *Manager) DeleteFile(baseName string) error {
 			logf("peerapi: failed to DeleteFile: %v", err)
 			return err
 		}
+		if err := os.Remove(path + metaSuffix); err != nil && !os.IsNotExist(err) {
+			logf("peerapi: failed to delete metadata: %v", redactError(err))
+		}
 		return nil
 	}
 }
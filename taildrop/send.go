@@ -6,6 +6,7 @@
 import (
 	"crypto/sha256"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -74,17 +75,42 @@ func (f *incomingFile) Write(p []byte) (n int, err error) {
 // a partial file. While resuming, PutFile may be called again with a non-zero
 // offset to specify where to resume receiving data at.
 func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, length int64) (int64, error) {
+	return m.PutFileWithMetadata(id, baseName, r, offset, length, FileMetadata{})
+}
+
+// PutFileWithMetadata is like [Manager.PutFile] but with metadata sent along
+// with the file. If md.SHA256 is set, the whole file, including any part of
+// it received earlier, must match it, or else [ErrChecksumMismatch] is
+// returned and the partial file deleted. The modification time and
+// permission bits in md are applied to the file in direct file mode, and
+// otherwise reported by [Manager.WaitingFiles].
+func (m *Manager) PutFileWithMetadata(id ClientID, baseName string, r io.Reader, offset, length int64, md FileMetadata) (int64, error) {
+	n, _, err := m.putFile(id, baseName, r, offset, length, nil, md)
+	return n, err
+}
+
+// putChunk describes a chunk of a file sent with [Manager.PutFileChunk].
+type putChunk struct {
+	totalSize int64 // of the whole file, or -1 if unknown
+	final     bool  // whether it's the last chunk
+}
+
+// putFile implements PutFileWithMetadata and, if chunk is non-nil,
+// PutFileChunk, in which case it also returns the checksum of the content
+// read from r. Unless chunk is final, the partial file is left in place for
+// the next chunk rather than renamed, and md is ignored.
+func (m *Manager) putFile(id ClientID, baseName string, r io.Reader, offset, length int64, chunk *putChunk, md FileMetadata) (_ int64, _ Checksum, err error) {
 	switch {
 	case m == nil || m.opts.Dir == "":
-		return 0, ErrNoTaildrop
+		return 0, Checksum{}, ErrNoTaildrop
 	case !envknob.CanTaildrop():
-		return 0, ErrNoTaildrop
+		return 0, Checksum{}, ErrNoTaildrop
 	case distro.Get() == distro.Unraid && !m.opts.DirectFileMode:
-		return 0, ErrNotAccessible
+		return 0, Checksum{}, ErrNotAccessible
 	}
 	dstPath, err := joinDir(m.opts.Dir, baseName)
 	if err != nil {
-		return 0, err
+		return 0, Checksum{}, err
 	}
 
 	redactAndLogError := func(action string, err error) error {
@@ -95,6 +121,7 @@ func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, len
 
 	// Check whether there is an in-progress transfer for the file.
 	partialPath := dstPath + id.partialSuffix()
+	chunksPath := dstPath + id.chunksSuffix()
 	inFileKey := incomingFileKey{id, baseName}
 	inFile, loaded := m.incomingFiles.LoadOrInit(inFileKey, func() *incomingFile {
 		inFile := &incomingFile{
@@ -103,6 +130,11 @@ func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, len
 			size:           length,
 			sendFileNotify: m.opts.SendFileNotify,
 		}
+		if chunk != nil {
+			// Report the progress of the whole file.
+			inFile.size = chunk.totalSize
+			inFile.copied = offset
+		}
 		if m.opts.DirectFileMode {
 			inFile.partialPath = partialPath
 			inFile.finalPath = dstPath
@@ -110,20 +142,25 @@ func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, len
 		return inFile
 	})
 	if loaded {
-		return 0, ErrFileExists
+		return 0, Checksum{}, ErrFileExists
 	}
 	defer m.incomingFiles.Delete(inFileKey)
 	m.deleter.Remove(filepath.Base(partialPath)) // avoid deleting the partial file while receiving
+	m.deleter.Remove(filepath.Base(chunksPath))
 
 	// Create (if not already) the partial file with read-write permissions.
 	f, err := os.OpenFile(partialPath, os.O_CREATE|os.O_RDWR, 0666)
 	if err != nil {
-		return 0, redactAndLogError("Create", err)
+		return 0, Checksum{}, redactAndLogError("Create", err)
 	}
+	done := false
 	defer func() {
 		f.Close() // best-effort to cleanup dangling file handles
-		if err != nil {
-			m.deleter.Insert(filepath.Base(partialPath)) // mark partial file for eventual deletion
+		if err != nil || !done {
+			// Mark the partial file for eventual deletion, unless the
+			// transfer is resumed (or continued, if chunked) before then.
+			m.deleter.Insert(filepath.Base(partialPath))
+			m.deleter.Insert(filepath.Base(chunksPath))
 		}
 	}()
 	inFile.w = f
@@ -144,31 +181,59 @@ func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, len
 	if offset != 0 {
 		currLength, err := f.Seek(0, io.SeekEnd)
 		if err != nil {
-			return 0, redactAndLogError("Seek", err)
+			return 0, Checksum{}, redactAndLogError("Seek", err)
 		}
 		if offset < 0 || offset > currLength {
-			return 0, redactAndLogError("Seek", err)
+			return 0, Checksum{}, redactAndLogError("Seek", fmt.Errorf("offset %d beyond end of partial file", offset))
 		}
 		if _, err := f.Seek(offset, io.SeekStart); err != nil {
-			return 0, redactAndLogError("Seek", err)
+			return 0, Checksum{}, redactAndLogError("Seek", err)
 		}
 		if err := f.Truncate(offset); err != nil {
-			return 0, redactAndLogError("Truncate", err)
+			return 0, Checksum{}, redactAndLogError("Truncate", err)
 		}
 	}
+	// Forget the checksums of chunks about to be overwritten.
+	if err := trimChunks(chunksPath, offset); err != nil {
+		return 0, Checksum{}, redactAndLogError("Truncate", err)
+	}
 
 	// Copy the contents of the file.
+	h := sha256.New()
+	if chunk != nil {
+		r = io.TeeReader(r, h)
+	}
 	copyLength, err := io.Copy(inFile, r)
 	if err != nil {
-		return 0, redactAndLogError("Copy", err)
+		return 0, Checksum{}, redactAndLogError("Copy", err)
 	}
 	if length >= 0 && copyLength != length {
-		return 0, redactAndLogError("Copy", errors.New("copied an unexpected number of bytes"))
+		return 0, Checksum{}, redactAndLogError("Copy", errors.New("copied an unexpected number of bytes"))
 	}
 	if err := f.Close(); err != nil {
-		return 0, redactAndLogError("Close", err)
+		return 0, Checksum{}, redactAndLogError("Close", err)
 	}
 	fileLength := offset + copyLength
+	var sum Checksum
+	if chunk != nil {
+		sum = Checksum{[sha256.Size]byte(h.Sum(nil))}
+		if !chunk.final {
+			cc := ChunkChecksum{Offset: offset, Size: copyLength, Checksum: sum}
+			if err := appendChunk(chunksPath, cc); err != nil {
+				return 0, Checksum{}, redactAndLogError("Write", err)
+			}
+			return fileLength, sum, nil
+		}
+	}
+	if err := md.verify(partialPath); err != nil {
+		if errors.Is(err, ErrChecksumMismatch) {
+			// Resuming from a corrupt partial file is pointless.
+			os.Remove(partialPath)
+			os.Remove(chunksPath)
+		}
+		return 0, Checksum{}, redactAndLogError("Verify", err)
+	}
+	done = true
 
 	inFile.mu.Lock()
 	inFile.done = true
@@ -198,7 +263,7 @@ func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, len
 			}
 		}()
 		if err != nil {
-			return 0, redactAndLogError("Rename", err)
+			return 0, Checksum{}, redactAndLogError("Rename", err)
 		}
 		if dstLength < 0 {
 			break // we successfully renamed; so stop
@@ -212,15 +277,15 @@ func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, len
 		if dstLength == fileLength {
 			partialSum, err := computePartialSum()
 			if err != nil {
-				return 0, redactAndLogError("Rename", err)
+				return 0, Checksum{}, redactAndLogError("Rename", err)
 			}
 			dstSum, err := sha256File(dstPath)
 			if err != nil {
-				return 0, redactAndLogError("Rename", err)
+				return 0, Checksum{}, redactAndLogError("Rename", err)
 			}
 			if dstSum == partialSum {
 				if err := os.Remove(partialPath); err != nil {
-					return 0, redactAndLogError("Remove", err)
+					return 0, Checksum{}, redactAndLogError("Remove", err)
 				}
 				break // we successfully found a content match; so stop
 			}
@@ -231,11 +296,23 @@ func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, len
 		inFile.finalPath = dstPath
 	}
 	if maxRetries <= 0 {
-		return 0, errors.New("too many retries trying to rename partial file")
+		return 0, Checksum{}, errors.New("too many retries trying to rename partial file")
+	}
+	if err := os.Remove(chunksPath); err != nil && !os.IsNotExist(err) {
+		m.opts.Logf("put Remove error: %v", redactError(err)) // non-fatal error
+	}
+	var mdErr error
+	if m.opts.DirectFileMode {
+		mdErr = md.apply(dstPath)
+	} else {
+		mdErr = writeMetadata(dstPath, md)
+	}
+	if mdErr != nil {
+		m.opts.Logf("put metadata error: %v", redactError(mdErr)) // non-fatal error
 	}
 	m.totalReceived.Add(1)
 	m.opts.SendFileNotify()
-	return fileLength, nil
+	return fileLength, sum, nil
 }
 
 func sha256File(file string) (out [sha256.Size]byte, err error) {
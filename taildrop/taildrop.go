@@ -51,6 +51,16 @@
 	// permitted to be uploaded directly on any platform, like
 	// partial files.
 	deletedSuffix = ".deleted"
+
+	// chunksSuffix is the suffix of the chunk checksums file kept next to
+	// a partial file received in chunks. It ends with partialSuffix so that
+	// it's treated as, and deleted with, a partial file.
+	chunksSuffix = ".chunks" + partialSuffix
+
+	// metaSuffix is the suffix of the file next to a received file waiting
+	// to be picked up that holds the metadata sent with it. Like partial
+	// files, these can't be uploaded directly.
+	metaSuffix = ".taildrop-meta"
 )
 
 // ClientID is an opaque identifier for file resumption.
@@ -65,6 +75,15 @@ func (id ClientID) partialSuffix() string {
 	return "." + string(id) + partialSuffix // e.g., ".n12345CNTRL.partial"
 }
 
+// chunksSuffix is the suffix of the file next to a partial file that holds
+// the checksums of the chunks received by [Manager.PutFileChunk].
+func (id ClientID) chunksSuffix() string {
+	if id == "" {
+		return chunksSuffix
+	}
+	return "." + string(id) + chunksSuffix // e.g., ".n12345CNTRL.chunks.partial"
+}
+
 // ManagerOptions are options to configure the [Manager].
 type ManagerOptions struct {
 	Logf  logger.Logf         // may be nil
@@ -164,7 +183,7 @@ func validFilenameRune(r rune) bool {
 }
 
 func isPartialOrDeleted(s string) bool {
-	return strings.HasSuffix(s, deletedSuffix) || strings.HasSuffix(s, partialSuffix)
+	return strings.HasSuffix(s, deletedSuffix) || strings.HasSuffix(s, partialSuffix) || strings.HasSuffix(s, metaSuffix)
 }
 
 func joinDir(dir, baseName string) (fullPath string, err error) {
@@ -0,0 +1,343 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tka
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2s"
+	"tailscale.com/types/key"
+	"tailscale.com/types/tkatype"
+)
+
+// LogExport is a machine-readable export of the chain of updates (AUMs) of a
+// tailnet key authority, with human-readable summaries, for audits.
+//
+// Its chain can be verified offline with [VerifyLogExport], as each AUM is
+// signed by keys trusted at the AUM before it. The export as a whole is signed
+// by the tailnet lock key of the node that made it.
+type LogExport struct {
+	// Head is the hash of the last AUM of the chain.
+	Head AUMHash
+
+	// Exported is when the export was made, per the exporting node.
+	Exported time.Time
+
+	// AUMs is the chain, oldest first, starting with a checkpoint: the
+	// genesis AUM, or the oldest one kept after compaction.
+	AUMs []tkatype.MarshaledAUM
+
+	// Updates summarizes AUMs, in the same order.
+	Updates []UpdateSummary
+
+	// Nodes summarizes the signatures of the nodes known to the exporting
+	// node.
+	Nodes []NodeSummary `json:",omitempty"`
+
+	// Signature is the exporting node's signature over the other fields.
+	// Its KeyID is the node's tailnet lock public key.
+	Signature tkatype.Signature
+}
+
+// UpdateSummary is a human-readable summary of an AUM in a LogExport.
+type UpdateSummary struct {
+	Hash AUMHash
+	Kind string // as in AUMKind.String
+
+	// Description describes the change, as in "add key tlpub:…".
+	Description string
+
+	// SignedBy are the keys that signed the AUM.
+	SignedBy []KeySummary
+}
+
+// KeySummary identifies a tailnet lock key in a LogExport.
+type KeySummary struct {
+	Key  string            // as in key.NLPublic.CLIString
+	Meta map[string]string `json:",omitempty"` // as at the time, if trusted
+}
+
+// NodeSummary is a human-readable summary of a node's signature in a
+// LogExport.
+type NodeSummary struct {
+	Name      string
+	NodeKey   key.NodePublic
+	Signature tkatype.MarshaledSignature
+
+	// Created is when the control plane created the node, if known.
+	// Node-key signatures don't record when they were made.
+	Created time.Time `json:",omitzero"`
+
+	// Kind and SignedBy describe Signature: its kind, as in
+	// SigKind.String, and the trusted key authorizing it. They're filled
+	// in by [Authority.ExportLog].
+	Kind     string     `json:",omitempty"`
+	SignedBy KeySummary `json:",omitzero"`
+}
+
+// ExportLog returns an export of the chain of AUMs from the oldest one kept
+// in storage to the head, with summaries of the given nodes' signatures,
+// signed by signer. The nodes' signatures must be valid at the head.
+func (a *Authority) ExportLog(storage Chonk, nodes []NodeSummary, signer key.NLPrivate) (*LogExport, error) {
+	var chain []AUM
+	oldest := a.oldestAncestor.Hash()
+	cursor := a.Head()
+	for {
+		aum, err := storage.AUM(cursor)
+		if err != nil {
+			if err == os.ErrNotExist {
+				return nil, fmt.Errorf("AUM %v missing from storage", cursor)
+			}
+			return nil, fmt.Errorf("reading AUM: %w", err)
+		}
+		chain = append(chain, aum)
+		if cursor == oldest && aum.MessageKind == AUMCheckpoint {
+			break
+		}
+		parent, hasParent := aum.Parent()
+		if !hasParent {
+			break
+		}
+		cursor = parent
+	}
+	slices.Reverse(chain)
+
+	updates, state, err := summarizeChain(chain)
+	if err != nil {
+		return nil, err
+	}
+	e := &LogExport{
+		Head:     a.Head(),
+		Exported: time.Now().UTC().Truncate(time.Second),
+		Updates:  updates,
+	}
+	for _, aum := range chain {
+		e.AUMs = append(e.AUMs, aum.Serialize())
+	}
+	for _, n := range nodes {
+		if err := a.NodeKeyAuthorized(n.NodeKey, n.Signature); err != nil {
+			return nil, fmt.Errorf("node %q: %w", n.Name, err)
+		}
+		n.Kind, n.SignedBy, err = summarizeNodeSig(state, n.Signature)
+		if err != nil {
+			return nil, fmt.Errorf("node %q: %w", n.Name, err)
+		}
+		e.Nodes = append(e.Nodes, n)
+	}
+
+	sigHash, err := e.sigHash()
+	if err != nil {
+		return nil, err
+	}
+	sigs, err := signer.SignAUM(sigHash)
+	if err != nil {
+		return nil, err
+	}
+	e.Signature = sigs[0]
+	return e, nil
+}
+
+// VerifyLogExport verifies e offline: that its AUMs are a correctly signed
+// chain ending at its Head, that its summaries match, that its nodes'
+// signatures are valid at Head, and that it's signed by the key of its
+// Signature.
+//
+// It returns whether that key is trusted at Head. If not, the chain is still
+// authentic, but anyone could have made the export.
+func VerifyLogExport(e *LogExport) (signerTrusted bool, err error) {
+	if len(e.AUMs) == 0 {
+		return false, errors.New("export has no AUMs")
+	}
+	chain := make([]AUM, len(e.AUMs))
+	for i, b := range e.AUMs {
+		if err := chain[i].Unserialize(b); err != nil {
+			return false, fmt.Errorf("AUM %d: %w", i, err)
+		}
+	}
+	updates, state, err := summarizeChain(chain)
+	if err != nil {
+		return false, err
+	}
+	if got := *state.LastAUMHash; got != e.Head {
+		return false, fmt.Errorf("chain ends at %v, not head %v", got, e.Head)
+	}
+	if err := jsonEqual(updates, e.Updates); err != nil {
+		return false, fmt.Errorf("update summaries: %w", err)
+	}
+	for _, n := range e.Nodes {
+		a := Authority{state: state}
+		if err := a.NodeKeyAuthorized(n.NodeKey, n.Signature); err != nil {
+			return false, fmt.Errorf("node %q: %w", n.Name, err)
+		}
+		kind, signedBy, err := summarizeNodeSig(state, n.Signature)
+		if err != nil {
+			return false, fmt.Errorf("node %q: %w", n.Name, err)
+		}
+		if kind != n.Kind {
+			return false, fmt.Errorf("node %q: summary has kind %q, want %q", n.Name, n.Kind, kind)
+		}
+		if err := jsonEqual(signedBy, n.SignedBy); err != nil {
+			return false, fmt.Errorf("node %q: %w", n.Name, err)
+		}
+	}
+
+	if len(e.Signature.KeyID) != ed25519.PublicKeySize {
+		return false, errors.New("export signature has an invalid key")
+	}
+	sigHash, err := e.sigHash()
+	if err != nil {
+		return false, err
+	}
+	signer := Key{Kind: Key25519, Public: e.Signature.KeyID, Votes: 1}
+	if err := signatureVerify(&e.Signature, sigHash, signer); err != nil {
+		return false, fmt.Errorf("export signature: %w", err)
+	}
+	_, err = state.GetKey(e.Signature.KeyID)
+	return err == nil, nil
+}
+
+// sigHash returns the digest of e that its Signature is over.
+func (e *LogExport) sigHash() (tkatype.AUMSigHash, error) {
+	unsigned := *e
+	unsigned.Signature = tkatype.Signature{}
+	b, err := json.Marshal(unsigned)
+	if err != nil {
+		return tkatype.AUMSigHash{}, err
+	}
+	return blake2s.Sum256(b), nil
+}
+
+// summarizeChain verifies that chain, which must start with a checkpoint, is
+// a sequence of AUMs each correctly signed and applying to the one before.
+// It returns their summaries and the state after the last.
+func summarizeChain(chain []AUM) ([]UpdateSummary, State, error) {
+	if len(chain) == 0 || chain[0].MessageKind != AUMCheckpoint || chain[0].State == nil {
+		return nil, State{}, errors.New("chain must start with a checkpoint")
+	}
+	var (
+		out   []UpdateSummary
+		state = *chain[0].State
+	)
+	for i, aum := range chain {
+		if err := aumVerify(aum, state, i == 0); err != nil {
+			return nil, State{}, fmt.Errorf("AUM %d (%v): %w", i, aum.Hash(), err)
+		}
+		s := UpdateSummary{
+			Hash:        aum.Hash(),
+			Kind:        aum.MessageKind.String(),
+			Description: describeAUM(aum),
+		}
+		for _, sig := range aum.Signatures {
+			s.SignedBy = append(s.SignedBy, summarizeKey(state, sig.KeyID))
+		}
+		out = append(out, s)
+
+		var err error
+		if i == 0 {
+			state = aum.State.cloneForUpdate(&aum)
+		} else if state, err = state.applyVerifiedAUM(aum); err != nil {
+			return nil, State{}, fmt.Errorf("AUM %d (%v): %w", i, aum.Hash(), err)
+		}
+	}
+	return out, state, nil
+}
+
+// describeAUM returns a human-readable description of the change made by aum.
+func describeAUM(aum AUM) string {
+	var sb strings.Builder
+	switch aum.MessageKind {
+	case AUMNoOp:
+		sb.WriteString("no change")
+	case AUMAddKey:
+		if aum.Key == nil {
+			return "add missing key"
+		}
+		fmt.Fprintf(&sb, "add key %s with %d votes", keyString(aum.Key.Public), aum.Key.Votes)
+		if len(aum.Key.Meta) > 0 {
+			fmt.Fprintf(&sb, ", metadata %v", aum.Key.Meta)
+		}
+	case AUMRemoveKey:
+		fmt.Fprintf(&sb, "remove key %s", keyString(aum.KeyID))
+	case AUMUpdateKey:
+		fmt.Fprintf(&sb, "update key %s", keyString(aum.KeyID))
+		if aum.Votes != nil {
+			fmt.Fprintf(&sb, ", votes %d", *aum.Votes)
+		}
+		if aum.Meta != nil {
+			fmt.Fprintf(&sb, ", metadata %v", aum.Meta)
+		}
+	case AUMCheckpoint:
+		if aum.State == nil {
+			return "checkpoint missing state"
+		}
+		fmt.Fprintf(&sb, "checkpoint with %d keys", len(aum.State.Keys))
+		for _, k := range aum.State.Keys {
+			fmt.Fprintf(&sb, "\n  %s with %d votes", keyString(k.Public), k.Votes)
+			if len(k.Meta) > 0 {
+				fmt.Fprintf(&sb, ", metadata %v", k.Meta)
+			}
+		}
+		fmt.Fprintf(&sb, "\n  %d disablement values", len(aum.State.DisablementSecrets))
+	default:
+		fmt.Fprintf(&sb, "unknown change %v", aum.MessageKind)
+	}
+	return sb.String()
+}
+
+// summarizeNodeSig returns the kind of the node-key signature sig and the
+// key in state authorizing it.
+func summarizeNodeSig(state State, sig tkatype.MarshaledSignature) (kind string, signedBy KeySummary, err error) {
+	var nks NodeKeySignature
+	if err := nks.Unserialize(sig); err != nil {
+		return "", KeySummary{}, fmt.Errorf("unserialize: %w", err)
+	}
+	keyID, err := nks.authorizingKeyID()
+	if err != nil {
+		return "", KeySummary{}, err
+	}
+	return nks.SigKind.String(), summarizeKey(state, keyID), nil
+}
+
+// summarizeKey returns the summary of the key with keyID, with its metadata
+// if it's trusted in state.
+func summarizeKey(state State, keyID tkatype.KeyID) KeySummary {
+	s := KeySummary{Key: keyString(keyID)}
+	if k, err := state.GetKey(keyID); err == nil {
+		s.Meta = k.Meta
+	}
+	return s
+}
+
+// keyString returns the CLI form of the 25519 key with public part pub.
+func keyString(pub []byte) string {
+	if len(pub) != ed25519.PublicKeySize {
+		return fmt.Sprintf("<invalid key %x>", pub)
+	}
+	return key.NLPublicFromEd25519Unsafe(pub).CLIString()
+}
+
+// jsonEqual returns an error if the summary in an export and the one
+// computed from its chain have different JSON encodings.
+func jsonEqual(computed, exported any) error {
+	cb, err := json.Marshal(computed)
+	if err != nil {
+		return err
+	}
+	eb, err := json.Marshal(exported)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(cb, eb) {
+		return fmt.Errorf("export has %s, chain has %s", eb, cb)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tka
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/types/key"
+	"tailscale.com/types/tkatype"
+)
+
+func TestLogExport(t *testing.T) {
+	nlPriv := key.NewNLPrivate()
+	k := Key{Kind: Key25519, Public: nlPriv.Public().Verifier(), Votes: 2, Meta: map[string]string{"name": "alice"}}
+	storage := &Mem{}
+	a, _, err := Create(storage, State{
+		Keys:               []Key{k},
+		DisablementSecrets: [][]byte{DisablementKDF([]byte{1, 2, 3})},
+	}, nlPriv)
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	pub2, _ := testingKey25519(t, 2)
+	b := a.NewUpdater(nlPriv)
+	if err := b.AddKey(Key{Kind: Key25519, Public: pub2, Votes: 1}); err != nil {
+		t.Fatal(err)
+	}
+	updates, err := b.Finalize(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Inform(storage, updates); err != nil {
+		t.Fatal(err)
+	}
+
+	node := key.NewNode()
+	nodeKeyPub, _ := node.Public().MarshalBinary()
+	nks := NodeKeySignature{SigKind: SigDirect, KeyID: k.MustID(), Pubkey: nodeKeyPub}
+	sigHash := nks.SigHash()
+	nks.Signature, _ = nlPriv.SignNKS(sigHash)
+
+	e, err := a.ExportLog(storage, []NodeSummary{{Name: "foo", NodeKey: node.Public(), Signature: nks.Serialize()}}, nlPriv)
+	if err != nil {
+		t.Fatalf("ExportLog() failed: %v", err)
+	}
+	if len(e.Updates) != 2 || e.Updates[0].Kind != "checkpoint" || e.Updates[1].Kind != "add-key" {
+		t.Errorf("Updates = %+v, want checkpoint and add-key", e.Updates)
+	}
+	if got := e.Updates[1].SignedBy; len(got) != 1 || got[0].Meta["name"] != "alice" {
+		t.Errorf("add-key SignedBy = %+v, want alice", got)
+	}
+	if got := e.Nodes[0]; got.Kind != "direct" || got.SignedBy.Meta["name"] != "alice" {
+		t.Errorf("Nodes[0] = %+v, want signed directly by alice", got)
+	}
+
+	// roundTrip returns a copy of e as read from its JSON, modified by f.
+	roundTrip := func(f func(*LogExport)) *LogExport {
+		j, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out LogExport
+		if err := json.Unmarshal(j, &out); err != nil {
+			t.Fatal(err)
+		}
+		f(&out)
+		return &out
+	}
+	trusted, err := VerifyLogExport(roundTrip(func(*LogExport) {}))
+	if err != nil || !trusted {
+		t.Fatalf("VerifyLogExport() = %v, %v; want true, nil", trusted, err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		f       func(*LogExport)
+		wantErr string
+	}{
+		{"description", func(e *LogExport) { e.Updates[1].Description = "nothing to see here" }, "update summaries"},
+		{"truncated", func(e *LogExport) { e.AUMs = e.AUMs[:1] }, "not head"},
+		{"reordered", func(e *LogExport) { e.AUMs[0], e.AUMs[1] = e.AUMs[1], e.AUMs[0] }, "checkpoint"},
+		{"node", func(e *LogExport) { e.Nodes[0].NodeKey = key.NewNode().Public() }, `node "foo"`},
+		{"time", func(e *LogExport) { e.Exported = e.Exported.Add(-time.Hour) }, "export signature"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := VerifyLogExport(roundTrip(tt.f))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("VerifyLogExport() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// An export made with an untrusted key verifies, but isn't trusted.
+	other := key.NewNLPrivate()
+	e2, err := a.ExportLog(storage, nil, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusted, err = VerifyLogExport(e2)
+	if err != nil || trusted {
+		t.Errorf("VerifyLogExport(untrusted) = %v, %v; want false, nil", trusted, err)
+	}
+	e2.Signature = tkatype.Signature{KeyID: e2.Signature.KeyID, Signature: make([]byte, ed25519.SignatureSize)}
+	if _, err := VerifyLogExport(e2); err == nil {
+		t.Error("VerifyLogExport with a bad signature succeeded")
+	}
+}
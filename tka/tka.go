@@ -848,3 +848,80 @@ func (a *Authority) MakeRetroactiveRevocation(storage Chonk, removeKeys []tkatyp
 
 	return forkingAUM, forkingAUM.StaticValidate()
 }
+
+// MakeRotation returns a checkpoint AUM that follows the current head and
+// atomically trusts addKeys, stops trusting removeKeys and, if
+// disablementValues is non-nil, replaces the disablement values, as when
+// rotating a compromised signing key or lost disablement secrets.
+//
+// The returned AUM is unsigned. As it can change anything about the state,
+// callers should have it signed by a quorum of trusted keys (see
+// [Authority.SignedVotes]) before distributing it.
+func (a *Authority) MakeRotation(addKeys []Key, removeKeys []tkatype.KeyID, disablementValues [][]byte) (*AUM, error) {
+	state := a.state.Clone()
+	for _, keyToRemove := range removeKeys {
+		idx := -1
+		for i := range state.Keys {
+			keyID, err := state.Keys[i].ID()
+			if err != nil {
+				return nil, fmt.Errorf("computing keyID: %v", err)
+			}
+			if bytes.Equal(keyToRemove, keyID) {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			return nil, fmt.Errorf("key %x is not trusted", keyToRemove)
+		}
+		state.Keys = append(state.Keys[:idx], state.Keys[idx+1:]...)
+	}
+	for _, k := range addKeys {
+		keyID, err := k.ID()
+		if err != nil {
+			return nil, fmt.Errorf("computing keyID: %v", err)
+		}
+		if _, err := state.GetKey(keyID); err == nil {
+			return nil, fmt.Errorf("key %x is already trusted", keyID)
+		}
+		state.Keys = append(state.Keys, k)
+	}
+	if len(state.Keys) == 0 {
+		return nil, errors.New("cannot remove all trusted keys")
+	}
+	if disablementValues != nil {
+		state.DisablementSecrets = disablementValues
+	}
+	state.LastAUMHash = nil // checkpoints can't specify a LastAUMHash
+
+	head := a.Head()
+	aum := &AUM{
+		MessageKind: AUMCheckpoint,
+		State:       &state,
+		PrevAUMHash: head[:],
+	}
+	return aum, aum.StaticValidate()
+}
+
+// SignedVotes verifies that aum follows the current head and that its
+// signatures are by trusted keys, and returns the total votes of the keys
+// that signed it, each counted once.
+func (a *Authority) SignedVotes(aum *AUM) (uint, error) {
+	if err := aumVerify(*aum, a.state, false); err != nil {
+		return 0, err
+	}
+	var votes uint
+	seen := make(set.Set[string])
+	for _, sig := range aum.Signatures {
+		if seen.Contains(string(sig.KeyID)) {
+			continue
+		}
+		seen.Add(string(sig.KeyID))
+		k, err := a.state.GetKey(sig.KeyID)
+		if err != nil {
+			return 0, err
+		}
+		votes += k.Votes
+	}
+	return votes, nil
+}
@@ -5,6 +5,7 @@
 
 import (
 	"bytes"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -652,3 +653,86 @@ func TestMakeRetroactiveRevocation(t *testing.T) {
 		t.Fatalf("MakeRetroactiveRevocation({k1, k2, k3}) returned %v, expected %q", err, wantErr)
 	}
 }
+
+func TestMakeRotation(t *testing.T) {
+	pub, priv := testingKey25519(t, 1)
+	k1 := Key{Kind: Key25519, Public: pub, Votes: 1}
+	pub2, priv2 := testingKey25519(t, 2)
+	k2 := Key{Kind: Key25519, Public: pub2, Votes: 1}
+	pub3, _ := testingKey25519(t, 3)
+	k3 := Key{Kind: Key25519, Public: pub3, Votes: 1}
+
+	storage := &Mem{}
+	a, _, err := Create(storage, State{
+		Keys:               []Key{k1, k2},
+		DisablementSecrets: [][]byte{DisablementKDF([]byte{1, 2, 3})},
+	}, signer25519(priv))
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+
+	// Rotate k2 out for k3, replacing the disablement values.
+	k2ID, _ := k2.ID()
+	k3ID, _ := k3.ID()
+	newSecret := []byte{4, 5, 6}
+	aum, err := a.MakeRotation([]Key{k3}, []tkatype.KeyID{k2ID}, [][]byte{DisablementKDF(newSecret)})
+	if err != nil {
+		t.Fatalf("MakeRotation() failed: %v", err)
+	}
+	if _, err := a.SignedVotes(aum); err == nil {
+		t.Error("SignedVotes() of an unsigned AUM succeeded")
+	}
+
+	sigHash := aum.SigHash()
+	for i, signer := range []signer25519{signer25519(priv), signer25519(priv2), signer25519(priv)} {
+		sigs, err := signer.SignAUM(sigHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		aum.Signatures = append(aum.Signatures, sigs...)
+
+		// The third signature repeats the first, so doesn't count.
+		want := uint(min(i+1, 2))
+		votes, err := a.SignedVotes(aum)
+		if err != nil {
+			t.Fatalf("SignedVotes() with %d signatures failed: %v", i+1, err)
+		}
+		if votes != want {
+			t.Errorf("SignedVotes() with %d signatures = %d, want %d", i+1, votes, want)
+		}
+	}
+
+	if err := a.Inform(storage, []AUM{*aum}); err != nil {
+		t.Fatalf("could not apply rotation: %v", err)
+	}
+	if _, err := a.state.GetKey(k3ID); err != nil {
+		t.Error("rotated state did not trust k3")
+	}
+	if _, err := a.state.GetKey(k2ID); err == nil {
+		t.Error("rotated state trusted removed-key k2")
+	}
+	if !a.ValidDisablement(newSecret) {
+		t.Error("new disablement secret not valid after rotation")
+	}
+	if a.ValidDisablement([]byte{1, 2, 3}) {
+		t.Error("old disablement secret still valid after rotation")
+	}
+
+	// Test the error cases.
+	k1ID, _ := k1.ID()
+	for _, tc := range []struct {
+		name    string
+		add     []Key
+		remove  []tkatype.KeyID
+		wantErr string
+	}{
+		{"untrusted", nil, []tkatype.KeyID{k2ID}, "is not trusted"},
+		{"duplicate", []Key{k1}, nil, "is already trusted"},
+		{"all", nil, []tkatype.KeyID{k1ID, k3ID}, "cannot remove all trusted keys"},
+	} {
+		_, err := a.MakeRotation(tc.add, tc.remove, nil)
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%s: MakeRotation() returned %v, want %q", tc.name, err, tc.wantErr)
+		}
+	}
+}
@@ -4,12 +4,14 @@
 package tsnet_test
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"tailscale.com/tsnet"
 )
@@ -89,6 +91,46 @@ func ExampleServer_multipleInstances() {
 	}()
 }
 
+// ExampleServer_concurrentInstances shows you how to start several tsnet
+// instances at once, such as in a test harness. Each Server has its own node
+// key, network stack, and logs, so they can be started and used concurrently
+// as long as each has its own Dir.
+func ExampleServer_concurrentInstances() {
+	baseDir, err := os.MkdirTemp("", "tsnet-example")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	const n = 5
+	servers := make([]*tsnet.Server, n)
+	var wg sync.WaitGroup
+	for i := range servers {
+		hostname := fmt.Sprintf("node%d", i)
+		servers[i] = &tsnet.Server{
+			Hostname:  hostname,
+			AuthKey:   os.Getenv("TS_AUTHKEY"),
+			Ephemeral: true,
+			Dir:       filepath.Join(baseDir, hostname),
+		}
+		wg.Add(1)
+		go func(srv *tsnet.Server) {
+			defer wg.Done()
+			if _, err := srv.Up(context.Background()); err != nil {
+				log.Printf("can't start %s: %v", srv.Hostname, err)
+			}
+		}(servers[i])
+	}
+	wg.Wait()
+
+	// When you're done, close the instances
+	defer func() {
+		for _, srv := range servers {
+			srv.Close()
+		}
+	}()
+}
+
 // ExampleServer_ignoreLogsSometimes shows you how to ignore all of the log messages
 // written by a tsnet instance, but allows you to opt-into them if a command-line
 // flag is set.
@@ -0,0 +1,158 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+)
+
+// ServeConfig edits the node's Serve and Funnel configuration: the
+// handlers that tailscaled runs for it on its Tailscale address, as
+// configured by the "tailscale serve" and "tailscale funnel" commands.
+// Unlike the listeners returned by ListenTLS and ListenFunnel, these
+// handlers proxy to or serve from things outside the program.
+//
+// A ServeConfig is obtained with Server.EditServeConfig. Its methods
+// record changes and return the ServeConfig, so that they can be
+// chained; none take effect until Apply is called, which also reports
+// the first error from any of them.
+//
+// A ServeConfig is not safe for concurrent use.
+type ServeConfig struct {
+	s      *Server
+	domain string               // the node's DNS name, for HTTPS and Funnel
+	self   *ipnstate.PeerStatus // the node, for checking Funnel access
+	sc     *ipn.ServeConfig
+	err    error // first error from a method, returned by Apply
+}
+
+// EditServeConfig returns a ServeConfig for changing the node's current
+// Serve and Funnel configuration.
+//
+// HTTPS must be enabled for the tailnet.
+//
+// It will start the server if it has not been started yet.
+func (s *Server) EditServeConfig(ctx context.Context) (*ServeConfig, error) {
+	st, err := s.Up(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(st.CertDomains) == 0 {
+		return nil, errors.New("tsnet: you must enable HTTPS in the admin panel to proceed. See https://tailscale.com/s/https")
+	}
+	sc, err := s.localClient.GetServeConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if sc == nil {
+		sc = new(ipn.ServeConfig)
+	}
+	return &ServeConfig{s: s, domain: st.CertDomains[0], self: st.Self, sc: sc}, nil
+}
+
+// Proxy serves HTTPS on port, reverse proxying requests under the path
+// mount to target. The target is a local port number (such as "3000") or
+// an http, https or https+insecure URL of localhost or 127.0.0.1.
+func (c *ServeConfig) Proxy(port uint16, mount, target string) *ServeConfig {
+	t, err := ipn.ExpandProxyTargetValue(target, []string{"http", "https", "https+insecure"}, "http")
+	if err != nil {
+		return c.fail(fmt.Errorf("Proxy(%d, %q, %q): %w", port, mount, target, err))
+	}
+	return c.web(port, mount, &ipn.HTTPHandler{Proxy: t})
+}
+
+// Path serves HTTPS on port, serving requests under the path mount from
+// the file or directory at the absolute path path.
+func (c *ServeConfig) Path(port uint16, mount, path string) *ServeConfig {
+	if !strings.HasPrefix(path, "/") {
+		return c.fail(fmt.Errorf("Path(%d, %q, %q): path must be absolute", port, mount, path))
+	}
+	return c.web(port, mount, &ipn.HTTPHandler{Path: path})
+}
+
+// Text serves HTTPS on port, responding to requests under the path mount
+// with text.
+func (c *ServeConfig) Text(port uint16, mount, text string) *ServeConfig {
+	return c.web(port, mount, &ipn.HTTPHandler{Text: text})
+}
+
+// web adds h at mount for HTTPS on port, after the checks common to Proxy,
+// Path and Text.
+func (c *ServeConfig) web(port uint16, mount string, h *ipn.HTTPHandler) *ServeConfig {
+	if !strings.HasPrefix(mount, "/") {
+		return c.fail(fmt.Errorf("mount %q must start with /", mount))
+	}
+	if c.sc.IsTCPForwardingOnPort(port) {
+		return c.fail(fmt.Errorf("port %d is already forwarding TCP", port))
+	}
+	c.sc.SetWebHandler(h, c.domain, port, mount, true)
+	return c
+}
+
+// TCP forwards TCP connections on port to target, a local port number
+// (such as "5432") or a tcp URL of localhost or 127.0.0.1. If
+// terminateTLS, TLS is terminated with the node's certificate first.
+func (c *ServeConfig) TCP(port uint16, target string, terminateTLS bool) *ServeConfig {
+	t, err := ipn.ExpandProxyTargetValue(target, []string{"tcp"}, "tcp")
+	if err != nil {
+		return c.fail(fmt.Errorf("TCP(%d, %q): %w", port, target, err))
+	}
+	if c.sc.IsServingWeb(port) {
+		return c.fail(fmt.Errorf("TCP(%d, %q): port is already serving HTTPS", port, target))
+	}
+	c.sc.SetTCPForwarding(port, strings.TrimPrefix(t, "tcp://"), terminateTLS, c.domain)
+	return c
+}
+
+// Funnel sets whether what's served on port is also reachable from the
+// internet with Funnel. The node must be allowed to use Funnel on port;
+// see ListenFunnel.
+func (c *ServeConfig) Funnel(port uint16, on bool) *ServeConfig {
+	if on {
+		if err := ipn.CheckFunnelAccess(port, c.self); err != nil {
+			return c.fail(err)
+		}
+	}
+	c.sc.SetFunnel(c.domain, port, on)
+	return c
+}
+
+// Remove stops serving on port, including with Funnel.
+func (c *ServeConfig) Remove(port uint16) *ServeConfig {
+	hp := ipn.HostPort(net.JoinHostPort(c.domain, strconv.Itoa(int(port))))
+	if web := c.sc.Web[hp]; web != nil {
+		mounts := make([]string, 0, len(web.Handlers))
+		for m := range web.Handlers {
+			mounts = append(mounts, m)
+		}
+		c.sc.RemoveWebHandler(c.domain, port, mounts, true)
+	}
+	c.sc.RemoveTCPForwarding(port)
+	c.sc.SetFunnel(c.domain, port, false)
+	return c
+}
+
+// Apply puts the changes made to c into effect. If any of c's methods
+// failed, it returns the first error and changes nothing.
+func (c *ServeConfig) Apply(ctx context.Context) error {
+	if c.err != nil {
+		return c.err
+	}
+	return c.s.localClient.SetServeConfig(ctx, c.sc)
+}
+
+func (c *ServeConfig) fail(err error) *ServeConfig {
+	if c.err == nil {
+		c.err = fmt.Errorf("tsnet: %w", err)
+	}
+	return c
+}
@@ -73,7 +73,9 @@ type Server struct {
 	// binary, you will need to make sure that Dir is set uniquely
 	// for each service. A good pattern for this is to have a
 	// "base" directory (such as your mutable storage folder) and
-	// then append the hostname on the end of it.
+	// then append the hostname on the end of it. Starting a Server
+	// whose directory is in use by another running Server in the
+	// same process fails.
 	Dir string
 
 	// Store specifies the state store to use.
@@ -124,6 +126,16 @@ type Server struct {
 	// field at zero unless you know what you are doing.
 	Port uint16
 
+	// Netstack, if non-nil, tunes the userspace network stack that
+	// carries the Server's connections, such as with a "throughput" or
+	// "low-memory" profile.
+	Netstack *ipn.NetstackConfig
+
+	// CertStore, if non-nil, is where the TLS certificates for ListenTLS
+	// and ListenFunnel are stored, such as in a database or secrets
+	// manager, instead of in the state directory.
+	CertStore ipn.CertStore
+
 	getCertForTesting func(*tls.ClientHelloInfo) (*tls.Certificate, error)
 
 	initOnce         sync.Once
@@ -133,6 +145,7 @@ type Server struct {
 	netstack         *netstack.Impl
 	netMon           *netmon.Monitor
 	rootPath         string // the state directory
+	claimedDir       string // rootPath's absolute path, if claimed by claimDir
 	hostname         string
 	shutdownCtx      context.Context
 	shutdownCancel   context.CancelFunc
@@ -178,6 +191,38 @@ func (s *Server) Dial(ctx context.Context, network, address string) (net.Conn, e
 	return s.dialer.UserDial(ctx, network, address)
 }
 
+// DialUDP connects to raddr on the tailnet from laddr, one of the node's
+// Tailscale addresses, with a port of zero to pick one. If laddr is the
+// zero value, both are picked.
+//
+// Unlike Dial, it always sends through the node's netstack, so a raddr
+// that isn't a peer or in a peer's subnet routes is only reachable through
+// an exit node. The returned net.Conn also implements net.PacketConn.
+//
+// It will start the server if it has not been started yet.
+func (s *Server) DialUDP(ctx context.Context, laddr, raddr netip.AddrPort) (net.Conn, error) {
+	if err := s.Start(); err != nil {
+		return nil, err
+	}
+	if err := s.awaitRunning(ctx); err != nil {
+		return nil, err
+	}
+	if !laddr.IsValid() {
+		// Don't just return the result of ns.DialContextUDP, or we'd
+		// return a *gonet.UDPConn(nil) rather than a nil interface.
+		c, err := s.netstack.DialContextUDP(ctx, raddr)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	c, err := s.netstack.DialContextUDPWithBind(ctx, laddr, raddr)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
 // awaitRunning waits until the backend is in state Running.
 // If the backend is in state Starting, it blocks until it reaches
 // a terminal state (such as Stopped, NeedsMachineAuth)
@@ -436,6 +481,10 @@ func (s *Server) Close() error {
 	}
 
 	wg.Wait()
+	if s.claimedDir != "" {
+		releaseDir(s.claimedDir)
+		s.claimedDir = ""
+	}
 	s.closed = true
 	return nil
 }
@@ -541,6 +590,18 @@ func (s *Server) start() (reterr error) {
 	} else if !fi.IsDir() {
 		return fmt.Errorf("%v is not a directory", s.rootPath)
 	}
+	dir, err := filepath.Abs(s.rootPath)
+	if err != nil {
+		return err
+	}
+	if err := claimDir(dir); err != nil {
+		return err
+	}
+	s.claimedDir = dir
+	closePool.addFunc(func() {
+		releaseDir(dir)
+		s.claimedDir = ""
+	})
 
 	tsLogf := func(format string, a ...any) {
 		if s.logtail != nil {
@@ -586,6 +647,11 @@ func (s *Server) start() (reterr error) {
 	if err != nil {
 		return fmt.Errorf("netstack.Create: %w", err)
 	}
+	if s.Netstack != nil {
+		if err := ns.Tune(s.Netstack); err != nil {
+			return err
+		}
+	}
 	sys.Tun.Get().Start()
 	sys.Set(ns)
 	ns.ProcessLocalIPs = true
@@ -638,6 +704,9 @@ func (s *Server) start() (reterr error) {
 	}
 	lb.SetTCPHandlerForFunnelFlow(s.getTCPHandlerForFunnelFlow)
 	lb.SetVarRoot(s.rootPath)
+	if s.CertStore != nil {
+		lb.SetCertStore(s.CertStore)
+	}
 	s.logf("tsnet starting with hostname %q, varRoot %q", s.hostname, s.rootPath)
 	s.lb = lb
 	if err := ns.Start(lb); err != nil {
@@ -892,6 +961,32 @@ func (s *Server) getUDPHandlerForFlow(src, dst netip.AddrPort) (handler func(net
 //
 // TODO(bradfitz): remove this maybe 6 months after 2022-03-17,
 // once people (notably Tailscale corp services) have updated.
+var (
+	dirsInUseMu sync.Mutex
+	dirsInUse   = set.Set[string]{} // absolute state directories of running Servers
+)
+
+// claimDir records that dir, an absolute path, is the state directory of a
+// Server being started. It returns an error if another Server in this
+// process already uses it, as the two would otherwise share a node key and
+// log files.
+func claimDir(dir string) error {
+	dirsInUseMu.Lock()
+	defer dirsInUseMu.Unlock()
+	if dirsInUse.Contains(dir) {
+		return fmt.Errorf("tsnet: state directory %q is in use by another Server in this process; set a distinct Dir for each Server", dir)
+	}
+	dirsInUse.Add(dir)
+	return nil
+}
+
+// releaseDir undoes a successful claimDir of dir.
+func releaseDir(dir string) {
+	dirsInUseMu.Lock()
+	defer dirsInUseMu.Unlock()
+	dirsInUse.Delete(dir)
+}
+
 func getTSNetDir(logf logger.Logf, confDir, prog string) (string, error) {
 	oldPath := filepath.Join(confDir, "tslib-"+prog)
 	newPath := filepath.Join(confDir, "tsnet-"+prog)
@@ -960,7 +1055,10 @@ func (s *Server) Listen(network, addr string) (net.Listener, error) {
 //
 // The network must be "udp", "udp4" or "udp6". The addr must be of the form
 // "ip:port" (or "[ip]:port") where ip is a valid IPv4 or IPv6 address
-// corresponding to "udp4" or "udp6" respectively. IP must be specified.
+// corresponding to "udp4" or "udp6" respectively. For "udp4" and "udp6",
+// ip may be omitted (":port") to receive packets sent to any address of
+// that family that the node handles, such as its own Tailscale address and
+// addresses in subnet routes it advertises. The port may be zero to pick one.
 //
 // If s has not been started yet, it will be started.
 func (s *Server) ListenPacket(network, addr string) (net.PacketConn, error) {
@@ -968,15 +1066,24 @@ func (s *Server) ListenPacket(network, addr string) (net.PacketConn, error) {
 	if err != nil {
 		return nil, err
 	}
-	if !ap.Addr().IsValid() {
-		return nil, fmt.Errorf("tsnet.ListenPacket(%q, %q): address must be a valid IP", network, addr)
-	}
-	if network == "udp" {
+	switch network {
+	case "udp":
+		if !ap.Addr().IsValid() {
+			return nil, fmt.Errorf("tsnet.ListenPacket(%q, %q): address must be a valid IP; or use udp4 or udp6 to listen on all addresses", network, addr)
+		}
 		if ap.Addr().Is4() {
 			network = "udp4"
 		} else {
 			network = "udp6"
 		}
+	case "udp4":
+		if !ap.Addr().IsValid() {
+			ap = netip.AddrPortFrom(netip.IPv4Unspecified(), ap.Port())
+		}
+	case "udp6":
+		if !ap.Addr().IsValid() {
+			ap = netip.AddrPortFrom(netip.IPv6Unspecified(), ap.Port())
+		}
 	}
 	if err := s.Start(); err != nil {
 		return nil, err
@@ -39,6 +39,7 @@
 	"tailscale.com/client/local"
 	"tailscale.com/cmd/testwrapper/flakytest"
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/ipn/store/mem"
 	"tailscale.com/net/netns"
 	"tailscale.com/tailcfg"
@@ -911,6 +912,67 @@ func TestUDPConn(t *testing.T) {
 	}
 }
 
+func TestClaimDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := claimDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := claimDir(dir); err == nil {
+		t.Fatal("second claimDir succeeded; want error")
+	}
+	releaseDir(dir)
+	if err := claimDir(dir); err != nil {
+		t.Fatalf("claimDir after release: %v", err)
+	}
+	releaseDir(dir)
+}
+
+func TestUDPConnWildcard(t *testing.T) {
+	tstest.ResourceCheck(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL, _ := startControl(t)
+	s1, s1ip, _ := startServer(t, ctx, controlURL, "s1")
+	s2, s2ip, _ := startServer(t, ctx, controlURL, "s2")
+
+	lc2, err := s2.LocalClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lc2.Ping(ctx, s1ip, tailcfg.PingICMP); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s1.ListenPacket("udp", ":8082"); err == nil {
+		t.Fatal("ListenPacket(udp, :8082) succeeded; want error")
+	}
+	pc := must.Get(s1.ListenPacket("udp4", ":8082"))
+	defer pc.Close()
+
+	laddr := netip.AddrPortFrom(s2ip, 9092)
+	w, err := s2.DialUDP(ctx, laddr, netip.AddrPortFrom(s1ip, 8082))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if _, err := io.WriteString(w, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 1024)
+	n, from, err := pc.ReadFrom(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got[:n]) != "hello" {
+		t.Errorf("got %q, want hello", got[:n])
+	}
+	if from.(*net.UDPAddr).AddrPort() != laddr {
+		t.Errorf("got from %v, want %v", from, laddr)
+	}
+}
+
 func parseMetrics(m []byte) (map[string]float64, error) {
 	metrics := make(map[string]float64)
 
@@ -1301,3 +1363,44 @@ func mustDirect(t *testing.T, logf logger.Logf, lc1, lc2 *local.Client) {
 	}
 	t.Error("magicsock did not find a direct path from lc1 to lc2")
 }
+
+func TestServeConfigEdits(t *testing.T) {
+	const domain = "foo.test.ts.net"
+	newConfig := func() *ServeConfig {
+		return &ServeConfig{domain: domain, self: new(ipnstate.PeerStatus), sc: new(ipn.ServeConfig)}
+	}
+
+	c := newConfig()
+	c.Proxy(443, "/", "3000").Text(443, "/hello", "hi").TCP(5432, "5432", false)
+	if c.err != nil {
+		t.Fatal(c.err)
+	}
+	want := &ipn.ServeConfig{
+		TCP: map[uint16]*ipn.TCPPortHandler{
+			443:  {HTTPS: true},
+			5432: {TCPForward: "127.0.0.1:5432"},
+		},
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			domain + ":443": {Handlers: map[string]*ipn.HTTPHandler{
+				"/":      {Proxy: "http://127.0.0.1:3000"},
+				"/hello": {Text: "hi"},
+			}},
+		},
+	}
+	if !reflect.DeepEqual(c.sc, want) {
+		t.Errorf("got %+v; want %+v", c.sc, want)
+	}
+	if c.Remove(443).Remove(5432); !reflect.DeepEqual(c.sc, new(ipn.ServeConfig)) {
+		t.Errorf("after Remove, got %+v; want empty", c.sc)
+	}
+
+	if c := newConfig().Proxy(443, "/", "example.com:80"); c.err == nil {
+		t.Error("Proxy to a non-local target succeeded")
+	}
+	if c := newConfig().TCP(443, "443", true).Text(443, "/", "hi"); c.err == nil {
+		t.Error("serving HTTPS on a TCP forwarding port succeeded")
+	}
+	if c := newConfig().Funnel(443, true); c.err == nil {
+		t.Error("Funnel without the funnel node attribute succeeded")
+	}
+}
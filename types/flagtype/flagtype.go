@@ -11,6 +11,8 @@
 	"math"
 	"strconv"
 	"strings"
+
+	"tailscale.com/tailcfg"
 )
 
 type portValue struct{ n *uint16 }
@@ -43,3 +45,44 @@ func (p portValue) Set(v string) error {
 	*p.n = uint16(n)
 	return nil
 }
+
+type portRangeValue struct{ pr *tailcfg.PortRange }
+
+// PortRangeValue returns a flag.Value that sets *dst from a "FIRST-LAST"
+// range of port numbers, or a single port number. The empty string, the
+// default, leaves *dst zero.
+func PortRangeValue(dst *tailcfg.PortRange) flag.Value {
+	return portRangeValue{dst}
+}
+
+func (p portRangeValue) String() string {
+	if p.pr == nil || *p.pr == (tailcfg.PortRange{}) {
+		return ""
+	}
+	return fmt.Sprintf("%d-%d", p.pr.First, p.pr.Last)
+}
+
+func (p portRangeValue) Set(v string) error {
+	if v == "" {
+		*p.pr = tailcfg.PortRange{}
+		return nil
+	}
+	firstStr, lastStr, isRange := strings.Cut(v, "-")
+	if !isRange {
+		lastStr = firstStr
+	}
+	var ports [2]uint16
+	for i, s := range []string{firstStr, lastStr} {
+		if err := (portValue{&ports[i]}).Set(s); err != nil {
+			return err
+		}
+	}
+	if ports[0] == 0 {
+		return errors.New("first port must be greater than 0")
+	}
+	if ports[0] > ports[1] {
+		return errors.New("first port must not be greater than the last")
+	}
+	*p.pr = tailcfg.PortRange{First: ports[0], Last: ports[1]}
+	return nil
+}
@@ -0,0 +1,270 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package bsdfw manages Tailscale's packet filter rules on the BSDs.
+//
+// It is the BSD counterpart of util/linuxfw: a Runner programs the rules
+// the router needs (letting tailnet traffic through the Tailscale
+// interface, masquerading subnet router traffic, stateful filtering of
+// forwarded connections, and letting magicsock's UDP port in) into whichever
+// of pf, ipfw or npf the system uses. All rules live in a Tailscale-owned
+// part of the ruleset (a pf anchor, tagged rules in an ipfw set, or an npf
+// dynamic ruleset), so the administrator's own rules are left alone.
+package bsdfw
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"tailscale.com/envknob"
+	"tailscale.com/types/logger"
+)
+
+// Mode is the kind of packet filter being used.
+type Mode string
+
+const (
+	ModePF   Mode = "pf"
+	ModeIPFW Mode = "ipfw"
+	ModeNPF  Mode = "npf"
+)
+
+// ErrNoFirewall is returned by New when no supported packet filter is
+// enabled on the system.
+var ErrNoFirewall = errors.New("no supported packet filter enabled")
+
+// Runner manages Tailscale's rules in the system packet filter.
+//
+// Each method replaces Tailscale's whole ruleset with one reflecting the
+// new state, so calls are idempotent.
+type Runner interface {
+	// Mode returns the kind of packet filter the Runner programs.
+	Mode() Mode
+
+	// AddBase adds rules passing all traffic on the Tailscale interface
+	// tunname, which wireguard-go's own filter already polices.
+	AddBase(tunname string) error
+
+	// DelBase removes the rules added by AddBase.
+	DelBase() error
+
+	// AddSNATRule adds a rule masquerading traffic from tailnet addresses
+	// leaving through the interface egressIf, so that hosts on subnets
+	// this node routes for can reply without a route back to the tailnet.
+	AddSNATRule(egressIf string) error
+
+	// DelSNATRule removes the rule added by AddSNATRule.
+	DelSNATRule() error
+
+	// AddStatefulRule adds rules rejecting new connections forwarded out
+	// the Tailscale interface tunname from non-tailnet sources, so that
+	// hosts on the LAN can't use this node to reach the tailnet, while
+	// letting replies to connections from the tailnet through.
+	AddStatefulRule(tunname string) error
+
+	// DelStatefulRule removes the rules added by AddStatefulRule.
+	DelStatefulRule() error
+
+	// AddMagicsockPortRule adds a rule passing inbound UDP traffic to
+	// magicsock's port. network is "udp4" or "udp6".
+	AddMagicsockPortRule(port uint16, network string) error
+
+	// DelMagicsockPortRule removes the rule added by AddMagicsockPortRule.
+	DelMagicsockPortRule(port uint16, network string) error
+
+	// Cleanup removes all of Tailscale's rules.
+	Cleanup() error
+}
+
+// ruleState is the full set of rules a Runner wants in place.
+type ruleState struct {
+	baseTun     string // interface to pass traffic on, or empty
+	snatIf      string // interface to masquerade on, or empty
+	statefulTun string // interface to statefully filter, or empty
+	port4       uint16 // magicsock IPv4 port, or zero
+	port6       uint16 // magicsock IPv6 port, or zero
+}
+
+func (s ruleState) isZero() bool { return s == ruleState{} }
+
+// commandRunner runs a packet filter control command, feeding it stdin if
+// non-nil. It exists to be replaced in tests.
+type commandRunner func(stdin []byte, args ...string) ([]byte, error)
+
+func execCommand(stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("running %q failed: %w\n%s", strings.Join(args, " "), err, out)
+	}
+	return out, nil
+}
+
+// backend renders a ruleState into a specific packet filter.
+type backend interface {
+	mode() Mode
+
+	// apply replaces Tailscale's rules with those for st.
+	apply(run commandRunner, st ruleState) error
+
+	// flush removes all of Tailscale's rules.
+	flush(run commandRunner) error
+}
+
+// runner implements Runner on top of a backend.
+type runner struct {
+	logf logger.Logf
+	b    backend
+	run  commandRunner
+
+	mu sync.Mutex
+	st ruleState
+}
+
+func (r *runner) Mode() Mode { return r.b.mode() }
+
+// update applies the state change made by f, keeping the previous state if
+// it fails.
+func (r *runner) update(f func(*ruleState)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st := r.st
+	f(&st)
+	if st == r.st {
+		return nil
+	}
+	var err error
+	if st.isZero() {
+		err = r.b.flush(r.run)
+	} else {
+		err = r.b.apply(r.run, st)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", r.b.mode(), err)
+	}
+	r.st = st
+	return nil
+}
+
+func (r *runner) AddBase(tunname string) error {
+	return r.update(func(st *ruleState) { st.baseTun = tunname })
+}
+
+func (r *runner) DelBase() error {
+	return r.update(func(st *ruleState) { st.baseTun = "" })
+}
+
+func (r *runner) AddSNATRule(egressIf string) error {
+	return r.update(func(st *ruleState) { st.snatIf = egressIf })
+}
+
+func (r *runner) DelSNATRule() error {
+	return r.update(func(st *ruleState) { st.snatIf = "" })
+}
+
+func (r *runner) AddStatefulRule(tunname string) error {
+	return r.update(func(st *ruleState) { st.statefulTun = tunname })
+}
+
+func (r *runner) DelStatefulRule() error {
+	return r.update(func(st *ruleState) { st.statefulTun = "" })
+}
+
+func (r *runner) AddMagicsockPortRule(port uint16, network string) error {
+	return r.update(func(st *ruleState) {
+		switch network {
+		case "udp4":
+			st.port4 = port
+		case "udp6":
+			st.port6 = port
+		}
+	})
+}
+
+func (r *runner) DelMagicsockPortRule(port uint16, network string) error {
+	return r.update(func(st *ruleState) {
+		switch {
+		case network == "udp4" && st.port4 == port:
+			st.port4 = 0
+		case network == "udp6" && st.port6 == port:
+			st.port6 = 0
+		}
+	})
+}
+
+func (r *runner) Cleanup() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.st = ruleState{}
+	return r.b.flush(r.run)
+}
+
+// candidates returns the packet filters to probe for on the current OS,
+// in order of preference.
+func candidates(goos string) []backend {
+	switch goos {
+	case "openbsd":
+		return []backend{pf{newSyntax: true}}
+	case "freebsd":
+		return []backend{pf{}, ipfw{}}
+	case "netbsd":
+		return []backend{npf{}, pf{}}
+	case "dragonfly":
+		return []backend{pf{}, ipfw{}}
+	}
+	return nil
+}
+
+// probe reports whether the packet filter b is installed and enabled.
+func probe(b backend, run commandRunner) bool {
+	switch b.mode() {
+	case ModePF:
+		// pfctl works whether or not pf is enabled, so check which.
+		out, err := run(nil, "pfctl", "-s", "info")
+		return err == nil && bytes.Contains(out, []byte("Status: Enabled"))
+	case ModeIPFW:
+		_, err := run(nil, "ipfw", "-q", "list")
+		return err == nil
+	case ModeNPF:
+		_, err := run(nil, "npfctl", "show")
+		return err == nil
+	}
+	return false
+}
+
+// New returns a Runner for the packet filter in use on this system.
+//
+// The TS_DEBUG_FIREWALL_MODE environment variable may be set to "pf",
+// "ipfw" or "npf" to skip detection. If no supported packet filter is
+// enabled, New returns ErrNoFirewall.
+func New(logf logger.Logf) (Runner, error) {
+	return newRunner(logf, runtime.GOOS, envknob.String("TS_DEBUG_FIREWALL_MODE"), execCommand)
+}
+
+func newRunner(logf logger.Logf, goos, forced string, run commandRunner) (Runner, error) {
+	cands := candidates(goos)
+	if forced != "" {
+		i := slices.IndexFunc(cands, func(b backend) bool { return string(b.mode()) == forced })
+		if i < 0 {
+			return nil, fmt.Errorf("firewall mode %q not supported on %s", forced, goos)
+		}
+		logf("bsdfw: TS_DEBUG_FIREWALL_MODE set, using %s", forced)
+		return &runner{logf: logf, b: cands[i], run: run}, nil
+	}
+	for _, b := range cands {
+		if probe(b, run) {
+			logf("bsdfw: using %s", b.mode())
+			return &runner{logf: logf, b: b, run: run}, nil
+		}
+	}
+	return nil, ErrNoFirewall
+}
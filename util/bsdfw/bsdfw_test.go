@@ -0,0 +1,223 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package bsdfw
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeCommands records the commands run and fails those listed in fail.
+type fakeCommands struct {
+	ran  []string
+	fail map[string]bool
+	out  map[string]string
+}
+
+func (f *fakeCommands) run(stdin []byte, args ...string) ([]byte, error) {
+	c := strings.Join(args, " ")
+	if stdin != nil {
+		c += " <<" + strings.TrimSpace(string(stdin))
+	}
+	f.ran = append(f.ran, c)
+	if f.fail[args[0]] {
+		return nil, errors.New("not found")
+	}
+	return []byte(f.out[args[0]]), nil
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		goos   string
+		forced string
+		fail   []string
+		out    map[string]string
+		want   Mode
+	}{
+		{goos: "openbsd", out: map[string]string{"pfctl": "Status: Enabled for 0 days"}, want: ModePF},
+		{goos: "openbsd", out: map[string]string{"pfctl": "Status: Disabled"}},
+		{goos: "freebsd", fail: []string{"pfctl"}, want: ModeIPFW},
+		{goos: "freebsd", fail: []string{"pfctl", "ipfw"}},
+		{goos: "netbsd", want: ModeNPF},
+		{goos: "netbsd", forced: "pf", fail: []string{"pfctl"}, want: ModePF},
+		{goos: "linux"},
+	}
+	for _, tt := range tests {
+		f := &fakeCommands{fail: map[string]bool{}, out: tt.out}
+		for _, c := range tt.fail {
+			f.fail[c] = true
+		}
+		r, err := newRunner(t.Logf, tt.goos, tt.forced, f.run)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: got %v, want error", tt.goos, r.Mode())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.goos, err)
+			continue
+		}
+		if r.Mode() != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.goos, r.Mode(), tt.want)
+		}
+	}
+	if _, err := newRunner(t.Logf, "openbsd", "ipfw", nil); err == nil {
+		t.Errorf("forcing ipfw on openbsd succeeded")
+	}
+}
+
+func TestRules(t *testing.T) {
+	st := ruleState{
+		baseTun:     "tailscale0",
+		snatIf:      "em0",
+		statefulTun: "tailscale0",
+		port4:       41641,
+	}
+
+	got := pf{newSyntax: true}.rules(st)
+	want := `match out on em0 inet from 100.64.0.0/10 nat-to (em0:0)
+block out quick on tailscale0 inet from ! 100.64.0.0/10 to any
+block out quick on tailscale0 inet6 from ! fd7a:115c:a1e0::/48 to any
+pass quick on tailscale0 all
+pass in quick inet proto udp from any to any port 41641
+`
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("pf rules (-want +got):\n%s", diff)
+	}
+	if got := (pf{}).rules(st); !strings.HasPrefix(got, "nat on em0 inet from 100.64.0.0/10 to any -> (em0:0)\n") {
+		t.Errorf("old-syntax pf rules don't start with nat rule:\n%s", got)
+	}
+
+	var cmds []string
+	for _, c := range (ipfw{}).commands(ruleState{baseTun: "tun0", statefulTun: "tun0", port6: 1234}) {
+		cmds = append(cmds, strings.Join(c, " "))
+	}
+	wantCmds := []string{
+		"ipfw -q add 20200 set 21 check-state // tailscale",
+		"ipfw -q add 20201 set 21 allow ip4 from any to not 100.64.0.0/10 in recv tun0 keep-state // tailscale",
+		"ipfw -q add 20202 set 21 allow ip6 from any to not fd7a:115c:a1e0::/48 in recv tun0 keep-state // tailscale",
+		"ipfw -q add 20203 set 21 deny ip4 from not 100.64.0.0/10 to any out xmit tun0 // tailscale",
+		"ipfw -q add 20204 set 21 deny ip6 from not fd7a:115c:a1e0::/48 to any out xmit tun0 // tailscale",
+		"ipfw -q add 20300 set 21 allow ip from any to any via tun0 // tailscale",
+		"ipfw -q add 20401 set 21 allow udp from any to me6 1234 in // tailscale",
+	}
+	if diff := cmp.Diff(wantCmds, cmds); diff != "" {
+		t.Errorf("ipfw commands (-want +got):\n%s", diff)
+	}
+
+	npfRules := (npf{}).rules(st)
+	wantNPF := map[string][]string{
+		"tailscale": {
+			"block out final on tailscale0 family inet from ! 100.64.0.0/10 to any",
+			"block out final on tailscale0 family inet6 from ! fd7a:115c:a1e0::/48 to any",
+			"pass stateful final on tailscale0 all",
+			"pass stateful in final family inet proto udp to any port 41641",
+		},
+		"tailscale-nat": {
+			"map em0 dynamic 100.64.0.0/10 -> em0",
+		},
+	}
+	if diff := cmp.Diff(wantNPF, npfRules); diff != "" {
+		t.Errorf("npf rules (-want +got):\n%s", diff)
+	}
+}
+
+func TestRunnerUpdates(t *testing.T) {
+	f := &fakeCommands{fail: map[string]bool{}}
+	r := &runner{logf: t.Logf, b: npf{}, run: f.run}
+
+	if err := r.AddBase("tun0"); err != nil {
+		t.Fatal(err)
+	}
+	// Repeating a call with no change in state runs nothing.
+	if err := r.AddBase("tun0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.AddMagicsockPortRule(41641, "udp4"); err != nil {
+		t.Fatal(err)
+	}
+	// Deleting a port that isn't the current one is a no-op.
+	if err := r.DelMagicsockPortRule(1, "udp4"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DelBase(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DelMagicsockPortRule(41641, "udp4"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"npfctl rule tailscale flush",
+		"npfctl rule tailscale-nat flush",
+		"npfctl rule tailscale add pass stateful final on tun0 all",
+
+		"npfctl rule tailscale flush",
+		"npfctl rule tailscale-nat flush",
+		"npfctl rule tailscale add pass stateful final on tun0 all",
+		"npfctl rule tailscale add pass stateful in final family inet proto udp to any port 41641",
+
+		"npfctl rule tailscale flush",
+		"npfctl rule tailscale-nat flush",
+		"npfctl rule tailscale add pass stateful in final family inet proto udp to any port 41641",
+
+		"npfctl rule tailscale flush",
+		"npfctl rule tailscale-nat flush",
+	}
+	if diff := cmp.Diff(want, f.ran); diff != "" {
+		t.Errorf("commands (-want +got):\n%s", diff)
+	}
+
+	// A failed update leaves the recorded state unchanged, so that the
+	// same call is retried in full.
+	f.fail["npfctl"] = true
+	if err := r.AddBase("tun0"); err == nil {
+		t.Fatal("AddBase succeeded with failing npfctl")
+	}
+	if !r.st.isZero() {
+		t.Errorf("state after failure = %+v, want zero", r.st)
+	}
+}
+
+func TestIPFWFlush(t *testing.T) {
+	// An administrator's rules, even in Tailscale's set, and an nat
+	// instance of the same number that Tailscale's rules don't use, must
+	// survive.
+	list := `00100 set 0 allow ip from any to any via lo0
+20100 set 21 allow ip from any to any via em0
+20200 set 21 check-state :default // tailscale
+20300 set 21 allow ip from any to any via tun0 // tailscale
+20300 set 21 allow ip from any to any via tun0 // tailscale
+20400 set 3 allow udp from any to me 41641 in // tailscale
+65535 set 31 deny ip from any to any
+`
+	f := &fakeCommands{fail: map[string]bool{}, out: map[string]string{"ipfw": list}}
+	if err := (ipfw{}).flush(f.run); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"ipfw -S list",
+		"ipfw -q set 21 delete 20200 20300",
+	}
+	if diff := cmp.Diff(want, f.ran); diff != "" {
+		t.Errorf("commands (-want +got):\n%s", diff)
+	}
+
+	list += "20100 set 21 nat 41641 ip4 from 100.64.0.0/10 to any out xmit em0 // tailscale\n"
+	f = &fakeCommands{fail: map[string]bool{}, out: map[string]string{"ipfw": list}}
+	if err := (ipfw{}).flush(f.run); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{
+		"ipfw -S list",
+		"ipfw -q set 21 delete 20200 20300 20100",
+		"ipfw -q nat 41641 delete",
+	}
+	if diff := cmp.Diff(want, f.ran); diff != "" {
+		t.Errorf("commands with nat (-want +got):\n%s", diff)
+	}
+}
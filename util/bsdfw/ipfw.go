@@ -0,0 +1,131 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package bsdfw
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"tailscale.com/net/tsaddr"
+)
+
+const (
+	// ipfwSet is the ipfw rule set holding Tailscale's rules.
+	ipfwSet = "21"
+
+	// ipfwTag is the comment marking Tailscale's rules. The set alone
+	// doesn't identify them, as nothing stops an administrator from
+	// using it too, so only rules with this comment are ever deleted.
+	ipfwTag = "tailscale"
+
+	// ipfwNAT is the ipfw nat instance used for masquerading.
+	ipfwNAT = "41641"
+)
+
+// Rule numbers. ipfw evaluates rules in numeric order, so these order the
+// stateful rules before the blanket pass for the Tailscale interface.
+const (
+	ipfwRuleNAT      = 20100
+	ipfwRuleStateful = 20200
+	ipfwRuleBase     = 20300
+	ipfwRulePort     = 20400
+)
+
+// ipfw programs FreeBSD's ipfw packet filter.
+type ipfw struct{}
+
+func (ipfw) mode() Mode { return ModeIPFW }
+
+// commands returns the ipfw commands that add the rules for st, to be
+// run after Tailscale's previous rules have been deleted.
+func (ipfw) commands(st ruleState) [][]string {
+	var cmds [][]string
+	add := func(num int, rule ...string) {
+		c := append([]string{"ipfw", "-q", "add", strconv.Itoa(num), "set", ipfwSet}, rule...)
+		cmds = append(cmds, append(c, "//", ipfwTag))
+	}
+	cgnat, ula := tsaddr.CGNATRange().String(), tsaddr.TailscaleULARange().String()
+
+	if st.snatIf != "" {
+		cmds = append(cmds, []string{"ipfw", "-q", "nat", ipfwNAT, "config", "if", st.snatIf, "same_ports", "reset"})
+		add(ipfwRuleNAT, "nat", ipfwNAT, "ip4", "from", cgnat, "to", "any", "out", "xmit", st.snatIf)
+		add(ipfwRuleNAT+1, "nat", ipfwNAT, "ip4", "from", "any", "to", "any", "in", "recv", st.snatIf)
+	}
+	if t := st.statefulTun; t != "" {
+		// Keep state for connections from the tailnet to the LAN so
+		// their replies get back out, then refuse anything else leaving
+		// for the tailnet from a non-tailnet source, that is, new
+		// connections from the LAN.
+		add(ipfwRuleStateful, "check-state")
+		add(ipfwRuleStateful+1, "allow", "ip4", "from", "any", "to", "not", cgnat, "in", "recv", t, "keep-state")
+		add(ipfwRuleStateful+2, "allow", "ip6", "from", "any", "to", "not", ula, "in", "recv", t, "keep-state")
+		add(ipfwRuleStateful+3, "deny", "ip4", "from", "not", cgnat, "to", "any", "out", "xmit", t)
+		add(ipfwRuleStateful+4, "deny", "ip6", "from", "not", ula, "to", "any", "out", "xmit", t)
+	}
+	if st.baseTun != "" {
+		add(ipfwRuleBase, "allow", "ip", "from", "any", "to", "any", "via", st.baseTun)
+	}
+	if st.port4 != 0 {
+		add(ipfwRulePort, "allow", "udp", "from", "any", "to", "me", strconv.Itoa(int(st.port4)), "in")
+	}
+	if st.port6 != 0 {
+		add(ipfwRulePort+1, "allow", "udp", "from", "any", "to", "me6", strconv.Itoa(int(st.port6)), "in")
+	}
+	return cmds
+}
+
+func (b ipfw) apply(run commandRunner, st ruleState) error {
+	if err := b.flush(run); err != nil {
+		return err
+	}
+	for _, c := range b.commands(st) {
+		if _, err := run(nil, c...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ownRules returns the numbers of the rules in the output of "ipfw -S
+// list" that Tailscale added, and whether any of them use its nat
+// instance.
+func (ipfw) ownRules(list []byte) (nums []string, nat bool) {
+	for _, line := range strings.Split(string(list), "\n") {
+		f := strings.Fields(line)
+		// Rules are listed as "NUM set SET ACTION ... // COMMENT".
+		if len(f) < 6 || f[1] != "set" || f[2] != ipfwSet {
+			continue
+		}
+		if f[len(f)-2] != "//" || f[len(f)-1] != ipfwTag {
+			continue
+		}
+		if !slices.Contains(nums, f[0]) {
+			nums = append(nums, f[0])
+		}
+		if f[3] == "nat" && f[4] == ipfwNAT {
+			nat = true
+		}
+	}
+	return nums, nat
+}
+
+func (b ipfw) flush(run commandRunner) error {
+	list, err := run(nil, "ipfw", "-S", "list")
+	if err != nil {
+		return err
+	}
+	nums, nat := b.ownRules(list)
+	if len(nums) > 0 {
+		if _, err := run(nil, append([]string{"ipfw", "-q", "set", ipfwSet, "delete"}, nums...)...); err != nil {
+			return err
+		}
+	}
+	if nat {
+		if _, err := run(nil, "ipfw", "-q", "nat", ipfwNAT, "delete"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package bsdfw
+
+import (
+	"fmt"
+	"strings"
+
+	"tailscale.com/net/tsaddr"
+)
+
+// npf's dynamic rulesets holding Tailscale's rules. npf.conf must declare
+// them for the rules to take effect, with
+//
+//	ruleset "tailscale"
+//
+// in the group filtering traffic and, to masquerade subnet router traffic,
+//
+//	map ruleset "tailscale-nat" on $ext_if
+const (
+	npfRuleset    = "tailscale"
+	npfNATRuleset = "tailscale-nat"
+)
+
+// npf programs NetBSD's npf packet filter.
+type npf struct{}
+
+func (npf) mode() Mode { return ModeNPF }
+
+// rules returns the rules to add to each of npf's dynamic rulesets for st,
+// keyed by ruleset name.
+func (npf) rules(st ruleState) map[string][]string {
+	m := map[string][]string{}
+	add := func(ruleset, format string, args ...any) {
+		m[ruleset] = append(m[ruleset], fmt.Sprintf(format, args...))
+	}
+	cgnat, ula := tsaddr.CGNATRange(), tsaddr.TailscaleULARange()
+
+	if st.snatIf != "" {
+		add(npfNATRuleset, "map %s dynamic %v -> %s", st.snatIf, cgnat, st.snatIf)
+	}
+	if t := st.statefulTun; t != "" {
+		// As with pf, replies to connections from the tailnet match the
+		// state created as they came in and skip the ruleset.
+		add(npfRuleset, "block out final on %s family inet from ! %v to any", t, cgnat)
+		add(npfRuleset, "block out final on %s family inet6 from ! %v to any", t, ula)
+	}
+	if st.baseTun != "" {
+		add(npfRuleset, "pass stateful final on %s all", st.baseTun)
+	}
+	if st.port4 != 0 {
+		add(npfRuleset, "pass stateful in final family inet proto udp to any port %d", st.port4)
+	}
+	if st.port6 != 0 {
+		add(npfRuleset, "pass stateful in final family inet6 proto udp to any port %d", st.port6)
+	}
+	return m
+}
+
+func (b npf) apply(run commandRunner, st ruleState) error {
+	if err := b.flush(run); err != nil {
+		return err
+	}
+	rules := b.rules(st)
+	for _, rs := range []string{npfRuleset, npfNATRuleset} {
+		for _, r := range rules[rs] {
+			args := append([]string{"npfctl", "rule", rs, "add"}, strings.Fields(r)...)
+			if _, err := run(nil, args...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (npf) flush(run commandRunner) error {
+	if _, err := run(nil, "npfctl", "rule", npfRuleset, "flush"); err != nil {
+		return err
+	}
+	// The NAT ruleset is only needed for subnet routers, so tolerate
+	// its absence.
+	run(nil, "npfctl", "rule", npfNATRuleset, "flush")
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package bsdfw
+
+import (
+	"fmt"
+	"strings"
+
+	"tailscale.com/net/tsaddr"
+)
+
+// pfAnchor is the pf anchor holding Tailscale's rules. The main ruleset
+// must reference it (with "anchor" and, on systems using the older
+// translation syntax, "nat-anchor") for the rules to take effect.
+const pfAnchor = "tailscale"
+
+// pf programs the pf packet filter used on OpenBSD, FreeBSD and NetBSD.
+type pf struct {
+	// newSyntax is whether pf uses the OpenBSD 4.7+ syntax, where
+	// translation is done by match rules rather than nat rules.
+	newSyntax bool
+}
+
+func (pf) mode() Mode { return ModePF }
+
+// rules returns the anchor's ruleset for st.
+func (b pf) rules(st ruleState) string {
+	var sb strings.Builder
+	line := func(format string, args ...any) {
+		fmt.Fprintf(&sb, format, args...)
+		sb.WriteByte('\n')
+	}
+	cgnat, ula := tsaddr.CGNATRange(), tsaddr.TailscaleULARange()
+
+	// In the older syntax, translation rules must precede filter rules.
+	if st.snatIf != "" {
+		if b.newSyntax {
+			line("match out on %s inet from %v nat-to (%s:0)", st.snatIf, cgnat, st.snatIf)
+		} else {
+			line("nat on %s inet from %v to any -> (%s:0)", st.snatIf, cgnat, st.snatIf)
+		}
+	}
+	if t := st.statefulTun; t != "" {
+		// Refuse new connections from the LAN into the tailnet. Packets
+		// from the tailnet to the LAN create state as they come in on
+		// the interface, so their replies match it and never reach
+		// these rules; this node's own traffic has a tailnet source.
+		line("block out quick on %s inet from ! %v to any", t, cgnat)
+		line("block out quick on %s inet6 from ! %v to any", t, ula)
+	}
+	if st.baseTun != "" {
+		line("pass quick on %s all", st.baseTun)
+	}
+	if st.port4 != 0 {
+		line("pass in quick inet proto udp from any to any port %d", st.port4)
+	}
+	if st.port6 != 0 {
+		line("pass in quick inet6 proto udp from any to any port %d", st.port6)
+	}
+	return sb.String()
+}
+
+func (b pf) apply(run commandRunner, st ruleState) error {
+	_, err := run([]byte(b.rules(st)), "pfctl", "-q", "-a", pfAnchor, "-f", "-")
+	return err
+}
+
+func (pf) flush(run commandRunner) error {
+	_, err := run(nil, "pfctl", "-q", "-a", pfAnchor, "-F", "all")
+	return err
+}
@@ -0,0 +1,14 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build windows || wasm || plan9 || tamago
+
+package resourcewatch
+
+import "time"
+
+// processCPUTime returns zero, disabling CPU monitoring on this platform.
+func processCPUTime() time.Duration {
+	// TODO: use GetProcessTimes on Windows.
+	return 0
+}
@@ -0,0 +1,380 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package resourcewatch watches the current process's CPU and memory usage
+// and, when either stays above a threshold for a sustained period, captures a
+// local diagnostics bundle (profiles and recent logs) and raises a health
+// warning pointing at it.
+//
+// It exists to catch rare runaway conditions in the field, where by the time
+// somebody notices and attaches a profiler the interesting state is long gone.
+package resourcewatch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/metrics"
+	"runtime/pprof"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/envknob"
+	"tailscale.com/health"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/ringbuffer"
+	"tailscale.com/util/sysresources"
+)
+
+var (
+	disableKnob    = envknob.RegisterBool("TS_DEBUG_DISABLE_RESOURCE_WATCHDOG")
+	cpuPercentKnob = envknob.RegisterInt("TS_RESOURCE_WATCHDOG_CPU_PERCENT")
+	memMBKnob      = envknob.RegisterInt("TS_RESOURCE_WATCHDOG_MEM_MB")
+	sustainKnob    = envknob.RegisterDuration("TS_RESOURCE_WATCHDOG_SUSTAIN")
+)
+
+// Config configures a Watcher.
+type Config struct {
+	// Dir is the directory in which diagnostics bundles are written.
+	// Each bundle is a subdirectory named after the time it was captured.
+	Dir string
+
+	// CPUPercent is the CPU usage threshold, as a percentage of one
+	// CPU core (so values above 100 are meaningful on multicore
+	// machines). Zero disables CPU monitoring.
+	CPUPercent float64
+
+	// MemoryBytes is the threshold for memory mapped by the Go runtime.
+	// Zero disables memory monitoring.
+	MemoryBytes uint64
+
+	// Sustain is how long usage must stay above a threshold before a
+	// bundle is captured.
+	Sustain time.Duration
+
+	// Interval is how often usage is sampled.
+	Interval time.Duration
+
+	// Cooldown is the minimum time between two captures. The health
+	// warning stays visible until it expires.
+	Cooldown time.Duration
+
+	// CPUProfileDuration is how long a CPU profile is collected for when
+	// the CPU threshold is the one exceeded.
+	CPUProfileDuration time.Duration
+
+	// MaxBundles is the number of bundles kept in Dir. Older bundles are
+	// removed when a new one is captured.
+	MaxBundles int
+
+	// LogLines is the number of recent log lines kept in memory to be
+	// included in a bundle.
+	LogLines int
+}
+
+// DefaultConfig returns the default configuration for a Watcher writing
+// bundles to dir, with thresholds overridable by environment variables.
+//
+// The CPU threshold defaults to 90% of one core and the memory threshold to
+// half of system memory (or 2 GiB if that can't be determined), both
+// sustained for ten minutes.
+func DefaultConfig(dir string) Config {
+	c := Config{
+		Dir:                dir,
+		CPUPercent:         90,
+		MemoryBytes:        2 << 30,
+		Sustain:            10 * time.Minute,
+		Interval:           15 * time.Second,
+		Cooldown:           time.Hour,
+		CPUProfileDuration: 15 * time.Second,
+		MaxBundles:         3,
+		LogLines:           1000,
+	}
+	if total := sysresources.TotalMemory(); total > 0 {
+		c.MemoryBytes = total / 2
+	}
+	if v := cpuPercentKnob(); v > 0 {
+		c.CPUPercent = float64(v)
+	}
+	if v := memMBKnob(); v > 0 {
+		c.MemoryBytes = uint64(v) << 20
+	}
+	if v := sustainKnob(); v > 0 {
+		c.Sustain = v
+	}
+	return c
+}
+
+// Disabled reports whether the watchdog has been disabled with the
+// TS_DEBUG_DISABLE_RESOURCE_WATCHDOG environment variable.
+func Disabled() bool {
+	return disableKnob()
+}
+
+// sample is a single measurement of the process's resource usage.
+type sample struct {
+	when    time.Time
+	cpuTime time.Duration // cumulative user+system CPU time; zero if unknown
+	memory  uint64        // bytes mapped by the Go runtime
+}
+
+// Watcher samples the process's resource usage and captures a diagnostics
+// bundle when it stays too high for too long.
+type Watcher struct {
+	logf logger.Logf
+	ht   *health.Tracker
+	cfg  Config
+	logs *ringbuffer.RingBuffer[string]
+
+	// sampleFunc and now are overridden in tests.
+	sampleFunc func() sample
+	now        func() time.Time
+
+	mu          sync.Mutex
+	last        sample    // previous sample, for computing CPU rate
+	cpuSince    time.Time // when CPU usage first went above threshold; zero if below
+	memSince    time.Time // when memory usage first went above threshold; zero if below
+	lastCapture time.Time
+	lastBundle  string
+}
+
+// New returns a new Watcher. It does nothing until Run is called.
+func New(logf logger.Logf, ht *health.Tracker, cfg Config) *Watcher {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 15 * time.Second
+	}
+	if cfg.LogLines <= 0 {
+		cfg.LogLines = 1000
+	}
+	return &Watcher{
+		logf:       logger.WithPrefix(logf, "resourcewatch: "),
+		ht:         ht,
+		cfg:        cfg,
+		logs:       ringbuffer.New[string](cfg.LogLines),
+		sampleFunc: takeSample,
+		now:        time.Now,
+	}
+}
+
+// WrapLogf returns a Logf that records each line into w's in-memory log
+// buffer, for inclusion in bundles, before passing it on to logf.
+func (w *Watcher) WrapLogf(logf logger.Logf) logger.Logf {
+	return func(format string, args ...any) {
+		line := fmt.Sprintf(format, args...)
+		w.logs.Add(time.Now().UTC().Format(time.RFC3339Nano) + " " + strings.TrimSuffix(line, "\n"))
+		logf(format, args...)
+	}
+}
+
+// LastBundle returns the path of the most recently captured bundle, or the
+// empty string if none has been captured.
+func (w *Watcher) LastBundle() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lastBundle
+}
+
+// Run samples resource usage until ctx is done.
+func (w *Watcher) Run(ctx context.Context) {
+	if w.cfg.CPUPercent <= 0 && w.cfg.MemoryBytes == 0 {
+		return
+	}
+	w.mu.Lock()
+	w.last = w.sampleFunc()
+	w.mu.Unlock()
+
+	t := time.NewTicker(w.cfg.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if reason := w.check(w.sampleFunc()); reason != "" {
+			dir, err := w.capture(ctx, reason)
+			if err != nil {
+				w.logf("capturing diagnostics for sustained high %s: %v", reason, err)
+				continue
+			}
+			w.logf("sustained high %s; diagnostics saved to %s", reason, dir)
+			w.ht.SetUnhealthy(highUsageWarnable, health.Args{
+				argResource:   reason,
+				argBundlePath: dir,
+			})
+		}
+	}
+}
+
+// check records s and returns the resource ("CPU" or "memory") whose usage
+// has been above its threshold for the configured Sustain period, if a
+// capture is due. Otherwise it returns the empty string.
+func (w *Watcher) check(s sample) (reason string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := w.now()
+	prev := w.last
+	w.last = s
+
+	cpuHigh := false
+	if w.cfg.CPUPercent > 0 && s.cpuTime > 0 && prev.cpuTime > 0 {
+		if wall := s.when.Sub(prev.when); wall > 0 {
+			pct := 100 * float64(s.cpuTime-prev.cpuTime) / float64(wall)
+			cpuHigh = pct >= w.cfg.CPUPercent
+		}
+	}
+	memHigh := w.cfg.MemoryBytes > 0 && s.memory >= w.cfg.MemoryBytes
+
+	update := func(since *time.Time, high bool) bool {
+		if !high {
+			*since = time.Time{}
+			return false
+		}
+		if since.IsZero() {
+			*since = now
+		}
+		return now.Sub(*since) >= w.cfg.Sustain
+	}
+	cpuDue := update(&w.cpuSince, cpuHigh)
+	memDue := update(&w.memSince, memHigh)
+
+	if !w.lastCapture.IsZero() && now.Sub(w.lastCapture) < w.cfg.Cooldown {
+		return ""
+	}
+	if !w.lastCapture.IsZero() && !cpuHigh && !memHigh {
+		w.ht.SetHealthy(highUsageWarnable)
+	}
+	switch {
+	case cpuDue:
+		reason = "CPU"
+	case memDue:
+		reason = "memory"
+	default:
+		return ""
+	}
+	w.lastCapture = now
+	return reason
+}
+
+// capture writes a diagnostics bundle to a new subdirectory of the
+// configured directory and returns its path.
+func (w *Watcher) capture(ctx context.Context, reason string) (dir string, err error) {
+	if w.cfg.Dir == "" {
+		return "", errors.New("no diagnostics directory configured")
+	}
+	dir = filepath.Join(w.cfg.Dir, "resource-"+w.now().UTC().Format("20060102T150405Z"))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	write := func(name string, b []byte) {
+		if werr := os.WriteFile(filepath.Join(dir, name), b, 0600); werr != nil && err == nil {
+			err = werr
+		}
+	}
+
+	w.mu.Lock()
+	last := w.last
+	w.mu.Unlock()
+	var summary bytes.Buffer
+	fmt.Fprintf(&summary, "reason: sustained high %s\n", reason)
+	fmt.Fprintf(&summary, "time: %s\n", w.now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(&summary, "go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&summary, "goroutines: %d\n", runtime.NumGoroutine())
+	fmt.Fprintf(&summary, "memory: %d bytes (threshold %d)\n", last.memory, w.cfg.MemoryBytes)
+	fmt.Fprintf(&summary, "cpu time: %v (threshold %.0f%% over %v)\n", last.cpuTime, w.cfg.CPUPercent, w.cfg.Sustain)
+	write("summary.txt", summary.Bytes())
+
+	if reason == "CPU" && w.cfg.CPUProfileDuration > 0 {
+		var buf bytes.Buffer
+		if perr := pprof.StartCPUProfile(&buf); perr != nil {
+			// Most likely somebody is already profiling via the debug
+			// handlers; note it rather than failing the whole bundle.
+			write("cpu.pprof.err", []byte(perr.Error()+"\n"))
+		} else {
+			select {
+			case <-ctx.Done():
+			case <-time.After(w.cfg.CPUProfileDuration):
+			}
+			pprof.StopCPUProfile()
+			write("cpu.pprof", buf.Bytes())
+		}
+	}
+
+	var buf bytes.Buffer
+	if perr := pprof.Lookup("heap").WriteTo(&buf, 0); perr == nil {
+		write("heap.pprof", buf.Bytes())
+	}
+	buf.Reset()
+	if perr := pprof.Lookup("goroutine").WriteTo(&buf, 2); perr == nil {
+		write("goroutines.txt", buf.Bytes())
+	}
+	write("logs.txt", []byte(strings.Join(w.logs.GetAll(), "\n")+"\n"))
+
+	w.mu.Lock()
+	w.lastBundle = dir
+	w.mu.Unlock()
+	w.pruneBundles()
+	return dir, err
+}
+
+// pruneBundles removes the oldest bundles in the configured directory so
+// that at most MaxBundles remain.
+func (w *Watcher) pruneBundles() {
+	if w.cfg.MaxBundles <= 0 {
+		return
+	}
+	ents, err := os.ReadDir(w.cfg.Dir)
+	if err != nil {
+		return
+	}
+	var names []string
+	for _, de := range ents {
+		if de.IsDir() && strings.HasPrefix(de.Name(), "resource-") {
+			names = append(names, de.Name())
+		}
+	}
+	slices.Sort(names) // names sort by capture time
+	for len(names) > w.cfg.MaxBundles {
+		if err := os.RemoveAll(filepath.Join(w.cfg.Dir, names[0])); err != nil {
+			w.logf("removing old bundle: %v", err)
+		}
+		names = names[1:]
+	}
+}
+
+const runtimeMemoryMetric = "/memory/classes/total:bytes"
+
+func takeSample() sample {
+	ms := []metrics.Sample{{Name: runtimeMemoryMetric}}
+	metrics.Read(ms)
+	s := sample{
+		when:    time.Now(),
+		cpuTime: processCPUTime(),
+	}
+	if ms[0].Value.Kind() == metrics.KindUint64 {
+		s.memory = ms[0].Value.Uint64()
+	}
+	return s
+}
+
+const (
+	argResource   health.Arg = "resource"
+	argBundlePath health.Arg = "bundle-path"
+)
+
+// highUsageWarnable is set when a diagnostics bundle has been captured due
+// to sustained high resource usage.
+var highUsageWarnable = health.Register(&health.Warnable{
+	Code:     "sustained-high-resource-usage",
+	Title:    "High resource usage",
+	Severity: health.SeverityMedium,
+	Text: func(args health.Args) string {
+		return fmt.Sprintf("Tailscale used a high amount of %s for a sustained period. Diagnostics were saved to %s; please include them when reporting this issue.", args[argResource], args[argBundlePath])
+	},
+})
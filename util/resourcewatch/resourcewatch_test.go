@@ -0,0 +1,116 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resourcewatch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/health"
+)
+
+func TestCheck(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	now := start
+	ht := new(health.Tracker)
+	w := New(t.Logf, ht, Config{
+		CPUPercent:  50,
+		MemoryBytes: 1000,
+		Sustain:     time.Minute,
+		Interval:    10 * time.Second,
+		Cooldown:    time.Hour,
+	})
+	w.now = func() time.Time { return now }
+
+	var cpu time.Duration = time.Second
+	step := func(cpuDelta time.Duration, mem uint64) string {
+		now = now.Add(10 * time.Second)
+		cpu += cpuDelta
+		return w.check(sample{when: now, cpuTime: cpu, memory: mem})
+	}
+	w.last = sample{when: now, cpuTime: cpu}
+
+	// Low usage never triggers.
+	for range 20 {
+		if got := step(time.Second, 10); got != "" {
+			t.Fatalf("low usage triggered %q", got)
+		}
+	}
+
+	// High CPU triggers only once sustained.
+	var got string
+	var n int
+	for n = 0; n < 20 && got == ""; n++ {
+		got = step(9*time.Second, 10)
+	}
+	if got != "CPU" {
+		t.Fatalf("got %q; want CPU", got)
+	}
+	if n < 6 {
+		t.Errorf("triggered after %d samples; want at least 6", n)
+	}
+
+	// Cooldown suppresses further captures, even for memory.
+	for range 20 {
+		if got := step(9*time.Second, 5000); got != "" {
+			t.Fatalf("triggered %q during cooldown", got)
+		}
+	}
+
+	// A dip below the threshold resets the sustain timer.
+	now = now.Add(time.Hour)
+	step(0, 10)
+	for range 6 {
+		if got := step(0, 5000); got != "" {
+			t.Fatalf("triggered %q before sustain period", got)
+		}
+	}
+	if got := step(0, 5000); got != "memory" {
+		t.Fatalf("got %q; want memory", got)
+	}
+}
+
+func TestCapture(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Unix(1700000000, 0)
+	w := New(t.Logf, new(health.Tracker), Config{
+		Dir:        dir,
+		MaxBundles: 2,
+	})
+	w.now = func() time.Time { return now }
+	logf := w.WrapLogf(t.Logf)
+	logf("hello %d", 42)
+
+	var bundles []string
+	for range 3 {
+		b, err := w.capture(context.Background(), "memory")
+		if err != nil {
+			t.Fatal(err)
+		}
+		bundles = append(bundles, b)
+		now = now.Add(time.Minute)
+	}
+	if got := w.LastBundle(); got != bundles[2] {
+		t.Errorf("LastBundle = %q; want %q", got, bundles[2])
+	}
+	if _, err := os.Stat(bundles[0]); !os.IsNotExist(err) {
+		t.Errorf("oldest bundle not pruned: %v", err)
+	}
+	for _, name := range []string{"summary.txt", "heap.pprof", "goroutines.txt", "logs.txt"} {
+		if _, err := os.Stat(filepath.Join(bundles[2], name)); err != nil {
+			t.Errorf("missing %s: %v", name, err)
+		}
+	}
+	logs, err := os.ReadFile(filepath.Join(bundles[2], "logs.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(logs), "hello 42") {
+		t.Errorf("logs.txt = %q; want it to contain recent log line", logs)
+	}
+}
@@ -45,6 +45,9 @@
 	// ExitNodeID is the exit node's node id. default ""; if blank, no exit node is forced.
 	// Exit node ID takes precedence over exit node IP.
 	// To find the node ID, go to /api.md#device.
+	// A value of the form "auto:<expression>", such as "auto:any" or
+	// "auto:online,tag:exit", chooses the exit node automatically from those
+	// matching the expression; see ipn.ExitNodeExpression.
 	ExitNodeID Key = "ExitNodeID"
 	ExitNodeIP Key = "ExitNodeIP" // default ""; if blank, no exit node is forced. Value is exit node IP.
 
@@ -111,6 +114,11 @@
 	// Key is a string value that specifies an option: "always", "never", "user-decides".
 	// The default is "user-decides" unless otherwise stated.
 	PostureChecking Key = "PostureChecking"
+	// RemoteDiagnostics controls whether the control plane may collect
+	// diagnostics from the device for remote debugging.
+	// Key is a string value that specifies an option: "off", "prompt" (ask the
+	// local user each time), "allow". If unset, the device's preference applies.
+	RemoteDiagnostics Key = "RemoteDiagnostics"
 	// DeviceSerialNumber is the serial number of the device that is running Tailscale.
 	// This is used on iOS/tvOS to allow IT administrators to manually give us a serial number via MDM.
 	// We are unable to programmatically get the serial number from IOKit due to sandboxing restrictions.
@@ -144,9 +152,19 @@
 	// would otherwise obtain from the OS, e.g. by calling os.Hostname().
 	Hostname Key = "Hostname"
 
+	// SubnetRouterFailover is a boolean key that, when true, makes the client
+	// move subnet routes from their primary router to another router approved
+	// for the same routes as soon as the primary stops answering, rather than
+	// waiting for the control plane to notice. It is read when tailscaled starts.
+	SubnetRouterFailover Key = "SubnetRouterFailover"
+
 	// Keys with a string array value.
 	// AllowedSuggestedExitNodes's string array value is a list of exit node IDs that restricts which exit nodes are considered when generating suggestions for exit nodes.
 	AllowedSuggestedExitNodes Key = "AllowedSuggestedExitNodes"
+	// SubnetRouterFailoverPreference's string array value lists the names, stable node IDs
+	// or Tailscale IPs of subnet routers, most preferred first, giving the order in which
+	// standby routers are failed over to when [SubnetRouterFailover] is enabled.
+	SubnetRouterFailoverPreference Key = "SubnetRouterFailoverPreference"
 )
 
 // implicitDefinitions is a list of [setting.Definition] that will be registered
@@ -176,6 +194,9 @@
 	setting.NewDefinition(LogTarget, setting.DeviceSetting, setting.StringValue),
 	setting.NewDefinition(MachineCertificateSubject, setting.DeviceSetting, setting.StringValue),
 	setting.NewDefinition(PostureChecking, setting.DeviceSetting, setting.PreferenceOptionValue),
+	setting.NewDefinition(RemoteDiagnostics, setting.DeviceSetting, setting.StringValue),
+	setting.NewDefinition(SubnetRouterFailover, setting.DeviceSetting, setting.BooleanValue),
+	setting.NewDefinition(SubnetRouterFailoverPreference, setting.DeviceSetting, setting.StringListValue),
 	setting.NewDefinition(Tailnet, setting.DeviceSetting, setting.StringValue),
 
 	// User policy settings (can be configured on a user- or device-basis):
@@ -76,19 +76,52 @@ func (g *Gauge) String() string {
 // WritePrometheus writes the gauge metric in Prometheus format to the given writer.
 // This satisfies the varz.PrometheusWriter interface.
 func (g *Gauge) WritePrometheus(w io.Writer, name string) {
+	writeGauge(w, name, g.help, g.m.Value())
+}
+
+// GaugeFunc is a gauge metric with no labels whose value is computed
+// each time the metrics are read, for values that are cheaper to look
+// up than to keep updated.
+type GaugeFunc struct {
+	f    func() float64
+	help string
+}
+
+// NewGaugeFunc creates and registers a new gauge metric with the given name
+// and help text, whose value is the result of f. f must be safe to call
+// concurrently.
+func (r *Registry) NewGaugeFunc(name, help string, f func() float64) *GaugeFunc {
+	g := &GaugeFunc{f, help}
+	r.vars.Set(name, g)
+	return g
+}
+
+// String returns the current value of the gauge.
+// This satisfies the expvar.Var interface.
+func (g *GaugeFunc) String() string {
+	return fmt.Sprint(g.f())
+}
+
+// WritePrometheus writes the gauge metric in Prometheus format to the given writer.
+// This satisfies the varz.PrometheusWriter interface.
+func (g *GaugeFunc) WritePrometheus(w io.Writer, name string) {
+	writeGauge(w, name, g.help, g.f())
+}
+
+func writeGauge(w io.Writer, name, help string, v float64) {
 	io.WriteString(w, "# TYPE ")
 	io.WriteString(w, name)
 	io.WriteString(w, " gauge\n")
-	if g.help != "" {
+	if help != "" {
 		io.WriteString(w, "# HELP ")
 		io.WriteString(w, name)
 		io.WriteString(w, " ")
-		io.WriteString(w, g.help)
+		io.WriteString(w, help)
 		io.WriteString(w, "\n")
 	}
 
 	io.WriteString(w, name)
-	fmt.Fprintf(w, " %v\n", g.m.Value())
+	fmt.Fprintf(w, " %v\n", v)
 }
 
 // Handler returns a varz.Handler that serves the userfacing expvar contained
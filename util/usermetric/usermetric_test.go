@@ -24,3 +24,22 @@ func TestGauge(t *testing.T) {
 	}
 
 }
+
+func TestGaugeFunc(t *testing.T) {
+	var reg Registry
+	v := 1.0
+	g := reg.NewGaugeFunc("test_gauge_func", "", func() float64 { return v })
+	v = 2
+
+	var buf bytes.Buffer
+	g.WritePrometheus(&buf, "test_gauge_func")
+	const want = `# TYPE test_gauge_func gauge
+test_gauge_func 2
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if got := g.String(); got != "2" {
+		t.Errorf("String = %q; want 2", got)
+	}
+}
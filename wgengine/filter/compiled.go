@@ -0,0 +1,167 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package filter
+
+import (
+	"net/netip"
+
+	"github.com/gaissmai/bart"
+	"tailscale.com/net/ipset"
+	"tailscale.com/net/packet"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/ipproto"
+	"tailscale.com/types/views"
+	"tailscale.com/util/set"
+	"tailscale.com/wgengine/filter/filtertype"
+)
+
+// compiledMatches is a matches list compiled into a form that is cheap
+// to evaluate per packet, even for very large rule sets.
+//
+// Rules are indexed by destination prefix in a routing table, so that a
+// lookup only visits the rules whose destinations cover the packet's
+// destination address, rather than every rule in the filter. Rules that
+// share a destination prefix, protocol set and port range are merged
+// into a single entry whose sources are the union of theirs, so a
+// policy granting many sources access to the same service costs one
+// source lookup rather than one per grant. Each entry carries a
+// protocol bitmap and its port range, so that source check only runs
+// for entries that already match the packet's protocol and port.
+//
+// All rules are accept rules, so the order in which they're consulted
+// doesn't change the verdict. The index is free to regroup them.
+//
+// A compiledMatches is immutable once built.
+type compiledMatches struct {
+	// dsts maps each destination prefix to the merged rules that
+	// accept traffic to it.
+	dsts *bart.Table[[]dstRule]
+
+	// srcCaps is the union of SrcCaps over all rules.
+	// See matches.matchIPsOnly for why these are consulted
+	// without regard to destination.
+	srcCaps []tailcfg.NodeCapability
+}
+
+// dstRule is a merged rule for one destination prefix in
+// compiledMatches.
+type dstRule struct {
+	protos protoSet
+	ports  filtertype.PortRange
+	m      *filtertype.Match // merged Srcs, SrcCaps and SrcsContains
+}
+
+// protoSet is a bitmap of IP protocol numbers.
+type protoSet [4]uint64
+
+func (s *protoSet) add(p ipproto.Proto) { s[p/64] |= 1 << (p % 64) }
+
+func (s *protoSet) contains(p ipproto.Proto) bool { return s[p/64]&(1<<(p%64)) != 0 }
+
+// dstRuleKey is the set of properties that rules must have in common
+// to be merged into one dstRule.
+type dstRuleKey struct {
+	dst    netip.Prefix
+	protos protoSet
+	ports  filtertype.PortRange
+}
+
+// compileMatches returns an index over ms, which must all be of the same
+// address family.
+func compileMatches(ms matches) *compiledMatches {
+	c := &compiledMatches{
+		dsts: new(bart.Table[[]dstRule]),
+	}
+	var (
+		caps   set.Set[tailcfg.NodeCapability]
+		keys   []dstRuleKey // in first-seen order, for determinism
+		merged = map[dstRuleKey]*filtertype.Match{}
+	)
+	for _, m := range ms {
+		var protos protoSet
+		for _, p := range m.IPProto.All() {
+			protos.add(p)
+		}
+		for _, dst := range m.Dsts {
+			k := dstRuleKey{dst.Net.Masked(), protos, dst.Ports}
+			mm, ok := merged[k]
+			if !ok {
+				mm = &filtertype.Match{IPProto: m.IPProto}
+				merged[k] = mm
+				keys = append(keys, k)
+			}
+			mm.Srcs = append(mm.Srcs, m.Srcs...)
+			mm.SrcCaps = append(mm.SrcCaps, m.SrcCaps...)
+		}
+		for _, cp := range m.SrcCaps {
+			if !caps.Contains(cp) {
+				caps.Make()
+				caps.Add(cp)
+				c.srcCaps = append(c.srcCaps, cp)
+			}
+		}
+	}
+	for _, k := range keys {
+		mm := merged[k]
+		mm.SrcCaps = set.SetOf(mm.SrcCaps).Slice()
+		mm.SrcsContains = ipset.NewContainsIPFunc(views.SliceOf(mm.Srcs))
+		r := dstRule{protos: k.protos, ports: k.ports, m: mm}
+		c.dsts.Update(k.dst, func(rs []dstRule, _ bool) []dstRule {
+			return append(rs, r)
+		})
+	}
+	return c
+}
+
+// eachDstRule calls f for each rule with a destination covering dst,
+// until f returns true. It reports whether f returned true.
+func (c *compiledMatches) eachDstRule(dst netip.Addr, f func(*dstRule) bool) (found bool) {
+	c.dsts.EachLookupPrefix(netip.PrefixFrom(dst, dst.BitLen()), func(_ netip.Prefix, rs []dstRule) bool {
+		for i := range rs {
+			if f(&rs[i]) {
+				found = true
+				return false
+			}
+		}
+		return true
+	})
+	return found
+}
+
+// match is the compiled equivalent of matches.match.
+func (c *compiledMatches) match(q *packet.Parsed, hasCap CapTestFunc) bool {
+	return c.eachDstRule(q.Dst.Addr(), func(r *dstRule) bool {
+		return r.protos.contains(q.IPProto) &&
+			r.ports.Contains(q.Dst.Port()) &&
+			srcMatches(r.m, q.Src.Addr(), hasCap)
+	})
+}
+
+// matchIPsOnly is the compiled equivalent of matches.matchIPsOnly.
+func (c *compiledMatches) matchIPsOnly(q *packet.Parsed, hasCap CapTestFunc) bool {
+	srcAddr := q.Src.Addr()
+	if c.eachDstRule(q.Dst.Addr(), func(r *dstRule) bool {
+		return r.m.SrcsContains(srcAddr)
+	}) {
+		return true
+	}
+	if hasCap != nil {
+		for _, cp := range c.srcCaps {
+			if hasCap(srcAddr, cp) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchProtoAndIPsOnlyIfAllPorts is the compiled equivalent of
+// matches.matchProtoAndIPsOnlyIfAllPorts.
+func (c *compiledMatches) matchProtoAndIPsOnlyIfAllPorts(q *packet.Parsed) bool {
+	return c.eachDstRule(q.Dst.Addr(), func(r *dstRule) bool {
+		return r.ports == filtertype.AllPorts &&
+			r.protos.contains(q.IPProto) &&
+			r.m.SrcsContains(q.Src.Addr())
+	})
+}
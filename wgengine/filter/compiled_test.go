@@ -0,0 +1,194 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/netip"
+	"os"
+	"testing"
+
+	"tailscale.com/net/packet"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/ipproto"
+	"tailscale.com/types/views"
+	"tailscale.com/wgengine/filter/filtertype"
+)
+
+// syntheticMatches returns n IPv4 rules shaped like a large tailnet
+// policy: mostly host-to-host grants on assorted ports, plus a few broad
+// and capability-based rules.
+func syntheticMatches(n int) matches {
+	host := func(i int) netip.Prefix {
+		return netip.PrefixFrom(netip.AddrFrom4([4]byte{100, 64 + byte(i>>16)&0x3f, byte(i >> 8), byte(i)}), 32)
+	}
+	var ms []Match
+	for i := range n {
+		var m Match
+		switch {
+		case i%97 == 0:
+			m = Match{
+				IPProto: views.SliceOf([]ipproto.Proto{ipproto.TCP}),
+				SrcCaps: []tailcfg.NodeCapability{tailcfg.NodeCapability(fmt.Sprintf("cap-%d", i%3))},
+				Dsts:    []NetPortRange{{Net: netip.MustParsePrefix("100.64.0.0/10"), Ports: PortRange{First: 22, Last: 22}}},
+			}
+		case i%31 == 0:
+			m = Match{
+				IPProto: views.SliceOf([]ipproto.Proto{ipproto.TCP, ipproto.UDP, ipproto.ICMPv4, testAllowedProto}),
+				Srcs:    []netip.Prefix{host(i)},
+				Dsts:    []NetPortRange{{Net: netip.MustParsePrefix("0.0.0.0/0"), Ports: filtertype.AllPorts}},
+			}
+		default:
+			m = Match{
+				IPProto: views.SliceOf([]ipproto.Proto{ipproto.TCP, ipproto.UDP}),
+				Srcs:    []netip.Prefix{host(i), host(i + 1)},
+				Dsts: []NetPortRange{
+					{Net: host(i + 2), Ports: PortRange{First: uint16(1000 + i%50), Last: uint16(1000 + i%50 + i%3)}},
+					{Net: netip.PrefixFrom(host(i).Addr(), 24), Ports: PortRange{First: 443, Last: 443}},
+				},
+			}
+		}
+		ms = append(ms, m)
+	}
+	return matchesFamily(ms, netip.Addr.Is4)
+}
+
+func TestCompiledMatchesEquivalent(t *testing.T) {
+	hasCap := func(src netip.Addr, c tailcfg.NodeCapability) bool {
+		return c == "cap-1" && src.As4()[3]%2 == 0
+	}
+
+	var fromFile []Match
+	bts, err := os.ReadFile("testdata/matches-1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(bts, &fromFile); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		ms   matches
+	}{
+		{"empty", nil},
+		{"synthetic", syntheticMatches(500)},
+		{"matches-1", matchesFamily(fromFile, netip.Addr.Is4)},
+	}
+	protos := []ipproto.Proto{ipproto.TCP, ipproto.UDP, ipproto.ICMPv4, testAllowedProto}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := compileMatches(tt.ms)
+			rnd := rand.New(rand.NewPCG(1, 2))
+
+			// Draw addresses mostly from the rules themselves, so
+			// that a good share of packets are accepted.
+			var addrs []netip.Addr
+			for _, m := range tt.ms {
+				for _, src := range m.Srcs {
+					addrs = append(addrs, src.Addr())
+				}
+				for _, dst := range m.Dsts {
+					addrs = append(addrs, dst.Net.Addr())
+				}
+			}
+			randAddr := func() string {
+				if len(addrs) > 0 && rnd.IntN(4) != 0 {
+					return addrs[rnd.IntN(len(addrs))].String()
+				}
+				return netip.AddrFrom4([4]byte{100, 64 + byte(rnd.IntN(2)), byte(rnd.IntN(3)), byte(rnd.IntN(256))}).String()
+			}
+			var accepted int
+			for range 20000 {
+				q := parsed(protos[rnd.IntN(len(protos))], randAddr(), randAddr(), 1234, uint16(1000+rnd.IntN(60)))
+				if rnd.IntN(4) == 0 {
+					q.Dst = netip.AddrPortFrom(q.Dst.Addr(), []uint16{22, 80, 443, 5432}[rnd.IntN(4)])
+				}
+				for _, hc := range []CapTestFunc{nil, hasCap} {
+					want := tt.ms.match(&q, hc)
+					if got := c.match(&q, hc); got != want {
+						t.Fatalf("match(%v, hasCap=%v) = %v; want %v", q.String(), hc != nil, got, want)
+					}
+					if want {
+						accepted++
+					}
+					if got, want := c.matchIPsOnly(&q, hc), tt.ms.matchIPsOnly(&q, hc); got != want {
+						t.Fatalf("matchIPsOnly(%v, hasCap=%v) = %v; want %v", q.String(), hc != nil, got, want)
+					}
+				}
+				if got, want := c.matchProtoAndIPsOnlyIfAllPorts(&q), tt.ms.matchProtoAndIPsOnlyIfAllPorts(&q); got != want {
+					t.Fatalf("matchProtoAndIPsOnlyIfAllPorts(%v) = %v; want %v", q.String(), got, want)
+				}
+			}
+			if len(tt.ms) > 0 && accepted == 0 {
+				t.Errorf("no packets accepted; test isn't exercising the index")
+			}
+		})
+	}
+}
+
+func TestCompiledMatchesNoAllocs(t *testing.T) {
+	c := compileMatches(syntheticMatches(1000))
+	q := parsed(ipproto.TCP, "100.64.3.200", "100.64.3.202", 1234, 1000)
+	got := testing.AllocsPerRun(1000, func() {
+		c.match(&q, nil)
+		c.matchIPsOnly(&q, nil)
+		c.matchProtoAndIPsOnlyIfAllPorts(&q)
+	})
+	if got != 0 {
+		t.Errorf("got %v allocs; want 0", got)
+	}
+}
+
+// BenchmarkMatches compares the per-packet cost of evaluating the
+// linear rule list against the compiled index as the rule set grows.
+func BenchmarkMatches(b *testing.B) {
+	for _, n := range []int{10, 100, 1000, 10000} {
+		ms := syntheticMatches(n)
+		c := compileMatches(ms)
+		// A packet from the second-to-last rule's source to its
+		// destination, which the linear matcher only finds at the end.
+		i := n - 2
+		src := netip.AddrFrom4([4]byte{100, 64 + byte(i>>16)&0x3f, byte(i >> 8), byte(i)})
+		dst := netip.AddrFrom4([4]byte{100, 64 + byte((i+2)>>16)&0x3f, byte((i + 2) >> 8), byte(i + 2)})
+		hit := parsed(ipproto.TCP, src.String(), dst.String(), 1234, uint16(1000+i%50))
+		miss := parsed(ipproto.TCP, src.String(), dst.String(), 1234, 9)
+
+		for _, tc := range []struct {
+			name string
+			q    packet.Parsed
+			want bool
+		}{
+			{"hit", hit, true},
+			{"miss", miss, false},
+		} {
+			b.Run(fmt.Sprintf("rules-%d/%s/linear", n, tc.name), func(b *testing.B) {
+				b.ReportAllocs()
+				for range b.N {
+					if ms.match(&tc.q, nil) != tc.want {
+						b.Fatal("unexpected result")
+					}
+				}
+			})
+			b.Run(fmt.Sprintf("rules-%d/%s/compiled", n, tc.name), func(b *testing.B) {
+				b.ReportAllocs()
+				for range b.N {
+					if c.match(&tc.q, nil) != tc.want {
+						b.Fatal("unexpected result")
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkCompileMatches(b *testing.B) {
+	ms := syntheticMatches(10000)
+	b.ReportAllocs()
+	for range b.N {
+		compileMatches(ms)
+	}
+}
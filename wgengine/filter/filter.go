@@ -9,6 +9,7 @@
 	"net/netip"
 	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go4.org/netipx"
@@ -47,13 +48,16 @@ type Filter struct {
 	// whether a given source IP address has a given capability.
 	srcIPHasCap CapTestFunc
 
-	// matches4 and matches6 are lists of match->action rules
+	// matches4 and matches6 are the compiled match->action rules
 	// applied to all packets arriving over tailscale
-	// tunnels. Matches are checked in order, and processing stops
-	// at the first matching rule. The default policy if no rules
-	// match is to drop the packet.
-	matches4 matches
-	matches6 matches
+	// tunnels. Processing stops at the first matching rule. The
+	// default policy if no rules match is to drop the packet.
+	matches4 *compiledMatches
+	matches6 *compiledMatches
+
+	// rules are the matches that New was given, in order. They're only
+	// consulted to attribute accepted packets to rules for stats.
+	rules []Match
 
 	// cap4 and cap6 are the subsets of the matches that are about
 	// capability grants, partitioned by source IP address family.
@@ -65,13 +69,18 @@ type Filter struct {
 	// incoming packets don't get accepted by matches above.
 	state *filterState
 
+	// stats, if non-nil, are the per-rule counters enabled by
+	// EnableStats.
+	stats atomic.Pointer[ruleStats]
+
 	shieldsUp bool
 }
 
 // filterState is a state cache of past seen packets.
 type filterState struct {
-	mu  sync.Mutex
-	lru *flowtrack.Cache[struct{}] // from flowtrack.Tuple -> struct{}
+	mu    sync.Mutex
+	lru   *flowtrack.Cache[struct{}] // from flowtrack.Tuple -> struct{}
+	drops *dropLog                   // or nil if no filter has enabled stats
 }
 
 // lruMax is the size of the LRU cache in filterState.
@@ -204,8 +213,9 @@ func New(matches []Match, capTest CapTestFunc, localNets, logIPs *netipx.IPSet,
 
 	f := &Filter{
 		logf:        logf,
-		matches4:    matchesFamily(matches, netip.Addr.Is4),
-		matches6:    matchesFamily(matches, netip.Addr.Is6),
+		matches4:    compileMatches(matchesFamily(matches, netip.Addr.Is4)),
+		matches6:    compileMatches(matchesFamily(matches, netip.Addr.Is6)),
+		rules:       matches,
 		cap4:        capMatchesFunc(matches, netip.Addr.Is4),
 		cap6:        capMatchesFunc(matches, netip.Addr.Is6),
 		local4:      ipset.FalseContainsIPFunc(),
@@ -344,13 +354,45 @@ func (f *Filter) logRateLimit(runflags RunFlags, q *packet.Parsed, dir direction
 // Check determines whether traffic from srcIP to dstIP:dstPort is allowed
 // using protocol proto.
 func (f *Filter) Check(srcIP, dstIP netip.Addr, dstPort uint16, proto ipproto.Proto) Response {
+	pkt := checkPacket(srcIP, dstIP, dstPort, proto)
+	if pkt == nil {
+		// Mismatched address families, no filters will
+		// match.
+		return Drop
+	}
+	r, _ := f.runIn(pkt, 0)
+	return r
+}
+
+// Explain is like Check, but also returns a short description of why the
+// filter reached its verdict, such as "tcp ok" or "no rules matched", as
+// logged for packets. It doesn't log.
+func (f *Filter) Explain(srcIP, dstIP netip.Addr, dstPort uint16, proto ipproto.Proto) (_ Response, why string) {
+	pkt := checkPacket(srcIP, dstIP, dstPort, proto)
+	if pkt == nil {
+		return Drop, "mismatched address families"
+	}
+	if r, reason := f.pre(pkt, 0, in); r == Accept || r == Drop {
+		if reason == "" {
+			return r, "pre-filter"
+		}
+		return r, string(reason)
+	}
+	if pkt.IPVersion == 4 {
+		return f.runIn4(pkt)
+	}
+	return f.runIn6(pkt)
+}
+
+// checkPacket returns a packet from srcIP to dstIP:dstPort using protocol
+// proto, for evaluating with the filter as the first packet of a flow, or
+// nil if the addresses are of different families.
+func checkPacket(srcIP, dstIP netip.Addr, dstPort uint16, proto ipproto.Proto) *packet.Parsed {
 	pkt := &packet.Parsed{}
 	pkt.Decode(dummyPacket) // initialize private fields
 	switch {
 	case (srcIP.Is4() && dstIP.Is6()) || (srcIP.Is6() && srcIP.Is4()):
-		// Mismatched address families, no filters will
-		// match.
-		return Drop
+		return nil
 	case srcIP.Is4():
 		pkt.IPVersion = 4
 	case srcIP.Is6():
@@ -364,8 +406,7 @@ func (f *Filter) Check(srcIP, dstIP netip.Addr, dstPort uint16, proto ipproto.Pr
 	if proto == ipproto.TCP {
 		pkt.TCPFlags = packet.TCPSyn
 	}
-
-	return f.RunIn(pkt, 0)
+	return pkt
 }
 
 // CheckTCP determines whether TCP traffic from srcIP to dstIP:dstPort
@@ -410,14 +451,21 @@ func (f *Filter) ShieldsUp() bool { return f.shieldsUp }
 // RunIn determines whether this node is allowed to receive q from a
 // Tailscale peer.
 func (f *Filter) RunIn(q *packet.Parsed, rf RunFlags) Response {
+	r, why := f.runIn(q, rf)
+	f.recordStats(q, r, why)
+	return r
+}
+
+// runIn is RunIn without updating stats, so that Check doesn't count its
+// synthesized packets. It also returns why it reached its verdict.
+func (f *Filter) runIn(q *packet.Parsed, rf RunFlags) (r Response, why string) {
 	dir := in
-	r, _ := f.pre(q, rf, dir)
+	r, reason := f.pre(q, rf, dir)
 	if r == Accept || r == Drop {
 		// already logged
-		return r
+		return r, string(reason)
 	}
 
-	var why string
 	switch q.IPVersion {
 	case 4:
 		r, why = f.runIn4(q)
@@ -427,7 +475,7 @@ func (f *Filter) RunIn(q *packet.Parsed, rf RunFlags) Response {
 		r, why = Drop, "not-ip"
 	}
 	f.logRateLimit(rf, q, dir, r, why)
-	return r
+	return r, why
 }
 
 // RunOut determines whether this node is allowed to send q to a
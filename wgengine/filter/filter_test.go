@@ -8,6 +8,7 @@
 	"encoding/json"
 	"flag"
 	"fmt"
+	"maps"
 	"net/netip"
 	"os"
 	"slices"
@@ -928,6 +929,125 @@ func TestMatchesMatchProtoAndIPsOnlyIfAllPorts(t *testing.T) {
 	}
 }
 
+func TestMatchIndex(t *testing.T) {
+	ms := []Match{
+		m(nets("8.1.1.1"), netports("1.2.3.4:22")),
+		m(nets("8.1.1.1"), netports("1.2.3.4:20-30"), ipproto.UDP),
+		m(nets("0.0.0.0/0"), netports("1.2.3.4:*"), testAllowedProto),
+		m(nil, netports("5.6.7.8:80"), tailcfg.NodeCapability("cap-web")),
+	}
+	hasCap := func(ip netip.Addr, cap tailcfg.NodeCapability) bool {
+		return ip == mustIP("10.0.0.1") && cap == "cap-web"
+	}
+	tests := []struct {
+		proto ipproto.Proto
+		src   string
+		dst   string
+		port  uint16
+		want  int
+	}{
+		{ipproto.TCP, "8.1.1.1", "1.2.3.4", 22, 0},
+		{ipproto.TCP, "8.1.1.1", "1.2.3.4", 23, -1},
+		{ipproto.UDP, "8.1.1.1", "1.2.3.4", 22, 0},
+		{ipproto.UDP, "8.1.1.1", "1.2.3.4", 23, 1},
+		{ipproto.SCTP, "8.1.1.1", "1.2.3.4", 22, -1},
+		{ipproto.ICMPv4, "8.1.1.1", "1.2.3.4", 0, 0},
+		{ipproto.ICMPv4, "8.1.1.2", "1.2.3.4", 0, 2},
+		{ipproto.ICMPv4, "10.0.0.1", "5.6.7.8", 0, 3},
+		{ipproto.ICMPv4, "10.0.0.2", "5.6.7.8", 0, -1},
+		{testAllowedProto, "8.1.1.2", "1.2.3.4", 0, 2},
+		{testDeniedProto, "8.1.1.2", "1.2.3.4", 0, -1},
+		{ipproto.TCP, "10.0.0.1", "5.6.7.8", 80, 3},
+		{ipproto.TCP, "10.0.0.2", "5.6.7.8", 80, -1},
+	}
+	for _, tt := range tests {
+		got := MatchIndex(ms, mustIP(tt.src), mustIP(tt.dst), tt.port, tt.proto, hasCap)
+		if got != tt.want {
+			t.Errorf("MatchIndex(%v %v => %v:%v) = %v; want %v", tt.proto, tt.src, tt.dst, tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	filt := newFilter(t.Logf)
+	tests := []struct {
+		proto   ipproto.Proto
+		src     string
+		dst     string
+		port    uint16
+		want    Response
+		wantWhy string
+	}{
+		{ipproto.TCP, "8.1.1.1", "1.2.3.4", 22, Accept, "tcp ok"},
+		{ipproto.TCP, "8.1.1.1", "1.2.3.4", 21, Drop, "no rules matched"},
+		{ipproto.UDP, "8.1.1.1", "1.2.3.4", 22, Accept, "ok"},
+		{ipproto.TCP, "8.1.1.1", "16.32.48.64", 443, Drop, "destination not allowed"},
+		{ipproto.TCP, "8.1.1.1", "2001::1", 22, Drop, "mismatched address families"},
+		{ipproto.TCP, "::1", "2001::1", 22, Accept, "tcp ok"},
+		{ipproto.TCP, "8.1.1.1", "224.0.0.1", 22, Drop, string(usermetric.ReasonMulticast)},
+	}
+	for _, tt := range tests {
+		got, why := filt.Explain(mustIP(tt.src), mustIP(tt.dst), tt.port, tt.proto)
+		if got != tt.want || why != tt.wantWhy {
+			t.Errorf("Explain(%v %v => %v:%v) = %v, %q; want %v, %q", tt.proto, tt.src, tt.dst, tt.port, got, why, tt.want, tt.wantWhy)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	filt := newFilter(t.Logf)
+	if _, ok := filt.Stats(); ok {
+		t.Fatal("stats enabled by default")
+	}
+	filt.EnableStats()
+
+	for _, p := range []packet.Parsed{
+		parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 22),
+		parsed(ipproto.TCP, "8.2.2.2", "1.2.3.4", 999, 22),
+		parsed(ipproto.UDP, "8.1.1.1", "5.6.7.8", 999, 27),
+		parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 21),
+		parsed(ipproto.TCP, "8.1.1.1", "16.32.48.64", 999, 443),
+	} {
+		filt.RunIn(&p, 0)
+	}
+	// Check evaluates synthesized packets, which aren't counted.
+	filt.Check(mustIP("8.1.1.1"), mustIP("1.2.3.4"), 22, ipproto.TCP)
+	filt.Check(mustIP("8.1.1.1"), mustIP("1.2.3.4"), 21, ipproto.TCP)
+
+	st, ok := filt.Stats()
+	if !ok {
+		t.Fatal("stats not enabled")
+	}
+	var hits []uint64
+	for _, r := range st.Rules {
+		hits = append(hits, r.Packets)
+	}
+	if want := []uint64{2, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0}; !slices.Equal(hits, want) {
+		t.Errorf("rule hits = %v; want %v", hits, want)
+	}
+	wantDrops := map[string]uint64{"no rules matched": 1, "destination not allowed": 1}
+	if !maps.Equal(st.DropsByReason, wantDrops) {
+		t.Errorf("DropsByReason = %v; want %v", st.DropsByReason, wantDrops)
+	}
+	if len(st.Drops) != 2 || st.Drops[0].Dst != mustIPPort("1.2.3.4:21") || st.Drops[0].Reason != "no rules matched" || st.Drops[0].Proto != ipproto.TCP {
+		t.Errorf("Drops = %+v", st.Drops)
+	}
+
+	// A new filter sharing state keeps the drop log, but not the rule
+	// counters, as its rules may differ.
+	filt2 := New(filt.rules, nil, nil, nil, filt, t.Logf)
+	filt2.EnableStats()
+	st, _ = filt2.Stats()
+	if len(st.Drops) != 2 || st.Rules[0].Packets != 0 {
+		t.Errorf("new filter stats = %+v", st)
+	}
+
+	filt.DisableStats()
+	if _, ok := filt.Stats(); ok {
+		t.Error("stats still enabled")
+	}
+}
+
 func TestPeerCaps(t *testing.T) {
 	mm, err := MatchesFromFilterRules([]tailcfg.FilterRule{
 		{
@@ -5,9 +5,11 @@
 
 import (
 	"net/netip"
+	"slices"
 
 	"tailscale.com/net/packet"
 	"tailscale.com/tailcfg"
+	"tailscale.com/types/ipproto"
 	"tailscale.com/types/views"
 	"tailscale.com/wgengine/filter/filtertype"
 )
@@ -105,3 +107,51 @@ func (ms matches) matchProtoAndIPsOnlyIfAllPorts(q *packet.Parsed) bool {
 	}
 	return false
 }
+
+// MatchIndex returns the index of the first of ms that accepts traffic from
+// srcIP to dstIP:dstPort using protocol proto, or -1 if none does. It follows
+// the filter's rules for each protocol: for ICMP, a match of the addresses
+// suffices, and for protocols other than TCP, UDP and SCTP only matches of
+// all ports count. hasCap, if non-nil, reports whether srcIP has a capability
+// listed in a match's SrcCaps.
+//
+// A Filter doesn't keep the order of its matches, so this is for explaining
+// its verdicts to people, as "tailscale acl check" does.
+func MatchIndex(ms []Match, srcIP, dstIP netip.Addr, dstPort uint16, proto ipproto.Proto, hasCap CapTestFunc) int {
+	for i := range ms {
+		m := &ms[i]
+		switch proto {
+		case ipproto.ICMPv4, ipproto.ICMPv6:
+			// As in matchIPsOnly, any capability in SrcCaps
+			// matches regardless of the destination.
+			if m.SrcsContains(srcIP) && dstsContain(m, dstIP, func(filtertype.PortRange) bool { return true }) {
+				return i
+			}
+			if hasCap != nil && slices.ContainsFunc(m.SrcCaps, func(c tailcfg.NodeCapability) bool { return hasCap(srcIP, c) }) {
+				return i
+			}
+		case ipproto.TCP, ipproto.UDP, ipproto.SCTP:
+			if views.SliceContains(m.IPProto, proto) && srcMatches(m, srcIP, hasCap) &&
+				dstsContain(m, dstIP, func(pr filtertype.PortRange) bool { return pr.Contains(dstPort) }) {
+				return i
+			}
+		default:
+			if views.SliceContains(m.IPProto, proto) && m.SrcsContains(srcIP) &&
+				dstsContain(m, dstIP, func(pr filtertype.PortRange) bool { return pr == filtertype.AllPorts }) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// dstsContain reports whether one of m's destinations contains dstIP with
+// ports for which portsOK returns true.
+func dstsContain(m *filtertype.Match, dstIP netip.Addr, portsOK func(filtertype.PortRange) bool) bool {
+	for _, dst := range m.Dsts {
+		if dst.Net.Contains(dstIP) && portsOK(dst.Ports) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package filter
+
+import (
+	"maps"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tailscale.com/net/packet"
+	"tailscale.com/tstime/rate"
+	"tailscale.com/types/ipproto"
+)
+
+// maxStatsDrops is the number of recent drops kept in a drop log.
+const maxStatsDrops = 256
+
+// Stats is a snapshot of the counters of a Filter with stats enabled.
+// See Filter.EnableStats.
+type Stats struct {
+	// Rules are the filter's rules, in the order passed to New, with
+	// the number of new flows each accepted.
+	Rules []RuleStats
+
+	// DropsByReason counts the incoming packets dropped, by the reason
+	// the filter gave, such as "no rules matched".
+	DropsByReason map[string]uint64
+
+	// Drops are the most recently logged drops, oldest first. Logging
+	// is rate limited and skips packets to or from addresses outside
+	// the filter's logIPs, so not every drop is here.
+	Drops []DropRecord
+
+	// DropsNotLogged is the number of drops counted in DropsByReason
+	// but not in Drops because of the rate limit or logIPs.
+	DropsNotLogged uint64
+}
+
+// RuleStats is the counter for one rule in Stats.
+type RuleStats struct {
+	Match Match
+
+	// Packets is the number of packets accepted by this rule. Only the
+	// first packet of a flow consults the rules: TCP SYNs, UDP and SCTP
+	// packets not matching a flow we started, and ICMP requests. If
+	// several rules accept a packet, only the first one counts it.
+	Packets uint64
+}
+
+// DropRecord is an incoming packet dropped by the filter.
+type DropRecord struct {
+	Time   time.Time
+	Src    netip.AddrPort
+	Dst    netip.AddrPort
+	Proto  ipproto.Proto
+	Reason string
+}
+
+// ruleStats is the per-rule state of a Filter with stats enabled.
+type ruleStats struct {
+	hits []atomic.Uint64 // indexed like Filter.rules
+}
+
+// dropLog records the packets dropped by a filter. It's kept in
+// filterState, so that it carries over to filters that share state with
+// the one that created it.
+type dropLog struct {
+	limiter *rate.Limiter
+
+	mu        sync.Mutex
+	byReason  map[string]uint64
+	recent    []DropRecord // ring buffer, once it has maxStatsDrops entries
+	next      int          // index in recent of the oldest entry, once full
+	notLogged uint64
+}
+
+func newDropLog() *dropLog {
+	return &dropLog{
+		limiter:  rate.NewLimiter(rate.Every(100*time.Millisecond), 20),
+		byReason: make(map[string]uint64),
+	}
+}
+
+// EnableStats makes f count the new flows each of its rules accepts and
+// record the incoming packets it drops, for explaining its verdicts
+// through Stats. Finding which rule accepted a flow is linear in the
+// number of rules, so stats are off by default.
+//
+// Rule counters start at zero with each new filter. The drop log is
+// shared with filters that share state with f.
+func (f *Filter) EnableStats() {
+	if f.stats.Load() != nil {
+		return
+	}
+	f.state.mu.Lock()
+	if f.state.drops == nil {
+		f.state.drops = newDropLog()
+	}
+	f.state.mu.Unlock()
+	f.stats.CompareAndSwap(nil, &ruleStats{hits: make([]atomic.Uint64, len(f.rules))})
+}
+
+// DisableStats stops f from counting packets. Its counters are
+// discarded.
+func (f *Filter) DisableStats() {
+	f.stats.Store(nil)
+}
+
+// Stats returns a snapshot of f's counters. It reports false if stats
+// aren't enabled.
+func (f *Filter) Stats() (_ Stats, ok bool) {
+	rs := f.stats.Load()
+	if rs == nil {
+		return Stats{}, false
+	}
+	var st Stats
+	st.Rules = make([]RuleStats, len(f.rules))
+	for i := range f.rules {
+		st.Rules[i] = RuleStats{
+			Match:   f.rules[i],
+			Packets: rs.hits[i].Load(),
+		}
+	}
+	f.state.mu.Lock()
+	dl := f.state.drops
+	f.state.mu.Unlock()
+
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	st.DropsByReason = maps.Clone(dl.byReason)
+	st.Drops = append(st.Drops, dl.recent[dl.next:]...)
+	st.Drops = append(st.Drops, dl.recent[:dl.next]...)
+	st.DropsNotLogged = dl.notLogged
+	return st, true
+}
+
+// acceptedByRule reports whether why, as returned by runIn4 or runIn6 for
+// an accepted packet, means that one of the rules accepted it, rather
+// than flow state or the protocol.
+func acceptedByRule(why string) bool {
+	switch why {
+	case "tcp ok", "ok", "icmp ok", "other-portless ok":
+		return true
+	}
+	return false
+}
+
+// recordStats updates the stats of f, if enabled, with the verdict r for
+// the incoming packet q.
+func (f *Filter) recordStats(q *packet.Parsed, r Response, why string) {
+	rs := f.stats.Load()
+	if rs == nil {
+		return
+	}
+	switch {
+	case r == Accept && acceptedByRule(why):
+		if i := MatchIndex(f.rules, q.Src.Addr(), q.Dst.Addr(), q.Dst.Port(), q.IPProto, f.srcIPHasCap); i >= 0 {
+			rs.hits[i].Add(1)
+		}
+	case r == Drop:
+		f.state.mu.Lock()
+		dl := f.state.drops
+		f.state.mu.Unlock()
+		dl.record(q, why, f.loggingAllowed(q))
+	}
+}
+
+// record counts a packet dropped for reason why and, if logAddrs and the
+// rate limit allow, logs it.
+func (dl *dropLog) record(q *packet.Parsed, why string, logAddrs bool) {
+	logIt := logAddrs && dl.limiter.Allow()
+
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.byReason[why]++
+	if !logIt {
+		dl.notLogged++
+		return
+	}
+	d := DropRecord{
+		Time:   time.Now(),
+		Src:    q.Src,
+		Dst:    q.Dst,
+		Proto:  q.IPProto,
+		Reason: why,
+	}
+	if len(dl.recent) < maxStatsDrops {
+		dl.recent = append(dl.recent, d)
+		return
+	}
+	dl.recent[dl.next] = d
+	dl.next = (dl.next + 1) % maxStatsDrops
+}
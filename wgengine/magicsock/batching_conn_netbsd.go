@@ -0,0 +1,275 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+	"tailscale.com/types/nettype"
+)
+
+// netbsdBatchingConn is a UDP socket that provides batched i/o using
+// recvmmsg(2) and sendmmsg(2). It implements batchingConn.
+//
+// golang.org/x/net only uses recvmmsg and sendmmsg on Linux, and reads and
+// writes one message per syscall elsewhere, so we make the syscalls
+// ourselves. NetBSD has no UDP segmentation offload, so unlike
+// linuxBatchingConn it never coalesces messages.
+//
+// FreeBSD isn't included: its recvmmsg and sendmmsg are libc wrappers
+// around a recvmsg or sendmsg syscall per message, so they'd save nothing.
+type netbsdBatchingConn struct {
+	pc        *net.UDPConn
+	rc        syscall.RawConn
+	batchPool sync.Pool // of *mmsgBatch
+}
+
+// mmsghdr is struct mmsghdr from <sys/socket.h>.
+type mmsghdr struct {
+	Hdr unix.Msghdr
+	Len uint32
+}
+
+// mmsgBatch holds the syscall arguments for a batch of up to len(hdrs)
+// messages.
+type mmsgBatch struct {
+	hdrs  []mmsghdr
+	iovs  []unix.Iovec
+	names []unix.RawSockaddrAny
+}
+
+func (c *netbsdBatchingConn) getBatch() *mmsgBatch {
+	return c.batchPool.Get().(*mmsgBatch)
+}
+
+func (c *netbsdBatchingConn) putBatch(batch *mmsgBatch) {
+	clear(batch.hdrs)
+	clear(batch.iovs)
+	c.batchPool.Put(batch)
+}
+
+func (c *netbsdBatchingConn) ReadFromUDPAddrPort(p []byte) (n int, addr netip.AddrPort, err error) {
+	return c.pc.ReadFromUDPAddrPort(p)
+}
+
+func (c *netbsdBatchingConn) SetDeadline(t time.Time) error {
+	return c.pc.SetDeadline(t)
+}
+
+func (c *netbsdBatchingConn) SetReadDeadline(t time.Time) error {
+	return c.pc.SetReadDeadline(t)
+}
+
+func (c *netbsdBatchingConn) SetWriteDeadline(t time.Time) error {
+	return c.pc.SetWriteDeadline(t)
+}
+
+func (c *netbsdBatchingConn) WriteBatchTo(buffs [][]byte, addr netip.AddrPort) error {
+	batch := c.getBatch()
+	defer c.putBatch(batch)
+	for len(buffs) > 0 {
+		n := min(len(buffs), len(batch.hdrs))
+		name, namelen := putSockaddr(&batch.names[0], addr)
+		for i, b := range buffs[:n] {
+			iov := &batch.iovs[i]
+			if len(b) > 0 {
+				iov.Base = &b[0]
+			}
+			iov.SetLen(len(b))
+			h := &batch.hdrs[i].Hdr
+			h.Name = name
+			h.Namelen = namelen
+			h.Iov = iov
+			h.SetIovlen(1)
+		}
+		if err := c.writeBatch(batch.hdrs[:n]); err != nil {
+			return err
+		}
+		buffs = buffs[n:]
+	}
+	return nil
+}
+
+// writeBatch sends the messages in hdrs, retrying until all of them are
+// sent or there's an error.
+func (c *netbsdBatchingConn) writeBatch(hdrs []mmsghdr) error {
+	var sysErr error
+	err := c.rc.Write(func(fd uintptr) (done bool) {
+		for len(hdrs) > 0 {
+			n, _, errno := unix.Syscall6(unix.SYS_SENDMMSG, fd, uintptr(unsafe.Pointer(&hdrs[0])), uintptr(len(hdrs)), 0, 0, 0)
+			switch errno {
+			case 0:
+				hdrs = hdrs[n:]
+			case unix.EINTR:
+			case unix.EAGAIN:
+				return false
+			default:
+				sysErr = os.NewSyscallError("sendmmsg", errno)
+				return true
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return sysErr
+}
+
+func (c *netbsdBatchingConn) ReadBatch(msgs []ipv6.Message, flags int) (n int, err error) {
+	batch := c.getBatch()
+	defer c.putBatch(batch)
+	msgs = msgs[:min(len(msgs), len(batch.hdrs))]
+	for i := range msgs {
+		b := msgs[i].Buffers[0]
+		iov := &batch.iovs[i]
+		if len(b) > 0 {
+			iov.Base = &b[0]
+		}
+		iov.SetLen(len(b))
+		h := &batch.hdrs[i].Hdr
+		h.Name = (*byte)(unsafe.Pointer(&batch.names[i]))
+		h.Namelen = unix.SizeofSockaddrAny
+		h.Iov = iov
+		h.SetIovlen(1)
+	}
+	hdrs := batch.hdrs[:len(msgs)]
+	var sysErr error
+	err = c.rc.Read(func(fd uintptr) (done bool) {
+		for {
+			r, _, errno := unix.Syscall6(unix.SYS_RECVMMSG, fd, uintptr(unsafe.Pointer(&hdrs[0])), uintptr(len(hdrs)), uintptr(flags), 0, 0)
+			switch errno {
+			case 0:
+				n = int(r)
+				return true
+			case unix.EINTR:
+			case unix.EAGAIN:
+				return false
+			default:
+				sysErr = os.NewSyscallError("recvmmsg", errno)
+				return true
+			}
+		}
+	})
+	if err == nil {
+		err = sysErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	for i := range msgs[:n] {
+		msg := &msgs[i]
+		msg.N = int(hdrs[i].Len)
+		msg.NN = 0
+		msg.Flags = int(hdrs[i].Hdr.Flags)
+		msg.Addr = sockaddrToUDPAddr(&batch.names[i])
+		if msg.Addr == nil {
+			// Not from an IPv4 or IPv6 address; ignore it.
+			msg.N = 0
+		}
+	}
+	return n, nil
+}
+
+// putSockaddr writes ap to sa and returns the name and namelen to put in a
+// unix.Msghdr to send to it.
+func putSockaddr(sa *unix.RawSockaddrAny, ap netip.AddrPort) (name *byte, namelen uint32) {
+	if ap.Addr().Is4() {
+		sa4 := (*unix.RawSockaddrInet4)(unsafe.Pointer(sa))
+		*sa4 = unix.RawSockaddrInet4{
+			Len:    unix.SizeofSockaddrInet4,
+			Family: unix.AF_INET,
+			Addr:   ap.Addr().As4(),
+		}
+		putPort(&sa4.Port, ap.Port())
+		return (*byte)(unsafe.Pointer(sa4)), unix.SizeofSockaddrInet4
+	}
+	sa6 := (*unix.RawSockaddrInet6)(unsafe.Pointer(sa))
+	*sa6 = unix.RawSockaddrInet6{
+		Len:    unix.SizeofSockaddrInet6,
+		Family: unix.AF_INET6,
+		Addr:   ap.Addr().As16(),
+	}
+	putPort(&sa6.Port, ap.Port())
+	return (*byte)(unsafe.Pointer(sa6)), unix.SizeofSockaddrInet6
+}
+
+// putPort writes port to p in network byte order.
+func putPort(p *uint16, port uint16) {
+	b := (*[2]byte)(unsafe.Pointer(p))
+	b[0], b[1] = byte(port>>8), byte(port)
+}
+
+// getPort returns the port, in network byte order, at p.
+func getPort(p *uint16) uint16 {
+	b := (*[2]byte)(unsafe.Pointer(p))
+	return uint16(b[0])<<8 | uint16(b[1])
+}
+
+// sockaddrToUDPAddr returns the address in sa, or nil if it's not an IPv4
+// or IPv6 address.
+func sockaddrToUDPAddr(sa *unix.RawSockaddrAny) *net.UDPAddr {
+	switch sa.Addr.Family {
+	case unix.AF_INET:
+		sa4 := (*unix.RawSockaddrInet4)(unsafe.Pointer(sa))
+		return net.UDPAddrFromAddrPort(netip.AddrPortFrom(netip.AddrFrom4(sa4.Addr), getPort(&sa4.Port)))
+	case unix.AF_INET6:
+		sa6 := (*unix.RawSockaddrInet6)(unsafe.Pointer(sa))
+		return net.UDPAddrFromAddrPort(netip.AddrPortFrom(netip.AddrFrom16(sa6.Addr), getPort(&sa6.Port)))
+	}
+	return nil
+}
+
+func (c *netbsdBatchingConn) SyscallConn() (syscall.RawConn, error) {
+	return c.rc, nil
+}
+
+func (c *netbsdBatchingConn) LocalAddr() net.Addr {
+	return c.pc.LocalAddr().(*net.UDPAddr)
+}
+
+func (c *netbsdBatchingConn) WriteToUDPAddrPort(b []byte, addr netip.AddrPort) (int, error) {
+	return c.pc.WriteToUDPAddrPort(b, addr)
+}
+
+func (c *netbsdBatchingConn) Close() error {
+	return c.pc.Close()
+}
+
+// tryUpgradeToBatchingConn upgrades pconn to a *netbsdBatchingConn if
+// appropriate.
+func tryUpgradeToBatchingConn(pconn nettype.PacketConn, network string, batchSize int) nettype.PacketConn {
+	if network != "udp4" && network != "udp6" {
+		return pconn
+	}
+	uc, ok := pconn.(*net.UDPConn)
+	if !ok {
+		return pconn
+	}
+	rc, err := uc.SyscallConn()
+	if err != nil {
+		return pconn
+	}
+	return &netbsdBatchingConn{
+		pc: uc,
+		rc: rc,
+		batchPool: sync.Pool{
+			New: func() any {
+				return &mmsgBatch{
+					hdrs:  make([]mmsghdr, batchSize),
+					iovs:  make([]unix.Iovec, batchSize),
+					names: make([]unix.RawSockaddrAny, batchSize),
+				}
+			},
+		},
+	}
+}
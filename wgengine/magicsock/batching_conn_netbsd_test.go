@@ -0,0 +1,81 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+)
+
+func Test_netbsdBatchingConn_sockaddr(t *testing.T) {
+	for _, s := range []string{"192.0.2.1:41641", "[2001:db8::1]:65535", "127.0.0.1:1"} {
+		ap := netip.MustParseAddrPort(s)
+		var sa unix.RawSockaddrAny
+		putSockaddr(&sa, ap)
+		got := sockaddrToUDPAddr(&sa)
+		if got == nil || got.AddrPort() != ap {
+			t.Errorf("round trip of %v = %v", ap, got)
+		}
+	}
+}
+
+func Test_netbsdBatchingConn_loopback(t *testing.T) {
+	for _, network := range []string{"udp4", "udp6"} {
+		t.Run(network, func(t *testing.T) {
+			ip := "127.0.0.1"
+			if network == "udp6" {
+				ip = "::1"
+			}
+			pc, err := net.ListenPacket(network, net.JoinHostPort(ip, "0"))
+			if err != nil {
+				t.Skipf("listen: %v", err)
+			}
+			bc, ok := tryUpgradeToBatchingConn(pc.(*net.UDPConn), network, 8).(*netbsdBatchingConn)
+			if !ok {
+				t.Fatal("not upgraded to *netbsdBatchingConn")
+			}
+			defer bc.Close()
+			laddr := bc.LocalAddr().(*net.UDPAddr).AddrPort()
+
+			// Send more than a batch, to cover splitting writes.
+			var buffs [][]byte
+			for i := range 10 {
+				buffs = append(buffs, []byte(fmt.Sprintf("packet %d", i)))
+			}
+			if err := bc.WriteBatchTo(buffs, laddr); err != nil {
+				t.Fatalf("WriteBatchTo: %v", err)
+			}
+
+			msgs := make([]ipv6.Message, 8)
+			for i := range msgs {
+				msgs[i].Buffers = [][]byte{make([]byte, 64)}
+			}
+			bc.SetReadDeadline(time.Now().Add(5 * time.Second))
+			var got []string
+			for len(got) < len(buffs) {
+				n, err := bc.ReadBatch(msgs, 0)
+				if err != nil {
+					t.Fatalf("ReadBatch after %d packets: %v", len(got), err)
+				}
+				for _, msg := range msgs[:n] {
+					if from := msg.Addr.(*net.UDPAddr).AddrPort(); from != laddr {
+						t.Errorf("packet from %v; want %v", from, laddr)
+					}
+					got = append(got, string(msg.Buffers[0][:msg.N]))
+				}
+			}
+			for i, b := range buffs {
+				if got[i] != string(b) {
+					t.Errorf("packet %d = %q; want %q", i, got[i], b)
+				}
+			}
+		})
+	}
+}
@@ -62,6 +62,20 @@
 	//
 	//lint:ignore U1000 used on Linux/Darwin only
 	debugPMTUD = envknob.RegisterBool("TS_DEBUG_PMTUD")
+	// debugDisableAdaptiveHeartbeat keeps endpoint heartbeats at a fixed
+	// heartbeatInterval for as long as a session is active, rather than
+	// backing off while the session is idle.
+	debugDisableAdaptiveHeartbeat = envknob.RegisterBool("TS_DEBUG_DISABLE_ADAPTIVE_HEARTBEAT")
+	// debugHeartbeatMaxInterval overrides heartbeatMaxInterval, the
+	// longest period idle endpoint heartbeats back off to.
+	debugHeartbeatMaxInterval = envknob.RegisterDuration("TS_DEBUG_HEARTBEAT_MAX_INTERVAL")
+	// debugDisableDERPHomeProbe stops pinging the home DERP region and
+	// its failover candidates, leaving home region changes to netcheck.
+	debugDisableDERPHomeProbe = envknob.RegisterBool("TS_DEBUG_DISABLE_DERP_HOME_PROBE")
+	// debugEnableMultipathFailover enables or disables, overriding
+	// control, duplicating packets across two paths for a short window
+	// after a peer's direct path degrades.
+	debugEnableMultipathFailover = envknob.RegisterOptBool("TS_DEBUG_ENABLE_MULTIPATH_FAILOVER")
 	// Hey you! Adding a new debugknob? Make sure to stub it out in the
 	// debugknobs_stubs.go file too.
 )
@@ -152,14 +152,10 @@ func (c *Conn) maybeSetNearestDERP(report *netcheck.Report) (preferredDERP int)
 	// Despite the above behaviour, ensure that we set the nearest DERP if
 	// we don't currently have one set; any DERP server is better than
 	// none, even if not connected to control.
-	var connectedToControl bool
-	if testenv.InTest() && !checkControlHealthDuringNearestDERPInTests {
-		connectedToControl = true
-	} else {
-		connectedToControl = c.health.GetInPollNetMap()
-	}
+	connectedToControl := c.connectedToControl()
 	c.mu.Lock()
 	myDerp := c.myDerp
+	avoidPreferred := c.derpHomeProbe.avoiding(report.PreferredDERP, mono.Now())
 	c.mu.Unlock()
 	if !connectedToControl {
 		if myDerp != 0 {
@@ -178,6 +174,11 @@ func (c *Conn) maybeSetNearestDERP(report *netcheck.Report) (preferredDERP int)
 		// one.
 		preferredDERP = c.pickDERPFallback()
 	}
+	if avoidPreferred && myDerp != 0 && preferredDERP != myDerp {
+		// We recently failed over from it when it stopped answering
+		// pings (see probeDERPHome); don't flap back.
+		return myDerp
+	}
 	if preferredDERP != myDerp {
 		c.logf(
 			"magicsock: home DERP changing from derp-%d [%dms] to derp-%d [%dms]",
@@ -189,6 +190,16 @@ func (c *Conn) maybeSetNearestDERP(report *netcheck.Report) (preferredDERP int)
 	return
 }
 
+// connectedToControl reports whether we're connected to control, and so
+// can tell peers about a change of DERP home. In tests, it's always true
+// unless checkControlHealthDuringNearestDERPInTests is set.
+func (c *Conn) connectedToControl() bool {
+	if testenv.InTest() && !checkControlHealthDuringNearestDERPInTests {
+		return true
+	}
+	return c.health.GetInPollNetMap()
+}
+
 func (c *Conn) derpRegionCodeLocked(regionID int) string {
 	if c.derpMap == nil {
 		return ""
@@ -736,6 +747,7 @@ func (c *Conn) processDERPReadResult(dm derpReadResult, b []byte) (n int, ep *en
 	}
 
 	ep.noteRecvActivity(ipp, mono.Now())
+	ep.pathStats.noteRx(dm.n, true)
 	if stats := c.stats.Load(); stats != nil {
 		stats.UpdateRxPhysical(ep.nodeAddr, ipp, 1, dm.n)
 	}
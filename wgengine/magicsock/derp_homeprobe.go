@@ -0,0 +1,287 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"tailscale.com/derp/derphttp"
+	"tailscale.com/tstime/mono"
+	"tailscale.com/types/key"
+)
+
+const (
+	// derpHomeProbeInterval is how often the home DERP region and the
+	// failover candidates are pinged.
+	derpHomeProbeInterval = time.Second
+
+	// derpHomeProbeTimeout is how long a ping may take before the probe
+	// counts as failed.
+	derpHomeProbeTimeout = time.Second
+
+	// derpHomeProbeCandidates is how many regions, after the home region,
+	// are kept connected and probed as failover candidates.
+	derpHomeProbeCandidates = 2
+
+	// derpHomeFailoverProbes is how many probes of the home region in a
+	// row must fail before failing over, so a single lost ping doesn't
+	// move us.
+	derpHomeFailoverProbes = 2
+
+	// derpHomeSettleTime is how long a region must have been home before
+	// failed probes can move us off it, giving a new home time to connect.
+	derpHomeSettleTime = 10 * time.Second
+
+	// derpHomeAvoidTime is how long after failing over from a region
+	// netcheck can't move us back to it, so a region that's still
+	// reachable by STUN but not usable for DERP doesn't flap our home.
+	derpHomeAvoidTime = 5 * time.Minute
+
+	// derpProbeSlowSlack is how much slower than twice its smoothed RTT a
+	// successful ping may be before it counts as a failed probe.
+	derpProbeSlowSlack = 100 * time.Millisecond
+)
+
+// derpProbeState is the probe history of a DERP region.
+type derpProbeState struct {
+	fails   int           // failed probes in a row
+	rtt     time.Duration // smoothed RTT of successful probes; zero if none yet
+	lastRTT time.Duration // RTT of the last probe, or zero if it got no reply
+}
+
+// derpHomeProber decides, from pings over the connections to the home DERP
+// region and its failover candidates, when to change home region ahead of
+// netcheck.
+//
+// The zero value is ready for use. It's not safe for concurrent use; Conn
+// guards it with Conn.mu.
+type derpHomeProber struct {
+	home      int       // home region at the last probe
+	homeSince mono.Time // when home became home
+
+	regions map[int]*derpProbeState // by region ID
+	avoid   map[int]mono.Time       // region ID => until when not to return to it
+}
+
+// setHome notes that home is the home region at now, forgetting the probe
+// history of regions not in probed.
+func (p *derpHomeProber) setHome(home int, probed []int, now mono.Time) {
+	if home != p.home {
+		p.home = home
+		p.homeSince = now
+	}
+	for rid := range p.regions {
+		if !slices.Contains(probed, rid) {
+			delete(p.regions, rid)
+		}
+	}
+}
+
+// observe records a probe of regionID that took rtt, or failed with err.
+func (p *derpHomeProber) observe(regionID int, rtt time.Duration, err error) {
+	st := p.regions[regionID]
+	if st == nil {
+		st = new(derpProbeState)
+		if p.regions == nil {
+			p.regions = make(map[int]*derpProbeState)
+		}
+		p.regions[regionID] = st
+	}
+	if err != nil {
+		st.fails++
+		st.lastRTT = 0
+		return
+	}
+	st.lastRTT = rtt
+	if st.rtt != 0 && rtt > 2*st.rtt+derpProbeSlowSlack {
+		st.fails++
+		return
+	}
+	st.fails = 0
+	if st.rtt == 0 {
+		st.rtt = rtt
+	} else {
+		st.rtt = (3*st.rtt + rtt) / 4
+	}
+}
+
+// failoverTarget returns the region to fail over to at now, if the home
+// region is degraded and a candidate is healthy and faster than it.
+func (p *derpHomeProber) failoverTarget(now mono.Time) (regionID int, ok bool) {
+	home := p.regions[p.home]
+	if p.home == 0 || home == nil || home.fails < derpHomeFailoverProbes {
+		return 0, false
+	}
+	if now.Sub(p.homeSince) < derpHomeSettleTime {
+		return 0, false
+	}
+	var best *derpProbeState
+	for rid, st := range p.regions {
+		if rid == p.home || st.fails > 0 || st.rtt == 0 || p.avoiding(rid, now) {
+			continue
+		}
+		if home.lastRTT != 0 && st.rtt >= home.lastRTT {
+			// The home region is slow, but still faster than this.
+			continue
+		}
+		if best == nil || st.rtt < best.rtt || (st.rtt == best.rtt && rid < regionID) {
+			best, regionID = st, rid
+		}
+	}
+	return regionID, best != nil
+}
+
+// noteFailover records that at now, the home region was changed to the one
+// failoverTarget returned.
+func (p *derpHomeProber) noteFailover(now mono.Time) {
+	if p.avoid == nil {
+		p.avoid = make(map[int]mono.Time)
+	}
+	p.avoid[p.home] = now.Add(derpHomeAvoidTime)
+}
+
+// avoiding reports whether, at now, regionID was failed over from too
+// recently to become home again.
+func (p *derpHomeProber) avoiding(regionID int, now mono.Time) bool {
+	until, ok := p.avoid[regionID]
+	if !ok {
+		return false
+	}
+	if now.After(until) {
+		delete(p.avoid, regionID)
+		return false
+	}
+	return true
+}
+
+// derpHomeProbeCandidatesLocked returns the up to derpHomeProbeCandidates
+// regions, other than home, with the lowest latency in the last netcheck
+// report.
+//
+// c.mu must be held.
+func (c *Conn) derpHomeProbeCandidatesLocked(home int) []int {
+	report := c.lastNetCheckReport.Load()
+	if report == nil || c.derpMap == nil {
+		return nil
+	}
+	var ids []int
+	for rid := range report.RegionLatency {
+		if r := c.derpMap.Regions[rid]; rid != home && r != nil && !r.Avoid {
+			ids = append(ids, rid)
+		}
+	}
+	slices.SortFunc(ids, func(a, b int) int {
+		return cmp.Or(cmp.Compare(report.RegionLatency[a], report.RegionLatency[b]), cmp.Compare(a, b))
+	})
+	if len(ids) > derpHomeProbeCandidates {
+		ids = ids[:derpHomeProbeCandidates]
+	}
+	return ids
+}
+
+// runDERPHomeProber pings the home DERP region and its failover candidates
+// every derpHomeProbeInterval until c is closed, failing over to the best
+// candidate when the home region stops answering. Without it, a degraded
+// home region is only left once writes to it time out and the next
+// netcheck notices.
+func (c *Conn) runDERPHomeProber() {
+	t := time.NewTicker(derpHomeProbeInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.donec:
+			return
+		case <-t.C:
+		}
+		if !debugDisableDERPHomeProbe() {
+			c.probeDERPHome()
+		}
+	}
+}
+
+// probeDERPHome pings the home DERP region and its failover candidates
+// once, and fails over if the home region is degraded.
+//
+// c.mu must NOT be held.
+func (c *Conn) probeDERPHome() {
+	c.mu.Lock()
+	home := c.myDerp
+	if c.closed || home == 0 || !c.shouldDoPeriodicReSTUNLocked() {
+		c.mu.Unlock()
+		return
+	}
+	regions := append([]int{home}, c.derpHomeProbeCandidatesLocked(home)...)
+	c.derpHomeProbe.setHome(home, regions, mono.Now())
+	c.mu.Unlock()
+
+	type probe struct {
+		regionID int
+		dc       *derphttp.Client
+		rtt      time.Duration
+		err      error
+	}
+	var probes []*probe
+	for _, rid := range regions {
+		// Connects to rid if needed, and keeps cleanStaleDerp from
+		// closing the connection while it's a candidate.
+		c.derpWriteChanForRegion(rid, key.NodePublic{})
+		c.mu.Lock()
+		ad, ok := c.activeDerp[rid]
+		c.mu.Unlock()
+		if ok {
+			probes = append(probes, &probe{regionID: rid, dc: ad.c})
+		}
+	}
+	var wg sync.WaitGroup
+	for _, p := range probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(c.connCtx, derpHomeProbeTimeout)
+			defer cancel()
+			t0 := time.Now()
+			p.err = p.dc.Ping(ctx)
+			p.rtt = time.Since(t0)
+		}()
+	}
+	wg.Wait()
+
+	connectedToControl := c.connectedToControl()
+	c.mu.Lock()
+	if c.closed || c.myDerp != home {
+		// Closed, or netcheck moved us while we were probing.
+		c.mu.Unlock()
+		return
+	}
+	for _, p := range probes {
+		c.derpHomeProbe.observe(p.regionID, p.rtt, p.err)
+	}
+	now := mono.Now()
+	to, ok := c.derpHomeProbe.failoverTarget(now)
+	if !ok || c.netInfoLast == nil {
+		c.mu.Unlock()
+		return
+	}
+	if !connectedToControl {
+		// As in maybeSetNearestDERP, peers can't learn of a new home
+		// without control.
+		metricDERPHomeNoChangeNoControl.Add(1)
+		c.mu.Unlock()
+		return
+	}
+	c.logf("magicsock: home derp-%d not answering pings; failing over to derp-%d [%dms]",
+		home, to, c.derpHomeProbe.regions[to].rtt.Milliseconds())
+	metricDERPHomeFailover.Add(1)
+	c.derpHomeProbe.noteFailover(now)
+	ni := c.netInfoLast.Clone()
+	ni.PreferredDERP = to
+	c.callNetInfoCallbackLocked(ni)
+	c.mu.Unlock()
+
+	c.setNearestDERP(to)
+}
@@ -0,0 +1,87 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"tailscale.com/tstime/mono"
+)
+
+func TestDERPHomeProber(t *testing.T) {
+	errTimeout := errors.New("timeout")
+	start := mono.Now()
+	var p derpHomeProber
+	regions := []int{1, 2, 3}
+
+	// probe records a round of probes at start+at, with an RTT per
+	// region, where zero means the ping failed, and returns the region
+	// to fail over to, if any.
+	probe := func(at time.Duration, rtts map[int]time.Duration) (int, bool) {
+		now := start.Add(at)
+		p.setHome(regions[0], regions, now)
+		for rid, rtt := range rtts {
+			if rtt == 0 {
+				p.observe(rid, 0, errTimeout)
+			} else {
+				p.observe(rid, rtt, nil)
+			}
+		}
+		return p.failoverTarget(now)
+	}
+	ms := time.Millisecond
+
+	for i := range 10 {
+		if to, ok := probe(time.Duration(i)*time.Second, map[int]time.Duration{1: 20 * ms, 2: 30 * ms, 3: 40 * ms}); ok {
+			t.Fatalf("healthy home: failover to %d", to)
+		}
+	}
+	// A lost ping isn't enough to fail over, as is any failure before the
+	// home region has settled.
+	if to, ok := probe(10*time.Second, map[int]time.Duration{1: 0, 2: 30 * ms, 3: 40 * ms}); ok {
+		t.Fatalf("one failed probe: failover to %d", to)
+	}
+	// A slow but faster than the candidates home region stays home.
+	if to, ok := probe(11*time.Second, map[int]time.Duration{1: 25 * ms, 2: 30 * ms, 3: 40 * ms}); ok {
+		t.Fatalf("recovered home: failover to %d", to)
+	}
+	// Home is slow for two probes; but the candidates are even slower.
+	p.regions[2].rtt, p.regions[3].rtt = 300*ms, 400*ms
+	for i := range 2 {
+		probe(time.Duration(12+i)*time.Second, map[int]time.Duration{1: 250 * ms})
+	}
+	if to, ok := p.failoverTarget(start.Add(13 * time.Second)); ok {
+		t.Fatalf("home slower than usual but faster than candidates: failover to %d", to)
+	}
+	p.regions[2].rtt, p.regions[3].rtt = 30*ms, 40*ms
+
+	// When home stops answering, we fail over to the fastest healthy
+	// candidate.
+	probe(14*time.Second, map[int]time.Duration{1: 0, 2: 30 * ms, 3: 40 * ms})
+	to, ok := probe(15*time.Second, map[int]time.Duration{1: 0, 2: 0, 3: 40 * ms})
+	if !ok || to != 3 {
+		t.Fatalf("failoverTarget = %v, %v; want 3, true", to, ok)
+	}
+	p.noteFailover(start.Add(15 * time.Second))
+
+	// The new home must settle before we'd fail over from it.
+	regions = []int{3, 1, 2}
+	probe(16*time.Second, map[int]time.Duration{3: 0, 1: 20 * ms, 2: 30 * ms})
+	if to, ok := probe(17*time.Second, map[int]time.Duration{3: 0, 1: 20 * ms, 2: 30 * ms}); ok {
+		t.Fatalf("unsettled home: failover to %d", to)
+	}
+	if to, ok := probe(26*time.Second, map[int]time.Duration{3: 0, 1: 20 * ms, 2: 30 * ms}); !ok || to != 2 {
+		t.Fatalf("failoverTarget with old home avoided = %v, %v; want 2, true", to, ok)
+	}
+
+	// The old home is avoided until derpHomeAvoidTime has passed.
+	if !p.avoiding(1, start.Add(15*time.Second+derpHomeAvoidTime-time.Second)) {
+		t.Error("not avoiding old home before derpHomeAvoidTime")
+	}
+	if p.avoiding(1, start.Add(16*time.Second+derpHomeAvoidTime)) {
+		t.Error("still avoiding old home after derpHomeAvoidTime")
+	}
+}
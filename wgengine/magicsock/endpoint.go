@@ -75,6 +75,7 @@ type endpoint struct {
 	mu sync.Mutex // Lock ordering: Conn.mu, then endpoint.mu
 
 	heartBeatTimer *time.Timer    // nil when idle
+	heartbeatEvery time.Duration  // current heartbeat period; grows while the session sends nothing, see nextHeartbeatIntervalLocked
 	lastSendExt    mono.Time      // last time there were outgoing packets sent to this peer from an external trigger (e.g. wireguard-go or disco pingCLI)
 	lastSendAny    mono.Time      // last time there were outgoing packets sent this peer from any trigger, internal or external to magicsock
 	lastFullPing   mono.Time      // last time we pinged all disco or wireguard only endpoints
@@ -95,15 +96,39 @@ type endpoint struct {
 
 	expired         bool // whether the node has expired
 	isWireguardOnly bool // whether the endpoint is WireGuard only
+
+	pathStats pathStats // for ipnstate.PeerPathStats; see its docs for locking
+
+	// multipathUntil, if non-zero, is when the current multipath failover
+	// window ends, and multipathAddr is the degraded direct path that
+	// started it. See startMultipathLocked.
+	multipathUntil mono.Time
+	multipathAddr  netip.AddrPort
 }
 
-func (de *endpoint) setBestAddrLocked(v addrQuality) {
+// setBestAddrLocked sets de.bestAddr to v, recording reason in de.pathStats
+// if it's a different address.
+//
+// de.mu must be held.
+func (de *endpoint) setBestAddrLocked(v addrQuality, reason string) {
 	if v.AddrPort != de.bestAddr.AddrPort {
 		de.probeUDPLifetime.resetCycleEndpointLocked()
+		de.pathStats.notePathChangeLocked(time.Now(), reason)
 	}
 	de.bestAddr = v
 }
 
+// wireMTU returns the path MTU of de's best UDP address, the largest
+// probed packet size to which it has answered, if it has one.
+func (de *endpoint) wireMTU() (mtu tstun.WireMTU, ok bool) {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	if !de.bestAddr.IsValid() || de.bestAddr.wireMTU == 0 {
+		return 0, false
+	}
+	return de.bestAddr.wireMTU, true
+}
+
 const (
 	// udpLifetimeProbeCliffSlack is how much slack to use relative to a
 	// ProbeUDPLifetimeConfig.Cliffs duration in order to account for RTT,
@@ -451,7 +476,7 @@ func (de *endpoint) deleteEndpointLocked(why string, ep netip.AddrPort) {
 			What: "deleteEndpointLocked-bestAddr-" + why,
 			From: de.bestAddr,
 		})
-		de.setBestAddrLocked(addrQuality{})
+		de.setBestAddrLocked(addrQuality{}, "endpoint-deleted")
 	}
 }
 
@@ -827,7 +852,39 @@ func (de *endpoint) heartbeat() {
 		de.sendDiscoPingsLocked(now, true)
 	}
 
-	de.heartBeatTimer = time.AfterFunc(heartbeatInterval, de.heartbeat)
+	de.heartBeatTimer = time.AfterFunc(de.nextHeartbeatIntervalLocked(now), de.heartbeat)
+}
+
+// nextHeartbeatIntervalLocked returns how long to wait before the next
+// heartbeat of an active session.
+//
+// While the session is sending, heartbeats run every heartbeatInterval.
+// Once it has sent nothing for heartbeatIdleAfter, the period doubles on
+// each heartbeat up to heartbeatMaxInterval, so that peers that are
+// connected but quiet don't cost a disco ping every few seconds until the
+// session times out. noteTxActivityExtTriggerLocked resets the period
+// when traffic resumes.
+//
+// de.mu must be held.
+func (de *endpoint) nextHeartbeatIntervalLocked(now mono.Time) time.Duration {
+	maxInterval := heartbeatMaxInterval
+	if d := debugHeartbeatMaxInterval(); d > 0 {
+		maxInterval = d
+	}
+	idleFor := now.Sub(de.lastSendExt)
+	if idleFor < heartbeatIdleAfter ||
+		maxInterval <= heartbeatInterval ||
+		de.probeUDPLifetime != nil || // relies on heartbeats to time its probes
+		debugDisableAdaptiveHeartbeat() {
+		de.heartbeatEvery = heartbeatInterval
+		return heartbeatInterval
+	}
+	de.heartbeatEvery = min(max(de.heartbeatEvery*2, heartbeatInterval), maxInterval)
+
+	// Don't overshoot the end of the session by more than the
+	// non-adaptive schedule would, so idle sessions are still wound
+	// down promptly.
+	return min(de.heartbeatEvery, max(sessionActiveTimeout-idleFor, 0)+heartbeatInterval)
 }
 
 // setHeartbeatDisabled sets heartbeatDisabled to the provided value.
@@ -862,8 +919,21 @@ func (de *endpoint) wantFullPingLocked(now mono.Time) bool {
 
 func (de *endpoint) noteTxActivityExtTriggerLocked(now mono.Time) {
 	de.lastSendExt = now
-	if de.heartBeatTimer == nil && !de.heartbeatDisabled {
+	if de.heartbeatDisabled {
+		return
+	}
+	if de.heartBeatTimer == nil {
+		de.heartbeatEvery = heartbeatInterval
 		de.heartBeatTimer = time.AfterFunc(heartbeatInterval, de.heartbeat)
+	} else if de.heartbeatEvery > heartbeatInterval {
+		// Traffic resumed while heartbeats were backed off. Pull the
+		// next one in rather than waiting out the long period. If the
+		// timer already fired, the running heartbeat sees the new
+		// lastSendExt and reschedules at the normal rate itself.
+		de.heartbeatEvery = heartbeatInterval
+		if de.heartBeatTimer.Stop() {
+			de.heartBeatTimer.Reset(heartbeatInterval)
+		}
 	}
 }
 
@@ -935,6 +1005,7 @@ func (de *endpoint) send(buffs [][]byte) error {
 
 	now := mono.Now()
 	udpAddr, derpAddr, startWGPing := de.addrForSendLocked(now)
+	derpAddr, dupAddr := de.multipathAddrsLocked(now, udpAddr, derpAddr)
 
 	if de.isWireguardOnly {
 		if startWGPing {
@@ -947,9 +1018,19 @@ func (de *endpoint) send(buffs [][]byte) error {
 	de.lastSendAny = now
 	de.mu.Unlock()
 
-	if !udpAddr.IsValid() && !derpAddr.IsValid() {
+	if !udpAddr.IsValid() && !derpAddr.IsValid() && !dupAddr.IsValid() {
 		return errNoUDPOrDERP
 	}
+	if dupAddr.IsValid() {
+		// Best effort; the old path may well be gone.
+		if _, err := de.c.sendUDPBatch(dupAddr, buffs); err == nil {
+			var txBytes int
+			for _, b := range buffs {
+				txBytes += len(b)
+			}
+			de.pathStats.noteTx(txBytes, false)
+		}
+	}
 	var err error
 	if udpAddr.IsValid() {
 		_, err = de.c.sendUDPBatch(udpAddr, buffs)
@@ -965,6 +1046,9 @@ func (de *endpoint) send(buffs [][]byte) error {
 		for _, b := range buffs {
 			txBytes += len(b)
 		}
+		if err == nil {
+			de.pathStats.noteTx(txBytes, false)
+		}
 
 		switch {
 		case udpAddr.Addr().Is4():
@@ -992,6 +1076,7 @@ func (de *endpoint) send(buffs [][]byte) error {
 			}
 		}
 
+		de.pathStats.noteTx(txBytes, true)
 		if stats := de.c.stats.Load(); stats != nil {
 			stats.UpdateTxPhysical(de.nodeAddr, derpAddr, len(buffs), txBytes)
 		}
@@ -1045,9 +1130,15 @@ func (de *endpoint) discoPingTimeout(txid stun.TxID) {
 	if !ok {
 		return
 	}
-	if debugDisco() || !de.bestAddr.IsValid() || mono.Now().After(de.trustBestAddrUntil) {
+	now := mono.Now()
+	if debugDisco() || !de.bestAddr.IsValid() || now.After(de.trustBestAddrUntil) {
 		de.c.dlogf("[v1] magicsock: disco: timeout waiting for pong %x from %v (%v, %v)", txid[:6], sp.to, de.publicKey.ShortString(), de.discoShort())
 	}
+	if sp.to == de.bestAddr.AddrPort && !now.After(de.trustBestAddrUntil) {
+		// We're still sending only to bestAddr, which has stopped
+		// answering.
+		de.startMultipathLocked(now, sp.to, "ping-timeout")
+	}
 	de.removeSentDiscoPingLocked(txid, sp, discoPingTimedOut)
 }
 
@@ -1470,11 +1561,12 @@ func (de *endpoint) addCandidateEndpoint(ep netip.AddrPort, forRxPingTxID stun.T
 }
 
 // clearBestAddrLocked clears the bestAddr and related fields such that future
-// packets will re-evaluate the best address to send to next.
+// packets will re-evaluate the best address to send to next. The reason is
+// recorded in de.pathStats.
 //
 // de.mu must be held.
-func (de *endpoint) clearBestAddrLocked() {
-	de.setBestAddrLocked(addrQuality{})
+func (de *endpoint) clearBestAddrLocked(reason string) {
+	de.setBestAddrLocked(addrQuality{}, reason)
 	de.bestAddrAt = 0
 	de.trustBestAddrUntil = 0
 }
@@ -1486,7 +1578,7 @@ func (de *endpoint) noteBadEndpoint(ipp netip.AddrPort) {
 	de.mu.Lock()
 	defer de.mu.Unlock()
 
-	de.clearBestAddrLocked()
+	de.clearBestAddrLocked("bad-endpoint")
 
 	if st, ok := de.endpointState[ipp]; ok {
 		st.clear()
@@ -1500,7 +1592,8 @@ func (de *endpoint) noteConnectivityChange() {
 	de.mu.Lock()
 	defer de.mu.Unlock()
 
-	de.clearBestAddrLocked()
+	de.startMultipathLocked(mono.Now(), de.bestAddr.AddrPort, "connectivity-change")
+	de.clearBestAddrLocked("connectivity-change")
 
 	for k := range de.endpointState {
 		de.endpointState[k].clear()
@@ -1587,6 +1680,7 @@ func (de *endpoint) handlePongConnLocked(m *disco.Pong, di *discoInfo, src netip
 			from:    src,
 			pongSrc: m.Src,
 		})
+		de.pathStats.observeRTTLocked(latency)
 	}
 
 	if sp.purpose != pingHeartbeat && sp.purpose != pingHeartbeatForUDPLifetime {
@@ -1617,7 +1711,7 @@ func (de *endpoint) handlePongConnLocked(m *disco.Pong, di *discoInfo, src netip
 				From: de.bestAddr,
 				To:   thisPong,
 			})
-			de.setBestAddrLocked(thisPong)
+			de.setBestAddrLocked(thisPong, "pong-better-addr")
 		}
 		if de.bestAddr.AddrPort == thisPong.AddrPort {
 			de.debugUpdates.Add(EndpointChange{
@@ -1629,6 +1723,7 @@ func (de *endpoint) handlePongConnLocked(m *disco.Pong, di *discoInfo, src netip
 			de.bestAddr.latency = latency
 			de.bestAddrAt = now
 			de.trustBestAddrUntil = now.Add(trustUDPAddrDuration)
+			de.endMultipathLocked()
 		}
 	}
 	return
@@ -1798,6 +1893,9 @@ func (de *endpoint) populatePeerStatus(ps *ipnstate.PeerStatus) {
 
 	ps.Relay = de.c.derpRegionCodeOfIDLocked(int(de.derpAddr.Port()))
 
+	ps.PathStats = new(ipnstate.PeerPathStats)
+	de.pathStats.populateLocked(ps.PathStats)
+
 	if de.lastSendExt.IsZero() {
 		return
 	}
@@ -1809,6 +1907,9 @@ func (de *endpoint) populatePeerStatus(ps *ipnstate.PeerStatus) {
 	if udpAddr, derpAddr, _ := de.addrForSendLocked(now); udpAddr.IsValid() && !derpAddr.IsValid() {
 		ps.CurAddr = udpAddr.String()
 	}
+	if udpAddr := de.bestAddr.AddrPort; udpAddr.IsValid() {
+		ps.PathStats.CurAddr = udpAddr.String()
+	}
 }
 
 // stopAndReset stops timers associated with de and resets its state back to zero.
@@ -1845,7 +1946,8 @@ func (de *endpoint) stopAndReset() {
 func (de *endpoint) resetLocked() {
 	de.lastSendExt = 0
 	de.lastFullPing = 0
-	de.clearBestAddrLocked()
+	de.clearBestAddrLocked("reset")
+	de.endMultipathLocked()
 	for _, es := range de.endpointState {
 		es.lastPing = 0
 	}
@@ -5,10 +5,12 @@
 
 import (
 	"net/netip"
+	"slices"
 	"testing"
 	"time"
 
 	"github.com/dsnet/try"
+	"tailscale.com/tstime/mono"
 	"tailscale.com/types/key"
 )
 
@@ -324,3 +326,55 @@ func(lifetime *probeUDPLifetime) time.Duration {
 		})
 	}
 }
+
+func Test_endpoint_nextHeartbeatIntervalLocked(t *testing.T) {
+	de := &endpoint{}
+	start := mono.Now()
+	de.lastSendExt = start
+
+	// Heartbeats run at the base rate while the session is sending.
+	if got := de.nextHeartbeatIntervalLocked(start.Add(heartbeatInterval)); got != heartbeatInterval {
+		t.Errorf("active: got %v; want %v", got, heartbeatInterval)
+	}
+
+	// Once idle, they back off exponentially up to the max.
+	now := start.Add(heartbeatIdleAfter)
+	var got []time.Duration
+	for range 3 {
+		d := de.nextHeartbeatIntervalLocked(now)
+		got = append(got, d)
+		now = now.Add(d)
+	}
+	want := []time.Duration{6 * time.Second, 12 * time.Second, 24 * time.Second}
+	if !slices.Equal(got, want) {
+		t.Errorf("idle backoff = %v; want %v", got, want)
+	}
+
+	// The period stays at the max, but doesn't overshoot the end of
+	// the session by more than heartbeatInterval.
+	now = start.Add(sessionActiveTimeout - 5*time.Second)
+	if got, want := de.nextHeartbeatIntervalLocked(now), 5*time.Second+heartbeatInterval; got != want {
+		t.Errorf("near session end: got %v; want %v", got, want)
+	}
+	if de.heartbeatEvery != heartbeatMaxInterval {
+		t.Errorf("heartbeatEvery = %v; want %v", de.heartbeatEvery, heartbeatMaxInterval)
+	}
+
+	// Traffic resuming ramps the heartbeat back up, pulling in a
+	// pending backed-off heartbeat.
+	de.heartBeatTimer = time.AfterFunc(time.Hour, func() {})
+	defer de.heartBeatTimer.Stop()
+	de.noteTxActivityExtTriggerLocked(now)
+	if de.heartbeatEvery != heartbeatInterval {
+		t.Errorf("after traffic: heartbeatEvery = %v; want %v", de.heartbeatEvery, heartbeatInterval)
+	}
+	if got := de.nextHeartbeatIntervalLocked(now.Add(heartbeatInterval)); got != heartbeatInterval {
+		t.Errorf("after traffic: got %v; want %v", got, heartbeatInterval)
+	}
+
+	// UDP lifetime probing relies on the fixed schedule.
+	de.probeUDPLifetime = &probeUDPLifetime{}
+	if got := de.nextHeartbeatIntervalLocked(now.Add(heartbeatIdleAfter * 2)); got != heartbeatInterval {
+		t.Errorf("with UDP lifetime probing: got %v; want %v", got, heartbeatInterval)
+	}
+}
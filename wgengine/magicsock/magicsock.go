@@ -13,6 +13,7 @@
 	"expvar"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"net"
 	"net/netip"
 	"reflect"
@@ -228,6 +229,10 @@ type Conn struct {
 	// port is the preferred port from opts.Port; 0 means auto.
 	port atomic.Uint32
 
+	// portRange, if non-zero, is the range of ports that bindSocket is
+	// restricted to. See SetPortRange.
+	portRange syncs.AtomicValue[tailcfg.PortRange]
+
 	// peerMTUEnabled is whether path MTU discovery to peers is enabled.
 	//
 	//lint:ignore U1000 used on Linux/Darwin only
@@ -337,6 +342,7 @@ type Conn struct {
 	homeless         bool                          // if true, don't try to find & stay conneted to a DERP home (myDerp will stay 0)
 	derpStarted      chan struct{}                 // closed on first connection to DERP; for tests & cleaner Close
 	activeDerp       map[int]activeDerp            // DERP regionID -> connection to a node in that region
+	derpHomeProbe    derpHomeProber                // when to fail over from myDerp; see probeDERPHome
 	prevDerp         map[int]*syncs.WaitGroupChan
 
 	// derpRoute contains optional alternate routes to use as an
@@ -405,6 +411,10 @@ type Options struct {
 	// Zero means to pick one automatically.
 	Port uint16
 
+	// PortRange, if non-zero, restricts the ports to listen on to a
+	// range. Its First must be non-zero. A Port outside of it is ignored.
+	PortRange tailcfg.PortRange
+
 	// EndpointsFunc optionally provides a func to be called when
 	// endpoints change. The called func does not own the slice.
 	EndpointsFunc func([]tailcfg.Endpoint)
@@ -526,6 +536,9 @@ func NewConn(opts Options) (*Conn, error) {
 
 	c := newConn(opts.logf())
 	c.port.Store(uint32(opts.Port))
+	if validPortRange(opts.PortRange) {
+		c.portRange.Store(opts.PortRange)
+	}
 	c.controlKnobs = opts.ControlKnobs
 	c.epFunc = opts.endpointsFunc()
 	c.derpActiveFunc = opts.derpActiveFunc()
@@ -558,6 +571,7 @@ func NewConn(opts Options) (*Conn, error) {
 	}
 
 	c.metrics = registerMetrics(opts.Metrics)
+	c.registerDERPMetrics(opts.Metrics)
 
 	if d4, err := c.listenRawDisco("ip4"); err == nil {
 		c.logf("[v1] using BPF disco receiver for IPv4")
@@ -572,6 +586,8 @@ func NewConn(opts Options) (*Conn, error) {
 		c.logf("[v1] couldn't create raw v6 disco listener, using regular listener instead: %v", err)
 	}
 
+	go c.runDERPHomeProber()
+
 	c.logf("magicsock: disco key = %v", c.discoShort)
 	return c, nil
 }
@@ -640,6 +656,29 @@ func registerMetrics(reg *usermetric.Registry) *metrics {
 	return m
 }
 
+// registerDERPMetrics registers the user-facing gauges describing c's
+// DERP connections, which are read from c's state when scraped.
+func (c *Conn) registerDERPMetrics(reg *usermetric.Registry) {
+	reg.NewGaugeFunc(
+		"tailscaled_derp_connections",
+		"Number of DERP relay servers the node is connected to",
+		func() float64 {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return float64(len(c.activeDerp))
+		},
+	)
+	reg.NewGaugeFunc(
+		"tailscaled_derp_home_region",
+		"Region ID of the node's home DERP relay server, or 0 if it has none",
+		func() float64 {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return float64(c.myDerp)
+		},
+	)
+}
+
 // deregisterMetrics unregisters the underlying usermetrics expvar counters
 // from clientmetrics.
 func deregisterMetrics(m *metrics) {
@@ -933,6 +972,23 @@ func (c *Conn) LastRecvActivityOfNodeKey(nk key.NodePublic) string {
 	return mono.Since(saw).Round(time.Second).String()
 }
 
+// PeerWireMTU returns the path MTU to the direct UDP path of the peer with
+// node key nk, as found by peer path MTU discovery. It reports false if
+// peer path MTU discovery is disabled or the peer has no direct path, in
+// which case packets go over DERP, which has no path MTU to fit in.
+func (c *Conn) PeerWireMTU(nk key.NodePublic) (mtu tstun.WireMTU, ok bool) {
+	if !c.PeerMTUEnabled() {
+		return 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	de, ok := c.peerMap.endpointForNodeKey(nk)
+	if !ok {
+		return 0, false
+	}
+	return de.wireMTU()
+}
+
 // Ping handles a "tailscale ping" CLI query.
 func (c *Conn) Ping(peer tailcfg.NodeView, res *ipnstate.PingResult, size int, cb func(*ipnstate.PingResult)) {
 	c.mu.Lock()
@@ -1522,6 +1578,7 @@ func (c *Conn) receiveIP(b []byte, ipp netip.AddrPort, cache *ippEndpointCache)
 	now := mono.Now()
 	ep.lastRecvUDPAny.StoreAtomic(now)
 	ep.noteRecvActivity(ipp, now)
+	ep.pathStats.noteRx(len(b), false)
 	if stats := c.stats.Load(); stats != nil {
 		stats.UpdateRxPhysical(ep.nodeAddr, ipp, 1, len(b))
 	}
@@ -1993,6 +2050,63 @@ func (c *Conn) SetPreferredPort(port uint16) {
 	c.resetEndpointStates()
 }
 
+// SetPortRange restricts the connection's local ports to pr, or lifts the
+// restriction if pr is the zero value. A pr whose First is zero or after
+// its Last is ignored. If the current ports are outside pr, the connection
+// rebinds.
+func (c *Conn) SetPortRange(pr tailcfg.PortRange) {
+	if pr != (tailcfg.PortRange{}) && !validPortRange(pr) {
+		c.logf("magicsock: ignoring invalid port range %d-%d", pr.First, pr.Last)
+		return
+	}
+	if c.portRange.Swap(pr) == pr {
+		return
+	}
+	if pr == (tailcfg.PortRange{}) || (c.portInRange(&c.pconn4) && c.portInRange(&c.pconn6)) {
+		return
+	}
+	c.logf("magicsock: rebinding to port range %d-%d", pr.First, pr.Last)
+	if err := c.rebind(keepCurrentPort); err != nil {
+		c.logf("%v", err)
+		return
+	}
+	c.resetEndpointStates()
+}
+
+// portInRange reports whether ruc is unbound or bound to a port in
+// c.portRange.
+func (c *Conn) portInRange(ruc *RebindingUDPConn) bool {
+	port := ruc.Port()
+	return port == 0 || c.portRange.Load().Contains(port)
+}
+
+// validPortRange reports whether pr is a usable port range for bindSocket.
+// Zero isn't allowed as its First, as binding to port 0 picks a port that
+// may be outside of pr.
+func validPortRange(pr tailcfg.PortRange) bool {
+	return pr.First != 0 && pr.First <= pr.Last
+}
+
+// maxPortRangeBindAttempts is the most ports in a port range that
+// bindSocket tries to bind to before giving up.
+const maxPortRangeBindAttempts = 16
+
+// portRangeCandidates returns up to n ports in pr, other than those in
+// skip, to try binding to. They're consecutive from a random start, so
+// that nodes sharing a range tend not to contend for the same ports.
+func portRangeCandidates(pr tailcfg.PortRange, n int, skip []uint16) []uint16 {
+	size := int(pr.Last) - int(pr.First) + 1
+	start := rand.IntN(size)
+	var ret []uint16
+	for i := 0; i < size && len(ret) < n; i++ {
+		port := pr.First + uint16((start+i)%size)
+		if !slices.Contains(skip, port) {
+			ret = append(ret, port)
+		}
+	}
+	return ret
+}
+
 // SetPrivateKey sets the connection's private key.
 //
 // This is only used to be able prove our identity when connecting to
@@ -2157,6 +2271,13 @@ func (c *Conn) SetProbeUDPLifetime(v bool) {
 	})
 }
 
+// SetPortMapperPreferences sets the preferences for how the portmapper maps
+// a port on the local gateway. A mapping made with different preferences
+// is replaced the next time endpoints are determined.
+func (c *Conn) SetPortMapperPreferences(p portmapper.Preferences) {
+	c.portMapper.SetPreferences(p)
+}
+
 // SetNetworkMap is called when the control client gets a new network
 // map from the control server. It must always be non-nil.
 //
@@ -2397,7 +2518,7 @@ type connBind struct {
 func (c *connBind) BatchSize() int {
 	// TODO(raggi): determine by properties rather than hardcoding platform behavior
 	switch runtime.GOOS {
-	case "linux":
+	case "linux", "netbsd":
 		return conn.IdealBatchSize
 	default:
 		return 1
@@ -2651,18 +2772,27 @@ func (c *Conn) bindSocket(ruc *RebindingUDPConn, network string, curPortFate cur
 	// Build a list of preferred ports.
 	// Best is the port that the user requested.
 	// Second best is the port that is currently in use.
-	// If those fail, fall back to 0.
+	// If those fail, fall back to 0, or with a port range, to some
+	// other ports in it.
+	pr := c.portRange.Load()
+	hasRange := pr != (tailcfg.PortRange{})
 	var ports []uint16
-	if port := uint16(c.port.Load()); port != 0 {
+	if port := uint16(c.port.Load()); port != 0 && (!hasRange || pr.Contains(port)) {
 		ports = append(ports, port)
 	}
 	if ruc.pconn != nil && curPortFate == keepCurrentPort {
 		curPort := uint16(ruc.localAddrLocked().Port)
-		ports = append(ports, curPort)
+		if !hasRange || pr.Contains(curPort) {
+			ports = append(ports, curPort)
+		}
 	}
-	ports = append(ports, 0)
 	// Remove duplicates. (All duplicates are consecutive.)
 	ports = slices.Compact(ports)
+	if hasRange {
+		ports = append(ports, portRangeCandidates(pr, maxPortRangeBindAttempts, ports)...)
+	} else {
+		ports = slices.Compact(append(ports, 0))
+	}
 
 	if debugBindSocket() {
 		c.logf("magicsock: bindSocket: candidate ports: %+v", ports)
@@ -2956,6 +3086,16 @@ func (c *Conn) SetHomeless(v bool) {
 	// Pings are only sent if we have not observed bidirectional traffic with an
 	// endpoint in at least this duration.
 	wireguardPingInterval = 5 * time.Second
+
+	// heartbeatIdleAfter is how long an active session may go without
+	// sending before its heartbeats start backing off from
+	// heartbeatInterval.
+	heartbeatIdleAfter = 6 * time.Second
+
+	// heartbeatMaxInterval is the longest heartbeat period that an idle
+	// session backs off to. It stays below endpointsFreshEnoughDuration
+	// so that heartbeats still keep typical NAT mappings open.
+	heartbeatMaxInterval = 24 * time.Second
 )
 
 // indexSentinelDeleted is the temporary value that endpointState.index takes while
@@ -2997,6 +3137,41 @@ func (c *Conn) DebugPickNewDERP() error {
 	return errors.New("too few regions")
 }
 
+// DebugSendCallMeMaybe sends peer, over DERP, a CallMeMaybe disco message
+// listing only eps, prompting it to send disco pings to each of them. It
+// returns peer's disco key, from which those pings are sealed.
+//
+// It exists so debug tooling can check that packets from peers reach eps,
+// such as through a port mapping. Until our next regular CallMeMaybe, peer
+// forgets the endpoints from our previous one.
+func (c *Conn) DebugSendCallMeMaybe(peer key.NodePublic, eps []netip.AddrPort) (key.DiscoPublic, error) {
+	c.mu.Lock()
+	if c.privateKey.IsZero() {
+		c.mu.Unlock()
+		return key.DiscoPublic{}, errors.New("tailscaled stopped")
+	}
+	ep, ok := c.peerMap.endpointForNodeKey(peer)
+	c.mu.Unlock()
+	if !ok {
+		return key.DiscoPublic{}, errors.New("unknown peer")
+	}
+	epDisco := ep.disco.Load()
+	if epDisco == nil {
+		return key.DiscoPublic{}, errors.New("peer has no disco key")
+	}
+	ep.mu.Lock()
+	derpAddr := ep.derpAddr
+	ep.mu.Unlock()
+	if !derpAddr.IsValid() {
+		return key.DiscoPublic{}, errors.New("peer has no DERP home")
+	}
+	c.logf("magicsock: [debug] sending call-me-maybe for %v to %v", eps, peer.ShortString())
+	if _, err := c.sendDiscoMessage(derpAddr, peer, epDisco.key, &disco.CallMeMaybe{MyNumber: eps}, discoLog); err != nil {
+		return key.DiscoPublic{}, err
+	}
+	return epDisco.key, nil
+}
+
 func (c *Conn) DebugForcePreferDERP(n int) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -3122,9 +3297,17 @@ type discoInfo struct {
 	// metricDERPHomeFallback is how many times we picked a DERP fallback.
 	metricDERPHomeFallback = clientmetric.NewCounter("derp_home_fallback")
 
+	// metricDERPHomeFailover is how many times we changed DERP home
+	// because the home region stopped answering pings.
+	metricDERPHomeFailover = clientmetric.NewCounter("derp_home_failover")
+
 	// metricDERPStaleCleaned is how many times we closed a stale DERP connection.
 	metricDERPStaleCleaned = clientmetric.NewCounter("derp_stale_cleaned")
 
+	// metricMultipathWindows is how many multipath failover windows we
+	// started.
+	metricMultipathWindows = clientmetric.NewCounter("magicsock_multipath_windows")
+
 	// Disco packets received bpf read path
 	//lint:ignore U1000 used on Linux only
 	metricRecvDiscoPacketIPv4 = clientmetric.NewCounter("magicsock_disco_recv_bpf_ipv4")
@@ -3139,3 +3139,21 @@ func TestNetworkDownSendErrors(t *testing.T) {
 		t.Errorf("expected NetworkDown to increment packet dropped metric; got %q", resp.Body.String())
 	}
 }
+
+func TestPortRangeCandidates(t *testing.T) {
+	pr := tailcfg.PortRange{First: 65530, Last: 65535}
+	for range 50 {
+		got := portRangeCandidates(pr, maxPortRangeBindAttempts, []uint16{65531})
+		if len(got) != 5 {
+			t.Fatalf("got %v; want the 5 other ports in range", got)
+		}
+		for _, port := range got {
+			if !pr.Contains(port) || port == 65531 {
+				t.Fatalf("got %v; want ports in %+v other than 65531", got, pr)
+			}
+		}
+	}
+	if got := portRangeCandidates(tailcfg.PortRange{First: 1, Last: 65535}, maxPortRangeBindAttempts, nil); len(got) != maxPortRangeBindAttempts {
+		t.Errorf("got %d candidates; want %d", len(got), maxPortRangeBindAttempts)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"net/netip"
+	"time"
+
+	"tailscale.com/tstime/mono"
+)
+
+// multipathWindow is how long a multipath failover window lasts, unless a
+// pong confirms a direct path first.
+const multipathWindow = 5 * time.Second
+
+// multipathFailoverEnabled reports whether endpoints should start multipath
+// failover windows when their direct path degrades.
+func (c *Conn) multipathFailoverEnabled() bool {
+	if v, ok := debugEnableMultipathFailover().Get(); ok {
+		return v
+	}
+	return c.controlKnobs != nil && c.controlKnobs.MultipathFailover.Load()
+}
+
+// startMultipathLocked starts a multipath failover window, if enabled, in
+// which send duplicates packets over both old, the direct path that has
+// degraded, and the peer's other path: DERP, or a new direct path once one
+// is found. The peer's WireGuard replay protection drops whichever copy of
+// a packet arrives second.
+//
+// de.mu must be held.
+func (de *endpoint) startMultipathLocked(now mono.Time, old netip.AddrPort, why string) {
+	if de.isWireguardOnly || !old.IsValid() || !de.c.multipathFailoverEnabled() {
+		return
+	}
+	if de.multipathUntil.IsZero() {
+		metricMultipathWindows.Add(1)
+		de.c.dlogf("[v1] magicsock: disco: node %v %v duplicating sends for %v after %v on %v", de.publicKey.ShortString(), de.discoShort(), multipathWindow, why, old)
+	}
+	de.multipathUntil = now.Add(multipathWindow)
+	de.multipathAddr = old
+	de.debugUpdates.Add(EndpointChange{
+		When: time.Now(),
+		What: "multipath-start-" + why,
+		From: old,
+	})
+}
+
+// endMultipathLocked ends the multipath failover window, if any.
+//
+// de.mu must be held.
+func (de *endpoint) endMultipathLocked() {
+	de.multipathUntil = 0
+	de.multipathAddr = netip.AddrPort{}
+}
+
+// multipathAddrsLocked returns the paths to send to during a multipath
+// failover window, given the udpAddr and derpAddr from addrForSendLocked:
+// DERP, and dupAddr, the degraded direct path, if it's not udpAddr.
+// Outside of a window, it returns derpAddr unchanged.
+//
+// de.mu must be held.
+func (de *endpoint) multipathAddrsLocked(now mono.Time, udpAddr, derpAddr netip.AddrPort) (_, dupAddr netip.AddrPort) {
+	if de.multipathUntil.IsZero() {
+		return derpAddr, netip.AddrPort{}
+	}
+	if now.After(de.multipathUntil) {
+		de.endMultipathLocked()
+		return derpAddr, netip.AddrPort{}
+	}
+	if de.multipathAddr != udpAddr {
+		dupAddr = de.multipathAddr
+	}
+	return de.derpAddr, dupAddr
+}
@@ -0,0 +1,60 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/control/controlknobs"
+	"tailscale.com/tstime/mono"
+	"tailscale.com/util/ringbuffer"
+)
+
+func TestMultipathAddrs(t *testing.T) {
+	oldAddr := netip.MustParseAddrPort("1.1.1.1:111")
+	newAddr := netip.MustParseAddrPort("3.3.3.3:333")
+	derpAddr := netip.MustParseAddrPort("127.3.3.40:1")
+
+	knobs := new(controlknobs.Knobs)
+	de := &endpoint{
+		c:            &Conn{controlKnobs: knobs, logf: t.Logf},
+		derpAddr:     derpAddr,
+		debugUpdates: ringbuffer.New[EndpointChange](10),
+	}
+	now := mono.Now()
+
+	de.startMultipathLocked(now, oldAddr, "test")
+	if !de.multipathUntil.IsZero() {
+		t.Fatal("window started with MultipathFailover disabled")
+	}
+	knobs.MultipathFailover.Store(true)
+	de.startMultipathLocked(now, oldAddr, "test")
+	if de.multipathUntil.IsZero() {
+		t.Fatal("window not started")
+	}
+
+	// While still sending to the degraded path, DERP is added.
+	gotDERP, gotDup := de.multipathAddrsLocked(now, oldAddr, netip.AddrPort{})
+	if gotDERP != derpAddr || gotDup.IsValid() {
+		t.Errorf("same path: got %v, %v; want %v, none", gotDERP, gotDup, derpAddr)
+	}
+	// With no direct path, or a new one, the old one is duplicated to.
+	for _, udpAddr := range []netip.AddrPort{{}, newAddr} {
+		gotDERP, gotDup = de.multipathAddrsLocked(now, udpAddr, derpAddr)
+		if gotDERP != derpAddr || gotDup != oldAddr {
+			t.Errorf("udpAddr %v: got %v, %v; want %v, %v", udpAddr, gotDERP, gotDup, derpAddr, oldAddr)
+		}
+	}
+
+	// After the window, sends are left alone.
+	gotDERP, gotDup = de.multipathAddrsLocked(now.Add(multipathWindow+time.Second), newAddr, netip.AddrPort{})
+	if gotDERP.IsValid() || gotDup.IsValid() {
+		t.Errorf("after window: got %v, %v; want none", gotDERP, gotDup)
+	}
+	if !de.multipathUntil.IsZero() {
+		t.Error("window not ended")
+	}
+}
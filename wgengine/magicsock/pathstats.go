@@ -0,0 +1,94 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"sync/atomic"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+// rttBucketsMS are the inclusive upper bounds, in milliseconds, of the
+// buckets of ipnstate.PeerPathStats.RTTHistogram. A last, unbounded bucket
+// follows them.
+var rttBucketsMS = [...]int{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000}
+
+// pathStats are the statistics behind an endpoint's
+// ipnstate.PeerPathStats.
+type pathStats struct {
+	// The byte counters are updated atomically, without endpoint.mu.
+	directRxBytes atomic.Int64
+	directTxBytes atomic.Int64
+	derpRxBytes   atomic.Int64
+	derpTxBytes   atomic.Int64
+
+	// The following fields are guarded by endpoint.mu.
+	lastChange       time.Time // when bestAddr last changed; zero if never
+	lastChangeReason string    // why bestAddr last changed
+	rtts             [len(rttBucketsMS) + 1]int64
+}
+
+// noteRx records n bytes received from the peer over DERP or, if not
+// isDERP, a direct path.
+func (ps *pathStats) noteRx(n int, isDERP bool) {
+	if isDERP {
+		ps.derpRxBytes.Add(int64(n))
+	} else {
+		ps.directRxBytes.Add(int64(n))
+	}
+}
+
+// noteTx records n bytes sent to the peer over DERP or, if not isDERP, a
+// direct path.
+func (ps *pathStats) noteTx(n int, isDERP bool) {
+	if isDERP {
+		ps.derpTxBytes.Add(int64(n))
+	} else {
+		ps.directTxBytes.Add(int64(n))
+	}
+}
+
+// notePathChangeLocked records that the peer's direct path changed at
+// now, for the given reason.
+//
+// endpoint.mu must be held.
+func (ps *pathStats) notePathChangeLocked(now time.Time, reason string) {
+	ps.lastChange = now
+	ps.lastChangeReason = reason
+}
+
+// observeRTTLocked records the round-trip time d of a disco ping over a
+// direct path.
+//
+// endpoint.mu must be held.
+func (ps *pathStats) observeRTTLocked(d time.Duration) {
+	for i, bound := range rttBucketsMS {
+		if d <= time.Duration(bound)*time.Millisecond {
+			ps.rtts[i]++
+			return
+		}
+	}
+	ps.rtts[len(rttBucketsMS)]++
+}
+
+// populateLocked fills in out's fields other than CurAddr and
+// LastHandshake.
+//
+// endpoint.mu must be held.
+func (ps *pathStats) populateLocked(out *ipnstate.PeerPathStats) {
+	out.DirectRxBytes = ps.directRxBytes.Load()
+	out.DirectTxBytes = ps.directTxBytes.Load()
+	out.DERPRxBytes = ps.derpRxBytes.Load()
+	out.DERPTxBytes = ps.derpTxBytes.Load()
+	out.LastPathChange = ps.lastChange
+	out.LastPathChangeReason = ps.lastChangeReason
+	out.RTTHistogram = make([]ipnstate.RTTBucket, len(ps.rtts))
+	for i, n := range ps.rtts {
+		out.RTTHistogram[i].Count = n
+		if i < len(rttBucketsMS) {
+			out.RTTHistogram[i].MaxMS = rttBucketsMS[i]
+		}
+	}
+}
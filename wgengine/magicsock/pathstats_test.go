@@ -0,0 +1,49 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+func TestPathStats(t *testing.T) {
+	var ps pathStats
+	ps.noteRx(100, false)
+	ps.noteRx(10, true)
+	ps.noteTx(200, false)
+	ps.noteTx(20, true)
+	ps.noteTx(20, true)
+	for _, d := range []time.Duration{
+		500 * time.Microsecond,
+		time.Millisecond,
+		3 * time.Millisecond,
+		time.Second,
+		2 * time.Second,
+	} {
+		ps.observeRTTLocked(d)
+	}
+	now := time.Unix(1700000000, 0)
+	ps.notePathChangeLocked(now, "pong-better-addr")
+
+	var got ipnstate.PeerPathStats
+	ps.populateLocked(&got)
+	if got.DirectRxBytes != 100 || got.DERPRxBytes != 10 || got.DirectTxBytes != 200 || got.DERPTxBytes != 40 {
+		t.Errorf("byte counts = %+v", got)
+	}
+	if !got.LastPathChange.Equal(now) || got.LastPathChangeReason != "pong-better-addr" {
+		t.Errorf("last path change = %v, %q", got.LastPathChange, got.LastPathChangeReason)
+	}
+	want := map[int]int64{1: 2, 5: 1, 1000: 1, 0: 1}
+	if len(got.RTTHistogram) != len(rttBucketsMS)+1 {
+		t.Fatalf("len(RTTHistogram) = %d; want %d", len(got.RTTHistogram), len(rttBucketsMS)+1)
+	}
+	for _, b := range got.RTTHistogram {
+		if b.Count != want[b.MaxMS] {
+			t.Errorf("bucket %d count = %d; want %d", b.MaxMS, b.Count, want[b.MaxMS])
+		}
+	}
+}
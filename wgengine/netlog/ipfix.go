@@ -0,0 +1,366 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netlog
+
+import (
+	"cmp"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"tailscale.com/types/logger"
+	"tailscale.com/types/netlogtype"
+)
+
+// IPFIX (RFC 7011) message framing.
+const (
+	ipfixVersion        = 10
+	ipfixTemplateSetID  = 2
+	ipfixTemplate4      = 256 // template ID for IPv4 flows
+	ipfixTemplate6      = 257 // template ID for IPv6 flows
+	ipfixMsgHeaderLen   = 16
+	ipfixSetHeaderLen   = 4
+	ipfixVarLen         = 0xffff // field length of variable-length fields
+	ipfixEnterpriseFlag = 0x8000
+
+	// ipfixMaxMessage is the largest message we send, to stay within a
+	// typical path MTU as RFC 7011, section 10.3.3 recommends for UDP.
+	ipfixMaxMessage = 1400
+
+	// ipfixTemplateRefresh is how often templates are resent. Over UDP, a
+	// collector that restarts only learns them again from a resend.
+	ipfixTemplateRefresh = time.Minute
+)
+
+// IANA-assigned IPFIX information elements.
+// See https://www.iana.org/assignments/ipfix.
+const (
+	ieOctetDeltaCount          = 1
+	iePacketDeltaCount         = 2
+	ieProtocolIdentifier       = 4
+	ieSourceTransportPort      = 7
+	ieSourceIPv4Address        = 8
+	ieDestinationTransportPort = 11
+	ieDestinationIPv4Address   = 12
+	ieSourceIPv6Address        = 27
+	ieDestinationIPv6Address   = 28
+	ieFlowDirection            = 61
+	ieFlowStartMilliseconds    = 152
+	ieFlowEndMilliseconds      = 153
+)
+
+// Enterprise-specific information elements, sent under the enterprise
+// number given to NewIPFIXExporter.
+const (
+	// ieTrafficType is the kind of traffic, like the fields of
+	// netlogtype.Message: 1 for traffic between Tailscale IPs, 2 for
+	// subnet router traffic and 3 for exit node traffic.
+	ieTrafficType = 1
+
+	// The Tailscale node names (as MagicDNS FQDNs, without the trailing
+	// dot) and user login names of the source and destination.
+	ieSourceNode      = 2
+	ieSourceUser      = 3
+	ieDestinationNode = 4
+	ieDestinationUser = 5
+)
+
+// flowDirection values.
+const (
+	ipfixIngress = 0
+	ipfixEgress  = 1
+)
+
+type ipfixField struct {
+	id         uint16
+	length     uint16 // or ipfixVarLen
+	enterprise bool
+}
+
+// AttributeFunc returns the Tailscale node name and user login name for a
+// Tailscale IP address, or empty strings if unknown.
+type AttributeFunc func(netip.Addr) (node, user string)
+
+// IPFIXExporter is an Exporter that sends flow records to an IPFIX
+// collector over UDP, for sites that want Tailscale flows in their
+// existing flow collection.
+//
+// Each connection the Logger records becomes up to two unidirectional
+// flow records, one for each direction with traffic, using the standard
+// information elements for addresses, ports, protocol and counts. If the
+// exporter has an enterprise number, records also carry the traffic type
+// and the Tailscale node and user names of both ends as
+// enterprise-specific elements.
+type IPFIXExporter struct {
+	conn       net.Conn
+	enterprise uint32 // or 0 to omit enterprise-specific elements
+	domainID   uint32
+	logf       logger.Logf
+
+	mu           sync.Mutex
+	attribute    AttributeFunc // or nil
+	seq          uint32        // data records sent, mod 2^32 (RFC 7011, section 3.1)
+	lastTemplate time.Time     // when templates were last sent
+}
+
+// NewIPFIXExporter returns an exporter that sends IPFIX messages to the
+// collector at the UDP address collector, as host:port. If enterprise is
+// non-zero, it's the IANA private enterprise number under which
+// Tailscale-specific elements are sent; collectors need to be told about
+// them to decode them. domainID is the observation domain ID of the
+// messages.
+func NewIPFIXExporter(collector string, enterprise, domainID uint32, logf logger.Logf) (*IPFIXExporter, error) {
+	conn, err := net.Dial("udp", collector)
+	if err != nil {
+		return nil, err
+	}
+	return &IPFIXExporter{
+		conn:       conn,
+		enterprise: enterprise,
+		domainID:   domainID,
+		logf:       logger.WithPrefix(logf, "ipfix: "),
+	}, nil
+}
+
+// SetAttribution sets the function used to look up the node and user names
+// of Tailscale IPs. Without one, the names are empty.
+func (x *IPFIXExporter) SetAttribution(f AttributeFunc) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.attribute = f
+}
+
+// Close closes the exporter's socket.
+func (x *IPFIXExporter) Close() error {
+	return x.conn.Close()
+}
+
+// ipfixRecord is an encoded data record for a template.
+type ipfixRecord struct {
+	template uint16
+	data     []byte
+}
+
+// ExportFlows implements Exporter. Physical traffic isn't exported, as
+// it's the WireGuard transport rather than flows between hosts.
+func (x *IPFIXExporter) ExportFlows(m *netlogtype.Message) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	names := map[netip.Addr][2]string{}
+	lookup := func(ip netip.Addr) (node, user string) {
+		if x.attribute == nil || !ip.IsValid() {
+			return "", ""
+		}
+		n, ok := names[ip]
+		if !ok {
+			n[0], n[1] = x.attribute(ip)
+			names[ip] = n
+		}
+		return n[0], n[1]
+	}
+
+	var recs []ipfixRecord
+	add := func(trafficType uint8, proto uint8, src, dst netip.AddrPort, bytes, packets uint64, dir uint8) {
+		if packets == 0 {
+			return
+		}
+		is6 := src.Addr().Is6() || dst.Addr().Is6()
+		if !is6 && !src.Addr().Is4() && !dst.Addr().Is4() {
+			return // fully anonymized
+		}
+		r := ipfixRecord{template: ipfixTemplate4}
+		if is6 {
+			r.template = ipfixTemplate6
+		}
+		b := appendIPFIXAddr(nil, src.Addr(), is6)
+		b = appendIPFIXAddr(b, dst.Addr(), is6)
+		b = binary.BigEndian.AppendUint16(b, src.Port())
+		b = binary.BigEndian.AppendUint16(b, dst.Port())
+		b = append(b, proto)
+		b = binary.BigEndian.AppendUint64(b, bytes)
+		b = binary.BigEndian.AppendUint64(b, packets)
+		b = binary.BigEndian.AppendUint64(b, uint64(m.Start.UnixMilli()))
+		b = binary.BigEndian.AppendUint64(b, uint64(m.End.UnixMilli()))
+		b = append(b, dir)
+		if x.enterprise != 0 {
+			srcNode, srcUser := lookup(src.Addr())
+			dstNode, dstUser := lookup(dst.Addr())
+			b = append(b, trafficType)
+			for _, s := range []string{srcNode, srcUser, dstNode, dstUser} {
+				b = appendIPFIXString(b, s)
+			}
+		}
+		r.data = b
+		recs = append(recs, r)
+	}
+	for i, traffic := range [][]netlogtype.ConnectionCounts{m.VirtualTraffic, m.SubnetTraffic, m.ExitTraffic} {
+		for _, cc := range traffic {
+			proto := uint8(cc.Proto)
+			add(uint8(i+1), proto, cc.Src, cc.Dst, cc.TxBytes, cc.TxPackets, ipfixEgress)
+			add(uint8(i+1), proto, cc.Dst, cc.Src, cc.RxBytes, cc.RxPackets, ipfixIngress)
+		}
+	}
+	if len(recs) == 0 {
+		return
+	}
+	slices.SortStableFunc(recs, func(a, b ipfixRecord) int { return cmp.Compare(a.template, b.template) })
+	for _, msg := range x.encodeLocked(time.Now(), recs) {
+		if _, err := x.conn.Write(msg); err != nil {
+			x.logf("sending to collector: %v", err)
+			return
+		}
+	}
+}
+
+// encodeLocked packs recs, which must be sorted by template, into as
+// many messages as needed, starting with the templates if they're due.
+func (x *IPFIXExporter) encodeLocked(now time.Time, recs []ipfixRecord) [][]byte {
+	var (
+		msgs   [][]byte
+		msg    []byte
+		setOff int // offset of the open set's header in msg, or 0 if none
+		setID  uint16
+	)
+	closeSet := func() {
+		if setOff != 0 {
+			binary.BigEndian.PutUint16(msg[setOff+2:], uint16(len(msg)-setOff))
+			setOff = 0
+		}
+	}
+	finish := func() {
+		closeSet()
+		binary.BigEndian.PutUint16(msg[2:], uint16(len(msg)))
+		msgs = append(msgs, msg)
+		msg = nil
+	}
+	start := func() {
+		msg = binary.BigEndian.AppendUint16(msg, ipfixVersion)
+		msg = binary.BigEndian.AppendUint16(msg, 0) // length, set by finish
+		msg = binary.BigEndian.AppendUint32(msg, uint32(now.Unix()))
+		msg = binary.BigEndian.AppendUint32(msg, x.seq)
+		msg = binary.BigEndian.AppendUint32(msg, x.domainID)
+		if now.Sub(x.lastTemplate) >= ipfixTemplateRefresh {
+			x.lastTemplate = now
+			msg = x.appendTemplateSet(msg)
+		}
+	}
+
+	start()
+	for _, r := range recs {
+		need := len(r.data)
+		if setOff == 0 || setID != r.template {
+			need += ipfixSetHeaderLen
+		}
+		if len(msg)+need > ipfixMaxMessage && len(msg) > ipfixMsgHeaderLen {
+			finish()
+			start()
+		}
+		if setOff == 0 || setID != r.template {
+			closeSet()
+			setOff, setID = len(msg), r.template
+			msg = binary.BigEndian.AppendUint16(msg, r.template)
+			msg = binary.BigEndian.AppendUint16(msg, 0) // length, set by closeSet
+		}
+		msg = append(msg, r.data...)
+		x.seq++
+	}
+	finish()
+	return msgs
+}
+
+// ipfixFields returns the fields of the IPv4 or IPv6 template.
+func (x *IPFIXExporter) ipfixFields(is6 bool) []ipfixField {
+	src, dst, addrLen := uint16(ieSourceIPv4Address), uint16(ieDestinationIPv4Address), uint16(4)
+	if is6 {
+		src, dst, addrLen = ieSourceIPv6Address, ieDestinationIPv6Address, 16
+	}
+	fields := []ipfixField{
+		{id: src, length: addrLen},
+		{id: dst, length: addrLen},
+		{id: ieSourceTransportPort, length: 2},
+		{id: ieDestinationTransportPort, length: 2},
+		{id: ieProtocolIdentifier, length: 1},
+		{id: ieOctetDeltaCount, length: 8},
+		{id: iePacketDeltaCount, length: 8},
+		{id: ieFlowStartMilliseconds, length: 8},
+		{id: ieFlowEndMilliseconds, length: 8},
+		{id: ieFlowDirection, length: 1},
+	}
+	if x.enterprise != 0 {
+		fields = append(fields,
+			ipfixField{id: ieTrafficType, length: 1, enterprise: true},
+			ipfixField{id: ieSourceNode, length: ipfixVarLen, enterprise: true},
+			ipfixField{id: ieSourceUser, length: ipfixVarLen, enterprise: true},
+			ipfixField{id: ieDestinationNode, length: ipfixVarLen, enterprise: true},
+			ipfixField{id: ieDestinationUser, length: ipfixVarLen, enterprise: true},
+		)
+	}
+	return fields
+}
+
+// appendTemplateSet appends a template set defining both templates.
+func (x *IPFIXExporter) appendTemplateSet(b []byte) []byte {
+	off := len(b)
+	b = binary.BigEndian.AppendUint16(b, ipfixTemplateSetID)
+	b = binary.BigEndian.AppendUint16(b, 0) // length, set below
+	for _, t := range []struct {
+		id  uint16
+		is6 bool
+	}{{ipfixTemplate4, false}, {ipfixTemplate6, true}} {
+		fields := x.ipfixFields(t.is6)
+		b = binary.BigEndian.AppendUint16(b, t.id)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(fields)))
+		for _, f := range fields {
+			id := f.id
+			if f.enterprise {
+				id |= ipfixEnterpriseFlag
+			}
+			b = binary.BigEndian.AppendUint16(b, id)
+			b = binary.BigEndian.AppendUint16(b, f.length)
+			if f.enterprise {
+				b = binary.BigEndian.AppendUint32(b, x.enterprise)
+			}
+		}
+	}
+	binary.BigEndian.PutUint16(b[off+2:], uint16(len(b)-off))
+	return b
+}
+
+// appendIPFIXAddr appends ip as a 4 or 16 byte address, or zeros if it's
+// invalid, as for the anonymized end of an exit node flow.
+func appendIPFIXAddr(b []byte, ip netip.Addr, is6 bool) []byte {
+	switch {
+	case !ip.IsValid():
+		if is6 {
+			return append(b, make([]byte, 16)...)
+		}
+		return append(b, 0, 0, 0, 0)
+	case is6:
+		a := ip.As16()
+		return append(b, a[:]...)
+	default:
+		a := ip.As4()
+		return append(b, a[:]...)
+	}
+}
+
+// appendIPFIXString appends s as a variable-length field (RFC 7011,
+// section 7), truncated to fit in a message.
+func appendIPFIXString(b []byte, s string) []byte {
+	const maxLen = 255 // keeps records well within ipfixMaxMessage
+	if len(s) > maxLen {
+		s = s[:maxLen]
+	}
+	if len(s) < 255 {
+		b = append(b, byte(len(s)))
+	} else {
+		b = append(b, 255)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	}
+	return append(b, s...)
+}
@@ -0,0 +1,222 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netlog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"tailscale.com/types/ipproto"
+	"tailscale.com/types/netlogtype"
+)
+
+// ipfixSet is a set of a decoded IPFIX message.
+type ipfixSet struct {
+	id   uint16
+	body []byte
+}
+
+func decodeIPFIX(t *testing.T, msg []byte) (seq uint32, sets []ipfixSet) {
+	t.Helper()
+	if len(msg) < ipfixMsgHeaderLen {
+		t.Fatalf("short message: %x", msg)
+	}
+	if v := binary.BigEndian.Uint16(msg); v != ipfixVersion {
+		t.Fatalf("version = %d", v)
+	}
+	if n := binary.BigEndian.Uint16(msg[2:]); int(n) != len(msg) {
+		t.Fatalf("length = %d; message is %d bytes", n, len(msg))
+	}
+	seq = binary.BigEndian.Uint32(msg[8:])
+	for b := msg[ipfixMsgHeaderLen:]; len(b) > 0; {
+		n := int(binary.BigEndian.Uint16(b[2:]))
+		if n < ipfixSetHeaderLen || n > len(b) {
+			t.Fatalf("bad set length %d", n)
+		}
+		sets = append(sets, ipfixSet{binary.BigEndian.Uint16(b), b[ipfixSetHeaderLen:n]})
+		b = b[n:]
+	}
+	return seq, sets
+}
+
+// decodeIPFIXRecord decodes one data record of the template fields from b
+// into strings, returning the rest of b.
+func decodeIPFIXRecord(fields []ipfixField, b []byte) (rec []string, rest []byte) {
+	for _, f := range fields {
+		n := int(f.length)
+		if n == ipfixVarLen {
+			n, b = int(b[0]), b[1:]
+			if n == 255 {
+				n, b = int(binary.BigEndian.Uint16(b)), b[2:]
+			}
+			rec = append(rec, string(b[:n]))
+			b = b[n:]
+			continue
+		}
+		v := b[:n]
+		b = b[n:]
+		switch {
+		case n == 4 && (f.id == ieSourceIPv4Address || f.id == ieDestinationIPv4Address):
+			rec = append(rec, netip.AddrFrom4([4]byte(v)).String())
+		case n == 16:
+			rec = append(rec, netip.AddrFrom16([16]byte(v)).String())
+		case n == 1:
+			rec = append(rec, fmt.Sprint(v[0]))
+		case n == 2:
+			rec = append(rec, fmt.Sprint(binary.BigEndian.Uint16(v)))
+		case n == 8:
+			rec = append(rec, fmt.Sprint(binary.BigEndian.Uint64(v)))
+		}
+	}
+	return rec, b
+}
+
+func TestIPFIXExporter(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	x, err := NewIPFIXExporter(pc.LocalAddr().String(), 32473, 7, t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+	x.SetAttribution(func(ip netip.Addr) (node, user string) {
+		switch ip {
+		case netip.MustParseAddr("100.64.0.1"):
+			return "self.example.ts.net", "alice@example.com"
+		case netip.MustParseAddr("100.64.0.2"):
+			return "peer.example.ts.net", "bob@example.com"
+		}
+		return "", ""
+	})
+
+	start := time.UnixMilli(1700000000000)
+	end := start.Add(5 * time.Second)
+	m := &netlogtype.Message{
+		Start: start,
+		End:   end,
+		VirtualTraffic: []netlogtype.ConnectionCounts{{
+			Connection: netlogtype.Connection{Proto: ipproto.TCP, Src: netip.MustParseAddrPort("100.64.0.1:1234"), Dst: netip.MustParseAddrPort("100.64.0.2:22")},
+			Counts:     netlogtype.Counts{TxPackets: 3, TxBytes: 300, RxPackets: 2, RxBytes: 200},
+		}},
+		ExitTraffic: []netlogtype.ConnectionCounts{{
+			Connection: netlogtype.Connection{Proto: ipproto.UDP, Src: netip.MustParseAddrPort("[fd7a:115c:a1e0::1]:0")},
+			Counts:     netlogtype.Counts{TxPackets: 1, TxBytes: 100},
+		}},
+		PhysicalTraffic: []netlogtype.ConnectionCounts{{
+			Connection: netlogtype.Connection{Src: netip.MustParseAddrPort("100.64.0.1:0"), Dst: netip.MustParseAddrPort("192.0.2.1:41641")},
+			Counts:     netlogtype.Counts{TxPackets: 9, TxBytes: 900},
+		}},
+	}
+	x.ExportFlows(m)
+
+	buf := make([]byte, 65536)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seq, sets := decodeIPFIX(t, buf[:n])
+	if seq != 0 {
+		t.Errorf("first sequence number = %d", seq)
+	}
+	if got := binary.BigEndian.Uint32(buf[12:]); got != 7 {
+		t.Errorf("observation domain = %d; want 7", got)
+	}
+	if len(sets) != 3 || sets[0].id != ipfixTemplateSetID || sets[1].id != ipfixTemplate4 || sets[2].id != ipfixTemplate6 {
+		t.Fatalf("sets = %+v; want templates, IPv4 and IPv6 data", sets)
+	}
+
+	// The template for IPv4 must list its fields, with the
+	// enterprise-specific ones carrying our enterprise number.
+	tb := sets[0].body
+	if id, count := binary.BigEndian.Uint16(tb), binary.BigEndian.Uint16(tb[2:]); id != ipfixTemplate4 || int(count) != len(x.ipfixFields(false)) {
+		t.Fatalf("first template = %d with %d fields", id, count)
+	}
+	tb = tb[4:]
+	for _, f := range x.ipfixFields(false) {
+		id, length := binary.BigEndian.Uint16(tb), binary.BigEndian.Uint16(tb[2:])
+		tb = tb[4:]
+		if f.enterprise {
+			if id != f.id|ipfixEnterpriseFlag || binary.BigEndian.Uint32(tb) != 32473 {
+				t.Errorf("enterprise field %d encoded as %d, %d", f.id, id, binary.BigEndian.Uint32(tb))
+			}
+			tb = tb[4:]
+		} else if id != f.id || length != f.length {
+			t.Errorf("field %d encoded as %d", f.id, id)
+		}
+	}
+
+	startMs, endMs := fmt.Sprint(start.UnixMilli()), fmt.Sprint(end.UnixMilli())
+	rec, rest := decodeIPFIXRecord(x.ipfixFields(false), sets[1].body)
+	want := []string{"100.64.0.1", "100.64.0.2", "1234", "22", "6", "300", "3", startMs, endMs, "1", "1", "self.example.ts.net", "alice@example.com", "peer.example.ts.net", "bob@example.com"}
+	if !reflect.DeepEqual(rec, want) {
+		t.Errorf("outgoing record = %q\nwant %q", rec, want)
+	}
+	rec, rest = decodeIPFIXRecord(x.ipfixFields(false), rest)
+	want = []string{"100.64.0.2", "100.64.0.1", "22", "1234", "6", "200", "2", startMs, endMs, "0", "1", "peer.example.ts.net", "bob@example.com", "self.example.ts.net", "alice@example.com"}
+	if !reflect.DeepEqual(rec, want) {
+		t.Errorf("incoming record = %q\nwant %q", rec, want)
+	}
+	if len(rest) != 0 {
+		t.Errorf("%d extra bytes in IPv4 set", len(rest))
+	}
+	rec, _ = decodeIPFIXRecord(x.ipfixFields(true), sets[2].body)
+	want = []string{"fd7a:115c:a1e0::1", "::", "0", "0", "17", "100", "1", startMs, endMs, "1", "3", "", "", "", ""}
+	if !reflect.DeepEqual(rec, want) {
+		t.Errorf("exit record = %q\nwant %q", rec, want)
+	}
+
+	// The next export continues the sequence and omits the templates,
+	// which aren't due yet.
+	x.ExportFlows(m)
+	n, _, err = pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seq, sets = decodeIPFIX(t, buf[:n])
+	if seq != 3 {
+		t.Errorf("second sequence number = %d; want 3", seq)
+	}
+	if len(sets) != 2 || sets[0].id != ipfixTemplate4 {
+		t.Errorf("second message sets = %+v", sets)
+	}
+}
+
+func TestIPFIXSplitsMessages(t *testing.T) {
+	x := &IPFIXExporter{}
+	var recs []ipfixRecord
+	for range 100 {
+		recs = append(recs, ipfixRecord{template: ipfixTemplate4, data: make([]byte, 50)})
+	}
+	msgs := x.encodeLocked(time.Now(), recs)
+	if len(msgs) < 4 {
+		t.Fatalf("got %d messages; want at least 4", len(msgs))
+	}
+	total := 0
+	for i, msg := range msgs {
+		if len(msg) > ipfixMaxMessage {
+			t.Errorf("message %d is %d bytes", i, len(msg))
+		}
+		seq, sets := decodeIPFIX(t, msg)
+		if int(seq) != total {
+			t.Errorf("message %d sequence = %d; want %d", i, seq, total)
+		}
+		for _, s := range sets {
+			if s.id == ipfixTemplate4 {
+				total += len(s.body) / 50
+			}
+		}
+	}
+	if total != 100 {
+		t.Errorf("encoded %d records; want 100", total)
+	}
+}
@@ -43,6 +43,15 @@ type noopDevice struct{
 
 func (noopDevice) SetStatistics(*connstats.Statistics) {}
 
+// Exporter receives the traffic that a Logger records, for sending to a
+// local flow collector in addition to, or instead of, the Tailscale log
+// service. See IPFIXExporter.
+type Exporter interface {
+	// ExportFlows is called with each message the Logger records,
+	// from a single goroutine. It must not retain m.
+	ExportFlows(m *netlogtype.Message)
+}
+
 // Logger logs statistics about every connection.
 // At present, it only logs connections within a tailscale network.
 // Exit node traffic is not logged for privacy reasons.
@@ -50,10 +59,11 @@ func (noopDevice) SetStatistics(*connstats.Statistics) {}
 type Logger struct {
 	mu sync.Mutex // protects all fields below
 
-	logger *logtail.Logger
-	stats  *connstats.Statistics
-	tun    Device
-	sock   Device
+	logger   *logtail.Logger // or nil if only exporting
+	exporter Exporter        // or nil
+	stats    *connstats.Statistics
+	tun      Device
+	sock     Device
 
 	addrs    map[netip.Addr]bool
 	prefixes map[netip.Prefix]bool
@@ -63,7 +73,7 @@ type Logger struct {
 func (nl *Logger) Running() bool {
 	nl.mu.Lock()
 	defer nl.mu.Unlock()
-	return nl.logger != nil
+	return nl.stats != nil
 }
 
 var testClient *http.Client
@@ -93,33 +103,42 @@ func (nl *Logger) Running() bool {
 // The IP protocol and source port are always zero.
 // The sock is used to populated the PhysicalTraffic field in Message.
 // The netMon parameter is optional; if non-nil it's used to do faster interface lookups.
-func (nl *Logger) Startup(nodeID tailcfg.StableNodeID, nodeLogID, domainLogID logid.PrivateID, tun, sock Device, netMon *netmon.Monitor, health *health.Tracker, logExitFlowEnabledEnabled bool) error {
+//
+// If nodeLogID is zero, nothing is uploaded to the Tailscale log service
+// and the statistics only go to the exporter, which may otherwise be nil.
+func (nl *Logger) Startup(nodeID tailcfg.StableNodeID, nodeLogID, domainLogID logid.PrivateID, tun, sock Device, netMon *netmon.Monitor, health *health.Tracker, logExitFlowEnabledEnabled bool, exporter Exporter) error {
 	nl.mu.Lock()
 	defer nl.mu.Unlock()
-	if nl.logger != nil {
-		return fmt.Errorf("network logger already running for %v", nl.logger.PrivateID().Public())
+	if nl.stats != nil {
+		return fmt.Errorf("network logger already running")
+	}
+	if nodeLogID.IsZero() && exporter == nil {
+		return fmt.Errorf("network logger has nowhere to log to")
 	}
 
 	// Startup a log stream to Tailscale's logging service.
-	logf := log.Printf
-	httpc := &http.Client{Transport: logpolicy.NewLogtailTransport(logtail.DefaultHost, netMon, health, logf)}
-	if testClient != nil {
-		httpc = testClient
-	}
-	nl.logger = logtail.NewLogger(logtail.Config{
-		Collection:    "tailtraffic.log.tailscale.io",
-		PrivateID:     nodeLogID,
-		CopyPrivateID: domainLogID,
-		Stderr:        io.Discard,
-		CompressLogs:  true,
-		HTTPC:         httpc,
-		// TODO(joetsai): Set Buffer? Use an in-memory buffer for now.
+	if !nodeLogID.IsZero() {
+		logf := log.Printf
+		httpc := &http.Client{Transport: logpolicy.NewLogtailTransport(logtail.DefaultHost, netMon, health, logf)}
+		if testClient != nil {
+			httpc = testClient
+		}
+		nl.logger = logtail.NewLogger(logtail.Config{
+			Collection:    "tailtraffic.log.tailscale.io",
+			PrivateID:     nodeLogID,
+			CopyPrivateID: domainLogID,
+			Stderr:        io.Discard,
+			CompressLogs:  true,
+			HTTPC:         httpc,
+			// TODO(joetsai): Set Buffer? Use an in-memory buffer for now.
 
-		// Include process sequence numbers to identify missing samples.
-		IncludeProcID:       true,
-		IncludeProcSequence: true,
-	}, logf)
-	nl.logger.SetSockstatsLabel(sockstats.LabelNetlogLogger)
+			// Include process sequence numbers to identify missing samples.
+			IncludeProcID:       true,
+			IncludeProcSequence: true,
+		}, logf)
+		nl.logger.SetSockstatsLabel(sockstats.LabelNetlogLogger)
+	}
+	nl.exporter = exporter
 
 	// Startup a data structure to track per-connection statistics.
 	// There is a maximum size for individual log messages that logtail
@@ -131,7 +150,7 @@ func (nl *Logger) Startup(nodeID tailcfg.StableNodeID, nodeLogID, domainLogID lo
 		addrs := nl.addrs
 		prefixes := nl.prefixes
 		nl.mu.Unlock()
-		recordStatistics(nl.logger, nodeID, start, end, virtual, physical, addrs, prefixes, logExitFlowEnabledEnabled)
+		recordStatistics(nl.logger, nl.exporter, nodeID, start, end, virtual, physical, addrs, prefixes, logExitFlowEnabledEnabled)
 	})
 
 	// Register the connection tracker into the TUN device.
@@ -151,7 +170,7 @@ func (nl *Logger) Startup(nodeID tailcfg.StableNodeID, nodeLogID, domainLogID lo
 	return nil
 }
 
-func recordStatistics(logger *logtail.Logger, nodeID tailcfg.StableNodeID, start, end time.Time, connstats, sockStats map[netlogtype.Connection]netlogtype.Counts, addrs map[netip.Addr]bool, prefixes map[netip.Prefix]bool, logExitFlowEnabled bool) {
+func recordStatistics(logger *logtail.Logger, exporter Exporter, nodeID tailcfg.StableNodeID, start, end time.Time, connstats, sockStats map[netlogtype.Connection]netlogtype.Counts, addrs map[netip.Addr]bool, prefixes map[netip.Prefix]bool, logExitFlowEnabled bool) {
 	m := netlogtype.Message{NodeID: nodeID, Start: start.UTC(), End: end.UTC()}
 
 	classifyAddr := func(a netip.Addr) (isTailscale, withinRoute bool) {
@@ -201,7 +220,13 @@ func recordStatistics(logger *logtail.Logger, nodeID tailcfg.StableNodeID, start
 		m.PhysicalTraffic = append(m.PhysicalTraffic, netlogtype.ConnectionCounts{Connection: conn, Counts: cnts})
 	}
 
-	if len(m.VirtualTraffic)+len(m.SubnetTraffic)+len(m.ExitTraffic)+len(m.PhysicalTraffic) > 0 {
+	if len(m.VirtualTraffic)+len(m.SubnetTraffic)+len(m.ExitTraffic)+len(m.PhysicalTraffic) == 0 {
+		return
+	}
+	if exporter != nil {
+		exporter.ExportFlows(&m)
+	}
+	if logger != nil {
 		if b, err := json.Marshal(m); err != nil {
 			logger.Logf("json.Marshal error: %v", err)
 		} else {
@@ -249,7 +274,7 @@ func (nl *Logger) ReconfigRoutes(cfg *router.Config) {
 func (nl *Logger) Shutdown(ctx context.Context) error {
 	nl.mu.Lock()
 	defer nl.mu.Unlock()
-	if nl.logger == nil {
+	if nl.stats == nil {
 		return nil
 	}
 
@@ -259,11 +284,15 @@ func (nl *Logger) Shutdown(ctx context.Context) error {
 	nl.sock.SetStatistics(nil)
 	nl.tun.SetStatistics(nil)
 	err1 := nl.stats.Shutdown(ctx)
-	err2 := nl.logger.Shutdown(ctx)
+	var err2 error
+	if nl.logger != nil {
+		err2 = nl.logger.Shutdown(ctx)
+	}
 	nl.mu.Lock()
 
 	// Purge state.
 	nl.logger = nil
+	nl.exporter = nil
 	nl.stats = nil
 	nl.tun = nil
 	nl.sock = nil
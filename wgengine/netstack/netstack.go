@@ -186,6 +186,7 @@ type Impl struct {
 	ctxCancel context.CancelFunc     // called on Close
 	lb        *ipnlocal.LocalBackend // or nil
 	dns       *dns.Manager
+	tuning    tuning // set by Create and Tune, before Start
 
 	// loopbackPort, if non-nil, will enable Impl to loop back (dnat to
 	// <address-family-loopback>:loopbackPort) TCP & UDP flows originally
@@ -250,44 +251,6 @@ type Impl struct {
 // have a UDP packet as big as the MTU.
 const maxUDPPacketSize = tstun.MaxPacketSize
 
-func setTCPBufSizes(ipstack *stack.Stack) error {
-	// tcpip.TCP{Receive,Send}BufferSizeRangeOption is gVisor's version of
-	// Linux's tcp_{r,w}mem. Application within gVisor differs as some Linux
-	// features are not (yet) implemented, and socket buffer memory is not
-	// controlled within gVisor, e.g. we allocate *stack.PacketBuffer's for the
-	// write path within Tailscale. Therefore, we loosen our understanding of
-	// the relationship between these Linux and gVisor tunables. The chosen
-	// values are biased towards higher throughput on high bandwidth-delay
-	// product paths, except on memory-constrained platforms.
-	tcpRXBufOpt := tcpip.TCPReceiveBufferSizeRangeOption{
-		// Min is unused by gVisor at the time of writing, but partially plumbed
-		// for application by the TCP_WINDOW_CLAMP socket option.
-		Min: tcpRXBufMinSize,
-		// Default is used by gVisor at socket creation.
-		Default: tcpRXBufDefSize,
-		// Max is used by gVisor to cap the advertised receive window post-read.
-		// (tcp_moderate_rcvbuf=true, the default).
-		Max: tcpRXBufMaxSize,
-	}
-	tcpipErr := ipstack.SetTransportProtocolOption(tcp.ProtocolNumber, &tcpRXBufOpt)
-	if tcpipErr != nil {
-		return fmt.Errorf("could not set TCP RX buf size: %v", tcpipErr)
-	}
-	tcpTXBufOpt := tcpip.TCPSendBufferSizeRangeOption{
-		// Min in unused by gVisor at the time of writing.
-		Min: tcpTXBufMinSize,
-		// Default is used by gVisor at socket creation.
-		Default: tcpTXBufDefSize,
-		// Max is used by gVisor to cap the send window.
-		Max: tcpTXBufMaxSize,
-	}
-	tcpipErr = ipstack.SetTransportProtocolOption(tcp.ProtocolNumber, &tcpTXBufOpt)
-	if tcpipErr != nil {
-		return fmt.Errorf("could not set TCP TX buf size: %v", tcpipErr)
-	}
-	return nil
-}
-
 // Create creates and populates a new Impl.
 func Create(logf logger.Logf, tundev *tstun.Wrapper, e wgengine.Engine, mc *magicsock.Conn, dialer *tsdial.Dialer, dns *dns.Manager, pm *proxymap.Mapper) (*Impl, error) {
 	if mc == nil {
@@ -312,29 +275,19 @@ func Create(logf logger.Logf, tundev *tstun.Wrapper, e wgengine.Engine, mc *magi
 		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
 		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol, icmp.NewProtocol4, icmp.NewProtocol6},
 	})
-	sackEnabledOpt := tcpip.TCPSACKEnabled(true) // TCP SACK is disabled by default
-	tcpipErr := ipstack.SetTransportProtocolOption(tcp.ProtocolNumber, &sackEnabledOpt)
-	if tcpipErr != nil {
-		return nil, fmt.Errorf("could not enable TCP SACK: %v", tcpipErr)
+	defTuning := defaultTuning()
+	if err := defTuning.apply(ipstack); err != nil {
+		return nil, err
 	}
 	// See https://github.com/tailscale/tailscale/issues/9707
 	// gVisor's RACK performs poorly. ACKs do not appear to be handled in a
 	// timely manner, leading to spurious retransmissions and a reduced
 	// congestion window.
 	tcpRecoveryOpt := tcpip.TCPRecovery(0)
-	tcpipErr = ipstack.SetTransportProtocolOption(tcp.ProtocolNumber, &tcpRecoveryOpt)
+	tcpipErr := ipstack.SetTransportProtocolOption(tcp.ProtocolNumber, &tcpRecoveryOpt)
 	if tcpipErr != nil {
 		return nil, fmt.Errorf("could not disable TCP RACK: %v", tcpipErr)
 	}
-	cubicOpt := tcpip.CongestionControlOption("cubic")
-	tcpipErr = ipstack.SetTransportProtocolOption(tcp.ProtocolNumber, &cubicOpt)
-	if tcpipErr != nil {
-		return nil, fmt.Errorf("could not set cubic congestion control: %v", tcpipErr)
-	}
-	err := setTCPBufSizes(ipstack)
-	if err != nil {
-		return nil, err
-	}
 	supportedGSOKind := stack.GSONotSupported
 	supportedGROKind := groNotSupported
 	if runtime.GOOS == "linux" {
@@ -386,6 +339,7 @@ func Create(logf logger.Logf, tundev *tstun.Wrapper, e wgengine.Engine, mc *magi
 		connsInFlightByClient: make(map[netip.Addr]int),
 		packetsInFlight:       make(map[stack.TransportEndpointID]struct{}),
 		dns:                   dns,
+		tuning:                defTuning,
 	}
 	loopbackPort, ok := envknob.LookupInt("TS_DEBUG_NETSTACK_LOOPBACK_PORT")
 	if ok && loopbackPort >= 0 && loopbackPort <= math.MaxUint16 {
@@ -503,7 +457,7 @@ func (ns *Impl) wrapTCPProtocolHandler(h protocolHandlerFunc) protocolHandlerFun
 
 		// Check the per-client limit.
 		inFlight := ns.connsInFlightByClient[remoteIP]
-		tooManyInFlight := inFlight >= maxInFlightConnectionAttemptsPerClient()
+		tooManyInFlight := inFlight >= ns.tuning.maxInFlightPerClient
 		if !tooManyInFlight {
 			ns.connsInFlightByClient[remoteIP]++
 		}
@@ -578,7 +532,7 @@ func (ns *Impl) Start(lb *ipnlocal.LocalBackend) error {
 		panic("nil LocalBackend")
 	}
 	ns.lb = lb
-	tcpFwd := tcp.NewForwarder(ns.ipstack, tcpRXBufDefSize, maxInFlightConnectionAttempts(), ns.acceptTCP)
+	tcpFwd := tcp.NewForwarder(ns.ipstack, ns.tuning.rxBuf.Default, ns.tuning.maxInFlight, ns.acceptTCP)
 	udpFwd := udp.NewForwarder(ns.ipstack, ns.acceptUDP)
 	ns.ipstack.SetTransportProtocolHandler(tcp.ProtocolNumber, ns.wrapTCPProtocolHandler(tcpFwd.HandlePacket))
 	ns.ipstack.SetTransportProtocolHandler(udp.ProtocolNumber, ns.wrapUDPProtocolHandler(udpFwd.HandlePacket))
@@ -859,6 +813,33 @@ func (ns *Impl) DialContextUDP(ctx context.Context, ipp netip.AddrPort) (*gonet.
 	return gonet.DialUDP(ns.ipstack, nil, remoteAddress, ipType)
 }
 
+// DialContextUDPWithBind is like DialContextUDP, but binds the connection
+// to the local address bind, whose port may be zero for an ephemeral port.
+// bind and ipp must be of the same address family.
+func (ns *Impl) DialContextUDPWithBind(ctx context.Context, bind, ipp netip.AddrPort) (*gonet.UDPConn, error) {
+	if bind.Addr().Is4() != ipp.Addr().Is4() {
+		return nil, fmt.Errorf("netstack: local address %v and remote address %v are of different families", bind, ipp)
+	}
+	localAddress := &tcpip.FullAddress{
+		NIC:  nicID,
+		Addr: tcpip.AddrFromSlice(bind.Addr().AsSlice()),
+		Port: bind.Port(),
+	}
+	remoteAddress := &tcpip.FullAddress{
+		NIC:  nicID,
+		Addr: tcpip.AddrFromSlice(ipp.Addr().AsSlice()),
+		Port: ipp.Port(),
+	}
+	var ipType tcpip.NetworkProtocolNumber
+	if ipp.Addr().Is4() {
+		ipType = ipv4.ProtocolNumber
+	} else {
+		ipType = ipv6.ProtocolNumber
+	}
+
+	return gonet.DialUDP(ns.ipstack, localAddress, remoteAddress, ipType)
+}
+
 // getInjectInboundBuffsSizes returns packet memory and a sizes slice for usage
 // when calling tstun.Wrapper.InjectInboundPacketBuffer(). These are sized with
 // consideration for MTU and GSO support on ns.linkEP. They should be recycled
@@ -1470,7 +1451,8 @@ func (ns *Impl) forwardTCP(getClient func(...tcpip.SettableSocketOption) *gonet.
 }
 
 // ListenPacket listens for incoming packets for the given network and address.
-// Address must be of the form "ip:port" or "[ip]:port".
+// Address must be of the form "ip:port" or "[ip]:port". An unspecified ip
+// (0.0.0.0 or ::) listens on all addresses of the network's family.
 //
 // As of 2024-05-18, only udp4 and udp6 are supported.
 func (ns *Impl) ListenPacket(network, address string) (net.PacketConn, error) {
@@ -1506,6 +1488,10 @@ func (ns *Impl) ListenPacket(network, address string) (net.PacketConn, error) {
 		Addr: tcpip.AddrFromSlice(ap.Addr().AsSlice()),
 		Port: ap.Port(),
 	}
+	if ap.Addr().IsUnspecified() {
+		// Listen on all of the NIC's addresses of the family.
+		localAddress.Addr = tcpip.Address{}
+	}
 	if err := ep.Bind(localAddress); err != nil {
 		ep.Close()
 		return nil, fmt.Errorf("netstack: Bind(%v): %v", localAddress, err)
@@ -1912,10 +1898,10 @@ func (ns *Impl) ExpVar() expvar.Var {
 
 	// Export gauges that show the current TCP forwarding limits.
 	m.Set("gauge_tcp_forward_in_flight_limit", expvar.Func(func() any {
-		return maxInFlightConnectionAttempts()
+		return ns.tuning.maxInFlight
 	}))
 	m.Set("gauge_tcp_forward_in_flight_per_client_limit", expvar.Func(func() any {
-		return maxInFlightConnectionAttemptsPerClient()
+		return ns.tuning.maxInFlightPerClient
 	}))
 
 	// This metric tracks the number of in-flight TCP forwarding
@@ -1939,7 +1925,7 @@ func (ns *Impl) ExpVar() expvar.Var {
 		ns.mu.Lock()
 		defer ns.mu.Unlock()
 
-		limit := maxInFlightConnectionAttemptsPerClient()
+		limit := ns.tuning.maxInFlightPerClient
 
 		var count int64
 		for _, n := range ns.connsInFlightByClient {
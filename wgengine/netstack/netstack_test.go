@@ -510,6 +510,40 @@ func tcp4syn(tb testing.TB, src, dst netip.Addr, sport, dport uint16) []byte {
 	return ip
 }
 
+func tcp6syn(tb testing.TB, src, dst netip.Addr, sport, dport uint16) []byte {
+	ip := header.IPv6(make([]byte, header.IPv6MinimumSize+header.TCPMinimumSize))
+	ip.Encode(&header.IPv6Fields{
+		TransportProtocol: header.TCPProtocolNumber,
+		PayloadLength:     header.TCPMinimumSize,
+		HopLimit:          64,
+		SrcAddr:           tcpip.AddrFrom16Slice(src.AsSlice()),
+		DstAddr:           tcpip.AddrFrom16Slice(dst.AsSlice()),
+	})
+
+	tcp := header.TCP(ip[header.IPv6MinimumSize:])
+	tcp.Encode(&header.TCPFields{
+		SrcPort:    sport,
+		DstPort:    dport,
+		SeqNum:     0,
+		DataOffset: header.TCPMinimumSize,
+		Flags:      header.TCPFlagSyn,
+		WindowSize: 65535,
+		Checksum:   0,
+	})
+	xsum := header.PseudoHeaderChecksum(
+		header.TCPProtocolNumber,
+		tcpip.AddrFrom16Slice(src.AsSlice()),
+		tcpip.AddrFrom16Slice(dst.AsSlice()),
+		uint16(header.TCPMinimumSize),
+	)
+	tcp.SetChecksum(^tcp.CalculateChecksum(xsum))
+	if !tcp.IsChecksumValid(tcpip.AddrFrom16Slice(src.AsSlice()), tcpip.AddrFrom16Slice(dst.AsSlice()), 0, 0) {
+		tb.Fatal("test broken; packet has incorrect TCP checksum")
+	}
+
+	return ip
+}
+
 // makeHangDialer returns a dialer that notifies the returned channel when a
 // connection is dialed and then hangs until the test finishes.
 func makeHangDialer(tb testing.TB) (func(context.Context, string, string) (net.Conn, error), chan struct{}) {
@@ -614,6 +648,62 @@ func TestTCPForwardLimits(t *testing.T) {
 	}
 }
 
+// TestTCPForward4via6KernelTun verifies that a 4via6 subnet router running
+// with a kernel TUN device (as on the BSDs, where the router only installs
+// the via route pointing at the tun) still terminates 4via6 connections in
+// netstack and dials the unmapped IPv4 destination, even though netstack is
+// neither processing local IPs nor subnets. No translation is needed in
+// tstun for this to work.
+func TestTCPForward4via6KernelTun(t *testing.T) {
+	impl := makeNetstack(t, func(impl *Impl) {
+		impl.ProcessLocalIPs = false
+		impl.ProcessSubnets = false
+	})
+
+	dialed := make(chan string, 1)
+	impl.forwardDialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+		select {
+		case dialed <- address:
+		default:
+		}
+		return nil, fmt.Errorf("test dialer")
+	}
+
+	prefs := ipn.NewPrefs()
+	prefs.AdvertiseRoutes = []netip.Prefix{
+		// $ tailscale debug via 7 10.1.1.0/24
+		// fd7a:115c:a1e0:b1a:0:7:a01:100/120
+		netip.MustParsePrefix("fd7a:115c:a1e0:b1a:0:7:a01:100/120"),
+	}
+	impl.lb.Start(ipn.Options{
+		UpdatePrefs: prefs,
+	})
+	impl.atomicIsLocalIPFunc.Store(looksLikeATailscaleSelfAddress)
+
+	client := netip.MustParseAddr("fd7a:115c:a1e0::1")
+	// $ tailscale debug via 7 10.1.1.9/24
+	// fd7a:115c:a1e0:b1a:0:7:a01:109/120
+	destAddr := netip.MustParseAddr("fd7a:115c:a1e0:b1a:0:7:a01:109")
+	pkt := tcp6syn(t, client, destAddr, 1234, 5678)
+	var parsed packet.Parsed
+	parsed.Decode(pkt)
+
+	// The packet must be consumed by netstack rather than delivered to
+	// the host's tun device, which has no way to reach a via address.
+	if resp, _ := impl.injectInbound(&parsed, impl.tundev, nil); resp != filter.DropSilently {
+		t.Errorf("got filter outcome %v, want filter.DropSilently", resp)
+	}
+
+	select {
+	case got := <-dialed:
+		if want := "10.1.1.9:5678"; got != want {
+			t.Errorf("dialed %q; want %q", got, want)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for forwarded dial")
+	}
+}
+
 // TestTCPForwardLimits_PerClient verifies that the per-client limit for TCP
 // forwarding works.
 func TestTCPForwardLimits_PerClient(t *testing.T) {
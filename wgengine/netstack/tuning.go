@@ -0,0 +1,161 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netstack
+
+import (
+	"errors"
+	"fmt"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"tailscale.com/ipn"
+)
+
+// tuning is the configuration of the gVisor stack and the TCP forwarder.
+type tuning struct {
+	rxBuf                tcpip.TCPReceiveBufferSizeRangeOption
+	txBuf                tcpip.TCPSendBufferSizeRangeOption
+	sack                 bool
+	congestionControl    string
+	maxInFlight          int // see maxInFlightConnectionAttempts
+	maxInFlightPerClient int // see maxInFlightConnectionAttemptsPerClient
+}
+
+// defaultTuning returns the tuning used unless a config file says otherwise.
+func defaultTuning() tuning {
+	return tuning{
+		rxBuf: tcpip.TCPReceiveBufferSizeRangeOption{
+			Min:     tcpRXBufMinSize,
+			Default: tcpRXBufDefSize,
+			Max:     tcpRXBufMaxSize,
+		},
+		txBuf: tcpip.TCPSendBufferSizeRangeOption{
+			Min:     tcpTXBufMinSize,
+			Default: tcpTXBufDefSize,
+			Max:     tcpTXBufMaxSize,
+		},
+		sack:                 true, // TCP SACK is disabled by default in gVisor
+		congestionControl:    "cubic",
+		maxInFlight:          maxInFlightConnectionAttempts(),
+		maxInFlightPerClient: maxInFlightConnectionAttemptsPerClient(),
+	}
+}
+
+// profileTuning returns the tuning for the named profile.
+func profileTuning(profile string) (tuning, error) {
+	t := defaultTuning()
+	switch profile {
+	case "", "default":
+	case "throughput":
+		// For proxies and subnet routers moving bulk data over high
+		// bandwidth-delay product paths, at the cost of memory.
+		t.rxBuf.Max = 32 << 20
+		t.txBuf.Max = 32 << 20
+		t.maxInFlight = 16384
+		t.maxInFlightPerClient = t.maxInFlight * 2 / 3
+	case "low-memory":
+		// For small machines; these are close to the gVisor defaults
+		// used on iOS, with smaller initial buffers.
+		t.rxBuf.Default = 64 << 10
+		t.rxBuf.Max = 1 << 20
+		t.txBuf.Default = 64 << 10
+		t.txBuf.Max = 1 << 20
+		t.maxInFlight = 256
+		t.maxInFlightPerClient = t.maxInFlight * 2 / 3
+	default:
+		return tuning{}, fmt.Errorf("unknown netstack profile %q; want \"throughput\" or \"low-memory\"", profile)
+	}
+	return t, nil
+}
+
+// tuningFromConfig returns the tuning configured by c.
+func tuningFromConfig(c *ipn.NetstackConfig) (tuning, error) {
+	t, err := profileTuning(c.Profile)
+	if err != nil {
+		return tuning{}, err
+	}
+	if n := c.TCPReceiveBufferMax; n != 0 {
+		if n < tcp.MinBufferSize {
+			return tuning{}, fmt.Errorf("TCP receive buffer size %d is below the minimum of %d", n, tcp.MinBufferSize)
+		}
+		t.rxBuf.Max = n
+		t.rxBuf.Default = min(t.rxBuf.Default, n)
+	}
+	if n := c.TCPSendBufferMax; n != 0 {
+		if n < tcp.MinBufferSize {
+			return tuning{}, fmt.Errorf("TCP send buffer size %d is below the minimum of %d", n, tcp.MinBufferSize)
+		}
+		t.txBuf.Max = n
+		t.txBuf.Default = min(t.txBuf.Default, n)
+	}
+	if v, ok := c.SACK.Get(); ok {
+		t.sack = v
+	}
+	switch c.CongestionControl {
+	case "":
+	case "cubic", "reno":
+		t.congestionControl = c.CongestionControl
+	default:
+		return tuning{}, fmt.Errorf("unknown congestion control algorithm %q; want \"cubic\" or \"reno\"", c.CongestionControl)
+	}
+	if n := c.MaxInFlightConnections; n != 0 {
+		if n < 0 {
+			return tuning{}, errors.New("MaxInFlightConnections must be positive")
+		}
+		t.maxInFlight = n
+		t.maxInFlightPerClient = min(t.maxInFlightPerClient, n)
+	}
+	if n := c.MaxInFlightConnectionsPerClient; n != 0 {
+		if n < 0 {
+			return tuning{}, errors.New("MaxInFlightConnectionsPerClient must be positive")
+		}
+		t.maxInFlightPerClient = min(n, t.maxInFlight)
+	}
+	return t, nil
+}
+
+// apply sets the gVisor stack options in t on ipstack.
+func (t tuning) apply(ipstack *stack.Stack) error {
+	sackOpt := tcpip.TCPSACKEnabled(t.sack)
+	if err := ipstack.SetTransportProtocolOption(tcp.ProtocolNumber, &sackOpt); err != nil {
+		return fmt.Errorf("could not set TCP SACK: %v", err)
+	}
+	ccOpt := tcpip.CongestionControlOption(t.congestionControl)
+	if err := ipstack.SetTransportProtocolOption(tcp.ProtocolNumber, &ccOpt); err != nil {
+		return fmt.Errorf("could not set %s congestion control: %v", t.congestionControl, err)
+	}
+	// tcpip.TCP{Receive,Send}BufferSizeRangeOption is gVisor's version of
+	// Linux's tcp_{r,w}mem. Min is unused by gVisor at the time of writing.
+	// Default is used at socket creation. Max caps the advertised receive
+	// window post-read (tcp_moderate_rcvbuf=true, the default) and the
+	// send window.
+	if err := ipstack.SetTransportProtocolOption(tcp.ProtocolNumber, &t.rxBuf); err != nil {
+		return fmt.Errorf("could not set TCP RX buf size: %v", err)
+	}
+	if err := ipstack.SetTransportProtocolOption(tcp.ProtocolNumber, &t.txBuf); err != nil {
+		return fmt.Errorf("could not set TCP TX buf size: %v", err)
+	}
+	return nil
+}
+
+// Tune applies the tuning in c, as from a config file, to the network
+// stack. It must be called before Start.
+func (ns *Impl) Tune(c *ipn.NetstackConfig) error {
+	if ns.lb != nil {
+		return errors.New("netstack: Tune called after Start")
+	}
+	t, err := tuningFromConfig(c)
+	if err != nil {
+		return err
+	}
+	if err := t.apply(ns.ipstack); err != nil {
+		return err
+	}
+	ns.tuning = t
+	ns.logf("netstack: tuned: profile=%q rxbuf=%d/%d txbuf=%d/%d sack=%v cc=%s inflight=%d/%d",
+		c.Profile, t.rxBuf.Default, t.rxBuf.Max, t.txBuf.Default, t.txBuf.Max,
+		t.sack, t.congestionControl, t.maxInFlight, t.maxInFlightPerClient)
+	return nil
+}
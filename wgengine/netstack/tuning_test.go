@@ -0,0 +1,61 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netstack
+
+import (
+	"testing"
+
+	"tailscale.com/ipn"
+)
+
+func TestTuningFromConfig(t *testing.T) {
+	def := defaultTuning()
+
+	got, err := tuningFromConfig(&ipn.NetstackConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != def {
+		t.Errorf("empty config = %+v, want defaults %+v", got, def)
+	}
+
+	got, err = tuningFromConfig(&ipn.NetstackConfig{Profile: "throughput"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.rxBuf.Max <= def.rxBuf.Max || got.maxInFlight <= def.maxInFlight {
+		t.Errorf("throughput profile %+v doesn't raise the defaults %+v", got, def)
+	}
+
+	got, err = tuningFromConfig(&ipn.NetstackConfig{
+		Profile:                         "low-memory",
+		SACK:                            "false",
+		CongestionControl:               "reno",
+		TCPSendBufferMax:                32 << 10,
+		MaxInFlightConnectionsPerClient: 1000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.sack || got.congestionControl != "reno" {
+		t.Errorf("sack=%v cc=%q; want overrides applied", got.sack, got.congestionControl)
+	}
+	if got.txBuf.Max != 32<<10 || got.txBuf.Default > got.txBuf.Max {
+		t.Errorf("txBuf = %+v; want max 32KiB and default no larger", got.txBuf)
+	}
+	if got.maxInFlightPerClient != got.maxInFlight {
+		t.Errorf("per-client limit %d not capped at global limit %d", got.maxInFlightPerClient, got.maxInFlight)
+	}
+
+	for _, c := range []ipn.NetstackConfig{
+		{Profile: "fast"},
+		{CongestionControl: "bbr"},
+		{TCPReceiveBufferMax: 1},
+		{MaxInFlightConnections: -1},
+	} {
+		if _, err := tuningFromConfig(&c); err == nil {
+			t.Errorf("%+v: got no error", c)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package bsdroute keeps the kernel routing table in sync with the routes a
+// BSD router wants.
+//
+// Each router computes the set of Entries it wants installed and hands it
+// to a Table, which works out the changes from what it installed before and
+// applies them as a single transaction, through a routing socket (see
+// route(4)) where possible and with route(8) otherwise. The routers differ
+// only in how they name the gateway of a route for route(8), which they
+// supply as a CmdFunc.
+package bsdroute
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+
+	"tailscale.com/types/logger"
+	"tailscale.com/util/set"
+)
+
+// Entry is a route that a router installs: one into the Tailscale
+// interface, or, if Dev is set, into that local interface.
+type Entry struct {
+	Dst netip.Prefix
+	Dev string // if non-empty, the local interface the route points into
+
+	// Priority is the route's priority on OpenBSD, where lower values
+	// are preferred, or 0 for the default. It must be 0 elsewhere.
+	Priority uint8
+}
+
+// Op is a single change to the kernel routing table: adding or deleting
+// an Entry.
+type Op struct {
+	Del bool // delete the route, rather than add it
+	Entry
+}
+
+func (op Op) String() string {
+	verb := "add"
+	if op.Del {
+		verb = "del"
+	}
+	s := fmt.Sprintf("%s %v", verb, op.Dst)
+	if op.Dev != "" {
+		s += " dev " + op.Dev
+	}
+	if op.Priority != 0 {
+		s += fmt.Sprintf(" priority %d", op.Priority)
+	}
+	return s
+}
+
+// inverse returns the op that undoes op.
+func (op Op) inverse() Op {
+	return Op{Del: !op.Del, Entry: op.Entry}
+}
+
+// ErrUnchanged is returned by an Op apply func when the routing table was
+// already in the requested state (adding a route that exists, or deleting
+// one that doesn't). ApplyOps treats it as success, but doesn't undo such
+// ops on rollback, as they changed nothing.
+var ErrUnchanged = errors.New("route unchanged")
+
+// OpError is the error ApplyOps returns when an op fails.
+type OpError struct {
+	Op  Op
+	Err error
+}
+
+func (e *OpError) Error() string {
+	return fmt.Sprintf("route %v: %v", e.Op, e.Err)
+}
+
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+// ApplyOps applies ops in order using apply, as a single transaction: if an
+// op fails, the ops that were already applied are undone in reverse order
+// and an *OpError for the failing op is returned, leaving the routing table as it
+// was before the call.
+//
+// Failures while rolling back are logged but otherwise ignored, as there's
+// nothing better to do with them.
+func ApplyOps(logf logger.Logf, ops []Op, apply func(Op) error) error {
+	var done []Op
+	for _, op := range ops {
+		err := apply(op)
+		if errors.Is(err, ErrUnchanged) {
+			continue
+		}
+		if err == nil {
+			done = append(done, op)
+			continue
+		}
+		if len(done) > 0 {
+			logf("route %v failed: %v; rolling back %d route changes", op, err, len(done))
+		}
+		for i := len(done) - 1; i >= 0; i-- {
+			undo := done[i].inverse()
+			if uerr := apply(undo); uerr != nil && !errors.Is(uerr, ErrUnchanged) {
+				logf("rollback: route %v failed: %v", undo, uerr)
+			}
+		}
+		return &OpError{Op: op, Err: err}
+	}
+	return nil
+}
+
+// Diff returns the ops that change the installed routes from old to new.
+// Entries for which reset reports true are deleted and added again even if
+// they're in both, as when the address they were installed with went away;
+// reset may be nil.
+//
+// Deletions come first, so that routes that are replaced never overlap.
+// Routes into the Tailscale interface are added before routes into local
+// interfaces, which are more specific, so that traffic they cover never
+// briefly takes the Tailscale route alone.
+func Diff(old, new set.Set[Entry], reset func(Entry) bool) []Op {
+	var ops []Op
+	for e := range old {
+		if !new.Contains(e) || (reset != nil && reset(e)) {
+			ops = append(ops, Op{Del: true, Entry: e})
+		}
+	}
+	for e := range new {
+		if !old.Contains(e) || (reset != nil && reset(e)) {
+			ops = append(ops, Op{Entry: e})
+		}
+	}
+	slices.SortFunc(ops, func(a, b Op) int {
+		if a.Del != b.Del {
+			if a.Del {
+				return -1
+			}
+			return 1
+		}
+		return compareEntries(a.Entry, b.Entry)
+	})
+	return ops
+}
+
+// compareEntries orders routes into the Tailscale interface before routes
+// into local interfaces, and then by destination.
+func compareEntries(a, b Entry) int {
+	if (a.Dev == "") != (b.Dev == "") {
+		if a.Dev == "" {
+			return -1
+		}
+		return 1
+	}
+	if c := a.Dst.Addr().Compare(b.Dst.Addr()); c != 0 {
+		return c
+	}
+	return cmp.Or(
+		cmp.Compare(a.Dst.Bits(), b.Dst.Bits()),
+		cmp.Compare(a.Dev, b.Dev),
+		cmp.Compare(a.Priority, b.Priority))
+}
+
+// Args returns the route(8) arguments applying op to a route whose gateway
+// is gw: on most BSDs the name of the interface the route points into, and
+// on NetBSD and OpenBSD, whose route(8) names interfaces by address, one of
+// its addresses. A route with a Priority gets OpenBSD's -priority.
+func Args(op Op, gw string) []string {
+	verb := "add"
+	if op.Del {
+		verb = "delete"
+	}
+	family := "-inet"
+	if op.Dst.Addr().Is6() {
+		family = "-inet6"
+	}
+	args := []string{"-q", "-n", verb, family, op.Dst.Masked().String(), "-iface", gw}
+	if op.Priority != 0 {
+		args = append(args, "-priority", strconv.Itoa(int(op.Priority)))
+	}
+	return args
+}
+
+// AddrArgs returns the route(8) arguments applying op to a route into the
+// interface with the addresses addr4 and addr6, naming it by its address
+// in op's address family, as NetBSD's and OpenBSD's route(8) require.
+func AddrArgs(op Op, addr4, addr6 netip.Addr) []string {
+	gw := addr4
+	if op.Dst.Addr().Is6() {
+		gw = addr6
+	}
+	return Args(op, gw.String())
+}
+
+// CmdFunc returns the route(8) command line applying op, for when the
+// routing socket is unavailable.
+type CmdFunc func(op Op) []string
+
+// RunFunc runs the command line args and returns its combined output, also
+// when it fails.
+type RunFunc func(args ...string) ([]byte, error)
+
+// Table is the set of routes a router has installed.
+type Table struct {
+	logf    logger.Logf
+	tunname string
+	useCmd  bool
+	run     RunFunc // or nil to use os/exec
+	routes  set.Set[Entry]
+	want    set.Set[Entry] // the routes of the latest Set
+	err     *OpError       // the failed op of the latest Set, or nil
+}
+
+// NewTable returns an empty Table for routes into the interface named
+// tunname.
+func NewTable(logf logger.Logf, tunname string) *Table {
+	return &Table{logf: logf, tunname: tunname}
+}
+
+// SetUseCmd sets whether to always use route(8), such as when the routes go
+// into a routing table that the routing socket messages can't address.
+func (t *Table) SetUseCmd(v bool) {
+	t.useCmd = v
+}
+
+// SetRun sets the func that runs route(8), in place of os/exec, such as a
+// router's fake in tests.
+func (t *Table) SetRun(run RunFunc) {
+	t.run = run
+}
+
+// Set changes the installed routes to want (see Diff for reset), with cmd
+// for the route(8) commands. If that fails, the changes are rolled back and
+// the installed routes stay as they were, so that a later Set retries them.
+func (t *Table) Set(want set.Set[Entry], reset func(Entry) bool, cmd CmdFunc) error {
+	t.want = want.Clone()
+	t.err = nil
+	if err := t.apply(Diff(t.routes, want, reset), cmd); err != nil {
+		errors.As(err, &t.err)
+		return err
+	}
+	t.routes = want.Clone()
+	return nil
+}
+
+// Diff returns the ops that Set would apply to install want.
+func (t *Table) Diff(want set.Set[Entry], reset func(Entry) bool) []Op {
+	return Diff(t.routes, want, reset)
+}
+
+// Flush removes all the installed routes.
+func (t *Table) Flush(cmd CmdFunc) error {
+	return t.Set(nil, nil, cmd)
+}
+
+// Forget forgets the installed routes without removing them, for when the
+// kernel already did, such as along with the addresses they pointed into.
+func (t *Table) Forget() {
+	t.routes = nil
+}
+
+// Adopt adds the routes in the kernel routing table that point into the
+// Tailscale interface, or via one of that interface's addresses, to the
+// installed routes, so that the next Set removes those it doesn't want.
+// Routers call it on startup, to remove the routes left behind by a
+// tailscaled that exited without cleaning up after itself. It returns the
+// number of routes adopted. With SetUseCmd, it does nothing, as the
+// routing table the routes go into may not be the one it can list.
+func (t *Table) Adopt() (int, error) {
+	if t.useCmd {
+		return 0, nil
+	}
+	found, err := kernelRoutes(t.tunname)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, e := range found {
+		if t.routes.Contains(e) {
+			continue
+		}
+		t.routes.Make()
+		t.routes.Add(e)
+		n++
+	}
+	return n, nil
+}
+
+// RouteState is the state of a route that a Table installed or was asked to.
+type RouteState struct {
+	Entry
+	Installed bool  // whether the route is installed
+	Wanted    bool  // whether the latest Set asked for the route
+	Err       error // why the latest Set failed to change the route, or nil
+}
+
+// State returns the state of the routes that are installed or that the
+// latest Set asked for. The routes differ if it failed, in which case the
+// route it failed on has an Err.
+func (t *Table) State() []RouteState {
+	var ret []RouteState
+	for e := range t.routes {
+		ret = append(ret, RouteState{Entry: e, Installed: true, Wanted: t.want.Contains(e)})
+	}
+	for e := range t.want {
+		if !t.routes.Contains(e) {
+			ret = append(ret, RouteState{Entry: e, Wanted: true})
+		}
+	}
+	for i := range ret {
+		if t.err != nil && t.err.Op.Entry == ret[i].Entry {
+			ret[i].Err = t.err.Err
+		}
+	}
+	slices.SortFunc(ret, func(a, b RouteState) int {
+		return compareEntries(a.Entry, b.Entry)
+	})
+	return ret
+}
+
+func (t *Table) apply(ops []Op, cmd CmdFunc) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	if !t.useCmd {
+		rs, err := newRouteSocket(t.tunname)
+		if err == nil {
+			defer rs.Close()
+			return ApplyOps(t.logf, ops, rs.apply)
+		}
+		t.logf("routing socket unavailable, using route(8): %v", err)
+	}
+	return applyCmds(t.logf, ops, cmd, t.run)
+}
@@ -0,0 +1,217 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package bsdroute
+
+import (
+	"errors"
+	"net/netip"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	"tailscale.com/util/set"
+)
+
+func TestApplyOps(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	add := func(s string) Op { return Op{Entry: Entry{Dst: pfx(s)}} }
+	del := func(s string) Op { return Op{Del: true, Entry: Entry{Dst: pfx(s)}} }
+	errBoom := errors.New("boom")
+
+	tests := []struct {
+		name    string
+		ops     []Op
+		results map[Op]error // result of applying each op; nil if absent
+		want    []Op         // ops applied, in order, including rollback
+		wantErr bool
+	}{
+		{
+			name: "all_ok",
+			ops:  []Op{del("10.0.0.0/8"), add("10.1.0.0/16"), add("fd00::/64")},
+			want: []Op{del("10.0.0.0/8"), add("10.1.0.0/16"), add("fd00::/64")},
+		},
+		{
+			name:    "rollback",
+			ops:     []Op{del("10.0.0.0/8"), add("10.1.0.0/16"), add("10.2.0.0/16")},
+			results: map[Op]error{add("10.2.0.0/16"): errBoom},
+			want: []Op{
+				del("10.0.0.0/8"), add("10.1.0.0/16"), add("10.2.0.0/16"),
+				del("10.1.0.0/16"), add("10.0.0.0/8"),
+			},
+			wantErr: true,
+		},
+		{
+			name:    "unchanged_not_rolled_back",
+			ops:     []Op{add("10.1.0.0/16"), add("10.2.0.0/16"), add("10.3.0.0/16")},
+			results: map[Op]error{add("10.1.0.0/16"): ErrUnchanged, add("10.3.0.0/16"): errBoom},
+			want: []Op{
+				add("10.1.0.0/16"), add("10.2.0.0/16"), add("10.3.0.0/16"),
+				del("10.2.0.0/16"),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []Op
+			err := ApplyOps(t.Logf, tt.ops, func(op Op) error {
+				got = append(got, op)
+				return tt.results[op]
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v; wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("applied ops\n got: %v\nwant: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	tun := func(s string) Entry { return Entry{Dst: pfx(s)} }
+	dev := func(s, dev string) Entry { return Entry{Dst: pfx(s), Dev: dev} }
+
+	old := set.Of(
+		tun("10.0.0.0/8"),
+		tun("fd7a:115c:a1e0::/48"),
+		dev("192.168.1.0/25", "em0"),
+		dev("192.168.1.128/25", "em0"),
+	)
+	new := set.Of(
+		tun("10.0.0.0/8"),
+		tun("fd7a:115c:a1e0::/48"),
+		tun("100.64.0.0/10"),
+		dev("192.168.1.0/25", "em0"),
+		dev("192.168.1.128/25", "em1"),
+	)
+	got := Diff(old, new, func(e Entry) bool { return e.Dev == "" && e.Dst.Addr().Is6() })
+	want := []Op{
+		{Del: true, Entry: tun("fd7a:115c:a1e0::/48")},
+		{Del: true, Entry: dev("192.168.1.128/25", "em0")},
+		{Entry: tun("100.64.0.0/10")},
+		{Entry: tun("fd7a:115c:a1e0::/48")},
+		{Entry: dev("192.168.1.128/25", "em1")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff\n got: %v\nwant: %v", got, want)
+	}
+
+	if got := Diff(new, new, nil); len(got) != 0 {
+		t.Errorf("Diff of equal sets = %v; want none", got)
+	}
+
+	// A route whose priority changed is replaced.
+	lan := pfx("192.168.1.0/24")
+	got = Diff(set.Of(Entry{Dst: lan}), set.Of(Entry{Dst: lan, Priority: 48}), nil)
+	want = []Op{
+		{Del: true, Entry: Entry{Dst: lan}},
+		{Entry: Entry{Dst: lan, Priority: 48}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff of priority change = %v; want %v", got, want)
+	}
+}
+
+func TestArgs(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	tests := []struct {
+		op   Op
+		gw   string
+		want []string
+	}{
+		{
+			Op{Entry: Entry{Dst: pfx("128.0.0.0/1")}}, "tun0",
+			[]string{"-q", "-n", "add", "-inet", "128.0.0.0/1", "-iface", "tun0"},
+		},
+		{
+			Op{Del: true, Entry: Entry{Dst: pfx("10.1.2.3/16")}}, "tun0",
+			[]string{"-q", "-n", "delete", "-inet", "10.1.0.0/16", "-iface", "tun0"},
+		},
+		{
+			Op{Entry: Entry{Dst: pfx("8000::/1")}}, "fd7a:115c:a1e0::1",
+			[]string{"-q", "-n", "add", "-inet6", "8000::/1", "-iface", "fd7a:115c:a1e0::1"},
+		},
+		{
+			Op{Entry: Entry{Dst: pfx("192.168.1.0/24"), Priority: 48}}, "100.64.0.1",
+			[]string{"-q", "-n", "add", "-inet", "192.168.1.0/24", "-iface", "100.64.0.1", "-priority", "48"},
+		},
+	}
+	for _, tt := range tests {
+		if got := Args(tt.op, tt.gw); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Args(%v, %q) = %q; want %q", tt.op, tt.gw, got, tt.want)
+		}
+	}
+}
+
+func TestAddrArgs(t *testing.T) {
+	addr4 := netip.MustParseAddr("100.64.0.1")
+	addr6 := netip.MustParseAddr("fd7a:115c:a1e0::1")
+	tests := []struct {
+		op   Op
+		want []string
+	}{
+		{
+			Op{Entry: Entry{Dst: netip.MustParsePrefix("128.0.0.0/1")}},
+			[]string{"-q", "-n", "add", "-inet", "128.0.0.0/1", "-iface", "100.64.0.1"},
+		},
+		{
+			Op{Del: true, Entry: Entry{Dst: netip.MustParsePrefix("8000::/1")}},
+			[]string{"-q", "-n", "delete", "-inet6", "8000::/1", "-iface", "fd7a:115c:a1e0::1"},
+		},
+	}
+	for _, tt := range tests {
+		if got := AddrArgs(tt.op, addr4, addr6); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("AddrArgs(%v) = %q; want %q", tt.op, got, tt.want)
+		}
+	}
+}
+
+func TestTableState(t *testing.T) {
+	if _, err := exec.LookPath("false"); err != nil {
+		t.Skip("no false(1) to stand in for a failing route(8)")
+	}
+	pfx := netip.MustParsePrefix
+	tun := func(s string) Entry { return Entry{Dst: pfx(s)} }
+	lan := Entry{Dst: pfx("192.168.1.0/25"), Dev: "em0"}
+
+	tbl := NewTable(t.Logf, "tun0")
+	tbl.SetUseCmd(true)
+	cmd := func(fail Entry) CmdFunc {
+		return func(op Op) []string {
+			if op.Entry == fail && !op.Del {
+				return []string{"false"}
+			}
+			return []string{"true"}
+		}
+	}
+	if err := tbl.Set(set.Of(tun("10.0.0.0/8"), lan), nil, cmd(Entry{})); err != nil {
+		t.Fatal(err)
+	}
+	want := []RouteState{
+		{Entry: tun("10.0.0.0/8"), Installed: true, Wanted: true},
+		{Entry: lan, Installed: true, Wanted: true},
+	}
+	if got := tbl.State(); !reflect.DeepEqual(got, want) {
+		t.Errorf("State after success\n got: %v\nwant: %v", got, want)
+	}
+
+	// A failed Set leaves the installed routes as they were, and blames
+	// the route it failed on.
+	err := tbl.Set(set.Of(tun("10.2.0.0/16"), lan), nil, cmd(tun("10.2.0.0/16")))
+	var oe *OpError
+	if !errors.As(err, &oe) || oe.Op != (Op{Entry: tun("10.2.0.0/16")}) {
+		t.Fatalf("Set err = %v; want an OpError for adding 10.2.0.0/16", err)
+	}
+	got := tbl.State()
+	want = []RouteState{
+		{Entry: tun("10.0.0.0/8"), Installed: true},
+		{Entry: tun("10.2.0.0/16"), Wanted: true, Err: oe.Err},
+		{Entry: lan, Installed: true, Wanted: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("State after failure\n got: %v\nwant: %v", got, want)
+	}
+}
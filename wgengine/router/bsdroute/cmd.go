@@ -0,0 +1,45 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package bsdroute
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"tailscale.com/types/logger"
+)
+
+// applyCmds applies ops as a single transaction (see ApplyOps) by running
+// the route(8) command returned by cmd for each op with run, or os/exec if
+// it's nil.
+func applyCmds(logf logger.Logf, ops []Op, cmd CmdFunc, run RunFunc) error {
+	if run == nil {
+		run = func(args ...string) ([]byte, error) {
+			return exec.Command(args[0], args[1:]...).CombinedOutput()
+		}
+	}
+	return ApplyOps(logf, ops, func(op Op) error {
+		argv := cmd(op)
+		out, err := run(argv...)
+		if err != nil {
+			if cmdUnchanged(op, out) {
+				return ErrUnchanged
+			}
+			return fmt.Errorf("%v: %w\n%s", argv, err, out)
+		}
+		return nil
+	})
+}
+
+// cmdUnchanged reports whether the output of a failed route(8) command
+// indicates that the routing table was already in the state that op asked
+// for.
+func cmdUnchanged(op Op, out []byte) bool {
+	s := string(out)
+	if op.Del {
+		return strings.Contains(s, "not in table")
+	}
+	return strings.Contains(s, "File exists")
+}
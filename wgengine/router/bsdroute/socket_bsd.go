@@ -0,0 +1,250 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package bsdroute
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"runtime"
+	"slices"
+
+	"golang.org/x/net/route"
+	"golang.org/x/sys/unix"
+)
+
+// routeSocket programs routes into the Tailscale interface (or, for ops
+// with a dev, into that local interface) by writing
+// RTM_ADD and RTM_DELETE messages to a PF_ROUTE socket. A whole batch of
+// route changes costs one write(2) each, rather than a fork and exec of
+// route(8) each, which shortens reconfiguration on subnet routers with
+// many routes from seconds to milliseconds.
+type routeSocket struct {
+	fd      int
+	ifIndex int // index of the Tailscale interface
+	pid     uintptr
+	seq     int
+}
+
+// newRouteSocket opens a routing socket for programming routes that point
+// into the interface named tunname.
+func newRouteSocket(tunname string) (*routeSocket, error) {
+	ifc, err := net.InterfaceByName(tunname)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := unix.Socket(unix.AF_ROUTE, unix.SOCK_RAW, unix.AF_UNSPEC)
+	if err != nil {
+		return nil, fmt.Errorf("opening routing socket: %w", err)
+	}
+	// We don't read replies; the kernel reports failures as write
+	// errors. Don't let replies and other processes' messages pile up.
+	unix.Shutdown(fd, unix.SHUT_RD)
+	return &routeSocket{
+		fd:      fd,
+		ifIndex: ifc.Index,
+		pid:     uintptr(os.Getpid()),
+	}, nil
+}
+
+// apply applies op. It's suitable for use with ApplyOps.
+func (s *routeSocket) apply(op Op) error {
+	ifIndex := s.ifIndex
+	if op.Dev != "" {
+		ifc, err := net.InterfaceByName(op.Dev)
+		if err != nil {
+			return err
+		}
+		ifIndex = ifc.Index
+	}
+	s.seq++
+	b, err := routeMessage(op, ifIndex, s.pid, s.seq)
+	if err != nil {
+		return err
+	}
+	_, err = unix.Write(s.fd, b)
+	switch {
+	case err == nil:
+		return nil
+	case !op.Del && errors.Is(err, unix.EEXIST),
+		op.Del && errors.Is(err, unix.ESRCH):
+		return ErrUnchanged
+	}
+	return err
+}
+
+// Close closes the routing socket.
+func (s *routeSocket) Close() error {
+	return unix.Close(s.fd)
+}
+
+// routeMessage returns the routing socket message that applies op to a
+// route whose gateway is the interface with index ifIndex, equivalent to
+// "route add -net <op.Dst> -iface <ifname>".
+func routeMessage(op Op, ifIndex int, pid uintptr, seq int) ([]byte, error) {
+	pfx := op.Dst.Masked()
+	typ := unix.RTM_ADD
+	if op.Del {
+		typ = unix.RTM_DELETE
+	}
+	flags := unix.RTF_UP | unix.RTF_STATIC
+	if pfx.IsSingleIP() {
+		flags |= unix.RTF_HOST
+	}
+
+	addrs := make([]route.Addr, unix.RTAX_NETMASK+1)
+	addrs[unix.RTAX_GATEWAY] = &route.LinkAddr{Index: ifIndex}
+	if pfx.Addr().Is4() {
+		addrs[unix.RTAX_DST] = &route.Inet4Addr{IP: pfx.Addr().As4()}
+		if !pfx.IsSingleIP() {
+			var mask [4]byte
+			copy(mask[:], net.CIDRMask(pfx.Bits(), 32))
+			addrs[unix.RTAX_NETMASK] = &route.Inet4Addr{IP: mask}
+		}
+	} else {
+		addrs[unix.RTAX_DST] = &route.Inet6Addr{IP: pfx.Addr().As16()}
+		if !pfx.IsSingleIP() {
+			var mask [16]byte
+			copy(mask[:], net.CIDRMask(pfx.Bits(), 128))
+			addrs[unix.RTAX_NETMASK] = &route.Inet6Addr{IP: mask}
+		}
+	}
+	if pfx.IsSingleIP() {
+		addrs = addrs[:unix.RTAX_GATEWAY+1]
+	}
+
+	m := &route.RouteMessage{
+		Version: unix.RTM_VERSION,
+		Type:    typ,
+		Flags:   flags,
+		Index:   ifIndex,
+		ID:      pid,
+		Seq:     seq,
+		Addrs:   addrs,
+	}
+	b, err := m.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	if op.Priority != 0 {
+		if runtime.GOOS != "openbsd" {
+			return nil, fmt.Errorf("route priorities are only supported on OpenBSD")
+		}
+		// x/net/route has no field for OpenBSD's rtm_priority, so
+		// set it in the marshaled rt_msghdr.
+		b[rtmPriorityOffset] = op.Priority
+	}
+	return b, nil
+}
+
+// rtmPriorityOffset is the offset of rtm_priority in OpenBSD's struct
+// rt_msghdr, after rtm_msglen, rtm_version, rtm_type, rtm_hdrlen,
+// rtm_index and rtm_tableid.
+const rtmPriorityOffset = 10
+
+// kernelRoutes returns the static routes in the kernel routing table that
+// point into the interface named tunname, or via one of its addresses.
+func kernelRoutes(tunname string) ([]Entry, error) {
+	ifc, err := net.InterfaceByName(tunname)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := ifc.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var own []netip.Addr
+	for _, a := range addrs {
+		if ipn, ok := a.(*net.IPNet); ok {
+			if ip, ok := netip.AddrFromSlice(ipn.IP); ok {
+				own = append(own, ip.Unmap())
+			}
+		}
+	}
+	rib, err := route.FetchRIB(unix.AF_UNSPEC, unix.NET_RT_DUMP, 0)
+	if err != nil {
+		return nil, fmt.Errorf("route.FetchRIB: %w", err)
+	}
+	msgs, err := route.ParseRIB(route.RIBTypeRoute, rib)
+	if err != nil {
+		return nil, fmt.Errorf("route.ParseRIB: %w", err)
+	}
+	return tunRoutes(msgs, ifc.Index, own), nil
+}
+
+// tunRoutes returns the Entries of the static routes in msgs that point
+// into the interface with index ifIndex, or via one of its addresses in
+// own. The routes that the kernel adds for the interface's own addresses
+// aren't static, so they're left out.
+//
+// A gateway merely being in Tailscale's address ranges isn't enough: ISPs
+// doing CGNAT hand out gateways in 100.64.0.0/10 too, and those routes,
+// such as the host's default route, aren't ours to remove.
+func tunRoutes(msgs []route.Message, ifIndex int, own []netip.Addr) []Entry {
+	var ret []Entry
+	for _, m := range msgs {
+		rm, ok := m.(*route.RouteMessage)
+		if !ok || rm.Flags&unix.RTF_STATIC == 0 || len(rm.Addrs) <= unix.RTAX_GATEWAY {
+			continue
+		}
+		if rm.Index != ifIndex && !slices.Contains(own, routeAddr(rm.Addrs[unix.RTAX_GATEWAY])) {
+			continue
+		}
+		dst := routeAddr(rm.Addrs[unix.RTAX_DST])
+		if !dst.IsValid() {
+			continue
+		}
+		var mask route.Addr
+		if len(rm.Addrs) > unix.RTAX_NETMASK {
+			mask = rm.Addrs[unix.RTAX_NETMASK]
+		}
+		bits := -1
+		switch {
+		case mask != nil:
+			bits = maskBits(mask, dst.BitLen())
+		case rm.Flags&unix.RTF_HOST != 0:
+			bits = dst.BitLen()
+		case dst.IsUnspecified():
+			bits = 0
+		}
+		if bits < 0 {
+			continue
+		}
+		ret = append(ret, Entry{Dst: netip.PrefixFrom(dst, bits)})
+	}
+	return ret
+}
+
+// routeAddr returns the IP address of a, or the zero Addr if a isn't one.
+func routeAddr(a route.Addr) netip.Addr {
+	switch a := a.(type) {
+	case *route.Inet4Addr:
+		return netip.AddrFrom4(a.IP)
+	case *route.Inet6Addr:
+		return netip.AddrFrom16(a.IP)
+	}
+	return netip.Addr{}
+}
+
+// maskBits returns the prefix length of the netmask a, for addresses of
+// bitLen bits, or -1 if it isn't a valid one.
+func maskBits(a route.Addr, bitLen int) int {
+	ip := routeAddr(a)
+	if !ip.IsValid() {
+		return -1
+	}
+	b := ip.AsSlice()
+	if len(b)*8 != bitLen {
+		return -1
+	}
+	ones, size := net.IPMask(b).Size()
+	if size == 0 {
+		return -1
+	}
+	return ones
+}
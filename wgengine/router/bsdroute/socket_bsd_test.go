@@ -0,0 +1,127 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package bsdroute
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+
+	"golang.org/x/net/route"
+	"golang.org/x/sys/unix"
+)
+
+func TestRouteMessage(t *testing.T) {
+	tests := []struct {
+		op       Op
+		wantType int
+		wantHost bool
+		wantMask bool
+	}{
+		{Op{Entry: Entry{Dst: netip.MustParsePrefix("10.1.2.0/24")}}, unix.RTM_ADD, false, true},
+		{Op{Del: true, Entry: Entry{Dst: netip.MustParsePrefix("100.64.1.2/32")}}, unix.RTM_DELETE, true, false},
+		{Op{Entry: Entry{Dst: netip.MustParsePrefix("fd7a:115c:a1e0::/48")}}, unix.RTM_ADD, false, true},
+	}
+	for _, tt := range tests {
+		b, err := routeMessage(tt.op, 7, 1234, 1)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.op, err)
+		}
+		msgs, err := route.ParseRIB(route.RIBTypeRoute, b)
+		if err != nil {
+			t.Fatalf("%v: ParseRIB: %v", tt.op, err)
+		}
+		if len(msgs) != 1 {
+			t.Fatalf("%v: got %d messages; want 1", tt.op, len(msgs))
+		}
+		m := msgs[0].(*route.RouteMessage)
+		if m.Type != tt.wantType {
+			t.Errorf("%v: type = %d; want %d", tt.op, m.Type, tt.wantType)
+		}
+		if got := m.Flags&unix.RTF_HOST != 0; got != tt.wantHost {
+			t.Errorf("%v: RTF_HOST = %v; want %v", tt.op, got, tt.wantHost)
+		}
+		if la, ok := m.Addrs[unix.RTAX_GATEWAY].(*route.LinkAddr); !ok || la.Index != 7 {
+			t.Errorf("%v: gateway = %#v; want link#7", tt.op, m.Addrs[unix.RTAX_GATEWAY])
+		}
+		hasMask := len(m.Addrs) > unix.RTAX_NETMASK && m.Addrs[unix.RTAX_NETMASK] != nil
+		if hasMask != tt.wantMask {
+			t.Errorf("%v: has netmask = %v; want %v", tt.op, hasMask, tt.wantMask)
+		}
+	}
+}
+
+func TestTunRoutes(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	var msgs []route.Message
+	// Routes as the routing socket installs them, read back as in a
+	// routing table dump.
+	for _, dst := range []string{"100.64.0.0/10", "100.101.102.103/32", "0.0.0.0/1", "fd7a:115c:a1e0::/48"} {
+		b, err := routeMessage(Op{Entry: Entry{Dst: pfx(dst)}}, 7, 1234, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := route.ParseRIB(route.RIBTypeRoute, b)
+		if err != nil {
+			t.Fatalf("%v: ParseRIB: %v", dst, err)
+		}
+		msgs = append(msgs, m...)
+	}
+	msgs = append(msgs,
+		// The kernel's own route to an address of the interface.
+		&route.RouteMessage{
+			Flags: unix.RTF_UP | unix.RTF_HOST,
+			Index: 7,
+			Addrs: []route.Addr{
+				unix.RTAX_DST:     &route.Inet4Addr{IP: [4]byte{100, 101, 102, 103}},
+				unix.RTAX_GATEWAY: &route.LinkAddr{Index: 7},
+			},
+		},
+		// Another interface's default route.
+		&route.RouteMessage{
+			Flags: unix.RTF_UP | unix.RTF_STATIC | unix.RTF_GATEWAY,
+			Index: 1,
+			Addrs: []route.Addr{
+				unix.RTAX_DST:     &route.Inet4Addr{},
+				unix.RTAX_GATEWAY: &route.Inet4Addr{IP: [4]byte{192, 168, 1, 1}},
+				unix.RTAX_NETMASK: &route.Inet4Addr{},
+			},
+		},
+		// A default route via an ISP's CGNAT gateway, which is in
+		// Tailscale's range but isn't ours.
+		&route.RouteMessage{
+			Flags: unix.RTF_UP | unix.RTF_STATIC | unix.RTF_GATEWAY,
+			Index: 2,
+			Addrs: []route.Addr{
+				unix.RTAX_DST:     &route.Inet4Addr{},
+				unix.RTAX_GATEWAY: &route.Inet4Addr{IP: [4]byte{100, 64, 0, 1}},
+				unix.RTAX_NETMASK: &route.Inet4Addr{},
+			},
+		},
+		// A route via the interface's address, on another interface.
+		&route.RouteMessage{
+			Flags: unix.RTF_UP | unix.RTF_STATIC | unix.RTF_GATEWAY,
+			Index: 3,
+			Addrs: []route.Addr{
+				unix.RTAX_DST:     &route.Inet4Addr{IP: [4]byte{10, 1, 0, 0}},
+				unix.RTAX_GATEWAY: &route.Inet4Addr{IP: [4]byte{100, 101, 102, 103}},
+				unix.RTAX_NETMASK: &route.Inet4Addr{IP: [4]byte{255, 255, 0, 0}},
+			},
+		},
+	)
+
+	got := tunRoutes(msgs, 7, []netip.Addr{netip.MustParseAddr("100.101.102.103")})
+	want := []Entry{
+		{Dst: pfx("100.64.0.0/10")},
+		{Dst: pfx("100.101.102.103/32")},
+		{Dst: pfx("0.0.0.0/1")},
+		{Dst: pfx("fd7a:115c:a1e0::/48")},
+		{Dst: pfx("10.1.0.0/16")},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("tunRoutes = %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,20 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !(darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package bsdroute
+
+import "errors"
+
+type routeSocket struct{}
+
+func newRouteSocket(tunname string) (*routeSocket, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (*routeSocket) apply(Op) error { return errors.ErrUnsupported }
+
+func (*routeSocket) Close() error { return nil }
+
+func kernelRoutes(tunname string) ([]Entry, error) { return nil, errors.ErrUnsupported }
@@ -0,0 +1,87 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package router
+
+import (
+	"cmp"
+	"net/netip"
+	"slices"
+	"strconv"
+
+	"tailscale.com/net/netmon"
+)
+
+// The BSDs have no policy routing to keep Tailscale's own traffic, such as
+// magicsock's packets to peers and DERP, out of Tailscale's routes, as the
+// Linux router does with ip rules. Without it, an exit node's default route
+// loops that traffic back into the Tailscale interface. Instead, on FreeBSD
+// and OpenBSD, Tailscale's own sockets can use a separate routing table
+// (see netns.SetBypassRoutingTable), in which the router keeps the
+// system's routes to its local networks and its IPv4 and IPv6 default
+// routes.
+
+// bypassTableRoute is a route in the bypass routing table.
+type bypassTableRoute struct {
+	Dst netip.Prefix
+	Dev string     // the interface a local network is on, or empty for a default route
+	Via netip.Addr // the local address on Dev, or the default gateway
+}
+
+// wantBypassTableRoutes returns the routes to keep in the bypass routing
+// table: the local networks of state other than on tunname, and default
+// routes via the IPv4 gateway gw4 and the IPv6 gateway gw6, if valid.
+func wantBypassTableRoutes(state *netmon.State, tunname string, gw4, gw6 netip.Addr) []bypassTableRoute {
+	var ret []bypassTableRoute
+	for lan, dev := range localNetworks(state, tunname) {
+		for _, pfx := range state.InterfaceIPs[dev] {
+			if pfx.Masked() == lan {
+				ret = append(ret, bypassTableRoute{Dst: lan, Dev: dev, Via: pfx.Addr()})
+				break
+			}
+		}
+	}
+	slices.SortFunc(ret, func(a, b bypassTableRoute) int {
+		return cmp.Or(a.Dst.Addr().Compare(b.Dst.Addr()), cmp.Compare(a.Dst.Bits(), b.Dst.Bits()))
+	})
+	// The default routes come last, as their gateways are reached
+	// through the local networks' routes.
+	if gw4.IsValid() {
+		ret = append(ret, bypassTableRoute{Dst: netip.PrefixFrom(netip.IPv4Unspecified(), 0), Via: gw4})
+	}
+	if gw6.IsValid() {
+		ret = append(ret, bypassTableRoute{Dst: netip.PrefixFrom(netip.IPv6Unspecified(), 0), Via: gw6})
+	}
+	return ret
+}
+
+// bypassTableRouteCmd returns the route(8) command on goos that adds (or
+// with del, deletes) r in the routing table numbered table.
+func bypassTableRouteCmd(goos string, table int, r bypassTableRoute, del bool) []string {
+	verb := "add"
+	if del {
+		verb = "delete"
+	}
+	family := "-inet"
+	if r.Dst.Addr().Is6() {
+		family = "-inet6"
+	}
+	args := []string{"route"}
+	if goos == "openbsd" {
+		args = append(args, "-T", strconv.Itoa(table))
+	}
+	args = append(args, "-q", "-n", verb, family, r.Dst.String())
+	switch {
+	case r.Dev == "":
+		args = append(args, r.Via.String())
+	case goos == "openbsd":
+		// OpenBSD's route(8) names interfaces by address.
+		args = append(args, "-iface", r.Via.String())
+	default:
+		args = append(args, "-iface", r.Dev)
+	}
+	if goos == "freebsd" {
+		args = append(args, "-fib", strconv.Itoa(table))
+	}
+	return args
+}
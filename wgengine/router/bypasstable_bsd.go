@@ -0,0 +1,170 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build darwin || freebsd || openbsd
+
+package router
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/route"
+	"golang.org/x/sys/unix"
+	"tailscale.com/net/netmon"
+	"tailscale.com/net/netns"
+	"tailscale.com/types/logger"
+)
+
+// bypassTable keeps the system's routes in the bypass routing table, if
+// tailscaled was configured with one, in line with the network.
+type bypassTable struct {
+	logf    logger.Logf
+	cmd     commandRunner
+	netMon  *netmon.Monitor
+	tunname string
+	table   int // or 0 if there's no bypass table
+
+	mu     sync.Mutex
+	routes []bypassTableRoute // installed, in order
+}
+
+func newBypassTable(logf logger.Logf, cmd commandRunner, netMon *netmon.Monitor, tunname string) *bypassTable {
+	table := netns.BypassRoutingTable()
+	if runtime.GOOS != "freebsd" && runtime.GOOS != "openbsd" {
+		table = 0
+	}
+	return &bypassTable{
+		logf:    logf,
+		cmd:     cmd,
+		netMon:  netMon,
+		tunname: tunname,
+		table:   table,
+	}
+}
+
+// update changes the routes in the bypass table to those of the current
+// network.
+func (b *bypassTable) update() error {
+	if b.table == 0 || b.netMon == nil {
+		return nil
+	}
+	gw4, _, _ := b.netMon.GatewayAndSelfIP()
+	gw6, err := defaultGateway6()
+	if err != nil {
+		b.logf("bypass table: finding IPv6 gateway: %v", err)
+	}
+	want := wantBypassTableRoutes(b.netMon.InterfaceState(), b.tunname, gw4, gw6)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.setLocked(want)
+}
+
+// close removes the routes from the bypass table.
+func (b *bypassTable) close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.setLocked(nil)
+}
+
+func (b *bypassTable) setLocked(want []bypassTableRoute) error {
+	if slices.Equal(b.routes, want) {
+		return nil
+	}
+	var errq error
+	// Delete in reverse order, so that the default route goes before the
+	// routes its gateway is reached through.
+	for i := len(b.routes) - 1; i >= 0; i-- {
+		r := b.routes[i]
+		if slices.Contains(want, r) {
+			continue
+		}
+		if err := b.run(r, true); err != nil {
+			b.logf("bypass table: %v", err)
+			if errq == nil {
+				errq = err
+			}
+			continue
+		}
+		b.routes = slices.Delete(b.routes, i, i+1)
+	}
+	for _, r := range want {
+		if slices.Contains(b.routes, r) {
+			continue
+		}
+		if err := b.run(r, false); err != nil {
+			b.logf("bypass table: %v", err)
+			if errq == nil {
+				errq = err
+			}
+			continue
+		}
+		b.routes = append(b.routes, r)
+	}
+	return errq
+}
+
+// run adds (or with del, deletes) r in the bypass table. Adding a route
+// that exists, such as a local network's route that the kernel added to
+// every FIB, or deleting one that doesn't, succeeds.
+func (b *bypassTable) run(r bypassTableRoute, del bool) error {
+	args := bypassTableRouteCmd(runtime.GOOS, b.table, r, del)
+	out, err := b.cmd.output(args...)
+	if err == nil {
+		return nil
+	}
+	if s := string(out); del && strings.Contains(s, "not in table") || !del && strings.Contains(s, "File exists") {
+		return nil
+	}
+	return fmt.Errorf("%v: %w\n%s", args, err, out)
+}
+
+// defaultGateway6 returns the gateway of the system's IPv6 default route,
+// with its zone if it's link-local, or the zero Addr if there's none.
+func defaultGateway6() (netip.Addr, error) {
+	rib, err := route.FetchRIB(unix.AF_INET6, unix.NET_RT_DUMP, 0)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("route.FetchRIB: %w", err)
+	}
+	msgs, err := route.ParseRIB(route.RIBTypeRoute, rib)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("route.ParseRIB: %w", err)
+	}
+	for _, m := range msgs {
+		rm, ok := m.(*route.RouteMessage)
+		if !ok || rm.Flags&unix.RTF_GATEWAY == 0 || len(rm.Addrs) <= unix.RTAX_NETMASK {
+			continue
+		}
+		dst, ok := rm.Addrs[unix.RTAX_DST].(*route.Inet6Addr)
+		if !ok || dst.IP != [16]byte{} {
+			continue
+		}
+		// The default route's netmask may be left out or be all zeros.
+		if mask, ok := rm.Addrs[unix.RTAX_NETMASK].(*route.Inet6Addr); ok && mask.IP != [16]byte{} {
+			continue
+		}
+		gw, ok := rm.Addrs[unix.RTAX_GATEWAY].(*route.Inet6Addr)
+		if !ok {
+			continue
+		}
+		ip := netip.AddrFrom16(gw.IP)
+		if ip.IsLinkLocalUnicast() {
+			zone := gw.ZoneID
+			if zone == 0 {
+				zone = rm.Index
+			}
+			ifc, err := net.InterfaceByIndex(zone)
+			if err != nil {
+				continue
+			}
+			ip = ip.WithZone(ifc.Name)
+		}
+		return ip, nil
+	}
+	return netip.Addr{}, nil
+}
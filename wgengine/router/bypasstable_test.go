@@ -0,0 +1,84 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package router
+
+import (
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"tailscale.com/net/netmon"
+)
+
+func TestWantBypassTableRoutes(t *testing.T) {
+	up := netmon.Interface{Interface: &net.Interface{Flags: net.FlagUp}}
+	loopback := netmon.Interface{Interface: &net.Interface{Flags: net.FlagUp | net.FlagLoopback}}
+	state := &netmon.State{
+		Interface: map[string]netmon.Interface{
+			"em0":        up,
+			"em1":        up,
+			"lo0":        loopback,
+			"tailscale0": up,
+		},
+		InterfaceIPs: map[string][]netip.Prefix{
+			"em0":        mustCIDRs("192.168.1.7/24", "fd00:1::7/64"),
+			"em1":        mustCIDRs("10.0.0.2/8"),
+			"lo0":        mustCIDRs("127.0.0.1/8"),
+			"tailscale0": mustCIDRs("100.64.0.1/10"),
+		},
+	}
+	gw := netip.MustParseAddr("192.168.1.1")
+	gw6 := netip.MustParseAddr("fe80::1%em0")
+
+	got := wantBypassTableRoutes(state, "tailscale0", gw, gw6)
+	want := []bypassTableRoute{
+		{Dst: netip.MustParsePrefix("10.0.0.0/8"), Dev: "em1", Via: netip.MustParseAddr("10.0.0.2")},
+		{Dst: netip.MustParsePrefix("192.168.1.0/24"), Dev: "em0", Via: netip.MustParseAddr("192.168.1.7")},
+		{Dst: netip.MustParsePrefix("fd00:1::/64"), Dev: "em0", Via: netip.MustParseAddr("fd00:1::7")},
+		{Dst: netip.MustParsePrefix("0.0.0.0/0"), Via: gw},
+		{Dst: netip.MustParsePrefix("::/0"), Via: gw6},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wantBypassTableRoutes = %v; want %v", got, want)
+	}
+
+	// Without an IPv6 gateway, there's only the IPv4 default route.
+	got = wantBypassTableRoutes(state, "tailscale0", gw, netip.Addr{})
+	if !reflect.DeepEqual(got, want[:4]) {
+		t.Errorf("wantBypassTableRoutes without IPv6 gateway = %v; want %v", got, want[:4])
+	}
+
+	// Without gateways, there are no default routes.
+	got = wantBypassTableRoutes(state, "tailscale0", netip.Addr{}, netip.Addr{})
+	if !reflect.DeepEqual(got, want[:3]) {
+		t.Errorf("wantBypassTableRoutes without gateways = %v; want %v", got, want[:3])
+	}
+}
+
+func TestBypassTableRouteCmd(t *testing.T) {
+	lan := bypassTableRoute{Dst: netip.MustParsePrefix("192.168.1.0/24"), Dev: "em0", Via: netip.MustParseAddr("192.168.1.7")}
+	lan6 := bypassTableRoute{Dst: netip.MustParsePrefix("fd00:1::/64"), Dev: "em0", Via: netip.MustParseAddr("fd00:1::7")}
+	def := bypassTableRoute{Dst: netip.MustParsePrefix("0.0.0.0/0"), Via: netip.MustParseAddr("192.168.1.1")}
+	def6 := bypassTableRoute{Dst: netip.MustParsePrefix("::/0"), Via: netip.MustParseAddr("fe80::1%em0")}
+	tests := []struct {
+		goos string
+		r    bypassTableRoute
+		del  bool
+		want []string
+	}{
+		{"freebsd", lan, false, []string{"route", "-q", "-n", "add", "-inet", "192.168.1.0/24", "-iface", "em0", "-fib", "3"}},
+		{"freebsd", lan6, true, []string{"route", "-q", "-n", "delete", "-inet6", "fd00:1::/64", "-iface", "em0", "-fib", "3"}},
+		{"freebsd", def, false, []string{"route", "-q", "-n", "add", "-inet", "0.0.0.0/0", "192.168.1.1", "-fib", "3"}},
+		{"openbsd", lan, false, []string{"route", "-T", "3", "-q", "-n", "add", "-inet", "192.168.1.0/24", "-iface", "192.168.1.7"}},
+		{"openbsd", def, true, []string{"route", "-T", "3", "-q", "-n", "delete", "-inet", "0.0.0.0/0", "192.168.1.1"}},
+		{"freebsd", def6, false, []string{"route", "-q", "-n", "add", "-inet6", "::/0", "fe80::1%em0", "-fib", "3"}},
+		{"openbsd", def6, false, []string{"route", "-T", "3", "-q", "-n", "add", "-inet6", "::/0", "fe80::1%em0"}},
+	}
+	for _, tt := range tests {
+		if got := bypassTableRouteCmd(tt.goos, 3, tt.r, tt.del); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("bypassTableRouteCmd(%s, %v, del=%v) = %q; want %q", tt.goos, tt.r.Dst, tt.del, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package router
+
+// commandRunner abstracts helpers to run OS commands. It exists
+// purely to swap out osCommandRunner with a fake runner in tests.
+type commandRunner interface {
+	run(...string) error
+	output(...string) ([]byte, error)
+}
@@ -4,6 +4,8 @@
 package router
 
 import (
+	"net/netip"
+
 	"go4.org/netipx"
 	"tailscale.com/types/logger"
 )
@@ -36,8 +38,15 @@ func (cr *consolidatingRouter) consolidateRoutes(cfg *Config) *Config {
 	if len(cfg.Routes) == 2 && cfg.Routes[0].Addr().Is4() != cfg.Routes[1].Addr().Is4() {
 		return cfg
 	}
+	// Routes with their own metric are kept as they are, as merging them
+	// with others would lose it.
 	var builder netipx.IPSetBuilder
+	var metricRoutes []netip.Prefix
 	for _, route := range cfg.Routes {
+		if _, ok := cfg.RouteMetrics[route]; ok {
+			metricRoutes = append(metricRoutes, route)
+			continue
+		}
 		builder.AddPrefix(route)
 	}
 	set, err := builder.IPSet()
@@ -45,7 +54,7 @@ func (cr *consolidatingRouter) consolidateRoutes(cfg *Config) *Config {
 		cr.logf("consolidateRoutes failed, keeping existing routes: %s", err)
 		return cfg
 	}
-	newRoutes := set.Prefixes()
+	newRoutes := append(set.Prefixes(), metricRoutes...)
 	oldLength := len(cfg.Routes)
 	newLength := len(newRoutes)
 	if oldLength == newLength {
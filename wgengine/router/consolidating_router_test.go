@@ -54,6 +54,23 @@ func TestConsolidateRoutes(t *testing.T) {
 			&Config{Routes: parseRoutes("10.0.0.0/32", "10.0.0.0/31")},
 			&Config{Routes: parseRoutes("10.0.0.0/31")},
 		},
+		{
+			"routes with a metric are kept",
+			&Config{
+				Routes: parseRoutes("10.0.0.0/32", "192.168.0.0/25", "10.0.0.0/31", "192.168.0.128/25"),
+				RouteMetrics: map[netip.Prefix]uint32{
+					netip.MustParsePrefix("192.168.0.0/25"):   100,
+					netip.MustParsePrefix("192.168.0.128/25"): 100,
+				},
+			},
+			&Config{
+				Routes: parseRoutes("10.0.0.0/31", "192.168.0.0/25", "192.168.0.128/25"),
+				RouteMetrics: map[netip.Prefix]uint32{
+					netip.MustParsePrefix("192.168.0.0/25"):   100,
+					netip.MustParsePrefix("192.168.0.128/25"): 100,
+				},
+			},
+		},
 	}
 
 	cr := &consolidatingRouter{logf: log.Printf}
@@ -0,0 +1,48 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package router
+
+import (
+	"sync/atomic"
+
+	"tailscale.com/envknob"
+	"tailscale.com/types/logger"
+)
+
+// dryRun reports whether routers that support it should only log the
+// address and route changes that Set would make, rather than make them.
+// So far, that's the NetBSD router.
+var dryRun = envknob.RegisterBool("TS_DEBUG_ROUTER_DRY_RUN")
+
+// pendingChanges is the changes skipped by the most recent Set in dry-run
+// mode.
+var pendingChanges atomic.Pointer[[]string]
+
+// PendingChanges returns the address and route changes that the most recent
+// call to Set skipped in dry-run mode (see TS_DEBUG_ROUTER_DRY_RUN), in the
+// order it would have made them, and whether there was such a call.
+//
+// As Set in dry-run mode changes nothing, the changes are those needed to go
+// from the configuration the router had when dry-run mode was turned on to
+// the latest one.
+func PendingChanges() (changes []string, ok bool) {
+	p := pendingChanges.Load()
+	if p == nil {
+		return nil, false
+	}
+	return *p, true
+}
+
+// setPendingChanges logs and records the changes skipped by Set in dry-run
+// mode.
+func setPendingChanges(logf logger.Logf, changes []string) {
+	for _, c := range changes {
+		logf("dry run: would %s", c)
+	}
+	if len(changes) == 0 {
+		logf("dry run: no changes")
+	}
+	changes = append([]string{}, changes...)
+	pendingChanges.Store(&changes)
+}
@@ -0,0 +1,125 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package router
+
+import (
+	"errors"
+	"runtime"
+
+	"tailscale.com/net/netmon"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/bsdfw"
+	"tailscale.com/util/multierr"
+)
+
+// bsdFirewall keeps the system packet filter's Tailscale rules in sync with
+// the router configuration on the BSDs. If no supported packet filter is
+// enabled, it does nothing.
+type bsdFirewall struct {
+	logf    logger.Logf
+	tunname string
+	fw      bsdfw.Runner // or nil if not managing a packet filter
+
+	snatIf   string // interface SNAT rule is installed for, or ""
+	stateful bool   // whether stateful filtering rules are installed
+}
+
+func newBSDFirewall(logf logger.Logf, tunname string) *bsdFirewall {
+	f := &bsdFirewall{logf: logf, tunname: tunname}
+	if runtime.GOOS == "darwin" {
+		// The macOS packet filter belongs to the system; leave it be.
+		return f
+	}
+	fw, err := bsdfw.New(logf)
+	if err != nil {
+		if errors.Is(err, bsdfw.ErrNoFirewall) {
+			logf("no packet filter enabled; not managing firewall rules")
+		} else {
+			logf("packet filter: %v", err)
+		}
+		return f
+	}
+	if err := fw.AddBase(tunname); err != nil {
+		logf("adding base packet filter rules: %v", err)
+	}
+	f.fw = fw
+	return f
+}
+
+// set updates the SNAT and stateful filtering rules for cfg.
+func (f *bsdFirewall) set(cfg *Config) error {
+	if f.fw == nil {
+		return nil
+	}
+	var errs []error
+
+	var snatIf string
+	if cfg.SNATSubnetRoutes && len(cfg.SubnetRoutes) > 0 {
+		ifName, err := netmon.DefaultRouteInterface()
+		if err != nil {
+			errs = append(errs, err)
+		}
+		snatIf = ifName
+	}
+	if snatIf != f.snatIf {
+		var err error
+		if snatIf == "" {
+			err = f.fw.DelSNATRule()
+		} else {
+			err = f.fw.AddSNATRule(snatIf)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			f.snatIf = snatIf
+		}
+	}
+
+	stateful := cfg.StatefulFiltering && len(cfg.SubnetRoutes) > 0
+	if stateful != f.stateful {
+		var err error
+		if stateful {
+			err = f.fw.AddStatefulRule(f.tunname)
+		} else {
+			err = f.fw.DelStatefulRule()
+		}
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			f.stateful = stateful
+		}
+	}
+	return multierr.New(errs...)
+}
+
+// updateMagicsockPort lets magicsock's new UDP port through the packet
+// filter.
+func (f *bsdFirewall) updateMagicsockPort(port uint16, network string) error {
+	if f.fw == nil || port == 0 {
+		return nil
+	}
+	return f.fw.AddMagicsockPortRule(port, network)
+}
+
+// close removes all of Tailscale's packet filter rules.
+func (f *bsdFirewall) close() error {
+	if f.fw == nil {
+		return nil
+	}
+	return f.fw.Cleanup()
+}
+
+// cleanUpFirewall removes any packet filter rules left behind by a previous
+// tailscaled that didn't shut down cleanly.
+func cleanUpFirewall(logf logger.Logf) {
+	fw, err := bsdfw.New(logger.Discard)
+	if err != nil {
+		return
+	}
+	if err := fw.Cleanup(); err != nil {
+		logf("packet filter cleanup: %v", err)
+	}
+}
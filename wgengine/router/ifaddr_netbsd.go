@@ -0,0 +1,192 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package router
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// The address ioctls for IPv6 aren't in x/sys/unix. Their values come from
+// evaluating the macros in NetBSD's sys/netinet6/in6_var.h:
+//
+//	#define SIOCDIFADDR_IN6	_IOW('i', 25, struct in6_ifreq)
+//	#define SIOCAIFADDR_IN6	_IOW('i', 107, struct in6_aliasreq)
+const (
+	siocDIFADDR_IN6 = 0x81206919
+	siocAIFADDR_IN6 = 0x8080696b
+)
+
+// nd6InfiniteLifetime is ND6_INFINITE_LIFETIME, the lifetime of addresses
+// that don't expire.
+const nd6InfiniteLifetime = 0xffffffff
+
+// ifreq is NetBSD's struct ifreq, with the ifr_addr or ifr_flags member of
+// its union.
+type ifreq struct {
+	Name [unix.IFNAMSIZ]byte
+	Addr [128]byte // sizeof(struct sockaddr_storage)
+}
+
+// ifaliasreq is NetBSD's struct ifaliasreq, for SIOCAIFADDR.
+type ifaliasreq struct {
+	Name    [unix.IFNAMSIZ]byte
+	Addr    unix.RawSockaddrInet4
+	Dstaddr unix.RawSockaddrInet4
+	Mask    unix.RawSockaddrInet4
+}
+
+// in6Ifreq is NetBSD's struct in6_ifreq, with the ifr_addr member of its
+// union, for SIOCDIFADDR_IN6.
+type in6Ifreq struct {
+	Name [unix.IFNAMSIZ]byte
+	Addr unix.RawSockaddrInet6
+	_    [244]byte // the rest of the union, up to sizeof(struct icmp6_ifstat)
+}
+
+// in6Aliasreq is NetBSD's struct in6_aliasreq, for SIOCAIFADDR_IN6.
+type in6Aliasreq struct {
+	Name       [unix.IFNAMSIZ]byte
+	Addr       unix.RawSockaddrInet6
+	Dstaddr    unix.RawSockaddrInet6
+	Prefixmask unix.RawSockaddrInet6
+	Flags      int32
+	Lifetime   struct {
+		Expire    int64
+		Preferred int64
+		Vltime    uint32
+		Pltime    uint32
+	}
+}
+
+func sockaddr4(a netip.Addr) unix.RawSockaddrInet4 {
+	return unix.RawSockaddrInet4{
+		Len:    unix.SizeofSockaddrInet4,
+		Family: unix.AF_INET,
+		Addr:   a.As4(),
+	}
+}
+
+func sockaddr6(a netip.Addr) unix.RawSockaddrInet6 {
+	return unix.RawSockaddrInet6{
+		Len:    unix.SizeofSockaddrInet6,
+		Family: unix.AF_INET6,
+		Addr:   a.As16(),
+	}
+}
+
+// maskAddr returns the netmask of the prefix length of p as an address.
+func maskAddr(p netip.Prefix) netip.Addr {
+	a, _ := netip.AddrFromSlice(net.CIDRMask(p.Bits(), p.Addr().BitLen()))
+	return a
+}
+
+// addrRequest returns the ioctl request and its argument that add (or with
+// del, remove) addr on the interface named ifname. As with ifconfig, IPv6
+// addresses are added with our whole ULA /48 as their prefix.
+func addrRequest(ifname string, addr netip.Prefix, del bool) (req uintptr, arg unsafe.Pointer) {
+	if addr.Addr().Is6() {
+		if del {
+			r := &in6Ifreq{Addr: sockaddr6(addr.Addr())}
+			copy(r.Name[:], ifname)
+			return siocDIFADDR_IN6, unsafe.Pointer(r)
+		}
+		r := &in6Aliasreq{
+			Addr:       sockaddr6(addr.Addr()),
+			Prefixmask: sockaddr6(maskAddr(netip.PrefixFrom(addr.Addr(), 48))),
+		}
+		r.Lifetime.Vltime = nd6InfiniteLifetime
+		r.Lifetime.Pltime = nd6InfiniteLifetime
+		copy(r.Name[:], ifname)
+		return siocAIFADDR_IN6, unsafe.Pointer(r)
+	}
+	if del {
+		r := new(ifreq)
+		copy(r.Name[:], ifname)
+		*(*unix.RawSockaddrInet4)(unsafe.Pointer(&r.Addr)) = sockaddr4(addr.Addr())
+		return unix.SIOCDIFADDR, unsafe.Pointer(r)
+	}
+	r := &ifaliasreq{
+		Addr: sockaddr4(addr.Addr()),
+		Mask: sockaddr4(maskAddr(addr)),
+	}
+	copy(r.Name[:], ifname)
+	return unix.SIOCAIFADDR, unsafe.Pointer(r)
+}
+
+// ifaddrSocket configures interface addresses and flags with ioctls on
+// datagram sockets, rather than by running ifconfig(8) for each change.
+type ifaddrSocket struct {
+	fd4 int
+	fd6 int // or -1 if IPv6 is unavailable
+}
+
+func newIfaddrSocket() (*ifaddrSocket, error) {
+	fd4, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		return nil, err
+	}
+	fd6, err := unix.Socket(unix.AF_INET6, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		fd6 = -1
+	}
+	return &ifaddrSocket{fd4: fd4, fd6: fd6}, nil
+}
+
+func ioctl(fd int, req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// setAddr adds (or with del, removes) addr on the interface named ifname.
+// Removing an address that isn't there succeeds. If the kernel doesn't
+// support the ioctl, the error matches [errors.ErrUnsupported].
+func (s *ifaddrSocket) setAddr(ifname string, addr netip.Prefix, del bool) error {
+	fd := s.fd4
+	if addr.Addr().Is6() {
+		if s.fd6 < 0 {
+			return errors.ErrUnsupported
+		}
+		fd = s.fd6
+	}
+	req, arg := addrRequest(ifname, addr, del)
+	err := ioctl(fd, req, arg)
+	switch {
+	case del && errors.Is(err, unix.EADDRNOTAVAIL):
+		return nil
+	case errors.Is(err, unix.ENOTTY):
+		return errors.ErrUnsupported
+	}
+	return err
+}
+
+// up brings up the interface named ifname.
+func (s *ifaddrSocket) up(ifname string) error {
+	var r ifreq
+	copy(r.Name[:], ifname)
+	if err := ioctl(s.fd4, unix.SIOCGIFFLAGS, unsafe.Pointer(&r)); err != nil {
+		return err
+	}
+	flags := (*int16)(unsafe.Pointer(&r.Addr))
+	if *flags&unix.IFF_UP != 0 {
+		return nil
+	}
+	*flags |= unix.IFF_UP
+	return ioctl(s.fd4, unix.SIOCSIFFLAGS, unsafe.Pointer(&r))
+}
+
+func (s *ifaddrSocket) Close() error {
+	if s.fd6 >= 0 {
+		unix.Close(s.fd6)
+	}
+	return unix.Close(s.fd4)
+}
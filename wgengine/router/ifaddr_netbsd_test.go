@@ -0,0 +1,76 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package router
+
+import (
+	"net/netip"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ioctlSize returns the argument size encoded in the ioctl request req.
+func ioctlSize(req uintptr) uintptr {
+	return (req >> 16) & 0x1fff
+}
+
+func TestIfaddrStructSizes(t *testing.T) {
+	tests := []struct {
+		name string
+		req  uintptr
+		size uintptr
+	}{
+		{"SIOCAIFADDR", unix.SIOCAIFADDR, unsafe.Sizeof(ifaliasreq{})},
+		{"SIOCDIFADDR", unix.SIOCDIFADDR, unsafe.Sizeof(ifreq{})},
+		{"SIOCGIFFLAGS", unix.SIOCGIFFLAGS, unsafe.Sizeof(ifreq{})},
+		{"SIOCAIFADDR_IN6", siocAIFADDR_IN6, unsafe.Sizeof(in6Aliasreq{})},
+		{"SIOCDIFADDR_IN6", siocDIFADDR_IN6, unsafe.Sizeof(in6Ifreq{})},
+	}
+	for _, tt := range tests {
+		if got := ioctlSize(tt.req); got != tt.size {
+			t.Errorf("%s argument size = %d; struct size = %d", tt.name, got, tt.size)
+		}
+	}
+}
+
+func TestAddrRequest(t *testing.T) {
+	req, arg := addrRequest("tun0", netip.MustParsePrefix("100.101.102.103/32"), false)
+	if req != unix.SIOCAIFADDR {
+		t.Fatalf("IPv4 add request = %#x; want SIOCAIFADDR", req)
+	}
+	ra := (*ifaliasreq)(arg)
+	if got := string(ra.Name[:4]); got != "tun0" || ra.Name[4] != 0 {
+		t.Errorf("name = %q; want tun0", ra.Name)
+	}
+	if ra.Addr.Family != unix.AF_INET || ra.Addr.Addr != [4]byte{100, 101, 102, 103} {
+		t.Errorf("addr = %+v; want 100.101.102.103", ra.Addr)
+	}
+	if ra.Mask.Addr != [4]byte{255, 255, 255, 255} {
+		t.Errorf("mask = %v; want /32", ra.Mask.Addr)
+	}
+
+	req, arg = addrRequest("tun0", netip.MustParsePrefix("100.101.102.103/32"), true)
+	if req != unix.SIOCDIFADDR {
+		t.Fatalf("IPv4 delete request = %#x; want SIOCDIFADDR", req)
+	}
+	if sa := (*unix.RawSockaddrInet4)(unsafe.Pointer(&(*ifreq)(arg).Addr)); sa.Addr != [4]byte{100, 101, 102, 103} {
+		t.Errorf("deleted addr = %+v; want 100.101.102.103", sa)
+	}
+
+	req, arg = addrRequest("tun0", netip.MustParsePrefix("fd7a:115c:a1e0::1/128"), false)
+	if req != siocAIFADDR_IN6 {
+		t.Fatalf("IPv6 add request = %#x; want SIOCAIFADDR_IN6", req)
+	}
+	r6 := (*in6Aliasreq)(arg)
+	if got := netip.AddrFrom16(r6.Addr.Addr); got != netip.MustParseAddr("fd7a:115c:a1e0::1") {
+		t.Errorf("IPv6 addr = %v", got)
+	}
+	if got := netip.AddrFrom16(r6.Prefixmask.Addr); got != netip.MustParseAddr("ffff:ffff:ffff::") {
+		t.Errorf("IPv6 prefix mask = %v; want /48", got)
+	}
+	if r6.Lifetime.Vltime != nd6InfiniteLifetime || r6.Lifetime.Pltime != nd6InfiniteLifetime {
+		t.Errorf("IPv6 lifetimes = %+v; want infinite", r6.Lifetime)
+	}
+}
@@ -405,7 +405,7 @@ func configureInterface(cfg *Config, tun *tun.NativeTun, ht *health.Tracker) (re
 			RouteData: winipcfg.RouteData{
 				Destination: route,
 				NextHop:     gateway,
-				Metric:      0,
+				Metric:      cfg.RouteMetrics[route],
 			},
 		}
 
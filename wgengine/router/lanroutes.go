@@ -0,0 +1,139 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package router
+
+import (
+	"maps"
+	"net/netip"
+	"slices"
+
+	"go4.org/netipx"
+	"tailscale.com/net/netmon"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/mak"
+	"tailscale.com/util/set"
+	"tailscale.com/wgengine/router/bsdroute"
+)
+
+// The BSD routers have no policy routing to keep traffic to the local
+// networks off Tailscale while an exit node is in use, as the Linux router
+// does with throw routes. Instead, they install routes more specific than
+// Tailscale's into the local network interfaces. Likewise, a route into
+// Tailscale for a whole local network would collide with the system's
+// route to it, so it's installed as two more specific routes.
+
+// splitPrefix returns the two halves of p, or p itself if it's a single
+// address.
+func splitPrefix(p netip.Prefix) []netip.Prefix {
+	p = p.Masked()
+	if p.IsSingleIP() {
+		return []netip.Prefix{p}
+	}
+	lo := netip.PrefixFrom(p.Addr(), p.Bits()+1)
+	hi := netip.PrefixFrom(netipx.PrefixLastIP(lo).Next(), p.Bits()+1)
+	return []netip.Prefix{lo, hi}
+}
+
+// localNetworks returns the local networks of the interfaces in state that
+// are up, other than tunname and loopback interfaces, mapped to the name of
+// the interface they're on.
+func localNetworks(state *netmon.State, tunname string) map[netip.Prefix]string {
+	if state == nil {
+		return nil
+	}
+	ret := make(map[netip.Prefix]string)
+	for name, pfxs := range state.InterfaceIPs {
+		iface := state.Interface[name]
+		if name == tunname || iface.Interface == nil || !iface.IsUp() || iface.IsLoopback() {
+			continue
+		}
+		for _, pfx := range pfxs {
+			if !pfx.IsSingleIP() {
+				ret[pfx.Masked()] = name
+			}
+		}
+	}
+	return ret
+}
+
+// splitLANRoutes returns routes with each route to a whole local network
+// of state replaced by its two halves.
+func splitLANRoutes(state *netmon.State, tunname string, routes []netip.Prefix) []netip.Prefix {
+	lans := localNetworks(state, tunname)
+	var ret []netip.Prefix
+	for _, r := range routes {
+		if _, ok := lans[r]; ok {
+			ret = append(ret, splitPrefix(r)...)
+		} else {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
+// bypassRoutes returns the routes that keep traffic to localRoutes on the
+// local networks of state, mapped to the interface each points into: the
+// halves of each local route, which take precedence over any of
+// Tailscale's routes that cover it. Local routes not on any local network
+// are skipped.
+func bypassRoutes(logf logger.Logf, state *netmon.State, tunname string, localRoutes []netip.Prefix) map[netip.Prefix]string {
+	lans := localNetworks(state, tunname)
+	// Check the most specific networks first.
+	nets := slices.SortedFunc(maps.Keys(lans), func(a, b netip.Prefix) int {
+		return b.Bits() - a.Bits()
+	})
+	var ret map[netip.Prefix]string
+	for _, lr := range localRoutes {
+		i := slices.IndexFunc(nets, func(n netip.Prefix) bool { return n.Contains(lr.Addr()) })
+		if i < 0 {
+			logf("local route %v is on no local network; not bypassing Tailscale for it", lr)
+			continue
+		}
+		for _, half := range splitPrefix(lr) {
+			mak.Set(&ret, half, lans[nets[i]])
+		}
+	}
+	return ret
+}
+
+// routeEntries returns the routes to install: routes into the Tailscale
+// interface, and the bypass routes into local interfaces.
+func routeEntries(routes []netip.Prefix, bypass map[netip.Prefix]string) set.Set[bsdroute.Entry] {
+	ret := set.Set[bsdroute.Entry]{}
+	for _, r := range routes {
+		ret.Add(bsdroute.Entry{Dst: r})
+	}
+	for r, dev := range bypass {
+		ret.Add(bsdroute.Entry{Dst: r, Dev: dev})
+	}
+	return ret
+}
+
+// splitDefaultRoutes returns routes with any IPv4 or IPv6 default route
+// replaced by the two halves of the address space, which together take
+// precedence over the system's default route without replacing it.
+func splitDefaultRoutes(routes []netip.Prefix) []netip.Prefix {
+	var ret []netip.Prefix
+	for _, r := range routes {
+		switch r {
+		case netip.PrefixFrom(netip.IPv4Unspecified(), 0):
+			ret = append(ret,
+				netip.MustParsePrefix("0.0.0.0/1"),
+				netip.MustParsePrefix("128.0.0.0/1"))
+		case netip.PrefixFrom(netip.IPv6Unspecified(), 0):
+			ret = append(ret,
+				netip.MustParsePrefix("::/1"),
+				netip.MustParsePrefix("8000::/1"))
+		default:
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
+// sortedPrefixes returns the prefixes in s in order, so that the routers
+// change addresses in the same order every time.
+func sortedPrefixes(s set.Set[netip.Prefix]) []netip.Prefix {
+	return slices.SortedFunc(maps.Keys(s), netipx.ComparePrefix)
+}
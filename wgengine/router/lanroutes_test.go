@@ -0,0 +1,87 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package router
+
+import (
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"tailscale.com/net/netmon"
+	"tailscale.com/util/set"
+	"tailscale.com/wgengine/router/bsdroute"
+)
+
+func TestSplitPrefix(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []netip.Prefix
+	}{
+		{"192.168.1.0/24", mustCIDRs("192.168.1.0/25", "192.168.1.128/25")},
+		{"192.168.1.7/24", mustCIDRs("192.168.1.0/25", "192.168.1.128/25")},
+		{"fd00::/64", mustCIDRs("fd00::/65", "fd00::8000:0:0:0/65")},
+		{"10.0.0.1/32", mustCIDRs("10.0.0.1/32")},
+	}
+	for _, tt := range tests {
+		if got := splitPrefix(netip.MustParsePrefix(tt.in)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitPrefix(%s) = %v; want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLANRoutes(t *testing.T) {
+	up := netmon.Interface{Interface: &net.Interface{Flags: net.FlagUp}}
+	loopback := netmon.Interface{Interface: &net.Interface{Flags: net.FlagUp | net.FlagLoopback}}
+	state := &netmon.State{
+		Interface: map[string]netmon.Interface{
+			"em0":        up,
+			"lo0":        loopback,
+			"tailscale0": up,
+		},
+		InterfaceIPs: map[string][]netip.Prefix{
+			"em0":        mustCIDRs("192.168.1.7/24", "fd00:1::7/64"),
+			"lo0":        mustCIDRs("127.0.0.1/8"),
+			"tailscale0": mustCIDRs("100.64.0.1/32"),
+		},
+	}
+
+	got := splitLANRoutes(state, "tailscale0", mustCIDRs("0.0.0.0/1", "192.168.1.0/24", "192.168.0.0/16", "127.0.0.0/8"))
+	want := mustCIDRs("0.0.0.0/1", "192.168.1.0/25", "192.168.1.128/25", "192.168.0.0/16", "127.0.0.0/8")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitLANRoutes = %v; want %v", got, want)
+	}
+
+	bypass := bypassRoutes(t.Logf, state, "tailscale0", mustCIDRs("192.168.1.0/24", "fd00:1::/64", "127.0.0.0/8"))
+	wantBypass := map[netip.Prefix]string{
+		netip.MustParsePrefix("192.168.1.0/25"):        "em0",
+		netip.MustParsePrefix("192.168.1.128/25"):      "em0",
+		netip.MustParsePrefix("fd00:1::/65"):           "em0",
+		netip.MustParsePrefix("fd00:1::8000:0:0:0/65"): "em0",
+	}
+	if !reflect.DeepEqual(bypass, wantBypass) {
+		t.Errorf("bypassRoutes = %v; want %v", bypass, wantBypass)
+	}
+
+	entries := routeEntries(mustCIDRs("100.64.0.0/10", "192.168.1.0/25"), bypass)
+	wantEntries := set.Of(
+		bsdroute.Entry{Dst: netip.MustParsePrefix("100.64.0.0/10")},
+		bsdroute.Entry{Dst: netip.MustParsePrefix("192.168.1.0/25")},
+		bsdroute.Entry{Dst: netip.MustParsePrefix("192.168.1.0/25"), Dev: "em0"},
+		bsdroute.Entry{Dst: netip.MustParsePrefix("192.168.1.128/25"), Dev: "em0"},
+		bsdroute.Entry{Dst: netip.MustParsePrefix("fd00:1::/65"), Dev: "em0"},
+		bsdroute.Entry{Dst: netip.MustParsePrefix("fd00:1::8000:0:0:0/65"), Dev: "em0"},
+	)
+	if !entries.Equal(wantEntries) {
+		t.Errorf("routeEntries = %v; want %v", entries, wantEntries)
+	}
+}
+
+func TestSplitDefaultRoutes(t *testing.T) {
+	got := splitDefaultRoutes(mustCIDRs("0.0.0.0/0", "10.0.0.0/8", "::/0", "100.64.0.1/32"))
+	want := mustCIDRs("0.0.0.0/1", "128.0.0.0/1", "10.0.0.0/8", "::/1", "8000::/1", "100.64.0.1/32")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
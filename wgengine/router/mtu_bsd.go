@@ -0,0 +1,100 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build darwin || freebsd || netbsd || openbsd
+
+package router
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"tailscale.com/net/tstun"
+	"tailscale.com/types/logger"
+)
+
+// tunMTU keeps the Tailscale interface's MTU at the configured one. Some
+// tun drivers ignore the MTU the device is created with, leaving the
+// driver's default.
+type tunMTU struct {
+	logf    logger.Logf
+	cmd     commandRunner
+	tunname string
+	def     int // the MTU when Config.NewMTU is 0
+
+	// lookup returns the interface. It's net.InterfaceByName, except in
+	// tests.
+	lookup func(name string) (*net.Interface, error)
+
+	mu      sync.Mutex
+	want    int // the MTU to set
+	applied int // the MTU last set, or 0
+	index   int // the interface index it was set on
+}
+
+func newTunMTU(logf logger.Logf, cmd commandRunner, tunname string) *tunMTU {
+	def := int(tstun.DefaultTUNMTU())
+	return &tunMTU{
+		logf:    logf,
+		cmd:     cmd,
+		tunname: tunname,
+		def:     def,
+		lookup:  net.InterfaceByName,
+		want:    def,
+	}
+}
+
+// set sets the interface's MTU to mtu, or to the default if it's 0.
+func (m *tunMTU) set(mtu int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.want = mtu
+	if mtu == 0 {
+		m.want = m.def
+	}
+	return m.applyLocked()
+}
+
+// pending returns the MTU that set would change the interface's MTU to,
+// if it would.
+func (m *tunMTU) pending(mtu int) (_ int, ok bool) {
+	if mtu == 0 {
+		mtu = m.def
+	}
+	ifc, err := m.lookup(m.tunname)
+	if err != nil || ifc.MTU == mtu {
+		return 0, false
+	}
+	return mtu, true
+}
+
+// check sets the interface's MTU again if the interface was recreated since
+// it was set, which resets it to the driver's default. An MTU changed by
+// hand with ifconfig(8) stays as it is.
+func (m *tunMTU) check() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.applyLocked()
+}
+
+func (m *tunMTU) applyLocked() error {
+	ifc, err := m.lookup(m.tunname)
+	if err != nil {
+		// The interface is gone; check sets the MTU if it comes back.
+		return nil
+	}
+	if ifc.Index == m.index && m.applied == m.want {
+		return nil
+	}
+	if ifc.MTU != m.want {
+		args := []string{"ifconfig", m.tunname, "mtu", strconv.Itoa(m.want)}
+		if out, err := m.cmd.output(args...); err != nil {
+			return fmt.Errorf("%v: %w\n%s", args, err, out)
+		}
+		m.logf("set %s mtu to %d", m.tunname, m.want)
+	}
+	m.index, m.applied = ifc.Index, m.want
+	return nil
+}
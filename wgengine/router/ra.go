@@ -0,0 +1,29 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package router
+
+// acceptRACmd returns the command on goos that makes the interface tunname
+// accept (or not) IPv6 router advertisements, and autoconfigure addresses
+// from them, or nil if goos has no per-interface knob for it.
+func acceptRACmd(goos, tunname string, accept bool) []string {
+	switch goos {
+	case "dragonfly", "freebsd", "netbsd":
+		flag := "-accept_rtadv"
+		if accept {
+			flag = "accept_rtadv"
+		}
+		// The flag is separated by "--", lest ndp(8) parse the
+		// leading "-" of a cleared flag as an option.
+		return []string{"ndp", "-i", tunname, "--", flag}
+	case "openbsd":
+		flag := "-autoconf"
+		if accept {
+			flag = "autoconf"
+		}
+		return []string{"ifconfig", tunname, "inet6", flag}
+	}
+	// macOS's utun interfaces don't autoconfigure addresses, and its
+	// ndp(8) can't change whether one accepts router advertisements.
+	return nil
+}
@@ -0,0 +1,25 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package router
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// setAcceptRA makes the interface tunname accept (or not) IPv6 router
+// advertisements, and autoconfigure addresses from them, where the system
+// allows changing it per interface.
+func setAcceptRA(cmd commandRunner, tunname string, accept bool) error {
+	args := acceptRACmd(runtime.GOOS, tunname, accept)
+	if args == nil {
+		return nil
+	}
+	if out, err := cmd.output(args...); err != nil {
+		return fmt.Errorf("%v: %w\n%s", args, err, out)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package router
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAcceptRACmd(t *testing.T) {
+	tests := []struct {
+		goos   string
+		accept bool
+		want   []string
+	}{
+		{"freebsd", false, []string{"ndp", "-i", "tailscale0", "--", "-accept_rtadv"}},
+		{"freebsd", true, []string{"ndp", "-i", "tailscale0", "--", "accept_rtadv"}},
+		{"netbsd", false, []string{"ndp", "-i", "tailscale0", "--", "-accept_rtadv"}},
+		{"dragonfly", false, []string{"ndp", "-i", "tailscale0", "--", "-accept_rtadv"}},
+		{"openbsd", false, []string{"ifconfig", "tailscale0", "inet6", "-autoconf"}},
+		{"openbsd", true, []string{"ifconfig", "tailscale0", "inet6", "autoconf"}},
+		{"darwin", false, nil},
+	}
+	for _, tt := range tests {
+		if got := acceptRACmd(tt.goos, "tailscale0", tt.accept); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("acceptRACmd(%q, %v) = %q; want %q", tt.goos, tt.accept, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package router
+
+import (
+	"sync"
+	"time"
+
+	"tailscale.com/types/logger"
+)
+
+const (
+	retryMinDelay = time.Second
+	retryMaxDelay = time.Minute
+)
+
+// retrier re-applies a router's configuration after it failed to apply,
+// such as when a route add raced with the interface coming up, with
+// exponential backoff until it succeeds or a newer configuration replaces
+// it.
+type retrier struct {
+	logf  logger.Logf
+	apply func() error // re-applies the latest configuration
+
+	minDelay, maxDelay time.Duration
+
+	mu     sync.Mutex
+	failed bool          // whether the latest attempt failed
+	delay  time.Duration // until the next retry
+	timer  *time.Timer   // or nil if no retry is scheduled
+	closed bool
+}
+
+func newRetrier(logf logger.Logf, apply func() error) *retrier {
+	return &retrier{
+		logf:     logf,
+		apply:    apply,
+		minDelay: retryMinDelay,
+		maxDelay: retryMaxDelay,
+	}
+}
+
+// done records the result of an attempt to apply the configuration,
+// scheduling a retry if it failed.
+func (rt *retrier) done(err error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.timer != nil {
+		rt.timer.Stop()
+		rt.timer = nil
+	}
+	if err == nil || rt.closed {
+		rt.failed = false
+		rt.delay = 0
+		return
+	}
+	if !rt.failed {
+		rt.delay = rt.minDelay
+	}
+	rt.failed = true
+	rt.logf("router config failed; retrying in %v", rt.delay)
+	rt.timer = time.AfterFunc(rt.delay, rt.retry)
+	rt.delay = min(2*rt.delay, rt.maxDelay)
+}
+
+// retry re-applies the configuration.
+func (rt *retrier) retry() {
+	rt.mu.Lock()
+	if rt.closed || !rt.failed {
+		rt.mu.Unlock()
+		return
+	}
+	rt.timer = nil
+	rt.mu.Unlock()
+	rt.done(rt.apply())
+}
+
+// poke retries right away if the latest attempt failed, as when the network
+// changed in a way that may have fixed what made it fail.
+func (rt *retrier) poke() {
+	rt.mu.Lock()
+	if rt.closed || !rt.failed {
+		rt.mu.Unlock()
+		return
+	}
+	if rt.timer != nil {
+		rt.timer.Stop()
+		rt.timer = nil
+	}
+	rt.delay = rt.minDelay
+	rt.mu.Unlock()
+	go rt.retry()
+}
+
+// close stops any further retries.
+func (rt *retrier) close() {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.closed = true
+	if rt.timer != nil {
+		rt.timer.Stop()
+		rt.timer = nil
+	}
+}
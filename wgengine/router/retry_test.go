@@ -0,0 +1,115 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package router
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestRetrier(t *testing.T, apply func() error) *retrier {
+	rt := newRetrier(t.Logf, apply)
+	rt.minDelay = time.Millisecond
+	rt.maxDelay = 4 * time.Millisecond
+	t.Cleanup(rt.close)
+	return rt
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRetrierRetriesUntilSuccess(t *testing.T) {
+	var calls atomic.Int32
+	rt := newTestRetrier(t, func() error {
+		if calls.Add(1) < 3 {
+			return errors.New("EEXIST")
+		}
+		return nil
+	})
+	rt.done(errors.New("interface not up"))
+	waitFor(t, "3 attempts", func() bool { return calls.Load() >= 3 })
+
+	// Having succeeded, it stops retrying.
+	time.Sleep(20 * time.Millisecond)
+	if got := calls.Load(); got != 3 {
+		t.Errorf("attempts = %d; want 3", got)
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.failed || rt.timer != nil {
+		t.Errorf("failed = %v, timer = %v after success; want no retry pending", rt.failed, rt.timer)
+	}
+}
+
+func TestRetrierBackoff(t *testing.T) {
+	rt := newRetrier(t.Logf, func() error { return errors.New("fail") })
+	defer rt.close()
+	nextDelay := func() time.Duration {
+		rt.mu.Lock()
+		defer rt.mu.Unlock()
+		return rt.delay
+	}
+	// Each failure schedules a retry and doubles the delay before the
+	// next one, up to retryMaxDelay.
+	want := []time.Duration{2, 4, 8, 16, 32, 60, 60}
+	for i, w := range want {
+		rt.done(errors.New("fail"))
+		if got := nextDelay(); got != w*time.Second {
+			t.Errorf("after failure %d, next delay = %v; want %v", i+1, got, w*time.Second)
+		}
+	}
+
+	// Success resets the backoff.
+	rt.done(nil)
+	rt.done(errors.New("fail"))
+	if got := nextDelay(); got != 2*time.Second {
+		t.Errorf("after success and failure, next delay = %v; want 2s", got)
+	}
+}
+
+func TestRetrierPoke(t *testing.T) {
+	var calls atomic.Int32
+	rt := newRetrier(t.Logf, func() error {
+		calls.Add(1)
+		return nil
+	})
+	defer rt.close()
+
+	// Nothing failed, so there's nothing to retry.
+	rt.poke()
+	time.Sleep(10 * time.Millisecond)
+	if got := calls.Load(); got != 0 {
+		t.Fatalf("attempts after poke with nothing failed = %d; want 0", got)
+	}
+
+	// The first retry would be a second away; poke retries right away.
+	rt.done(errors.New("fail"))
+	rt.poke()
+	waitFor(t, "retry after poke", func() bool { return calls.Load() == 1 })
+}
+
+func TestRetrierClose(t *testing.T) {
+	var calls atomic.Int32
+	rt := newTestRetrier(t, func() error {
+		calls.Add(1)
+		return errors.New("fail")
+	})
+	rt.done(errors.New("fail"))
+	rt.close()
+	rt.poke()
+	time.Sleep(20 * time.Millisecond)
+	if got := calls.Load(); got != 0 {
+		t.Errorf("attempts after close = %d; want 0", got)
+	}
+}
@@ -69,18 +69,41 @@ type Config struct {
 	// interface.  These are the /32 and /128 routes to peers, as
 	// well as any other subnets that peers are advertising and
 	// this node has chosen to use.
+	//
+	// 4via6 subnet routes (see tsaddr.MapVia) are IPv6 routes like any
+	// other: netstack translates their packets to and from IPv4 on every
+	// platform, so routers install them without special handling.
 	Routes []netip.Prefix
 
+	// RouteMetrics are the metrics of those Routes that aren't added with
+	// the platform's default, such as subnet routes configured to lose
+	// to the system's own routes to the same networks. Lower metrics are
+	// preferred. On OpenBSD, it's the route priority, at most 63; the
+	// other BSDs have no route metrics and ignore it. On Linux, it
+	// orders routes within a routing table, so routes in Tailscale's own
+	// table, which is looked up first, still win over the main table's.
+	RouteMetrics map[netip.Prefix]uint32
+
 	// LocalRoutes are the routes that should not be routed through Tailscale.
 	// There are no priorities set in how these routes are added, normal
 	// routing rules apply.
 	LocalRoutes []netip.Prefix
 
-	// NewMTU is currently only used by the MacOS network extension
-	// app to set the MTU of the tun in the router configuration
-	// callback. If zero, the MTU is unchanged.
+	// NewMTU is the MTU to set on the tun. The MacOS network extension
+	// app sets it in the router configuration callback. If zero, the MTU
+	// is unchanged, except by tailscaled's routers on macOS and the BSDs,
+	// which set tstun.DefaultTUNMTU (see tailscaled's --mtu), as some BSD
+	// tun drivers ignore the MTU the device was created with.
 	NewMTU int
 
+	// IgnoreRouterAdvertisements is whether the tun stops accepting IPv6
+	// router advertisements, and autoconfiguring addresses (SLAAC) from
+	// them, so that one arriving over Tailscale can't install a default
+	// route. It's implemented on Linux and the BSDs other than macOS,
+	// whose tun doesn't autoconfigure addresses. If false, the system's
+	// setting for the tun is left in effect.
+	IgnoreRouterAdvertisements bool
+
 	// SubnetRoutes is the list of subnets that this node is
 	// advertising to other Tailscale nodes.
 	// As of 2023-10-11, this field is only used for network
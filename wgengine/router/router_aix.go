@@ -0,0 +1,208 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package router
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/tailscale/wireguard-go/tun"
+	"tailscale.com/health"
+	"tailscale.com/net/netmon"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/set"
+	"tailscale.com/wgengine/router/bsdroute"
+)
+
+// aixRouter configures the Tailscale interface on AIX with chdev(1),
+// ifconfig(1) and route(1). wireguard-go has no AIX tun driver, so it's
+// only used with tun devices provided by other means; tailscaled defaults
+// to userspace networking on AIX.
+type aixRouter struct {
+	logf    logger.Logf
+	netMon  *netmon.Monitor
+	tunname string
+	local   set.Set[netip.Prefix]
+	routes  set.Set[netip.Prefix]
+	mtu     int
+
+	// gateway4 and gateway6 are the local addresses the current routes
+	// were added with as their nexthop.
+	gateway4 netip.Addr
+	gateway6 netip.Addr
+}
+
+func newUserspaceRouter(logf logger.Logf, tundev tun.Device, netMon *netmon.Monitor, health *health.Tracker) (Router, error) {
+	tunname, err := tundev.Name()
+	if err != nil {
+		return nil, err
+	}
+	return &aixRouter{
+		logf:    logf,
+		netMon:  netMon,
+		tunname: tunname,
+	}, nil
+}
+
+func cmd(args ...string) *exec.Cmd {
+	if len(args) == 0 {
+		log.Fatalf("exec.Cmd(%#v) invalid; need argv[0]", args)
+	}
+	return exec.Command(args[0], args[1:]...)
+}
+
+func (r *aixRouter) run(args ...string) error {
+	out, err := cmd(args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %w\n%s", args, err, out)
+	}
+	return nil
+}
+
+func (r *aixRouter) Up() error {
+	// chdev changes both the running interface and its ODM entry, so
+	// the interface stays up across reconfiguration by the system.
+	if err := r.run("chdev", "-l", r.tunname, "-a", "state=up"); err != nil {
+		r.logf("%v", err)
+		return err
+	}
+	return nil
+}
+
+// addrArgs returns the ifconfig arguments for address p.
+func addrArgs(p netip.Prefix) []string {
+	if p.Addr().Is6() {
+		return []string{"inet6", p.String()}
+	}
+	mask := net.IP(net.CIDRMask(p.Bits(), 32)).String()
+	return []string{"inet", p.Addr().String(), "netmask", mask}
+}
+
+func (r *aixRouter) Set(cfg *Config) error {
+	if cfg == nil {
+		cfg = &shutdownConfig
+	}
+	var errq error
+	setErr := func(err error) {
+		r.logf("%v", err)
+		if errq == nil {
+			errq = err
+		}
+	}
+
+	if cfg.NewMTU != 0 && cfg.NewMTU != r.mtu {
+		if err := r.run("chdev", "-l", r.tunname, "-a", "mtu="+strconv.Itoa(cfg.NewMTU)); err != nil {
+			setErr(err)
+		} else {
+			r.mtu = cfg.NewMTU
+		}
+	}
+
+	newLocal := set.SetOf(cfg.LocalAddrs)
+	for addr := range r.local {
+		if !newLocal.Contains(addr) {
+			args := append([]string{"ifconfig", r.tunname}, addrArgs(addr)...)
+			if err := r.run(append(args, "delete")...); err != nil {
+				setErr(err)
+			}
+		}
+	}
+	var gw4, gw6 netip.Addr
+	for _, addr := range cfg.LocalAddrs {
+		if addr.Addr().Is4() && !gw4.IsValid() {
+			gw4 = addr.Addr()
+		} else if addr.Addr().Is6() && !gw6.IsValid() {
+			gw6 = addr.Addr()
+		}
+		if r.local.Contains(addr) {
+			continue
+		}
+		args := append([]string{"ifconfig", r.tunname}, addrArgs(addr)...)
+		if err := r.run(append(args, "alias")...); err != nil {
+			setErr(err)
+		}
+	}
+	r.local = newLocal
+
+	// Like illumos, AIX wants a nexthop for interface routes; use a local
+	// address of the interface, and skip routes of a family without one.
+	newRoutes := set.Set[netip.Prefix]{}
+	for _, route := range cfg.Routes {
+		if (route.Addr().Is4() && gw4.IsValid()) || (route.Addr().Is6() && gw6.IsValid()) {
+			newRoutes.Add(route)
+		}
+	}
+	var ops []bsdroute.Op
+	for route := range r.routes {
+		if !newRoutes.Contains(route) {
+			ops = append(ops, bsdroute.Op{Del: true, Entry: bsdroute.Entry{Dst: route}})
+		}
+	}
+	for route := range newRoutes {
+		if !r.routes.Contains(route) {
+			ops = append(ops, bsdroute.Op{Entry: bsdroute.Entry{Dst: route}})
+		}
+	}
+	apply := func(op bsdroute.Op) error {
+		verb, gw := "add", gw4
+		if op.Del {
+			verb, gw = "delete", r.gateway4
+		}
+		pfx := op.Dst.Masked()
+		var args []string
+		if pfx.Addr().Is4() {
+			mask := net.IP(net.CIDRMask(pfx.Bits(), 32)).String()
+			args = []string{"route", verb, "-net", pfx.Addr().String(), "-netmask", mask}
+		} else {
+			gw = gw6
+			if op.Del {
+				gw = r.gateway6
+			}
+			args = []string{"route", verb, "-inet6", "-net", pfx.Addr().String(), "-prefixlen", strconv.Itoa(pfx.Bits())}
+		}
+		args = append(args, gw.String(), "-interface")
+		out, err := cmd(args...).CombinedOutput()
+		if err != nil {
+			if op.Del && strings.Contains(string(out), "not in table") ||
+				!op.Del && strings.Contains(string(out), "File exists") {
+				return bsdroute.ErrUnchanged
+			}
+			return fmt.Errorf("%v: %w\n%s", args, err, out)
+		}
+		return nil
+	}
+	if err := bsdroute.ApplyOps(r.logf, ops, apply); err != nil {
+		// The route changes were rolled back; keep the old set so
+		// that the next Set retries them.
+		setErr(err)
+	} else {
+		r.routes = newRoutes
+		r.gateway4, r.gateway6 = gw4, gw6
+	}
+	return errq
+}
+
+// UpdateMagicsockPort implements the Router interface. This implementation
+// does nothing and returns nil because this router does not currently need
+// to know what the magicsock UDP port is.
+func (r *aixRouter) UpdateMagicsockPort(_ uint16, _ string) error {
+	return nil
+}
+
+func (r *aixRouter) Close() error {
+	err := r.Set(nil)
+	cleanUp(r.logf, r.tunname)
+	return err
+}
+
+func cleanUp(logf logger.Logf, interfaceName string) {
+	if out, err := cmd("chdev", "-l", interfaceName, "-a", "state=down").CombinedOutput(); err != nil {
+		logf("chdev state=down: %v\n%s", err, out)
+	}
+}
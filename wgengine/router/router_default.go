@@ -1,7 +1,7 @@
 // Copyright (c) Tailscale Inc & AUTHORS
 // SPDX-License-Identifier: BSD-3-Clause
 
-//go:build !windows && !linux && !darwin && !openbsd && !freebsd && !illumos && !solaris && !netbsd
+//go:build !windows && !linux && !darwin && !openbsd && !freebsd && !illumos && !solaris && !netbsd && !aix && !dragonfly
 
 package router
 
@@ -0,0 +1,197 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package router
+
+import (
+	"net/netip"
+	"strconv"
+
+	"github.com/tailscale/wireguard-go/tun"
+	"tailscale.com/health"
+	"tailscale.com/net/netmon"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/set"
+	"tailscale.com/wgengine/router/bsdroute"
+)
+
+// For now this router only supports the WireGuard userspace implementation.
+
+type dragonflyRouter struct {
+	logf    logger.Logf
+	netMon  *netmon.Monitor
+	tunname string
+	cmd     commandRunner
+	local   set.Set[netip.Prefix]
+	routes  *bsdroute.Table
+	mtu     int
+	fw      *bsdFirewall
+
+	ignoreRA bool // whether router advertisements are turned off on the interface
+}
+
+func newUserspaceRouter(logf logger.Logf, tundev tun.Device, netMon *netmon.Monitor, health *health.Tracker) (Router, error) {
+	tunname, err := tundev.Name()
+	if err != nil {
+		return nil, err
+	}
+	mtu, err := tundev.MTU()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := osCommandRunner{}
+	routes := bsdroute.NewTable(logf, tunname)
+	routes.SetRun(cmd.output)
+	adoptStaleRoutes(logf, routes)
+	return &dragonflyRouter{
+		logf:    logf,
+		netMon:  netMon,
+		tunname: tunname,
+		cmd:     cmd,
+		mtu:     mtu,
+		routes:  routes,
+		fw:      newBSDFirewall(logf, tunname),
+	}, nil
+}
+
+func (r *dragonflyRouter) Up() error {
+	ifup := []string{"ifconfig", r.tunname, "up"}
+	if out, err := r.cmd.output(ifup...); err != nil {
+		r.logf("running ifconfig failed: %v\n%s", err, out)
+		return err
+	}
+	return nil
+}
+
+// addrCmd returns the ifconfig command adding (or with del, removing) the
+// local address addr.
+func addrCmd(tunname string, addr netip.Prefix, del bool) []string {
+	if addr.Addr().Is6() {
+		// As on FreeBSD, add our whole ULA /48 rather than a /128,
+		// which the tun driver rejects. That also creates the route
+		// to the ULA range, so it's never added as a route.
+		addr = netip.PrefixFrom(addr.Addr(), 48)
+		if del {
+			return []string{"ifconfig", tunname, "inet6", addr.String(), "-alias"}
+		}
+		return []string{"ifconfig", tunname, "inet6", addr.String(), "alias"}
+	}
+	if del {
+		return []string{"ifconfig", tunname, "inet", addr.String(), "-alias"}
+	}
+	return []string{"ifconfig", tunname, "inet", addr.String(), addr.Addr().String(), "alias"}
+}
+
+// ifaceRouteCmd returns the route(8) command applying op to a route into
+// the interface tunname.
+func ifaceRouteCmd(tunname string, op bsdroute.Op) []string {
+	return append([]string{"route"}, bsdroute.Args(op, tunname)...)
+}
+
+func (r *dragonflyRouter) Set(cfg *Config) error {
+	if cfg == nil {
+		cfg = &shutdownConfig
+	}
+
+	var errq error
+	setErr := func(err error) {
+		if errq == nil {
+			errq = err
+		}
+	}
+
+	if cfg.NewMTU != 0 && cfg.NewMTU != r.mtu {
+		args := []string{"ifconfig", r.tunname, "mtu", strconv.Itoa(cfg.NewMTU)}
+		if out, err := r.cmd.output(args...); err != nil {
+			r.logf("mtu change failed: %v: %v\n%s", args, err, out)
+			setErr(err)
+		} else {
+			r.mtu = cfg.NewMTU
+		}
+	}
+
+	if cfg.IgnoreRouterAdvertisements != r.ignoreRA {
+		if err := setAcceptRA(r.cmd, r.tunname, !cfg.IgnoreRouterAdvertisements); err != nil {
+			r.logf("router advertisements change failed: %v", err)
+			setErr(err)
+		} else {
+			r.ignoreRA = cfg.IgnoreRouterAdvertisements
+		}
+	}
+
+	newLocal := set.SetOf(cfg.LocalAddrs)
+	for _, addr := range sortedPrefixes(r.local) {
+		if newLocal.Contains(addr) {
+			continue
+		}
+		args := addrCmd(r.tunname, addr, true)
+		if out, err := r.cmd.output(args...); err != nil {
+			r.logf("addr del failed: %v: %v\n%s", args, err, out)
+			setErr(err)
+		}
+	}
+	for _, addr := range sortedPrefixes(newLocal) {
+		if r.local.Contains(addr) {
+			continue
+		}
+		args := addrCmd(r.tunname, addr, false)
+		if out, err := r.cmd.output(args...); err != nil {
+			r.logf("addr add failed: %v: %v\n%s", args, err, out)
+			setErr(err)
+			newLocal.Delete(addr)
+		}
+	}
+	r.local = newLocal
+
+	// Default routes (from an exit node) are split in two, so that they
+	// take precedence over the system's default route without replacing
+	// it, and the system's route is back in effect once they're removed.
+	//
+	// TODO: keep magicsock's own traffic out of the split routes, as
+	// net/netns does by binding to the default interface on macOS.
+	newRoutes := set.Set[bsdroute.Entry]{}
+	for _, route := range splitDefaultRoutes(cfg.Routes) {
+		if route == tsaddr.TailscaleULARange() {
+			// Added by the kernel along with our IPv6 address.
+			continue
+		}
+		newRoutes.Add(bsdroute.Entry{Dst: route})
+	}
+	routeCmd := func(op bsdroute.Op) []string { return ifaceRouteCmd(r.tunname, op) }
+	if err := r.routes.Set(newRoutes, nil, routeCmd); err != nil {
+		// The route changes were rolled back, so the next Set
+		// retries them.
+		r.logf("route update failed: %v", err)
+		setErr(err)
+	}
+
+	if err := r.fw.set(cfg); err != nil {
+		r.logf("packet filter update failed: %v", err)
+		setErr(err)
+	}
+
+	publishState(r.tunname, r.local.Slice(), r.routes, errq)
+	return errq
+}
+
+// UpdateMagicsockPort implements the Router interface. It lets the new port
+// through the system packet filter, if Tailscale manages one.
+func (r *dragonflyRouter) UpdateMagicsockPort(port uint16, network string) error {
+	return r.fw.updateMagicsockPort(port, network)
+}
+
+func (r *dragonflyRouter) Close() error {
+	cleanUp(r.logf, r.tunname)
+	return r.fw.close()
+}
+
+func cleanUp(logf logger.Logf, interfaceName string) {
+	// As on FreeBSD, a tun interface left behind would stop the next
+	// tailscaled from creating it.
+	if out, err := (osCommandRunner{}).output("ifconfig", interfaceName, "destroy"); err != nil {
+		logf("ifconfig destroy: %v\n%s", err, out)
+	}
+	cleanUpFirewall(logf)
+}
@@ -0,0 +1,46 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package router
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"tailscale.com/wgengine/router/bsdroute"
+)
+
+func TestDragonflyCommands(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	tests := []struct {
+		got  []string
+		want []string
+	}{
+		{
+			addrCmd("tun0", pfx("100.101.102.103/32"), false),
+			[]string{"ifconfig", "tun0", "inet", "100.101.102.103/32", "100.101.102.103", "alias"},
+		},
+		{
+			addrCmd("tun0", pfx("100.101.102.103/32"), true),
+			[]string{"ifconfig", "tun0", "inet", "100.101.102.103/32", "-alias"},
+		},
+		{
+			addrCmd("tun0", pfx("fd7a:115c:a1e0::1/128"), false),
+			[]string{"ifconfig", "tun0", "inet6", "fd7a:115c:a1e0::1/48", "alias"},
+		},
+		{
+			ifaceRouteCmd("tun0", bsdroute.Op{Entry: bsdroute.Entry{Dst: pfx("128.0.0.0/1")}}),
+			[]string{"route", "-q", "-n", "add", "-inet", "128.0.0.0/1", "-iface", "tun0"},
+		},
+		{
+			ifaceRouteCmd("tun0", bsdroute.Op{Del: true, Entry: bsdroute.Entry{Dst: pfx("10.1.2.3/16")}}),
+			[]string{"route", "-q", "-n", "delete", "-inet", "10.1.0.0/16", "-iface", "tun0"},
+		},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("got %q, want %q", tt.got, tt.want)
+		}
+	}
+}
@@ -4,8 +4,11 @@
 package router
 
 import (
+	"errors"
+
 	"github.com/tailscale/wireguard-go/tun"
 	"tailscale.com/health"
+	"tailscale.com/hostinfo"
 	"tailscale.com/net/netmon"
 	"tailscale.com/types/logger"
 )
@@ -16,6 +19,14 @@
 // https://svnweb.freebsd.org/base?view=revision&revision=357986
 
 func newUserspaceRouter(logf logger.Logf, tundev tun.Device, netMon *netmon.Monitor, health *health.Tracker) (Router, error) {
+	if j := hostinfo.GetJail(); j.Jailed {
+		if !j.VNET {
+			// Without VNET, route(8) would act on the host's routing
+			// table, which the jail isn't allowed to change.
+			return nil, errors.New("running in a jail without VNET; use --tun=userspace-networking or give the jail VNET")
+		}
+		logf("router: running in a VNET jail")
+	}
 	return newUserspaceBSDRouter(logf, tundev, netMon, health)
 }
 
@@ -25,7 +36,8 @@ func cleanUp(logf logger.Logf, interfaceName string) {
 	// will result in "interface tailscale0 already exists"
 	// until the defunct interface is ifconfig-destroyed.
 	ifup := []string{"ifconfig", interfaceName, "destroy"}
-	if out, err := cmd(ifup...).CombinedOutput(); err != nil {
+	if out, err := (osCommandRunner{}).output(ifup...); err != nil {
 		logf("ifconfig destroy: %v\n%s", err, out)
 	}
+	cleanUpFirewall(logf)
 }
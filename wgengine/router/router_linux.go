@@ -49,9 +49,11 @@ type linuxRouter struct {
 	unregNetMon       func()
 	addrs             map[netip.Prefix]bool
 	routes            map[netip.Prefix]bool
+	routeMetrics      map[netip.Prefix]uint32 // metrics routes are added with
 	localRoutes       map[netip.Prefix]bool
 	snatSubnetRoutes  bool
 	statefulFiltering bool
+	ignoreRAs         bool
 	netfilterMode     preftype.NetfilterMode
 	netfilterKind     string
 
@@ -346,6 +348,7 @@ func (r *linuxRouter) Close() error {
 
 	r.addrs = nil
 	r.routes = nil
+	r.routeMetrics = nil
 	r.localRoutes = nil
 
 	return nil
@@ -395,6 +398,21 @@ func (r *linuxRouter) Set(cfg *Config) error {
 	}
 	r.localRoutes = newLocalRoutes
 
+	// Routes whose metric changed are deleted, for cidrDiff to add them
+	// back with the new one. That leaves the remaining routes with the
+	// same metric in both, for cidrDiff to delete them by.
+	for cidr := range r.routes {
+		if r.routeMetrics[cidr] == cfg.RouteMetrics[cidr] {
+			continue
+		}
+		if err := r.delRoute(cidr); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delete(r.routes, cidr)
+	}
+	r.routeMetrics = cfg.RouteMetrics
+
 	newRoutes, err := cidrDiff("route", r.routes, cfg.Routes, r.addRoute, r.delRoute, r.logf)
 	if err != nil {
 		errs = append(errs, err)
@@ -407,6 +425,14 @@ func (r *linuxRouter) Set(cfg *Config) error {
 	}
 	r.addrs = newAddrs
 
+	if cfg.IgnoreRouterAdvertisements != r.ignoreRAs {
+		if err := r.setAcceptRA(!cfg.IgnoreRouterAdvertisements); err != nil {
+			errs = append(errs, err)
+		} else {
+			r.ignoreRAs = cfg.IgnoreRouterAdvertisements
+		}
+	}
+
 	// Ensure that the SNAT rule is added or removed as needed.
 	switch {
 	case cfg.SNATSubnetRoutes == r.snatSubnetRoutes:
@@ -801,7 +827,7 @@ func (r *linuxRouter) addRoute(cidr netip.Prefix) error {
 		return nil
 	}
 	if r.useIPCommand() {
-		return r.addRouteDef([]string{normalizeCIDR(cidr), "dev", r.tunname}, cidr)
+		return r.addRouteDef(r.routeDef(cidr), cidr)
 	}
 	linkIndex, err := r.linkIndex()
 	if err != nil {
@@ -811,9 +837,20 @@ func (r *linuxRouter) addRoute(cidr netip.Prefix) error {
 		LinkIndex: linkIndex,
 		Dst:       netipx.PrefixIPNet(cidr.Masked()),
 		Table:     r.routeTable(),
+		Priority:  int(r.routeMetrics[cidr]),
 	})
 }
 
+// routeDef returns the ip(8) route definition of the route for cidr
+// pointing to the tunnel interface.
+func (r *linuxRouter) routeDef(cidr netip.Prefix) []string {
+	def := []string{normalizeCIDR(cidr), "dev", r.tunname}
+	if m := r.routeMetrics[cidr]; m != 0 {
+		def = append(def, "metric", strconv.FormatUint(uint64(m), 10))
+	}
+	return def
+}
+
 // addThrowRoute adds a throw route for the provided cidr.
 // This has the effect that lookup in the routing table is terminated
 // pretending that no route was found. Fails if the route already exists,
@@ -878,7 +915,7 @@ func (r *linuxRouter) delRoute(cidr netip.Prefix) error {
 		return nil
 	}
 	if r.useIPCommand() {
-		return r.delRouteDef([]string{normalizeCIDR(cidr), "dev", r.tunname}, cidr)
+		return r.delRouteDef(r.routeDef(cidr), cidr)
 	}
 	linkIndex, err := r.linkIndex()
 	if err != nil {
@@ -888,6 +925,7 @@ func (r *linuxRouter) delRoute(cidr netip.Prefix) error {
 		LinkIndex: linkIndex,
 		Dst:       netipx.PrefixIPNet(cidr.Masked()),
 		Table:     r.routeTable(),
+		Priority:  int(r.routeMetrics[cidr]),
 	})
 	if errors.Is(err, errESRCH) {
 		// Didn't exist to begin with.
@@ -1022,6 +1060,32 @@ func writeSysctl(key, val string) error {
 	return nil
 }
 
+// setAcceptRA sets whether the kernel accepts IPv6 router advertisements on
+// the tunnel interface, and autoconfigures addresses from them.
+func (r *linuxRouter) setAcceptRA(accept bool) error {
+	if !r.v6Available {
+		return nil
+	}
+	val := "0"
+	if accept {
+		val = "1"
+	}
+	for _, key := range []string{"accept_ra", "autoconf"} {
+		// The interface name may contain dots, so the path is built
+		// here rather than by writeSysctl.
+		fn := "/proc/sys/net/ipv6/conf/" + r.tunname + "/" + key
+		if err := os.WriteFile(fn, []byte(val), 0644); err != nil {
+			if os.IsNotExist(err) {
+				// IPv6 is disabled on the interface.
+				return nil
+			}
+			return fmt.Errorf("setting %s of %s to %s: %w", key, r.tunname, val, err)
+		}
+	}
+	r.logf("%s accept_ra=%s autoconf=%s", r.tunname, val, val)
+	return nil
+}
+
 // downInterface sets the tunnel interface administratively down.
 func (r *linuxRouter) downInterface() error {
 	if r.useIPCommand() {
@@ -78,6 +78,21 @@ func TestRouterStates(t *testing.T) {
 ip route add 192.168.16.0/24 dev tailscale0 table 52` + basic,
 		},
 
+		{
+			name: "addr and routes with a route metric",
+			in: &Config{
+				LocalAddrs:    mustCIDRs("100.101.102.103/10"),
+				Routes:        mustCIDRs("100.100.100.100/32", "192.168.16.0/24"),
+				RouteMetrics:  map[netip.Prefix]uint32{netip.MustParsePrefix("192.168.16.0/24"): 100},
+				NetfilterMode: netfilterOff,
+			},
+			want: `
+up
+ip addr add 100.101.102.103/10 dev tailscale0
+ip route add 100.100.100.100/32 dev tailscale0 table 52
+ip route add 192.168.16.0/24 dev tailscale0 metric 100 table 52` + basic,
+		},
+
 		{
 			name: "addr and routes and subnet routes",
 			in: &Config{
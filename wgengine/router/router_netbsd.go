@@ -6,16 +6,16 @@
 import (
 	"errors"
 	"fmt"
-	"log"
 	"net/netip"
-	"os/exec"
+	"sync"
+	"time"
 
 	"github.com/tailscale/wireguard-go/tun"
-	"go4.org/netipx"
 	"tailscale.com/health"
 	"tailscale.com/net/netmon"
 	"tailscale.com/types/logger"
 	"tailscale.com/util/set"
+	"tailscale.com/wgengine/router/bsdroute"
 )
 
 // For now this router only supports the WireGuard userspace implementation.
@@ -25,10 +25,29 @@
 type netbsdRouter struct {
 	logf    logger.Logf
 	netMon  *netmon.Monitor
+	health  *health.Tracker
 	tunname string
-	local4  netip.Prefix
-	local6  netip.Prefix
-	routes  set.Set[netip.Prefix]
+	cmd     commandRunner
+	ioctls  bool // whether to set addresses with ioctls, rather than ifconfig(8)
+	local   set.Set[netip.Prefix]
+	routes  *bsdroute.Table
+	fw      *bsdFirewall
+	mtu     *tunMTU
+	dryRun  bool // only log the changes Set would make; see TS_DEBUG_ROUTER_DRY_RUN
+
+	ignoreRA bool // whether router advertisements are turned off on the interface
+
+	retry       *retrier // re-applies cfg after it failed to apply
+	unregNetMon func()   // or nil
+
+	mu     sync.Mutex // guards the fields below, and serializes configuration
+	cfg    *Config    // the latest configuration to apply, or nil before Set
+	closed bool
+
+	// routeAddr4 and routeAddr6 are the local addresses that the routes
+	// into the interface point into it with.
+	routeAddr4 netip.Addr
+	routeAddr6 netip.Addr
 }
 
 func newUserspaceRouter(logf logger.Logf, tundev tun.Device, netMon *netmon.Monitor, health *health.Tracker) (Router, error) {
@@ -36,36 +55,124 @@ func newUserspaceRouter(logf logger.Logf, tundev tun.Device, netMon *netmon.Moni
 	if err != nil {
 		return nil, err
 	}
+	return newUserspaceRouterAdvanced(logf, tunname, netMon, osCommandRunner{}, health)
+}
+
+func newUserspaceRouterAdvanced(logf logger.Logf, tunname string, netMon *netmon.Monitor, cmd commandRunner, health *health.Tracker) (*netbsdRouter, error) {
+	// A fake commandRunner in tests sees ifconfig(8) and route(8)
+	// commands, rather than ioctls and routing socket messages, and
+	// the system packet filter is left alone.
+	_, useOS := cmd.(osCommandRunner)
+	routes := bsdroute.NewTable(logf, tunname)
+	routes.SetRun(cmd.output)
+	routes.SetUseCmd(!useOS)
+	adoptStaleRoutes(logf, routes)
+	fw := &bsdFirewall{logf: logf, tunname: tunname}
+	if useOS {
+		fw = newBSDFirewall(logf, tunname)
+	}
 
-	return &netbsdRouter{
+	r := &netbsdRouter{
 		logf:    logf,
 		netMon:  netMon,
+		health:  health,
 		tunname: tunname,
-	}, nil
-}
-
-func cmd(args ...string) *exec.Cmd {
-	if len(args) == 0 {
-		log.Fatalf("exec.Cmd(%#v) invalid; need argv[0]", args)
+		cmd:     cmd,
+		ioctls:  useOS,
+		routes:  routes,
+		fw:      fw,
+		mtu:     newTunMTU(logf, cmd, tunname),
+		dryRun:  dryRun(),
+	}
+	r.retry = newRetrier(logf, r.reapply)
+	if netMon != nil {
+		// A change in the network, such as an interface coming up, may
+		// fix what made the configuration fail, so retry it right away.
+		// If the interface was recreated, it needs its MTU set again.
+		r.unregNetMon = netMon.RegisterChangeCallback(func(*netmon.ChangeDelta) {
+			if !r.dryRun {
+				if err := r.mtu.check(); err != nil {
+					r.logf("mtu change failed: %v", err)
+				}
+			}
+			r.retry.poke()
+		})
 	}
-	return exec.Command(args[0], args[1:]...)
+	return r, nil
 }
 
+// routerConfigWarnable is unhealthy while the router fails to configure the
+// interface's addresses, routes or packet filter rules. Failed changes are
+// retried with backoff and on network changes, so it takes a while to become
+// visible, to leave out failures that a retry fixes.
+var routerConfigWarnable = health.Register(&health.Warnable{
+	Code:     "router-config-failed",
+	Title:    "Network configuration failed",
+	Severity: health.SeverityMedium,
+	Text: func(args health.Args) string {
+		return fmt.Sprintf("Tailscale failed to configure its network interface, routes or packet filter. Some peers or routes may be unreachable. Error: %s", args[health.ArgError])
+	},
+	ImpactsConnectivity: true,
+	TimeToVisible:       10 * time.Second,
+})
+
 func (r *netbsdRouter) Up() error {
+	if r.ioctls {
+		if ifs, err := newIfaddrSocket(); err == nil {
+			err = ifs.up(r.tunname)
+			ifs.Close()
+			if err == nil {
+				return nil
+			}
+			r.logf("bringing up %s failed, using ifconfig(8): %v", r.tunname, err)
+		}
+	}
 	ifup := []string{"ifconfig", r.tunname, "up"}
 	r.logf("Up: %s", ifup)
-	if out, err := cmd(ifup...).CombinedOutput(); err != nil {
+	if out, err := r.cmd.output(ifup...); err != nil {
 		r.logf("running ifconfig failed: %v\n%s", err, out)
 		return err
 	}
 	return nil
 }
 
-func inet(p netip.Prefix) string {
-	if p.Addr().Is6() {
-		return "inet6"
+// addrCmd returns the ifconfig(8) command adding (or with del, removing) the
+// local address addr, for when the address ioctls are unavailable.
+func addrCmd(tunname string, addr netip.Prefix, del bool) []string {
+	if addr.Addr().Is6() {
+		// in https://github.com/tailscale/tailscale/issues/1307 we made
+		// FreeBSD use a /48 for IPv6 addresses, which is nice because we
+		// don't need to additionally add routing entries. Do that here too.
+		addr = netip.PrefixFrom(addr.Addr(), 48)
+		if del {
+			return []string{"ifconfig", tunname, "inet6", addr.String(), "delete"}
+		}
+		return []string{"ifconfig", tunname, "inet6", addr.String(), "alias"}
+	}
+	if del {
+		return []string{"ifconfig", tunname, "inet", addr.String(), "-alias"}
+	}
+	return []string{"ifconfig", tunname, "inet", addr.String(), "alias"}
+}
+
+// routeAddr returns the local address of the IPv4 (or with is6, IPv6)
+// family among addrs that route(8) points routes into the Tailscale
+// interface with: cur, if it's still among them, so that existing routes
+// stay valid, or else the first one.
+func routeAddr(addrs []netip.Prefix, is6 bool, cur netip.Addr) netip.Addr {
+	var first netip.Addr
+	for _, addr := range addrs {
+		if addr.Addr().Is6() != is6 {
+			continue
+		}
+		if addr.Addr() == cur {
+			return cur
+		}
+		if !first.IsValid() {
+			first = addr.Addr()
+		}
 	}
-	return "inet"
+	return first
 }
 
 func (r *netbsdRouter) Set(cfg *Config) error {
@@ -73,184 +180,267 @@ func (r *netbsdRouter) Set(cfg *Config) error {
 		cfg = &shutdownConfig
 	}
 
-	// TODO: support configuring multiple local addrs on interface.
 	r.logf("cfg=%s", cfg)
-	r.logf("cfg.LocalAddrs=%s", cfg.LocalAddrs)
-	if len(cfg.LocalAddrs) == 0 {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cfg = cfg
+	err := r.setLocked(cfg)
+	publishState(r.tunname, r.local.Slice(), r.routes, err)
+	r.retry.done(err)
+	return err
+}
+
+// reapply applies the latest configuration again, after it failed to apply.
+func (r *netbsdRouter) reapply() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cfg == nil || r.closed {
 		return nil
 	}
-	numIPv4 := 0
-	numIPv6 := 0
-	localAddr4 := netip.Prefix{}
-	localAddr6 := netip.Prefix{}
-	for _, addr := range cfg.LocalAddrs {
-		if addr.Addr().Is4() {
-			numIPv4++
-			localAddr4 = addr
+	r.logf("retrying router config")
+	err := r.setLocked(r.cfg)
+	publishState(r.tunname, r.local.Slice(), r.routes, err)
+	return err
+}
+
+// setLocked changes the interface's MTU, addresses, routes and packet
+// filter rules to those of cfg. Changes that fail are left for the next call to
+// retry. r.mu must be held.
+func (r *netbsdRouter) setLocked(cfg *Config) error {
+	var errq error
+	setErr := func(err error) {
+		if errq == nil {
+			errq = err
 		}
-		if addr.Addr().Is6() {
-			numIPv6++
-			localAddr6 = addr
+	}
+
+	// Addresses are set with ioctls, falling back to ifconfig(8) where
+	// the kernel lacks them.
+	var ifs *ifaddrSocket
+	if r.ioctls {
+		var err error
+		ifs, err = newIfaddrSocket()
+		if err != nil {
+			r.logf("address ioctls unavailable, using ifconfig(8): %v", err)
+		} else {
+			defer ifs.Close()
 		}
 	}
-	if numIPv4 > 1 || numIPv6 > 1 {
-		return errors.New("netbsd doesn't support setting multiple local addrs yet")
+	var changes []string // in dry-run mode, the changes not made
+	if r.dryRun {
+		if mtu, ok := r.mtu.pending(cfg.NewMTU); ok {
+			changes = append(changes, fmt.Sprintf("mtu %d", mtu))
+		}
+	} else if err := r.mtu.set(cfg.NewMTU); err != nil {
+		r.logf("mtu change failed: %v", err)
+		setErr(err)
 	}
-
-	var errq error
-
-	r.logf("localAddr4=%s, r.local4=%s", localAddr4, r.local4)
-	if localAddr4 != r.local4 {
-		if r.local4.IsValid() {
-			addrdel := []string{"ifconfig", r.tunname,
-				"inet", r.local4.String(), "-alias"}
-			out, err := cmd(addrdel...).CombinedOutput()
-			if err != nil {
-				r.logf("addr del failed: %v: %v\n%s", addrdel, err, out)
-				if errq == nil {
-					errq = err
-				}
-			}
-
-			routedel := []string{"route", "-q", "-n",
-				"delete", "-inet", r.local4.String(),
-				"-iface", r.local4.Addr().String()}
-			if out, err := cmd(routedel...).CombinedOutput(); err != nil {
-				r.logf("route del failed: %v: %v\n%s", routedel, err, out)
-				if errq == nil {
-					errq = err
-				}
-			}
+	if cfg.IgnoreRouterAdvertisements != r.ignoreRA {
+		accept := !cfg.IgnoreRouterAdvertisements
+		if r.dryRun {
+			changes = append(changes, fmt.Sprintf("accept_rtadv %v", accept))
+		} else if err := setAcceptRA(r.cmd, r.tunname, accept); err != nil {
+			r.logf("router advertisements change failed: %v", err)
+			setErr(err)
+		} else {
+			r.ignoreRA = cfg.IgnoreRouterAdvertisements
 		}
-
-		if localAddr4.IsValid() {
-			addradd := []string{"ifconfig", r.tunname,
-				"inet", localAddr4.String(), "alias"}
-			out, err := cmd(addradd...).CombinedOutput()
-			if err != nil {
-				r.logf("addr add failed: %v: %v\n%s", addradd, err, out)
-				if errq == nil {
-					errq = err
-				}
+	}
+	setAddr := func(addr netip.Prefix, del bool) error {
+		if r.dryRun {
+			verb := "add"
+			if del {
+				verb = "del"
 			}
-
-			routeadd := []string{"route", "-q", "-n",
-				"add", "-inet", localAddr4.String(),
-				"-iface", localAddr4.Addr().String()}
-			if out, err := cmd(routeadd...).CombinedOutput(); err != nil {
-				r.logf("route add failed: %v: %v\n%s", routeadd, err, out)
-				if errq == nil {
-					errq = err
-				}
+			changes = append(changes, fmt.Sprintf("addr %s %v", verb, addr))
+			return nil
+		}
+		if ifs != nil {
+			err := ifs.setAddr(r.tunname, addr, del)
+			if !errors.Is(err, errors.ErrUnsupported) {
+				return err
 			}
 		}
+		args := addrCmd(r.tunname, addr, del)
+		if out, err := r.cmd.output(args...); err != nil {
+			return fmt.Errorf("%v: %w\n%s", args, err, out)
+		}
+		return nil
 	}
 
-	if localAddr6.IsValid() {
-		// in https://github.com/tailscale/tailscale/issues/1307 we made
-		// FreeBSD use a /48 for IPv6 addresses, which is nice because we
-		// don't need to additionally add routing entries. Do that here too.
-		localAddr6 = netip.PrefixFrom(localAddr6.Addr(), 48)
-	}
-
-	if localAddr6 != r.local6 {
-		if r.local6.IsValid() {
-			addrdel := []string{"ifconfig", r.tunname,
-				"inet6", r.local6.String(), "delete"}
-			out, err := cmd(addrdel...).CombinedOutput()
-			if err != nil {
-				r.logf("addr del failed: %v: %v\n%s", addrdel, err, out)
-				if errq == nil {
-					errq = err
-				}
-			}
+	newLocal := set.SetOf(cfg.LocalAddrs)
+	for _, addr := range sortedPrefixes(r.local) {
+		if newLocal.Contains(addr) {
+			continue
 		}
+		if err := setAddr(addr, true); err != nil {
+			r.logf("addr del %v failed: %v", addr, err)
+			setErr(err)
+		}
+	}
+	for _, addr := range sortedPrefixes(newLocal) {
+		if r.local.Contains(addr) {
+			continue
+		}
+		if err := setAddr(addr, false); err != nil {
+			r.logf("addr add %v failed: %v", addr, err)
+			setErr(err)
+			newLocal.Delete(addr)
+		}
+	}
+	if !r.dryRun {
+		r.local = newLocal
+	}
 
-		if localAddr6.IsValid() {
-			addradd := []string{"ifconfig", r.tunname,
-				"inet6", localAddr6.String()}
-			out, err := cmd(addradd...).CombinedOutput()
-			if err != nil {
-				r.logf("addr add failed: %v: %v\n%s", addradd, err, out)
-				if errq == nil {
-					errq = err
-				}
-			}
+	// Routes point into the interface by one of its addresses. If that
+	// address went away, so did the routes, so they're all added again
+	// with another.
+	var addrs []netip.Prefix
+	for _, addr := range cfg.LocalAddrs {
+		if newLocal.Contains(addr) {
+			addrs = append(addrs, addr)
 		}
 	}
+	routeAddr4 := routeAddr(addrs, false, r.routeAddr4)
+	routeAddr6 := routeAddr(addrs, true, r.routeAddr6)
+	reset := func(e bsdroute.Entry) bool {
+		if e.Dev != "" {
+			return false
+		}
+		if e.Dst.Addr().Is6() {
+			return r.routeAddr6.IsValid() && r.routeAddr6 != routeAddr6
+		}
+		return r.routeAddr4.IsValid() && r.routeAddr4 != routeAddr4
+	}
 
-	newRoutes := set.Set[netip.Prefix]{}
-	for _, route := range cfg.Routes {
-		newRoutes.Add(route)
+	// As on FreeBSD, default routes (from an exit node) and routes to whole
+	// local networks are split in two, so that they take precedence over
+	// the system's routes without replacing them, and local routes bypass
+	// Tailscale. Close removes them, putting the system's default route
+	// back in effect.
+	//
+	// TODO: keep magicsock's own traffic out of the split routes. NetBSD
+	// has no equivalent of macOS's IP_BOUND_IF or route(8)'s -ifscope to
+	// bind it to the default interface, as net/netns does elsewhere.
+	var ifState *netmon.State
+	if r.netMon != nil {
+		ifState = r.netMon.InterfaceState()
 	}
-	for route := range r.routes {
-		if _, keep := newRoutes[route]; !keep {
-			net := netipx.PrefixIPNet(route)
-			nip := net.IP.Mask(net.Mask)
-			nstr := fmt.Sprintf("%v/%d", nip, route.Bits())
-			dst := localAddr4.Addr().String()
-			if route.Addr().Is6() {
-				dst = localAddr6.Addr().String()
-			}
-			routedel := []string{"route", "-q", "-n",
-				"del", "-" + inet(route), nstr,
-				"-iface", dst}
-			out, err := cmd(routedel...).CombinedOutput()
-			if err != nil {
-				r.logf("route del failed: %v: %v\n%s", routedel, err, out)
-				if errq == nil {
-					errq = err
-				}
-			}
+	newBypass := bypassRoutes(r.logf, ifState, r.tunname, cfg.LocalRoutes)
+	routes := splitLANRoutes(ifState, r.tunname, splitDefaultRoutes(cfg.Routes))
+	// Each IPv4 address also gets a host route into the interface.
+	for _, addr := range addrs {
+		if addr.Addr().Is4() {
+			routes = append(routes, netip.PrefixFrom(addr.Addr(), 32))
 		}
 	}
-	for route := range newRoutes {
-		if _, exists := r.routes[route]; !exists {
-			net := netipx.PrefixIPNet(route)
-			nip := net.IP.Mask(net.Mask)
-			nstr := fmt.Sprintf("%v/%d", nip, route.Bits())
-			dst := localAddr4.Addr().String()
-			if route.Addr().Is6() {
-				dst = localAddr6.Addr().String()
-			}
-			routeadd := []string{"route", "-q", "-n",
-				"add", "-" + inet(route), nstr,
-				"-iface", dst}
-			out, err := cmd(routeadd...).CombinedOutput()
-			if err != nil {
-				r.logf("addr add failed: %v: %v\n%s", routeadd, err, out)
-				if errq == nil {
-					errq = err
-				}
-			}
+	routeCmd := func(op bsdroute.Op) []string {
+		if op.Del {
+			return addrRouteCmd(ifState, r.routeAddr4, r.routeAddr6, op)
 		}
+		return addrRouteCmd(ifState, routeAddr4, routeAddr6, op)
+	}
+	wantRoutes := routeEntries(routes, newBypass)
+	if r.dryRun {
+		for _, op := range r.routes.Diff(wantRoutes, reset) {
+			changes = append(changes, "route "+op.String())
+		}
+		setPendingChanges(r.logf, changes)
+		return nil
+	}
+	if err := r.routes.Set(wantRoutes, reset, routeCmd); err != nil {
+		// The route changes were rolled back, so the next Set
+		// retries them.
+		r.logf("route update failed: %v", err)
+		setErr(err)
+	} else {
+		r.routeAddr4 = routeAddr4
+		r.routeAddr6 = routeAddr6
 	}
 
-	r.local4 = localAddr4
-	r.local6 = localAddr6
-	r.routes = newRoutes
+	if err := r.fw.set(cfg); err != nil {
+		r.logf("packet filter update failed: %v", err)
+		setErr(err)
+	}
 
+	if errq != nil {
+		r.health.SetUnhealthy(routerConfigWarnable, health.Args{health.ArgError: errq.Error()})
+	} else {
+		r.health.SetHealthy(routerConfigWarnable)
+	}
 	return errq
 }
 
-// UpdateMagicsockPort implements the Router interface. This implementation
-// does nothing and returns nil because this router does not currently need
-// to know what the magicsock UDP port is.
-func (r *netbsdRouter) UpdateMagicsockPort(_ uint16, _ string) error {
-	return nil
+// addrRouteCmd returns the route(8) command for op, pointing routes into the
+// Tailscale interface with addr4 or addr6.
+func addrRouteCmd(state *netmon.State, addr4, addr6 netip.Addr, op bsdroute.Op) []string {
+	if op.Dev != "" {
+		return append([]string{"route"}, bsdroute.Args(op, devAddr(state, op.Dev, op.Dst))...)
+	}
+	return append([]string{"route"}, bsdroute.AddrArgs(op, addr4, addr6)...)
+}
+
+// devAddr returns the address of the interface dev on the network of route,
+// as route(8) names interfaces by address, or else dev's name.
+func devAddr(state *netmon.State, dev string, route netip.Prefix) string {
+	if state != nil {
+		for _, pfx := range state.InterfaceIPs[dev] {
+			if pfx.Contains(route.Addr()) {
+				return pfx.Addr().String()
+			}
+		}
+	}
+	return dev
+}
+
+// UpdateMagicsockPort implements the Router interface. It lets the new port
+// through the system packet filter, if Tailscale manages one.
+func (r *netbsdRouter) UpdateMagicsockPort(port uint16, network string) error {
+	return r.fw.updateMagicsockPort(port, network)
+}
+
+// delRoutes removes the routes added by Set, including the split default
+// routes of an exit node, which would otherwise keep sending traffic into
+// the interface once it's down.
+func (r *netbsdRouter) delRoutes() error {
+	var ifState *netmon.State
+	if r.netMon != nil {
+		ifState = r.netMon.InterfaceState()
+	}
+	return r.routes.Flush(func(op bsdroute.Op) []string {
+		return addrRouteCmd(ifState, r.routeAddr4, r.routeAddr6, op)
+	})
 }
 
 func (r *netbsdRouter) Close() error {
-	cleanUp(r.logf, r.tunname)
-	return nil
+	r.retry.close()
+	if r.unregNetMon != nil {
+		r.unregNetMon()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	if err := r.delRoutes(); err != nil {
+		r.logf("removing routes failed: %v", err)
+	}
+	r.health.SetHealthy(routerConfigWarnable)
+	ifDown(r.logf, r.cmd, r.tunname)
+	return r.fw.close()
 }
 
-func cleanUp(logf logger.Logf, interfaceName string) {
+// ifDown brings the interface named interfaceName down.
+func ifDown(logf logger.Logf, cmd commandRunner, interfaceName string) {
 	ifdown := []string{"ifconfig", interfaceName, "down"}
 	logf("cleanUp: ifdown=%s", ifdown)
-	out, err := cmd(ifdown...).CombinedOutput()
+	out, err := cmd.output(ifdown...)
 	logf("cleanUp: interfaceName=%s", interfaceName)
 	if err != nil {
 		logf("ifconfig down: %v\n%s", err, out)
 	}
 }
+
+func cleanUp(logf logger.Logf, interfaceName string) {
+	ifDown(logf, osCommandRunner{}, interfaceName)
+	cleanUpFirewall(logf)
+}
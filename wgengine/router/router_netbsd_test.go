@@ -0,0 +1,309 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package router
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"tailscale.com/util/set"
+	"tailscale.com/wgengine/router/bsdroute"
+)
+
+func TestNetBSDAddrCmd(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	tests := []struct {
+		got  []string
+		want []string
+	}{
+		{
+			addrCmd("tun0", pfx("100.101.102.103/32"), false),
+			[]string{"ifconfig", "tun0", "inet", "100.101.102.103/32", "alias"},
+		},
+		{
+			addrCmd("tun0", pfx("100.101.102.103/32"), true),
+			[]string{"ifconfig", "tun0", "inet", "100.101.102.103/32", "-alias"},
+		},
+		{
+			addrCmd("tun0", pfx("fd7a:115c:a1e0::1/128"), false),
+			[]string{"ifconfig", "tun0", "inet6", "fd7a:115c:a1e0::1/48", "alias"},
+		},
+		{
+			addrCmd("tun0", pfx("fd7a:115c:a1e0::1/128"), true),
+			[]string{"ifconfig", "tun0", "inet6", "fd7a:115c:a1e0::1/48", "delete"},
+		},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("got %q, want %q", tt.got, tt.want)
+		}
+	}
+}
+
+func TestNetBSDRouteAddr(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	ip := netip.MustParseAddr
+	addrs := []netip.Prefix{
+		pfx("100.64.0.1/32"),
+		pfx("fd7a:115c:a1e0::1/128"),
+		pfx("100.64.0.2/32"),
+		pfx("fd7a:115c:a1e0::2/128"),
+	}
+	tests := []struct {
+		name string
+		is6  bool
+		cur  netip.Addr
+		want netip.Addr
+	}{
+		{"first-v4", false, netip.Addr{}, ip("100.64.0.1")},
+		{"first-v6", true, netip.Addr{}, ip("fd7a:115c:a1e0::1")},
+		{"keep-current", false, ip("100.64.0.2"), ip("100.64.0.2")},
+		{"current-gone", false, ip("100.64.0.3"), ip("100.64.0.1")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := routeAddr(addrs, tt.is6, tt.cur); got != tt.want {
+				t.Errorf("routeAddr = %v; want %v", got, tt.want)
+			}
+		})
+	}
+	if got := routeAddr(addrs[:1], true, netip.Addr{}); got.IsValid() {
+		t.Errorf("routeAddr without IPv6 addresses = %v; want invalid", got)
+	}
+}
+
+func TestNetBSDAddrRouteCmd(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	addr4 := netip.MustParseAddr("100.64.0.1")
+	addr6 := netip.MustParseAddr("fd7a:115c:a1e0::1")
+	tests := []struct {
+		op   bsdroute.Op
+		want []string
+	}{
+		{
+			bsdroute.Op{Entry: bsdroute.Entry{Dst: pfx("0.0.0.0/1")}},
+			[]string{"route", "-q", "-n", "add", "-inet", "0.0.0.0/1", "-iface", "100.64.0.1"},
+		},
+		{
+			bsdroute.Op{Del: true, Entry: bsdroute.Entry{Dst: pfx("128.0.0.0/1")}},
+			[]string{"route", "-q", "-n", "delete", "-inet", "128.0.0.0/1", "-iface", "100.64.0.1"},
+		},
+		{
+			bsdroute.Op{Entry: bsdroute.Entry{Dst: pfx("8000::/1")}},
+			[]string{"route", "-q", "-n", "add", "-inet6", "8000::/1", "-iface", "fd7a:115c:a1e0::1"},
+		},
+		{
+			bsdroute.Op{Del: true, Entry: bsdroute.Entry{Dst: pfx("192.168.1.0/24"), Dev: "wm0"}},
+			[]string{"route", "-q", "-n", "delete", "-inet", "192.168.1.0/24", "-iface", "wm0"},
+		},
+	}
+	for _, tt := range tests {
+		if got := addrRouteCmd(nil, addr4, addr6, tt.op); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("addrRouteCmd(%v) = %q; want %q", tt.op, got, tt.want)
+		}
+	}
+}
+
+// fakeBSDCommands is a commandRunner for the BSD routers' tests. It records
+// the commands it's asked to run, fails those in fail, and keeps track of
+// the interface's MTU.
+type fakeBSDCommands struct {
+	mu   sync.Mutex
+	cmds []string
+	fail set.Set[string]
+	mtu  int
+}
+
+func (f *fakeBSDCommands) run(args ...string) error {
+	_, err := f.output(args...)
+	return err
+}
+
+func (f *fakeBSDCommands) output(args ...string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := strings.Join(args, " ")
+	f.cmds = append(f.cmds, c)
+	if f.fail.Contains(c) {
+		return []byte("route: writing to routing socket: Network is unreachable\n"), errors.New("exit status 1")
+	}
+	if len(args) == 4 && args[0] == "ifconfig" && args[2] == "mtu" {
+		f.mtu, _ = strconv.Atoi(args[3])
+	}
+	return nil, nil
+}
+
+// take returns the commands run since the last call, one per line.
+func (f *fakeBSDCommands) take() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var b strings.Builder
+	for _, c := range f.cmds {
+		b.WriteString("\n" + c)
+	}
+	f.cmds = nil
+	return b.String() + "\n"
+}
+
+// iface returns the interface, for tunMTU.lookup.
+func (f *fakeBSDCommands) iface(name string) (*net.Interface, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &net.Interface{Index: 1, Name: name, MTU: f.mtu}, nil
+}
+
+func TestNetBSDRouterStates(t *testing.T) {
+	fake := &fakeBSDCommands{mtu: 1280}
+	r, err := newUserspaceRouterAdvanced(t.Logf, "tun0", nil, fake, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.mtu.lookup = fake.iface
+	t.Cleanup(func() { r.retry.close() })
+
+	states := []struct {
+		name    string
+		in      *Config
+		fail    []string
+		wantErr bool
+		want    string
+	}{
+		{
+			name: "addrs and routes",
+			in: &Config{
+				LocalAddrs:                 mustCIDRs("100.101.102.103/32", "fd7a:115c:a1e0::1/128"),
+				Routes:                     mustCIDRs("100.64.0.0/10", "fd7a:115c:a1e0::/48"),
+				IgnoreRouterAdvertisements: true,
+			},
+			want: `
+ndp -i tun0 -- -accept_rtadv
+ifconfig tun0 inet 100.101.102.103/32 alias
+ifconfig tun0 inet6 fd7a:115c:a1e0::1/48 alias
+route -q -n add -inet 100.64.0.0/10 -iface 100.101.102.103
+route -q -n add -inet 100.101.102.103/32 -iface 100.101.102.103
+route -q -n add -inet6 fd7a:115c:a1e0::/48 -iface fd7a:115c:a1e0::1
+`,
+		},
+		{
+			name: "exit node and mtu",
+			in: &Config{
+				LocalAddrs:                 mustCIDRs("100.101.102.103/32", "fd7a:115c:a1e0::1/128"),
+				Routes:                     mustCIDRs("100.64.0.0/10", "fd7a:115c:a1e0::/48", "0.0.0.0/0", "::/0"),
+				NewMTU:                     1400,
+				IgnoreRouterAdvertisements: true,
+			},
+			want: `
+ifconfig tun0 mtu 1400
+route -q -n add -inet 0.0.0.0/1 -iface 100.101.102.103
+route -q -n add -inet 128.0.0.0/1 -iface 100.101.102.103
+route -q -n add -inet6 ::/1 -iface fd7a:115c:a1e0::1
+route -q -n add -inet6 8000::/1 -iface fd7a:115c:a1e0::1
+`,
+		},
+		{
+			name: "unchanged",
+			in: &Config{
+				LocalAddrs:                 mustCIDRs("100.101.102.103/32", "fd7a:115c:a1e0::1/128"),
+				Routes:                     mustCIDRs("100.64.0.0/10", "fd7a:115c:a1e0::/48", "0.0.0.0/0", "::/0"),
+				NewMTU:                     1400,
+				IgnoreRouterAdvertisements: true,
+			},
+			want: `
+`,
+		},
+		{
+			name: "new ipv4 addr",
+			in: &Config{
+				LocalAddrs:                 mustCIDRs("100.101.102.104/32", "fd7a:115c:a1e0::1/128"),
+				Routes:                     mustCIDRs("100.64.0.0/10", "fd7a:115c:a1e0::/48", "0.0.0.0/0", "::/0"),
+				NewMTU:                     1400,
+				IgnoreRouterAdvertisements: true,
+			},
+			want: `
+ifconfig tun0 inet 100.101.102.103/32 -alias
+ifconfig tun0 inet 100.101.102.104/32 alias
+route -q -n delete -inet 0.0.0.0/1 -iface 100.101.102.103
+route -q -n delete -inet 100.64.0.0/10 -iface 100.101.102.103
+route -q -n delete -inet 100.101.102.103/32 -iface 100.101.102.103
+route -q -n delete -inet 128.0.0.0/1 -iface 100.101.102.103
+route -q -n add -inet 0.0.0.0/1 -iface 100.101.102.104
+route -q -n add -inet 100.64.0.0/10 -iface 100.101.102.104
+route -q -n add -inet 100.101.102.104/32 -iface 100.101.102.104
+route -q -n add -inet 128.0.0.0/1 -iface 100.101.102.104
+`,
+		},
+		{
+			name: "failed route is rolled back",
+			in: &Config{
+				LocalAddrs:                 mustCIDRs("100.101.102.104/32", "fd7a:115c:a1e0::1/128"),
+				Routes:                     mustCIDRs("100.64.0.0/10", "fd7a:115c:a1e0::/48", "10.0.0.0/8", "192.168.0.0/16"),
+				NewMTU:                     1400,
+				IgnoreRouterAdvertisements: true,
+			},
+			fail:    []string{"route -q -n add -inet 192.168.0.0/16 -iface 100.101.102.104"},
+			wantErr: true,
+			want: `
+route -q -n delete -inet 0.0.0.0/1 -iface 100.101.102.104
+route -q -n delete -inet 128.0.0.0/1 -iface 100.101.102.104
+route -q -n delete -inet6 ::/1 -iface fd7a:115c:a1e0::1
+route -q -n delete -inet6 8000::/1 -iface fd7a:115c:a1e0::1
+route -q -n add -inet 10.0.0.0/8 -iface 100.101.102.104
+route -q -n add -inet 192.168.0.0/16 -iface 100.101.102.104
+route -q -n delete -inet 10.0.0.0/8 -iface 100.101.102.104
+route -q -n add -inet6 8000::/1 -iface fd7a:115c:a1e0::1
+route -q -n add -inet6 ::/1 -iface fd7a:115c:a1e0::1
+route -q -n add -inet 128.0.0.0/1 -iface 100.101.102.104
+route -q -n add -inet 0.0.0.0/1 -iface 100.101.102.104
+`,
+		},
+		{
+			name: "4via6 route",
+			in: &Config{
+				LocalAddrs:                 mustCIDRs("100.101.102.104/32", "fd7a:115c:a1e0::1/128"),
+				Routes:                     mustCIDRs("100.64.0.0/10", "fd7a:115c:a1e0::/48", "fd7a:115c:a1e0:b1a:0:7:a00:0/120"),
+				NewMTU:                     1400,
+				IgnoreRouterAdvertisements: true,
+			},
+			want: `
+route -q -n delete -inet 0.0.0.0/1 -iface 100.101.102.104
+route -q -n delete -inet 128.0.0.0/1 -iface 100.101.102.104
+route -q -n delete -inet6 ::/1 -iface fd7a:115c:a1e0::1
+route -q -n delete -inet6 8000::/1 -iface fd7a:115c:a1e0::1
+route -q -n add -inet6 fd7a:115c:a1e0:b1a:0:7:a00:0/120 -iface fd7a:115c:a1e0::1
+`,
+		},
+		{
+			name: "shutdown",
+			in:   nil,
+			want: `
+ifconfig tun0 mtu 1280
+ndp -i tun0 -- accept_rtadv
+ifconfig tun0 inet 100.101.102.104/32 -alias
+ifconfig tun0 inet6 fd7a:115c:a1e0::1/48 delete
+route -q -n delete -inet 100.64.0.0/10 -iface 100.101.102.104
+route -q -n delete -inet 100.101.102.104/32 -iface 100.101.102.104
+route -q -n delete -inet6 fd7a:115c:a1e0::/48 -iface fd7a:115c:a1e0::1
+route -q -n delete -inet6 fd7a:115c:a1e0:b1a:0:7:a00:0/120 -iface fd7a:115c:a1e0::1
+`,
+		},
+	}
+	for _, st := range states {
+		t.Run(st.name, func(t *testing.T) {
+			fake.fail = set.SetOf(st.fail)
+			err := r.Set(st.in)
+			if (err != nil) != st.wantErr {
+				t.Errorf("Set err = %v; wantErr %v", err, st.wantErr)
+			}
+			if got := fake.take(); got != st.want {
+				t.Errorf("commands\n got: %s\nwant: %s", got, st.want)
+			}
+		})
+	}
+}
@@ -6,16 +6,16 @@
 import (
 	"errors"
 	"fmt"
-	"log"
 	"net/netip"
-	"os/exec"
+	"strconv"
 
 	"github.com/tailscale/wireguard-go/tun"
-	"go4.org/netipx"
+	"tailscale.com/envknob"
 	"tailscale.com/health"
 	"tailscale.com/net/netmon"
 	"tailscale.com/types/logger"
 	"tailscale.com/util/set"
+	"tailscale.com/wgengine/router/bsdroute"
 )
 
 // For now this router only supports the WireGuard userspace implementation.
@@ -26,45 +26,103 @@ type openbsdRouter struct {
 	logf    logger.Logf
 	netMon  *netmon.Monitor
 	tunname string
+	cmd     commandRunner
 	local4  netip.Prefix
 	local6  netip.Prefix
-	routes  set.Set[netip.Prefix]
+	routes  *bsdroute.Table
+	fw      *bsdFirewall
+	rdomain int // routing domain of the interface and its routes
+	bypass  *bypassTable
+	mtu     *tunMTU
+
+	ignoreRA    bool // whether router advertisements are turned off on the interface
+	unregNetMon func()
 }
 
+// tunRDomain is the routing domain (see rdomain(4)) to place the Tailscale
+// interface and the routes into it in. tailscaled's own sockets, and so
+// its control, DERP and WireGuard traffic, stay in the routing table that
+// tailscaled was started in (see route(8)'s exec command).
+var tunRDomain = envknob.RegisterInt("TS_TUN_RDOMAIN")
+
 func newUserspaceRouter(logf logger.Logf, tundev tun.Device, netMon *netmon.Monitor, health *health.Tracker) (Router, error) {
 	tunname, err := tundev.Name()
 	if err != nil {
 		return nil, err
 	}
 
-	return &openbsdRouter{
+	cmd := osCommandRunner{}
+	rdomain := tunRDomain()
+	if rdomain < 0 || rdomain > 255 {
+		return nil, fmt.Errorf("invalid rdomain %d", rdomain)
+	}
+	if rdomain != 0 {
+		// Moving the interface to another rdomain removes its addresses,
+		// so do it before Set adds any.
+		args := []string{"ifconfig", tunname, "rdomain", strconv.Itoa(rdomain)}
+		if out, err := cmd.output(args...); err != nil {
+			return nil, fmt.Errorf("%v: %w\n%s", args, err, out)
+		}
+		logf("placed %s in rdomain %d", tunname, rdomain)
+	}
+
+	routes := bsdroute.NewTable(logf, tunname)
+	// Our routing socket messages address the default routing table,
+	// so use route(8), which can target another.
+	routes.SetUseCmd(rdomain != 0)
+	routes.SetRun(cmd.output)
+	adoptStaleRoutes(logf, routes)
+
+	r := &openbsdRouter{
 		logf:    logf,
 		netMon:  netMon,
 		tunname: tunname,
-	}, nil
+		cmd:     cmd,
+		routes:  routes,
+		fw:      newBSDFirewall(logf, tunname),
+		rdomain: rdomain,
+		bypass:  newBypassTable(logf, cmd, netMon, tunname),
+		mtu:     newTunMTU(logf, cmd, tunname),
+	}
+	if netMon != nil {
+		// Keep the bypass table in line with the network as it changes,
+		// not only when the router is reconfigured, and set the MTU
+		// again if the interface was recreated.
+		r.unregNetMon = netMon.RegisterChangeCallback(func(*netmon.ChangeDelta) {
+			if err := r.mtu.check(); err != nil {
+				r.logf("mtu change failed: %v", err)
+			}
+			r.bypass.update()
+		})
+	}
+	return r, nil
 }
 
-func cmd(args ...string) *exec.Cmd {
-	if len(args) == 0 {
-		log.Fatalf("exec.Cmd(%#v) invalid; need argv[0]", args)
+// route returns the route(8) command line with args, acting on the
+// routing table of the interface's rdomain.
+func (r *openbsdRouter) route(args ...string) []string {
+	if r.rdomain == 0 {
+		return append([]string{"route"}, args...)
 	}
-	return exec.Command(args[0], args[1:]...)
+	return append([]string{"route", "-T", strconv.Itoa(r.rdomain)}, args...)
 }
 
 func (r *openbsdRouter) Up() error {
 	ifup := []string{"ifconfig", r.tunname, "up"}
-	if out, err := cmd(ifup...).CombinedOutput(); err != nil {
+	if out, err := r.cmd.output(ifup...); err != nil {
 		r.logf("running ifconfig failed: %v\n%s", err, out)
 		return err
 	}
 	return nil
 }
 
-func inet(p netip.Prefix) string {
-	if p.Addr().Is6() {
-		return "inet6"
-	}
-	return "inet"
+// rtpMax is RTP_MAX, the largest (least preferred) route priority.
+const rtpMax = 63
+
+// routePriority returns the route priority of a route with the given
+// metric, or 0 for the default if it has none.
+func routePriority(metric uint32) uint8 {
+	return uint8(min(metric, rtpMax))
 }
 
 func (r *openbsdRouter) Set(cfg *Config) error {
@@ -96,11 +154,27 @@ func (r *openbsdRouter) Set(cfg *Config) error {
 
 	var errq error
 
+	if err := r.mtu.set(cfg.NewMTU); err != nil {
+		r.logf("mtu change failed: %v", err)
+		errq = err
+	}
+
+	if cfg.IgnoreRouterAdvertisements != r.ignoreRA {
+		if err := setAcceptRA(r.cmd, r.tunname, !cfg.IgnoreRouterAdvertisements); err != nil {
+			r.logf("router advertisements change failed: %v", err)
+			if errq == nil {
+				errq = err
+			}
+		} else {
+			r.ignoreRA = cfg.IgnoreRouterAdvertisements
+		}
+	}
+
 	if localAddr4 != r.local4 {
 		if r.local4.IsValid() {
 			addrdel := []string{"ifconfig", r.tunname,
 				"inet", r.local4.String(), "-alias"}
-			out, err := cmd(addrdel...).CombinedOutput()
+			out, err := r.cmd.output(addrdel...)
 			if err != nil {
 				r.logf("addr del failed: %v: %v\n%s", addrdel, err, out)
 				if errq == nil {
@@ -108,10 +182,10 @@ func (r *openbsdRouter) Set(cfg *Config) error {
 				}
 			}
 
-			routedel := []string{"route", "-q", "-n",
+			routedel := r.route("-q", "-n",
 				"del", "-inet", r.local4.String(),
-				"-iface", r.local4.Addr().String()}
-			if out, err := cmd(routedel...).CombinedOutput(); err != nil {
+				"-iface", r.local4.Addr().String())
+			if out, err := r.cmd.output(routedel...); err != nil {
 				r.logf("route del failed: %v: %v\n%s", routedel, err, out)
 				if errq == nil {
 					errq = err
@@ -122,7 +196,7 @@ func (r *openbsdRouter) Set(cfg *Config) error {
 		if localAddr4.IsValid() {
 			addradd := []string{"ifconfig", r.tunname,
 				"inet", localAddr4.String(), "alias"}
-			out, err := cmd(addradd...).CombinedOutput()
+			out, err := r.cmd.output(addradd...)
 			if err != nil {
 				r.logf("addr add failed: %v: %v\n%s", addradd, err, out)
 				if errq == nil {
@@ -130,10 +204,10 @@ func (r *openbsdRouter) Set(cfg *Config) error {
 				}
 			}
 
-			routeadd := []string{"route", "-q", "-n",
+			routeadd := r.route("-q", "-n",
 				"add", "-inet", localAddr4.String(),
-				"-iface", localAddr4.Addr().String()}
-			if out, err := cmd(routeadd...).CombinedOutput(); err != nil {
+				"-iface", localAddr4.Addr().String())
+			if out, err := r.cmd.output(routeadd...); err != nil {
 				r.logf("route add failed: %v: %v\n%s", routeadd, err, out)
 				if errq == nil {
 					errq = err
@@ -153,7 +227,7 @@ func (r *openbsdRouter) Set(cfg *Config) error {
 		if r.local6.IsValid() {
 			addrdel := []string{"ifconfig", r.tunname,
 				"inet6", r.local6.String(), "delete"}
-			out, err := cmd(addrdel...).CombinedOutput()
+			out, err := r.cmd.output(addrdel...)
 			if err != nil {
 				r.logf("addr del failed: %v: %v\n%s", addrdel, err, out)
 				if errq == nil {
@@ -165,7 +239,7 @@ func (r *openbsdRouter) Set(cfg *Config) error {
 		if localAddr6.IsValid() {
 			addradd := []string{"ifconfig", r.tunname,
 				"inet6", localAddr6.String()}
-			out, err := cmd(addradd...).CombinedOutput()
+			out, err := r.cmd.output(addradd...)
 			if err != nil {
 				r.logf("addr add failed: %v: %v\n%s", addradd, err, out)
 				if errq == nil {
@@ -175,75 +249,68 @@ func (r *openbsdRouter) Set(cfg *Config) error {
 		}
 	}
 
-	newRoutes := set.Set[netip.Prefix]{}
+	newRoutes := set.Set[bsdroute.Entry]{}
 	for _, route := range cfg.Routes {
-		newRoutes.Add(route)
-	}
-	for route := range r.routes {
-		if _, keep := newRoutes[route]; !keep {
-			net := netipx.PrefixIPNet(route)
-			nip := net.IP.Mask(net.Mask)
-			nstr := fmt.Sprintf("%v/%d", nip, route.Bits())
-			dst := localAddr4.Addr().String()
-			if route.Addr().Is6() {
-				dst = localAddr6.Addr().String()
-			}
-			routedel := []string{"route", "-q", "-n",
-				"del", "-" + inet(route), nstr,
-				"-iface", dst}
-			out, err := cmd(routedel...).CombinedOutput()
-			if err != nil {
-				r.logf("route del failed: %v: %v\n%s", routedel, err, out)
-				if errq == nil {
-					errq = err
-				}
-			}
-		}
+		newRoutes.Add(bsdroute.Entry{Dst: route, Priority: routePriority(cfg.RouteMetrics[route])})
 	}
-	for route := range newRoutes {
-		if _, exists := r.routes[route]; !exists {
-			net := netipx.PrefixIPNet(route)
-			nip := net.IP.Mask(net.Mask)
-			nstr := fmt.Sprintf("%v/%d", nip, route.Bits())
-			dst := localAddr4.Addr().String()
-			if route.Addr().Is6() {
-				dst = localAddr6.Addr().String()
-			}
-			routeadd := []string{"route", "-q", "-n",
-				"add", "-" + inet(route), nstr,
-				"-iface", dst}
-			out, err := cmd(routeadd...).CombinedOutput()
-			if err != nil {
-				r.logf("addr add failed: %v: %v\n%s", routeadd, err, out)
-				if errq == nil {
-					errq = err
-				}
-			}
+	routeCmd := func(op bsdroute.Op) []string {
+		return r.route(bsdroute.AddrArgs(op, localAddr4.Addr(), localAddr6.Addr())...)
+	}
+	if err := r.routes.Set(newRoutes, nil, routeCmd); err != nil {
+		// The route changes were rolled back, so the next Set
+		// retries them.
+		r.logf("route update failed: %v", err)
+		if errq == nil {
+			errq = err
 		}
 	}
 
 	r.local4 = localAddr4
 	r.local6 = localAddr6
-	r.routes = newRoutes
 
+	if err := r.bypass.update(); err != nil && errq == nil {
+		errq = err
+	}
+
+	if err := r.fw.set(cfg); err != nil {
+		r.logf("packet filter update failed: %v", err)
+		if errq == nil {
+			errq = err
+		}
+	}
+
+	var local []netip.Prefix
+	for _, addr := range []netip.Prefix{r.local4, r.local6} {
+		if addr.IsValid() {
+			local = append(local, addr)
+		}
+	}
+	publishState(r.tunname, local, r.routes, errq)
 	return errq
 }
 
-// UpdateMagicsockPort implements the Router interface. This implementation
-// does nothing and returns nil because this router does not currently need
-// to know what the magicsock UDP port is.
-func (r *openbsdRouter) UpdateMagicsockPort(_ uint16, _ string) error {
-	return nil
+// UpdateMagicsockPort implements the Router interface. It lets the new port
+// through the system packet filter, if Tailscale manages one.
+func (r *openbsdRouter) UpdateMagicsockPort(port uint16, network string) error {
+	return r.fw.updateMagicsockPort(port, network)
 }
 
 func (r *openbsdRouter) Close() error {
+	if r.unregNetMon != nil {
+		r.unregNetMon()
+	}
 	cleanUp(r.logf, r.tunname)
-	return nil
+	err := r.fw.close()
+	if err2 := r.bypass.close(); err == nil {
+		err = err2
+	}
+	return err
 }
 
 func cleanUp(logf logger.Logf, interfaceName string) {
-	out, err := cmd("ifconfig", interfaceName, "down").CombinedOutput()
+	out, err := (osCommandRunner{}).output("ifconfig", interfaceName, "down")
 	if err != nil {
 		logf("ifconfig down: %v\n%s", err, out)
 	}
+	cleanUpFirewall(logf)
 }
@@ -22,8 +22,8 @@ func mustCIDRs(ss ...string) []netip.Prefix {
 
 func TestConfigEqual(t *testing.T) {
 	testedFields := []string{
-		"LocalAddrs", "Routes", "LocalRoutes", "NewMTU",
-		"SubnetRoutes", "SNATSubnetRoutes", "StatefulFiltering",
+		"LocalAddrs", "Routes", "RouteMetrics", "LocalRoutes", "NewMTU",
+		"IgnoreRouterAdvertisements", "SubnetRoutes", "SNATSubnetRoutes", "StatefulFiltering",
 		"NetfilterMode", "NetfilterKind",
 	}
 	configType := reflect.TypeFor[Config]()
@@ -156,6 +156,26 @@ func TestConfigEqual(t *testing.T) {
 			&Config{NewMTU: 0},
 			false,
 		},
+		{
+			&Config{IgnoreRouterAdvertisements: true},
+			&Config{IgnoreRouterAdvertisements: true},
+			true,
+		},
+		{
+			&Config{IgnoreRouterAdvertisements: true},
+			&Config{},
+			false,
+		},
+		{
+			&Config{RouteMetrics: map[netip.Prefix]uint32{netip.MustParsePrefix("192.168.1.0/24"): 100}},
+			&Config{RouteMetrics: map[netip.Prefix]uint32{netip.MustParsePrefix("192.168.1.0/24"): 100}},
+			true,
+		},
+		{
+			&Config{RouteMetrics: map[netip.Prefix]uint32{netip.MustParsePrefix("192.168.1.0/24"): 100}},
+			&Config{RouteMetrics: map[netip.Prefix]uint32{netip.MustParsePrefix("192.168.1.0/24"): 200}},
+			false,
+		},
 	}
 	for i, tt := range tests {
 		got := tt.a.Equal(tt.b)
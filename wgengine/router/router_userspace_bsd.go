@@ -6,19 +6,15 @@
 package router
 
 import (
-	"fmt"
-	"log"
 	"net/netip"
-	"os/exec"
 	"runtime"
 
 	"github.com/tailscale/wireguard-go/tun"
-	"go4.org/netipx"
 	"tailscale.com/health"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/types/logger"
-	"tailscale.com/version"
+	"tailscale.com/wgengine/router/bsdroute"
 )
 
 type userspaceBSDRouter struct {
@@ -26,8 +22,15 @@ type userspaceBSDRouter struct {
 	netMon  *netmon.Monitor
 	health  *health.Tracker
 	tunname string
+	cmd     commandRunner
 	local   []netip.Prefix
-	routes  map[netip.Prefix]bool
+	routes  *bsdroute.Table
+	fw      *bsdFirewall
+	bypass  *bypassTable
+	mtu     *tunMTU
+
+	ignoreRA    bool // whether router advertisements are turned off on the interface
+	unregNetMon func()
 }
 
 func newUserspaceBSDRouter(logf logger.Logf, tundev tun.Device, netMon *netmon.Monitor, health *health.Tracker) (Router, error) {
@@ -36,12 +39,33 @@ func newUserspaceBSDRouter(logf logger.Logf, tundev tun.Device, netMon *netmon.M
 		return nil, err
 	}
 
-	return &userspaceBSDRouter{
+	cmd := osCommandRunner{}
+	routes := bsdroute.NewTable(logf, tunname)
+	routes.SetRun(cmd.output)
+	adoptStaleRoutes(logf, routes)
+	r := &userspaceBSDRouter{
 		logf:    logf,
 		netMon:  netMon,
 		health:  health,
 		tunname: tunname,
-	}, nil
+		cmd:     cmd,
+		routes:  routes,
+		fw:      newBSDFirewall(logf, tunname),
+		bypass:  newBypassTable(logf, cmd, netMon, tunname),
+		mtu:     newTunMTU(logf, cmd, tunname),
+	}
+	if netMon != nil {
+		// Keep the bypass table in line with the network as it changes,
+		// not only when the router is reconfigured, and set the MTU
+		// again if the interface was recreated.
+		r.unregNetMon = netMon.RegisterChangeCallback(func(*netmon.ChangeDelta) {
+			if err := r.mtu.check(); err != nil {
+				r.logf("mtu change failed: %v", err)
+			}
+			r.bypass.update()
+		})
+	}
+	return r, nil
 }
 
 func (r *userspaceBSDRouter) addrsToRemove(newLocalAddrs []netip.Prefix) (remove []netip.Prefix) {
@@ -76,16 +100,9 @@ func (r *userspaceBSDRouter) addrsToAdd(newLocalAddrs []netip.Prefix) (add []net
 	return
 }
 
-func cmd(args ...string) *exec.Cmd {
-	if len(args) == 0 {
-		log.Fatalf("exec.Cmd(%#v) invalid; need argv[0]", args)
-	}
-	return exec.Command(args[0], args[1:]...)
-}
-
 func (r *userspaceBSDRouter) Up() error {
 	ifup := []string{"ifconfig", r.tunname, "up"}
-	if out, err := cmd(ifup...).CombinedOutput(); err != nil {
+	if out, err := r.cmd.output(ifup...); err != nil {
 		r.logf("running ifconfig failed: %v\n%s", err, out)
 		return err
 	}
@@ -109,6 +126,19 @@ func (r *userspaceBSDRouter) Set(cfg *Config) (reterr error) {
 			reterr = err
 		}
 	}
+	if err := r.mtu.set(cfg.NewMTU); err != nil {
+		r.logf("mtu change failed: %v", err)
+		setErr(err)
+	}
+	if cfg.IgnoreRouterAdvertisements != r.ignoreRA {
+		if err := setAcceptRA(r.cmd, r.tunname, !cfg.IgnoreRouterAdvertisements); err != nil {
+			r.logf("router advertisements change failed: %v", err)
+			setErr(err)
+		} else {
+			r.ignoreRA = cfg.IgnoreRouterAdvertisements
+		}
+	}
+
 	addrsToRemove := r.addrsToRemove(cfg.LocalAddrs)
 
 	// If we're removing all addresses, we need to remove and re-add all
@@ -118,7 +148,7 @@ func (r *userspaceBSDRouter) Set(cfg *Config) (reterr error) {
 	// Update the addresses.
 	for _, addr := range addrsToRemove {
 		arg := []string{"ifconfig", r.tunname, inet(addr), addr.String(), "-alias"}
-		out, err := cmd(arg...).CombinedOutput()
+		out, err := r.cmd.output(arg...)
 		if err != nil {
 			r.logf("addr del failed: %v => %v\n%s", arg, err, out)
 			setErr(err)
@@ -136,76 +166,83 @@ func (r *userspaceBSDRouter) Set(cfg *Config) (reterr error) {
 		} else {
 			arg = []string{"ifconfig", r.tunname, inet(addr), addr.String(), addr.Addr().String()}
 		}
-		out, err := cmd(arg...).CombinedOutput()
+		out, err := r.cmd.output(arg...)
 		if err != nil {
 			r.logf("addr add failed: %v => %v\n%s", arg, err, out)
 			setErr(err)
 		}
 	}
 
-	newRoutes := make(map[netip.Prefix]bool)
-	for _, route := range cfg.Routes {
+	// Default routes (from an exit node) are split in two, so that they
+	// take precedence over the system's default route without replacing
+	// it, as are routes to whole local networks, which would collide with
+	// the system's routes to them. Local routes, the local networks
+	// allowed while using an exit node, bypass Tailscale.
+	var ifState *netmon.State
+	if r.netMon != nil {
+		ifState = r.netMon.InterfaceState()
+	}
+	newBypass := bypassRoutes(r.logf, ifState, r.tunname, cfg.LocalRoutes)
+	var newRoutes []netip.Prefix
+	for _, route := range splitLANRoutes(ifState, r.tunname, splitDefaultRoutes(cfg.Routes)) {
 		if runtime.GOOS != "darwin" && route == tsaddr.TailscaleULARange() {
 			// Because we added the interface address as a /48 above,
 			// the kernel already created the Tailscale ULA route
 			// implicitly. We mustn't try to add/delete it ourselves.
 			continue
 		}
-		newRoutes[route] = true
-	}
-	// Delete any preexisting routes.
-	for route := range r.routes {
-		if resetRoutes || !newRoutes[route] {
-			net := netipx.PrefixIPNet(route)
-			nip := net.IP.Mask(net.Mask)
-			nstr := fmt.Sprintf("%v/%d", nip, route.Bits())
-			del := "del"
-			if version.OS() == "macOS" {
-				del = "delete"
-			}
-			routedel := []string{"route", "-q", "-n",
-				del, "-" + inet(route), nstr,
-				"-iface", r.tunname}
-			out, err := cmd(routedel...).CombinedOutput()
-			if err != nil {
-				r.logf("route del failed: %v: %v\n%s", routedel, err, out)
-				setErr(err)
-			}
+		newRoutes = append(newRoutes, route)
+	}
+	reset := func(e bsdroute.Entry) bool { return resetRoutes && e.Dev == "" }
+	routeCmd := func(op bsdroute.Op) []string {
+		gw := r.tunname
+		if op.Dev != "" {
+			gw = op.Dev
 		}
+		return append([]string{"route"}, bsdroute.Args(op, gw)...)
 	}
-	// Add the routes.
-	for route := range newRoutes {
-		if resetRoutes || !r.routes[route] {
-			net := netipx.PrefixIPNet(route)
-			nip := net.IP.Mask(net.Mask)
-			nstr := fmt.Sprintf("%v/%d", nip, route.Bits())
-			routeadd := []string{"route", "-q", "-n",
-				"add", "-" + inet(route), nstr,
-				"-iface", r.tunname}
-			out, err := cmd(routeadd...).CombinedOutput()
-			if err != nil {
-				r.logf("addr add failed: %v: %v\n%s", routeadd, err, out)
-				setErr(err)
-			}
+	if err := r.routes.Set(routeEntries(newRoutes, newBypass), reset, routeCmd); err != nil {
+		// The route changes were rolled back, so the next Set retries
+		// them. If the kernel dropped our routes along with the
+		// addresses, nothing remains.
+		r.logf("route update failed: %v", err)
+		setErr(err)
+		if resetRoutes {
+			r.routes.Forget()
 		}
 	}
 
-	// Store the interface and routes so we know what to change on an update.
+	if err := r.bypass.update(); err != nil {
+		setErr(err)
+	}
+
+	// Store the interface addresses so we know what to change on an update.
 	if reterr == nil {
 		r.local = append([]netip.Prefix{}, cfg.LocalAddrs...)
 	}
-	r.routes = newRoutes
 
+	if err := r.fw.set(cfg); err != nil {
+		r.logf("packet filter update failed: %v", err)
+		setErr(err)
+	}
+
+	publishState(r.tunname, r.local, r.routes, reterr)
 	return reterr
 }
 
-// UpdateMagicsockPort implements the Router interface. This implementation
-// does nothing and returns nil because this router does not currently need
-// to know what the magicsock UDP port is.
-func (r *userspaceBSDRouter) UpdateMagicsockPort(_ uint16, _ string) error {
-	return nil
+// UpdateMagicsockPort implements the Router interface. It lets the new port
+// through the system packet filter, if Tailscale manages one.
+func (r *userspaceBSDRouter) UpdateMagicsockPort(port uint16, network string) error {
+	return r.fw.updateMagicsockPort(port, network)
 }
 
 func (r *userspaceBSDRouter) Close() error {
-	return nil
+	if r.unregNetMon != nil {
+		r.unregNetMon()
+	}
+	err := r.fw.close()
+	if err2 := r.bypass.close(); err == nil {
+		err = err2
+	}
+	return err
 }
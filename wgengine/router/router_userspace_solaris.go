@@ -11,12 +11,14 @@
 	"log"
 	"net/netip"
 	"os/exec"
+	"strings"
 
 	"github.com/tailscale/wireguard-go/tun"
 	"go4.org/netipx"
 	"tailscale.com/health"
 	"tailscale.com/net/netmon"
 	"tailscale.com/types/logger"
+	"tailscale.com/wgengine/router/bsdroute"
 )
 
 type userspaceSunosRouter struct {
@@ -26,6 +28,11 @@ type userspaceSunosRouter struct {
 	tunname string
 	local   []netip.Prefix
 	routes  map[netip.Prefix]struct{}
+
+	// gateway4 and gateway6 are the nexthops the current routes
+	// were added with.
+	gateway4 string
+	gateway6 string
 }
 
 func newUserspaceSunosRouter(logf logger.Logf, tundev tun.Device, linkMon *netmon.Monitor, health *health.Tracker) (Router, error) {
@@ -169,46 +176,59 @@ func (r *userspaceSunosRouter) Set(cfg *Config) (reterr error) {
 
 	newRoutes := make(map[netip.Prefix]struct{})
 	for _, route := range cfg.Routes {
+		if (route.Addr().Is4() && firstGateway4 == "") || (route.Addr().Is6() && firstGateway6 == "") {
+			// Without a local address of the route's family, there's
+			// no nexthop to give it.
+			continue
+		}
 		newRoutes[route] = struct{}{}
 	}
-	// Delete any pre-existing routes.
+	var ops []bsdroute.Op
 	for route := range r.routes {
 		if _, keep := newRoutes[route]; !keep {
-			net := netipx.PrefixIPNet(route)
-			nip := net.IP.Mask(net.Mask)
-			nstr := fmt.Sprintf("%v/%d", nip, route.Bits())
-			del := "delete"
-			routedel := []string{"route", "-q", "-n",
-				del, "-" + inet(route), nstr,
-				"-iface", r.tunname}
-			out, err := cmd(routedel...).CombinedOutput()
-			if err != nil {
-				r.logf("route delete failed: %v: %v\n%s", routedel, err, out)
-				setErr(err)
-			}
+			ops = append(ops, bsdroute.Op{Del: true, Entry: bsdroute.Entry{Dst: route}})
 		}
 	}
 	for route := range newRoutes {
 		if _, exists := r.routes[route]; !exists {
-			net := netipx.PrefixIPNet(route)
-			nip := net.IP.Mask(net.Mask)
-			nstr := fmt.Sprintf("%v/%d", nip, route.Bits())
-			var gateway string
-			if route.Addr().Is4() && firstGateway4 != "" {
-				gateway = firstGateway4
-			}
-			if route.Addr().Is6() && firstGateway6 != "" {
-				gateway = firstGateway6
-			}
-			routeadd := []string{"route", "-q", "-n",
-				"add", "-" + inet(route), nstr,
-				"-ifp", r.tunname, gateway, "-iface"}
-			out, err := cmd(routeadd...).CombinedOutput()
-			if err != nil {
-				r.logf("addr add failed: %v: %v\n%s", routeadd, err, out)
-				setErr(err)
+			ops = append(ops, bsdroute.Op{Entry: bsdroute.Entry{Dst: route}})
+		}
+	}
+	apply := func(op bsdroute.Op) error {
+		net := netipx.PrefixIPNet(op.Dst)
+		nip := net.IP.Mask(net.Mask)
+		nstr := fmt.Sprintf("%v/%d", nip, op.Dst.Bits())
+		// Routes must be deleted with the nexthop they were added
+		// with, which is the old address if it changed.
+		gw4, gw6, verb := firstGateway4, firstGateway6, "add"
+		if op.Del {
+			gw4, gw6, verb = r.gateway4, r.gateway6, "delete"
+		}
+		gateway := gw4
+		if op.Dst.Addr().Is6() {
+			gateway = gw6
+		}
+		args := []string{"route", "-q", "-n",
+			verb, "-" + inet(op.Dst), nstr,
+			"-ifp", r.tunname, gateway, "-iface"}
+		out, err := cmd(args...).CombinedOutput()
+		if err != nil {
+			if op.Del && strings.Contains(string(out), "not in table") ||
+				!op.Del && strings.Contains(string(out), "entry exists") {
+				return bsdroute.ErrUnchanged
 			}
+			return fmt.Errorf("%v: %w\n%s", args, err, out)
 		}
+		return nil
+	}
+	if err := bsdroute.ApplyOps(r.logf, ops, apply); err != nil {
+		// The route changes were rolled back; keep the old set so
+		// that the next Set retries them.
+		r.logf("route update failed: %v", err)
+		setErr(err)
+		newRoutes = r.routes
+	} else {
+		r.gateway4, r.gateway6 = firstGateway4, firstGateway6
 	}
 
 	// Store the interface and routes so we know what to change on an update.
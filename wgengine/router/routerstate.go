@@ -0,0 +1,56 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package router
+
+import (
+	"net/netip"
+	"slices"
+	"sync/atomic"
+
+	"go4.org/netipx"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/wgengine/router/bsdroute"
+)
+
+// lastState is the state of the router after its most recent Set.
+var lastState atomic.Pointer[ipnstate.RouterState]
+
+// State returns the state of the router after its most recent Set, and
+// whether there was one by a router that reports it. So far, the BSD
+// routers do.
+func State() (st ipnstate.RouterState, ok bool) {
+	p := lastState.Load()
+	if p == nil {
+		return st, false
+	}
+	return *p, true
+}
+
+// publishState records the state of a router with the interface tunname,
+// the addresses local on it and the routes in routes, after a Set that
+// returned err.
+func publishState(tunname string, local []netip.Prefix, routes *bsdroute.Table, err error) {
+	st := &ipnstate.RouterState{
+		Interface:  tunname,
+		LocalAddrs: slices.Clone(local),
+	}
+	slices.SortFunc(st.LocalAddrs, netipx.ComparePrefix)
+	for _, rs := range routes.State() {
+		r := ipnstate.RouteState{
+			Dst:       rs.Dst,
+			Dev:       rs.Dev,
+			Priority:  int(rs.Priority),
+			Installed: rs.Installed,
+			Wanted:    rs.Wanted,
+		}
+		if rs.Err != nil {
+			r.Error = rs.Err.Error()
+		}
+		st.Routes = append(st.Routes, r)
+	}
+	if err != nil {
+		st.Error = err.Error()
+	}
+	lastState.Store(st)
+}
@@ -17,14 +17,6 @@
 	"golang.org/x/sys/unix"
 )
 
-// commandRunner abstracts helpers to run OS commands. It exists
-// purely to swap out osCommandRunner (below) with a fake runner in
-// tests.
-type commandRunner interface {
-	run(...string) error
-	output(...string) ([]byte, error)
-}
-
 type osCommandRunner struct {
 	// ambientCapNetAdmin determines whether commands are executed with
 	// CAP_NET_ADMIN.
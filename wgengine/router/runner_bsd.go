@@ -0,0 +1,30 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package router
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// osCommandRunner runs commands with os/exec.
+type osCommandRunner struct{}
+
+func (o osCommandRunner) run(args ...string) error {
+	_, err := o.output(args...)
+	return err
+}
+
+// output returns the command's combined output. Unlike on Linux, it's
+// returned along with the error if the command fails, as the routers
+// report what ifconfig(8) and route(8) said, and look in it for failures
+// that aren't.
+func (o osCommandRunner) output(args ...string) ([]byte, error) {
+	if len(args) == 0 {
+		return nil, errors.New("cmd: no argv[0]")
+	}
+	return exec.Command(args[0], args[1:]...).CombinedOutput()
+}
@@ -0,0 +1,27 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package router
+
+import (
+	"tailscale.com/types/logger"
+	"tailscale.com/wgengine/router/bsdroute"
+)
+
+// adoptStaleRoutes adds the routes left in the kernel routing table by a
+// previous tailscaled, which didn't get to remove them, such as after a
+// crash, to routes, so that the router's first Set removes those it
+// doesn't want. The kernel only removes them along with the interface,
+// which some tun drivers keep until reboot.
+func adoptStaleRoutes(logf logger.Logf, routes *bsdroute.Table) {
+	n, err := routes.Adopt()
+	if err != nil {
+		logf("listing stale routes: %v", err)
+		return
+	}
+	if n > 0 {
+		logf("found %d stale routes; removing those not wanted on the next update", n)
+	}
+}
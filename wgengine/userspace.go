@@ -42,6 +42,7 @@
 	"tailscale.com/types/ipproto"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
+	"tailscale.com/types/logid"
 	"tailscale.com/types/netmap"
 	"tailscale.com/types/views"
 	"tailscale.com/util/clientmetric"
@@ -97,7 +98,8 @@ type userspaceEngine struct {
 	tundev           *tstun.Wrapper
 	wgdev            *device.Device
 	router           router.Router
-	confListenPort   uint16 // original conf.ListenPort
+	confListenPort   uint16            // original conf.ListenPort
+	confPortRange    tailcfg.PortRange // original conf.ListenPortRange
 	dns              *dns.Manager
 	magicConn        *magicsock.Conn
 	netMon           *netmon.Monitor
@@ -105,6 +107,7 @@ type userspaceEngine struct {
 	netMonOwned      bool                // whether we created netMon (and thus need to close it)
 	netMonUnregister func()              // unsubscribes from changes; used regardless of netMonOwned
 	birdClient       BIRDClient          // or nil
+	flowExporter     netlog.Exporter     // or nil
 	controlKnobs     *controlknobs.Knobs // or nil
 
 	testMaybeReconfigHook func() // for tests; if non-nil, fires if maybeReconfigWireguardLocked called
@@ -212,6 +215,11 @@ type Config struct {
 	// If zero, a port is automatically selected.
 	ListenPort uint16
 
+	// ListenPortRange, if non-zero, restricts the ports on which the
+	// engine will listen to a range, overriding any range from
+	// ControlKnobs. Its First must be non-zero.
+	ListenPortRange tailcfg.PortRange
+
 	// RespondToPing determines whether this engine should internally
 	// reply to ICMP pings, without involving the OS.
 	// Used in "fake" mode for development.
@@ -227,6 +235,11 @@ type Config struct {
 	// DriveForLocal, if populated, will cause the engine to expose a Taildrive
 	// listener at 100.100.100.100:8080.
 	DriveForLocal drive.FileSystemForLocal
+
+	// FlowExporter, if non-nil, receives the traffic statistics of the
+	// network logger, which then runs whether or not the control plane
+	// enables network logging for the tailnet.
+	FlowExporter netlog.Exporter
 }
 
 // NewFakeUserspaceEngine returns a new userspace engine for testing.
@@ -330,7 +343,9 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 		tundev:         tsTUNDev,
 		router:         rtr,
 		confListenPort: conf.ListenPort,
+		confPortRange:  conf.ListenPortRange,
 		birdClient:     conf.BIRDClient,
+		flowExporter:   conf.FlowExporter,
 		controlKnobs:   conf.ControlKnobs,
 		reconfigureVPN: conf.ReconfigureVPN,
 		health:         conf.HealthTracker,
@@ -391,6 +406,7 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 	magicsockOpts := magicsock.Options{
 		Logf:             logf,
 		Port:             conf.ListenPort,
+		PortRange:        e.portRange(),
 		EndpointsFunc:    endpointsFn,
 		DERPActiveFunc:   e.RequestStatus,
 		IdleFunc:         e.tundev.IdleDuration,
@@ -454,6 +470,8 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 		return true
 	}
 
+	e.tundev.PeerPathMTU = e.magicConn.PeerWireMTU
+
 	// wgdev takes ownership of tundev, will close it when closed.
 	e.logf("Creating WireGuard device...")
 	e.wgdev = wgcfg.NewDevice(e.tundev, e.magicConn.Bind(), e.wgLogger.DeviceLogger)
@@ -742,6 +760,8 @@ func (e *userspaceEngine) maybeReconfigWireguardLocked(discoChanged map[key.Node
 		}
 	}
 	e.lastNMinPeers = len(min.Peers)
+	metricNumActiveWGPeers.Set(int64(len(min.Peers)))
+	metricNumLazyWGPeers.Set(int64(len(e.trimmedNodes)))
 
 	if changed := deephash.Update(&e.lastEngineSigTrim, &struct {
 		WGConfig     *wgcfg.Config
@@ -859,6 +879,18 @@ func hasOverlap(aips, rips views.Slice[netip.Prefix]) bool {
 	return false
 }
 
+// portRange returns the port range magicsock should be restricted to: the
+// configured one, else the one from control, if any.
+func (e *userspaceEngine) portRange() tailcfg.PortRange {
+	if e.confPortRange != (tailcfg.PortRange{}) {
+		return e.confPortRange
+	}
+	if e.controlKnobs != nil {
+		return e.controlKnobs.MagicsockPortRange.Load()
+	}
+	return tailcfg.PortRange{}
+}
+
 func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config, dnsCfg *dns.Config) error {
 	if routerCfg == nil {
 		panic("routerCfg must not be nil")
@@ -888,6 +920,7 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 	if e.controlKnobs != nil && e.controlKnobs.RandomizeClientPort.Load() {
 		listenPort = 0
 	}
+	portRange := e.portRange()
 
 	peerMTUEnable := e.magicConn.ShouldPMTUD()
 
@@ -914,11 +947,13 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 	oldLogIDs := e.lastCfgFull.NetworkLogging
 	netLogIDsNowValid := !newLogIDs.NodeID.IsZero() && !newLogIDs.DomainID.IsZero()
 	netLogIDsWasValid := !oldLogIDs.NodeID.IsZero() && !oldLogIDs.DomainID.IsZero()
-	netLogIDsChanged := netLogIDsNowValid && netLogIDsWasValid && newLogIDs != oldLogIDs
-	netLogRunning := netLogIDsNowValid && !routerCfg.Equal(&router.Config{})
 	if envknob.NoLogsNoSupport() {
-		netLogRunning = false
+		netLogIDsNowValid, netLogIDsWasValid = false, false
 	}
+	// With a flow exporter, the logger also runs without valid IDs, so
+	// it's restarted when they become valid or invalid, too.
+	netLogIDsChanged := newLogIDs != oldLogIDs && (netLogIDsNowValid && netLogIDsWasValid || e.flowExporter != nil)
+	netLogRunning := (netLogIDsNowValid || e.flowExporter != nil) && !routerCfg.Equal(&router.Config{})
 
 	// TODO(bradfitz,danderson): maybe delete this isDNSIPOverTailscale
 	// field and delete the resolver.ForwardLinkSelector hook and
@@ -962,6 +997,7 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 		e.logf("wgengine: Reconfig: SetPrivateKey: %v", err)
 	}
 	e.magicConn.UpdatePeers(peerSet)
+	e.magicConn.SetPortRange(portRange)
 	e.magicConn.SetPreferredPort(listenPort)
 	e.magicConn.UpdatePMTUD()
 
@@ -983,11 +1019,15 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 	// Startup the network logger.
 	// Do this before configuring the router so that we capture initial packets.
 	if netLogRunning && !e.networkLogger.Running() {
-		nid := cfg.NetworkLogging.NodeID
-		tid := cfg.NetworkLogging.DomainID
+		var nid, tid logid.PrivateID
+		if netLogIDsNowValid {
+			nid, tid = cfg.NetworkLogging.NodeID, cfg.NetworkLogging.DomainID
+			e.logf("wgengine: Reconfig: starting up network logger (node:%s tailnet:%s)", nid.Public(), tid.Public())
+		} else {
+			e.logf("wgengine: Reconfig: starting up network logger for flow export only")
+		}
 		logExitFlowEnabled := cfg.NetworkLogging.LogExitFlowEnabled
-		e.logf("wgengine: Reconfig: starting up network logger (node:%s tailnet:%s)", nid.Public(), tid.Public())
-		if err := e.networkLogger.Startup(cfg.NodeID, nid, tid, e.tundev, e.magicConn, e.netMon, e.health, logExitFlowEnabled); err != nil {
+		if err := e.networkLogger.Startup(cfg.NodeID, nid, tid, e.tundev, e.magicConn, e.netMon, e.health, logExitFlowEnabled, e.flowExporter); err != nil {
 			e.logf("wgengine: Reconfig: error starting up network logger: %v", err)
 		}
 		e.networkLogger.ReconfigRoutes(routerCfg)
@@ -1284,10 +1324,25 @@ func (e *userspaceEngine) UpdateStatus(sb *ipnstate.StatusBuilder) {
 			})
 		}
 	}
+	counts := e.wireGuardPeerCounts()
+	sb.MutateStatus(func(s *ipnstate.Status) {
+		s.WireGuardPeers = counts
+	})
 
 	e.magicConn.UpdateStatus(sb)
 }
 
+// wireGuardPeerCounts returns the number of peers in the last WireGuard
+// config and the number trimmed out of it, to be configured on demand.
+func (e *userspaceEngine) wireGuardPeerCounts() *ipnstate.WireGuardPeerCounts {
+	e.wgLock.Lock()
+	defer e.wgLock.Unlock()
+	return &ipnstate.WireGuardPeerCounts{
+		Active: e.lastNMinPeers,
+		Lazy:   len(e.trimmedNodes),
+	}
+}
+
 func (e *userspaceEngine) Ping(ip netip.Addr, pingType tailcfg.PingType, size int, cb func(*ipnstate.PingResult)) {
 	res := &ipnstate.PingResult{IP: ip.String()}
 	pip, ok := e.PeerForIP(ip)
@@ -1591,6 +1646,9 @@ func (ls fwdDNSLinkSelector) PickLink(ip netip.Addr) (linkName string) {
 
 	metricNumMajorChanges = clientmetric.NewCounter("wgengine_major_changes")
 	metricNumMinorChanges = clientmetric.NewCounter("wgengine_minor_changes")
+
+	metricNumActiveWGPeers = clientmetric.NewGauge("wgengine_wireguard_peers_active")
+	metricNumLazyWGPeers   = clientmetric.NewGauge("wgengine_wireguard_peers_lazy")
 )
 
 func (e *userspaceEngine) InstallCaptureHook(cb packet.CaptureCallback) {
@@ -16,6 +16,7 @@
 	"tailscale.com/control/controlknobs"
 	"tailscale.com/envknob"
 	"tailscale.com/health"
+	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/net/dns"
 	"tailscale.com/net/netaddr"
 	"tailscale.com/net/tstun"
@@ -157,7 +158,51 @@ func TestUserspaceEngineReconfig(t *testing.T) {
 		if got := ue.trimmedNodes; !reflect.DeepEqual(got, wantTrimmedNodes) {
 			t.Errorf("wrong wantTrimmedNodes\n got: %v\nwant: %v\n", got, wantTrimmedNodes)
 		}
+
+		wantCounts := &ipnstate.WireGuardPeerCounts{Active: 0, Lazy: 1}
+		if got := ue.wireGuardPeerCounts(); !reflect.DeepEqual(got, wantCounts) {
+			t.Errorf("wireGuardPeerCounts = %+v; want %+v", got, wantCounts)
+		}
+	}
+}
+
+// TestUserspaceEngineLazyPeer tests that an idle peer is left out of the
+// WireGuard device until a packet from it shows up, and that the peer
+// counts in the status follow.
+func TestUserspaceEngineLazyPeer(t *testing.T) {
+	e, err := NewFakeUserspaceEngine(t.Logf, 0, new(health.Tracker), new(usermetric.Registry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+	ue := e.(*userspaceEngine)
+
+	nk := key.NewNode().Public()
+	e.SetNetworkMap(&netmap.NetworkMap{
+		Peers: nodeViews([]*tailcfg.Node{{ID: 1, Key: nk}}),
+	})
+	cfg := &wgcfg.Config{
+		Peers: []wgcfg.Peer{{
+			PublicKey:  nk,
+			AllowedIPs: []netip.Prefix{netip.PrefixFrom(netaddr.IPv4(100, 100, 99, 1), 32)},
+		}},
+	}
+	if err := e.Reconfig(cfg, &router.Config{}, &dns.Config{}); err != nil {
+		t.Fatal(err)
 	}
+	check := func(wantCounts ipnstate.WireGuardPeerCounts, wantInDevice bool) {
+		t.Helper()
+		if got := ue.wireGuardPeerCounts(); *got != wantCounts {
+			t.Errorf("wireGuardPeerCounts = %+v; want %+v", got, wantCounts)
+		}
+		if got := ue.wgdev.LookupPeer(nk.Raw32()) != nil; got != wantInDevice {
+			t.Errorf("peer in WireGuard device = %v; want %v", got, wantInDevice)
+		}
+	}
+	check(ipnstate.WireGuardPeerCounts{Active: 0, Lazy: 1}, false)
+
+	ue.noteRecvActivity(nk)
+	check(ipnstate.WireGuardPeerCounts{Active: 1, Lazy: 0}, true)
 }
 
 func TestUserspaceEnginePortReconfig(t *testing.T) {